@@ -3,19 +3,32 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/wg-hubspoke/wg-hubspoke/common/types"
 )
 
+// maxRetryDelay caps the backoff between retries of one request.
+const maxRetryDelay = time.Minute
+
 type ControllerClient struct {
 	baseURL    string
 	httpClient *http.Client
 	token      string
+
+	retryAttempts int           // retries after the first attempt
+	retryDelay    time.Duration // backoff before the first retry, doubled for each one after
+	// sleep and jitter are replaced in tests
+	sleep  func(ctx context.Context, d time.Duration) error
+	jitter func(d time.Duration) time.Duration
 }
 
 func NewControllerClient(baseURL string) *ControllerClient {
@@ -24,6 +37,8 @@ func NewControllerClient(baseURL string) *ControllerClient {
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		sleep:  sleepContext,
+		jitter: equalJitter,
 	}
 }
 
@@ -31,6 +46,114 @@ func (c *ControllerClient) SetToken(token string) {
 	c.token = token
 }
 
+// ConfigureTLS presents the client certificate in certFile and keyFile to
+// the controller, and verifies the controller against the CAs in caFile
+// when it is set rather than the system roots.
+func (c *ControllerClient) ConfigureTLS(certFile, keyFile, caFile string) error {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in CA file %s", caFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	c.httpClient.Transport = transport
+	return nil
+}
+
+// SetRetryPolicy makes requests that fail with a network error, 429 or 5xx
+// retry up to attempts times, backing off exponentially from delay.
+func (c *ControllerClient) SetRetryPolicy(attempts int, delay time.Duration) {
+	c.retryAttempts = attempts
+	c.retryDelay = delay
+}
+
+// do sends the request, retrying while the controller is unreachable or
+// overloaded. The last response or error is returned once the retries are
+// used up.
+func (c *ControllerClient) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		resp, err := c.httpClient.Do(attemptReq)
+		if err == nil && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return resp, nil
+		}
+		if attempt >= c.retryAttempts || ctx.Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			// Drain so the connection can be reused
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err := c.sleep(ctx, c.jitter(c.backoff(attempt))); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff is the delay before retry number attempt+1.
+func (c *ControllerClient) backoff(attempt int) time.Duration {
+	delay := c.retryDelay
+	for i := 0; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// equalJitter picks a delay between d/2 and d, so agents that lost the
+// controller at the same moment don't all retry in lockstep.
+func equalJitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 func (c *ControllerClient) RegisterNode(ctx context.Context, req types.NodeRegistrationRequest) (*types.APIResponse, error) {
 	url := fmt.Sprintf("%s/api/v1/nodes", c.baseURL)
 	
@@ -49,7 +172,7 @@ func (c *ControllerClient) RegisterNode(ctx context.Context, req types.NodeRegis
 		httpReq.Header.Set("Authorization", "Bearer "+c.token)
 	}
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
@@ -84,7 +207,7 @@ func (c *ControllerClient) GetNodeConfig(ctx context.Context, nodeID string) (*t
 		httpReq.Header.Set("Authorization", "Bearer "+c.token)
 	}
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
@@ -139,7 +262,7 @@ func (c *ControllerClient) UpdateNodeStatus(ctx context.Context, nodeID string,
 		httpReq.Header.Set("Authorization", "Bearer "+c.token)
 	}
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
@@ -157,6 +280,117 @@ func (c *ControllerClient) UpdateNodeStatus(ctx context.Context, nodeID string,
 	return nil
 }
 
+func (c *ControllerClient) ReportPeerErrors(ctx context.Context, nodeID string, report types.PeerApplyReport) error {
+	url := fmt.Sprintf("%s/api/v1/nodes/%s/peer-errors", c.baseURL, nodeID)
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		var apiResp types.APIResponse
+		if json.Unmarshal(respBody, &apiResp) == nil {
+			return fmt.Errorf("API error: %s", apiResp.Error)
+		}
+		return fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// UpdatePublicKey submits a node's new public key. Its private key stays on
+// the node.
+func (c *ControllerClient) UpdatePublicKey(ctx context.Context, nodeID string, publicKey string) error {
+	url := fmt.Sprintf("%s/api/v1/nodes/%s/public-key", c.baseURL, nodeID)
+
+	body, err := json.Marshal(types.NodePublicKeyRequest{PublicKey: publicKey})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		var apiResp types.APIResponse
+		if json.Unmarshal(respBody, &apiResp) == nil {
+			return fmt.Errorf("API error: %s", apiResp.Error)
+		}
+		return fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// SendMetrics reports a node's metrics sample to the controller.
+func (c *ControllerClient) SendMetrics(ctx context.Context, nodeID string, metrics map[string]interface{}) error {
+	url := fmt.Sprintf("%s/api/v1/monitoring/nodes/%s/metrics", c.baseURL, nodeID)
+
+	body, err := json.Marshal(metrics)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		var apiResp types.APIResponse
+		if json.Unmarshal(respBody, &apiResp) == nil && apiResp.Error != "" {
+			return fmt.Errorf("API error: %s", apiResp.Error)
+		}
+		return fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
 func (c *ControllerClient) HealthCheck(ctx context.Context) (*types.HealthStatus, error) {
 	url := fmt.Sprintf("%s/health", c.baseURL)
 	
@@ -165,7 +399,7 @@ func (c *ControllerClient) HealthCheck(ctx context.Context) (*types.HealthStatus
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
@@ -0,0 +1,272 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+// flakyServer fails the first failures requests with status, then answers
+// with a successful APIResponse.
+type flakyServer struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	requests int
+	bodies   []string
+}
+
+func (f *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests++
+	body, _ := io.ReadAll(r.Body)
+	f.bodies = append(f.bodies, string(body))
+
+	if f.requests <= f.failures {
+		w.WriteHeader(f.status)
+		json.NewEncoder(w).Encode(types.APIResponse{Success: false, Error: "controller unavailable"})
+		return
+	}
+	json.NewEncoder(w).Encode(types.APIResponse{Success: true, Data: map[string]interface{}{"id": "node-1"}})
+}
+
+// newRetryTestClient records the backoff delays instead of sleeping.
+func newRetryTestClient(t *testing.T, server *flakyServer, attempts int) (*ControllerClient, *[]time.Duration) {
+	t.Helper()
+
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	var delays []time.Duration
+	c := NewControllerClient(ts.URL)
+	c.SetRetryPolicy(attempts, 100*time.Millisecond)
+	c.jitter = func(d time.Duration) time.Duration { return d }
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	return c, &delays
+}
+
+func TestRetryBacksOffUntilSuccess(t *testing.T) {
+	server := &flakyServer{failures: 3, status: http.StatusServiceUnavailable}
+	c, delays := newRetryTestClient(t, server, 3)
+
+	resp, err := c.RegisterNode(context.Background(), types.NodeRegistrationRequest{Name: "spoke-1", NodeType: "spoke"})
+	if err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if !resp.Success {
+		t.Errorf("expected the successful response, got %+v", resp)
+	}
+	if server.requests != 4 {
+		t.Errorf("expected 4 requests, got %d", server.requests)
+	}
+
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}
+	if len(*delays) != len(want) {
+		t.Fatalf("expected backoff %v, got %v", want, *delays)
+	}
+	for i := range want {
+		if (*delays)[i] != want[i] {
+			t.Errorf("expected backoff %v, got %v", want, *delays)
+			break
+		}
+	}
+
+	// Retries resend the full request body
+	for i, body := range server.bodies {
+		if body != server.bodies[0] || body == "" {
+			t.Errorf("request %d sent body %q, expected %q", i+1, body, server.bodies[0])
+		}
+	}
+}
+
+func TestRetryGivesUpAfterAttempts(t *testing.T) {
+	server := &flakyServer{failures: 10, status: http.StatusBadGateway}
+	c, delays := newRetryTestClient(t, server, 2)
+
+	if _, err := c.GetNodeConfig(context.Background(), "node-1"); err == nil {
+		t.Fatal("expected an error once the retries are used up")
+	}
+	if server.requests != 3 {
+		t.Errorf("expected the first attempt plus 2 retries, got %d requests", server.requests)
+	}
+	if len(*delays) != 2 {
+		t.Errorf("expected 2 backoffs, got %v", *delays)
+	}
+}
+
+func TestRetrySkipsClientErrors(t *testing.T) {
+	server := &flakyServer{failures: 1, status: http.StatusBadRequest}
+	c, delays := newRetryTestClient(t, server, 3)
+
+	if err := c.UpdateNodeStatus(context.Background(), "node-1", "active"); err == nil {
+		t.Fatal("expected the client error to be returned")
+	}
+	if server.requests != 1 || len(*delays) != 0 {
+		t.Errorf("expected no retry for a 400, got %d requests", server.requests)
+	}
+}
+
+func TestRetryUnreachableController(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	url := ts.URL
+	ts.Close()
+
+	var delays []time.Duration
+	c := NewControllerClient(url)
+	c.SetRetryPolicy(2, time.Second)
+	c.jitter = func(d time.Duration) time.Duration { return d }
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	if _, err := c.HealthCheck(context.Background()); err == nil {
+		t.Fatal("expected an error for an unreachable controller")
+	}
+	if len(delays) != 2 || delays[0] != time.Second || delays[1] != 2*time.Second {
+		t.Errorf("expected backoff [1s 2s], got %v", delays)
+	}
+}
+
+func TestRetryBackoffIsCapped(t *testing.T) {
+	c := NewControllerClient("")
+	c.SetRetryPolicy(10, 10*time.Second)
+
+	if d := c.backoff(8); d != maxRetryDelay {
+		t.Errorf("expected the backoff to be capped at %v, got %v", maxRetryDelay, d)
+	}
+}
+
+func TestEqualJitterBounds(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := equalJitter(time.Second); d < 500*time.Millisecond || d > time.Second {
+			t.Fatalf("expected jitter between 500ms and 1s, got %v", d)
+		}
+	}
+}
+
+func TestSendMetrics(t *testing.T) {
+	var gotPath, gotMethod, gotAuth string
+	var gotBody map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotMethod, gotAuth = r.URL.Path, r.Method, r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		json.NewEncoder(w).Encode(types.APIResponse{Success: true, Message: "Metrics recorded successfully"})
+	}))
+	defer ts.Close()
+
+	c := NewControllerClient(ts.URL)
+	c.SetToken("agent-token")
+
+	err := c.SendMetrics(context.Background(), "node-1", map[string]interface{}{"cpu_usage": 12.5, "wg_peers": 3})
+	if err != nil {
+		t.Fatalf("SendMetrics failed: %v", err)
+	}
+	if gotMethod != http.MethodPost || gotPath != "/api/v1/monitoring/nodes/node-1/metrics" {
+		t.Errorf("expected POST /api/v1/monitoring/nodes/node-1/metrics, got %s %s", gotMethod, gotPath)
+	}
+	if gotAuth != "Bearer agent-token" {
+		t.Errorf("expected the bearer token to be sent, got %q", gotAuth)
+	}
+	if gotBody["cpu_usage"] != 12.5 || gotBody["wg_peers"] != float64(3) {
+		t.Errorf("unexpected metrics payload: %v", gotBody)
+	}
+}
+
+func TestSendMetricsRejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(types.APIResponse{Success: false, Error: "Invalid token"})
+	}))
+	defer ts.Close()
+
+	err := NewControllerClient(ts.URL).SendMetrics(context.Background(), "node-1", map[string]interface{}{})
+	if err == nil || err.Error() != "API error: Invalid token" {
+		t.Errorf("expected the controller's error to be returned, got %v", err)
+	}
+}
+
+// writeClientCertificate writes a self-signed client certificate for the
+// node and its key to dir.
+func writeClientCertificate(t *testing.T, dir, nodeID string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: nodeID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestConfigureTLSPresentsClientCertificate(t *testing.T) {
+	var presented string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented = r.TLS.PeerCertificates[0].Subject.CommonName
+		json.NewEncoder(w).Encode(types.APIResponse{Success: true, Data: map[string]interface{}{}})
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o644)
+	certFile, keyFile := writeClientCertificate(t, dir, "node-1")
+
+	c := NewControllerClient(ts.URL)
+	if err := c.ConfigureTLS(certFile, keyFile, caFile); err != nil {
+		t.Fatalf("ConfigureTLS failed: %v", err)
+	}
+	if err := c.UpdateNodeStatus(context.Background(), "node-1", "active"); err != nil {
+		t.Fatalf("expected the request to succeed over mutual TLS: %v", err)
+	}
+	if presented != "node-1" {
+		t.Errorf("expected the node's certificate to be presented, got %q", presented)
+	}
+
+	if err := c.ConfigureTLS(filepath.Join(dir, "missing.crt"), keyFile, caFile); err == nil {
+		t.Error("expected a missing certificate file to be reported")
+	}
+}
@@ -3,9 +3,9 @@ package config
 import (
 	"context"
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/wg-hubspoke/wg-hubspoke/common/types"
@@ -32,6 +32,12 @@ type ControllerConfig struct {
 	RequestTimeout  time.Duration `yaml:"request_timeout"`
 	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
 	ConfigRefreshInterval time.Duration `yaml:"config_refresh_interval"`
+	// Client certificate for controllers that require mutual TLS; its CN
+	// is the node ID. CAFile verifies the controller instead of the
+	// system roots.
+	ClientCertFile string `yaml:"client_cert_file"`
+	ClientKeyFile  string `yaml:"client_key_file"`
+	CAFile         string `yaml:"ca_file"`
 }
 
 type NodeConfig struct {
@@ -43,11 +49,16 @@ type NodeConfig struct {
 }
 
 type WGConfig struct {
-	Interface    string `yaml:"interface"`
-	ConfigPath   string `yaml:"config_path"`
-	PrivateKey   string `yaml:"private_key"`
-	PublicKey    string `yaml:"public_key"`
-	MTU          int    `yaml:"mtu"`
+	Interface        string        `yaml:"interface"`
+	ConfigPath       string        `yaml:"config_path"`
+	PrivateKey       string        `yaml:"private_key"`
+	PublicKey        string        `yaml:"public_key"`
+	MTU              int           `yaml:"mtu"`
+	RestartDelay     time.Duration `yaml:"restart_delay"`
+	HandshakeTimeout time.Duration `yaml:"handshake_timeout"`
+	// How long a new config has to reach the controller before it is
+	// rolled back to the last one that could
+	ConfirmTimeout time.Duration `yaml:"confirm_timeout"`
 }
 
 type MonitoringConfig struct {
@@ -77,10 +88,33 @@ func (m *Manager) LoadConfig() error {
 	// Set defaults
 	m.setDefaults(config)
 
+	// Keep the current config if the new one is unusable
+	if err := validateAgentConfig(config); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
 	m.config = config
 	return nil
 }
 
+// validateAgentConfig rejects settings the daemon can't run with, such as
+// intervals that would make its tickers panic.
+func validateAgentConfig(config *AgentConfig) error {
+	if config.Controller.HeartbeatInterval <= 0 {
+		return fmt.Errorf("controller.heartbeat_interval must be positive")
+	}
+	if config.Controller.ConfigRefreshInterval <= 0 {
+		return fmt.Errorf("controller.config_refresh_interval must be positive")
+	}
+	if config.Monitoring.Interval <= 0 {
+		return fmt.Errorf("monitoring.interval must be positive")
+	}
+	if config.Controller.RetryAttempts < 0 {
+		return fmt.Errorf("controller.retry_attempts must not be negative")
+	}
+	return nil
+}
+
 func (m *Manager) SaveConfig() error {
 	if m.config == nil {
 		return fmt.Errorf("no config to save")
@@ -121,6 +155,12 @@ func (m *Manager) UpdateNodeConfig(nodeID string, privateKey string, publicKey s
 }
 
 func (m *Manager) GenerateWireGuardConfig(ctx context.Context, nodeConfig *types.NodeConfigResponse) (string, error) {
+	// Checked here as well as on the controller, since a value with a line
+	// break would inject its own directives
+	if err := validateNodeConfig(nodeConfig); err != nil {
+		return "", err
+	}
+
 	var config string
 
 	// Interface section
@@ -131,12 +171,43 @@ func (m *Manager) GenerateWireGuardConfig(ctx context.Context, nodeConfig *types
 		config += fmt.Sprintf("Address = %s\n", addr)
 	}
 	
+	// wg-quick takes resolver addresses and search domains on one line
+	var dns []string
+	for _, entry := range nodeConfig.Interface.DNS {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			dns = append(dns, entry)
+		}
+	}
+	if len(dns) > 0 {
+		config += fmt.Sprintf("DNS = %s\n", strings.Join(dns, ", "))
+	}
+	
 	if nodeConfig.Interface.ListenPort > 0 {
 		config += fmt.Sprintf("ListenPort = %d\n", nodeConfig.Interface.ListenPort)
 	}
 	
-	if nodeConfig.Interface.MTU > 0 {
-		config += fmt.Sprintf("MTU = %d\n", nodeConfig.Interface.MTU)
+	// Fall back to the agent's own MTU when the controller doesn't set one
+	mtu := nodeConfig.Interface.MTU
+	if mtu == 0 && m.config != nil {
+		mtu = m.config.WireGuard.MTU
+	}
+	if mtu > 0 {
+		config += fmt.Sprintf("MTU = %d\n", mtu)
+	}
+
+	hooks := nodeConfig.Interface.InterfaceHooks
+	for _, hook := range []struct {
+		key      string
+		commands []string
+	}{
+		{"PreUp", hooks.PreUp},
+		{"PostUp", hooks.PostUp},
+		{"PreDown", hooks.PreDown},
+		{"PostDown", hooks.PostDown},
+	} {
+		for _, command := range hook.commands {
+			config += fmt.Sprintf("%s = %s\n", hook.key, command)
+		}
 	}
 
 	// Peers section
@@ -144,7 +215,8 @@ func (m *Manager) GenerateWireGuardConfig(ctx context.Context, nodeConfig *types
 		config += "\n[Peer]\n"
 		config += fmt.Sprintf("PublicKey = %s\n", peer.PublicKey)
 		
-		for _, allowedIP := range peer.AllowedIPs {
+		// Routed subnets are accepted from the peer like its own address
+		for _, allowedIP := range peer.AllowedIPsWithRoutes() {
 			config += fmt.Sprintf("AllowedIPs = %s\n", allowedIP)
 		}
 		
@@ -160,15 +232,78 @@ func (m *Manager) GenerateWireGuardConfig(ctx context.Context, nodeConfig *types
 	return config, nil
 }
 
+// validateNodeConfig checks every value GenerateWireGuardConfig writes out.
+func validateNodeConfig(nodeConfig *types.NodeConfigResponse) error {
+	iface := nodeConfig.Interface
+	if err := iface.InterfaceHooks.Validate(); err != nil {
+		return err
+	}
+	values := append([]string{iface.PrivateKey}, iface.Address...)
+	for _, value := range append(values, iface.DNS...) {
+		if err := types.ValidateText(value); err != nil {
+			return fmt.Errorf("invalid interface: %w", err)
+		}
+	}
+
+	for _, peer := range nodeConfig.Peers {
+		if err := types.ValidateKey(peer.PublicKey); err != nil {
+			return fmt.Errorf("invalid peer %q: %w", peer.PublicKey, err)
+		}
+		if peer.Endpoint != "" {
+			if err := types.ValidateEndpoint(peer.Endpoint); err != nil {
+				return fmt.Errorf("invalid peer %s: %w", peer.PublicKey, err)
+			}
+		}
+		for _, allowedIP := range peer.AllowedIPsWithRoutes() {
+			if err := types.ValidateText(allowedIP); err != nil {
+				return fmt.Errorf("invalid peer %s: %w", peer.PublicKey, err)
+			}
+		}
+	}
+	return nil
+}
+
+// WriteWireGuardConfig writes the node's WireGuard config, keeping the file
+// it replaces next to it as a .bak so the previous config can be inspected or
+// restored.
 func (m *Manager) WriteWireGuardConfig(config string) error {
+	return m.writeWireGuardConfig(config, true)
+}
+
+// RestoreWireGuardConfig writes a previously applied config back without
+// replacing the .bak.
+func (m *Manager) RestoreWireGuardConfig(config string) error {
+	return m.writeWireGuardConfig(config, false)
+}
+
+// PreviousWireGuardConfig returns the config that the last write replaced.
+// The error satisfies os.IsNotExist if nothing has been replaced yet.
+func (m *Manager) PreviousWireGuardConfig() (string, error) {
 	if m.config == nil {
-		return fmt.Errorf("config not loaded")
+		return "", fmt.Errorf("config not loaded")
 	}
 
-	configPath := m.config.WireGuard.ConfigPath
-	if configPath == "" {
-		configPath = fmt.Sprintf("/etc/wireguard/%s.conf", m.config.WireGuard.Interface)
+	data, err := os.ReadFile(m.WireGuardConfigPath() + ".bak")
+	if err != nil {
+		return "", err
 	}
+	return string(data), nil
+}
+
+// WireGuardConfigPath is where the node's WireGuard config is written.
+func (m *Manager) WireGuardConfigPath() string {
+	if m.config.WireGuard.ConfigPath != "" {
+		return m.config.WireGuard.ConfigPath
+	}
+	return fmt.Sprintf("/etc/wireguard/%s.conf", m.config.WireGuard.Interface)
+}
+
+func (m *Manager) writeWireGuardConfig(config string, backup bool) error {
+	if m.config == nil {
+		return fmt.Errorf("config not loaded")
+	}
+
+	configPath := m.WireGuardConfigPath()
 
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(configPath)
@@ -176,6 +311,18 @@ func (m *Manager) WriteWireGuardConfig(config string) error {
 		return fmt.Errorf("failed to create wireguard config directory: %w", err)
 	}
 
+	if backup {
+		current, err := os.ReadFile(configPath)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read current wireguard config: %w", err)
+		}
+		if err == nil && string(current) != config {
+			if err := os.WriteFile(configPath+".bak", current, 0600); err != nil {
+				return fmt.Errorf("failed to back up wireguard config: %w", err)
+			}
+		}
+	}
+
 	// Write config with restrictive permissions
 	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
 		return fmt.Errorf("failed to write wireguard config: %w", err)
@@ -212,6 +359,15 @@ func (m *Manager) setDefaults(config *AgentConfig) {
 	if config.WireGuard.MTU == 0 {
 		config.WireGuard.MTU = 1420
 	}
+	if config.WireGuard.RestartDelay == 0 {
+		config.WireGuard.RestartDelay = 1 * time.Second
+	}
+	if config.WireGuard.HandshakeTimeout == 0 {
+		config.WireGuard.HandshakeTimeout = 30 * time.Second
+	}
+	if config.WireGuard.ConfirmTimeout == 0 {
+		config.WireGuard.ConfirmTimeout = 30 * time.Second
+	}
 
 	// Monitoring defaults
 	if config.Monitoring.Interval == 0 {
@@ -0,0 +1,295 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+const (
+	berlinKey = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	parisKey  = "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="
+)
+
+func TestGenerateWireGuardConfigIncludesRoutes(t *testing.T) {
+	nodeConfig := &types.NodeConfigResponse{
+		Interface: types.WGInterface{
+			PrivateKey: "private-key",
+			Address:    []string{"10.100.0.1/16"},
+			ListenPort: 51820,
+		},
+		Peers: []types.WGPeer{
+			{
+				PublicKey:  berlinKey,
+				AllowedIPs: []string{"10.100.1.2/32"},
+				Routes:     []string{"192.168.10.0/24", "192.168.11.0/24"},
+			},
+			{
+				PublicKey:  parisKey,
+				AllowedIPs: []string{"10.100.1.3/32"},
+				// Listed as both an address and a route
+				Routes: []string{"10.100.1.3/32"},
+			},
+		},
+	}
+
+	config, err := NewManager("").GenerateWireGuardConfig(context.Background(), nodeConfig)
+	if err != nil {
+		t.Fatalf("GenerateWireGuardConfig failed: %v", err)
+	}
+
+	peers := strings.Split(config, "[Peer]")
+	if len(peers) != 3 {
+		t.Fatalf("expected two peer sections, got:\n%s", config)
+	}
+
+	berlin := peers[1]
+	for _, allowed := range []string{"10.100.1.2/32", "192.168.10.0/24", "192.168.11.0/24"} {
+		if !strings.Contains(berlin, "AllowedIPs = "+allowed+"\n") {
+			t.Errorf("expected AllowedIPs = %s for spoke-berlin, got:\n%s", allowed, berlin)
+		}
+	}
+	if strings.Contains(peers[2], "192.168.10.0/24") {
+		t.Errorf("expected spoke-berlin's routes only on its own peer, got:\n%s", peers[2])
+	}
+	if n := strings.Count(peers[2], "AllowedIPs = 10.100.1.3/32"); n != 1 {
+		t.Errorf("expected a duplicated route to be written once, got %d times", n)
+	}
+}
+
+func TestGenerateWireGuardConfigWithoutRoutes(t *testing.T) {
+	nodeConfig := &types.NodeConfigResponse{
+		Interface: types.WGInterface{PrivateKey: "private-key", Address: []string{"10.100.1.2/16"}},
+		Peers: []types.WGPeer{
+			{PublicKey: berlinKey, AllowedIPs: []string{"0.0.0.0/0"}, Endpoint: "hub.example.com:51820"},
+		},
+	}
+
+	config, err := NewManager("").GenerateWireGuardConfig(context.Background(), nodeConfig)
+	if err != nil {
+		t.Fatalf("GenerateWireGuardConfig failed: %v", err)
+	}
+	if n := strings.Count(config, "AllowedIPs = "); n != 1 {
+		t.Errorf("expected a single AllowedIPs line, got %d:\n%s", n, config)
+	}
+}
+
+func TestGenerateWireGuardConfigDNS(t *testing.T) {
+	nodeConfig := &types.NodeConfigResponse{
+		Interface: types.WGInterface{
+			PrivateKey: "private-key",
+			Address:    []string{"10.100.1.2/16"},
+			MTU:        1380,
+			// As split from a comma-separated WG_DNS
+			DNS: []string{"10.100.0.53", " 10.100.0.54", " corp.example.com", ""},
+		},
+	}
+
+	config, err := NewManager("").GenerateWireGuardConfig(context.Background(), nodeConfig)
+	if err != nil {
+		t.Fatalf("GenerateWireGuardConfig failed: %v", err)
+	}
+	if !strings.Contains(config, "DNS = 10.100.0.53, 10.100.0.54, corp.example.com\n") {
+		t.Errorf("expected a DNS line in the interface section, got:\n%s", config)
+	}
+	if !strings.Contains(config, "MTU = 1380\n") {
+		t.Errorf("expected the controller's MTU, got:\n%s", config)
+	}
+
+	nodeConfig.Interface.DNS = nil
+	config, err = NewManager("").GenerateWireGuardConfig(context.Background(), nodeConfig)
+	if err != nil {
+		t.Fatalf("GenerateWireGuardConfig failed: %v", err)
+	}
+	if strings.Contains(config, "DNS") {
+		t.Errorf("expected no DNS line without resolvers, got:\n%s", config)
+	}
+}
+
+func TestGenerateWireGuardConfigMTUFallback(t *testing.T) {
+	manager := NewManager("")
+	manager.config = &AgentConfig{WireGuard: WGConfig{MTU: 1280}}
+	nodeConfig := &types.NodeConfigResponse{
+		Interface: types.WGInterface{PrivateKey: "private-key", Address: []string{"10.100.1.2/16"}},
+	}
+
+	config, err := manager.GenerateWireGuardConfig(context.Background(), nodeConfig)
+	if err != nil {
+		t.Fatalf("GenerateWireGuardConfig failed: %v", err)
+	}
+	if !strings.Contains(config, "MTU = 1280\n") {
+		t.Errorf("expected the agent's MTU when the controller sets none, got:\n%s", config)
+	}
+}
+
+func TestGenerateWireGuardConfigHooks(t *testing.T) {
+	nodeConfig := &types.NodeConfigResponse{
+		Interface: types.WGInterface{
+			PrivateKey: "private-key",
+			Address:    []string{"10.100.0.1/16"},
+			InterfaceHooks: types.InterfaceHooks{
+				PreUp: []string{"sysctl -w net.ipv4.ip_forward=1"},
+				PostUp: []string{
+					"iptables -A FORWARD -i %i -j ACCEPT",
+					"iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE",
+				},
+				PostDown: []string{"iptables -t nat -D POSTROUTING -o eth0 -j MASQUERADE"},
+			},
+		},
+		Peers: []types.WGPeer{{PublicKey: berlinKey, AllowedIPs: []string{"10.100.1.2/32"}}},
+	}
+
+	config, err := NewManager("").GenerateWireGuardConfig(context.Background(), nodeConfig)
+	if err != nil {
+		t.Fatalf("GenerateWireGuardConfig failed: %v", err)
+	}
+
+	iface := strings.Split(config, "[Peer]")[0]
+	want := "PreUp = sysctl -w net.ipv4.ip_forward=1\n" +
+		"PostUp = iptables -A FORWARD -i %i -j ACCEPT\n" +
+		"PostUp = iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE\n" +
+		"PostDown = iptables -t nat -D POSTROUTING -o eth0 -j MASQUERADE\n"
+	if !strings.Contains(iface, want) {
+		t.Errorf("expected the hooks in the interface section, got:\n%s", config)
+	}
+	if strings.Contains(iface, "PreDown") {
+		t.Errorf("expected no PreDown line without hooks, got:\n%s", config)
+	}
+}
+
+func TestGenerateWireGuardConfigRejectsMultilineHooks(t *testing.T) {
+	for _, hook := range []string{"true\n[Peer]\nPublicKey = attacker", "true\rPostUp = rm -rf /"} {
+		nodeConfig := &types.NodeConfigResponse{
+			Interface: types.WGInterface{
+				PrivateKey:     "private-key",
+				InterfaceHooks: types.InterfaceHooks{PostUp: []string{hook}},
+			},
+		}
+
+		_, err := NewManager("").GenerateWireGuardConfig(context.Background(), nodeConfig)
+		if !errors.Is(err, types.ErrInvalidHook) {
+			t.Errorf("expected %q to be rejected, got %v", hook, err)
+		}
+	}
+}
+
+func TestGenerateWireGuardConfigRejectsInjection(t *testing.T) {
+	validPeer := func() types.WGPeer {
+		return types.WGPeer{PublicKey: berlinKey, AllowedIPs: []string{"10.100.1.2/32"}, Endpoint: "hub.example.com:51820"}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*types.NodeConfigResponse)
+		want   error
+	}{
+		{"endpoint with a directive", func(c *types.NodeConfigResponse) {
+			c.Peers[0].Endpoint = "hub.example.com:51820\nPublicKey = " + parisKey
+		}, types.ErrInvalidEndpoint},
+		{"endpoint without a port", func(c *types.NodeConfigResponse) {
+			c.Peers[0].Endpoint = "hub.example.com"
+		}, types.ErrInvalidEndpoint},
+		{"endpoint with a bad host", func(c *types.NodeConfigResponse) {
+			c.Peers[0].Endpoint = "hub example.com:51820"
+		}, types.ErrInvalidEndpoint},
+		{"public key with a directive", func(c *types.NodeConfigResponse) {
+			c.Peers[0].PublicKey = berlinKey + "\nEndpoint = 198.51.100.66:51820"
+		}, types.ErrInvalidKey},
+		{"short public key", func(c *types.NodeConfigResponse) {
+			c.Peers[0].PublicKey = "c2hvcnQ="
+		}, types.ErrInvalidKey},
+		{"allowed IP with a directive", func(c *types.NodeConfigResponse) {
+			c.Peers[0].Routes = []string{"192.168.10.0/24\n[Peer]"}
+		}, types.ErrControlChars},
+		{"address with a directive", func(c *types.NodeConfigResponse) {
+			c.Interface.Address = []string{"10.100.1.2/16\nPostUp = id"}
+		}, types.ErrControlChars},
+		{"DNS with a directive", func(c *types.NodeConfigResponse) {
+			c.Interface.DNS = []string{"10.100.0.53\rPostUp = id"}
+		}, types.ErrControlChars},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodeConfig := &types.NodeConfigResponse{
+				Interface: types.WGInterface{PrivateKey: "private-key", Address: []string{"10.100.1.2/16"}},
+				Peers:     []types.WGPeer{validPeer()},
+			}
+			tt.modify(nodeConfig)
+
+			config, err := NewManager("").GenerateWireGuardConfig(context.Background(), nodeConfig)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v with config:\n%s", tt.want, err, config)
+			}
+		})
+	}
+}
+
+func TestLoadConfigKeepsCurrentConfigWhenInvalid(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "agent.yaml"))
+	if err := m.CreateDefaultConfig("https://controller.example.com", "spoke-1", "spoke"); err != nil {
+		t.Fatalf("failed to create config: %v", err)
+	}
+	if err := m.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	current := m.GetConfig()
+
+	data := "controller:\n  url: https://controller.example.com\n  heartbeat_interval: -5s\n"
+	if err := os.WriteFile(m.configPath, []byte(data), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	if err := m.LoadConfig(); err == nil {
+		t.Fatal("expected a negative heartbeat interval to be rejected")
+	}
+	if m.GetConfig() != current {
+		t.Error("expected the current config to be kept")
+	}
+	if got := m.GetConfig().Controller.HeartbeatInterval; got != 30*time.Second {
+		t.Errorf("expected the heartbeat interval to stay 30s, got %v", got)
+	}
+}
+
+func TestWriteWireGuardConfigKeepsPrevious(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "agent.yaml"))
+	if err := m.CreateDefaultConfig("https://controller.example.com", "spoke-1", "spoke"); err != nil {
+		t.Fatalf("failed to create config: %v", err)
+	}
+	m.GetConfig().WireGuard.ConfigPath = filepath.Join(t.TempDir(), "wg0.conf")
+
+	if _, err := m.PreviousWireGuardConfig(); !os.IsNotExist(err) {
+		t.Fatalf("expected no previous config before the first write, got %v", err)
+	}
+
+	for _, config := range []string{"first", "second", "second"} {
+		if err := m.WriteWireGuardConfig(config); err != nil {
+			t.Fatalf("WriteWireGuardConfig failed: %v", err)
+		}
+	}
+
+	// Rewriting the same config doesn't replace the previous one
+	previous, err := m.PreviousWireGuardConfig()
+	if err != nil {
+		t.Fatalf("PreviousWireGuardConfig failed: %v", err)
+	}
+	if previous != "first" {
+		t.Errorf("expected the replaced config to be kept, got %q", previous)
+	}
+
+	if err := m.RestoreWireGuardConfig("first"); err != nil {
+		t.Fatalf("RestoreWireGuardConfig failed: %v", err)
+	}
+	if data, _ := os.ReadFile(m.WireGuardConfigPath()); string(data) != "first" {
+		t.Errorf("expected the restored config to be written, got %q", data)
+	}
+	if previous, _ := m.PreviousWireGuardConfig(); previous != "first" {
+		t.Errorf("expected a restore to leave the previous config, got %q", previous)
+	}
+}
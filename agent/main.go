@@ -3,18 +3,22 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 	"github.com/wg-hubspoke/wg-hubspoke/agent/client"
 	"github.com/wg-hubspoke/wg-hubspoke/agent/config"
+	"github.com/wg-hubspoke/wg-hubspoke/agent/services"
 	"github.com/wg-hubspoke/wg-hubspoke/agent/wg"
 	"github.com/wg-hubspoke/wg-hubspoke/common/types"
 	"golang.org/x/crypto/curve25519"
@@ -83,22 +87,25 @@ func runAgent(cmd *cobra.Command, args []string) {
 
 	agentConfig := configManager.GetConfig()
 
-	// Override configuration with command line flags
-	if controllerURL != "" {
-		agentConfig.Controller.URL = controllerURL
-	}
-	if nodeName != "" {
-		agentConfig.Node.Name = nodeName
-	}
-	if nodeType != "" {
-		agentConfig.Node.Type = nodeType
-	}
-	if endpoint != "" {
-		agentConfig.Node.Endpoint = endpoint
-	}
-	if port > 0 {
-		agentConfig.Node.Port = port
+	// Override configuration with command line flags, again on every reload
+	applyFlags := func(c *config.AgentConfig) {
+		if controllerURL != "" {
+			c.Controller.URL = controllerURL
+		}
+		if nodeName != "" {
+			c.Node.Name = nodeName
+		}
+		if nodeType != "" {
+			c.Node.Type = nodeType
+		}
+		if endpoint != "" {
+			c.Node.Endpoint = endpoint
+		}
+		if port > 0 {
+			c.Node.Port = port
+		}
 	}
+	applyFlags(agentConfig)
 
 	// Initialize WireGuard manager
 	wgManager, err := wg.NewManager(agentConfig.WireGuard.Interface)
@@ -114,6 +121,13 @@ func runAgent(cmd *cobra.Command, args []string) {
 
 	// Initialize controller client
 	controllerClient := client.NewControllerClient(agentConfig.Controller.URL)
+	controllerClient.SetToken(agentConfig.Controller.Token)
+	controllerClient.SetRetryPolicy(agentConfig.Controller.RetryAttempts, agentConfig.Controller.RetryDelay)
+	if c := agentConfig.Controller; c.ClientCertFile != "" || c.CAFile != "" {
+		if err := controllerClient.ConfigureTLS(c.ClientCertFile, c.ClientKeyFile, c.CAFile); err != nil {
+			log.Fatalf("Failed to configure controller TLS: %v", err)
+		}
+	}
 
 	// Start agent
 	agent := &Agent{
@@ -121,6 +135,7 @@ func runAgent(cmd *cobra.Command, args []string) {
 		configManager:    configManager,
 		wgManager:        wgManager,
 		controllerClient: controllerClient,
+		applyFlags:       applyFlags,
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -153,6 +168,12 @@ type Agent struct {
 	configManager    *config.Manager
 	wgManager        *wg.Manager
 	controllerClient *client.ControllerClient
+	nodeConfig       *types.NodeConfigResponse   // last config fetched from the controller
+	configHash       string                      // hash of the last written config, cleared if applying it fails
+	applyFlags       func(*config.AgentConfig)   // command line overrides, reapplied after a reload
+	lastApplied      *appliedConfig              // last config confirmed to reach the controller
+	peersSkipped     bool                        // the last peer report sent had skipped peers
+	bringUp          func(context.Context) error // brings up the written config; replaced in tests
 }
 
 func (a *Agent) RunOnce(ctx context.Context) error {
@@ -162,7 +183,7 @@ func (a *Agent) RunOnce(ctx context.Context) error {
 	}
 
 	// Get configuration
-	if err := a.updateConfiguration(ctx); err != nil {
+	if _, err := a.updateConfiguration(ctx); err != nil {
 		return fmt.Errorf("failed to update configuration: %w", err)
 	}
 
@@ -182,7 +203,30 @@ func (a *Agent) RunDaemon(ctx context.Context) error {
 		return fmt.Errorf("initial setup failed: %w", err)
 	}
 
-	// Start periodic tasks
+	// Report metrics now that the node ID is known
+	if a.config.Monitoring.Enabled {
+		monitoringService := services.NewMonitoringService(a.config, a.wgManager, a.controllerClient)
+		go monitoringService.StartPeriodicCollection(ctx)
+		go func() {
+			if err := monitoringService.ServeHealthCheck(ctx); err != nil {
+				log.Printf("Health check server stopped: %v", err)
+			}
+		}()
+	}
+
+	// Re-read the config file on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	a.runPeriodicTasks(ctx, reload)
+	return nil
+}
+
+// runPeriodicTasks sends heartbeats and refreshes the config until ctx is
+// done. A value on reload re-reads the config file and re-arms the tickers
+// with its intervals; the WireGuard interface is left as it is.
+func (a *Agent) runPeriodicTasks(ctx context.Context, reload <-chan os.Signal) {
 	heartbeatTicker := time.NewTicker(a.config.Controller.HeartbeatInterval)
 	defer heartbeatTicker.Stop()
 
@@ -192,24 +236,61 @@ func (a *Agent) RunDaemon(ctx context.Context) error {
 	for {
 		select {
 		case <-ctx.Done():
-			return nil
+			return
+		case <-reload:
+			if err := a.reloadConfig(); err != nil {
+				log.Printf("Config reload failed, keeping current config: %v", err)
+				continue
+			}
+			heartbeatTicker.Reset(a.config.Controller.HeartbeatInterval)
+			configTicker.Reset(a.config.Controller.ConfigRefreshInterval)
+			log.Printf("Configuration reloaded")
 		case <-heartbeatTicker.C:
 			if err := a.heartbeat(ctx); err != nil {
 				log.Printf("Heartbeat failed: %v", err)
 			}
 		case <-configTicker.C:
-			if err := a.updateConfiguration(ctx); err != nil {
+			changed, err := a.updateConfiguration(ctx)
+			if err != nil {
 				log.Printf("Config update failed: %v", err)
-			} else {
+			} else if changed {
 				if err := a.applyConfiguration(ctx); err != nil {
 					log.Printf("Config apply failed: %v", err)
+					// Try again on the next refresh, unless the config was
+					// rolled back; that one waits for the controller to
+					// change it
+					if !errors.Is(err, errConfigRolledBack) {
+						a.configHash = ""
+					}
 				}
 			}
 		}
 	}
 }
 
+// reloadConfig re-reads the config file, leaving the current config in place
+// if it can't be loaded or fails validation. The controller client and
+// monitoring keep the settings they started with until the agent restarts.
+func (a *Agent) reloadConfig() error {
+	if err := a.configManager.LoadConfig(); err != nil {
+		return err
+	}
+
+	reloaded := a.configManager.GetConfig()
+	if a.applyFlags != nil {
+		a.applyFlags(reloaded)
+	}
+	a.config = reloaded
+	return nil
+}
+
 func (a *Agent) registerNode(ctx context.Context) error {
+	// An agent.yaml downloaded from the controller names an existing node
+	// and carries an agent token, which may not register nodes
+	if a.config.Node.ID != "" && a.config.Controller.Token != "" {
+		return a.claimNode(ctx)
+	}
+
 	// Generate key pair if not exists
 	if a.config.WireGuard.PrivateKey == "" || a.config.WireGuard.PublicKey == "" {
 		privateKey, publicKey, err := a.generateKeyPair()
@@ -227,11 +308,13 @@ func (a *Agent) registerNode(ctx context.Context) error {
 
 	// Register with controller
 	req := types.NodeRegistrationRequest{
-		Name:      a.config.Node.Name,
-		NodeType:  a.config.Node.Type,
-		PublicKey: a.config.WireGuard.PublicKey,
-		Endpoint:  a.config.Node.Endpoint,
-		Port:      a.config.Node.Port,
+		Name:         a.config.Node.Name,
+		NodeType:     a.config.Node.Type,
+		PublicKey:    a.config.WireGuard.PublicKey,
+		Endpoint:     a.config.Node.Endpoint,
+		Port:         a.config.Node.Port,
+		AgentVersion: version,
+		BuildCommit:  commitHash,
 	}
 
 	resp, err := a.controllerClient.RegisterNode(ctx, req)
@@ -253,37 +336,125 @@ func (a *Agent) registerNode(ctx context.Context) error {
 	return nil
 }
 
-func (a *Agent) updateConfiguration(ctx context.Context) error {
+// claimNode takes over a node created on the controller. Its agent.yaml has
+// no private key, so the agent generates a pair on first start and submits
+// the public key in place of the one the node was created with.
+func (a *Agent) claimNode(ctx context.Context) error {
+	if a.config.WireGuard.PrivateKey != "" && a.config.WireGuard.PublicKey != "" {
+		return nil
+	}
+
+	if err := a.replaceKeyPair(ctx); err != nil {
+		return err
+	}
+
+	log.Printf("Node claimed with a new key pair: %s", a.config.Node.Name)
+	return nil
+}
+
+// replaceKeyPair generates a new key pair and submits its public key to the
+// controller. The pair is saved first, so the controller never holds a key
+// whose private half could be lost; if the submit fails, the old pair is
+// saved back.
+func (a *Agent) replaceKeyPair(ctx context.Context) error {
+	nodeID := a.config.Node.ID
+	oldPrivateKey, oldPublicKey := a.config.WireGuard.PrivateKey, a.config.WireGuard.PublicKey
+
+	privateKey, publicKey, err := a.generateKeyPair()
+	if err != nil {
+		return fmt.Errorf("failed to generate key pair: %w", err)
+	}
+
+	if err := a.configManager.UpdateNodeConfig(nodeID, privateKey, publicKey); err != nil {
+		a.restoreKeyPair(nodeID, oldPrivateKey, oldPublicKey)
+		return fmt.Errorf("failed to save key pair: %w", err)
+	}
+	if err := a.controllerClient.UpdatePublicKey(ctx, nodeID, publicKey); err != nil {
+		a.restoreKeyPair(nodeID, oldPrivateKey, oldPublicKey)
+		return fmt.Errorf("failed to submit public key: %w", err)
+	}
+	return nil
+}
+
+// restoreKeyPair puts back the key pair the controller still has.
+func (a *Agent) restoreKeyPair(nodeID, privateKey, publicKey string) {
+	if err := a.configManager.UpdateNodeConfig(nodeID, privateKey, publicKey); err != nil {
+		log.Printf("Failed to restore the previous key pair: %v", err)
+	}
+}
+
+// updateConfiguration fetches the node's config and writes it out, reporting
+// whether it changed. A config identical to the last one written is skipped
+// so it isn't reapplied on every refresh.
+func (a *Agent) updateConfiguration(ctx context.Context) (bool, error) {
 	if a.config.Node.ID == "" {
-		return fmt.Errorf("node ID not set")
+		return false, fmt.Errorf("node ID not set")
 	}
 
 	config, err := a.controllerClient.GetNodeConfig(ctx, a.config.Node.ID)
 	if err != nil {
-		return fmt.Errorf("failed to get node config: %w", err)
+		return false, fmt.Errorf("failed to get node config: %w", err)
+	}
+
+	// The controller never has the node's private key, so the agent fills
+	// in its own, first generating a new pair if the controller asks
+	if config.RotateKey {
+		if err := a.rotateKey(ctx); err != nil {
+			return false, err
+		}
 	}
+	config.Interface.PrivateKey = a.config.WireGuard.PrivateKey
 
 	// Generate WireGuard configuration
 	wgConfig, err := a.configManager.GenerateWireGuardConfig(ctx, config)
 	if err != nil {
-		return fmt.Errorf("failed to generate WireGuard config: %w", err)
+		return false, fmt.Errorf("failed to generate WireGuard config: %w", err)
+	}
+
+	// Routes are installed separately, so they count as part of the config
+	sum := sha256.Sum256([]byte(wgConfig + "\n" + strings.Join(config.Routes, "\n")))
+	hash := hex.EncodeToString(sum[:])
+	if hash == a.configHash {
+		return false, nil
 	}
 
 	// Write configuration to file
 	if err := a.configManager.WriteWireGuardConfig(wgConfig); err != nil {
-		return fmt.Errorf("failed to write WireGuard config: %w", err)
+		return false, fmt.Errorf("failed to write WireGuard config: %w", err)
 	}
+	a.nodeConfig = config
+	a.configHash = hash
 
 	log.Printf("Configuration updated successfully")
-	return nil
+	return true, nil
 }
 
+// applyConfiguration brings up the written config and reports the node
+// active, rolling back to the last config that could reach the controller if
+// the new one can't.
 func (a *Agent) applyConfiguration(ctx context.Context) error {
-	configPath := a.config.WireGuard.ConfigPath
-	if configPath == "" {
-		configPath = fmt.Sprintf("/etc/wireguard/%s.conf", a.config.WireGuard.Interface)
+	bringUp := a.bringUp
+	if bringUp == nil {
+		bringUp = a.bringUpConfiguration
+	}
+	if err := a.applyWithRollback(ctx, bringUp); err != nil {
+		return err
 	}
 
+	// Update node status to active
+	if err := a.controllerClient.UpdateNodeStatus(ctx, a.config.Node.ID, "active"); err != nil {
+		log.Printf("Failed to update node status: %v", err)
+	}
+
+	log.Printf("WireGuard configuration applied successfully")
+	return nil
+}
+
+// bringUpConfiguration applies the config file to the interface and waits
+// for a peer to connect.
+func (a *Agent) bringUpConfiguration(ctx context.Context) error {
+	configPath := a.configManager.WireGuardConfigPath()
+
 	// Validate configuration
 	if err := a.wgManager.ValidateConfig(configPath); err != nil {
 		return fmt.Errorf("invalid configuration: %w", err)
@@ -295,24 +466,77 @@ func (a *Agent) applyConfiguration(ctx context.Context) error {
 		return fmt.Errorf("failed to check interface status: %w", err)
 	}
 
+	appliedAt := time.Now()
 	if isUp {
-		// Restart interface with new configuration
-		if err := a.wgManager.RestartInterface(ctx, configPath); err != nil {
-			return fmt.Errorf("failed to restart interface: %w", err)
+		restarted, err := a.applyPeers(ctx, configPath)
+		if err != nil {
+			return err
+		}
+		if !restarted {
+			// Sessions survive a reload, so a handshake that is still valid counts
+			appliedAt = appliedAt.Add(-wg.RejectAfterTime)
 		}
 	} else {
 		// Start interface
 		if err := a.wgManager.ApplyConfig(ctx, configPath); err != nil {
 			return fmt.Errorf("failed to apply configuration: %w", err)
 		}
+		// wg-quick applied every peer, clearing any the controller holds
+		if err := a.reportSkippedPeers(ctx, len(a.nodeConfig.Peers), nil); err != nil {
+			log.Printf("Failed to report applied peers: %v", err)
+		}
 	}
 
-	// Update node status to active
-	if err := a.controllerClient.UpdateNodeStatus(ctx, a.config.Node.ID, "active"); err != nil {
-		log.Printf("Failed to update node status: %v", err)
+	if err := a.wgManager.SyncRoutes(ctx, a.nodeConfig.Routes); err != nil {
+		return fmt.Errorf("failed to install routes: %w", err)
+	}
+
+	// Only report the node active once a peer is actually connected
+	if err := a.wgManager.WaitForHandshake(ctx, appliedAt, a.config.WireGuard.HandshakeTimeout); err != nil {
+		return fmt.Errorf("configuration applied but not connected: %w", err)
 	}
 
-	log.Printf("WireGuard configuration applied successfully")
+	return nil
+}
+
+// applyPeers updates the running interface's peers in place, restarting it
+// only if the interface itself changed, and reports the peers that could not
+// be applied to the controller. It reports whether the interface restarted.
+func (a *Agent) applyPeers(ctx context.Context, configPath string) (bool, error) {
+	restarted, skipped, err := a.wgManager.Reload(ctx, configPath, a.nodeConfig, a.config.WireGuard.RestartDelay)
+	if err != nil {
+		return restarted, fmt.Errorf("failed to reload interface: %w", err)
+	}
+
+	if err := a.reportSkippedPeers(ctx, len(a.nodeConfig.Peers)-len(skipped), skipped); err != nil {
+		log.Printf("Some peers were not applied: %v", err)
+	}
+	return restarted, nil
+}
+
+// reportSkippedPeers logs peers that could not be applied and reports them
+// back to the controller. Once every peer applies, one clean report clears
+// the ones reported before.
+func (a *Agent) reportSkippedPeers(ctx context.Context, applied int, skipped []types.PeerApplyError) error {
+	if len(skipped) == 0 && !a.peersSkipped {
+		return nil
+	}
+
+	for _, peer := range skipped {
+		log.Printf("Skipped peer %s: %s", peer.PublicKey, peer.Error)
+	}
+
+	report := types.PeerApplyReport{
+		Applied:    applied,
+		Skipped:    skipped,
+		ReportedAt: time.Now(),
+	}
+
+	if err := a.controllerClient.ReportPeerErrors(ctx, a.config.Node.ID, report); err != nil {
+		return fmt.Errorf("failed to report skipped peers: %w", err)
+	}
+	a.peersSkipped = len(skipped) > 0
+
 	return nil
 }
 
@@ -333,6 +557,17 @@ func (a *Agent) heartbeat(ctx context.Context) error {
 	return nil
 }
 
+// rotateKey replaces the node's key pair at the controller's request. Peers
+// move to the new key on their next config refresh.
+func (a *Agent) rotateKey(ctx context.Context) error {
+	if err := a.replaceKeyPair(ctx); err != nil {
+		return fmt.Errorf("failed to rotate key: %w", err)
+	}
+
+	log.Printf("Rotated WireGuard key at the controller's request")
+	return nil
+}
+
 func (a *Agent) generateKeyPair() (string, string, error) {
 	// Generate private key
 	var privateKey [32]byte
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/agent/client"
+	"github.com/wg-hubspoke/wg-hubspoke/agent/config"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+// configServer serves a node config that tests can swap out and records
+// public keys and peer reports submitted by the agent. While unreachable is set it drops
+// every connection, as if the agent had lost its path to it.
+type configServer struct {
+	mu          sync.Mutex
+	config      types.NodeConfigResponse
+	publicKey   string
+	peerReports []types.PeerApplyReport
+	unreachable bool
+	rejectKeys  bool // refuse submitted public keys
+}
+
+func (s *configServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.unreachable {
+		if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			conn.Close()
+		}
+		return
+	}
+
+	if r.URL.Path == "/health" {
+		json.NewEncoder(w).Encode(types.APIResponse{Success: true, Data: types.HealthStatus{Status: "healthy"}})
+		return
+	}
+
+	if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/public-key") {
+		var req types.NodePublicKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.rejectKeys {
+			http.Error(w, "public key rejected", http.StatusInternalServerError)
+			return
+		}
+		s.publicKey = req.PublicKey
+		s.config.RotateKey = false
+		json.NewEncoder(w).Encode(types.APIResponse{Success: true})
+		return
+	}
+
+	if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/peer-errors") {
+		var report types.PeerApplyReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.peerReports = append(s.peerReports, report)
+		json.NewEncoder(w).Encode(types.APIResponse{Success: true})
+		return
+	}
+
+	json.NewEncoder(w).Encode(types.APIResponse{Success: true, Data: s.config})
+}
+
+func newConfigTestAgent(t *testing.T, server *configServer) (*Agent, string) {
+	t.Helper()
+
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	dir := t.TempDir()
+	configManager := config.NewManager(filepath.Join(dir, "agent.yaml"))
+	if err := configManager.CreateDefaultConfig(ts.URL, "spoke-1", "spoke"); err != nil {
+		t.Fatalf("failed to create config: %v", err)
+	}
+
+	agentConfig := configManager.GetConfig()
+	agentConfig.Node.ID = "node-1"
+	agentConfig.WireGuard.PrivateKey = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	agentConfig.WireGuard.PublicKey = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	agentConfig.WireGuard.ConfigPath = filepath.Join(dir, "wg0.conf")
+
+	return &Agent{
+		config:           agentConfig,
+		configManager:    configManager,
+		controllerClient: client.NewControllerClient(ts.URL),
+	}, agentConfig.WireGuard.ConfigPath
+}
+
+func TestUpdateConfigurationSkipsUnchangedConfig(t *testing.T) {
+	server := &configServer{config: types.NodeConfigResponse{
+		Interface: types.WGInterface{Address: []string{"10.100.1.2/16"}},
+		Peers: []types.WGPeer{
+			{PublicKey: "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=", AllowedIPs: []string{"10.100.0.0/16"}, Endpoint: "hub.example.com:51820"},
+		},
+	}}
+	agent, configPath := newConfigTestAgent(t, server)
+	ctx := context.Background()
+
+	changed, err := agent.updateConfiguration(ctx)
+	if err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	if !changed {
+		t.Fatal("expected the first config to be written")
+	}
+
+	// Mark the file so a second write would be noticed
+	if err := os.WriteFile(configPath, []byte("written once"), 0600); err != nil {
+		t.Fatalf("failed to mark config: %v", err)
+	}
+
+	changed, err = agent.updateConfiguration(ctx)
+	if err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	if changed {
+		t.Error("expected an identical config to be reported unchanged")
+	}
+	if data, _ := os.ReadFile(configPath); string(data) != "written once" {
+		t.Errorf("expected an identical config not to be rewritten, got:\n%s", data)
+	}
+
+	// Routes are part of the config even though they aren't in the file
+	server.mu.Lock()
+	server.config.Routes = []string{"192.168.10.0/24"}
+	server.mu.Unlock()
+
+	changed, err = agent.updateConfiguration(ctx)
+	if err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	if !changed {
+		t.Error("expected a route change to be reported")
+	}
+	if data, _ := os.ReadFile(configPath); string(data) == "written once" {
+		t.Error("expected the changed config to be written")
+	}
+}
+
+func TestUpdateConfigurationRewritesAfterFailedApply(t *testing.T) {
+	server := &configServer{config: types.NodeConfigResponse{
+		Interface: types.WGInterface{Address: []string{"10.100.1.2/16"}},
+	}}
+	agent, _ := newConfigTestAgent(t, server)
+	ctx := context.Background()
+
+	if _, err := agent.updateConfiguration(ctx); err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+
+	// The daemon clears the hash when applying fails
+	agent.configHash = ""
+
+	changed, err := agent.updateConfiguration(ctx)
+	if err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	if !changed {
+		t.Error("expected the config to be rewritten after a failed apply")
+	}
+}
+
+func TestUpdateConfigurationRotatesKeyLocally(t *testing.T) {
+	server := &configServer{config: types.NodeConfigResponse{
+		Interface: types.WGInterface{Address: []string{"10.100.1.2/16"}},
+	}}
+	agent, configPath := newConfigTestAgent(t, server)
+	ctx := context.Background()
+
+	// The controller sends no private key; the agent injects its own
+	if _, err := agent.updateConfiguration(ctx); err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	if data, _ := os.ReadFile(configPath); !strings.Contains(string(data), "PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\n") {
+		t.Errorf("expected the agent's own key to be used, got:\n%s", data)
+	}
+	if server.publicKey != "" {
+		t.Errorf("expected no key to be submitted before a rotation is requested, got %q", server.publicKey)
+	}
+
+	server.mu.Lock()
+	server.config.RotateKey = true
+	server.mu.Unlock()
+
+	changed, err := agent.updateConfiguration(ctx)
+	if err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	if !changed {
+		t.Fatal("expected a rotated key to change the config")
+	}
+
+	rotated := agent.config.WireGuard
+	if rotated.PrivateKey == "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=" {
+		t.Fatal("expected a new private key to be generated")
+	}
+	if server.publicKey != rotated.PublicKey {
+		t.Errorf("expected the new public key %q to be submitted, got %q", rotated.PublicKey, server.publicKey)
+	}
+	if data, _ := os.ReadFile(configPath); !strings.Contains(string(data), "PrivateKey = "+rotated.PrivateKey+"\n") {
+		t.Errorf("expected the new private key to be written, got:\n%s", data)
+	}
+
+	// The new key pair is saved for the next registration
+	reloaded := config.NewManager(filepath.Join(filepath.Dir(configPath), "agent.yaml"))
+	if err := reloaded.LoadConfig(); err != nil {
+		t.Fatalf("failed to reload agent config: %v", err)
+	}
+	if got := reloaded.GetConfig().WireGuard; got.PrivateKey != rotated.PrivateKey || got.PublicKey != rotated.PublicKey {
+		t.Errorf("expected the new key pair to be saved, got %q/%q", got.PrivateKey, got.PublicKey)
+	}
+}
+
+func TestRotateKeyKeepsPairWhenSubmitFails(t *testing.T) {
+	server := &configServer{config: types.NodeConfigResponse{
+		Interface: types.WGInterface{Address: []string{"10.100.1.2/16"}},
+		RotateKey: true,
+	}, rejectKeys: true}
+	agent, configPath := newConfigTestAgent(t, server)
+	original := agent.config.WireGuard
+
+	if _, err := agent.updateConfiguration(context.Background()); err == nil {
+		t.Fatal("expected a rejected public key to fail the rotation")
+	}
+
+	if got := agent.config.WireGuard; got.PrivateKey != original.PrivateKey || got.PublicKey != original.PublicKey {
+		t.Errorf("expected the old key pair to be kept, got %q/%q", got.PrivateKey, got.PublicKey)
+	}
+	reloaded := config.NewManager(filepath.Join(filepath.Dir(configPath), "agent.yaml"))
+	if err := reloaded.LoadConfig(); err != nil {
+		t.Fatalf("failed to reload agent config: %v", err)
+	}
+	if got := reloaded.GetConfig().WireGuard; got.PrivateKey != original.PrivateKey || got.PublicKey != original.PublicKey {
+		t.Errorf("expected the old key pair to be saved back, got %q/%q", got.PrivateKey, got.PublicKey)
+	}
+}
+
+func TestReportSkippedPeersClearsOnceApplied(t *testing.T) {
+	server := &configServer{}
+	agent, _ := newConfigTestAgent(t, server)
+	ctx := context.Background()
+
+	// Nothing to report while every peer applies
+	if err := agent.reportSkippedPeers(ctx, 2, nil); err != nil {
+		t.Fatalf("reportSkippedPeers failed: %v", err)
+	}
+	if len(server.peerReports) != 0 {
+		t.Fatalf("expected no report without skipped peers, got %+v", server.peerReports)
+	}
+
+	skipped := []types.PeerApplyError{{PublicKey: "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=", Error: "failed to configure peer: invalid argument"}}
+	if err := agent.reportSkippedPeers(ctx, 1, skipped); err != nil {
+		t.Fatalf("reportSkippedPeers failed: %v", err)
+	}
+	if len(server.peerReports) != 1 {
+		t.Fatalf("expected the skipped peer to be reported, got %+v", server.peerReports)
+	}
+	if report := server.peerReports[0]; report.Applied != 1 || len(report.Skipped) != 1 || report.Skipped[0].PublicKey != skipped[0].PublicKey {
+		t.Errorf("expected one applied and one skipped peer, got %+v", report)
+	}
+
+	// The next clean apply clears the skipped peer, once
+	for i := 0; i < 2; i++ {
+		if err := agent.reportSkippedPeers(ctx, 2, nil); err != nil {
+			t.Fatalf("reportSkippedPeers failed: %v", err)
+		}
+	}
+	if len(server.peerReports) != 2 || len(server.peerReports[1].Skipped) != 0 || server.peerReports[1].Applied != 2 {
+		t.Errorf("expected a single clean report, got %+v", server.peerReports)
+	}
+}
+
+func TestSIGHUPReloadsHeartbeatInterval(t *testing.T) {
+	heartbeats := make(chan struct{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Path == "/api/v1/nodes/node-1" {
+			select {
+			case heartbeats <- struct{}{}:
+			default:
+			}
+		}
+		json.NewEncoder(w).Encode(types.APIResponse{Success: true})
+	}))
+	t.Cleanup(ts.Close)
+
+	configPath := filepath.Join(t.TempDir(), "agent.yaml")
+	configManager := config.NewManager(configPath)
+	if err := configManager.CreateDefaultConfig(ts.URL, "spoke-1", "spoke"); err != nil {
+		t.Fatalf("failed to create config: %v", err)
+	}
+	agentConfig := configManager.GetConfig()
+	agentConfig.Node.ID = "node-1"
+	agentConfig.Controller.HeartbeatInterval = time.Hour
+	agentConfig.Controller.ConfigRefreshInterval = time.Hour
+	if err := configManager.SaveConfig(); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+
+	agent := &Agent{
+		config:           agentConfig,
+		configManager:    configManager,
+		controllerClient: client.NewControllerClient(ts.URL),
+	}
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		agent.runPeriodicTasks(ctx, reload)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Shorten the interval on disk, which only takes effect after SIGHUP
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	data = []byte(strings.Replace(string(data), "heartbeat_interval: 1h0m0s", "heartbeat_interval: 20ms", 1))
+	if err := os.WriteFile(configPath, data, 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	select {
+	case <-heartbeats:
+		t.Fatal("expected no heartbeat before the reload")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("failed to send SIGHUP: %v", err)
+	}
+
+	select {
+	case <-heartbeats:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a heartbeat at the reloaded interval")
+	}
+}
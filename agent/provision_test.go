@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wg-hubspoke/wg-hubspoke/agent/client"
+	"github.com/wg-hubspoke/wg-hubspoke/agent/config"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/api"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The models' gen_random_uuid() defaults are Postgres-only, so the tables
+// the node routes touch are created by hand using gorm's column names
+var provisionTestSchema = []string{
+	`CREATE TABLE nodes (
+		id TEXT PRIMARY KEY, name TEXT NOT NULL UNIQUE, description TEXT, node_type TEXT NOT NULL,
+		public_key TEXT NOT NULL, key_rotated_at DATETIME, key_rotation_requested_at DATETIME,
+		allocated_ip TEXT NOT NULL, endpoint TEXT,
+		port INTEGER, allowed_ips TEXT, last_handshake DATETIME, last_seen DATETIME, endpoint_probed_at DATETIME, endpoint_probe_error TEXT, peer_errors TEXT, peer_errors_reported_at DATETIME, agent_version TEXT, build_commit TEXT, status TEXT, persistent_keepalive INTEGER,
+		mtu INTEGER, pinned_hub_id TEXT, backup_hub_ids TEXT, routes TEXT,
+		pre_up TEXT, post_up TEXT, pre_down TEXT, post_down TEXT, tags TEXT, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+	`CREATE TABLE topology (
+		id TEXT PRIMARY KEY, hub_id TEXT NOT NULL, spoke_id TEXT NOT NULL,
+		created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+	`CREATE TABLE policies (
+		id TEXT PRIMARY KEY, name TEXT NOT NULL, description TEXT,
+		source_node_id TEXT, destination_node_id TEXT, source_group TEXT, destination_group TEXT,
+		source_group_id TEXT, destination_group_id TEXT, source_cidr TEXT, destination_cidr TEXT,
+		protocol TEXT, port INTEGER, action TEXT NOT NULL, priority INTEGER DEFAULT 100, enabled BOOLEAN DEFAULT TRUE,
+		created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+	`CREATE TABLE audit_logs (
+		id TEXT PRIMARY KEY, user_id TEXT, action TEXT NOT NULL, resource TEXT,
+		resource_id TEXT, description TEXT, ip_address TEXT, user_agent TEXT,
+		metadata TEXT, job_id TEXT, sequence INTEGER, prev_hash TEXT, hash TEXT,
+		created_at DATETIME)`,
+}
+
+// newProvisionTestController serves the controller's node routes behind its
+// real authentication middleware.
+func newProvisionTestController(t *testing.T) (*httptest.Server, *services.NodeService, *services.AuthService, *gorm.DB) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	for _, stmt := range provisionTestSchema {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create test schema: %v", err)
+		}
+	}
+
+	controllerConfig := &types.Config{
+		Auth: types.AuthConfig{JWTSecret: "provision-test-secret", NodeTokenExpiration: time.Hour},
+		WG:   types.WGConfig{Interface: "wg0", Subnet: "10.100.0.0/16", MTU: 1420},
+	}
+	auditService := services.NewAuditService(db)
+	nodeService := services.NewNodeService(db, controllerConfig, auditService)
+	authService := services.NewAuthService(db, controllerConfig, auditService)
+	authHandler := api.NewAuthHandler(authService)
+	nodesHandler := api.NewNodesHandler(nodeService, services.NewMonitoringService(db, nil), authService, services.NewApprovalService(db, controllerConfig, auditService))
+	healthHandler := api.NewHealthHandler(services.NewHealthService(db, "test"), "test")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/health", healthHandler.HealthCheck)
+	v1 := router.Group("/api/v1")
+	v1.Use(authHandler.AuthMiddleware())
+	v1.Use(authHandler.ReadOnlyMiddleware())
+	nodes := v1.Group("/nodes")
+	nodes.POST("", nodesHandler.RegisterNode)
+	nodes.PUT("/:id", nodesHandler.UpdateNode)
+	nodes.GET("/:id/config", nodesHandler.GetNodeConfig)
+	nodes.POST("/:id/peer-errors", nodesHandler.ReportPeerErrors)
+	nodes.PUT("/:id/public-key", nodesHandler.UpdateNodePublicKey)
+
+	ts := httptest.NewServer(router)
+	t.Cleanup(ts.Close)
+	return ts, nodeService, authService, db
+}
+
+func TestRunOnceClaimsNodeFromDownloadedConfig(t *testing.T) {
+	ts, nodeService, authService, db := newProvisionTestController(t)
+	ctx := context.Background()
+
+	// An operator creates the node and downloads its agent.yaml
+	createdKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{5}, 32))
+	node, err := nodeService.RegisterNode(ctx, types.NodeRegistrationRequest{
+		Name:      "spoke-1",
+		NodeType:  "spoke",
+		PublicKey: createdKey,
+		Endpoint:  "203.0.113.10",
+		Port:      51820,
+	})
+	if err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+	token, _, err := authService.GenerateNodeToken(ctx, node, nil)
+	if err != nil {
+		t.Fatalf("GenerateNodeToken failed: %v", err)
+	}
+	data, err := nodeService.GenerateAgentConfig(node, ts.URL, token)
+	if err != nil {
+		t.Fatalf("GenerateAgentConfig failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.yaml")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write agent.yaml: %v", err)
+	}
+
+	newAgent := func() *Agent {
+		configManager := config.NewManager(path)
+		if err := configManager.LoadConfig(); err != nil {
+			t.Fatalf("failed to load agent.yaml: %v", err)
+		}
+		agentConfig := configManager.GetConfig()
+		agentConfig.WireGuard.ConfigPath = filepath.Join(dir, "wg0.conf")
+		agentConfig.WireGuard.ConfirmTimeout = time.Second
+
+		controllerClient := client.NewControllerClient(agentConfig.Controller.URL)
+		controllerClient.SetToken(agentConfig.Controller.Token)
+		return &Agent{
+			config:           agentConfig,
+			configManager:    configManager,
+			controllerClient: controllerClient,
+			bringUp:          func(context.Context) error { return nil },
+		}
+	}
+
+	agent := newAgent()
+	if err := agent.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+
+	publicKey := agent.config.WireGuard.PublicKey
+	if publicKey == "" || publicKey == createdKey || agent.config.WireGuard.PrivateKey == "" {
+		t.Fatalf("expected the agent to generate its own key pair, got public key %q", publicKey)
+	}
+	stored, err := nodeService.GetNode(ctx, node.ID)
+	if err != nil {
+		t.Fatalf("GetNode failed: %v", err)
+	}
+	if stored.PublicKey != publicKey {
+		t.Errorf("expected the controller to hold the agent's public key %s, got %s", publicKey, stored.PublicKey)
+	}
+	var count int64
+	db.Model(&models.Node{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected the agent to claim the existing node, got %d nodes", count)
+	}
+
+	wgConfig, err := os.ReadFile(agent.config.WireGuard.ConfigPath)
+	if err != nil {
+		t.Fatalf("expected a WireGuard config to be written: %v", err)
+	}
+	if !strings.Contains(string(wgConfig), "PrivateKey = "+agent.config.WireGuard.PrivateKey) {
+		t.Errorf("expected the WireGuard config to use the generated key, got:\n%s", wgConfig)
+	}
+
+	// A restart reuses the saved pair rather than replacing it again
+	restarted := newAgent()
+	if err := restarted.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce after a restart failed: %v", err)
+	}
+	if restarted.config.WireGuard.PublicKey != publicKey {
+		t.Errorf("expected the saved key pair to be kept, got %s", restarted.config.WireGuard.PublicKey)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+// confirmPollInterval is how often a newly applied config is checked for a
+// path to the controller.
+const confirmPollInterval = 2 * time.Second
+
+// errConfigRolledBack is returned when a new config was applied but had to
+// be replaced by the previous one.
+var errConfigRolledBack = errors.New("configuration rolled back")
+
+// appliedConfig is a config that was applied and could reach the controller.
+type appliedConfig struct {
+	contents   []byte
+	nodeConfig *types.NodeConfigResponse
+}
+
+// applyWithRollback applies the written config with apply and confirms the
+// controller can still be reached over it. If either step fails, the last
+// confirmed config is written back and applied again so the agent isn't cut
+// off by a bad config. Without one, the error is returned as it is.
+func (a *Agent) applyWithRollback(ctx context.Context, apply func(context.Context) error) error {
+	err := apply(ctx)
+	if err == nil {
+		err = a.confirmControllerReachable(ctx)
+	}
+	if err == nil {
+		a.recordAppliedConfig()
+		return nil
+	}
+	if a.lastApplied == nil || ctx.Err() != nil {
+		return err
+	}
+
+	log.Printf("New configuration failed, rolling back to the previous one: %v", err)
+	if rollbackErr := a.rollbackConfiguration(ctx, apply); rollbackErr != nil {
+		return fmt.Errorf("%w; rollback failed: %v", err, rollbackErr)
+	}
+	return fmt.Errorf("%w: %v", errConfigRolledBack, err)
+}
+
+// confirmControllerReachable polls the controller's health check until it
+// answers or the confirm timeout passes.
+func (a *Agent) confirmControllerReachable(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, a.config.WireGuard.ConfirmTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(confirmPollInterval)
+	defer ticker.Stop()
+
+	for {
+		_, err := a.controllerClient.HealthCheck(ctx)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("controller unreachable after applying configuration: %w", err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordAppliedConfig keeps the config file just confirmed as the one to roll
+// back to.
+func (a *Agent) recordAppliedConfig() {
+	contents, err := os.ReadFile(a.configManager.WireGuardConfigPath())
+	if err != nil {
+		log.Printf("Failed to keep applied configuration for rollback: %v", err)
+		return
+	}
+
+	a.lastApplied = &appliedConfig{
+		contents:   contents,
+		nodeConfig: a.nodeConfig,
+	}
+}
+
+// rollbackConfiguration writes the last confirmed config back and applies it.
+// The .bak and config hash are left alone, so the previous config stays on
+// disk and the rolled back one isn't written again until the controller
+// changes it. The exception is a config with an older private key: the
+// controller only knows the new one, so the config is retried on the next
+// refresh.
+func (a *Agent) rollbackConfiguration(ctx context.Context, apply func(context.Context) error) error {
+	previous := a.lastApplied
+	if err := a.configManager.RestoreWireGuardConfig(string(previous.contents)); err != nil {
+		return fmt.Errorf("failed to restore previous config: %w", err)
+	}
+	a.nodeConfig = previous.nodeConfig
+	if previous.nodeConfig != nil && previous.nodeConfig.Interface.PrivateKey != a.config.WireGuard.PrivateKey {
+		a.configHash = ""
+	}
+
+	if err := apply(ctx); err != nil {
+		return fmt.Errorf("failed to apply previous config: %w", err)
+	}
+
+	log.Printf("Rolled back to the previous WireGuard configuration")
+	return nil
+}
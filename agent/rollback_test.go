@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+var (
+	goodPeer = types.WGPeer{PublicKey: "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=", AllowedIPs: []string{"10.100.0.0/16"}, Endpoint: "hub.example.com:51820"}
+	badPeer  = types.WGPeer{PublicKey: "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", AllowedIPs: []string{"0.0.0.0/0"}, Endpoint: "hub.example.com:51820"}
+)
+
+// fakeApply stands in for bringing up the interface. It records each config
+// file it applies and cuts the agent off from the controller whenever that
+// config routes everything through badPeer.
+type fakeApply struct {
+	t          *testing.T
+	server     *configServer
+	configPath string
+	applied    []string
+}
+
+func (f *fakeApply) apply(ctx context.Context) error {
+	data, err := os.ReadFile(f.configPath)
+	if err != nil {
+		f.t.Fatalf("failed to read applied config: %v", err)
+	}
+	f.applied = append(f.applied, string(data))
+
+	f.server.mu.Lock()
+	f.server.unreachable = strings.Contains(string(data), badPeer.PublicKey)
+	f.server.mu.Unlock()
+	return nil
+}
+
+func TestApplyRollsBackWhenControllerUnreachable(t *testing.T) {
+	server := &configServer{config: types.NodeConfigResponse{
+		Interface: types.WGInterface{Address: []string{"10.100.1.2/16"}},
+		Peers:     []types.WGPeer{goodPeer},
+	}}
+	agent, configPath := newConfigTestAgent(t, server)
+	agent.config.WireGuard.ConfirmTimeout = 100 * time.Millisecond
+	fake := &fakeApply{t: t, server: server, configPath: configPath}
+	ctx := context.Background()
+
+	if _, err := agent.updateConfiguration(ctx); err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	if err := agent.applyWithRollback(ctx, fake.apply); err != nil {
+		t.Fatalf("expected the first config to apply, got %v", err)
+	}
+	good := fake.applied[0]
+
+	// The next config loses the path to the controller once applied
+	server.mu.Lock()
+	server.config.Peers = []types.WGPeer{badPeer}
+	server.mu.Unlock()
+
+	if _, err := agent.updateConfiguration(ctx); err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	err := agent.applyWithRollback(ctx, fake.apply)
+	if !errors.Is(err, errConfigRolledBack) {
+		t.Fatalf("expected the config to be rolled back, got %v", err)
+	}
+
+	if len(fake.applied) != 3 || fake.applied[2] != good {
+		t.Fatalf("expected the previous config to be applied again, got %d applies", len(fake.applied))
+	}
+	if data, _ := os.ReadFile(configPath); string(data) != good {
+		t.Errorf("expected the previous config file to be restored, got:\n%s", data)
+	}
+	if previous, _ := agent.configManager.PreviousWireGuardConfig(); previous != good {
+		t.Errorf("expected the previous config to stay backed up, got:\n%s", previous)
+	}
+	if len(agent.nodeConfig.Peers) != 1 || agent.nodeConfig.Peers[0].PublicKey != goodPeer.PublicKey {
+		t.Errorf("expected the previous peers to be restored, got %+v", agent.nodeConfig.Peers)
+	}
+
+	// The rolled back config isn't rewritten until the controller changes it
+	changed, err := agent.updateConfiguration(ctx)
+	if err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	if changed {
+		t.Error("expected the rolled back config not to be written again")
+	}
+}
+
+func TestApplyRollsBackWhenApplyFails(t *testing.T) {
+	server := &configServer{config: types.NodeConfigResponse{
+		Interface: types.WGInterface{Address: []string{"10.100.1.2/16"}},
+		Peers:     []types.WGPeer{goodPeer},
+	}}
+	agent, configPath := newConfigTestAgent(t, server)
+	ctx := context.Background()
+
+	var applies int
+	apply := func(ctx context.Context) error {
+		applies++
+		if applies == 2 {
+			return errors.New("no peer handshake within timeout")
+		}
+		return nil
+	}
+
+	if _, err := agent.updateConfiguration(ctx); err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	if err := agent.applyWithRollback(ctx, apply); err != nil {
+		t.Fatalf("expected the first config to apply, got %v", err)
+	}
+	good, _ := os.ReadFile(configPath)
+
+	server.mu.Lock()
+	server.config.Interface.Address = []string{"10.100.1.3/16"}
+	server.mu.Unlock()
+
+	if _, err := agent.updateConfiguration(ctx); err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	if err := agent.applyWithRollback(ctx, apply); !errors.Is(err, errConfigRolledBack) {
+		t.Fatalf("expected the config to be rolled back, got %v", err)
+	}
+	if data, _ := os.ReadFile(configPath); string(data) != string(good) {
+		t.Errorf("expected the previous config file to be restored, got:\n%s", data)
+	}
+}
+
+func TestRollbackAfterKeyRotationRetriesConfig(t *testing.T) {
+	server := &configServer{config: types.NodeConfigResponse{
+		Interface: types.WGInterface{Address: []string{"10.100.1.2/16"}},
+		Peers:     []types.WGPeer{goodPeer},
+	}}
+	agent, configPath := newConfigTestAgent(t, server)
+	ctx := context.Background()
+
+	var applies int
+	apply := func(ctx context.Context) error {
+		applies++
+		if applies == 2 {
+			return errors.New("no peer handshake within timeout")
+		}
+		return nil
+	}
+
+	if _, err := agent.updateConfiguration(ctx); err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	if err := agent.applyWithRollback(ctx, apply); err != nil {
+		t.Fatalf("expected the first config to apply, got %v", err)
+	}
+
+	server.mu.Lock()
+	server.config.RotateKey = true
+	server.mu.Unlock()
+
+	if _, err := agent.updateConfiguration(ctx); err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	if err := agent.applyWithRollback(ctx, apply); !errors.Is(err, errConfigRolledBack) {
+		t.Fatalf("expected the config to be rolled back, got %v", err)
+	}
+
+	// The controller only has the new public key, so the old config can't
+	// be left in place until the controller changes something
+	changed, err := agent.updateConfiguration(ctx)
+	if err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	if !changed {
+		t.Fatal("expected the rotated config to be written again")
+	}
+	if data, _ := os.ReadFile(configPath); !strings.Contains(string(data), "PrivateKey = "+agent.config.WireGuard.PrivateKey+"\n") {
+		t.Errorf("expected the new private key to be written, got:\n%s", data)
+	}
+}
+
+func TestApplyWithoutPreviousConfigReturnsError(t *testing.T) {
+	server := &configServer{config: types.NodeConfigResponse{
+		Interface: types.WGInterface{Address: []string{"10.100.1.2/16"}},
+		Peers:     []types.WGPeer{badPeer},
+	}}
+	agent, configPath := newConfigTestAgent(t, server)
+	agent.config.WireGuard.ConfirmTimeout = 100 * time.Millisecond
+	fake := &fakeApply{t: t, server: server, configPath: configPath}
+	ctx := context.Background()
+
+	if _, err := agent.updateConfiguration(ctx); err != nil {
+		t.Fatalf("updateConfiguration failed: %v", err)
+	}
+	err := agent.applyWithRollback(ctx, fake.apply)
+	if err == nil {
+		t.Fatal("expected an unreachable controller to fail the apply")
+	}
+	if errors.Is(err, errConfigRolledBack) {
+		t.Error("expected nothing to roll back to")
+	}
+	if len(fake.applied) != 1 {
+		t.Errorf("expected the config to be applied once, got %d", len(fake.applied))
+	}
+	if agent.lastApplied != nil {
+		t.Error("expected an unconfirmed config not to be kept for rollback")
+	}
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// healthCheckTimeout bounds the controller check behind /health, so a
+// monitoring probe isn't held up by the client's retries.
+const healthCheckTimeout = 5 * time.Second
+
+// AgentHealth is the body of the agent's /health endpoint.
+type AgentHealth struct {
+	Status     string   `json:"status"`     // healthy or unhealthy
+	Interface  string   `json:"interface"`  // up or down
+	Controller string   `json:"controller"` // reachable or unreachable
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// HealthHandler serves /health and the configured metrics path for local
+// monitoring of the node.
+func (s *MonitoringService) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.serveHealth)
+	mux.HandleFunc(s.metricsPath(), s.serveMetrics)
+	return mux
+}
+
+// ServeHealthCheck listens on the configured health check port until ctx is
+// done.
+func (s *MonitoringService) ServeHealthCheck(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", s.config.Monitoring.HealthCheckPort),
+		Handler:           s.HealthHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve health check: %w", err)
+	}
+	return nil
+}
+
+func (s *MonitoringService) metricsPath() string {
+	if s.config.Monitoring.MetricsPath == "" {
+		return "/metrics"
+	}
+	return s.config.Monitoring.MetricsPath
+}
+
+// CheckHealth reports whether the WireGuard interface is up and the
+// controller answers its health check.
+func (s *MonitoringService) CheckHealth(ctx context.Context) *AgentHealth {
+	health := &AgentHealth{Status: "healthy", Interface: "up", Controller: "reachable"}
+
+	isUp, err := s.wgManager.IsInterfaceUp()
+	if err != nil {
+		health.Errors = append(health.Errors, fmt.Sprintf("interface check failed: %v", err))
+	}
+	if !isUp {
+		health.Status = "unhealthy"
+		health.Interface = "down"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	if _, err := s.controllerClient.HealthCheck(ctx); err != nil {
+		health.Status = "unhealthy"
+		health.Controller = "unreachable"
+		health.Errors = append(health.Errors, err.Error())
+	}
+
+	return health
+}
+
+func (s *MonitoringService) serveHealth(w http.ResponseWriter, r *http.Request) {
+	health := s.CheckHealth(r.Context())
+
+	status := http.StatusOK
+	if health.Status != "healthy" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
+
+func (s *MonitoringService) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics := s.LatestMetrics()
+	if metrics == nil {
+		http.Error(w, "no metrics collected yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writePrometheusMetrics(w, metrics)
+}
+
+// writePrometheusMetrics writes a sample in the Prometheus text format,
+// labelled with the node's ID.
+func writePrometheusMetrics(w io.Writer, m *NodeMetrics) {
+	labels := fmt.Sprintf("{node_id=%q}", m.NodeID.String())
+	write := func(name, kind, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s%s %g\n", name, help, name, kind, name, labels, value)
+	}
+
+	wgUp := 0.0
+	if m.WGMetrics.Status == "up" {
+		wgUp = 1
+	}
+	var lastHandshake float64
+	if !m.WGMetrics.LastHandshake.IsZero() {
+		lastHandshake = float64(m.WGMetrics.LastHandshake.Unix())
+	}
+
+	write("wg_sdwan_agent_cpu_usage_percent", "gauge", "CPU usage of the node", m.SystemMetrics.CPUUsage)
+	write("wg_sdwan_agent_memory_usage_percent", "gauge", "Memory usage of the node", m.SystemMetrics.MemoryUsage)
+	write("wg_sdwan_agent_disk_usage_percent", "gauge", "Disk usage of the node's root filesystem", m.SystemMetrics.DiskUsage)
+	write("wg_sdwan_agent_network_receive_bytes_total", "counter", "Bytes received on non-loopback interfaces", float64(m.SystemMetrics.NetworkRx))
+	write("wg_sdwan_agent_network_transmit_bytes_total", "counter", "Bytes sent on non-loopback interfaces", float64(m.SystemMetrics.NetworkTx))
+	write("wg_sdwan_agent_wireguard_up", "gauge", "Whether the WireGuard interface is up", wgUp)
+	write("wg_sdwan_agent_wireguard_peers", "gauge", "Number of WireGuard peers", float64(m.WGMetrics.Peers))
+	write("wg_sdwan_agent_wireguard_receive_bytes_total", "counter", "Bytes received from WireGuard peers", float64(m.WGMetrics.RxBytes))
+	write("wg_sdwan_agent_wireguard_transmit_bytes_total", "counter", "Bytes sent to WireGuard peers", float64(m.WGMetrics.TxBytes))
+	write("wg_sdwan_agent_wireguard_last_handshake_timestamp_seconds", "gauge", "Time of the most recent peer handshake", lastHandshake)
+	write("wg_sdwan_agent_latency_milliseconds", "gauge", "Average ping latency to the first peer", m.WGMetrics.Latency)
+	write("wg_sdwan_agent_packet_loss_percent", "gauge", "Ping packet loss to the first peer", m.WGMetrics.PacketLoss)
+	write("wg_sdwan_agent_collection_errors", "gauge", "Errors in the last metrics collection", float64(len(m.Errors)))
+	write("wg_sdwan_agent_last_collection_timestamp_seconds", "gauge", "Time of the last metrics collection", float64(m.Timestamp.Unix()))
+}
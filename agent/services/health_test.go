@@ -0,0 +1,141 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/agent/client"
+	"github.com/wg-hubspoke/wg-hubspoke/agent/config"
+	"github.com/wg-hubspoke/wg-hubspoke/agent/wg"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+// fakeInterface reports a fixed interface state.
+type fakeInterface struct {
+	up bool
+}
+
+func (f fakeInterface) IsInterfaceUp() (bool, error) {
+	return f.up, nil
+}
+
+func (f fakeInterface) GetInterfaceStatus() (*wg.InterfaceStatus, error) {
+	return &wg.InterfaceStatus{}, nil
+}
+
+func newHealthTestService(t *testing.T, up bool, controllerURL string) *MonitoringService {
+	t.Helper()
+
+	agentConfig := &config.AgentConfig{
+		Node:       config.NodeConfig{ID: uuid.NewString()},
+		Monitoring: config.MonitoringConfig{MetricsPath: "/metrics"},
+	}
+	service := NewMonitoringService(agentConfig, nil, client.NewControllerClient(controllerURL))
+	service.wgManager = fakeInterface{up: up}
+	return service
+}
+
+func getHealth(t *testing.T, service *MonitoringService) (int, AgentHealth) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	service.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	var health AgentHealth
+	if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+		t.Fatalf("failed to decode health response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, health
+}
+
+func TestHealthEndpoint(t *testing.T) {
+	controller := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(types.APIResponse{Success: true, Data: types.HealthStatus{Status: "healthy"}})
+	}))
+	defer controller.Close()
+
+	code, health := getHealth(t, newHealthTestService(t, true, controller.URL))
+	if code != http.StatusOK || health.Status != "healthy" {
+		t.Errorf("expected 200 healthy, got %d %+v", code, health)
+	}
+	if health.Interface != "up" || health.Controller != "reachable" {
+		t.Errorf("expected the interface up and controller reachable, got %+v", health)
+	}
+
+	// A down interface makes the node unhealthy
+	code, health = getHealth(t, newHealthTestService(t, false, controller.URL))
+	if code != http.StatusServiceUnavailable || health.Status != "unhealthy" || health.Interface != "down" {
+		t.Errorf("expected 503 with the interface down, got %d %+v", code, health)
+	}
+
+	// So does a controller that can't be reached
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	code, health = getHealth(t, newHealthTestService(t, true, unreachable.URL))
+	if code != http.StatusServiceUnavailable || health.Controller != "unreachable" {
+		t.Errorf("expected 503 with the controller unreachable, got %d %+v", code, health)
+	}
+	if len(health.Errors) == 0 {
+		t.Error("expected the controller error to be reported")
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	service := newHealthTestService(t, true, "http://127.0.0.1:0")
+	handler := service.HealthHandler()
+
+	// Nothing to report before the first collection
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before the first sample, got %d", rec.Code)
+	}
+
+	collected := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	service.setLatestMetrics(&NodeMetrics{
+		NodeID: service.nodeID,
+		SystemMetrics: SystemMetrics{
+			CPUUsage:  42.5,
+			NetworkRx: 1024,
+		},
+		WGMetrics: WireGuardMetrics{
+			Status:        "up",
+			Peers:         3,
+			LastHandshake: collected,
+		},
+		Errors:    []string{"disk metrics error"},
+		Timestamp: collected,
+	})
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("expected the Prometheus text format, got %q", ct)
+	}
+
+	body, _ := io.ReadAll(rec.Body)
+	labels := `{node_id="` + service.nodeID.String() + `"}`
+	for _, line := range []string{
+		"# TYPE wg_sdwan_agent_cpu_usage_percent gauge",
+		"wg_sdwan_agent_cpu_usage_percent" + labels + " 42.5",
+		"# TYPE wg_sdwan_agent_network_receive_bytes_total counter",
+		"wg_sdwan_agent_network_receive_bytes_total" + labels + " 1024",
+		"wg_sdwan_agent_wireguard_up" + labels + " 1",
+		"wg_sdwan_agent_wireguard_peers" + labels + " 3",
+		"wg_sdwan_agent_wireguard_last_handshake_timestamp_seconds" + labels + " 1.7145648e+09",
+		"wg_sdwan_agent_collection_errors" + labels + " 1",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("expected %q in metrics, got:\n%s", line, body)
+		}
+	}
+}
@@ -3,25 +3,39 @@ package services
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"runtime"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/mem"
+	psnet "github.com/shirou/gopsutil/v3/net"
 	"github.com/wg-hubspoke/wg-hubspoke/agent/client"
 	"github.com/wg-hubspoke/wg-hubspoke/agent/config"
 	"github.com/wg-hubspoke/wg-hubspoke/agent/wg"
 )
 
+// wgStatusSource is the part of wg.Manager the monitoring service uses.
+type wgStatusSource interface {
+	IsInterfaceUp() (bool, error)
+	GetInterfaceStatus() (*wg.InterfaceStatus, error)
+}
+
 type MonitoringService struct {
 	config           *config.AgentConfig
-	wgManager        *wg.Manager
+	wgManager        wgStatusSource
 	controllerClient *client.ControllerClient
 	nodeID           uuid.UUID
+
+	mu     sync.RWMutex
+	latest *NodeMetrics // last sample from StartPeriodicCollection
 }
 
 type SystemMetrics struct {
@@ -94,28 +108,28 @@ func (s *MonitoringService) collectSystemMetrics(ctx context.Context) (*SystemMe
 	}
 
 	// CPU usage
-	cpuUsage, err := s.getCPUUsage()
+	cpuUsage, err := s.getCPUUsage(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get CPU usage: %w", err)
 	}
 	metrics.CPUUsage = cpuUsage
 
 	// Memory usage
-	memUsage, err := s.getMemoryUsage()
+	memUsage, err := s.getMemoryUsage(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get memory usage: %w", err)
 	}
 	metrics.MemoryUsage = memUsage
 
 	// Disk usage
-	diskUsage, err := s.getDiskUsage()
+	diskUsage, err := s.getDiskUsage(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get disk usage: %w", err)
 	}
 	metrics.DiskUsage = diskUsage
 
 	// Network usage
-	networkRx, networkTx, err := s.getNetworkUsage()
+	networkRx, networkTx, err := s.getNetworkUsage(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get network usage: %w", err)
 	}
@@ -185,141 +199,69 @@ func (s *MonitoringService) collectWireGuardMetrics(ctx context.Context) (*WireG
 	return metrics, nil
 }
 
-func (s *MonitoringService) getCPUUsage() (float64, error) {
-	if runtime.GOOS == "linux" {
-		return s.getLinuxCPUUsage()
-	}
-	return 0, fmt.Errorf("CPU usage not supported on %s", runtime.GOOS)
-}
-
-func (s *MonitoringService) getLinuxCPUUsage() (float64, error) {
-	// Read /proc/stat for CPU usage
-	cmd := exec.Command("top", "-bn1")
-	output, err := cmd.Output()
+func (s *MonitoringService) getCPUUsage(ctx context.Context) (float64, error) {
+	// A zero interval measures against the previous call, so the first
+	// sample covers the time since the agent started
+	percents, err := cpu.PercentWithContext(ctx, 0, false)
 	if err != nil {
-		return 0, fmt.Errorf("failed to execute top command: %w", err)
+		return 0, err
 	}
-
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "Cpu(s):") {
-			// Parse CPU usage from top output
-			parts := strings.Fields(line)
-			for i, part := range parts {
-				if strings.Contains(part, "us,") {
-					cpuStr := strings.TrimSuffix(part, "%us,")
-					if cpu, err := strconv.ParseFloat(cpuStr, 64); err == nil {
-						return cpu, nil
-					}
-				}
-			}
-		}
+	if len(percents) == 0 {
+		return 0, fmt.Errorf("no CPU statistics available")
 	}
-
-	return 0, fmt.Errorf("failed to parse CPU usage")
+	return percents[0], nil
 }
 
-func (s *MonitoringService) getMemoryUsage() (float64, error) {
-	if runtime.GOOS == "linux" {
-		return s.getLinuxMemoryUsage()
-	}
-	return 0, fmt.Errorf("memory usage not supported on %s", runtime.GOOS)
-}
-
-func (s *MonitoringService) getLinuxMemoryUsage() (float64, error) {
-	// Read /proc/meminfo
-	content, err := os.ReadFile("/proc/meminfo")
+func (s *MonitoringService) getMemoryUsage(ctx context.Context) (float64, error) {
+	vm, err := mem.VirtualMemoryWithContext(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("failed to read /proc/meminfo: %w", err)
-	}
-
-	lines := strings.Split(string(content), "\n")
-	var memTotal, memFree, memBuffers, memCached int64
-
-	for _, line := range lines {
-		fields := strings.Fields(line)
-		if len(fields) >= 2 {
-			switch fields[0] {
-			case "MemTotal:":
-				memTotal, _ = strconv.ParseInt(fields[1], 10, 64)
-			case "MemFree:":
-				memFree, _ = strconv.ParseInt(fields[1], 10, 64)
-			case "Buffers:":
-				memBuffers, _ = strconv.ParseInt(fields[1], 10, 64)
-			case "Cached:":
-				memCached, _ = strconv.ParseInt(fields[1], 10, 64)
-			}
-		}
-	}
-
-	memUsed := memTotal - memFree - memBuffers - memCached
-	if memTotal > 0 {
-		return float64(memUsed) / float64(memTotal) * 100, nil
+		return 0, err
 	}
-
-	return 0, fmt.Errorf("failed to calculate memory usage")
+	return vm.UsedPercent, nil
 }
 
-func (s *MonitoringService) getDiskUsage() (float64, error) {
-	if runtime.GOOS == "linux" {
-		return s.getLinuxDiskUsage()
+func (s *MonitoringService) getDiskUsage(ctx context.Context) (float64, error) {
+	usage, err := disk.UsageWithContext(ctx, rootPath())
+	if err != nil {
+		return 0, err
 	}
-	return 0, fmt.Errorf("disk usage not supported on %s", runtime.GOOS)
+	return usage.UsedPercent, nil
 }
 
-func (s *MonitoringService) getLinuxDiskUsage() (float64, error) {
-	var stat syscall.Statfs_t
-	if err := syscall.Statfs("/", &stat); err != nil {
-		return 0, fmt.Errorf("failed to get disk usage: %w", err)
-	}
-
-	total := stat.Blocks * uint64(stat.Bsize)
-	free := stat.Bavail * uint64(stat.Bsize)
-	used := total - free
-
-	if total > 0 {
-		return float64(used) / float64(total) * 100, nil
+// rootPath is the filesystem holding the agent's config and WireGuard state.
+func rootPath() string {
+	if runtime.GOOS == "windows" {
+		if drive := os.Getenv("SystemDrive"); drive != "" {
+			return drive + `\`
+		}
+		return `C:\`
 	}
-
-	return 0, nil
+	return "/"
 }
 
-func (s *MonitoringService) getNetworkUsage() (int64, int64, error) {
-	if runtime.GOOS == "linux" {
-		return s.getLinuxNetworkUsage()
+func (s *MonitoringService) getNetworkUsage(ctx context.Context) (int64, int64, error) {
+	counters, err := psnet.IOCountersWithContext(ctx, true)
+	if err != nil {
+		return 0, 0, err
 	}
-	return 0, 0, fmt.Errorf("network usage not supported on %s", runtime.GOOS)
-}
 
-func (s *MonitoringService) getLinuxNetworkUsage() (int64, int64, error) {
-	// Read /proc/net/dev
-	content, err := os.ReadFile("/proc/net/dev")
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to read /proc/net/dev: %w", err)
+	// Skip loopback interfaces, whose names differ per OS
+	loopback := make(map[string]bool)
+	if ifaces, err := net.Interfaces(); err == nil {
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagLoopback != 0 {
+				loopback[iface.Name] = true
+			}
+		}
 	}
 
-	lines := strings.Split(string(content), "\n")
 	var totalRx, totalTx int64
-
-	for _, line := range lines {
-		if strings.Contains(line, ":") {
-			parts := strings.Split(line, ":")
-			if len(parts) == 2 {
-				interfaceName := strings.TrimSpace(parts[0])
-				// Skip loopback interface
-				if interfaceName == "lo" {
-					continue
-				}
-
-				fields := strings.Fields(parts[1])
-				if len(fields) >= 9 {
-					rx, _ := strconv.ParseInt(fields[0], 10, 64)
-					tx, _ := strconv.ParseInt(fields[8], 10, 64)
-					totalRx += rx
-					totalTx += tx
-				}
-			}
+	for _, counter := range counters {
+		if loopback[counter.Name] {
+			continue
 		}
+		totalRx += int64(counter.BytesRecv)
+		totalTx += int64(counter.BytesSent)
 	}
 
 	return totalRx, totalTx, nil
@@ -395,7 +337,13 @@ func (s *MonitoringService) measurePacketLoss(ip string) float64 {
 	return 0
 }
 
+// SendMetrics posts a sample to the controller in the shape its
+// /monitoring/nodes/:node_id/metrics endpoint parses.
 func (s *MonitoringService) SendMetrics(ctx context.Context, metrics *NodeMetrics) error {
+	if s.nodeID == uuid.Nil {
+		return fmt.Errorf("node ID not set")
+	}
+
 	// Convert metrics to map for API call
 	metricsMap := map[string]interface{}{
 		"cpu_usage":         metrics.SystemMetrics.CPUUsage,
@@ -414,12 +362,9 @@ func (s *MonitoringService) SendMetrics(ctx context.Context, metrics *NodeMetric
 		"timestamp":         metrics.Timestamp,
 	}
 
-	// Send metrics to controller
-	url := fmt.Sprintf("%s/monitoring/nodes/%s/metrics", s.config.Controller.URL, s.nodeID.String())
-	
-	// This would be implemented in the controller client
-	// For now, we'll just log the metrics
-	fmt.Printf("Sending metrics to controller: %+v\n", metricsMap)
+	if err := s.controllerClient.SendMetrics(ctx, s.nodeID.String(), metricsMap); err != nil {
+		return fmt.Errorf("failed to send metrics to controller: %w", err)
+	}
 
 	return nil
 }
@@ -438,6 +383,7 @@ func (s *MonitoringService) StartPeriodicCollection(ctx context.Context) {
 				fmt.Printf("Failed to collect metrics: %v\n", err)
 				continue
 			}
+			s.setLatestMetrics(metrics)
 
 			if err := s.SendMetrics(ctx, metrics); err != nil {
 				fmt.Printf("Failed to send metrics: %v\n", err)
@@ -450,6 +396,20 @@ func (s *MonitoringService) GetCurrentMetrics(ctx context.Context) (*NodeMetrics
 	return s.CollectMetrics(ctx)
 }
 
+// LatestMetrics returns the last sample collected by StartPeriodicCollection,
+// or nil before the first one.
+func (s *MonitoringService) LatestMetrics() *NodeMetrics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latest
+}
+
+func (s *MonitoringService) setLatestMetrics(metrics *NodeMetrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest = metrics
+}
+
 func (s *MonitoringService) GetSystemInfo() map[string]interface{} {
 	return map[string]interface{}{
 		"os":           runtime.GOOS,
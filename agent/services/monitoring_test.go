@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/agent/client"
+	"github.com/wg-hubspoke/wg-hubspoke/agent/config"
+	"github.com/wg-hubspoke/wg-hubspoke/agent/wg"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+func TestCollectSystemMetrics(t *testing.T) {
+	// With no PATH any shelled-out command would fail the collection
+	t.Setenv("PATH", "")
+
+	wgManager, err := wg.NewManager("wg-metrics-test")
+	if err != nil {
+		t.Skipf("WireGuard client unavailable: %v", err)
+	}
+	defer wgManager.Close()
+
+	service := NewMonitoringService(&config.AgentConfig{Node: config.NodeConfig{ID: uuid.NewString()}}, wgManager, nil)
+	metrics, err := service.CollectMetrics(context.Background())
+	if err != nil {
+		t.Fatalf("CollectMetrics failed: %v", err)
+	}
+
+	for _, e := range metrics.Errors {
+		if strings.HasPrefix(e, "System metrics error") {
+			t.Fatalf("expected system metrics on %s, got %s", runtime.GOOS, e)
+		}
+	}
+
+	system := metrics.SystemMetrics
+	for name, percent := range map[string]float64{
+		"cpu_usage":    system.CPUUsage,
+		"memory_usage": system.MemoryUsage,
+		"disk_usage":   system.DiskUsage,
+	} {
+		if percent < 0 || percent > 100 {
+			t.Errorf("expected %s between 0 and 100, got %v", name, percent)
+		}
+	}
+	if system.MemoryUsage == 0 || system.DiskUsage == 0 {
+		t.Errorf("expected memory and disk usage to be populated, got %+v", system)
+	}
+	if system.NetworkRx < 0 || system.NetworkTx < 0 {
+		t.Errorf("expected non-negative network counters, got rx=%d tx=%d", system.NetworkRx, system.NetworkTx)
+	}
+	if system.Timestamp.IsZero() {
+		t.Error("expected the sample to be timestamped")
+	}
+}
+
+func TestSendMetricsReachesController(t *testing.T) {
+	nodeID := uuid.New()
+
+	var gotPath, gotAuth string
+	var gotBody map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		json.NewEncoder(w).Encode(types.APIResponse{Success: true})
+	}))
+	defer ts.Close()
+
+	controllerClient := client.NewControllerClient(ts.URL)
+	controllerClient.SetToken("agent-token")
+	service := NewMonitoringService(&config.AgentConfig{Node: config.NodeConfig{ID: nodeID.String()}}, nil, controllerClient)
+
+	handshake := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	metrics := &NodeMetrics{
+		NodeID: nodeID,
+		SystemMetrics: SystemMetrics{
+			CPUUsage:    42.5,
+			MemoryUsage: 61.25,
+			DiskUsage:   70,
+			NetworkRx:   1024,
+			NetworkTx:   2048,
+		},
+		WGMetrics: WireGuardMetrics{
+			Status:        "up",
+			Peers:         3,
+			LastHandshake: handshake,
+			Latency:       12.5,
+			PacketLoss:    0.5,
+		},
+		Errors:    []string{"disk metrics error"},
+		Timestamp: handshake,
+	}
+
+	if err := service.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("SendMetrics failed: %v", err)
+	}
+
+	if want := "/api/v1/monitoring/nodes/" + nodeID.String() + "/metrics"; gotPath != want {
+		t.Errorf("expected metrics on %s, got %s", want, gotPath)
+	}
+	if gotAuth != "Bearer agent-token" {
+		t.Errorf("expected the agent token, got %q", gotAuth)
+	}
+
+	// Keys the controller's metrics handler reads
+	want := map[string]interface{}{
+		"cpu_usage":         42.5,
+		"memory_usage":      61.25,
+		"disk_usage":        float64(70),
+		"network_rx":        float64(1024),
+		"network_tx":        float64(2048),
+		"wg_status":         "up",
+		"wg_peers":          float64(3),
+		"wg_last_handshake": handshake.Format(time.RFC3339),
+		"latency_ms":        12.5,
+		"packet_loss":       0.5,
+	}
+	for key, value := range want {
+		if gotBody[key] != value {
+			t.Errorf("expected %s = %v, got %v", key, value, gotBody[key])
+		}
+	}
+	if errs, ok := gotBody["errors"].([]interface{}); !ok || len(errs) != 1 || errs[0] != "disk metrics error" {
+		t.Errorf("expected the collection errors to be reported, got %v", gotBody["errors"])
+	}
+}
+
+func TestSendMetricsWithoutNodeID(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+
+	service := NewMonitoringService(&config.AgentConfig{}, nil, client.NewControllerClient(ts.URL))
+	if err := service.SendMetrics(context.Background(), &NodeMetrics{}); err == nil {
+		t.Error("expected an error before the node is registered")
+	}
+	if requests != 0 {
+		t.Errorf("expected nothing to be sent, got %d requests", requests)
+	}
+}
+
+func TestSendMetricsControllerError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(types.APIResponse{Success: false, Error: "Insufficient permissions"})
+	}))
+	defer ts.Close()
+
+	service := NewMonitoringService(&config.AgentConfig{Node: config.NodeConfig{ID: uuid.NewString()}}, nil, client.NewControllerClient(ts.URL))
+	if err := service.SendMetrics(context.Background(), &NodeMetrics{}); err == nil {
+		t.Error("expected the rejected request to be reported")
+	}
+}
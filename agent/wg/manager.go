@@ -2,18 +2,49 @@ package wg
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os/exec"
 	"strings"
 	"time"
 
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
 	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
+var (
+	ErrHandshakeTimeout = errors.New("no peer handshake within timeout")
+)
+
+// handshakePollInterval is how often WaitForHandshake checks peer state.
+const handshakePollInterval = 500 * time.Millisecond
+
+// RejectAfterTime is how long a WireGuard session stays usable after the
+// handshake that established it.
+const RejectAfterTime = 180 * time.Second
+
+// wgClient is the part of wgctrl.Client the manager uses.
+type wgClient interface {
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+	Close() error
+}
+
+// interfaceStatusSource reports the current state of a WireGuard interface.
+type interfaceStatusSource interface {
+	GetInterfaceStatus() (*InterfaceStatus, error)
+}
+
 type Manager struct {
-	client    *wgctrl.Client
-	interface string
+	client        wgClient
+	interfaceName string
+	// runCommand runs wg-quick(8) and ip(8); replaced in tests
+	runCommand func(cmd *exec.Cmd) ([]byte, error)
+	// interfaceAddrs lists the addresses on the interface; replaced in tests
+	interfaceAddrs func(name string) ([]string, error)
+	routes         map[string]bool // routes installed by SyncRoutes
 }
 
 type InterfaceStatus struct {
@@ -42,8 +73,11 @@ func NewManager(interfaceName string) (*Manager, error) {
 	}
 
 	return &Manager{
-		client:    client,
-		interface: interfaceName,
+		client:         client,
+		interfaceName:  interfaceName,
+		runCommand:     (*exec.Cmd).CombinedOutput,
+		interfaceAddrs: interfaceAddresses,
+		routes:         make(map[string]bool),
 	}, nil
 }
 
@@ -52,7 +86,7 @@ func (m *Manager) Close() error {
 }
 
 func (m *Manager) IsInterfaceUp() (bool, error) {
-	_, err := m.client.Device(m.interface)
+	_, err := m.client.Device(m.interfaceName)
 	if err != nil {
 		if strings.Contains(err.Error(), "no such device") {
 			return false, nil
@@ -63,7 +97,7 @@ func (m *Manager) IsInterfaceUp() (bool, error) {
 }
 
 func (m *Manager) GetInterfaceStatus() (*InterfaceStatus, error) {
-	device, err := m.client.Device(m.interface)
+	device, err := m.client.Device(m.interfaceName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get device info: %w", err)
 	}
@@ -103,11 +137,11 @@ func (m *Manager) GetInterfaceStatus() (*InterfaceStatus, error) {
 }
 
 func (m *Manager) ApplyConfig(ctx context.Context, configPath string) error {
-	cmd := exec.CommandContext(ctx, "wg-quick", "down", m.interface)
-	cmd.Run() // Ignore errors, interface might not be up
+	cmd := exec.CommandContext(ctx, "wg-quick", "down", m.interfaceName)
+	m.runCommand(cmd) // Ignore errors, interface might not be up
 
 	cmd = exec.CommandContext(ctx, "wg-quick", "up", configPath)
-	if err := cmd.Run(); err != nil {
+	if _, err := m.runCommand(cmd); err != nil {
 		return fmt.Errorf("failed to apply WireGuard config: %w", err)
 	}
 
@@ -115,20 +149,24 @@ func (m *Manager) ApplyConfig(ctx context.Context, configPath string) error {
 }
 
 func (m *Manager) StopInterface(ctx context.Context) error {
-	cmd := exec.CommandContext(ctx, "wg-quick", "down", m.interface)
-	if err := cmd.Run(); err != nil {
+	cmd := exec.CommandContext(ctx, "wg-quick", "down", m.interfaceName)
+	if _, err := m.runCommand(cmd); err != nil {
 		return fmt.Errorf("failed to stop WireGuard interface: %w", err)
 	}
 
 	return nil
 }
 
-func (m *Manager) RestartInterface(ctx context.Context, configPath string) error {
+func (m *Manager) RestartInterface(ctx context.Context, configPath string, delay time.Duration) error {
 	if err := m.StopInterface(ctx); err != nil {
 		return fmt.Errorf("failed to stop interface: %w", err)
 	}
 
-	time.Sleep(1 * time.Second)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+	}
 
 	if err := m.ApplyConfig(ctx, configPath); err != nil {
 		return fmt.Errorf("failed to start interface: %w", err)
@@ -137,35 +175,342 @@ func (m *Manager) RestartInterface(ctx context.Context, configPath string) error
 	return nil
 }
 
+// Reload brings the running interface in line with config. Peers are added,
+// removed and updated in place so established sessions survive; the
+// interface is only restarted from configPath when its addresses or listen
+// port changed. It reports whether the interface was restarted and the peers
+// that could not be applied.
+func (m *Manager) Reload(ctx context.Context, configPath string, config *types.NodeConfigResponse, restartDelay time.Duration) (bool, []types.PeerApplyError, error) {
+	device, err := m.client.Device(m.interfaceName)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to get device info: %w", err)
+	}
+
+	changed, err := m.interfaceChanged(device, config.Interface)
+	if err != nil {
+		return false, nil, err
+	}
+	if changed {
+		if err := m.RestartInterface(ctx, configPath, restartDelay); err != nil {
+			return true, nil, err
+		}
+		return true, nil, nil
+	}
+
+	// A rotated key is swapped in place; peers handshake again using it
+	if config.Interface.PrivateKey != "" {
+		key, err := wgtypes.ParseKey(config.Interface.PrivateKey)
+		if err != nil {
+			return false, nil, fmt.Errorf("invalid private key: %w", err)
+		}
+		if key != device.PrivateKey {
+			if err := m.client.ConfigureDevice(m.interfaceName, wgtypes.Config{PrivateKey: &key}); err != nil {
+				return false, nil, fmt.Errorf("failed to update private key: %w", err)
+			}
+		}
+	}
+
+	updates, skipped := diffPeers(device.Peers, config.Peers)
+	for _, update := range updates {
+		if err := ctx.Err(); err != nil {
+			return false, skipped, err
+		}
+
+		cfg := wgtypes.Config{
+			Peers: []wgtypes.PeerConfig{update},
+		}
+		if err := m.client.ConfigureDevice(m.interfaceName, cfg); err != nil {
+			skipped = append(skipped, types.PeerApplyError{
+				PublicKey: update.PublicKey.String(),
+				Error:     fmt.Sprintf("failed to configure peer: %v", err),
+			})
+		}
+	}
+
+	return false, skipped, nil
+}
+
+// interfaceChanged reports whether the device's listen port or addresses
+// differ from the interface config, which can't be changed in place.
+func (m *Manager) interfaceChanged(device *wgtypes.Device, iface types.WGInterface) (bool, error) {
+	// Without a configured port the kernel picks one
+	if iface.ListenPort > 0 && device.ListenPort != iface.ListenPort {
+		return true, nil
+	}
+
+	current, err := m.interfaceAddrs(m.interfaceName)
+	if err != nil {
+		return false, fmt.Errorf("failed to get interface addresses: %w", err)
+	}
+
+	wanted := make(map[string]bool)
+	for _, addr := range iface.Address {
+		ip, ipNet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return false, fmt.Errorf("invalid interface address %q: %w", addr, err)
+		}
+		wanted[(&net.IPNet{IP: ip, Mask: ipNet.Mask}).String()] = true
+	}
+	if len(current) != len(wanted) {
+		return true, nil
+	}
+	for _, addr := range current {
+		if !wanted[addr] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// interfaceAddresses lists the addresses on the named interface in CIDR
+// form, leaving out the link-local ones the kernel adds itself.
+func interfaceAddresses(name string) ([]string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		result = append(result, ipNet.String())
+	}
+
+	return result, nil
+}
+
+// diffPeers returns the peer configs that bring the device's peers in line
+// with the wanted ones. Peers that are already up to date are left out so
+// their sessions aren't touched, and peers that can't be parsed are skipped.
+func diffPeers(current []wgtypes.Peer, wanted []types.WGPeer) ([]wgtypes.PeerConfig, []types.PeerApplyError) {
+	existing := make(map[wgtypes.Key]wgtypes.Peer, len(current))
+	for _, peer := range current {
+		existing[peer.PublicKey] = peer
+	}
+
+	updates := make([]wgtypes.PeerConfig, 0)
+	skipped := make([]types.PeerApplyError, 0)
+	keep := make(map[wgtypes.Key]bool, len(wanted))
+
+	for _, peer := range wanted {
+		peerConfig, err := buildPeerConfig(peer)
+		if err != nil {
+			skipped = append(skipped, types.PeerApplyError{
+				PublicKey: peer.PublicKey,
+				Error:     err.Error(),
+			})
+			continue
+		}
+		keep[peerConfig.PublicKey] = true
+
+		running, ok := existing[peerConfig.PublicKey]
+		if !ok {
+			updates = append(updates, peerConfig)
+			continue
+		}
+
+		// Leave a roamed endpoint alone unless the controller moved the peer
+		if peerConfig.Endpoint != nil && running.Endpoint != nil && peerConfig.Endpoint.String() == running.Endpoint.String() {
+			peerConfig.Endpoint = nil
+		}
+		if peerChanged(running, peerConfig) {
+			updates = append(updates, peerConfig)
+		}
+	}
+
+	for _, peer := range current {
+		if !keep[peer.PublicKey] {
+			updates = append(updates, wgtypes.PeerConfig{PublicKey: peer.PublicKey, Remove: true})
+		}
+	}
+
+	return updates, skipped
+}
+
+func peerChanged(running wgtypes.Peer, peerConfig wgtypes.PeerConfig) bool {
+	if peerConfig.Endpoint != nil {
+		return true
+	}
+
+	var keepalive time.Duration
+	if peerConfig.PersistentKeepaliveInterval != nil {
+		keepalive = *peerConfig.PersistentKeepaliveInterval
+	}
+	if running.PersistentKeepaliveInterval != keepalive {
+		return true
+	}
+
+	if len(running.AllowedIPs) != len(peerConfig.AllowedIPs) {
+		return true
+	}
+	allowed := make(map[string]bool, len(running.AllowedIPs))
+	for _, ipNet := range running.AllowedIPs {
+		allowed[ipNet.String()] = true
+	}
+	for _, ipNet := range peerConfig.AllowedIPs {
+		if !allowed[ipNet.String()] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WaitForHandshake blocks until at least one peer completes a handshake after
+// since, returning ErrHandshakeTimeout if none does within timeout. An
+// interface without peers has nothing to connect to and succeeds immediately.
+func (m *Manager) WaitForHandshake(ctx context.Context, since time.Time, timeout time.Duration) error {
+	return waitForHandshake(ctx, m, since, timeout, handshakePollInterval)
+}
+
+func waitForHandshake(ctx context.Context, source interfaceStatusSource, since time.Time, timeout, interval time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		status, err := source.GetInterfaceStatus()
+		if err == nil {
+			if len(status.Peers) == 0 {
+				return nil
+			}
+			for _, peer := range status.Peers {
+				if peer.LastHandshakeTime.After(since) {
+					return nil
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return ErrHandshakeTimeout
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
 func (m *Manager) UpdatePeerEndpoint(ctx context.Context, publicKey, endpoint string) error {
 	pubKey, err := wgtypes.ParseKey(publicKey)
 	if err != nil {
 		return fmt.Errorf("invalid public key: %w", err)
 	}
 
-	udpAddr, err := wgtypes.ParseEndpoint(endpoint)
+	udpAddr, err := net.ResolveUDPAddr("udp", endpoint)
 	if err != nil {
 		return fmt.Errorf("invalid endpoint: %w", err)
 	}
 
 	peerConfig := wgtypes.PeerConfig{
-		PublicKey:    pubKey,
-		UpdateOnly:   true,
+		PublicKey:         pubKey,
+		UpdateOnly:        true,
 		ReplaceAllowedIPs: false,
-		Endpoint:     &udpAddr,
+		Endpoint:          udpAddr,
 	}
 
 	config := wgtypes.Config{
 		Peers: []wgtypes.PeerConfig{peerConfig},
 	}
 
-	if err := m.client.ConfigureDevice(m.interface, config); err != nil {
+	if err := m.client.ConfigureDevice(m.interfaceName, config); err != nil {
 		return fmt.Errorf("failed to update peer endpoint: %w", err)
 	}
 
 	return nil
 }
 
+func buildPeerConfig(peer types.WGPeer) (wgtypes.PeerConfig, error) {
+	pubKey, err := wgtypes.ParseKey(peer.PublicKey)
+	if err != nil {
+		return wgtypes.PeerConfig{}, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	peerAllowedIPs := peer.AllowedIPsWithRoutes()
+	allowedIPs := make([]net.IPNet, 0, len(peerAllowedIPs))
+	for _, cidr := range peerAllowedIPs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return wgtypes.PeerConfig{}, fmt.Errorf("invalid allowed IP %q: %w", cidr, err)
+		}
+		allowedIPs = append(allowedIPs, *ipNet)
+	}
+
+	peerConfig := wgtypes.PeerConfig{
+		PublicKey:         pubKey,
+		ReplaceAllowedIPs: true,
+		AllowedIPs:        allowedIPs,
+	}
+
+	if peer.Endpoint != "" {
+		udpAddr, err := net.ResolveUDPAddr("udp", peer.Endpoint)
+		if err != nil {
+			return wgtypes.PeerConfig{}, fmt.Errorf("invalid endpoint: %w", err)
+		}
+		peerConfig.Endpoint = udpAddr
+	}
+
+	if peer.PersistentKeepalive > 0 {
+		keepalive := time.Duration(peer.PersistentKeepalive) * time.Second
+		peerConfig.PersistentKeepaliveInterval = &keepalive
+	}
+
+	return peerConfig, nil
+}
+
+// SyncRoutes points a kernel route for each subnet at the WireGuard
+// interface and removes routes installed by an earlier sync that are no
+// longer wanted. wg-quick already routes the AllowedIPs it brings up, but
+// peers applied at runtime are not routed without this. Default routes are
+// left to wg-quick, which installs them with policy routing.
+func (m *Manager) SyncRoutes(ctx context.Context, routes []string) error {
+	wanted := make(map[string]bool)
+	for _, route := range routes {
+		_, ipNet, err := net.ParseCIDR(route)
+		if err != nil {
+			return fmt.Errorf("invalid route %q: %w", route, err)
+		}
+		if ones, _ := ipNet.Mask.Size(); ones == 0 {
+			continue
+		}
+		wanted[ipNet.String()] = true
+	}
+
+	var errs []error
+	for route := range wanted {
+		cmd := exec.CommandContext(ctx, "ip", "route", "replace", route, "dev", m.interfaceName)
+		if output, err := m.runCommand(cmd); err != nil {
+			errs = append(errs, fmt.Errorf("failed to add route %s: %w: %s", route, err, strings.TrimSpace(string(output))))
+			continue
+		}
+		m.routes[route] = true
+	}
+
+	for route := range m.routes {
+		if wanted[route] {
+			continue
+		}
+		// The route is already gone if the interface was restarted
+		cmd := exec.CommandContext(ctx, "ip", "route", "del", route, "dev", m.interfaceName)
+		m.runCommand(cmd)
+		delete(m.routes, route)
+	}
+
+	return errors.Join(errs...)
+}
+
 func (m *Manager) RemovePeer(ctx context.Context, publicKey string) error {
 	pubKey, err := wgtypes.ParseKey(publicKey)
 	if err != nil {
@@ -181,7 +526,7 @@ func (m *Manager) RemovePeer(ctx context.Context, publicKey string) error {
 		Peers: []wgtypes.PeerConfig{peerConfig},
 	}
 
-	if err := m.client.ConfigureDevice(m.interface, config); err != nil {
+	if err := m.client.ConfigureDevice(m.interfaceName, config); err != nil {
 		return fmt.Errorf("failed to remove peer: %w", err)
 	}
 
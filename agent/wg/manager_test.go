@@ -0,0 +1,422 @@
+package wg
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// fakeStatusSource reports a handshake for its peer once handshakeAfter polls
+// have been made; a negative value never reports one.
+type fakeStatusSource struct {
+	mu             sync.Mutex
+	polls          int
+	handshakeAfter int
+	err            error
+}
+
+func (f *fakeStatusSource) GetInterfaceStatus() (*InterfaceStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.polls++
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	peer := PeerStatus{PublicKey: "peer-1"}
+	if f.handshakeAfter >= 0 && f.polls > f.handshakeAfter {
+		peer.LastHandshakeTime = time.Now()
+	}
+	return &InterfaceStatus{Name: "wg0", Peers: []PeerStatus{peer}}, nil
+}
+
+func TestWaitForHandshakeSucceedsAfterHandshake(t *testing.T) {
+	source := &fakeStatusSource{handshakeAfter: 3}
+	since := time.Now().Add(-time.Millisecond)
+
+	if err := waitForHandshake(context.Background(), source, since, time.Second, time.Millisecond); err != nil {
+		t.Fatalf("expected success once a handshake is observed, got %v", err)
+	}
+	if source.polls != 4 {
+		t.Errorf("expected success on the 4th poll, got %d polls", source.polls)
+	}
+}
+
+func TestWaitForHandshakeTimesOut(t *testing.T) {
+	source := &fakeStatusSource{handshakeAfter: -1}
+
+	err := waitForHandshake(context.Background(), source, time.Now(), 50*time.Millisecond, 5*time.Millisecond)
+	if !errors.Is(err, ErrHandshakeTimeout) {
+		t.Fatalf("expected ErrHandshakeTimeout, got %v", err)
+	}
+	if source.polls < 2 {
+		t.Errorf("expected repeated polling before timing out, got %d polls", source.polls)
+	}
+}
+
+func TestWaitForHandshakeIgnoresStaleHandshake(t *testing.T) {
+	source := &fakeStatusSource{handshakeAfter: 0}
+
+	// Handshakes from before the apply started don't count
+	since := time.Now().Add(time.Hour)
+	err := waitForHandshake(context.Background(), source, since, 30*time.Millisecond, 5*time.Millisecond)
+	if !errors.Is(err, ErrHandshakeTimeout) {
+		t.Fatalf("expected ErrHandshakeTimeout for stale handshake, got %v", err)
+	}
+}
+
+func TestWaitForHandshakeRetriesStatusErrors(t *testing.T) {
+	source := &fakeStatusSource{err: errors.New("device busy")}
+
+	err := waitForHandshake(context.Background(), source, time.Now(), 30*time.Millisecond, 5*time.Millisecond)
+	if !errors.Is(err, ErrHandshakeTimeout) {
+		t.Fatalf("expected ErrHandshakeTimeout while status is unavailable, got %v", err)
+	}
+}
+
+func TestWaitForHandshakeNoPeers(t *testing.T) {
+	source := interfaceStatusFunc(func() (*InterfaceStatus, error) {
+		return &InterfaceStatus{Name: "wg0"}, nil
+	})
+
+	if err := waitForHandshake(context.Background(), source, time.Now(), time.Second, time.Millisecond); err != nil {
+		t.Fatalf("expected an interface without peers to succeed, got %v", err)
+	}
+}
+
+type interfaceStatusFunc func() (*InterfaceStatus, error)
+
+func (f interfaceStatusFunc) GetInterfaceStatus() (*InterfaceStatus, error) {
+	return f()
+}
+
+func TestBuildPeerConfigIncludesRoutes(t *testing.T) {
+	peer := types.WGPeer{
+		PublicKey:  "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+		AllowedIPs: []string{"10.100.1.2/32"},
+		Routes:     []string{"192.168.10.0/24"},
+	}
+
+	config, err := buildPeerConfig(peer)
+	if err != nil {
+		t.Fatalf("buildPeerConfig failed: %v", err)
+	}
+
+	var allowed []string
+	for _, ipNet := range config.AllowedIPs {
+		allowed = append(allowed, ipNet.String())
+	}
+	if len(allowed) != 2 || allowed[0] != "10.100.1.2/32" || allowed[1] != "192.168.10.0/24" {
+		t.Errorf("expected the route to be added to AllowedIPs, got %v", allowed)
+	}
+
+	peer.Routes = []string{"not-a-cidr"}
+	if _, err := buildPeerConfig(peer); err == nil {
+		t.Error("expected an invalid route to be rejected")
+	}
+}
+
+func newRouteTestManager(fail map[string]bool) (*Manager, *[]string) {
+	var commands []string
+	return &Manager{
+		interfaceName: "wg0",
+		routes:        make(map[string]bool),
+		runCommand: func(cmd *exec.Cmd) ([]byte, error) {
+			command := strings.Join(cmd.Args, " ")
+			commands = append(commands, command)
+			if fail[command] {
+				return []byte("RTNETLINK answers: File exists"), errors.New("exit status 2")
+			}
+			return nil, nil
+		},
+	}, &commands
+}
+
+func TestSyncRoutes(t *testing.T) {
+	manager, commands := newRouteTestManager(nil)
+	ctx := context.Background()
+
+	// Host bits are masked off and default routes are left to wg-quick
+	if err := manager.SyncRoutes(ctx, []string{"192.168.10.1/24", "192.168.11.0/24", "0.0.0.0/0"}); err != nil {
+		t.Fatalf("SyncRoutes failed: %v", err)
+	}
+	sort.Strings(*commands)
+	want := []string{
+		"ip route replace 192.168.10.0/24 dev wg0",
+		"ip route replace 192.168.11.0/24 dev wg0",
+	}
+	if strings.Join(*commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected commands:\n%s", strings.Join(*commands, "\n"))
+	}
+
+	// Routes dropped from the config are removed
+	*commands = nil
+	if err := manager.SyncRoutes(ctx, []string{"192.168.11.0/24"}); err != nil {
+		t.Fatalf("SyncRoutes failed: %v", err)
+	}
+	sort.Strings(*commands)
+	want = []string{
+		"ip route del 192.168.10.0/24 dev wg0",
+		"ip route replace 192.168.11.0/24 dev wg0",
+	}
+	if strings.Join(*commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected commands:\n%s", strings.Join(*commands, "\n"))
+	}
+
+	if err := manager.SyncRoutes(ctx, []string{"192.168.11.0"}); err == nil {
+		t.Error("expected an invalid route to be rejected")
+	}
+}
+
+func TestSyncRoutesReportsFailures(t *testing.T) {
+	manager, _ := newRouteTestManager(map[string]bool{"ip route replace 192.168.10.0/24 dev wg0": true})
+
+	err := manager.SyncRoutes(context.Background(), []string{"192.168.10.0/24", "192.168.11.0/24"})
+	if err == nil || !strings.Contains(err.Error(), "192.168.10.0/24") {
+		t.Fatalf("expected the failed route to be reported, got %v", err)
+	}
+	if manager.routes["192.168.10.0/24"] || !manager.routes["192.168.11.0/24"] {
+		t.Errorf("expected only the installed route to be tracked, got %v", manager.routes)
+	}
+}
+
+// fakeDevice stands in for wgctrl, recording the peer changes made.
+type fakeDevice struct {
+	device     *wgtypes.Device
+	configured []wgtypes.PeerConfig
+	privateKey *wgtypes.Key
+	failKey    string
+}
+
+func (f *fakeDevice) Device(name string) (*wgtypes.Device, error) {
+	return f.device, nil
+}
+
+func (f *fakeDevice) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	if cfg.PrivateKey != nil {
+		f.privateKey = cfg.PrivateKey
+	}
+	for _, peer := range cfg.Peers {
+		if peer.PublicKey.String() == f.failKey {
+			return errors.New("operation not permitted")
+		}
+	}
+	f.configured = append(f.configured, cfg.Peers...)
+	return nil
+}
+
+func (f *fakeDevice) Close() error {
+	return nil
+}
+
+func mustKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key.PublicKey()
+}
+
+func mustCIDR(t *testing.T, cidr string) net.IPNet {
+	t.Helper()
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("invalid CIDR %q: %v", cidr, err)
+	}
+	return *ipNet
+}
+
+// newReloadTestManager runs a hub on 10.100.0.1/16 port 51820 with the
+// given peers, recording any wg-quick commands.
+func newReloadTestManager(peers []wgtypes.Peer) (*Manager, *fakeDevice, *[]string) {
+	device := &fakeDevice{device: &wgtypes.Device{Name: "wg0", ListenPort: 51820, Peers: peers}}
+	manager, commands := newRouteTestManager(nil)
+	manager.client = device
+	manager.interfaceAddrs = func(name string) ([]string, error) {
+		return []string{"10.100.0.1/16"}, nil
+	}
+	return manager, device, commands
+}
+
+func hubConfig(peers ...types.WGPeer) *types.NodeConfigResponse {
+	return &types.NodeConfigResponse{
+		Interface: types.WGInterface{Address: []string{"10.100.0.1/16"}, ListenPort: 51820},
+		Peers:     peers,
+	}
+}
+
+func TestReloadAppliesPeerChangesInPlace(t *testing.T) {
+	unchanged, moved, removed, added := mustKey(t), mustKey(t), mustKey(t), mustKey(t)
+	keepalive := 25 * time.Second
+	manager, device, commands := newReloadTestManager([]wgtypes.Peer{
+		{
+			PublicKey:                   unchanged,
+			Endpoint:                    &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 51820},
+			AllowedIPs:                  []net.IPNet{mustCIDR(t, "10.100.1.2/32"), mustCIDR(t, "192.168.10.0/24")},
+			PersistentKeepaliveInterval: keepalive,
+		},
+		{
+			PublicKey:  moved,
+			Endpoint:   &net.UDPAddr{IP: net.ParseIP("198.51.100.2"), Port: 51820},
+			AllowedIPs: []net.IPNet{mustCIDR(t, "10.100.1.3/32")},
+		},
+		{PublicKey: removed, AllowedIPs: []net.IPNet{mustCIDR(t, "10.100.1.4/32")}},
+	})
+
+	config := hubConfig(
+		types.WGPeer{
+			PublicKey:           unchanged.String(),
+			Endpoint:            "198.51.100.1:51820",
+			AllowedIPs:          []string{"10.100.1.2/32"},
+			Routes:              []string{"192.168.10.0/24"},
+			PersistentKeepalive: 25,
+		},
+		types.WGPeer{PublicKey: moved.String(), Endpoint: "198.51.100.20:51820", AllowedIPs: []string{"10.100.1.3/32"}},
+		types.WGPeer{PublicKey: added.String(), AllowedIPs: []string{"10.100.1.5/32"}},
+		types.WGPeer{PublicKey: "not-a-key", AllowedIPs: []string{"10.100.1.6/32"}},
+	)
+
+	restarted, skipped, err := manager.Reload(context.Background(), "/etc/wireguard/wg0.conf", config, 0)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if restarted || len(*commands) != 0 {
+		t.Fatalf("expected a peer change to be applied without a restart, ran %v", *commands)
+	}
+	if len(skipped) != 1 || skipped[0].PublicKey != "not-a-key" {
+		t.Errorf("expected the invalid peer to be skipped, got %+v", skipped)
+	}
+
+	changes := make(map[wgtypes.Key]wgtypes.PeerConfig)
+	for _, peer := range device.configured {
+		changes[peer.PublicKey] = peer
+	}
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 peer changes, got %+v", device.configured)
+	}
+	if _, ok := changes[unchanged]; ok {
+		t.Error("expected the unchanged peer to be left alone")
+	}
+	if peer := changes[moved]; peer.Endpoint == nil || peer.Endpoint.String() != "198.51.100.20:51820" {
+		t.Errorf("expected the moved peer's endpoint to be updated, got %+v", peer)
+	}
+	if peer := changes[removed]; !peer.Remove {
+		t.Errorf("expected the dropped peer to be removed, got %+v", peer)
+	}
+	if peer, ok := changes[added]; !ok || peer.Remove || len(peer.AllowedIPs) != 1 {
+		t.Errorf("expected the new peer to be added, got %+v", peer)
+	}
+}
+
+func TestReloadKeepsRoamedEndpoint(t *testing.T) {
+	spoke := mustKey(t)
+	manager, device, _ := newReloadTestManager([]wgtypes.Peer{
+		{
+			PublicKey:  spoke,
+			Endpoint:   &net.UDPAddr{IP: net.ParseIP("203.0.113.9"), Port: 40123},
+			AllowedIPs: []net.IPNet{mustCIDR(t, "10.100.1.2/32")},
+		},
+	})
+
+	// A spoke without a configured endpoint keeps the one it roamed to
+	config := hubConfig(types.WGPeer{PublicKey: spoke.String(), AllowedIPs: []string{"10.100.1.2/32", "192.168.10.0/24"}})
+	if _, _, err := manager.Reload(context.Background(), "/etc/wireguard/wg0.conf", config, 0); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if len(device.configured) != 1 || device.configured[0].Endpoint != nil {
+		t.Errorf("expected only the allowed IPs to be updated, got %+v", device.configured)
+	}
+}
+
+func TestReloadRestartsOnInterfaceChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*types.NodeConfigResponse)
+	}{
+		{"address", func(c *types.NodeConfigResponse) { c.Interface.Address = []string{"10.100.0.2/16"} }},
+		{"added address", func(c *types.NodeConfigResponse) {
+			c.Interface.Address = append(c.Interface.Address, "fd00:100::1/64")
+		}},
+		{"listen port", func(c *types.NodeConfigResponse) { c.Interface.ListenPort = 51821 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, device, commands := newReloadTestManager(nil)
+			config := hubConfig(types.WGPeer{PublicKey: mustKey(t).String(), AllowedIPs: []string{"10.100.1.2/32"}})
+			tt.modify(config)
+
+			restarted, _, err := manager.Reload(context.Background(), "/etc/wireguard/wg0.conf", config, 0)
+			if err != nil {
+				t.Fatalf("Reload failed: %v", err)
+			}
+			want := []string{"wg-quick down wg0", "wg-quick down wg0", "wg-quick up /etc/wireguard/wg0.conf"}
+			if !restarted || strings.Join(*commands, "\n") != strings.Join(want, "\n") {
+				t.Errorf("expected a down/up cycle, got restarted=%v commands=%v", restarted, *commands)
+			}
+			if len(device.configured) != 0 {
+				t.Errorf("expected peers to come from the restart, got %+v", device.configured)
+			}
+		})
+	}
+}
+
+func TestReloadReportsConfigureFailures(t *testing.T) {
+	peer := mustKey(t)
+	manager, device, _ := newReloadTestManager(nil)
+	device.failKey = peer.String()
+
+	config := hubConfig(types.WGPeer{PublicKey: peer.String(), AllowedIPs: []string{"10.100.1.2/32"}})
+	restarted, skipped, err := manager.Reload(context.Background(), "/etc/wireguard/wg0.conf", config, 0)
+	if err != nil || restarted {
+		t.Fatalf("expected the failure to be reported per peer, got restarted=%v err=%v", restarted, err)
+	}
+	if len(skipped) != 1 || skipped[0].PublicKey != peer.String() || !strings.Contains(skipped[0].Error, "operation not permitted") {
+		t.Errorf("expected the failed peer to be skipped, got %+v", skipped)
+	}
+}
+
+func TestReloadSwapsRotatedKeyInPlace(t *testing.T) {
+	manager, device, commands := newReloadTestManager(nil)
+	current, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	device.device.PrivateKey = current
+
+	config := hubConfig()
+	config.Interface.PrivateKey = current.String()
+	if _, _, err := manager.Reload(context.Background(), "/etc/wireguard/wg0.conf", config, 0); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if device.privateKey != nil {
+		t.Fatal("expected an unchanged key to be left alone")
+	}
+
+	rotated, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	config.Interface.PrivateKey = rotated.String()
+	restarted, _, err := manager.Reload(context.Background(), "/etc/wireguard/wg0.conf", config, 0)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if restarted || len(*commands) != 0 {
+		t.Errorf("expected the key to be swapped without a restart, ran %v", *commands)
+	}
+	if device.privateKey == nil || *device.privateKey != rotated {
+		t.Errorf("expected the rotated key to be configured, got %v", device.privateKey)
+	}
+}
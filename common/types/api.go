@@ -1,11 +1,15 @@
 package types
 
 import (
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+var ErrInvalidHook = errors.New("interface hooks must be single-line commands")
+
 type APIResponse struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
@@ -19,40 +23,126 @@ type PaginatedResponse struct {
 }
 
 type PaginationInfo struct {
-	Page       int   `json:"page"`
-	PerPage    int   `json:"per_page"`
-	Total      int64 `json:"total"`
-	TotalPages int   `json:"total_pages"`
+	Page       int    `json:"page"`
+	PerPage    int    `json:"per_page"`
+	Total      int64  `json:"total"`
+	TotalPages int    `json:"total_pages"`
+	Sort       string `json:"sort,omitempty"`
+	Order      string `json:"order,omitempty"`
+}
+
+// CursorPaginatedResponse is returned by listings paged with an opaque
+// cursor rather than a page number.
+type CursorPaginatedResponse struct {
+	APIResponse
+	Pagination CursorPaginationInfo `json:"pagination"`
+}
+
+type CursorPaginationInfo struct {
+	PerPage int `json:"per_page"`
+	// NextCursor fetches the following page; it is empty on the last one.
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
 }
 
 type NodeRegistrationRequest struct {
-	Name       string   `json:"name" binding:"required"`
-	NodeType   string   `json:"node_type" binding:"required,oneof=hub spoke"`
-	PublicKey  string   `json:"public_key" binding:"required"`
-	Endpoint   string   `json:"endpoint"`
-	Port       int      `json:"port"`
-	AllowedIPs []string `json:"allowed_ips"`
+	Name         string            `json:"name" binding:"required"`
+	Description  string            `json:"description"`
+	NodeType     string            `json:"node_type" binding:"required,oneof=hub spoke"`
+	PublicKey    string            `json:"public_key" binding:"required"`
+	Endpoint     string            `json:"endpoint"`
+	Port         int               `json:"port"`
+	AllowedIPs   []string          `json:"allowed_ips"`
+	Routes       []string          `json:"routes,omitempty"`
+	PinnedHubID  *uuid.UUID        `json:"pinned_hub_id,omitempty"`
+	BackupHubIDs []string          `json:"backup_hub_ids,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	// PersistentKeepalive overrides WG_PERSISTENT_KEEPALIVE for this node;
+	// 0 turns keepalives off.
+	PersistentKeepalive *int `json:"persistent_keepalive,omitempty"`
+	// Build of the registering agent, for tracking upgrades across the fleet
+	AgentVersion string `json:"agent_version,omitempty"`
+	BuildCommit  string `json:"build_commit,omitempty"`
+	InterfaceHooks
 }
 
 type NodeUpdateRequest struct {
-	Name       *string  `json:"name,omitempty"`
-	Endpoint   *string  `json:"endpoint,omitempty"`
-	Port       *int     `json:"port,omitempty"`
-	AllowedIPs []string `json:"allowed_ips,omitempty"`
-	Status     *string  `json:"status,omitempty"`
+	Name         *string    `json:"name,omitempty"`
+	Description  *string    `json:"description,omitempty"`
+	Endpoint     *string    `json:"endpoint,omitempty"`
+	Port         *int       `json:"port,omitempty"`
+	AllowedIPs   []string   `json:"allowed_ips,omitempty"`
+	Routes       []string   `json:"routes,omitempty"`
+	Status       *string    `json:"status,omitempty"`
+	PinnedHubID  *uuid.UUID `json:"pinned_hub_id,omitempty"`
+	BackupHubIDs []string   `json:"backup_hub_ids,omitempty"`
+	// Tags replaces the node's tags when set; an empty object clears them.
+	Tags                map[string]string `json:"tags,omitempty"`
+	PersistentKeepalive *int              `json:"persistent_keepalive,omitempty"`
+	InterfaceHooks
+}
+
+// NodePublicKeyRequest replaces a node's public key with one its agent
+// generated.
+type NodePublicKeyRequest struct {
+	PublicKey string `json:"public_key" binding:"required"`
+}
+
+// NodeStatusUpdateRequest moves several nodes to one status at once.
+type NodeStatusUpdateRequest struct {
+	NodeIDs []uuid.UUID `json:"node_ids" binding:"required,min=1"`
+	Status  string      `json:"status" binding:"required"`
 }
 
 type NodeConfigResponse struct {
 	Interface   WGInterface `json:"interface"`
 	Peers       []WGPeer    `json:"peers"`
 	GeneratedAt time.Time   `json:"generated_at"`
+	// Routes lists the subnets routed through the tunnel across all peers,
+	// for the agent to install as kernel routes.
+	Routes []string `json:"routes,omitempty"`
+	// RotateKey asks the agent to generate a new key pair and submit its
+	// public key.
+	RotateKey bool `json:"rotate_key,omitempty"`
 }
 
 type WGInterface struct {
-	PrivateKey string   `json:"private_key"`
+	// PrivateKey is filled in by the agent from its own key pair; the
+	// controller never has it.
+	PrivateKey string   `json:"private_key,omitempty"`
 	Address    []string `json:"address"`
 	ListenPort int      `json:"listen_port"`
 	MTU        int      `json:"mtu"`
+	DNS        []string `json:"dns,omitempty"` // resolver addresses and search domains
+	InterfaceHooks
+}
+
+// InterfaceHooks are commands wg-quick runs around bringing the interface up
+// and down, such as NAT rules on a hub.
+type InterfaceHooks struct {
+	PreUp    []string `json:"pre_up,omitempty"`
+	PostUp   []string `json:"post_up,omitempty"`
+	PreDown  []string `json:"pre_down,omitempty"`
+	PostDown []string `json:"post_down,omitempty"`
+}
+
+// IsSet reports whether any hook list is given, including an empty one that
+// clears the hooks.
+func (h InterfaceHooks) IsSet() bool {
+	return h.PreUp != nil || h.PostUp != nil || h.PreDown != nil || h.PostDown != nil
+}
+
+// Validate rejects hooks that span several lines, which would add directives
+// of their own to the generated config.
+func (h InterfaceHooks) Validate() error {
+	for _, hooks := range [][]string{h.PreUp, h.PostUp, h.PreDown, h.PostDown} {
+		for _, hook := range hooks {
+			if strings.ContainsAny(hook, "\r\n") {
+				return ErrInvalidHook
+			}
+		}
+	}
+	return nil
 }
 
 type WGPeer struct {
@@ -60,6 +150,35 @@ type WGPeer struct {
 	AllowedIPs          []string `json:"allowed_ips"`
 	Endpoint            string   `json:"endpoint,omitempty"`
 	PersistentKeepalive int      `json:"persistent_keepalive,omitempty"`
+	// Routes are LAN subnets reached through this peer, such as a branch
+	// network behind a spoke.
+	Routes []string `json:"routes,omitempty"`
+}
+
+// AllowedIPsWithRoutes returns the peer's AllowedIPs followed by its routed
+// subnets, so traffic for those subnets is accepted from the peer.
+func (p WGPeer) AllowedIPsWithRoutes() []string {
+	allowedIPs := make([]string, 0, len(p.AllowedIPs)+len(p.Routes))
+	seen := make(map[string]bool)
+	for _, cidr := range append(append([]string{}, p.AllowedIPs...), p.Routes...) {
+		if seen[cidr] {
+			continue
+		}
+		seen[cidr] = true
+		allowedIPs = append(allowedIPs, cidr)
+	}
+	return allowedIPs
+}
+
+type PeerApplyError struct {
+	PublicKey string `json:"public_key"`
+	Error     string `json:"error"`
+}
+
+type PeerApplyReport struct {
+	Applied    int              `json:"applied"`
+	Skipped    []PeerApplyError `json:"skipped"`
+	ReportedAt time.Time        `json:"reported_at"`
 }
 
 type PolicyRequest struct {
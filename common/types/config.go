@@ -3,14 +3,20 @@ package types
 import "time"
 
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Database DatabaseConfig `yaml:"database"`
-	Redis    RedisConfig    `yaml:"redis"`
-	Auth     AuthConfig     `yaml:"auth"`
-	WG       WGConfig       `yaml:"wireguard"`
-	Log      LogConfig      `yaml:"log"`
-	JWT      JWTConfig      `yaml:"jwt"`
-	HA       HAConfig       `yaml:"ha"`
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Redis     RedisConfig     `yaml:"redis"`
+	Auth      AuthConfig      `yaml:"auth"`
+	WG        WGConfig        `yaml:"wireguard"`
+	Log       LogConfig       `yaml:"log"`
+	JWT       JWTConfig       `yaml:"jwt"`
+	HA        HAConfig        `yaml:"ha"`
+	Alerting  AlertingConfig  `yaml:"alerting"`
+	Features  FeaturesConfig  `yaml:"features"`
+	Backup    BackupConfig    `yaml:"backup"`
+	Email     EmailConfig     `yaml:"email"`
+	Webhooks  WebhookConfig   `yaml:"webhooks"`
+	Retention RetentionConfig `yaml:"retention"`
 }
 
 type ServerConfig struct {
@@ -19,12 +25,39 @@ type ServerConfig struct {
 	ReadTimeout  time.Duration `yaml:"read_timeout"`
 	WriteTimeout time.Duration `yaml:"write_timeout"`
 	TLS          TLSConfig     `yaml:"tls"`
+	DevMode      bool          `yaml:"dev_mode" env:"DEVELOPMENT_MODE"`
+	PublicURL    string        `yaml:"public_url" env:"CONTROLLER_PUBLIC_URL"`
+	CORS         CORSConfig    `yaml:"cors"`
+}
+
+// CORSConfig controls which browser origins may call the API. Origins not
+// listed get no CORS headers, so browsers refuse them; "*" allows any
+// origin unless credentials are allowed.
+type CORSConfig struct {
+	AllowedOrigins   []string `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+	AllowedMethods   []string `yaml:"allowed_methods" env:"CORS_ALLOWED_METHODS"`
+	AllowedHeaders   []string `yaml:"allowed_headers" env:"CORS_ALLOWED_HEADERS"`
+	AllowCredentials bool     `yaml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
 }
 
 type TLSConfig struct {
 	Enabled  bool   `yaml:"enabled" env:"TLS_ENABLED"`
 	CertFile string `yaml:"cert_file" env:"TLS_CERT_FILE"`
 	KeyFile  string `yaml:"key_file" env:"TLS_KEY_FILE"`
+	// CAFile is the CA that issued the controllers' certificates. HA peers
+	// are called over HTTPS and verified against it.
+	CAFile string `yaml:"ca_file" env:"TLS_CA_FILE"`
+	// RedirectPort, when set, serves plain HTTP on this port that only
+	// redirects to HTTPS.
+	RedirectPort int `yaml:"redirect_port" env:"TLS_REDIRECT_PORT"`
+	// ClientCAFile lists the CAs that issue agent client certificates.
+	// When set, agents may authenticate with a certificate whose CN names
+	// their node; RequireClientCert makes that mandatory for agents.
+	ClientCAFile      string `yaml:"client_ca_file" env:"TLS_CLIENT_CA_FILE"`
+	RequireClientCert bool   `yaml:"require_client_cert" env:"TLS_REQUIRE_CLIENT_CERT"`
+	// CertExpiryWarningDays is how long before a certificate expires that
+	// an alert is raised for it.
+	CertExpiryWarningDays int `yaml:"cert_expiry_warning_days" env:"TLS_CERT_EXPIRY_WARNING_DAYS"`
 }
 
 type DatabaseConfig struct {
@@ -35,7 +68,11 @@ type DatabaseConfig struct {
 	Password     string `yaml:"password" env:"DB_PASSWORD"`
 	SSLMode      string `yaml:"ssl_mode" env:"DB_SSL_MODE"`
 	MaxConns     int    `yaml:"max_conns" env:"DB_MAX_CONNECTIONS"`
+	MaxIdleConns int    `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNECTIONS"`
 	MaxIdleTime  time.Duration `yaml:"max_idle_time" env:"DB_MAX_IDLE_TIME"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"` // connections older than this are closed once idle
+	ConnectRetries    int           `yaml:"connect_retries" env:"DB_CONNECT_RETRIES"` // attempts after the first before startup gives up
+	ConnectRetryDelay time.Duration `yaml:"connect_retry_delay" env:"DB_CONNECT_RETRY_DELAY"` // doubled after each failed attempt
 }
 
 type RedisConfig struct {
@@ -46,9 +83,42 @@ type RedisConfig struct {
 }
 
 type AuthConfig struct {
-	JWTSecret    string        `yaml:"jwt_secret" env:"JWT_SECRET"`
-	JWTExpiration time.Duration `yaml:"jwt_expiration" env:"JWT_EXPIRATION"`
-	BCryptCost   int           `yaml:"bcrypt_cost" env:"BCRYPT_COST"`
+	JWTSecret           string        `yaml:"jwt_secret" env:"JWT_SECRET"`
+	JWTExpiration       time.Duration `yaml:"jwt_expiration" env:"JWT_EXPIRATION"`
+	BCryptCost          int           `yaml:"bcrypt_cost" env:"BCRYPT_COST"`
+	JWTSecretMinLength  int           `yaml:"jwt_secret_min_length" env:"JWT_SECRET_MIN_LENGTH"`
+	NodeTokenExpiration time.Duration `yaml:"node_token_expiration" env:"NODE_TOKEN_EXPIRATION"`
+	// RefreshTokenExpiration is how long a refresh token issued at login
+	// stays valid; each refresh rotates it.
+	RefreshTokenExpiration time.Duration `yaml:"refresh_token_expiration" env:"REFRESH_TOKEN_EXPIRATION"`
+	// PasswordResetURL is the page that accepts a reset token, which is
+	// appended as the token query parameter.
+	PasswordResetURL        string        `yaml:"password_reset_url" env:"PASSWORD_RESET_URL"`
+	PasswordResetExpiration time.Duration `yaml:"password_reset_expiration" env:"PASSWORD_RESET_EXPIRATION"`
+	// RequireDualApproval makes destructive actions such as restores wait
+	// for a second admin's approval.
+	RequireDualApproval bool `yaml:"require_dual_approval" env:"REQUIRE_DUAL_APPROVAL"`
+	// AllowRegistration opens POST /auth/register to anyone; registered
+	// accounts always get the user role.
+	AllowRegistration bool       `yaml:"allow_registration" env:"ALLOW_REGISTRATION"`
+	LDAP              LDAPConfig `yaml:"ldap"`
+}
+
+// LDAPConfig lets users log in with directory credentials. The user is bound
+// as UserDNTemplate with the username in place of %s, and their groups decide
+// the role. Local accounts keep working alongside it.
+type LDAPConfig struct {
+	Enabled            bool          `yaml:"enabled" env:"LDAP_ENABLED"`
+	URL                string        `yaml:"url" env:"LDAP_URL"` // ldap:// or ldaps://
+	UserDNTemplate     string        `yaml:"user_dn_template" env:"LDAP_USER_DN_TEMPLATE"`
+	GroupAttribute     string        `yaml:"group_attribute" env:"LDAP_GROUP_ATTRIBUTE"`
+	EmailAttribute     string        `yaml:"email_attribute" env:"LDAP_EMAIL_ATTRIBUTE"`
+	AdminGroups        []string      `yaml:"admin_groups" env:"LDAP_ADMIN_GROUPS"`
+	OperatorGroups     []string      `yaml:"operator_groups" env:"LDAP_OPERATOR_GROUPS"`
+	UserGroups         []string      `yaml:"user_groups" env:"LDAP_USER_GROUPS"`
+	ObserverGroups     []string      `yaml:"observer_groups" env:"LDAP_OBSERVER_GROUPS"`
+	Timeout            time.Duration `yaml:"timeout" env:"LDAP_TIMEOUT"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify" env:"LDAP_INSECURE_SKIP_VERIFY"`
 }
 
 type WGConfig struct {
@@ -59,6 +129,18 @@ type WGConfig struct {
 	PersistentKeepalive int `yaml:"persistent_keepalive" env:"WG_PERSISTENT_KEEPALIVE"`
 	MTU              int    `yaml:"mtu" env:"WG_MTU"`
 	ConfigPath       string `yaml:"config_path" env:"WG_CONFIG_PATH"`
+	RepairDuplicateIPs bool `yaml:"repair_duplicate_ips" env:"WG_REPAIR_DUPLICATE_IPS"`
+	KeyRotationInterval time.Duration `yaml:"key_rotation_interval" env:"WG_KEY_ROTATION_DAYS"` // 0 disables scheduled rotation
+	HeartbeatTimeout time.Duration `yaml:"heartbeat_timeout" env:"WG_HEARTBEAT_TIMEOUT"` // active nodes silent this long are marked inactive; 0 disables
+	DNS              []string `yaml:"dns" env:"WG_DNS"` // pushed to nodes as the tunnel's resolvers and search domains
+	MinAgentVersion  string `yaml:"min_agent_version" env:"WG_MIN_AGENT_VERSION"` // older agents are flagged in topology health
+}
+
+// RetentionConfig sets how many days of history the HA leader keeps before
+// pruning it. 0 keeps it forever.
+type RetentionConfig struct {
+	AuditLogDays int `yaml:"audit_log_days" env:"AUDIT_RETENTION_DAYS"`
+	MetricsDays  int `yaml:"metrics_days" env:"METRICS_RETENTION_DAYS"`
 }
 
 type LogConfig struct {
@@ -79,4 +161,83 @@ type HAConfig struct {
 	PeerNodes         []string      `yaml:"peer_nodes" env:"HA_PEER_NODES"`
 	HeartbeatInterval time.Duration `yaml:"heartbeat_interval" env:"HA_HEARTBEAT_INTERVAL"`
 	ElectionTimeout   time.Duration `yaml:"election_timeout" env:"HA_ELECTION_TIMEOUT"`
-}
\ No newline at end of file
+	LeadershipWebhook string        `yaml:"leadership_webhook" env:"HA_LEADERSHIP_WEBHOOK_URL"`
+	DiscoveryDNS      string        `yaml:"discovery_dns" env:"HA_DISCOVERY_DNS"`
+	DiscoveryInterval time.Duration `yaml:"discovery_interval" env:"HA_DISCOVERY_INTERVAL"`
+	// Peers slower than this to answer a health check mark the cluster
+	// degraded; 0 disables the check
+	SlowPeerLatency time.Duration `yaml:"slow_peer_latency" env:"HA_SLOW_PEER_MS"`
+	// PeerSecret signs election and leader requests between controllers.
+	// Every controller in the cluster must share it; HA won't start without
+	// it.
+	PeerSecret string `yaml:"peer_secret" env:"HA_PEER_SECRET"`
+}
+
+type AlertingConfig struct {
+	// Targets maps an alert severity (info, warning, critical) to the
+	// endpoints notified when an alert of that severity fires.
+	Targets      map[string]AlertTargets `yaml:"targets"`
+	MaxRetries   int                     `yaml:"max_retries" env:"ALERT_NOTIFY_MAX_RETRIES"`
+	RetryBackoff time.Duration           `yaml:"retry_backoff" env:"ALERT_NOTIFY_RETRY_BACKOFF"`
+}
+
+// WebhookConfig sends node lifecycle events to external endpoints.
+type WebhookConfig struct {
+	// Endpoints maps a node event (registered, approved, active, offline,
+	// deleted) to the URLs notified when it happens.
+	Endpoints map[string][]string `yaml:"endpoints"`
+	// Secret signs node event, alert and leadership webhooks with
+	// HMAC-SHA256 so receivers can verify they came from the controller.
+	Secret       string        `yaml:"secret" env:"WEBHOOK_SECRET"`
+	MaxRetries   int           `yaml:"max_retries" env:"WEBHOOK_MAX_RETRIES"`
+	RetryBackoff time.Duration `yaml:"retry_backoff" env:"WEBHOOK_RETRY_BACKOFF"`
+}
+
+// FeaturesConfig switches optional subsystems on or off per deployment. HA
+// is controlled by HAConfig.Enabled.
+type FeaturesConfig struct {
+	Backups       bool `yaml:"backups" env:"FEATURE_BACKUPS"`
+	RemoteBackups bool `yaml:"remote_backups" env:"FEATURE_REMOTE_BACKUPS"`
+	Mesh          bool `yaml:"mesh" env:"FEATURE_MESH"`
+	// EndpointProbe checks a node's endpoint is reachable before it is
+	// made active.
+	EndpointProbe bool `yaml:"endpoint_probe" env:"FEATURE_ENDPOINT_PROBE"`
+	// ConfigHistory keeps the previous config served to each node so admins
+	// can compare it with the current one.
+	ConfigHistory bool `yaml:"config_history" env:"FEATURE_CONFIG_HISTORY"`
+}
+
+// BackupConfig holds settings for where backups are kept. Backups stay on
+// local disk unless FeaturesConfig.RemoteBackups is on.
+type BackupConfig struct {
+	S3 S3Config `yaml:"s3"`
+	// EncryptionKey is a base64-encoded 32-byte AES-256 key used for backups
+	// created with encryption and for restoring them.
+	EncryptionKey string `yaml:"encryption_key" env:"BACKUP_ENCRYPTION_KEY"`
+}
+
+// S3Config points at an S3-compatible bucket such as AWS S3 or MinIO.
+type S3Config struct {
+	Endpoint        string `yaml:"endpoint" env:"BACKUP_S3_ENDPOINT"`
+	Region          string `yaml:"region" env:"BACKUP_S3_REGION"`
+	Bucket          string `yaml:"bucket" env:"BACKUP_S3_BUCKET"`
+	AccessKeyID     string `yaml:"access_key_id" env:"BACKUP_S3_ACCESS_KEY_ID"`
+	SecretAccessKey string `yaml:"secret_access_key" env:"BACKUP_S3_SECRET_ACCESS_KEY"`
+	// Prefix is prepended to every object key, e.g. "wg-sdwan/".
+	Prefix string `yaml:"prefix" env:"BACKUP_S3_PREFIX"`
+}
+
+// EmailConfig is the SMTP relay used for password reset emails. Email is
+// disabled while SMTPHost is empty.
+type EmailConfig struct {
+	SMTPHost     string `yaml:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort     int    `yaml:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD"`
+	From         string `yaml:"from" env:"SMTP_FROM"`
+}
+
+type AlertTargets struct {
+	WebhookURLs     []string `yaml:"webhook_urls"`
+	SlackWebhookURL string   `yaml:"slack_webhook_url"`
+}
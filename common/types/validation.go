@@ -0,0 +1,69 @@
+package types
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrInvalidEndpoint = errors.New("endpoint must be host:port")
+	ErrInvalidKey      = errors.New("key must be 32 bytes of base64")
+	ErrControlChars    = errors.New("value must not contain control characters")
+)
+
+// ValidateKey checks that key is a base64 encoded WireGuard key.
+func ValidateKey(key string) error {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 32 {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// ValidateEndpoint checks that endpoint is an IP address or hostname and a
+// port, as WireGuard's Endpoint setting takes it. IPv6 addresses must be in
+// brackets.
+func ValidateEndpoint(endpoint string) error {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidEndpoint, endpoint)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%w: %q", ErrInvalidEndpoint, endpoint)
+	}
+	if net.ParseIP(host) == nil && !isHostname(host) {
+		return fmt.Errorf("%w: %q", ErrInvalidEndpoint, endpoint)
+	}
+	return nil
+}
+
+// ValidateText rejects values with control characters. A line break in a
+// value written into a WireGuard config would start a directive of its own.
+func ValidateText(value string) error {
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%w: %q", ErrControlChars, value)
+	}
+	return nil
+}
+
+func isHostname(host string) bool {
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+)
+
+type ApprovalHandler struct {
+	approvalService *services.ApprovalService
+	authService     *services.AuthService
+}
+
+func NewApprovalHandler(approvalService *services.ApprovalService, authService *services.AuthService) *ApprovalHandler {
+	return &ApprovalHandler{
+		approvalService: approvalService,
+		authService:     authService,
+	}
+}
+
+type RejectApprovalRequest struct {
+	Reason string `json:"reason"`
+}
+
+// GetApprovals godoc
+// @Summary List approval requests
+// @Description Get paginated list of destructive actions awaiting or decided by a second admin (admin only)
+// @Tags approvals
+// @Accept json
+// @Produce json
+// @Param status query string false "Filter by status (pending, rejected, executed, failed)"
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page" default(10)
+// @Success 200 {object} types.PaginatedResponse{data=[]services.ApprovalRequest}
+// @Failure 403 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /approvals [get]
+func (h *ApprovalHandler) GetApprovals(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "10"))
+
+	requests, total, err := h.approvalService.GetRequests(c.Request.Context(), c.Query("status"), page, perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	for i := range requests {
+		hideApprovalResult(&requests[i])
+	}
+
+	totalPages := int((total + int64(perPage) - 1) / int64(perPage))
+
+	c.JSON(http.StatusOK, types.PaginatedResponse{
+		APIResponse: types.APIResponse{
+			Success: true,
+			Data:    requests,
+		},
+		Pagination: types.PaginationInfo{
+			Page:       page,
+			PerPage:    perPage,
+			Total:      total,
+			TotalPages: totalPages,
+		},
+	})
+}
+
+// GetApproval godoc
+// @Summary Get approval request
+// @Description Get an approval request. The result of an executed action is returned once to the admin who requested it and then cleared (admin only)
+// @Tags approvals
+// @Accept json
+// @Produce json
+// @Param id path string true "Approval request ID"
+// @Success 200 {object} types.APIResponse{data=services.ApprovalRequest}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Router /approvals/{id} [get]
+func (h *ApprovalHandler) GetApproval(c *gin.Context) {
+	user, ok := h.requireAdmin(c)
+	if !ok {
+		return
+	}
+
+	id, ok := parseApprovalID(c)
+	if !ok {
+		return
+	}
+
+	request, err := h.approvalService.TakeResult(c.Request.Context(), id, user.ID)
+	if err != nil {
+		respondApprovalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    request,
+	})
+}
+
+// ApproveRequest godoc
+// @Summary Approve a pending destructive action
+// @Description Approve a request made by another admin; the action runs immediately (admin only)
+// @Tags approvals
+// @Accept json
+// @Produce json
+// @Param id path string true "Approval request ID"
+// @Success 200 {object} types.APIResponse{data=services.ApprovalRequest}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 409 {object} types.APIResponse
+// @Router /approvals/{id}/approve [post]
+func (h *ApprovalHandler) ApproveRequest(c *gin.Context) {
+	user, ok := h.requireAdmin(c)
+	if !ok {
+		return
+	}
+
+	id, ok := parseApprovalID(c)
+	if !ok {
+		return
+	}
+
+	request, err := h.approvalService.Approve(c.Request.Context(), id, user.ID)
+	if err != nil {
+		respondApprovalError(c, err)
+		return
+	}
+
+	hideApprovalResult(request)
+
+	if request.Status == services.ApprovalStatusFailed {
+		c.JSON(http.StatusOK, types.APIResponse{
+			Success: true,
+			Data:    request,
+			Message: "Request approved but the action failed",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    request,
+		Message: "Request approved and executed",
+	})
+}
+
+// RejectRequest godoc
+// @Summary Reject a pending destructive action
+// @Description Reject a request made by another admin without running it (admin only)
+// @Tags approvals
+// @Accept json
+// @Produce json
+// @Param id path string true "Approval request ID"
+// @Param reject body RejectApprovalRequest false "Rejection reason"
+// @Success 200 {object} types.APIResponse{data=services.ApprovalRequest}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 409 {object} types.APIResponse
+// @Router /approvals/{id}/reject [post]
+func (h *ApprovalHandler) RejectRequest(c *gin.Context) {
+	user, ok := h.requireAdmin(c)
+	if !ok {
+		return
+	}
+
+	id, ok := parseApprovalID(c)
+	if !ok {
+		return
+	}
+
+	var req RejectApprovalRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	request, err := h.approvalService.Reject(c.Request.Context(), id, user.ID, req.Reason)
+	if err != nil {
+		respondApprovalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    request,
+		Message: "Request rejected",
+	})
+}
+
+func (h *ApprovalHandler) requireAdmin(c *gin.Context) (*models.User, bool) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return nil, false
+	}
+
+	// RequireRole lets observers through for reads, but approvals can carry
+	// exported configuration, so only admins may see them
+	user := currentUser.(*models.User)
+	if user.Role != models.UserRoleAdmin {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return nil, false
+	}
+
+	return user, true
+}
+
+func parseApprovalID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid approval request ID format",
+		})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func respondApprovalError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrApprovalNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrSelfApproval), errors.Is(err, services.ErrApproverNotAdmin):
+		status = http.StatusForbidden
+	case errors.Is(err, services.ErrApprovalNotPending), errors.Is(err, services.ErrApprovalExpired):
+		status = http.StatusConflict
+	}
+
+	c.JSON(status, types.APIResponse{
+		Success: false,
+		Error:   err.Error(),
+	})
+}
+
+// hideApprovalResult strips action output, such as exported configuration.
+// The requester collects it once through GetApproval.
+func hideApprovalResult(request *services.ApprovalRequest) {
+	request.Result = ""
+}
+
+// requestApproval queues a destructive action for a second admin and
+// responds with 202 Accepted. Queuing is a write, so read-only users are
+// refused even on GET routes such as exports.
+func requestApproval(c *gin.Context, approvalService *services.ApprovalService, action string, payload interface{}, user *models.User, description string) {
+	if user.IsReadOnly() {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Read-only access",
+		})
+		return
+	}
+
+	request, err := approvalService.RequestApproval(c.Request.Context(), action, payload, user.ID, description)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, types.APIResponse{
+		Success: true,
+		Data:    request,
+		Message: "Action is pending approval by another admin",
+	})
+}
@@ -1,8 +1,12 @@
 package api
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -36,9 +40,14 @@ func NewAuditHandler(auditService *services.AuditService, authService *services.
 // @Param action query string false "Filter by action"
 // @Param resource query string false "Filter by resource"
 // @Param resource_id query string false "Filter by resource ID"
+// @Param job_id query string false "Filter by job correlation ID"
+// @Param q query string false "Case-insensitive text to find in the description or metadata"
 // @Param start_time query string false "Start time (RFC3339)"
 // @Param end_time query string false "End time (RFC3339)"
+// @Param cursor query string false "Page with a cursor instead of page numbers; pass it empty for the first page, then the previous response's next_cursor"
 // @Success 200 {object} types.PaginatedResponse{data=[]models.AuditLog}
+// @Success 200 {object} types.CursorPaginatedResponse{data=[]models.AuditLog}
+// @Failure 400 {object} types.APIResponse
 // @Failure 403 {object} types.APIResponse
 // @Router /audit/logs [get]
 func (h *AuditHandler) GetAuditLogs(c *gin.Context) {
@@ -63,38 +72,12 @@ func (h *AuditHandler) GetAuditLogs(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "10"))
 
-	filters := make(map[string]interface{})
-
-	if userID := c.Query("user_id"); userID != "" {
-		if id, err := uuid.Parse(userID); err == nil {
-			filters["user_id"] = id
-		}
-	}
-
-	if action := c.Query("action"); action != "" {
-		filters["action"] = action
-	}
+	// The paginated view skips malformed filters rather than failing
+	filters, _ := auditLogFilters(c)
 
-	if resource := c.Query("resource"); resource != "" {
-		filters["resource"] = resource
-	}
-
-	if resourceID := c.Query("resource_id"); resourceID != "" {
-		if id, err := uuid.Parse(resourceID); err == nil {
-			filters["resource_id"] = id
-		}
-	}
-
-	if startTime := c.Query("start_time"); startTime != "" {
-		if t, err := time.Parse(time.RFC3339, startTime); err == nil {
-			filters["start_time"] = t
-		}
-	}
-
-	if endTime := c.Query("end_time"); endTime != "" {
-		if t, err := time.Parse(time.RFC3339, endTime); err == nil {
-			filters["end_time"] = t
-		}
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		h.getAuditLogsAfter(c, cursor, perPage, filters)
+		return
 	}
 
 	logs, total, err := h.auditService.GetAuditLogs(c.Request.Context(), page, perPage, filters)
@@ -122,6 +105,172 @@ func (h *AuditHandler) GetAuditLogs(c *gin.Context) {
 	})
 }
 
+// getAuditLogsAfter serves GetAuditLogs in cursor mode, which skips the
+// total count so large audit tables page quickly.
+func (h *AuditHandler) getAuditLogsAfter(c *gin.Context, cursor string, perPage int, filters map[string]interface{}) {
+	if perPage < 1 {
+		perPage = 10
+	}
+
+	logs, next, err := h.auditService.GetAuditLogsAfter(c.Request.Context(), cursor, perPage, filters)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidCursor) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.CursorPaginatedResponse{
+		APIResponse: types.APIResponse{
+			Success: true,
+			Data:    logs,
+		},
+		Pagination: types.CursorPaginationInfo{
+			PerPage:    perPage,
+			NextCursor: next,
+			HasMore:    next != "",
+		},
+	})
+}
+
+// ExportAuditLogs godoc
+// @Summary Export audit logs
+// @Description Download every audit log matching the filters as CSV or JSON, oldest first (admin only)
+// @Tags audit
+// @Produce text/csv
+// @Produce json
+// @Param format query string false "Export format (csv/json)" default(csv)
+// @Param user_id query string false "Filter by user ID"
+// @Param action query string false "Filter by action"
+// @Param resource query string false "Filter by resource"
+// @Param resource_id query string false "Filter by resource ID"
+// @Param job_id query string false "Filter by job correlation ID"
+// @Param q query string false "Case-insensitive text to find in the description or metadata"
+// @Param start_time query string false "Start time (RFC3339)"
+// @Param end_time query string false "End time (RFC3339)"
+// @Success 200 {file} file
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /audit/export [get]
+func (h *AuditHandler) ExportAuditLogs(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	format := c.DefaultQuery("format", services.AuditExportFormatCSV)
+	contentType := "text/csv; charset=utf-8"
+	switch format {
+	case services.AuditExportFormatCSV:
+	case services.AuditExportFormatJSON:
+		contentType = "application/json; charset=utf-8"
+	default:
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid format. Supported formats: csv, json",
+		})
+		return
+	}
+
+	// An export silently widened by a mistyped date would be misleading
+	filters, err := auditLogFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("audit-logs-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+
+	count, err := h.auditService.ExportAuditLogs(c.Request.Context(), c.Writer, format, filters)
+	if err != nil {
+		if !c.Writer.Written() {
+			c.Header("Content-Disposition", "")
+			c.JSON(http.StatusInternalServerError, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		// Headers are already sent; the truncated download is all we can give
+		slog.ErrorContext(c.Request.Context(), "Audit log export failed", "rows", count, "error", err)
+	}
+}
+
+// VerifyAuditChain godoc
+// @Summary Verify audit log integrity
+// @Description Walk the audit log hash chain and report the first entry that was altered, removed or inserted, if any (admin only)
+// @Tags audit
+// @Accept json
+// @Produce json
+// @Success 200 {object} types.APIResponse{data=services.AuditChainVerification}
+// @Failure 403 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /audit/verify [get]
+func (h *AuditHandler) VerifyAuditChain(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	result, err := h.auditService.VerifyChain(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	message := "Audit log chain is intact"
+	if !result.Valid {
+		message = "Audit log chain is broken"
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    result,
+		Message: message,
+	})
+}
+
 // GetAuditLog godoc
 // @Summary Get audit log by ID
 // @Description Get specific audit log by ID (admin only)
@@ -389,4 +538,63 @@ func (h *AuditHandler) GetActivitySummary(c *gin.Context) {
 		Success: true,
 		Data:    summary,
 	})
+}
+
+// auditLogFilters reads the audit log filters from the query string. Filters
+// that fail to parse are left out; invalid times are also reported in the
+// returned error.
+func auditLogFilters(c *gin.Context) (map[string]interface{}, error) {
+	filters := make(map[string]interface{})
+	var invalid []string
+
+	if userID := c.Query("user_id"); userID != "" {
+		if id, err := uuid.Parse(userID); err == nil {
+			filters["user_id"] = id
+		}
+	}
+
+	if action := c.Query("action"); action != "" {
+		filters["action"] = action
+	}
+
+	if resource := c.Query("resource"); resource != "" {
+		filters["resource"] = resource
+	}
+
+	if resourceID := c.Query("resource_id"); resourceID != "" {
+		if id, err := uuid.Parse(resourceID); err == nil {
+			filters["resource_id"] = id
+		}
+	}
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		filters["q"] = q
+	}
+
+	if jobID := c.Query("job_id"); jobID != "" {
+		if id, err := uuid.Parse(jobID); err == nil {
+			filters["job_id"] = id
+		}
+	}
+
+	if startTime := c.Query("start_time"); startTime != "" {
+		if t, err := time.Parse(time.RFC3339, startTime); err == nil {
+			filters["start_time"] = t
+		} else {
+			invalid = append(invalid, "start_time")
+		}
+	}
+
+	if endTime := c.Query("end_time"); endTime != "" {
+		if t, err := time.Parse(time.RFC3339, endTime); err == nil {
+			filters["end_time"] = t
+		} else {
+			invalid = append(invalid, "end_time")
+		}
+	}
+
+	if len(invalid) > 0 {
+		return filters, fmt.Errorf("invalid %s, expected RFC3339", strings.Join(invalid, " and "))
+	}
+	return filters, nil
 }
\ No newline at end of file
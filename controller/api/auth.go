@@ -1,12 +1,14 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	"github.com/wg-hubspoke/wg-hubspoke/common/types"
 	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
 	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
@@ -32,6 +34,7 @@ func NewAuthHandler(authService *services.AuthService) *AuthHandler {
 // @Success 200 {object} types.APIResponse{data=services.LoginResponse}
 // @Failure 400 {object} types.APIResponse
 // @Failure 401 {object} types.APIResponse
+// @Failure 429 {object} types.APIResponse
 // @Router /auth/login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req services.LoginRequest
@@ -52,6 +55,9 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		if err == services.ErrUserNotFound || err == services.ErrInvalidPassword {
 			statusCode = http.StatusUnauthorized
 		}
+		if err == services.ErrAccountLocked {
+			statusCode = http.StatusTooManyRequests
+		}
 
 		c.JSON(statusCode, types.APIResponse{
 			Success: false,
@@ -67,6 +73,233 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	})
 }
 
+// Register godoc
+// @Summary Register an account
+// @Description Create an account with the user role; refused unless self-registration is enabled
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param user body services.RegisterRequest true "Account details"
+// @Success 201 {object} types.APIResponse{data=models.User}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 409 {object} types.APIResponse
+// @Router /auth/register [post]
+func (h *AuthHandler) Register(c *gin.Context) {
+	var req services.RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	user, err := h.authService.Register(c.Request.Context(), req, c.ClientIP(), c.GetHeader("User-Agent"))
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrRegistrationDisabled):
+			statusCode = http.StatusForbidden
+		case errors.Is(err, services.ErrUserExists):
+			statusCode = http.StatusConflict
+		case errors.Is(err, services.ErrPasswordPolicy):
+			statusCode = http.StatusBadRequest
+		}
+
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, types.APIResponse{
+		Success: true,
+		Data:    user,
+		Message: "User registered successfully",
+	})
+}
+
+// refreshTokenRequest carries the refresh token issued at login.
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// RefreshToken godoc
+// @Summary Refresh access token
+// @Description Exchange a refresh token for a new access token, rotating the refresh token
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body refreshTokenRequest true "Refresh token"
+// @Success 200 {object} types.APIResponse{data=services.LoginResponse}
+// @Failure 400 {object} types.APIResponse
+// @Failure 401 {object} types.APIResponse
+// @Router /auth/refresh [post]
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var req refreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	resp, err := h.authService.RefreshToken(c.Request.Context(), req.RefreshToken, c.ClientIP(), c.GetHeader("User-Agent"))
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrTokenExpired) ||
+			errors.Is(err, services.ErrRefreshTokenReused) || errors.Is(err, services.ErrUnauthorized) {
+			statusCode = http.StatusUnauthorized
+		}
+
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    resp,
+		Message: "Token refreshed successfully",
+	})
+}
+
+// Logout godoc
+// @Summary User logout
+// @Description Revoke the refresh token and every token rotated from the same login
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body refreshTokenRequest true "Refresh token"
+// @Success 200 {object} types.APIResponse
+// @Failure 400 {object} types.APIResponse
+// @Failure 401 {object} types.APIResponse
+// @Router /auth/logout [post]
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req refreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := h.authService.RevokeRefreshToken(c.Request.Context(), req.RefreshToken, c.ClientIP(), c.GetHeader("User-Agent")); err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidToken) {
+			statusCode = http.StatusUnauthorized
+		}
+
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "Logged out successfully",
+	})
+}
+
+// forgotPasswordRequest names the account whose password was forgotten.
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ForgotPassword godoc
+// @Summary Request password reset
+// @Description Email a single-use password reset link. The response is the same whether or not the address belongs to a user
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body forgotPasswordRequest true "Account email"
+// @Success 200 {object} types.APIResponse
+// @Failure 400 {object} types.APIResponse
+// @Failure 503 {object} types.APIResponse
+// @Router /auth/forgot-password [post]
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req forgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := h.authService.RequestPasswordReset(c.Request.Context(), req.Email, c.ClientIP(), c.GetHeader("User-Agent")); err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrEmailNotConfigured) {
+			statusCode = http.StatusServiceUnavailable
+		}
+
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "If the address belongs to an account, a password reset link has been sent",
+	})
+}
+
+// resetPasswordRequest carries the emailed reset token and the new password.
+type resetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// ResetPassword godoc
+// @Summary Reset password
+// @Description Set a new password using a password reset token. The token can be used once
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body resetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} types.APIResponse
+// @Failure 400 {object} types.APIResponse
+// @Router /auth/reset-password [post]
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req resetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := h.authService.ResetPassword(c.Request.Context(), req.Token, req.NewPassword, c.ClientIP(), c.GetHeader("User-Agent")); err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrPasswordPolicy) || errors.Is(err, services.ErrInvalidToken) ||
+			errors.Is(err, services.ErrTokenExpired) || errors.Is(err, services.ErrUnauthorized) {
+			statusCode = http.StatusBadRequest
+		}
+
+		c.JSON(statusCode, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "Password reset successfully",
+	})
+}
+
 // CreateUser godoc
 // @Summary Create new user
 // @Description Create a new user (admin only)
@@ -455,6 +688,13 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 			})
 			return
 		}
+		if errors.Is(err, services.ErrPasswordPolicy) {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
 			Error:   err.Error(),
@@ -471,7 +711,34 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 // AuthMiddleware - JWT authentication middleware
 func (h *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// The follower that forwarded the request verified the agent's
+		// certificate; see HAHandler.ProxiedClientMiddleware
+		if nodeID, ok := c.Get("proxied_client_node"); ok {
+			authorizeAgent(c, nodeID.(uuid.UUID))
+			return
+		}
+
+		// Agents may authenticate with a client certificate naming their
+		// node; certificates that name no node are left to token auth
+		nodeID, err := h.authService.AuthenticateNodeCertificate(c.Request.TLS)
+		if err == nil {
+			authorizeAgent(c, nodeID)
+			return
+		}
+		if !errors.Is(err, services.ErrNoClientCertificate) && !errors.Is(err, services.ErrNoCertificateNodeIdentity) {
+			c.JSON(http.StatusUnauthorized, types.APIResponse{
+				Success: false,
+				Error:   "Invalid client certificate",
+			})
+			c.Abort()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
+		// Browsers cannot set headers on WebSocket requests
+		if token := c.Query("access_token"); authHeader == "" && token != "" && websocket.IsWebSocketUpgrade(c.Request) {
+			authHeader = "Bearer " + token
+		}
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, types.APIResponse{
 				Success: false,
@@ -491,6 +758,20 @@ func (h *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if nodeClaims, err := h.authService.ValidateNodeToken(tokenString); err == nil {
+			if h.authService.NodeClientCertificateRequired() {
+				c.JSON(http.StatusUnauthorized, types.APIResponse{
+					Success: false,
+					Error:   "Client certificate required",
+				})
+				c.Abort()
+				return
+			}
+
+			authorizeAgent(c, nodeClaims.NodeID)
+			return
+		}
+
 		claims, err := h.authService.ValidateToken(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, types.APIResponse{
@@ -521,12 +802,85 @@ func (h *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if user.MustChangePassword && !passwordChangeRoutes[c.Request.Method+" "+c.FullPath()] {
+			c.JSON(http.StatusForbidden, types.APIResponse{
+				Success: false,
+				Error:   "Password change required",
+			})
+			c.Abort()
+			return
+		}
+
 		c.Set("current_user", user)
 		c.Set("user_claims", claims)
 		c.Next()
 	}
 }
 
+// agentRoutes maps the routes an agent token may call to the path parameter
+// holding the node ID, which must match the token's node.
+var agentRoutes = map[string]string{
+	"GET /api/v1/nodes/:id/config":                   "id",
+	"PUT /api/v1/nodes/:id":                          "id",
+	"POST /api/v1/nodes/:id/peer-errors":             "id",
+	"PUT /api/v1/nodes/:id/public-key":               "id",
+	"POST /api/v1/monitoring/nodes/:node_id/metrics": "node_id",
+}
+
+// passwordChangeRoutes are the only routes open to a user who must change
+// their password.
+var passwordChangeRoutes = map[string]bool{
+	"POST /auth/change-password": true,
+}
+
+// authorizeAgent lets an authenticated agent through to the routes it may
+// call for its own node.
+func authorizeAgent(c *gin.Context, nodeID uuid.UUID) {
+	if !agentRouteAllowed(c, nodeID) {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Agent not permitted for this endpoint",
+		})
+		c.Abort()
+		return
+	}
+
+	c.Set("current_node", nodeID)
+	c.Next()
+}
+
+func agentRouteAllowed(c *gin.Context, nodeID uuid.UUID) bool {
+	param, ok := agentRoutes[c.Request.Method+" "+c.FullPath()]
+	if !ok {
+		return false
+	}
+	return c.Param(param) == nodeID.String()
+}
+
+// requireUserRole returns the current user if their role is at least role,
+// otherwise it writes an error response.
+func requireUserRole(c *gin.Context, authService *services.AuthService, role models.UserRole) (*models.User, bool) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return nil, false
+	}
+
+	user := currentUser.(*models.User)
+	if err := authService.RequireRole(user.Role, role); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Insufficient permissions",
+		})
+		return nil, false
+	}
+
+	return user, true
+}
+
 // AdminMiddleware - Admin role requirement middleware
 func (h *AuthHandler) AdminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -552,4 +906,43 @@ func (h *AuthHandler) AdminMiddleware() gin.HandlerFunc {
 
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// ReadOnlyMiddleware - Rejects write requests from read-only roles
+func (h *AuthHandler) ReadOnlyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		// Agent tokens are already restricted to their node's routes
+		if _, ok := c.Get("current_node"); ok {
+			c.Next()
+			return
+		}
+
+		currentUser, exists := c.Get("current_user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, types.APIResponse{
+				Success: false,
+				Error:   "Unauthorized",
+			})
+			c.Abort()
+			return
+		}
+
+		user := currentUser.(*models.User)
+		if err := h.authService.RequireWriteAccess(user.Role); err != nil {
+			c.JSON(http.StatusForbidden, types.APIResponse{
+				Success: false,
+				Error:   "Read-only access",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
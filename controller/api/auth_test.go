@@ -0,0 +1,1025 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var rbacTestSchema = []string{
+	`CREATE TABLE users (
+		id TEXT PRIMARY KEY, username TEXT NOT NULL UNIQUE, email TEXT NOT NULL UNIQUE,
+		password TEXT NOT NULL, role TEXT DEFAULT 'user', is_active BOOLEAN DEFAULT TRUE, auth_source TEXT DEFAULT 'local',
+		must_change_password BOOLEAN DEFAULT FALSE, last_login DATETIME, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+	`CREATE TABLE nodes (
+		id TEXT PRIMARY KEY, name TEXT NOT NULL UNIQUE, description TEXT, node_type TEXT NOT NULL,
+		public_key TEXT NOT NULL, key_rotated_at DATETIME, key_rotation_requested_at DATETIME,
+		allocated_ip TEXT NOT NULL, endpoint TEXT,
+		port INTEGER, allowed_ips TEXT, last_handshake DATETIME, last_seen DATETIME, endpoint_probed_at DATETIME, endpoint_probe_error TEXT, peer_errors TEXT, peer_errors_reported_at DATETIME, agent_version TEXT, build_commit TEXT, status TEXT, persistent_keepalive INTEGER,
+		mtu INTEGER, pinned_hub_id TEXT, backup_hub_ids TEXT, routes TEXT,
+		pre_up TEXT, post_up TEXT, pre_down TEXT, post_down TEXT, tags TEXT, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+	`CREATE TABLE audit_logs (
+		id TEXT PRIMARY KEY, user_id TEXT, action TEXT NOT NULL, resource TEXT,
+		resource_id TEXT, description TEXT, ip_address TEXT, user_agent TEXT,
+		metadata TEXT, job_id TEXT, sequence INTEGER, prev_hash TEXT, hash TEXT,
+		created_at DATETIME)`,
+}
+
+type rbacTestEnv struct {
+	db     *gorm.DB
+	router *gin.Engine
+	users  map[models.UserRole]*models.User
+}
+
+func newRBACTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	for _, stmt := range rbacTestSchema {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create test schema: %v", err)
+		}
+	}
+	return db
+}
+
+// newRBACTestEnv serves the node, user, audit log and config import and
+// export routes, authenticating each request as the user whose role is named
+// in the X-Test-Role header.
+func newRBACTestEnv(t *testing.T) *rbacTestEnv {
+	t.Helper()
+
+	db := newRBACTestDB(t)
+	env := &rbacTestEnv{db: db, users: make(map[models.UserRole]*models.User)}
+	for _, role := range []models.UserRole{models.UserRoleAdmin, models.UserRoleOperator, models.UserRoleUser, models.UserRoleObserver} {
+		user := &models.User{Username: string(role), Email: string(role) + "@example.com", Password: "x", Role: role, IsActive: true}
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create %s user: %v", role, err)
+		}
+		env.users[role] = user
+	}
+
+	config := &types.Config{WG: types.WGConfig{Subnet: "10.100.0.0/16", MTU: 1420}}
+	auditService := services.NewAuditService(db)
+	authService := services.NewAuthService(db, config, auditService)
+	nodesHandler := NewNodesHandler(services.NewNodeService(db, config, auditService), nil, authService, services.NewApprovalService(db, config, auditService))
+	authHandler := NewAuthHandler(authService)
+	configHandler := NewConfigHandler(services.NewConfigService(db, auditService), authService, services.NewApprovalService(db, config, auditService))
+	auditHandler := NewAuditHandler(auditService, authService)
+
+	gin.SetMode(gin.TestMode)
+	env.router = gin.New()
+	v1 := env.router.Group("/api/v1")
+	v1.Use(func(c *gin.Context) {
+		c.Set("current_user", env.users[models.UserRole(c.GetHeader("X-Test-Role"))])
+		c.Next()
+	})
+	v1.Use(authHandler.ReadOnlyMiddleware())
+	v1.POST("/nodes", nodesHandler.RegisterNode)
+	v1.GET("/nodes", nodesHandler.GetNodes)
+	v1.PUT("/nodes/:id", nodesHandler.UpdateNode)
+	v1.DELETE("/nodes/:id", nodesHandler.DeleteNode)
+	v1.GET("/nodes/:id/agent-config", nodesHandler.DownloadAgentConfig)
+	v1.GET("/audit/logs", auditHandler.GetAuditLogs)
+	v1.PATCH("/nodes/status", nodesHandler.UpdateNodeStatuses)
+	v1.DELETE("/users/:id", authHandler.DeleteUser)
+	v1.GET("/config/export", configHandler.ExportConfiguration)
+	v1.POST("/config/import", configHandler.ImportConfiguration)
+	v1.POST("/config/validate", configHandler.ValidateConfiguration)
+
+	return env
+}
+
+func (e *rbacTestEnv) serve(role models.UserRole, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Test-Role", string(role))
+	e.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestOperatorCanRegisterNodes(t *testing.T) {
+	env := newRBACTestEnv(t)
+
+	register := func(name string, key byte) types.NodeRegistrationRequest {
+		return types.NodeRegistrationRequest{
+			Name:      name,
+			NodeType:  "hub",
+			PublicKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{key}, 32)),
+			Endpoint:  name + ".example.com",
+			Port:      51820,
+		}
+	}
+
+	if w := env.serve(models.UserRoleOperator, http.MethodPost, "/api/v1/nodes", register("hub-operator", 1)); w.Code != http.StatusCreated {
+		t.Fatalf("expected operator to register a node, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := env.serve(models.UserRoleAdmin, http.MethodPost, "/api/v1/nodes", register("hub-admin", 2)); w.Code != http.StatusCreated {
+		t.Errorf("expected admin to register a node, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := env.serve(models.UserRoleUser, http.MethodPost, "/api/v1/nodes", register("hub-user", 3)); w.Code != http.StatusForbidden {
+		t.Errorf("expected user to be forbidden from registering nodes, got %d", w.Code)
+	}
+
+	var count int64
+	env.db.Model(&models.Node{}).Count(&count)
+	if count != 2 {
+		t.Errorf("expected 2 registered nodes, got %d", count)
+	}
+}
+
+func TestOnlyAdminsSetInterfaceHooks(t *testing.T) {
+	env := newRBACTestEnv(t)
+	hooks := types.InterfaceHooks{PostUp: []string{"iptables -A FORWARD -i %i -j ACCEPT"}}
+	register := func(name string, key byte) types.NodeRegistrationRequest {
+		return types.NodeRegistrationRequest{
+			Name:           name,
+			NodeType:       "spoke",
+			PublicKey:      base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{key}, 32)),
+			InterfaceHooks: hooks,
+		}
+	}
+
+	if w := env.serve(models.UserRoleOperator, http.MethodPost, "/api/v1/nodes", register("spoke-operator", 1)); w.Code != http.StatusForbidden {
+		t.Errorf("expected an operator to be refused hooks at registration, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := env.serve(models.UserRoleAdmin, http.MethodPost, "/api/v1/nodes", register("spoke-admin", 2)); w.Code != http.StatusCreated {
+		t.Fatalf("expected an admin to register a node with hooks, got %d: %s", w.Code, w.Body.String())
+	}
+
+	plain := register("spoke-plain", 3)
+	plain.InterfaceHooks = types.InterfaceHooks{}
+	w := env.serve(models.UserRoleOperator, http.MethodPost, "/api/v1/nodes", plain)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected an operator to register a node without hooks, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Data models.Node `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode node: %v", err)
+	}
+	path := "/api/v1/nodes/" + created.Data.ID.String()
+
+	update := types.NodeUpdateRequest{InterfaceHooks: hooks}
+	if w := env.serve(models.UserRoleOperator, http.MethodPut, path, update); w.Code != http.StatusForbidden {
+		t.Errorf("expected an operator to be refused a hook change, got %d: %s", w.Code, w.Body.String())
+	}
+	description := "branch office"
+	if w := env.serve(models.UserRoleOperator, http.MethodPut, path, types.NodeUpdateRequest{Description: &description}); w.Code != http.StatusOK {
+		t.Errorf("expected an operator to update other fields, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := env.serve(models.UserRoleAdmin, http.MethodPut, path, update); w.Code != http.StatusOK {
+		t.Errorf("expected an admin to change the hooks, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var entries []models.AuditLog
+	env.db.Where("resource = ?", "node_hooks").Find(&entries)
+	if len(entries) != 2 {
+		t.Fatalf("expected an audit entry for each hook change, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.UserID == nil || *entry.UserID != env.users[models.UserRoleAdmin].ID {
+			t.Errorf("expected the hook change to be attributed to the admin, got %v", entry.UserID)
+		}
+		if !strings.Contains(entry.Metadata, "iptables -A FORWARD") {
+			t.Errorf("expected the hook commands in the audit metadata, got %q", entry.Metadata)
+		}
+	}
+}
+
+func TestDownloadAgentConfigIgnoresRequestHost(t *testing.T) {
+	env := newRBACTestEnv(t)
+
+	w := env.serve(models.UserRoleOperator, http.MethodPost, "/api/v1/nodes", types.NodeRegistrationRequest{
+		Name:      "spoke-1",
+		NodeType:  "spoke",
+		PublicKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{4}, 32)),
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("failed to register spoke-1: %d %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Data models.Node `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode node: %v", err)
+	}
+	path := "/api/v1/nodes/" + created.Data.ID.String() + "/agent-config"
+
+	// The file carries an agent token, so only admins may download it
+	if w := env.serve(models.UserRoleOperator, http.MethodGet, path, nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected an operator to be refused, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Host = "attacker.example.com"
+	req.Header.Set("X-Test-Role", string(models.UserRoleAdmin))
+	env.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected an admin to download the config, got %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "attacker.example.com") {
+		t.Errorf("expected the controller URL not to come from the Host header, got:\n%s", w.Body.String())
+	}
+}
+
+func TestRegisterNodeWithTagsAndFilter(t *testing.T) {
+	env := newRBACTestEnv(t)
+
+	for i, tags := range []map[string]string{
+		{"region": "us-east", "env": "prod"},
+		{"region": "us-east", "env": "staging"},
+		{"region": "eu-west", "env": "prod"},
+	} {
+		req := types.NodeRegistrationRequest{
+			Name:      fmt.Sprintf("hub-%d", i),
+			NodeType:  "hub",
+			PublicKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{byte(i + 1)}, 32)),
+			Tags:      tags,
+		}
+		if w := env.serve(models.UserRoleOperator, http.MethodPost, "/api/v1/nodes", req); w.Code != http.StatusCreated {
+			t.Fatalf("failed to register hub-%d: %d %s", i, w.Code, w.Body.String())
+		}
+	}
+
+	list := func(query string) []string {
+		t.Helper()
+		w := env.serve(models.UserRoleUser, http.MethodGet, "/api/v1/nodes?"+query, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /nodes?%s: expected 200, got %d: %s", query, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Data []models.Node `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode nodes: %v", err)
+		}
+		var names []string
+		for _, node := range resp.Data {
+			names = append(names, node.Name)
+		}
+		return names
+	}
+
+	if names := list("tag=region:us-east"); len(names) != 2 || names[0] != "hub-0" || names[1] != "hub-1" {
+		t.Errorf("expected the us-east hubs, got %v", names)
+	}
+	if names := list("tag=region:us-east&tag=env:prod"); len(names) != 1 || names[0] != "hub-0" {
+		t.Errorf("expected only the us-east prod hub, got %v", names)
+	}
+	if names := list("tag=env:dev"); len(names) != 0 {
+		t.Errorf("expected no nodes, got %v", names)
+	}
+	if w := env.serve(models.UserRoleUser, http.MethodGet, "/api/v1/nodes?tag=:prod", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a tag filter without a key, got %d", w.Code)
+	}
+}
+
+func TestGetNodesSorting(t *testing.T) {
+	env := newRBACTestEnv(t)
+
+	// Registered in this order, so created_at sorts b, a, c
+	for i, name := range []string{"hub-b", "hub-a", "hub-c"} {
+		req := types.NodeRegistrationRequest{
+			Name:      name,
+			NodeType:  "hub",
+			PublicKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{byte(i + 1)}, 32)),
+		}
+		if w := env.serve(models.UserRoleOperator, http.MethodPost, "/api/v1/nodes", req); w.Code != http.StatusCreated {
+			t.Fatalf("failed to register %s: %d %s", name, w.Code, w.Body.String())
+		}
+	}
+	env.db.Model(&models.Node{}).Where("name = ?", "hub-a").Updates(map[string]interface{}{"status": models.NodeStatusActive, "last_handshake": time.Now().Add(-time.Minute)})
+	env.db.Model(&models.Node{}).Where("name = ?", "hub-c").Updates(map[string]interface{}{"status": models.NodeStatusDisabled, "last_handshake": time.Now().Add(-time.Hour)})
+
+	list := func(query string) ([]string, types.PaginationInfo) {
+		t.Helper()
+		w := env.serve(models.UserRoleUser, http.MethodGet, "/api/v1/nodes?"+query, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /nodes?%s: expected 200, got %d: %s", query, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Data       []models.Node        `json:"data"`
+			Pagination types.PaginationInfo `json:"pagination"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode nodes: %v", err)
+		}
+		var names []string
+		for _, node := range resp.Data {
+			names = append(names, node.Name)
+		}
+		return names, resp.Pagination
+	}
+
+	for _, tc := range []struct {
+		query    string
+		expected string
+	}{
+		{"", "hub-b,hub-a,hub-c"},
+		{"sort=name", "hub-a,hub-b,hub-c"},
+		{"sort=name&order=desc", "hub-c,hub-b,hub-a"},
+		{"sort=created_at&order=DESC", "hub-c,hub-a,hub-b"},
+		{"sort=status", "hub-a,hub-c,hub-b"},
+		// Where never-seen nodes sort differs between databases
+		{"sort=last_handshake&order=desc&status=active", "hub-a"},
+		{"sort=name&per_page=2&page=2", "hub-c"},
+	} {
+		names, _ := list(tc.query)
+		if got := strings.Join(names, ","); got != tc.expected {
+			t.Errorf("GET /nodes?%s: expected %s, got %s", tc.query, tc.expected, got)
+		}
+	}
+
+	if _, pagination := list("sort=name&order=desc"); pagination.Sort != "name" || pagination.Order != "desc" {
+		t.Errorf("expected the sort to be echoed back, got %+v", pagination)
+	}
+	if _, pagination := list(""); pagination.Sort != "created_at" || pagination.Order != "asc" {
+		t.Errorf("expected the default sort to be echoed back, got %+v", pagination)
+	}
+
+	for _, query := range []string{"sort=public_key", "sort=name%3BDROP%20TABLE%20nodes", "sort=name&order=sideways"} {
+		if w := env.serve(models.UserRoleUser, http.MethodGet, "/api/v1/nodes?"+query, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /nodes?%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestRegisterNodeRecordsAgentVersion(t *testing.T) {
+	env := newRBACTestEnv(t)
+
+	req := types.NodeRegistrationRequest{
+		Name:         "spoke-1",
+		NodeType:     "spoke",
+		PublicKey:    base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+		AgentVersion: "v1.4.2",
+		BuildCommit:  "3f2c1ab",
+	}
+	if w := env.serve(models.UserRoleOperator, http.MethodPost, "/api/v1/nodes", req); w.Code != http.StatusCreated {
+		t.Fatalf("failed to register node: %d %s", w.Code, w.Body.String())
+	}
+
+	var stored models.Node
+	if err := env.db.Where("name = ?", "spoke-1").First(&stored).Error; err != nil {
+		t.Fatalf("failed to load node: %v", err)
+	}
+	if stored.AgentVersion != "v1.4.2" || stored.BuildCommit != "3f2c1ab" {
+		t.Errorf("expected the agent build to be stored, got %q/%q", stored.AgentVersion, stored.BuildCommit)
+	}
+
+	w := env.serve(models.UserRoleUser, http.MethodGet, "/api/v1/nodes", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /nodes: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data []models.Node `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode nodes: %v", err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].AgentVersion != "v1.4.2" || resp.Data[0].BuildCommit != "3f2c1ab" {
+		t.Errorf("expected the agent build in the node listing, got %+v", resp.Data)
+	}
+
+	// Control characters are rejected as they are in names
+	req.Name, req.PublicKey, req.AgentVersion = "spoke-2", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)), "v1\nPostUp = rm -rf /"
+	if w := env.serve(models.UserRoleOperator, http.MethodPost, "/api/v1/nodes", req); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a multi-line version to be rejected, got %d", w.Code)
+	}
+}
+
+func TestUpdateNodeStatuses(t *testing.T) {
+	env := newRBACTestEnv(t)
+
+	var ids []uuid.UUID
+	for i, name := range []string{"hub-1", "hub-2"} {
+		req := types.NodeRegistrationRequest{
+			Name:      name,
+			NodeType:  "hub",
+			PublicKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{byte(i + 1)}, 32)),
+		}
+		w := env.serve(models.UserRoleOperator, http.MethodPost, "/api/v1/nodes", req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("failed to register %s: %d %s", name, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Data models.Node `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode node: %v", err)
+		}
+		ids = append(ids, resp.Data.ID)
+	}
+
+	for _, tc := range []struct {
+		role     models.UserRole
+		body     interface{}
+		expected int
+	}{
+		{models.UserRoleUser, types.NodeStatusUpdateRequest{NodeIDs: ids, Status: "disabled"}, http.StatusForbidden},
+		{models.UserRoleOperator, types.NodeStatusUpdateRequest{NodeIDs: ids, Status: "retired"}, http.StatusBadRequest},
+		{models.UserRoleOperator, types.NodeStatusUpdateRequest{Status: "disabled"}, http.StatusBadRequest},
+		{models.UserRoleOperator, types.NodeStatusUpdateRequest{NodeIDs: append(ids, uuid.New()), Status: "disabled"}, http.StatusNotFound},
+		{models.UserRoleOperator, types.NodeStatusUpdateRequest{NodeIDs: ids, Status: "disabled"}, http.StatusOK},
+	} {
+		if w := env.serve(tc.role, http.MethodPatch, "/api/v1/nodes/status", tc.body); w.Code != tc.expected {
+			t.Errorf("%s PATCH /nodes/status %+v: expected %d, got %d: %s", tc.role, tc.body, tc.expected, w.Code, w.Body.String())
+		}
+	}
+
+	var disabled int64
+	env.db.Model(&models.Node{}).Where("status = ?", models.NodeStatusDisabled).Count(&disabled)
+	if disabled != 2 {
+		t.Errorf("expected both nodes disabled, got %d", disabled)
+	}
+}
+
+func TestOperatorCannotDeleteUsers(t *testing.T) {
+	env := newRBACTestEnv(t)
+	target := env.users[models.UserRoleUser]
+	path := "/api/v1/users/" + target.ID.String()
+
+	if w := env.serve(models.UserRoleOperator, http.MethodDelete, path, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("expected operator to be forbidden from deleting users, got %d", w.Code)
+	}
+	var count int64
+	env.db.Model(&models.User{}).Where("id = ?", target.ID).Count(&count)
+	if count != 1 {
+		t.Fatal("expected the user to survive the forbidden delete")
+	}
+
+	if w := env.serve(models.UserRoleAdmin, http.MethodDelete, path, nil); w.Code != http.StatusOK {
+		t.Errorf("expected admin to delete the user, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestObserverIsReadOnly(t *testing.T) {
+	env := newRBACTestEnv(t)
+	node := models.Node{Name: "hub-1", NodeType: models.NodeTypeHub, PublicKey: "hub-key", AllocatedIP: "10.100.0.1", Status: models.NodeStatusActive}
+	if err := env.db.Omit("AllowedIPs", "BackupHubIDs", "Routes", "PreUp", "PostUp", "PreDown", "PostDown").Create(&node).Error; err != nil {
+		t.Fatalf("failed to create node: %v", err)
+	}
+	nodePath := "/api/v1/nodes/" + node.ID.String()
+	description := "changed"
+
+	for _, path := range []string{"/api/v1/nodes", "/api/v1/audit/logs"} {
+		if w := env.serve(models.UserRoleObserver, http.MethodGet, path, nil); w.Code != http.StatusOK {
+			t.Errorf("expected observer to read %s, got %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPost, "/api/v1/nodes", types.NodeRegistrationRequest{Name: "spoke-1", NodeType: "spoke", PublicKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))}},
+		{http.MethodPut, nodePath, types.NodeUpdateRequest{Description: &description}},
+		{http.MethodDelete, nodePath, nil},
+		{http.MethodPatch, "/api/v1/nodes/status", map[string]interface{}{"node_ids": []string{node.ID.String()}, "status": "disabled"}},
+		{http.MethodDelete, "/api/v1/users/" + env.users[models.UserRoleUser].ID.String(), nil},
+		{http.MethodPost, "/api/v1/config/import", map[string]interface{}{}},
+	} {
+		if w := env.serve(models.UserRoleObserver, tc.method, tc.path, tc.body); w.Code != http.StatusForbidden {
+			t.Errorf("expected observer %s %s to be forbidden, got %d", tc.method, tc.path, w.Code)
+		}
+	}
+
+	var stored models.Node
+	if err := env.db.Select("name", "description", "status").Where("id = ?", node.ID).First(&stored).Error; err != nil {
+		t.Fatalf("expected the node to survive: %v", err)
+	}
+	if stored.Description != "" || stored.Status != models.NodeStatusActive {
+		t.Errorf("expected the node to be unchanged, got %+v", stored)
+	}
+	var users int64
+	env.db.Model(&models.User{}).Count(&users)
+	if users != int64(len(env.users)) {
+		t.Errorf("expected no users to be deleted, got %d of %d", users, len(env.users))
+	}
+
+	// Other roles still get past the read-only check
+	if w := env.serve(models.UserRoleOperator, http.MethodPut, nodePath, types.NodeUpdateRequest{Description: &description}); w.Code != http.StatusOK {
+		t.Errorf("expected operator to update the node, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// newPasswordChangeTestRouter serves login, change-password and the node
+// routes behind the real authentication middleware, with only the default
+// admin seeded.
+func newPasswordChangeTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+
+	db := newRBACTestDB(t)
+	if err := db.AutoMigrate(&services.RefreshToken{}); err != nil {
+		t.Fatalf("failed to migrate refresh_tokens: %v", err)
+	}
+
+	config := &types.Config{
+		Auth: types.AuthConfig{JWTSecret: "password-change-test-secret", JWTExpiration: 15 * time.Minute},
+		WG:   types.WGConfig{Subnet: "10.100.0.0/16"},
+	}
+	auditService := services.NewAuditService(db)
+	authService := services.NewAuthService(db, config, auditService)
+	if err := authService.InitializeDefaultAdmin(); err != nil {
+		t.Fatalf("InitializeDefaultAdmin failed: %v", err)
+	}
+	authHandler := NewAuthHandler(authService)
+	nodesHandler := NewNodesHandler(services.NewNodeService(db, config, auditService), nil, authService, services.NewApprovalService(db, config, auditService))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/login", authHandler.Login)
+	router.POST("/auth/change-password", authHandler.AuthMiddleware(), authHandler.ChangePassword)
+	v1 := router.Group("/api/v1")
+	v1.Use(authHandler.AuthMiddleware())
+	v1.GET("/nodes", nodesHandler.GetNodes)
+	return router
+}
+
+func serveJSON(router *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestDefaultAdminMustChangePassword(t *testing.T) {
+	router := newPasswordChangeTestRouter(t)
+
+	w := serveJSON(router, http.MethodPost, "/auth/login", "", map[string]string{"username": "admin", "password": "admin123"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the default admin to log in, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data services.LoginResponse `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode login response: %v", err)
+	}
+	if !resp.Data.MustChangePassword {
+		t.Fatal("expected the login response to require a password change")
+	}
+	token := resp.Data.Token
+
+	if w := serveJSON(router, http.MethodGet, "/api/v1/nodes", token, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("expected protected routes to be refused before the change, got %d", w.Code)
+	}
+
+	change := map[string]string{"current_password": "admin123", "new_password": "N3w-Passw0rd!"}
+	if w := serveJSON(router, http.MethodPost, "/auth/change-password", "", change); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected change-password to require a token, got %d", w.Code)
+	}
+	if w := serveJSON(router, http.MethodPost, "/auth/change-password", token, change); w.Code != http.StatusOK {
+		t.Fatalf("expected the password change to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The same token now reaches protected routes
+	if w := serveJSON(router, http.MethodGet, "/api/v1/nodes", token, nil); w.Code != http.StatusOK {
+		t.Errorf("expected protected routes after the change, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(router, http.MethodPost, "/auth/login", "", map[string]string{"username": "admin", "password": "admin123"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the default password to stop working, got %d", w.Code)
+	}
+}
+
+// newClientCertTestRouter serves an agent route behind AuthMiddleware with
+// client certificates validated by the security service.
+func newClientCertTestRouter(t *testing.T, requireClientCert bool) (*gin.Engine, *services.AuthService) {
+	t.Helper()
+
+	db := newSecurityTestDB(t)
+	config := &types.Config{
+		Server: types.ServerConfig{TLS: types.TLSConfig{RequireClientCert: requireClientCert}},
+		Auth:   types.AuthConfig{JWTSecret: securityTestJWTSecret, NodeTokenExpiration: time.Hour},
+	}
+	auditService := services.NewAuditService(db)
+	authService := services.NewAuthService(db, config, auditService)
+	authService.SetSecurityService(services.NewSecurityService(db, config, auditService))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	v1 := router.Group("/api/v1")
+	v1.Use(NewAuthHandler(authService).AuthMiddleware())
+	v1.GET("/nodes/:id/config", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router, authService
+}
+
+func clientCertificate(t *testing.T, commonName string, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func serveWithClientCert(router *gin.Engine, path string, cert *x509.Certificate, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cert != nil {
+		// As left by a handshake that verified cert against the client CAs
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert},
+			VerifiedChains:   [][]*x509.Certificate{{cert}},
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthMiddlewareClientCertificate(t *testing.T) {
+	router, _ := newClientCertTestRouter(t, false)
+	nodeID := uuid.New()
+	path := "/api/v1/nodes/" + nodeID.String() + "/config"
+
+	valid := clientCertificate(t, nodeID.String(), time.Now().Add(time.Hour))
+	if w := serveWithClientCert(router, path, valid, ""); w.Code != http.StatusOK {
+		t.Errorf("expected a valid client certificate to authenticate its node, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveWithClientCert(router, "/api/v1/nodes/"+uuid.NewString()+"/config", valid, ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected another node's config to be refused, got %d", w.Code)
+	}
+
+	expired := clientCertificate(t, nodeID.String(), time.Now().Add(-time.Minute))
+	if w := serveWithClientCert(router, path, expired, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected an expired client certificate to be rejected, got %d", w.Code)
+	}
+
+	// Certificates naming no node leave authentication to the token
+	if w := serveWithClientCert(router, path, clientCertificate(t, "alice", time.Now().Add(time.Hour)), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a request without a token to need one, got %d", w.Code)
+	}
+}
+
+func TestAuthMiddlewareRequiresClientCertificateForAgents(t *testing.T) {
+	router, authService := newClientCertTestRouter(t, true)
+	node := &models.Node{ID: uuid.New(), Name: "spoke-1"}
+	token, _, err := authService.GenerateNodeToken(context.Background(), node, nil)
+	if err != nil {
+		t.Fatalf("GenerateNodeToken failed: %v", err)
+	}
+	path := "/api/v1/nodes/" + node.ID.String() + "/config"
+
+	if w := serveWithClientCert(router, path, nil, token); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected an agent token alone to be refused, got %d", w.Code)
+	}
+	cert := clientCertificate(t, node.ID.String(), time.Now().Add(time.Hour))
+	if w := serveWithClientCert(router, path, cert, token); w.Code != http.StatusOK {
+		t.Errorf("expected an agent with its certificate to be let through, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAgentMayOnlyUpdateReportedFields(t *testing.T) {
+	db := newRBACTestDB(t)
+	config := &types.Config{WG: types.WGConfig{Subnet: "10.100.0.0/16", MTU: 1420}}
+	auditService := services.NewAuditService(db)
+	nodeService := services.NewNodeService(db, config, auditService)
+	nodesHandler := NewNodesHandler(nodeService, nil, services.NewAuthService(db, config, auditService), services.NewApprovalService(db, config, auditService))
+
+	node, err := nodeService.RegisterNode(context.Background(), types.NodeRegistrationRequest{
+		Name:      "spoke-1",
+		NodeType:  "spoke",
+		PublicKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)),
+	})
+	if err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PUT("/api/v1/nodes/:id", func(c *gin.Context) {
+		// As set by AuthMiddleware for the node's agent token
+		c.Set("current_node", node.ID)
+		c.Next()
+	}, nodesHandler.UpdateNode)
+	path := "/api/v1/nodes/" + node.ID.String()
+
+	active, disabled, name, port := "active", "disabled", "renamed", 51821
+	endpoint := "spoke-1.example.com"
+
+	// A pending node waits for an operator rather than approving itself
+	if w := serveJSON(router, http.MethodPut, path, "", types.NodeUpdateRequest{Status: &active}); w.Code != http.StatusForbidden {
+		t.Errorf("expected a pending node's agent to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := db.Model(&models.Node{}).Where("id = ?", node.ID).Update("status", models.NodeStatusInactive).Error; err != nil {
+		t.Fatalf("failed to approve node: %v", err)
+	}
+
+	if w := serveJSON(router, http.MethodPut, path, "", types.NodeUpdateRequest{Status: &active, Endpoint: &endpoint, Port: &port}); w.Code != http.StatusOK {
+		t.Fatalf("expected the agent to report its status and endpoint, got %d: %s", w.Code, w.Body.String())
+	}
+
+	for field, req := range map[string]types.NodeUpdateRequest{
+		"name":     {Status: &active, Name: &name},
+		"routes":   {Routes: []string{"192.168.10.0/24"}},
+		"tags":     {Tags: map[string]string{"site": "lab"}},
+		"hooks":    {InterfaceHooks: types.InterfaceHooks{PostUp: []string{"iptables -F"}}},
+		"disabled": {Status: &disabled},
+	} {
+		if w := serveJSON(router, http.MethodPut, path, "", req); w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected an agent update to be refused, got %d: %s", field, w.Code, w.Body.String())
+		}
+	}
+
+	var stored models.Node
+	if err := db.First(&stored, "id = ?", node.ID).Error; err != nil {
+		t.Fatalf("failed to load node: %v", err)
+	}
+	if stored.Name != "spoke-1" || stored.Status != models.NodeStatusActive || stored.Port != port {
+		t.Errorf("expected only the reported fields to change, got name %q status %q port %d", stored.Name, stored.Status, stored.Port)
+	}
+
+	// Nor does a heartbeat re-enable a node an operator disabled
+	if err := db.Model(&models.Node{}).Where("id = ?", node.ID).Update("status", models.NodeStatusDisabled).Error; err != nil {
+		t.Fatalf("failed to disable node: %v", err)
+	}
+	if w := serveJSON(router, http.MethodPut, path, "", types.NodeUpdateRequest{Status: &active}); w.Code != http.StatusForbidden {
+		t.Errorf("expected a disabled node's heartbeat to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := db.First(&stored, "id = ?", node.ID).Error; err != nil {
+		t.Fatalf("failed to load node: %v", err)
+	}
+	if stored.Status != models.NodeStatusDisabled {
+		t.Errorf("expected the node to stay disabled, got %s", stored.Status)
+	}
+}
+
+func TestRotateNodeKeyWaitsForApproval(t *testing.T) {
+	db := newRBACTestDB(t)
+	if err := db.AutoMigrate(&services.ApprovalRequest{}); err != nil {
+		t.Fatalf("failed to migrate approval_requests: %v", err)
+	}
+	operator := &models.User{Username: "operator", Email: "operator@example.com", Password: "x", Role: models.UserRoleOperator, IsActive: true}
+	if err := db.Create(operator).Error; err != nil {
+		t.Fatalf("failed to create operator: %v", err)
+	}
+
+	config := &types.Config{
+		Auth: types.AuthConfig{RequireDualApproval: true},
+		WG:   types.WGConfig{Subnet: "10.100.0.0/16", MTU: 1420},
+	}
+	auditService := services.NewAuditService(db)
+	nodeService := services.NewNodeService(db, config, auditService)
+	approvalService := services.NewApprovalService(db, config, auditService)
+	nodeService.RegisterApprovalActions(approvalService)
+	nodesHandler := NewNodesHandler(nodeService, nil, services.NewAuthService(db, config, auditService), approvalService)
+
+	node, err := nodeService.RegisterNode(context.Background(), types.NodeRegistrationRequest{
+		Name:      "spoke-1",
+		NodeType:  "spoke",
+		PublicKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{9}, 32)),
+	})
+	if err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/v1/nodes/:id/rotate-key", func(c *gin.Context) {
+		c.Set("current_user", operator)
+		c.Next()
+	}, nodesHandler.RotateNodeKey)
+
+	w := serveJSON(router, http.MethodPost, "/api/v1/nodes/"+node.ID.String()+"/rotate-key", "", nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data services.ApprovalRequest `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Data.Action != services.ApprovalActionRotateNodeKey || resp.Data.Status != services.ApprovalStatusPending {
+		t.Errorf("expected a pending node.rekey request, got %+v", resp.Data)
+	}
+
+	stored, err := nodeService.GetNode(context.Background(), node.ID)
+	if err != nil {
+		t.Fatalf("GetNode failed: %v", err)
+	}
+	if stored.KeyRotationRequestedAt != nil {
+		t.Error("expected no rotation before a second admin approves")
+	}
+
+	if w := serveJSON(router, http.MethodPost, "/api/v1/nodes/"+uuid.New().String()+"/rotate-key", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown node, got %d", w.Code)
+	}
+}
+
+func TestReplaceNodePublicKeyWaitsForApproval(t *testing.T) {
+	db := newRBACTestDB(t)
+	if err := db.AutoMigrate(&services.ApprovalRequest{}); err != nil {
+		t.Fatalf("failed to migrate approval_requests: %v", err)
+	}
+	operator := &models.User{Username: "operator", Email: "operator@example.com", Password: "x", Role: models.UserRoleOperator, IsActive: true}
+	if err := db.Create(operator).Error; err != nil {
+		t.Fatalf("failed to create operator: %v", err)
+	}
+
+	config := &types.Config{
+		Auth: types.AuthConfig{RequireDualApproval: true},
+		WG:   types.WGConfig{Subnet: "10.100.0.0/16", MTU: 1420},
+	}
+	auditService := services.NewAuditService(db)
+	nodeService := services.NewNodeService(db, config, auditService)
+	approvalService := services.NewApprovalService(db, config, auditService)
+	nodeService.RegisterApprovalActions(approvalService)
+	nodesHandler := NewNodesHandler(nodeService, nil, services.NewAuthService(db, config, auditService), approvalService)
+
+	originalKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{9}, 32))
+	node, err := nodeService.RegisterNode(context.Background(), types.NodeRegistrationRequest{
+		Name:      "spoke-1",
+		NodeType:  "spoke",
+		PublicKey: originalKey,
+	})
+	if err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PUT("/api/v1/nodes/:id/public-key", func(c *gin.Context) {
+		// As set by AuthMiddleware for an operator or the node's agent
+		if c.GetHeader("Authorization") == "Bearer agent" {
+			c.Set("current_node", node.ID)
+		} else {
+			c.Set("current_user", operator)
+		}
+		c.Next()
+	}, nodesHandler.UpdateNodePublicKey)
+	path := "/api/v1/nodes/" + node.ID.String() + "/public-key"
+	operatorKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{10}, 32))
+
+	w := serveJSON(router, http.MethodPut, path, "", types.NodePublicKeyRequest{PublicKey: operatorKey})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected an operator's key to wait for approval with 202, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data services.ApprovalRequest `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Data.Action != services.ApprovalActionSetNodeKey || resp.Data.Status != services.ApprovalStatusPending {
+		t.Errorf("expected a pending node.public_key request, got %+v", resp.Data)
+	}
+	stored, err := nodeService.GetNode(context.Background(), node.ID)
+	if err != nil {
+		t.Fatalf("GetNode failed: %v", err)
+	}
+	if stored.PublicKey != originalKey {
+		t.Error("expected the key to stay until a second admin approves")
+	}
+
+	// The node's own agent completes a rotation without approval
+	agentKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{11}, 32))
+	if w := serveJSON(router, http.MethodPut, path, "agent", types.NodePublicKeyRequest{PublicKey: agentKey}); w.Code != http.StatusOK {
+		t.Fatalf("expected the agent's key to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	if stored, err = nodeService.GetNode(context.Background(), node.ID); err != nil || stored.PublicKey != agentKey {
+		t.Errorf("expected the agent's key to be stored, got %v, %v", stored, err)
+	}
+}
+
+func TestObserverCannotQueueOrReadApprovals(t *testing.T) {
+	db := newRBACTestDB(t)
+	if err := db.AutoMigrate(&services.ApprovalRequest{}); err != nil {
+		t.Fatalf("failed to migrate approval_requests: %v", err)
+	}
+	users := make(map[models.UserRole]*models.User)
+	for _, role := range []models.UserRole{models.UserRoleAdmin, models.UserRoleObserver} {
+		user := &models.User{Username: string(role), Email: string(role) + "@example.com", Password: "x", Role: role, IsActive: true}
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create %s user: %v", role, err)
+		}
+		users[role] = user
+	}
+
+	config := &types.Config{Auth: types.AuthConfig{RequireDualApproval: true}}
+	auditService := services.NewAuditService(db)
+	authService := services.NewAuthService(db, config, auditService)
+	approvalService := services.NewApprovalService(db, config, auditService)
+	configService := services.NewConfigService(db, auditService)
+	configService.RegisterApprovalActions(approvalService)
+	configHandler := NewConfigHandler(configService, authService, approvalService)
+	approvalHandler := NewApprovalHandler(approvalService, authService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	v1 := router.Group("/api/v1")
+	v1.Use(func(c *gin.Context) {
+		c.Set("current_user", users[models.UserRole(c.GetHeader("X-Test-Role"))])
+		c.Next()
+	})
+	v1.Use(NewAuthHandler(authService).ReadOnlyMiddleware())
+	v1.GET("/config/export", configHandler.ExportConfiguration)
+	v1.GET("/config/backup", configHandler.GenerateBackup)
+	v1.GET("/approvals", approvalHandler.GetApprovals)
+	v1.GET("/approvals/:id", approvalHandler.GetApproval)
+
+	serve := func(role models.UserRole, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Test-Role", string(role))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Queuing an approval is a write even though exports are GETs
+	for _, path := range []string{"/api/v1/config/export", "/api/v1/config/backup"} {
+		if w := serve(models.UserRoleObserver, path); w.Code != http.StatusForbidden {
+			t.Errorf("expected observer GET %s to be forbidden, got %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+	var count int64
+	db.Model(&services.ApprovalRequest{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected observers to queue no approvals, got %d", count)
+	}
+
+	w := serve(models.UserRoleAdmin, "/api/v1/config/export")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected admin export to be queued, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data services.ApprovalRequest `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	// Approvals can carry exported configuration, so observers can't read them
+	for _, path := range []string{"/api/v1/approvals", "/api/v1/approvals/" + resp.Data.ID.String()} {
+		if w := serve(models.UserRoleObserver, path); w.Code != http.StatusForbidden {
+			t.Errorf("expected observer GET %s to be forbidden, got %d: %s", path, w.Code, w.Body.String())
+		}
+		if w := serve(models.UserRoleAdmin, path); w.Code != http.StatusOK {
+			t.Errorf("expected admin GET %s to succeed, got %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -12,14 +14,16 @@ import (
 )
 
 type BackupHandler struct {
-	backupService *services.BackupService
-	authService   *services.AuthService
+	backupService   *services.BackupService
+	authService     *services.AuthService
+	approvalService *services.ApprovalService
 }
 
-func NewBackupHandler(backupService *services.BackupService, authService *services.AuthService) *BackupHandler {
+func NewBackupHandler(backupService *services.BackupService, authService *services.AuthService, approvalService *services.ApprovalService) *BackupHandler {
 	return &BackupHandler{
-		backupService: backupService,
-		authService:   authService,
+		backupService:   backupService,
+		authService:     authService,
+		approvalService: approvalService,
 	}
 }
 
@@ -204,12 +208,13 @@ func (h *BackupHandler) GetBackup(c *gin.Context) {
 
 // RestoreBackup godoc
 // @Summary Restore database backup
-// @Description Restore database from a backup (admin only)
+// @Description Restore database from a backup (admin only). With REQUIRE_DUAL_APPROVAL the restore is queued until another admin approves it
 // @Tags backup
 // @Accept json
 // @Produce json
 // @Param restore body services.RestoreOptions true "Restore options"
 // @Success 200 {object} types.APIResponse{data=services.RestoreResult}
+// @Success 202 {object} types.APIResponse{data=services.ApprovalRequest}
 // @Failure 400 {object} types.APIResponse
 // @Failure 403 {object} types.APIResponse
 // @Failure 500 {object} types.APIResponse
@@ -247,6 +252,12 @@ func (h *BackupHandler) RestoreBackup(c *gin.Context) {
 		options.RestoreType = "full"
 	}
 
+	if h.approvalService.Required() {
+		requestApproval(c, h.approvalService, services.ApprovalActionRestoreBackup, options, user,
+			fmt.Sprintf("Restore backup %s (%s)", options.BackupID, options.RestoreType))
+		return
+	}
+
 	result, err := h.backupService.RestoreBackup(c.Request.Context(), options, user.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
@@ -271,14 +282,81 @@ func (h *BackupHandler) RestoreBackup(c *gin.Context) {
 	}
 }
 
+// VerifyBackup godoc
+// @Summary Verify backup integrity
+// @Description Recompute the SHA-256 checksum and size of a stored backup file and compare them with the values recorded at creation (admin only)
+// @Tags backup
+// @Accept json
+// @Produce json
+// @Param id path string true "Backup ID"
+// @Success 200 {object} types.APIResponse{data=services.BackupVerification}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /backup/{id}/verify [post]
+func (h *BackupHandler) VerifyBackup(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid backup ID format",
+		})
+		return
+	}
+
+	verification, err := h.backupService.VerifyBackup(c.Request.Context(), id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrBackupNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	message := "Backup checksum matches"
+	if !verification.Match {
+		message = "Backup checksum does not match"
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    verification,
+		Message: message,
+	})
+}
+
 // DeleteBackup godoc
 // @Summary Delete backup
-// @Description Delete a backup and its associated file (admin only)
+// @Description Delete a backup and its associated file (admin only). With REQUIRE_DUAL_APPROVAL the deletion is queued until another admin approves it
 // @Tags backup
 // @Accept json
 // @Produce json
 // @Param id path string true "Backup ID"
 // @Success 200 {object} types.APIResponse
+// @Success 202 {object} types.APIResponse{data=services.ApprovalRequest}
 // @Failure 400 {object} types.APIResponse
 // @Failure 403 {object} types.APIResponse
 // @Failure 404 {object} types.APIResponse
@@ -312,6 +390,12 @@ func (h *BackupHandler) DeleteBackup(c *gin.Context) {
 		return
 	}
 
+	if h.approvalService.Required() {
+		requestApproval(c, h.approvalService, services.ApprovalActionDeleteBackup,
+			map[string]interface{}{"backup_id": id}, user, fmt.Sprintf("Delete backup %s", id))
+		return
+	}
+
 	err = h.backupService.DeleteBackup(c.Request.Context(), id, user.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
@@ -329,12 +413,12 @@ func (h *BackupHandler) DeleteBackup(c *gin.Context) {
 
 // ScheduleBackup godoc
 // @Summary Schedule automatic backup
-// @Description Schedule automatic backup with cron expression (admin only)
+// @Description Schedule automatic backup with a cron expression such as "0 2 * * *", "@daily" or "@every 6h". Schedules are persisted and only run on the HA leader (admin only)
 // @Tags backup
 // @Accept json
 // @Produce json
 // @Param schedule body map[string]interface{} true "Schedule options"
-// @Success 200 {object} types.APIResponse
+// @Success 200 {object} types.APIResponse{data=services.BackupSchedule}
 // @Failure 400 {object} types.APIResponse
 // @Failure 403 {object} types.APIResponse
 // @Failure 500 {object} types.APIResponse
@@ -371,9 +455,13 @@ func (h *BackupHandler) ScheduleBackup(c *gin.Context) {
 		return
 	}
 
-	err := h.backupService.ScheduleBackup(c.Request.Context(), request.Schedule, request.Options, user.ID)
+	schedule, err := h.backupService.ScheduleBackup(c.Request.Context(), request.Schedule, request.Options, user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, types.APIResponse{
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidBackupSchedule) || errors.Is(err, services.ErrBackupKeyMissing) || errors.Is(err, services.ErrBackupKeyInvalid) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, types.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -382,10 +470,113 @@ func (h *BackupHandler) ScheduleBackup(c *gin.Context) {
 
 	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
+		Data:    schedule,
 		Message: "Backup scheduled successfully",
 	})
 }
 
+// GetBackupSchedules godoc
+// @Summary List backup schedules
+// @Description List persisted backup schedules with their next and last run (admin only)
+// @Tags backup
+// @Accept json
+// @Produce json
+// @Success 200 {object} types.APIResponse{data=[]services.BackupSchedule}
+// @Failure 403 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /backup/schedules [get]
+func (h *BackupHandler) GetBackupSchedules(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	schedules, err := h.backupService.GetSchedules(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    schedules,
+	})
+}
+
+// DeleteBackupSchedule godoc
+// @Summary Delete backup schedule
+// @Description Stop and remove a backup schedule (admin only)
+// @Tags backup
+// @Accept json
+// @Produce json
+// @Param id path string true "Schedule ID"
+// @Success 200 {object} types.APIResponse
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Router /backup/schedules/{id} [delete]
+func (h *BackupHandler) DeleteBackupSchedule(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid schedule ID format",
+		})
+		return
+	}
+
+	if err := h.backupService.DeleteSchedule(c.Request.Context(), id, user.ID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrBackupScheduleNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "Backup schedule deleted successfully",
+	})
+}
+
 // GetBackupStats godoc
 // @Summary Get backup statistics
 // @Description Get backup system statistics (admin only)
@@ -1,8 +1,12 @@
 package api
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -12,25 +16,31 @@ import (
 )
 
 type ConfigHandler struct {
-	configService *services.ConfigService
-	authService   *services.AuthService
+	configService   *services.ConfigService
+	authService     *services.AuthService
+	approvalService *services.ApprovalService
 }
 
-func NewConfigHandler(configService *services.ConfigService, authService *services.AuthService) *ConfigHandler {
+func NewConfigHandler(configService *services.ConfigService, authService *services.AuthService, approvalService *services.ApprovalService) *ConfigHandler {
 	return &ConfigHandler{
-		configService: configService,
-		authService:   authService,
+		configService:   configService,
+		authService:     authService,
+		approvalService: approvalService,
 	}
 }
 
 // ExportConfiguration godoc
 // @Summary Export system configuration
-// @Description Export complete system configuration including nodes, policies, and users (admin only)
+// @Description Export system configuration including nodes, policies, users and topology, or only the requested sections (admin only)
 // @Tags config
 // @Accept json
 // @Produce json
 // @Param format query string false "Export format (json/yaml)" default(json)
+// @Param include query string false "Comma-separated sections to export (nodes,policies,users,topology); all by default"
+// @Param compress query boolean false "Download a gzip-compressed file" default(false)
 // @Success 200 {object} types.APIResponse{data=string} "Base64 encoded configuration data"
+// @Success 202 {object} types.APIResponse{data=services.ApprovalRequest} "Export queued for approval when REQUIRE_DUAL_APPROVAL is set"
+// @Failure 400 {object} types.APIResponse
 // @Failure 403 {object} types.APIResponse
 // @Failure 500 {object} types.APIResponse
 // @Router /config/export [get]
@@ -62,7 +72,26 @@ func (h *ConfigHandler) ExportConfiguration(c *gin.Context) {
 		return
 	}
 
-	data, err := h.configService.ExportConfiguration(c.Request.Context(), user.ID, format)
+	sections, err := services.ParseExportSections(c.Query("include"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if h.approvalService.Required() {
+		description := fmt.Sprintf("Export full configuration as %s", format)
+		if sections != nil {
+			description = fmt.Sprintf("Export %s as %s", strings.Join(sections, ", "), format)
+		}
+		requestApproval(c, h.approvalService, services.ApprovalActionExportConfig,
+			map[string]interface{}{"format": format, "sections": sections}, user, description)
+		return
+	}
+
+	data, err := h.configService.ExportConfiguration(c.Request.Context(), user.ID, format, sections)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
@@ -71,11 +100,7 @@ func (h *ConfigHandler) ExportConfiguration(c *gin.Context) {
 		return
 	}
 
-	// Set appropriate headers for download
-	filename := "wg-sdwan-config." + format
-	c.Header("Content-Disposition", "attachment; filename="+filename)
-	c.Header("Content-Type", "application/octet-stream")
-	c.Data(http.StatusOK, "application/octet-stream", data)
+	writeConfigDownload(c, "wg-sdwan-config."+format, data)
 }
 
 // ImportConfiguration godoc
@@ -90,6 +115,7 @@ func (h *ConfigHandler) ExportConfiguration(c *gin.Context) {
 // @Param skip_users formData boolean false "Skip importing users" default(false)
 // @Param skip_nodes formData boolean false "Skip importing nodes" default(false)
 // @Param skip_policies formData boolean false "Skip importing policies" default(false)
+// @Param dry_run formData boolean false "Report the import result without saving anything" default(false)
 // @Success 200 {object} types.APIResponse{data=services.ImportResult}
 // @Failure 400 {object} types.APIResponse
 // @Failure 403 {object} types.APIResponse
@@ -136,8 +162,8 @@ func (h *ConfigHandler) ImportConfiguration(c *gin.Context) {
 	defer src.Close()
 
 	// Read file content
-	data := make([]byte, file.Size)
-	if _, err := src.Read(data); err != nil {
+	data, err := io.ReadAll(src)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error:   "Failed to read file content",
@@ -151,12 +177,14 @@ func (h *ConfigHandler) ImportConfiguration(c *gin.Context) {
 	skipUsers, _ := strconv.ParseBool(c.DefaultPostForm("skip_users", "false"))
 	skipNodes, _ := strconv.ParseBool(c.DefaultPostForm("skip_nodes", "false"))
 	skipPolicies, _ := strconv.ParseBool(c.DefaultPostForm("skip_policies", "false"))
+	dryRun, _ := strconv.ParseBool(c.DefaultPostForm("dry_run", "false"))
 
 	options := services.ImportOptions{
 		OverwriteExisting: overwriteExisting,
 		SkipUsers:         skipUsers,
 		SkipNodes:         skipNodes,
 		SkipPolicies:      skipPolicies,
+		DryRun:            dryRun,
 		ImportedBy:        user.ID,
 	}
 
@@ -171,10 +199,14 @@ func (h *ConfigHandler) ImportConfiguration(c *gin.Context) {
 	}
 
 	if result.Success {
+		message := "Configuration imported successfully"
+		if result.DryRun {
+			message = "Configuration import dry run completed, nothing was saved"
+		}
 		c.JSON(http.StatusOK, types.APIResponse{
 			Success: true,
 			Data:    result,
-			Message: "Configuration imported successfully",
+			Message: message,
 		})
 	} else {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
@@ -238,8 +270,8 @@ func (h *ConfigHandler) ValidateConfiguration(c *gin.Context) {
 	defer src.Close()
 
 	// Read file content
-	data := make([]byte, file.Size)
-	if _, err := src.Read(data); err != nil {
+	data, err := io.ReadAll(src)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
 			Error:   "Failed to read file content",
@@ -265,6 +297,87 @@ func (h *ConfigHandler) ValidateConfiguration(c *gin.Context) {
 	})
 }
 
+// DiffConfiguration godoc
+// @Summary Diff configuration file against live configuration
+// @Description Show the nodes, policies, users and topology an import would add, remove or modify, with field-level changes (admin only)
+// @Tags config
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "Configuration file (JSON or YAML)"
+// @Param format formData string false "File format (json/yaml)" default(json)
+// @Success 200 {object} types.APIResponse{data=services.ConfigDiff}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /config/diff [post]
+func (h *ConfigHandler) DiffConfiguration(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "No file uploaded",
+		})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Failed to open uploaded file",
+		})
+		return
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Failed to read file content",
+		})
+		return
+	}
+
+	format := c.DefaultPostForm("format", "json")
+
+	diff, err := h.configService.DiffConfiguration(c.Request.Context(), data, format)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidConfiguration) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    diff,
+	})
+}
+
 // GetConfigurationSummary godoc
 // @Summary Get configuration summary
 // @Description Get summary of current system configuration (admin only)
@@ -316,7 +429,9 @@ func (h *ConfigHandler) GetConfigurationSummary(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param format query string false "Backup format (json/yaml)" default(json)
+// @Param compress query boolean false "Download a gzip-compressed file" default(false)
 // @Success 200 {object} types.APIResponse{data=string} "Base64 encoded backup data"
+// @Success 202 {object} types.APIResponse{data=services.ApprovalRequest} "Export queued for approval when REQUIRE_DUAL_APPROVAL is set"
 // @Failure 403 {object} types.APIResponse
 // @Failure 500 {object} types.APIResponse
 // @Router /config/backup [get]
@@ -348,7 +463,13 @@ func (h *ConfigHandler) GenerateBackup(c *gin.Context) {
 		return
 	}
 
-	data, err := h.configService.ExportConfiguration(c.Request.Context(), user.ID, format)
+	if h.approvalService.Required() {
+		requestApproval(c, h.approvalService, services.ApprovalActionExportConfig,
+			map[string]interface{}{"format": format}, user, fmt.Sprintf("Export full configuration as %s", format))
+		return
+	}
+
+	data, err := h.configService.ExportConfiguration(c.Request.Context(), user.ID, format, nil)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
@@ -357,9 +478,317 @@ func (h *ConfigHandler) GenerateBackup(c *gin.Context) {
 		return
 	}
 
+	writeConfigDownload(c, "wg-sdwan-backup-"+uuid.New().String()[:8]+"."+format, data)
+}
+
+// writeConfigDownload sends an exported configuration as a file download.
+// With compress=true the file itself is gzipped (and named .gz); otherwise a
+// client that accepts gzip gets it compressed in transit.
+func writeConfigDownload(c *gin.Context, filename string, data []byte) {
+	compress, _ := strconv.ParseBool(c.DefaultQuery("compress", "false"))
+	transport := !compress && acceptsGzip(c.GetHeader("Accept-Encoding"))
+	if compress || transport {
+		compressed, err := services.CompressConfiguration(data)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		data = compressed
+	}
+
+	contentType := "application/octet-stream"
+	if compress {
+		filename += ".gz"
+		contentType = "application/gzip"
+	}
+	if transport {
+		c.Header("Content-Encoding", "gzip")
+	}
+	c.Header("Vary", "Accept-Encoding")
+
 	// Set appropriate headers for download
-	filename := "wg-sdwan-backup-" + uuid.New().String()[:8] + "." + format
 	c.Header("Content-Disposition", "attachment; filename="+filename)
-	c.Header("Content-Type", "application/octet-stream")
-	c.Data(http.StatusOK, "application/octet-stream", data)
+	c.Header("Content-Type", contentType)
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, coding := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		// gzip;q=0 explicitly refuses it
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			quality, err := strconv.ParseFloat(value, 64)
+			return err == nil && quality > 0
+		}
+		return true
+	}
+	return false
+}
+
+// GetConfigVersions godoc
+// @Summary List configuration versions
+// @Description List stored configuration snapshots, newest first (admin only)
+// @Tags config
+// @Accept json
+// @Produce json
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page" default(10)
+// @Success 200 {object} types.PaginatedResponse{data=[]services.ConfigVersion}
+// @Failure 403 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /config/versions [get]
+func (h *ConfigHandler) GetConfigVersions(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "10"))
+
+	versions, total, err := h.configService.GetVersions(c.Request.Context(), page, perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	totalPages := int((total + int64(perPage) - 1) / int64(perPage))
+
+	c.JSON(http.StatusOK, types.PaginatedResponse{
+		APIResponse: types.APIResponse{
+			Success: true,
+			Data:    versions,
+		},
+		Pagination: types.PaginationInfo{
+			Page:       page,
+			PerPage:    perPage,
+			Total:      total,
+			TotalPages: totalPages,
+		},
+	})
+}
+
+// CreateConfigVersion godoc
+// @Summary Create configuration version
+// @Description Snapshot the current configuration (admin only)
+// @Tags config
+// @Accept json
+// @Produce json
+// @Param version body map[string]string false "Optional description"
+// @Success 201 {object} types.APIResponse{data=services.ConfigVersion}
+// @Failure 403 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /config/versions [post]
+func (h *ConfigHandler) CreateConfigVersion(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	var request struct {
+		Description string `json:"description"`
+	}
+	// The body is optional
+	_ = c.ShouldBindJSON(&request)
+
+	version, err := h.configService.CreateVersion(c.Request.Context(), user.ID, request.Description)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, types.APIResponse{
+		Success: true,
+		Data:    version,
+		Message: "Configuration version created successfully",
+	})
+}
+
+// GetConfigVersion godoc
+// @Summary Get configuration version
+// @Description Get a configuration version with its snapshot. The snapshot is a full export, so it is left out when REQUIRE_DUAL_APPROVAL is set (admin only)
+// @Tags config
+// @Accept json
+// @Produce json
+// @Param id path string true "Version ID"
+// @Success 200 {object} types.APIResponse{data=services.ConfigVersion}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Router /config/versions/{id} [get]
+func (h *ConfigHandler) GetConfigVersion(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid version ID format",
+		})
+		return
+	}
+
+	version, err := h.configService.GetVersion(c.Request.Context(), id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrConfigVersionNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// Reading a snapshot must not bypass the approval a full export needs
+	if h.approvalService.Required() {
+		version.Config = nil
+		c.JSON(http.StatusOK, types.APIResponse{
+			Success: true,
+			Data:    version,
+			Message: "Snapshot contents require an approved configuration export",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    version,
+	})
+}
+
+// RollbackConfigVersion godoc
+// @Summary Roll back to configuration version
+// @Description Re-import a stored snapshot, overwriting existing records. Records created after the snapshot are kept (admin only)
+// @Tags config
+// @Accept json
+// @Produce json
+// @Param id path string true "Version ID"
+// @Success 200 {object} types.APIResponse{data=services.ImportResult}
+// @Success 202 {object} types.APIResponse{data=services.ApprovalRequest} "Rollback queued for approval when REQUIRE_DUAL_APPROVAL is set"
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /config/versions/{id}/rollback [post]
+func (h *ConfigHandler) RollbackConfigVersion(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid version ID format",
+		})
+		return
+	}
+
+	if h.approvalService.Required() {
+		if _, err := h.configService.GetVersion(c.Request.Context(), id); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, services.ErrConfigVersionNotFound) {
+				status = http.StatusNotFound
+			}
+			c.JSON(status, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		requestApproval(c, h.approvalService, services.ApprovalActionRollbackConfig,
+			map[string]interface{}{"version_id": id}, user, fmt.Sprintf("Roll back configuration to version %s", id))
+		return
+	}
+
+	result, err := h.configService.RollbackToVersion(c.Request.Context(), id, user.ID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrConfigVersionNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, types.APIResponse{
+			Success: false,
+			Data:    result,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    result,
+		Message: "Configuration rolled back successfully",
+	})
 }
\ No newline at end of file
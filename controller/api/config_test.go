@@ -0,0 +1,196 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+)
+
+// largeConfigNodes is enough nodes to make an export several times the size
+// of a typical read buffer.
+const largeConfigNodes = 1000
+
+func (e *rbacTestEnv) upload(path string, file []byte, fields map[string]string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("file", "config.json")
+	part.Write(file)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	writer.Close()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-Test-Role", string(models.UserRoleAdmin))
+	e.router.ServeHTTP(w, req)
+	return w
+}
+
+// largeConfigExport builds an export of largeConfigNodes nodes and inserts
+// the same nodes, so importing it skips every one.
+func largeConfigExport(t *testing.T, env *rbacTestEnv) []byte {
+	t.Helper()
+
+	export := services.ConfigExport{Version: "1.0"}
+	for i := 0; i < largeConfigNodes; i++ {
+		node := models.Node{
+			ID:          uuid.New(),
+			Name:        fmt.Sprintf("spoke-%04d", i),
+			Description: "Branch office spoke imported from a large configuration export",
+			NodeType:    models.NodeTypeSpoke,
+			PublicKey:   "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+			AllocatedIP: fmt.Sprintf("10.100.%d.%d/16", 1+i/250, 1+i%250),
+			Endpoint:    fmt.Sprintf("spoke-%04d.example.com", i),
+			Port:        51820,
+			Status:      models.NodeStatusActive,
+		}
+		if err := env.db.Exec("INSERT INTO nodes (id, name, node_type, public_key, allocated_ip) VALUES (?, ?, ?, ?, ?)",
+			node.ID, node.Name, node.NodeType, node.PublicKey, node.AllocatedIP).Error; err != nil {
+			t.Fatalf("failed to insert node %s: %v", node.Name, err)
+		}
+		export.Nodes = append(export.Nodes, node)
+	}
+
+	data, err := json.Marshal(export)
+	if err != nil {
+		t.Fatalf("failed to marshal export: %v", err)
+	}
+	if len(data) < 256<<10 {
+		t.Fatalf("expected a large export, got %d bytes", len(data))
+	}
+	return data
+}
+
+func TestImportConfigurationReadsLargeFile(t *testing.T) {
+	env := newRBACTestEnv(t)
+	data := largeConfigExport(t, env)
+
+	w := env.upload("/api/v1/config/import", data, map[string]string{
+		"format":        "json",
+		"dry_run":       "true",
+		"skip_users":    "true",
+		"skip_policies": "true",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Data services.ImportResult `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if got := resp.Data.NodesImported + resp.Data.NodesSkipped; got != largeConfigNodes {
+		t.Errorf("expected all %d nodes to be parsed, got %d", largeConfigNodes, got)
+	}
+}
+
+func TestValidateConfigurationReadsLargeFile(t *testing.T) {
+	env := newRBACTestEnv(t)
+	data := largeConfigExport(t, env)
+
+	// Duplicating the last node is only noticed when the whole file is read
+	var export services.ConfigExport
+	json.Unmarshal(data, &export)
+	export.Nodes = append(export.Nodes, export.Nodes[len(export.Nodes)-1])
+	data, _ = json.Marshal(export)
+
+	w := env.upload("/api/v1/config/validate", data, map[string]string{"format": "json"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Data []string `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := fmt.Sprintf("Duplicate node name: spoke-%04d", largeConfigNodes-1)
+	if len(resp.Data) != 1 || resp.Data[0] != want {
+		t.Errorf("expected only %q, got %v", want, resp.Data)
+	}
+}
+
+func TestExportConfigurationCompressed(t *testing.T) {
+	env := newRBACTestEnv(t)
+	largeConfigExport(t, env)
+
+	export := func(query, acceptEncoding string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/config/export?include=nodes"+query, nil)
+		req.Header.Set("X-Test-Role", string(models.UserRoleAdmin))
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		env.router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	plain := export("", "")
+	if plain.Header().Get("Content-Encoding") != "" {
+		t.Error("expected an uncompressed export by default")
+	}
+
+	// compress=true downloads a .gz file
+	file := export("&compress=true", "")
+	if got := file.Header().Get("Content-Disposition"); got != "attachment; filename=wg-sdwan-config.json.gz" {
+		t.Errorf("expected a .json.gz download, got %q", got)
+	}
+	if file.Body.Len()*5 > plain.Body.Len() {
+		t.Errorf("expected the %d byte export to compress well, got %d bytes", plain.Body.Len(), file.Body.Len())
+	}
+	compressed := file.Body.Bytes()
+
+	// A client that accepts gzip gets the same file compressed in transit
+	transit := export("", "br;q=1.0, gzip;q=0.8")
+	if transit.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip content encoding, got %q", transit.Header().Get("Content-Encoding"))
+	}
+	reader, err := gzip.NewReader(transit.Body)
+	if err != nil {
+		t.Fatalf("failed to open gzip response: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to decompress response: %v", err)
+	}
+	var decoded services.ConfigExport
+	if err := json.Unmarshal(data, &decoded); err != nil || len(decoded.Nodes) != largeConfigNodes {
+		t.Errorf("expected %d nodes in the decompressed export, got %d (%v)", largeConfigNodes, len(decoded.Nodes), err)
+	}
+	if refused := export("", "gzip;q=0"); refused.Header().Get("Content-Encoding") != "" {
+		t.Error("expected gzip;q=0 to get an uncompressed export")
+	}
+
+	// The compressed file imports as is
+	w := env.upload("/api/v1/config/import", compressed, map[string]string{"format": "json", "dry_run": "true"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data services.ImportResult `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Data.NodesSkipped != largeConfigNodes {
+		t.Errorf("expected all %d existing nodes to be skipped, got %+v", largeConfigNodes, resp.Data)
+	}
+}
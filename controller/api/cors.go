@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+// CORSMiddleware answers cross-origin requests from the origins in config.
+// A matching origin is echoed back rather than answered with "*", and "*"
+// in the allowlist is ignored when credentials are allowed, since browsers
+// refuse credentialed responses that allow any origin. Preflight requests
+// from other origins are refused with 403; their other requests get no CORS
+// headers, so the browser withholds the response.
+func CORSMiddleware(config types.CORSConfig) gin.HandlerFunc {
+	allowAny := false
+	allowed := make(map[string]bool)
+	for _, origin := range config.AllowedOrigins {
+		origin = normalizeOrigin(origin)
+		if origin == "*" {
+			allowAny = !config.AllowCredentials
+			continue
+		}
+		if origin != "" {
+			allowed[origin] = true
+		}
+	}
+	methods := joinHeaderValues(config.AllowedMethods)
+	headers := joinHeaderValues(config.AllowedHeaders)
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		// The response depends on the origin, so caches must key on it
+		c.Header("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		if !allowAny && !allowed[normalizeOrigin(origin)] {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		if config.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID, Retry-After")
+
+		if preflight {
+			c.Header("Access-Control-Allow-Methods", methods)
+			c.Header("Access-Control-Allow-Headers", headers)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// normalizeOrigin makes configured and requested origins comparable:
+// scheme and host are case-insensitive and a trailing slash is ignored.
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+}
+
+func joinHeaderValues(values []string) string {
+	trimmed := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			trimmed = append(trimmed, value)
+		}
+	}
+	return strings.Join(trimmed, ", ")
+}
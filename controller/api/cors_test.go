@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+func newCORSTestRouter(config types.CORSConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CORSMiddleware(config))
+	router.GET("/api/v1/nodes", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+func corsRequest(router *gin.Engine, method, origin string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/v1/nodes", nil)
+	req.Header.Set("Origin", origin)
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		req.Header.Set("Access-Control-Request-Headers", "Authorization")
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORSAllowedOriginReflected(t *testing.T) {
+	router := newCORSTestRouter(types.CORSConfig{
+		AllowedOrigins:   []string{"https://console.example.com", " http://localhost:3000"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+	})
+
+	w := corsRequest(router, http.MethodOptions, "http://localhost:3000")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected preflight to succeed, got %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "http://localhost:3000" {
+		t.Errorf("expected the origin to be echoed back, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+		t.Errorf("expected the configured methods, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type, Authorization" {
+		t.Errorf("expected the configured headers, got %q", got)
+	}
+
+	w = corsRequest(router, http.MethodGet, "https://console.example.com")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the request to reach the handler, got %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://console.example.com" {
+		t.Errorf("expected the origin to be echoed back, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("expected credentials to be allowed, got %q", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("expected Vary: Origin, got %q", got)
+	}
+}
+
+func TestCORSDisallowedOriginRejected(t *testing.T) {
+	router := newCORSTestRouter(types.CORSConfig{
+		AllowedOrigins: []string{"https://console.example.com"},
+		AllowedMethods: []string{"GET"},
+	})
+
+	w := corsRequest(router, http.MethodOptions, "https://evil.example.net")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected preflight from an unlisted origin to be refused, got %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected no Access-Control-Allow-Origin, got %q", got)
+	}
+
+	w = corsRequest(router, http.MethodGet, "https://evil.example.net")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected no Access-Control-Allow-Origin for an unlisted origin, got %q", got)
+	}
+}
+
+func TestCORSWildcardIgnoredWithCredentials(t *testing.T) {
+	wildcard := types.CORSConfig{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}}
+
+	w := corsRequest(newCORSTestRouter(wildcard), http.MethodGet, "https://any.example.org")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://any.example.org" {
+		t.Errorf("expected any origin to be allowed by the wildcard, got %q", got)
+	}
+
+	wildcard.AllowCredentials = true
+	w = corsRequest(newCORSTestRouter(wildcard), http.MethodOptions, "https://any.example.org")
+	if w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected the wildcard not to allow credentialed requests, got %d %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
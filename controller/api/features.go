@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+)
+
+type FeaturesHandler struct {
+	featureService *services.FeatureService
+}
+
+func NewFeaturesHandler(featureService *services.FeatureService) *FeaturesHandler {
+	return &FeaturesHandler{
+		featureService: featureService,
+	}
+}
+
+// GetFeatures godoc
+// @Summary Get feature flags
+// @Description Get the optional subsystems enabled on this deployment
+// @Tags features
+// @Accept json
+// @Produce json
+// @Success 200 {object} types.APIResponse{data=map[string]bool}
+// @Router /features [get]
+func (h *FeaturesHandler) GetFeatures(c *gin.Context) {
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    h.featureService.GetFeatures(),
+	})
+}
+
+// RequireFeature responds with 404 to every request when the named feature
+// is disabled, so disabled subsystems look as if they were never installed.
+func (h *FeaturesHandler) RequireFeature(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.featureService.IsEnabled(name) {
+			c.JSON(http.StatusNotFound, types.APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("Feature %s is disabled", name),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+)
+
+func newFeaturesTestRouter(config *types.Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	featuresHandler := NewFeaturesHandler(services.NewFeatureService(config))
+
+	ok := func(c *gin.Context) {
+		c.JSON(http.StatusOK, types.APIResponse{Success: true})
+	}
+
+	router := gin.New()
+	router.GET("/features", featuresHandler.GetFeatures)
+
+	ha := router.Group("/ha")
+	ha.Use(featuresHandler.RequireFeature(services.FeatureHA))
+	ha.GET("/status", ok)
+
+	backup := router.Group("/api/v1/backup")
+	backup.Use(featuresHandler.RequireFeature(services.FeatureBackups))
+	backup.GET("", ok)
+
+	return router
+}
+
+func serve(router *gin.Engine, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequireFeatureDisabledReturnsNotFound(t *testing.T) {
+	router := newFeaturesTestRouter(&types.Config{
+		HA:       types.HAConfig{Enabled: false},
+		Features: types.FeaturesConfig{Backups: false},
+	})
+
+	for _, path := range []string{"/ha/status", "/api/v1/backup"} {
+		w := serve(router, http.MethodGet, path)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: expected 404 for disabled feature, got %d", path, w.Code)
+		}
+
+		var response types.APIResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("GET %s: failed to decode response: %v", path, err)
+		}
+		if response.Success || response.Error == "" {
+			t.Errorf("GET %s: expected a disabled error, got %+v", path, response)
+		}
+	}
+}
+
+func TestRequireFeatureEnabledServesRequest(t *testing.T) {
+	router := newFeaturesTestRouter(&types.Config{
+		HA:       types.HAConfig{Enabled: true},
+		Features: types.FeaturesConfig{Backups: true},
+	})
+
+	for _, path := range []string{"/ha/status", "/api/v1/backup"} {
+		if w := serve(router, http.MethodGet, path); w.Code != http.StatusOK {
+			t.Errorf("GET %s: expected 200 for enabled feature, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestGetFeatures(t *testing.T) {
+	router := newFeaturesTestRouter(&types.Config{
+		HA:       types.HAConfig{Enabled: true},
+		Features: types.FeaturesConfig{Backups: false, RemoteBackups: true, Mesh: true},
+	})
+
+	w := serve(router, http.MethodGet, "/features")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var response struct {
+		Success bool            `json:"success"`
+		Data    map[string]bool `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	expected := map[string]bool{
+		services.FeatureHA:            true,
+		services.FeatureBackups:       false,
+		services.FeatureRemoteBackups: false, // requires backups
+		services.FeatureMesh:          true,
+		services.FeatureEndpointProbe: false,
+		services.FeatureConfigHistory: false,
+	}
+	for name, want := range expected {
+		if got, ok := response.Data[name]; !ok || got != want {
+			t.Errorf("feature %s = %v (present %v), want %v", name, got, ok, want)
+		}
+	}
+}
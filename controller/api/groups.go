@@ -0,0 +1,325 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+)
+
+type GroupsHandler struct {
+	groupService *services.NodeGroupService
+	authService  *services.AuthService
+}
+
+func NewGroupsHandler(groupService *services.NodeGroupService, authService *services.AuthService) *GroupsHandler {
+	return &GroupsHandler{
+		groupService: groupService,
+		authService:  authService,
+	}
+}
+
+// GetGroups godoc
+// @Summary List node groups
+// @Description Get all node groups
+// @Tags groups
+// @Produce json
+// @Success 200 {object} types.APIResponse{data=[]models.NodeGroup}
+// @Failure 500 {object} types.APIResponse
+// @Router /groups [get]
+func (h *GroupsHandler) GetGroups(c *gin.Context) {
+	groups, err := h.groupService.GetGroups(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    groups,
+	})
+}
+
+// GetGroup godoc
+// @Summary Get node group
+// @Description Get a node group with its members
+// @Tags groups
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {object} types.APIResponse{data=models.NodeGroup}
+// @Failure 400 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Router /groups/{id} [get]
+func (h *GroupsHandler) GetGroup(c *gin.Context) {
+	id, ok := parseGroupID(c)
+	if !ok {
+		return
+	}
+
+	group, err := h.groupService.GetGroup(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(groupErrorStatus(err), types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    group,
+	})
+}
+
+// CreateGroup godoc
+// @Summary Create node group
+// @Description Create a node group; spokes in a mesh group peer directly when the mesh feature is on (operator or admin)
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Param group body services.NodeGroupRequest true "Node group"
+// @Success 201 {object} types.APIResponse{data=models.NodeGroup}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 409 {object} types.APIResponse
+// @Router /groups [post]
+func (h *GroupsHandler) CreateGroup(c *gin.Context) {
+	user, ok := requireUserRole(c, h.authService, models.UserRoleOperator)
+	if !ok {
+		return
+	}
+
+	var req services.NodeGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	group, err := h.groupService.CreateGroup(c.Request.Context(), &req, &user.ID)
+	if err != nil {
+		c.JSON(groupErrorStatus(err), types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, types.APIResponse{
+		Success: true,
+		Message: "Node group created successfully",
+		Data:    group,
+	})
+}
+
+// UpdateGroup godoc
+// @Summary Update node group
+// @Description Replace a node group's name, description and mesh setting (operator or admin)
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param group body services.NodeGroupRequest true "Node group"
+// @Success 200 {object} types.APIResponse{data=models.NodeGroup}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 409 {object} types.APIResponse
+// @Router /groups/{id} [put]
+func (h *GroupsHandler) UpdateGroup(c *gin.Context) {
+	user, ok := requireUserRole(c, h.authService, models.UserRoleOperator)
+	if !ok {
+		return
+	}
+
+	id, ok := parseGroupID(c)
+	if !ok {
+		return
+	}
+
+	var req services.NodeGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	group, err := h.groupService.UpdateGroup(c.Request.Context(), id, &req, &user.ID)
+	if err != nil {
+		c.JSON(groupErrorStatus(err), types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "Node group updated successfully",
+		Data:    group,
+	})
+}
+
+// DeleteGroup godoc
+// @Summary Delete node group
+// @Description Delete a node group that no policy selects (operator or admin)
+// @Tags groups
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {object} types.APIResponse
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 409 {object} types.APIResponse
+// @Router /groups/{id} [delete]
+func (h *GroupsHandler) DeleteGroup(c *gin.Context) {
+	user, ok := requireUserRole(c, h.authService, models.UserRoleOperator)
+	if !ok {
+		return
+	}
+
+	id, ok := parseGroupID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.groupService.DeleteGroup(c.Request.Context(), id, &user.ID); err != nil {
+		c.JSON(groupErrorStatus(err), types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "Node group deleted successfully",
+	})
+}
+
+// AddGroupMember godoc
+// @Summary Add node group member
+// @Description Assign a spoke to a node group (operator or admin)
+// @Tags groups
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param node_id path string true "Node ID"
+// @Success 200 {object} types.APIResponse
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Router /groups/{id}/members/{node_id} [put]
+func (h *GroupsHandler) AddGroupMember(c *gin.Context) {
+	user, ok := requireUserRole(c, h.authService, models.UserRoleOperator)
+	if !ok {
+		return
+	}
+
+	id, nodeID, ok := parseGroupMember(c)
+	if !ok {
+		return
+	}
+
+	if err := h.groupService.AddMember(c.Request.Context(), id, nodeID, &user.ID); err != nil {
+		c.JSON(groupErrorStatus(err), types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "Node added to group successfully",
+	})
+}
+
+// RemoveGroupMember godoc
+// @Summary Remove node group member
+// @Description Remove a spoke from a node group (operator or admin)
+// @Tags groups
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param node_id path string true "Node ID"
+// @Success 200 {object} types.APIResponse
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Router /groups/{id}/members/{node_id} [delete]
+func (h *GroupsHandler) RemoveGroupMember(c *gin.Context) {
+	user, ok := requireUserRole(c, h.authService, models.UserRoleOperator)
+	if !ok {
+		return
+	}
+
+	id, nodeID, ok := parseGroupMember(c)
+	if !ok {
+		return
+	}
+
+	if err := h.groupService.RemoveMember(c.Request.Context(), id, nodeID, &user.ID); err != nil {
+		c.JSON(groupErrorStatus(err), types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "Node removed from group successfully",
+	})
+}
+
+func parseGroupID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid group ID format",
+		})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func parseGroupMember(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	id, ok := parseGroupID(c)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	nodeID, err := uuid.Parse(c.Param("node_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid node ID format",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return id, nodeID, true
+}
+
+func groupErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrNodeGroupNotFound), errors.Is(err, services.ErrNodeNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrNodeGroupExists), errors.Is(err, services.ErrNodeGroupInUse):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrInvalidGroupMember), errors.Is(err, types.ErrControlChars):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,9 +1,12 @@
 package api
 
 import (
+	"encoding/json"
+	"net"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/wg-hubspoke/wg-hubspoke/common/types"
 	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
 )
@@ -52,20 +55,17 @@ func (h *HAHandler) GetHealthStatus(c *gin.Context) {
 
 // HandleVoteRequest godoc
 // @Summary Handle leader election vote
-// @Description Handle leader election vote request from peer nodes
+// @Description Handle leader election vote request from peer nodes, signed with HA_PEER_SECRET
 // @Tags ha
 // @Accept json
 // @Produce json
 // @Param request body services.LeaderElectionRequest true "Election request"
 // @Success 200 {object} types.APIResponse{data=services.LeaderElectionResponse}
+// @Failure 401 {object} types.APIResponse
 // @Router /ha/election [post]
 func (h *HAHandler) HandleVoteRequest(c *gin.Context) {
 	var request services.LeaderElectionRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, types.APIResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+	if !h.bindPeerRequest(c, &request) {
 		return
 	}
 
@@ -78,20 +78,17 @@ func (h *HAHandler) HandleVoteRequest(c *gin.Context) {
 
 // HandleLeaderAnnouncement godoc
 // @Summary Handle leader announcement
-// @Description Handle leader announcement from elected leader
+// @Description Handle leader announcement from elected leader, signed with HA_PEER_SECRET
 // @Tags ha
 // @Accept json
 // @Produce json
 // @Param announcement body map[string]interface{} true "Leader announcement"
 // @Success 200 {object} types.APIResponse
+// @Failure 401 {object} types.APIResponse
 // @Router /ha/leader [post]
 func (h *HAHandler) HandleLeaderAnnouncement(c *gin.Context) {
 	var announcement map[string]interface{}
-	if err := c.ShouldBindJSON(&announcement); err != nil {
-		c.JSON(http.StatusBadRequest, types.APIResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+	if !h.bindPeerRequest(c, &announcement) {
 		return
 	}
 
@@ -124,4 +121,90 @@ func (h *HAHandler) SyncConfiguration(c *gin.Context) {
 		Success: true,
 		Message: "Configuration synchronized successfully",
 	})
-}
\ No newline at end of file
+}
+
+// bindPeerRequest checks that the request body was signed with the
+// cluster's peer secret and decodes it into v.
+func (h *HAHandler) bindPeerRequest(c *gin.Context, v interface{}) bool {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return false
+	}
+
+	if err := h.haService.VerifyPeerRequest(c.Request.Header, body); err != nil {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Peer authentication failed",
+		})
+		return false
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// LeaderProxyMiddleware forwards write requests received by a follower to
+// the current leader and relays the leader's response.
+func (h *HAHandler) LeaderProxyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		local := false
+		h.haService.EnsureLeaderOrProxy(func(w http.ResponseWriter, r *http.Request) {
+			local = true
+		})(c.Writer, c.Request)
+
+		if !local {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// ProxiedClientMiddleware restores the client of a request a follower
+// forwarded to this controller. Only the follower's signed headers are
+// believed: the client address replaces the follower's own, X-Forwarded-For
+// is dropped, and the node named by the client's certificate is left for
+// AuthMiddleware. Requests claiming to be forwarded without a valid
+// signature are refused.
+func (h *HAHandler) ProxiedClientMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader(services.ProxiedByHeader) == "" {
+			c.Next()
+			return
+		}
+
+		client, err := h.haService.VerifyProxiedRequest(c.Request)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, types.APIResponse{
+				Success: false,
+				Error:   "Untrusted proxied request",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Request.RemoteAddr = net.JoinHostPort(client.IP, "0")
+		c.Request.Header.Del("X-Forwarded-For")
+		c.Request.Header.Del("X-Real-IP")
+		if client.NodeID != uuid.Nil {
+			c.Set("proxied_client_node", client.NodeID)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+)
+
+func TestHAEndpointsRequirePeerSignature(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	haService := services.NewHAService(nil, &types.Config{
+		HA: types.HAConfig{Enabled: true, NodeID: "controller-1", ClusterID: "cluster-a", PeerSecret: "peer-secret"},
+	})
+	haHandler := NewHAHandler(haService)
+
+	router := gin.New()
+	router.POST("/ha/election", haHandler.HandleVoteRequest)
+	router.POST("/ha/leader", haHandler.HandleLeaderAnnouncement)
+
+	election, _ := json.Marshal(services.LeaderElectionRequest{NodeID: "controller-2", ClusterID: "cluster-a", Term: 50})
+	announcement, _ := json.Marshal(map[string]interface{}{"leader_id": "controller-2", "cluster_id": "cluster-a", "term": 60})
+
+	post := func(path string, body []byte, secret string) int {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			timestamp := time.Now().Unix()
+			req.Header.Set(services.SignatureTimestampHeader, strconv.FormatInt(timestamp, 10))
+			req.Header.Set(services.SignatureHeader, services.SignPayload(secret, timestamp, body))
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for _, path := range []string{"/ha/election", "/ha/leader"} {
+		body := election
+		if path == "/ha/leader" {
+			body = announcement
+		}
+		if code := post(path, body, ""); code != http.StatusUnauthorized {
+			t.Errorf("POST %s unsigned: expected 401, got %d", path, code)
+		}
+		if code := post(path, body, "wrong-secret"); code != http.StatusUnauthorized {
+			t.Errorf("POST %s with the wrong secret: expected 401, got %d", path, code)
+		}
+	}
+	if term := haService.CurrentTerm(); term != 0 {
+		t.Fatalf("expected unauthenticated requests to leave the term alone, got %d", term)
+	}
+
+	// Signed, but controller-2 was never discovered
+	if code := post("/ha/election", election, "peer-secret"); code != http.StatusOK {
+		t.Errorf("expected a signed vote request to be answered, got %d", code)
+	}
+	if term := haService.CurrentTerm(); term != 0 {
+		t.Errorf("expected a vote request from an unknown controller not to move the term, got %d", term)
+	}
+}
+
+// newProxyTestRouter serves an agent write route the way main.go does,
+// behind the HA and auth middleware.
+func newProxyTestRouter(haService *services.HAService, authService *services.AuthService, handler gin.HandlerFunc) *gin.Engine {
+	haHandler := NewHAHandler(haService)
+
+	router := gin.New()
+	router.Use(haHandler.ProxiedClientMiddleware())
+	router.GET("/ha/health", haHandler.GetHealthStatus)
+
+	v1 := router.Group("/api/v1")
+	v1.Use(haHandler.LeaderProxyMiddleware())
+	v1.Use(NewAuthHandler(authService).AuthMiddleware())
+	v1.PUT("/nodes/:id", handler)
+	return router
+}
+
+func TestCertificateAuthenticatedWriteThroughFollower(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newSecurityTestDB(t)
+	config := &types.Config{Auth: types.AuthConfig{JWTSecret: securityTestJWTSecret}}
+	auditService := services.NewAuditService(db)
+	authService := services.NewAuthService(db, config, auditService)
+	authService.SetSecurityService(services.NewSecurityService(db, config, auditService))
+
+	type receivedRequest struct {
+		node     interface{}
+		clientIP string
+	}
+	received := make(chan receivedRequest, 1)
+
+	// HA disabled makes the leader lead on its own
+	leader := services.NewHAService(nil, &types.Config{
+		HA: types.HAConfig{NodeID: "controller-1", ClusterID: "cluster-a", PeerSecret: "peer-secret"},
+	})
+	if err := leader.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start leader: %v", err)
+	}
+	leaderServer := httptest.NewServer(newProxyTestRouter(leader, authService, func(c *gin.Context) {
+		node, _ := c.Get("current_node")
+		received <- receivedRequest{node: node, clientIP: c.ClientIP()}
+		c.JSON(http.StatusOK, types.APIResponse{Success: true})
+	}))
+	t.Cleanup(leaderServer.Close)
+
+	host, portStr, _ := net.SplitHostPort(strings.TrimPrefix(leaderServer.URL, "http://"))
+	port, _ := strconv.Atoi(portStr)
+	follower := services.NewHAService(nil, &types.Config{
+		Server: types.ServerConfig{Port: port},
+		HA: types.HAConfig{
+			Enabled: true, NodeID: "controller-2", ClusterID: "cluster-a", PeerNodes: []string{host},
+			HeartbeatInterval: 10 * time.Millisecond, ElectionTimeout: time.Hour, PeerSecret: "peer-secret",
+		},
+	})
+	follower.SetClientCertificateAuthenticator(authService.AuthenticateNodeCertificate)
+	if err := follower.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start follower: %v", err)
+	}
+	t.Cleanup(func() { follower.Stop(context.Background()) })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for follower.GetLeader() != "controller-1" {
+		if time.Now().After(deadline) {
+			t.Fatal("follower did not find the leader")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	followerRouter := newProxyTestRouter(follower, authService, func(c *gin.Context) {
+		t.Error("follower must not serve write requests locally")
+	})
+
+	nodeID := uuid.New()
+	path := "/api/v1/nodes/" + nodeID.String()
+	cert := clientCertificate(t, nodeID.String(), time.Now().Add(time.Hour))
+
+	followerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// As left by a handshake that verified cert against the client CAs
+		r.RemoteAddr = "203.0.113.7:40000"
+		r.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert},
+			VerifiedChains:   [][]*x509.Certificate{{cert}},
+		}
+		followerRouter.ServeHTTP(w, r)
+	}))
+	t.Cleanup(followerServer.Close)
+
+	req, _ := http.NewRequest(http.MethodPut, followerServer.URL+path, strings.NewReader(`{"status":"active"}`))
+	// Claims the client makes for itself are not passed on
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("X-WG-Client-Node", uuid.NewString())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request to follower failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the write to be served by the leader, got %d: %s", resp.StatusCode, body)
+	}
+	select {
+	case got := <-received:
+		if got.node != nodeID {
+			t.Errorf("expected the leader to authenticate node %s from the certificate, got %v", nodeID, got.node)
+		}
+		if got.clientIP != "203.0.113.7" {
+			t.Errorf("expected the leader to see the client's address, got %s", got.clientIP)
+		}
+	default:
+		t.Fatal("leader did not receive the proxied request")
+	}
+
+	// Only a follower holding the peer secret can vouch for a client
+	forged, _ := http.NewRequest(http.MethodPut, leaderServer.URL+path, strings.NewReader(`{"status":"active"}`))
+	forged.Header.Set(services.ProxiedByHeader, "controller-2")
+	forged.Header.Set("X-WG-Client-IP", "203.0.113.7")
+	forged.Header.Set("X-WG-Client-Node", nodeID.String())
+	resp, err = http.DefaultClient.Do(forged)
+	if err != nil {
+		t.Fatalf("request to leader failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected an unsigned proxied request to be refused, got %d", resp.StatusCode)
+	}
+}
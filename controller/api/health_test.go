@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+)
+
+func TestReadinessCheckFollowsDatabase(t *testing.T) {
+	db := newRBACTestDB(t)
+	handler := NewHealthHandler(services.NewHealthService(db, "test"), "test")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ready", handler.ReadinessCheck)
+	router.GET("/live", handler.LivenessCheck)
+
+	if w := serve(router, http.MethodGet, "/ready"); w.Code != http.StatusOK {
+		t.Fatalf("expected ready with a live database, got %d: %s", w.Code, w.Body.String())
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database handle: %v", err)
+	}
+	sqlDB.Close()
+
+	if w := serve(router, http.MethodGet, "/ready"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 once the database is closed, got %d: %s", w.Code, w.Body.String())
+	}
+	// Liveness only reflects the process, so orchestrators don't restart
+	// the controller over a database outage
+	if w := serve(router, http.MethodGet, "/live"); w.Code != http.StatusOK {
+		t.Errorf("expected live to stay 200 without a database, got %d", w.Code)
+	}
+}
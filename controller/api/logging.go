@@ -0,0 +1,63 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// RequestLogger gives each request an ID, taken from the X-Request-ID header
+// when the client or a proxy in front already set a usable one, and logs
+// the request once it completes. The ID is returned in the response header
+// and carried by the request context, so everything services log while
+// serving the request can be correlated.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = uuid.NewString()
+			// Forwarded with the request when it is proxied to the HA leader
+			c.Request.Header.Set(requestIDHeader, requestID)
+		}
+		c.Header(requestIDHeader, requestID)
+
+		ctx := services.WithRequestID(c.Request.Context(), requestID)
+		c.Request = c.Request.WithContext(ctx)
+		c.Set("request_id", requestID)
+
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "Request completed",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP())
+	}
+}
+
+// isValidRequestID accepts IDs of up to 128 printable ASCII characters
+// without spaces, which is enough for UUIDs and common tracing formats and
+// keeps client-supplied values from corrupting log lines.
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > 128 {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] <= ' ' || requestID[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+)
+
+func TestRequestLoggerCorrelatesJSONLogs(t *testing.T) {
+	var output bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(services.NewLogger(types.LogConfig{Level: "warn", Format: "json"}, &output))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestLogger())
+	router.GET("/fail", func(c *gin.Context) {
+		slog.InfoContext(c.Request.Context(), "Below the configured level")
+		slog.WarnContext(c.Request.Context(), "Logged by a service")
+		c.Status(http.StatusInternalServerError)
+	})
+
+	serveWithID := func(requestID string) string {
+		req := httptest.NewRequest(http.MethodGet, "/fail", nil)
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Header().Get("X-Request-ID")
+	}
+
+	generated := serveWithID("")
+	if generated == "" {
+		t.Fatal("expected a generated request ID in the response")
+	}
+	if supplied := serveWithID("trace-1234"); supplied != "trace-1234" {
+		t.Errorf("expected the client's request ID to be kept, got %q", supplied)
+	}
+	if replaced := serveWithID("bad id\n"); replaced == "bad id\n" || replaced == "" {
+		t.Errorf("expected an unusable request ID to be replaced, got %q", replaced)
+	}
+
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("expected every log line to be JSON, got %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	// Each request logs the service warning and the failed request itself
+	if len(entries) != 6 {
+		t.Fatalf("expected 6 log entries at warn level and above, got %d: %v", len(entries), entries)
+	}
+	expected := []struct {
+		msg   string
+		level string
+	}{
+		{"Logged by a service", "WARN"},
+		{"Request completed", "ERROR"},
+	}
+	for i, entry := range entries {
+		if entry["msg"] != expected[i%2].msg || entry["level"] != expected[i%2].level {
+			t.Errorf("entry %d: expected %s at %s, got %v", i, expected[i%2].msg, expected[i%2].level, entry)
+		}
+	}
+	if entries[0]["request_id"] != generated || entries[1]["request_id"] != generated {
+		t.Errorf("expected the first request's entries to carry %s, got %v and %v", generated, entries[0]["request_id"], entries[1]["request_id"])
+	}
+	if entries[2]["request_id"] != "trace-1234" || entries[3]["request_id"] != "trace-1234" {
+		t.Errorf("expected the second request's entries to carry the supplied ID, got %v and %v", entries[2]["request_id"], entries[3]["request_id"])
+	}
+	if entries[1]["status"] != float64(http.StatusInternalServerError) || entries[1]["path"] != "/fail" {
+		t.Errorf("expected the request entry to describe the request, got %v", entries[1])
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/common/expfmt"
+)
+
+// unmatchedRoute labels requests that matched no route, so that scans of
+// random paths cannot grow the number of series without bound.
+const unmatchedRoute = "unmatched"
+
+// HTTPMetrics collects metrics about the controller's own HTTP server
+// alongside the Go runtime and process collectors.
+type HTTPMetrics struct {
+	registry        *prometheus.Registry
+	requestsTotal   *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	inFlight        prometheus.Gauge
+}
+
+func NewHTTPMetrics() *HTTPMetrics {
+	m := &HTTPMetrics{
+		registry: prometheus.NewRegistry(),
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wg_sdwan_http_requests_total",
+			Help: "Total number of HTTP requests served by the controller",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wg_sdwan_http_request_duration_seconds",
+			Help:    "HTTP request latency by route and status",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "wg_sdwan_http_requests_in_flight",
+			Help: "Number of HTTP requests currently being served",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requestsTotal,
+		m.requestDuration,
+		m.inFlight,
+	)
+	return m
+}
+
+// Middleware records every request by its route template rather than its
+// path, so /nodes/:id is one series however many nodes there are.
+func (m *HTTPMetrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		m.requestsTotal.WithLabelValues(c.Request.Method, route, status).Inc()
+		m.requestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// WriteText writes the collected metrics in the Prometheus text format.
+func (m *HTTPMetrics) WriteText(w io.Writer) error {
+	families, err := m.registry.Gather()
+	if err != nil {
+		return err
+	}
+
+	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+)
+
+func TestPrometheusMetricsIncludeHTTPServerMetrics(t *testing.T) {
+	httpMetrics := NewHTTPMetrics()
+	handler := NewMonitoringHandler(services.NewMonitoringService(newRBACTestDB(t), nil), nil)
+	handler.SetHTTPMetrics(httpMetrics)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(httpMetrics.Middleware())
+	router.GET("/metrics", handler.GetPrometheusMetrics)
+	router.GET("/api/v1/nodes/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/api/v1/nodes", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+
+	serve(router, http.MethodGet, "/api/v1/nodes/first")
+	serve(router, http.MethodGet, "/api/v1/nodes/second")
+	serve(router, http.MethodPost, "/api/v1/nodes")
+	serve(router, http.MethodGet, "/no/such/path")
+
+	w := serve(router, http.MethodGet, "/metrics")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+
+	for _, series := range []string{
+		// Both node lookups share the route template
+		`wg_sdwan_http_requests_total{method="GET",route="/api/v1/nodes/:id",status="200"} 2`,
+		`wg_sdwan_http_requests_total{method="POST",route="/api/v1/nodes",status="500"} 1`,
+		`wg_sdwan_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`wg_sdwan_http_request_duration_seconds_bucket{method="GET",route="/api/v1/nodes/:id",status="200",le="+Inf"} 2`,
+		`wg_sdwan_http_request_duration_seconds_count{method="POST",route="/api/v1/nodes",status="500"} 1`,
+		"# TYPE wg_sdwan_http_request_duration_seconds histogram",
+		"# TYPE wg_sdwan_http_requests_total counter",
+		"wg_sdwan_http_requests_in_flight 1",
+		"go_goroutines",
+		// Node metrics are still served
+		"wg_sdwan_total_nodes",
+	} {
+		if !strings.Contains(body, series) {
+			t.Errorf("expected /metrics to contain %q, got:\n%s", series, body)
+		}
+	}
+	if strings.Contains(body, "/no/such/path") || strings.Contains(body, "/api/v1/nodes/first") {
+		t.Error("expected requests to be labeled by route template, not raw path")
+	}
+}
@@ -2,25 +2,62 @@ package api
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
 	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
 )
 
+const (
+	// streamWriteTimeout bounds each write to a stream client.
+	streamWriteTimeout = 10 * time.Second
+	// streamPingInterval keeps idle streams open through proxies; a client
+	// that misses two pings is disconnected.
+	streamPingInterval = 30 * time.Second
+)
+
+// labelValueEscaper escapes the characters the Prometheus text format does
+// not allow unescaped in a label value.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// streamUpgrader accepts any origin; the CORS allowlist does not apply to
+// WebSocket upgrades. Streams authenticate with a bearer token rather than
+// cookies, so a foreign page gains nothing by opening one.
+var streamUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
 type MonitoringHandler struct {
 	monitoringService *services.MonitoringService
+	authService       *services.AuthService
+	httpMetrics       *HTTPMetrics
 }
 
-func NewMonitoringHandler(monitoringService *services.MonitoringService) *MonitoringHandler {
+func NewMonitoringHandler(monitoringService *services.MonitoringService, authService *services.AuthService) *MonitoringHandler {
 	return &MonitoringHandler{
 		monitoringService: monitoringService,
+		authService:       authService,
 	}
 }
 
+// SetHTTPMetrics includes the controller's own HTTP server metrics in the
+// /metrics output.
+func (h *MonitoringHandler) SetHTTPMetrics(httpMetrics *HTTPMetrics) {
+	h.httpMetrics = httpMetrics
+}
+
 // UpdateNodeMetrics godoc
 // @Summary Update node metrics
 // @Description Update monitoring metrics for a specific node
@@ -130,6 +167,77 @@ func (h *MonitoringHandler) GetAllNodeMetrics(c *gin.Context) {
 	})
 }
 
+// StreamNodeEvents godoc
+// @Summary Stream node status and metrics
+// @Description Upgrade to a WebSocket that pushes a JSON services.NodeEvent for each metrics report and status change, for every node or only node_id. Browsers may pass the token as access_token since they cannot set headers on WebSocket requests.
+// @Tags monitoring
+// @Param node_id query string false "Only stream events for this node"
+// @Param access_token query string false "Bearer token, when the Authorization header cannot be set"
+// @Success 101 {object} services.NodeEvent
+// @Failure 400 {object} types.APIResponse
+// @Router /monitoring/stream [get]
+func (h *MonitoringHandler) StreamNodeEvents(c *gin.Context) {
+	var nodeID uuid.UUID
+	if nodeIDStr := c.Query("node_id"); nodeIDStr != "" {
+		var err error
+		nodeID, err = uuid.Parse(nodeIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error:   "Invalid node ID format",
+			})
+			return
+		}
+	}
+
+	// The upgrader writes its own error response
+	conn, err := streamUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	events, unsubscribe := h.monitoringService.SubscribeNodeEvents(nodeID)
+	defer unsubscribe()
+
+	// Clients only send control frames; reading handles them and notices
+	// when the client goes away
+	closed := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(2 * streamPingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * streamPingInterval))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-c.Request.Context().Done():
+			return
+		case event := <-events:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
 // GetSystemMetrics godoc
 // @Summary Get system metrics
 // @Description Get overall system metrics and statistics
@@ -192,6 +300,52 @@ func (h *MonitoringHandler) GetNodeHealth(c *gin.Context) {
 	})
 }
 
+// GetNodesByHealthScore godoc
+// @Summary List nodes by health score
+// @Description Get nodes whose current health score is below (or above) a threshold, sorted by score
+// @Tags monitoring
+// @Accept json
+// @Produce json
+// @Param threshold query number true "Health score threshold"
+// @Param direction query string false "Match scores below or above the threshold" Enums(below,above) default(below)
+// @Success 200 {object} types.APIResponse{data=[]services.NodeHealthScore}
+// @Failure 400 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /monitoring/nodes/health [get]
+func (h *MonitoringHandler) GetNodesByHealthScore(c *gin.Context) {
+	threshold, err := strconv.ParseFloat(c.Query("threshold"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid threshold",
+		})
+		return
+	}
+
+	direction := c.DefaultQuery("direction", "below")
+	if direction != "below" && direction != "above" {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "direction must be below or above",
+		})
+		return
+	}
+
+	nodes, err := h.monitoringService.GetNodesByHealthScore(c.Request.Context(), threshold, direction == "below")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    nodes,
+	})
+}
+
 // GetTopologyHealth godoc
 // @Summary Get topology health
 // @Description Get overall network topology health status
@@ -226,7 +380,7 @@ func (h *MonitoringHandler) GetTopologyHealth(c *gin.Context) {
 // @Param node_id path string true "Node ID"
 // @Param metric query string true "Metric name"
 // @Param duration query string false "Duration" default("24h")
-// @Success 200 {object} types.APIResponse{data=[]interface{}}
+// @Success 200 {object} types.APIResponse{data=[]services.MetricPoint}
 // @Failure 400 {object} types.APIResponse
 // @Failure 500 {object} types.APIResponse
 // @Router /monitoring/nodes/{node_id}/history [get]
@@ -262,6 +416,13 @@ func (h *MonitoringHandler) GetMetricsHistory(c *gin.Context) {
 
 	history, err := h.monitoringService.GetMetricsHistory(c.Request.Context(), nodeID, metric, duration)
 	if err != nil {
+		if err == services.ErrUnknownMetric {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error:   "Unknown metric",
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
 			Error:   err.Error(),
@@ -351,7 +512,7 @@ func (h *MonitoringHandler) GetPrometheusMetrics(c *gin.Context) {
 	prometheusMetrics += "# TYPE wg_sdwan_node_cpu_usage gauge\n"
 
 	for nodeID, nodeMetrics := range metrics {
-		labels := `{node_id="` + nodeID.String() + `",node_name="` + nodeMetrics.NodeName + `"}`
+		labels := `{node_id="` + nodeID.String() + `",node_name="` + escapeLabelValue(nodeMetrics.NodeName) + `"}`
 		prometheusMetrics += "wg_sdwan_node_cpu_usage" + labels + " " + fmt.Sprintf("%.2f", nodeMetrics.CPUUsage) + "\n"
 		prometheusMetrics += "wg_sdwan_node_memory_usage" + labels + " " + fmt.Sprintf("%.2f", nodeMetrics.MemoryUsage) + "\n"
 		prometheusMetrics += "wg_sdwan_node_network_rx" + labels + " " + fmt.Sprintf("%d", nodeMetrics.NetworkRx) + "\n"
@@ -359,6 +520,7 @@ func (h *MonitoringHandler) GetPrometheusMetrics(c *gin.Context) {
 		prometheusMetrics += "wg_sdwan_node_latency" + labels + " " + fmt.Sprintf("%.2f", nodeMetrics.Latency) + "\n"
 		prometheusMetrics += "wg_sdwan_node_packet_loss" + labels + " " + fmt.Sprintf("%.2f", nodeMetrics.PacketLoss) + "\n"
 		prometheusMetrics += "wg_sdwan_node_wg_peers" + labels + " " + fmt.Sprintf("%d", nodeMetrics.WGPeers) + "\n"
+		prometheusMetrics += "wg_sdwan_node_bandwidth_bps" + labels + " " + fmt.Sprintf("%d", nodeMetrics.Bandwidth) + "\n"
 	}
 
 	// System metrics
@@ -370,6 +532,261 @@ func (h *MonitoringHandler) GetPrometheusMetrics(c *gin.Context) {
 	prometheusMetrics += "wg_sdwan_hub_nodes " + fmt.Sprintf("%d", systemMetrics.HubNodes) + "\n"
 	prometheusMetrics += "wg_sdwan_spoke_nodes " + fmt.Sprintf("%d", systemMetrics.SpokeNodes) + "\n"
 
+	// Config fetch metrics
+	configFetches := h.monitoringService.GetConfigFetchStats(c.Request.Context())
+	prometheusMetrics += "# HELP wg_sdwan_config_fetch_total Total number of config fetches per node\n"
+	prometheusMetrics += "# TYPE wg_sdwan_config_fetch_total counter\n"
+	for nodeID, stats := range configFetches {
+		labels := `{node_id="` + nodeID.String() + `"}`
+		prometheusMetrics += "wg_sdwan_config_fetch_total" + labels + " " + fmt.Sprintf("%d", stats.Count) + "\n"
+	}
+	prometheusMetrics += "# HELP wg_sdwan_config_generation_seconds Config generation latency per node\n"
+	prometheusMetrics += "# TYPE wg_sdwan_config_generation_seconds summary\n"
+	for nodeID, stats := range configFetches {
+		labels := `{node_id="` + nodeID.String() + `"}`
+		prometheusMetrics += "wg_sdwan_config_generation_seconds_sum" + labels + " " + fmt.Sprintf("%.6f", stats.TotalLatency.Seconds()) + "\n"
+		prometheusMetrics += "wg_sdwan_config_generation_seconds_count" + labels + " " + fmt.Sprintf("%d", stats.Count) + "\n"
+	}
+
+	if h.httpMetrics != nil {
+		var httpMetrics strings.Builder
+		if err := h.httpMetrics.WriteText(&httpMetrics); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to gather HTTP server metrics", "error", err)
+		}
+		prometheusMetrics += httpMetrics.String()
+	}
+
 	c.Header("Content-Type", "text/plain")
 	c.String(http.StatusOK, prometheusMetrics)
-}
\ No newline at end of file
+}
+
+// GetConfigFetchStats godoc
+// @Summary Get config fetch statistics
+// @Description Get per-node config fetch counts and generation latency
+// @Tags monitoring
+// @Accept json
+// @Produce json
+// @Success 200 {object} types.APIResponse{data=map[string]services.ConfigFetchStats}
+// @Router /monitoring/config-fetches [get]
+func (h *MonitoringHandler) GetConfigFetchStats(c *gin.Context) {
+	stats := h.monitoringService.GetConfigFetchStats(c.Request.Context())
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    stats,
+	})
+}
+
+// GetAlertRules godoc
+// @Summary List alert rules
+// @Description Get all configured alert rules
+// @Tags monitoring
+// @Accept json
+// @Produce json
+// @Param search query string false "Search by name or description"
+// @Success 200 {object} types.APIResponse{data=[]services.AlertRule}
+// @Failure 500 {object} types.APIResponse
+// @Router /monitoring/alerts/rules [get]
+func (h *MonitoringHandler) GetAlertRules(c *gin.Context) {
+	rules, err := h.monitoringService.GetAlertRules(c.Request.Context(), c.Query("search"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    rules,
+	})
+}
+
+// CreateAlertRule godoc
+// @Summary Create alert rule
+// @Description Create an alert rule evaluated against incoming node metrics (operator or admin)
+// @Tags monitoring
+// @Accept json
+// @Produce json
+// @Param rule body services.AlertRuleRequest true "Alert rule"
+// @Success 201 {object} types.APIResponse{data=services.AlertRule}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /monitoring/alerts/rules [post]
+func (h *MonitoringHandler) CreateAlertRule(c *gin.Context) {
+	if _, ok := requireUserRole(c, h.authService, models.UserRoleOperator); !ok {
+		return
+	}
+
+	var req services.AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rule, err := h.monitoringService.CreateAlertRule(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(alertRuleErrorStatus(err), types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, types.APIResponse{
+		Success: true,
+		Message: "Alert rule created successfully",
+		Data:    rule,
+	})
+}
+
+// UpdateAlertRule godoc
+// @Summary Update alert rule
+// @Description Replace an alert rule's condition and settings; active alerts raised by the rule are resolved (operator or admin)
+// @Tags monitoring
+// @Accept json
+// @Produce json
+// @Param id path string true "Alert rule ID"
+// @Param rule body services.AlertRuleRequest true "Alert rule"
+// @Success 200 {object} types.APIResponse{data=services.AlertRule}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /monitoring/alerts/rules/{id} [put]
+func (h *MonitoringHandler) UpdateAlertRule(c *gin.Context) {
+	if _, ok := requireUserRole(c, h.authService, models.UserRoleOperator); !ok {
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid alert rule ID format",
+		})
+		return
+	}
+
+	var req services.AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rule, err := h.monitoringService.UpdateAlertRule(c.Request.Context(), id, &req)
+	if err != nil {
+		c.JSON(alertRuleErrorStatus(err), types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "Alert rule updated successfully",
+		Data:    rule,
+	})
+}
+
+// DeleteAlertRule godoc
+// @Summary Delete alert rule
+// @Description Delete an alert rule and resolve its active alerts (operator or admin)
+// @Tags monitoring
+// @Accept json
+// @Produce json
+// @Param id path string true "Alert rule ID"
+// @Success 200 {object} types.APIResponse
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /monitoring/alerts/rules/{id} [delete]
+func (h *MonitoringHandler) DeleteAlertRule(c *gin.Context) {
+	if _, ok := requireUserRole(c, h.authService, models.UserRoleOperator); !ok {
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid alert rule ID format",
+		})
+		return
+	}
+
+	if err := h.monitoringService.DeleteAlertRule(c.Request.Context(), id); err != nil {
+		c.JSON(alertRuleErrorStatus(err), types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "Alert rule deleted successfully",
+	})
+}
+
+// GetAlerts godoc
+// @Summary List alerts
+// @Description Get triggered alerts, optionally filtered by status and node
+// @Tags monitoring
+// @Accept json
+// @Produce json
+// @Param status query string false "Filter by status" Enums(active,resolved)
+// @Param node_id query string false "Filter by node ID"
+// @Success 200 {object} types.APIResponse{data=[]services.Alert}
+// @Failure 400 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /monitoring/alerts [get]
+func (h *MonitoringHandler) GetAlerts(c *gin.Context) {
+	var nodeID *uuid.UUID
+	if nodeIDStr := c.Query("node_id"); nodeIDStr != "" {
+		id, err := uuid.Parse(nodeIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error:   "Invalid node ID format",
+			})
+			return
+		}
+		nodeID = &id
+	}
+
+	alerts, err := h.monitoringService.GetAlerts(c.Request.Context(), c.Query("status"), nodeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    alerts,
+	})
+}
+
+func alertRuleErrorStatus(err error) int {
+	switch err {
+	case services.ErrAlertRuleNotFound:
+		return http.StatusNotFound
+	case services.ErrUnknownMetric, services.ErrInvalidOperator, services.ErrInvalidSeverity:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
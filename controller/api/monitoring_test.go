@@ -0,0 +1,286 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/common/expfmt"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+	"gorm.io/gorm"
+)
+
+func dialStream(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/monitoring/stream" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial %s: %v", url, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) services.NodeEvent {
+	t.Helper()
+
+	var event services.NodeEvent
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatalf("failed to read event: %v", err)
+	}
+	return event
+}
+
+// newMonitoringTestDB adds the tables node metric reports are recorded in.
+func newMonitoringTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db := newRBACTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE node_metrics_history (
+			id TEXT PRIMARY KEY, node_id TEXT NOT NULL, cpu_usage REAL, memory_usage REAL, disk_usage REAL,
+			network_rx INTEGER, network_tx INTEGER, wg_peers INTEGER, latency REAL, packet_loss REAL,
+			bandwidth INTEGER, recorded_at DATETIME NOT NULL)`,
+		`CREATE TABLE alert_rules (id TEXT PRIMARY KEY, enabled BOOLEAN)`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create test schema: %v", err)
+		}
+	}
+	return db
+}
+
+func TestStreamNodeEventsPushesMetrics(t *testing.T) {
+	db := newMonitoringTestDB(t)
+	var nodes []*models.Node
+	for _, name := range []string{"spoke-a", "spoke-b"} {
+		node := &models.Node{Name: name, NodeType: models.NodeTypeSpoke, PublicKey: name, AllocatedIP: "10.100.0.2", Status: models.NodeStatusActive}
+		if err := db.Create(node).Error; err != nil {
+			t.Fatalf("failed to create node: %v", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	monitoringService := services.NewMonitoringService(db, nil)
+	handler := NewMonitoringHandler(monitoringService, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v1/monitoring/stream", handler.StreamNodeEvents)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	all := dialStream(t, server, "")
+	filtered := dialStream(t, server, "?node_id="+nodes[1].ID.String())
+
+	// Subscriptions are registered after the upgrade completes
+	deadline := time.Now().Add(5 * time.Second)
+	for monitoringService.NodeEventSubscriberCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for stream subscriptions")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	report := map[string]interface{}{"cpu_usage": 12.5, "wg_status": "up"}
+	if err := monitoringService.UpdateNodeMetrics(context.Background(), nodes[0].ID, report); err != nil {
+		t.Fatalf("UpdateNodeMetrics failed: %v", err)
+	}
+
+	status := readEvent(t, all)
+	if status.Type != services.NodeEventStatus || status.NodeID != nodes[0].ID || status.Status != string(models.NodeStatusActive) || status.WGStatus != "up" {
+		t.Errorf("expected a status event for the first report, got %+v", status)
+	}
+	metrics := readEvent(t, all)
+	if metrics.Type != services.NodeEventMetrics || metrics.NodeID != nodes[0].ID {
+		t.Fatalf("expected a metrics event, got %+v", metrics)
+	}
+	if metrics.Metrics == nil || metrics.Metrics.CPUUsage != 12.5 {
+		t.Errorf("expected the reported metrics, got %+v", metrics.Metrics)
+	}
+
+	// The filtered subscriber sees only its own node's events
+	if err := monitoringService.UpdateNodeMetrics(context.Background(), nodes[1].ID, report); err != nil {
+		t.Fatalf("UpdateNodeMetrics failed: %v", err)
+	}
+	if event := readEvent(t, filtered); event.NodeID != nodes[1].ID {
+		t.Errorf("expected only events for %s, got %+v", nodes[1].ID, event)
+	}
+}
+
+func TestStreamNodeEventsRejectsInvalidNodeID(t *testing.T) {
+	handler := NewMonitoringHandler(services.NewMonitoringService(nil, nil), nil)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/stream", handler.StreamNodeEvents)
+
+	if w := serve(router, http.MethodGet, "/stream?node_id=not-a-uuid"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid node_id, got %d", w.Code)
+	}
+	if w := serve(router, http.MethodGet, "/stream?node_id="+uuid.New().String()); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a plain request to be refused the upgrade, got %d", w.Code)
+	}
+}
+
+func TestPrometheusMetricsEscapeLabelValues(t *testing.T) {
+	db := newMonitoringTestDB(t)
+	name := "branch \"east\"\\dc1\nrack 2"
+	node := &models.Node{Name: name, NodeType: models.NodeTypeSpoke, PublicKey: "escape-test", AllocatedIP: "10.100.0.2", Status: models.NodeStatusActive}
+	if err := db.Create(node).Error; err != nil {
+		t.Fatalf("failed to create node: %v", err)
+	}
+
+	monitoringService := services.NewMonitoringService(db, nil)
+	if err := monitoringService.UpdateNodeMetrics(context.Background(), node.ID, map[string]interface{}{"cpu_usage": 12.5}); err != nil {
+		t.Fatalf("UpdateNodeMetrics failed: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/metrics", NewMonitoringHandler(monitoringService, nil).GetPrometheusMetrics)
+
+	w := serve(router, http.MethodGet, "/metrics")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(w.Body.String()))
+	if err != nil {
+		t.Fatalf("expected the exposition to parse, got %v:\n%s", err, w.Body.String())
+	}
+	cpu, ok := families["wg_sdwan_node_cpu_usage"]
+	if !ok || len(cpu.GetMetric()) != 1 {
+		t.Fatalf("expected one wg_sdwan_node_cpu_usage series, got %v", cpu)
+	}
+	var nodeName string
+	for _, label := range cpu.GetMetric()[0].GetLabel() {
+		if label.GetName() == "node_name" {
+			nodeName = label.GetValue()
+		}
+	}
+	if nodeName != name {
+		t.Errorf("expected node_name %q after unescaping, got %q", name, nodeName)
+	}
+}
+
+func TestUpdateNodeMetricsStoresJSONNumbers(t *testing.T) {
+	db := newMonitoringTestDB(t)
+	node := &models.Node{Name: "spoke-a", NodeType: models.NodeTypeSpoke, PublicKey: "spoke-a", AllocatedIP: "10.100.0.2", Status: models.NodeStatusActive}
+	if err := db.Create(node).Error; err != nil {
+		t.Fatalf("failed to create node: %v", err)
+	}
+
+	handler := NewMonitoringHandler(services.NewMonitoringService(db, nil), nil)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/v1/monitoring/nodes/:node_id/metrics", handler.UpdateNodeMetrics)
+	router.GET("/api/v1/monitoring/nodes/:node_id/metrics", handler.GetNodeMetrics)
+	path := "/api/v1/monitoring/nodes/" + node.ID.String() + "/metrics"
+
+	// As sent by the agent
+	report := json.RawMessage(`{"cpu_usage": 41.5, "memory_usage": 63.2, "network_rx": 8589934592, "network_tx": 1048576, "wg_peers": 3, "latency_ms": 12.5, "wg_status": "up"}`)
+	if w := serveJSON(router, http.MethodPost, path, "", report); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := serveJSON(router, http.MethodGet, path, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data services.NodeMetrics `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	stored := resp.Data
+	if stored.NetworkRx != 8589934592 || stored.NetworkTx != 1048576 || stored.WGPeers != 3 {
+		t.Errorf("expected the counters to be stored, got rx=%d tx=%d peers=%d", stored.NetworkRx, stored.NetworkTx, stored.WGPeers)
+	}
+	if stored.CPUUsage != 41.5 || stored.Latency != 12.5 {
+		t.Errorf("expected cpu 41.5 and latency 12.5, got %v and %v", stored.CPUUsage, stored.Latency)
+	}
+}
+
+func TestGetNodeConfigRecordsFetch(t *testing.T) {
+	db := newMonitoringTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE topology (
+			id TEXT PRIMARY KEY, hub_id TEXT NOT NULL, spoke_id TEXT NOT NULL,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE policies (
+			id TEXT PRIMARY KEY, name TEXT NOT NULL, description TEXT,
+			source_node_id TEXT, destination_node_id TEXT, source_group TEXT, destination_group TEXT,
+			source_group_id TEXT, destination_group_id TEXT,
+			source_c_id_r TEXT, destination_c_id_r TEXT,
+			protocol TEXT, port INTEGER, action TEXT NOT NULL, priority INTEGER DEFAULT 100,
+			enabled BOOLEAN DEFAULT TRUE, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create test schema: %v", err)
+		}
+	}
+	node := &models.Node{Name: "hub-1", NodeType: models.NodeTypeHub, PublicKey: "fetch-test", AllocatedIP: "10.100.0.1", Status: models.NodeStatusActive}
+	if err := db.Omit("AllowedIPs", "BackupHubIDs", "Routes", "PreUp", "PostUp", "PreDown", "PostDown").Create(node).Error; err != nil {
+		t.Fatalf("failed to create node: %v", err)
+	}
+
+	config := &types.Config{WG: types.WGConfig{Subnet: "10.100.0.0/16", MTU: 1420}}
+	auditService := services.NewAuditService(db)
+	monitoringService := services.NewMonitoringService(db, nil)
+	nodesHandler := NewNodesHandler(services.NewNodeService(db, config, auditService), monitoringService, nil, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/nodes/:id/config", nodesHandler.GetNodeConfig)
+	router.GET("/metrics", NewMonitoringHandler(monitoringService, nil).GetPrometheusMetrics)
+
+	for i := 0; i < 2; i++ {
+		if w := serve(router, http.MethodGet, "/nodes/"+node.ID.String()+"/config"); w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	// A failed fetch isn't counted
+	if w := serve(router, http.MethodGet, "/nodes/"+uuid.NewString()+"/config"); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown node, got %d", w.Code)
+	}
+
+	stats := monitoringService.GetConfigFetchStats(context.Background())
+	fetches, ok := stats[node.ID]
+	if len(stats) != 1 || !ok {
+		t.Fatalf("expected fetches recorded for the node only, got %+v", stats)
+	}
+	if fetches.Count != 2 || fetches.TotalLatency <= 0 || fetches.LastLatency <= 0 || fetches.MaxLatency < fetches.LastLatency {
+		t.Errorf("expected two fetches with latency samples, got %+v", fetches)
+	}
+	if fetches.LastFetchedAt.IsZero() {
+		t.Error("expected the fetch time to be recorded")
+	}
+
+	w := serve(router, http.MethodGet, "/metrics")
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(w.Body.String()))
+	if err != nil {
+		t.Fatalf("expected the exposition to parse, got %v", err)
+	}
+	total := families["wg_sdwan_config_fetch_total"]
+	if total == nil || len(total.GetMetric()) != 1 || total.GetMetric()[0].GetCounter().GetValue() != 2 {
+		t.Errorf("expected wg_sdwan_config_fetch_total of 2, got %v", total)
+	}
+	latency := families["wg_sdwan_config_generation_seconds"]
+	if latency == nil || len(latency.GetMetric()) != 1 || latency.GetMetric()[0].GetSummary().GetSampleCount() != 2 {
+		t.Errorf("expected two wg_sdwan_config_generation_seconds samples, got %v", latency)
+	}
+}
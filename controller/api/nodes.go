@@ -1,8 +1,14 @@
 package api
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -12,27 +18,40 @@ import (
 )
 
 type NodesHandler struct {
-	nodeService *services.NodeService
+	nodeService       *services.NodeService
+	monitoringService *services.MonitoringService
+	authService       *services.AuthService
+	approvalService   *services.ApprovalService
 }
 
-func NewNodesHandler(nodeService *services.NodeService) *NodesHandler {
+func NewNodesHandler(nodeService *services.NodeService, monitoringService *services.MonitoringService, authService *services.AuthService, approvalService *services.ApprovalService) *NodesHandler {
 	return &NodesHandler{
-		nodeService: nodeService,
+		nodeService:       nodeService,
+		monitoringService: monitoringService,
+		authService:       authService,
+		approvalService:   approvalService,
 	}
 }
 
 // RegisterNode godoc
 // @Summary Register a new node
-// @Description Register a new hub or spoke node in the network
+// @Description Register a new hub or spoke node in the network (operator or admin)
 // @Tags nodes
 // @Accept json
 // @Produce json
 // @Param node body types.NodeRegistrationRequest true "Node registration data"
 // @Success 201 {object} types.APIResponse{data=models.Node}
 // @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 409 {object} types.APIResponse
 // @Failure 500 {object} types.APIResponse
 // @Router /nodes [post]
 func (h *NodesHandler) RegisterNode(c *gin.Context) {
+	user, ok := requireUserRole(c, h.authService, models.UserRoleOperator)
+	if !ok {
+		return
+	}
+
 	var req types.NodeRegistrationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
@@ -41,9 +60,26 @@ func (h *NodesHandler) RegisterNode(c *gin.Context) {
 		})
 		return
 	}
+	if !mayChangeHooks(c, user, req.InterfaceHooks) {
+		return
+	}
 
 	node, err := h.nodeService.RegisterNode(c.Request.Context(), req)
 	if err != nil {
+		if errors.Is(err, services.ErrPortInUse) || errors.Is(err, services.ErrPortRangeExhausted) {
+			c.JSON(http.StatusConflict, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		if isInvalidNodeRequest(err) {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
 			Error:   err.Error(),
@@ -51,6 +87,10 @@ func (h *NodesHandler) RegisterNode(c *gin.Context) {
 		return
 	}
 
+	if req.InterfaceHooks.IsSet() {
+		h.nodeService.LogHookChange(c.Request.Context(), node, req.InterfaceHooks, &user.ID, c.ClientIP(), c.GetHeader("User-Agent"))
+	}
+
 	c.JSON(http.StatusCreated, types.APIResponse{
 		Success: true,
 		Data:    node,
@@ -68,7 +108,12 @@ func (h *NodesHandler) RegisterNode(c *gin.Context) {
 // @Param per_page query int false "Items per page" default(10)
 // @Param node_type query string false "Filter by node type" Enums(hub,spoke)
 // @Param status query string false "Filter by status" Enums(pending,active,inactive,disabled)
+// @Param search query string false "Search by name or description"
+// @Param tag query []string false "Filter by tag as key:value, or key for any value; repeat to require several" collectionFormat(multi)
+// @Param sort query string false "Sort field" Enums(name,created_at,status,last_handshake) default(created_at)
+// @Param order query string false "Sort order" Enums(asc,desc) default(asc)
 // @Success 200 {object} types.PaginatedResponse{data=[]models.Node}
+// @Failure 400 {object} types.APIResponse
 // @Failure 500 {object} types.APIResponse
 // @Router /nodes [get]
 func (h *NodesHandler) GetNodes(c *gin.Context) {
@@ -76,9 +121,20 @@ func (h *NodesHandler) GetNodes(c *gin.Context) {
 	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "10"))
 	nodeType := c.Query("node_type")
 	status := c.Query("status")
+	search := c.Query("search")
+	tags := c.QueryArray("tag")
+	sort := c.DefaultQuery("sort", "created_at")
+	order := strings.ToLower(c.DefaultQuery("order", "asc"))
 
-	nodes, total, err := h.nodeService.GetNodes(c.Request.Context(), page, perPage, nodeType, status)
+	nodes, total, err := h.nodeService.GetNodes(c.Request.Context(), page, perPage, nodeType, status, search, tags, sort, order)
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidTag) || errors.Is(err, services.ErrInvalidSort) || errors.Is(err, services.ErrInvalidSortOrder) {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
 			Error:   err.Error(),
@@ -98,6 +154,8 @@ func (h *NodesHandler) GetNodes(c *gin.Context) {
 			PerPage:    perPage,
 			Total:      total,
 			TotalPages: totalPages,
+			Sort:       sort,
+			Order:      order,
 		},
 	})
 }
@@ -148,7 +206,7 @@ func (h *NodesHandler) GetNode(c *gin.Context) {
 
 // UpdateNode godoc
 // @Summary Update a node
-// @Description Update node details
+// @Description Update node details (operator or admin, or the node's own agent)
 // @Tags nodes
 // @Accept json
 // @Produce json
@@ -156,10 +214,22 @@ func (h *NodesHandler) GetNode(c *gin.Context) {
 // @Param node body types.NodeUpdateRequest true "Node update data"
 // @Success 200 {object} types.APIResponse{data=models.Node}
 // @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
 // @Failure 404 {object} types.APIResponse
+// @Failure 409 {object} types.APIResponse
 // @Failure 500 {object} types.APIResponse
 // @Router /nodes/{id} [put]
 func (h *NodesHandler) UpdateNode(c *gin.Context) {
+	// Agents update their own node, which AuthMiddleware already checked
+	_, isAgent := c.Get("current_node")
+	var user *models.User
+	if !isAgent {
+		var ok bool
+		if user, ok = requireUserRole(c, h.authService, models.UserRoleOperator); !ok {
+			return
+		}
+	}
+
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
@@ -179,6 +249,38 @@ func (h *NodesHandler) UpdateNode(c *gin.Context) {
 		return
 	}
 
+	if isAgent && !agentMayUpdate(req) {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Agents may only report the node's status, endpoint and port",
+		})
+		return
+	}
+	if user != nil && !mayChangeHooks(c, user, req.InterfaceHooks) {
+		return
+	}
+
+	// Agents send their heartbeat through this endpoint. A missing node is
+	// reported by UpdateNode below.
+	if isAgent {
+		if err := h.nodeService.RecordHeartbeat(c.Request.Context(), id, time.Now()); err != nil && !errors.Is(err, services.ErrNodeNotFound) {
+			slog.WarnContext(c.Request.Context(), "Failed to record node heartbeat", "node_id", id, "error", err)
+		}
+
+		// A disabled or pending node stays that way until an operator
+		// changes it; its agent can't bring it back with a heartbeat
+		if req.Status != nil {
+			current, err := h.nodeService.GetNode(c.Request.Context(), id)
+			if err == nil && current.Status != models.NodeStatusActive && current.Status != models.NodeStatusInactive {
+				c.JSON(http.StatusForbidden, types.APIResponse{
+					Success: false,
+					Error:   fmt.Sprintf("Node is %s; only an operator can change its status", current.Status),
+				})
+				return
+			}
+		}
+	}
+
 	node, err := h.nodeService.UpdateNode(c.Request.Context(), id, req)
 	if err != nil {
 		if err == services.ErrNodeNotFound {
@@ -188,6 +290,20 @@ func (h *NodesHandler) UpdateNode(c *gin.Context) {
 			})
 			return
 		}
+		if errors.Is(err, services.ErrEndpointUnreachable) || errors.Is(err, services.ErrPortInUse) {
+			c.JSON(http.StatusConflict, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		if isInvalidNodeRequest(err) {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, types.APIResponse{
 			Success: false,
 			Error:   err.Error(),
@@ -195,6 +311,10 @@ func (h *NodesHandler) UpdateNode(c *gin.Context) {
 		return
 	}
 
+	if user != nil && req.InterfaceHooks.IsSet() {
+		h.nodeService.LogHookChange(c.Request.Context(), node, req.InterfaceHooks, &user.ID, c.ClientIP(), c.GetHeader("User-Agent"))
+	}
+
 	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
 		Data:    node,
@@ -202,18 +322,86 @@ func (h *NodesHandler) UpdateNode(c *gin.Context) {
 	})
 }
 
+// UpdateNodeStatuses godoc
+// @Summary Update the status of several nodes
+// @Description Move every listed node to one status in a single transaction, auditing each change; nothing changes if any node is missing (operator or admin)
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param request body types.NodeStatusUpdateRequest true "Node IDs and target status"
+// @Success 200 {object} types.APIResponse{data=[]services.NodeStatusChange}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 409 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /nodes/status [patch]
+func (h *NodesHandler) UpdateNodeStatuses(c *gin.Context) {
+	user, ok := requireUserRole(c, h.authService, models.UserRoleOperator)
+	if !ok {
+		return
+	}
+
+	var req types.NodeStatusUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	changes, err := h.nodeService.UpdateNodeStatuses(c.Request.Context(), req.NodeIDs, models.NodeStatus(req.Status), &user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidNodeStatus):
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		case errors.Is(err, services.ErrNodeNotFound):
+			c.JSON(http.StatusNotFound, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		case errors.Is(err, services.ErrEndpointUnreachable):
+			c.JSON(http.StatusConflict, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    changes,
+		Message: fmt.Sprintf("Updated the status of %d nodes", len(changes)),
+	})
+}
+
 // DeleteNode godoc
 // @Summary Delete a node
-// @Description Delete a node from the network
+// @Description Delete a node from the network (operator or admin)
 // @Tags nodes
 // @Accept json
 // @Produce json
 // @Param id path string true "Node ID"
 // @Success 200 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
 // @Failure 404 {object} types.APIResponse
 // @Failure 500 {object} types.APIResponse
 // @Router /nodes/{id} [delete]
 func (h *NodesHandler) DeleteNode(c *gin.Context) {
+	if _, ok := requireUserRole(c, h.authService, models.UserRoleOperator); !ok {
+		return
+	}
+
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
@@ -268,6 +456,7 @@ func (h *NodesHandler) GetNodeConfig(c *gin.Context) {
 		return
 	}
 
+	start := time.Now()
 	config, err := h.nodeService.GetNodeConfig(c.Request.Context(), id)
 	if err != nil {
 		if err == services.ErrNodeNotFound {
@@ -283,9 +472,557 @@ func (h *NodesHandler) GetNodeConfig(c *gin.Context) {
 		})
 		return
 	}
+	h.monitoringService.RecordConfigFetch(id, time.Since(start))
 
 	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
 		Data:    config,
 	})
-}
\ No newline at end of file
+}
+
+// GetPreviousNodeConfig godoc
+// @Summary Get previous node configuration
+// @Description Get the WireGuard configuration a node was served before its current one, for comparison (admin only, requires the config_history feature)
+// @Tags nodes
+// @Produce json
+// @Param id path string true "Node ID"
+// @Success 200 {object} types.APIResponse{data=types.NodeConfigResponse}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /nodes/{id}/config/previous [get]
+func (h *NodesHandler) GetPreviousNodeConfig(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid node ID format",
+		})
+		return
+	}
+
+	config, err := h.nodeService.GetPreviousNodeConfig(c.Request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrNodeNotFound):
+			c.JSON(http.StatusNotFound, types.APIResponse{
+				Success: false,
+				Error:   "Node not found",
+			})
+		case errors.Is(err, services.ErrNoPreviousConfig):
+			c.JSON(http.StatusNotFound, types.APIResponse{
+				Success: false,
+				Error:   "No previous configuration served to this node",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    config,
+	})
+}
+
+// DownloadAgentConfig godoc
+// @Summary Download agent configuration
+// @Description Generate a ready-to-deploy agent.yaml for a node, including a freshly issued agent token (admin only)
+// @Tags nodes
+// @Produce application/x-yaml
+// @Param id path string true "Node ID"
+// @Success 200 {file} file
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /nodes/{id}/agent-config [get]
+func (h *NodesHandler) DownloadAgentConfig(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	// The file carries a long-lived agent credential
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid node ID format",
+		})
+		return
+	}
+
+	node, err := h.nodeService.GetNode(c.Request.Context(), id)
+	if err != nil {
+		if err == services.ErrNodeNotFound {
+			c.JSON(http.StatusNotFound, types.APIResponse{
+				Success: false,
+				Error:   "Node not found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	token, _, err := h.authService.GenerateNodeToken(c.Request.Context(), node, &user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// The controller's address comes from its own configuration, never from
+	// the request's Host header, which the client chooses
+	data, err := h.nodeService.GenerateAgentConfig(node, "", token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=agent.yaml")
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/x-yaml", data)
+}
+
+// ReportPeerErrors godoc
+// @Summary Report skipped peers
+// @Description Report peers that an agent could not apply to its WireGuard interface
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param id path string true "Node ID"
+// @Param report body types.PeerApplyReport true "Peer apply report"
+// @Success 200 {object} types.APIResponse
+// @Failure 400 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /nodes/{id}/peer-errors [post]
+func (h *NodesHandler) ReportPeerErrors(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid node ID format",
+		})
+		return
+	}
+
+	var report types.PeerApplyReport
+	if err := c.ShouldBindJSON(&report); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := h.nodeService.ReportPeerErrors(c.Request.Context(), id, report); err != nil {
+		if err == services.ErrNodeNotFound {
+			c.JSON(http.StatusNotFound, types.APIResponse{
+				Success: false,
+				Error:   "Node not found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "Peer errors reported successfully",
+	})
+}
+
+// RotateNodeKey godoc
+// @Summary Rotate a node's WireGuard key
+// @Description Ask a node's agent to generate a new key pair; it submits the new public key on its next config refresh and peers move to it after that (operator or admin). With dual approval enabled the rotation waits for a second admin and 202 returns the approval request.
+// @Tags nodes
+// @Produce json
+// @Param id path string true "Node ID"
+// @Success 202 {object} types.APIResponse{data=models.Node}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /nodes/{id}/rotate-key [post]
+func (h *NodesHandler) RotateNodeKey(c *gin.Context) {
+	user, ok := requireUserRole(c, h.authService, models.UserRoleOperator)
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid node ID format",
+		})
+		return
+	}
+
+	if h.approvalService.Required() {
+		node, err := h.nodeService.GetNode(c.Request.Context(), id)
+		if err != nil {
+			if errors.Is(err, services.ErrNodeNotFound) {
+				c.JSON(http.StatusNotFound, types.APIResponse{
+					Success: false,
+					Error:   "Node not found",
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		requestApproval(c, h.approvalService, services.ApprovalActionRotateNodeKey,
+			map[string]interface{}{"node_id": id}, user, fmt.Sprintf("Rotate the key of node %s", node.Name))
+		return
+	}
+
+	node, err := h.nodeService.RequestKeyRotation(c.Request.Context(), id, &user.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrNodeNotFound) {
+			c.JSON(http.StatusNotFound, types.APIResponse{
+				Success: false,
+				Error:   "Node not found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, types.APIResponse{
+		Success: true,
+		Data:    node,
+		Message: "Key rotation requested; the node's agent will submit a new public key",
+	})
+}
+
+// UpdateNodePublicKey godoc
+// @Summary Submit a node's new public key
+// @Description Replace a node's public key with one its agent generated, completing a requested key rotation. The private key stays on the node.
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param id path string true "Node ID"
+// @Param key body types.NodePublicKeyRequest true "New public key"
+// @Success 200 {object} types.APIResponse{data=models.Node}
+// @Success 202 {object} types.APIResponse{data=services.ApprovalRequest}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 409 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /nodes/{id}/public-key [put]
+func (h *NodesHandler) UpdateNodePublicKey(c *gin.Context) {
+	// Agents submit their own node's key, which AuthMiddleware already checked
+	var user *models.User
+	var performedBy *uuid.UUID
+	if _, isAgent := c.Get("current_node"); !isAgent {
+		var ok bool
+		user, ok = requireUserRole(c, h.authService, models.UserRoleOperator)
+		if !ok {
+			return
+		}
+		performedBy = &user.ID
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid node ID format",
+		})
+		return
+	}
+
+	var req types.NodePublicKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// Replacing a key by hand skips the rotation an agent does, so it takes
+	// the same approval as requesting one
+	if user != nil && h.approvalService.Required() {
+		if types.ValidateKey(req.PublicKey) != nil {
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error:   services.ErrInvalidPublicKey.Error(),
+			})
+			return
+		}
+		node, err := h.nodeService.GetNode(c.Request.Context(), id)
+		if err != nil {
+			if errors.Is(err, services.ErrNodeNotFound) {
+				c.JSON(http.StatusNotFound, types.APIResponse{
+					Success: false,
+					Error:   "Node not found",
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		requestApproval(c, h.approvalService, services.ApprovalActionSetNodeKey,
+			map[string]interface{}{"node_id": id, "public_key": req.PublicKey}, user, fmt.Sprintf("Replace the public key of node %s", node.Name))
+		return
+	}
+
+	node, err := h.nodeService.UpdateNodePublicKey(c.Request.Context(), id, req.PublicKey, performedBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidPublicKey):
+			c.JSON(http.StatusBadRequest, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		case errors.Is(err, services.ErrNodeNotFound):
+			c.JSON(http.StatusNotFound, types.APIResponse{
+				Success: false,
+				Error:   "Node not found",
+			})
+		case errors.Is(err, services.ErrPublicKeyInUse):
+			c.JSON(http.StatusConflict, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    node,
+		Message: "Node public key updated successfully",
+	})
+}
+
+// CheckDuplicateIPs godoc
+// @Summary Check for duplicate allocated IPs
+// @Description Find nodes sharing an allocated IP and optionally move all but the oldest to free addresses (operator or admin)
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param repair query bool false "Reallocate conflicting nodes" default(false)
+// @Success 200 {object} types.APIResponse{data=services.IPConsistencyReport}
+// @Failure 403 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /nodes/check-ips [post]
+func (h *NodesHandler) CheckDuplicateIPs(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleOperator); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Operator access required",
+		})
+		return
+	}
+
+	repair := c.Query("repair") == "true"
+	report, err := h.nodeService.CheckDuplicateIPs(c.Request.Context(), repair, &user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
+
+// RebalanceTopology godoc
+// @Summary Rebalance spokes across hubs
+// @Description Redistribute unpinned spokes evenly across active hubs; pinned spokes stay on their pinned or backup hubs (operator or admin)
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Success 200 {object} types.APIResponse{data=map[string]int}
+// @Failure 403 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /nodes/rebalance [post]
+func (h *NodesHandler) RebalanceTopology(c *gin.Context) {
+	if _, ok := requireUserRole(c, h.authService, models.UserRoleOperator); !ok {
+		return
+	}
+
+	moved, err := h.nodeService.RebalanceTopology(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data: map[string]int{
+			"moved": moved,
+		},
+		Message: "Topology rebalanced successfully",
+	})
+}
+
+// GetTopology godoc
+// @Summary Get the topology graph
+// @Description Get every node and the hub/spoke links between them, with each link's latest health from monitoring
+// @Tags nodes
+// @Produce json
+// @Success 200 {object} types.APIResponse{data=services.TopologyGraph}
+// @Failure 500 {object} types.APIResponse
+// @Router /topology [get]
+func (h *NodesHandler) GetTopology(c *gin.Context) {
+	graph, err := h.nodeService.GetTopologyGraph(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if h.monitoringService != nil {
+		h.monitoringService.AnnotateLinkHealth(graph)
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    graph,
+	})
+}
+
+// mayChangeHooks refuses requests that set interface hooks unless user is an
+// admin: hooks run as root on the node, so setting them is as good as a
+// shell there.
+func mayChangeHooks(c *gin.Context, user *models.User, hooks types.InterfaceHooks) bool {
+	if !hooks.IsSet() || user.Role == models.UserRoleAdmin {
+		return true
+	}
+	c.JSON(http.StatusForbidden, types.APIResponse{
+		Success: false,
+		Error:   "Admin access required to set interface hooks",
+	})
+	return false
+}
+
+// agentMayUpdate reports whether req only touches what an agent may change
+// on its own node: the status it reports, active or inactive, and where it
+// listens. Anything else, including fields added later, is for operators.
+func agentMayUpdate(req types.NodeUpdateRequest) bool {
+	allowed := types.NodeUpdateRequest{
+		Status:   req.Status,
+		Endpoint: req.Endpoint,
+		Port:     req.Port,
+	}
+	if !reflect.DeepEqual(req, allowed) {
+		return false
+	}
+	if req.Status != nil {
+		status := models.NodeStatus(*req.Status)
+		return status == models.NodeStatusActive || status == models.NodeStatusInactive
+	}
+	return true
+}
+
+// isInvalidNodeRequest reports whether a register or update failed on the
+// request's contents rather than on the server.
+func isInvalidNodeRequest(err error) bool {
+	for _, target := range []error{
+		services.ErrInvalidPinnedHub,
+		services.ErrInvalidRoute,
+		services.ErrInvalidPublicKey,
+		services.ErrInvalidTag,
+		services.ErrInvalidKeepalive,
+		services.ErrInvalidAllowedIP,
+		types.ErrInvalidHook,
+		types.ErrInvalidEndpoint,
+		types.ErrControlChars,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+)
+
+type PoliciesHandler struct {
+	policyService *services.PolicyService
+	authService   *services.AuthService
+}
+
+func NewPoliciesHandler(policyService *services.PolicyService, authService *services.AuthService) *PoliciesHandler {
+	return &PoliciesHandler{
+		policyService: policyService,
+		authService:   authService,
+	}
+}
+
+// GetPolicies godoc
+// @Summary List policies
+// @Description Get all access policies in evaluation order
+// @Tags policies
+// @Produce json
+// @Param search query string false "Search by name or description"
+// @Success 200 {object} types.APIResponse{data=[]models.Policy}
+// @Failure 500 {object} types.APIResponse
+// @Router /policies [get]
+func (h *PoliciesHandler) GetPolicies(c *gin.Context) {
+	policies, err := h.policyService.GetPolicies(c.Request.Context(), c.Query("search"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    policies,
+	})
+}
+
+// GetPolicy godoc
+// @Summary Get policy
+// @Description Get an access policy by ID
+// @Tags policies
+// @Produce json
+// @Param id path string true "Policy ID"
+// @Success 200 {object} types.APIResponse{data=models.Policy}
+// @Failure 400 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Router /policies/{id} [get]
+func (h *PoliciesHandler) GetPolicy(c *gin.Context) {
+	id, ok := parsePolicyID(c)
+	if !ok {
+		return
+	}
+
+	policy, err := h.policyService.GetPolicy(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(policyErrorStatus(err), types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    policy,
+	})
+}
+
+// CreatePolicy godoc
+// @Summary Create policy
+// @Description Create an access policy; node configs leave out the AllowedIPs it denies (operator or admin)
+// @Tags policies
+// @Accept json
+// @Produce json
+// @Param policy body services.PolicyRequest true "Policy"
+// @Success 201 {object} types.APIResponse{data=models.Policy}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /policies [post]
+func (h *PoliciesHandler) CreatePolicy(c *gin.Context) {
+	user, ok := requireUserRole(c, h.authService, models.UserRoleOperator)
+	if !ok {
+		return
+	}
+
+	var req services.PolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	policy, err := h.policyService.CreatePolicy(c.Request.Context(), &req, &user.ID)
+	if err != nil {
+		c.JSON(policyErrorStatus(err), types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, types.APIResponse{
+		Success: true,
+		Message: "Policy created successfully",
+		Data:    policy,
+	})
+}
+
+// UpdatePolicy godoc
+// @Summary Update policy
+// @Description Replace an access policy (operator or admin)
+// @Tags policies
+// @Accept json
+// @Produce json
+// @Param id path string true "Policy ID"
+// @Param policy body services.PolicyRequest true "Policy"
+// @Success 200 {object} types.APIResponse{data=models.Policy}
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /policies/{id} [put]
+func (h *PoliciesHandler) UpdatePolicy(c *gin.Context) {
+	user, ok := requireUserRole(c, h.authService, models.UserRoleOperator)
+	if !ok {
+		return
+	}
+
+	id, ok := parsePolicyID(c)
+	if !ok {
+		return
+	}
+
+	var req services.PolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	policy, err := h.policyService.UpdatePolicy(c.Request.Context(), id, &req, &user.ID)
+	if err != nil {
+		c.JSON(policyErrorStatus(err), types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "Policy updated successfully",
+		Data:    policy,
+	})
+}
+
+// DeletePolicy godoc
+// @Summary Delete policy
+// @Description Delete an access policy (operator or admin)
+// @Tags policies
+// @Produce json
+// @Param id path string true "Policy ID"
+// @Success 200 {object} types.APIResponse
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Failure 500 {object} types.APIResponse
+// @Router /policies/{id} [delete]
+func (h *PoliciesHandler) DeletePolicy(c *gin.Context) {
+	user, ok := requireUserRole(c, h.authService, models.UserRoleOperator)
+	if !ok {
+		return
+	}
+
+	id, ok := parsePolicyID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.policyService.DeletePolicy(c.Request.Context(), id, &user.ID); err != nil {
+		c.JSON(policyErrorStatus(err), types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "Policy deleted successfully",
+	})
+}
+
+func parsePolicyID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "Invalid policy ID format",
+		})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func policyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrPolicyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidPolicy):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
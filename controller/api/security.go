@@ -1,8 +1,11 @@
 package api
 
 import (
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wg-hubspoke/wg-hubspoke/common/types"
@@ -234,7 +237,7 @@ func (h *SecurityHandler) GenerateSecureToken(c *gin.Context) {
 // @Param per_page query int false "Items per page" default(10)
 // @Param event_type query string false "Filter by event type"
 // @Param severity query string false "Filter by severity"
-// @Success 200 {object} types.PaginatedResponse{data=[]services.SecurityEvent}
+// @Success 200 {object} types.PaginatedResponse{data=[]services.SecurityEventView}
 // @Failure 403 {object} types.APIResponse
 // @Failure 500 {object} types.APIResponse
 // @Router /security/events [get]
@@ -259,21 +262,36 @@ func (h *SecurityHandler) GetSecurityEvents(c *gin.Context) {
 
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = 10
+	}
 	eventType := c.Query("event_type")
 	severity := c.Query("severity")
 
-	// This would need to be implemented in SecurityService
-	// For now, return a placeholder response
+	events, total, err := h.securityService.GetSecurityEvents(c.Request.Context(), page, perPage, eventType, severity)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	totalPages := int((total + int64(perPage) - 1) / int64(perPage))
+
 	c.JSON(http.StatusOK, types.PaginatedResponse{
 		APIResponse: types.APIResponse{
 			Success: true,
-			Data:    []services.SecurityEvent{},
+			Data:    events,
 		},
 		Pagination: types.PaginationInfo{
 			Page:       page,
 			PerPage:    perPage,
-			Total:      0,
-			TotalPages: 0,
+			Total:      total,
+			TotalPages: totalPages,
 		},
 	})
 }
@@ -320,7 +338,7 @@ func (h *SecurityHandler) AddAllowedIP(c *gin.Context) {
 		return
 	}
 
-	err := h.securityService.AddAllowedCIDR(request.CIDR)
+	err := h.securityService.AddAllowedCIDR(c.Request.Context(), request.CIDR, user.ID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, types.APIResponse{
 			Success: false,
@@ -335,6 +353,102 @@ func (h *SecurityHandler) AddAllowedIP(c *gin.Context) {
 	})
 }
 
+// GetAllowedIPs godoc
+// @Summary Get allowed IPs/CIDRs
+// @Description Get the IP/CIDR whitelist (admin only)
+// @Tags security
+// @Accept json
+// @Produce json
+// @Success 200 {object} types.APIResponse{data=[]string}
+// @Failure 403 {object} types.APIResponse
+// @Router /security/whitelist [get]
+func (h *SecurityHandler) GetAllowedIPs(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Data:    h.securityService.GetAllowedCIDRs(),
+	})
+}
+
+// RemoveAllowedIP godoc
+// @Summary Remove allowed IP/CIDR
+// @Description Remove IP address or CIDR from whitelist (admin only)
+// @Tags security
+// @Accept json
+// @Produce json
+// @Param cidr query string true "IP or CIDR to remove"
+// @Success 200 {object} types.APIResponse
+// @Failure 400 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Router /security/whitelist [delete]
+func (h *SecurityHandler) RemoveAllowedIP(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	cidr := c.Query("cidr")
+	if cidr == "" {
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   "cidr query parameter is required",
+		})
+		return
+	}
+
+	err := h.securityService.RemoveAllowedCIDR(c.Request.Context(), cidr, user.ID)
+	if err != nil {
+		if err == services.ErrCIDRNotAllowlisted {
+			c.JSON(http.StatusNotFound, types.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "IP/CIDR removed from whitelist successfully",
+	})
+}
+
 // GetBlockedIPs godoc
 // @Summary Get blocked IPs
 // @Description Get list of currently blocked IP addresses (admin only)
@@ -363,29 +477,85 @@ func (h *SecurityHandler) GetBlockedIPs(c *gin.Context) {
 		return
 	}
 
-	// This would need to be implemented in SecurityService
-	// For now, return a placeholder response
+	blockedIPs := h.securityService.GetBlockedIPs()
 	c.JSON(http.StatusOK, types.APIResponse{
 		Success: true,
 		Data: map[string]interface{}{
-			"blocked_ips": []string{},
-			"total":       0,
+			"blocked_ips": blockedIPs,
+			"total":       len(blockedIPs),
 		},
 	})
 }
 
+// UnblockIP godoc
+// @Summary Unblock IP
+// @Description Manually remove an IP address from the block list (admin only)
+// @Tags security
+// @Accept json
+// @Produce json
+// @Param ip path string true "Blocked IP address"
+// @Success 200 {object} types.APIResponse
+// @Failure 403 {object} types.APIResponse
+// @Failure 404 {object} types.APIResponse
+// @Router /security/blocked-ips/{ip} [delete]
+func (h *SecurityHandler) UnblockIP(c *gin.Context) {
+	currentUser, exists := c.Get("current_user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	user := currentUser.(*models.User)
+	if err := h.authService.RequireRole(user.Role, models.UserRoleAdmin); err != nil {
+		c.JSON(http.StatusForbidden, types.APIResponse{
+			Success: false,
+			Error:   "Admin access required",
+		})
+		return
+	}
+
+	ip := c.Param("ip")
+	if err := h.securityService.UnblockIP(c.Request.Context(), ip, user.ID); err != nil {
+		if err == services.ErrIPNotBlocked {
+			c.JSON(http.StatusNotFound, types.APIResponse{
+				Success: false,
+				Error:   "IP is not blocked",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, types.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.APIResponse{
+		Success: true,
+		Message: "IP unblocked successfully",
+	})
+}
+
 // SecurityMiddleware provides security middleware for Gin
 func (h *SecurityHandler) SecurityMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Set security headers
 		headers := h.securityService.GetSecurityHeaders()
 		for key, value := range headers {
+			// Browsers ignore HSTS received over plain HTTP
+			if key == "Strict-Transport-Security" && !isHTTPS(c.Request) {
+				continue
+			}
 			c.Header(key, value)
 		}
 
 		// Check if IP is blocked
 		clientIP := c.ClientIP()
-		if h.securityService.IsIPBlocked(clientIP) {
+		if blockedUntil, blocked := h.securityService.BlockedUntil(clientIP); blocked {
+			setRetryAfter(c, blockedUntil)
 			c.JSON(http.StatusTooManyRequests, types.APIResponse{
 				Success: false,
 				Error:   "IP temporarily blocked due to suspicious activity",
@@ -406,6 +576,7 @@ func (h *SecurityHandler) SecurityMiddleware() gin.HandlerFunc {
 
 		// Check rate limiting
 		if !h.securityService.CheckRateLimit(clientIP) {
+			setRetryAfter(c, h.securityService.RateLimitResetTime(clientIP))
 			c.JSON(http.StatusTooManyRequests, types.APIResponse{
 				Success: false,
 				Error:   "Rate limit exceeded",
@@ -414,10 +585,67 @@ func (h *SecurityHandler) SecurityMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Users and agents get an allowance of their own, so that one busy
+		// client cannot use up the limit of everyone behind the same IP
+		client := clientIP
+		if subject := h.tokenSubject(c); subject != "" {
+			if allowed, retryAt := h.securityService.CheckUserRateLimit(subject); !allowed {
+				setRetryAfter(c, retryAt)
+				c.JSON(http.StatusTooManyRequests, types.APIResponse{
+					Success: false,
+					Error:   "User rate limit exceeded",
+				})
+				c.Abort()
+				return
+			}
+			client = subject
+		}
+
+		if allowed, retryAt := h.securityService.CheckRouteRateLimit(c.Request.URL.Path, client); !allowed {
+			setRetryAfter(c, retryAt)
+			c.JSON(http.StatusTooManyRequests, types.APIResponse{
+				Success: false,
+				Error:   "Rate limit exceeded for this endpoint",
+			})
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
 
+// tokenSubject returns the subject of a valid bearer token on the request,
+// or an empty string for anonymous requests and invalid tokens.
+func (h *SecurityHandler) tokenSubject(c *gin.Context) string {
+	if h.authService == nil {
+		return ""
+	}
+
+	tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if tokenString == "" || tokenString == c.GetHeader("Authorization") {
+		return ""
+	}
+
+	if nodeClaims, err := h.authService.ValidateNodeToken(tokenString); err == nil {
+		return nodeClaims.Subject
+	}
+	if claims, err := h.authService.ValidateToken(tokenString); err == nil {
+		return claims.Subject
+	}
+	return ""
+}
+
+// setRetryAfter tells the client how many seconds to wait before retrying,
+// rounded up so that retrying on time is never early.
+func setRetryAfter(c *gin.Context, retryAt time.Time) {
+	seconds := int(math.Ceil(time.Until(retryAt).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+}
+
 // CSRFMiddleware provides CSRF protection
 func (h *SecurityHandler) CSRFMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+	"gorm.io/gorm"
+)
+
+const securityTestJWTSecret = "security-test-secret"
+
+// newSecurityTestDB adds the tables the security service keeps its state in.
+func newSecurityTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db := newRBACTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE security_events (
+			id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), event_type TEXT NOT NULL, severity TEXT NOT NULL,
+			ip TEXT, user_agent TEXT, user_id TEXT, description TEXT, metadata TEXT, created_at DATETIME)`,
+		`CREATE TABLE security_policies (id INTEGER PRIMARY KEY, policies TEXT NOT NULL, updated_by TEXT, updated_at DATETIME)`,
+		`CREATE TABLE allowed_cidrs (id TEXT PRIMARY KEY, cidr TEXT NOT NULL UNIQUE, created_by TEXT, created_at DATETIME)`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create security tables: %v", err)
+		}
+	}
+	return db
+}
+
+// newSecurityMiddlewareTestRouter serves /ping and /api/v1/config/export
+// behind the security middleware with the default policies changed by
+// configure.
+func newSecurityMiddlewareTestRouter(t *testing.T, configure func(*services.SecurityPolicies)) (*gin.Engine, *services.SecurityService) {
+	t.Helper()
+
+	db := newSecurityTestDB(t)
+	config := &types.Config{Auth: types.AuthConfig{JWTSecret: securityTestJWTSecret}}
+	auditService := services.NewAuditService(db)
+	securityService := services.NewSecurityService(db, config, auditService)
+	policies := securityService.GetSecurityPolicies()
+	configure(policies)
+	if err := securityService.UpdateSecurityPolicies(context.Background(), policies, uuid.New()); err != nil {
+		t.Fatalf("UpdateSecurityPolicies failed: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(NewSecurityHandler(securityService, services.NewAuthService(db, config, auditService)).SecurityMiddleware())
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/api/v1/config/export", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router, securityService
+}
+
+func securityTestToken(t *testing.T) string {
+	t.Helper()
+
+	userID := uuid.New()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &services.Claims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString([]byte(securityTestJWTSecret))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func requestFrom(router *gin.Engine, ip string) *httptest.ResponseRecorder {
+	return requestWithToken(router, ip, "/ping", "")
+}
+
+func requestWithToken(router *gin.Engine, ip, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = ip + ":40000"
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func assertRetryAfter(t *testing.T, w *httptest.ResponseRecorder, min, max int) {
+	t.Helper()
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d: %s", w.Code, w.Body.String())
+	}
+	seconds, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil {
+		t.Fatalf("expected Retry-After in seconds, got %q", w.Header().Get("Retry-After"))
+	}
+	if seconds < min || seconds > max {
+		t.Errorf("expected Retry-After between %d and %d, got %d", min, max, seconds)
+	}
+}
+
+func TestSecurityMiddlewareRateLimitRetryAfter(t *testing.T) {
+	router, _ := newSecurityMiddlewareTestRouter(t, func(policies *services.SecurityPolicies) {
+		policies.RateLimitRequests = 2
+		policies.RateLimitWindow = 30 * time.Second
+	})
+
+	for i := 0; i < 2; i++ {
+		if w := requestFrom(router, "192.0.2.10"); w.Code != http.StatusOK || w.Header().Get("Retry-After") != "" {
+			t.Fatalf("request %d: expected 200 without Retry-After, got %d %q", i+1, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+
+	assertRetryAfter(t, requestFrom(router, "192.0.2.10"), 29, 30)
+}
+
+func TestSecurityMiddlewareBlockedIPRetryAfter(t *testing.T) {
+	router, securityService := newSecurityMiddlewareTestRouter(t, func(policies *services.SecurityPolicies) {
+		policies.LoginLockoutTime = 10 * time.Minute
+	})
+
+	for i := 0; i < securityService.GetSecurityPolicies().MaxLoginAttempts; i++ {
+		securityService.RecordFailedLogin(context.Background(), "198.51.100.7", "test", nil)
+	}
+
+	assertRetryAfter(t, requestFrom(router, "198.51.100.7"), 599, 600)
+}
+
+func TestSecurityMiddlewareUserRateLimit(t *testing.T) {
+	router, _ := newSecurityMiddlewareTestRouter(t, func(policies *services.SecurityPolicies) {
+		policies.UserRateLimitRequests = 3
+		policies.RateLimitWindow = time.Minute
+	})
+	heavy, light := securityTestToken(t), securityTestToken(t)
+
+	// Both users are behind the same NAT gateway
+	for i := 0; i < 3; i++ {
+		if w := requestWithToken(router, "203.0.113.5", "/ping", heavy); w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i+1, w.Code)
+		}
+	}
+	assertRetryAfter(t, requestWithToken(router, "203.0.113.5", "/ping", heavy), 59, 60)
+
+	if w := requestWithToken(router, "203.0.113.5", "/ping", light); w.Code != http.StatusOK {
+		t.Errorf("expected another user behind the same IP not to be limited, got %d", w.Code)
+	}
+	if w := requestFrom(router, "203.0.113.5"); w.Code != http.StatusOK {
+		t.Errorf("expected anonymous requests to fall under the per-IP limit only, got %d", w.Code)
+	}
+}
+
+func TestSecurityMiddlewareRouteRateLimit(t *testing.T) {
+	router, _ := newSecurityMiddlewareTestRouter(t, func(policies *services.SecurityPolicies) {
+		policies.RouteRateLimits = []services.RouteRateLimit{
+			{PathPrefix: "/api/v1/config/export", Requests: 2, Window: 10 * time.Second},
+		}
+	})
+	token := securityTestToken(t)
+
+	for i := 0; i < 2; i++ {
+		if w := requestWithToken(router, "192.0.2.20", "/api/v1/config/export", token); w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i+1, w.Code)
+		}
+	}
+	assertRetryAfter(t, requestWithToken(router, "192.0.2.20", "/api/v1/config/export", token), 9, 10)
+
+	if w := requestWithToken(router, "192.0.2.20", "/ping", token); w.Code != http.StatusOK {
+		t.Errorf("expected other endpoints to keep the general limit, got %d", w.Code)
+	}
+	if w := requestWithToken(router, "192.0.2.20", "/api/v1/config/export", securityTestToken(t)); w.Code != http.StatusOK {
+		t.Errorf("expected each user to get their own allowance for the endpoint, got %d", w.Code)
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// HTTPSRedirect redirects every request to the same host and path on the
+// HTTPS port. 308 keeps the method and body, so API clients that still post
+// to the plain HTTP address are not silently turned into GETs.
+func HTTPSRedirect(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+// isHTTPS reports whether the client reached the controller over HTTPS,
+// directly or through a TLS-terminating proxy.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	for _, tc := range []struct {
+		port     int
+		host     string
+		target   string
+		expected string
+	}{
+		{443, "controller.example.com", "/api/v1/nodes?page=2", "https://controller.example.com/api/v1/nodes?page=2"},
+		{443, "controller.example.com:80", "/health", "https://controller.example.com/health"},
+		{8443, "controller.example.com:8080", "/health", "https://controller.example.com:8443/health"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.target, nil)
+		req.Host = tc.host
+		w := httptest.NewRecorder()
+		HTTPSRedirect(tc.port).ServeHTTP(w, req)
+
+		if w.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s%s: expected 308, got %d", tc.host, tc.target, w.Code)
+		}
+		if location := w.Header().Get("Location"); location != tc.expected {
+			t.Errorf("%s%s: expected redirect to %s, got %s", tc.host, tc.target, tc.expected, location)
+		}
+	}
+}
+
+func TestSecurityMiddlewareHSTSOnlyOverHTTPS(t *testing.T) {
+	router, _ := newSecurityMiddlewareTestRouter(t, func(policies *services.SecurityPolicies) {
+		policies.EnableHTTPS = true
+	})
+
+	if w := requestFrom(router, "192.0.2.30"); w.Header().Get("Strict-Transport-Security") != "" {
+		t.Errorf("expected no HSTS over plain HTTP, got %q", w.Header().Get("Strict-Transport-Security"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.RemoteAddr = "192.0.2.30:40000"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Header().Get("Strict-Transport-Security") == "" {
+		t.Error("expected HSTS when the client connected over HTTPS")
+	}
+}
@@ -2,20 +2,23 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wg-hubspoke/wg-hubspoke/common/types"
 	"github.com/wg-hubspoke/wg-hubspoke/controller/api"
-	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/migrations"
 	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -27,6 +30,9 @@ var (
 	commitHash = "unknown"
 )
 
+// maintenanceInterval is how often history past its retention is pruned.
+const maintenanceInterval = 24 * time.Hour
+
 func main() {
 	// Load environment variables
 	if err := loadEnv(); err != nil {
@@ -39,6 +45,22 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Log as configured; the standard logger is routed through it too
+	logOutput := os.Stdout
+	if config.Log.File != "" {
+		logOutput, err = os.OpenFile(config.Log.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			log.Fatalf("Failed to open log file: %v", err)
+		}
+		defer logOutput.Close()
+	}
+	slog.SetDefault(services.NewLogger(config.Log, logOutput))
+
+	// Refuse to start with a weak JWT secret outside development mode
+	if err := checkJWTSecret(config); err != nil {
+		log.Fatalf("%v", err)
+	}
+
 	// Initialize database
 	db, err := initDatabase(config)
 	if err != nil {
@@ -46,40 +68,143 @@ func main() {
 	}
 
 	// Initialize services
-	nodeService := services.NewNodeService(db, config)
-	healthService := services.NewHealthService(db, version)
-	authService := services.NewAuthService(db, config)
 	auditService := services.NewAuditService(db)
-	monitoringService := services.NewMonitoringService(db)
+	nodeService := services.NewNodeService(db, config, auditService)
+	policyService := services.NewPolicyService(db, auditService)
+	groupService := services.NewNodeGroupService(db, auditService)
+	healthService := services.NewHealthService(db, version)
+	authService := services.NewAuthService(db, config, auditService)
+	notificationService := services.NewNotificationService(config)
+	monitoringService := services.NewMonitoringService(db, notificationService)
+	webhookService := services.NewWebhookService(config)
+	nodeService.SetWebhookService(webhookService)
+	monitoringService.SetWebhookService(webhookService)
+	if err := monitoringService.SetVersionPolicy(version, config.WG.MinAgentVersion); err != nil {
+		log.Fatalf("Invalid WG_MIN_AGENT_VERSION: %v", err)
+	}
+	if config.Features.EndpointProbe {
+		nodeService.SetEndpointProber(services.NewUDPProber(0))
+	}
+	if config.Features.ConfigHistory {
+		nodeService.SetConfigHistory(services.NewConfigHistory())
+	}
 	haService := services.NewHAService(db, config)
 	configService := services.NewConfigService(db, auditService)
 	backupService := services.NewBackupService(db, config, auditService)
 	securityService := services.NewSecurityService(db, config, auditService)
+	emailService := services.NewEmailService(config)
+	authService.SetEmailService(emailService)
+	authService.SetPasswordValidator(securityService.ValidatePassword)
+	authService.SetSecurityService(securityService)
+	haService.SetClientCertificateAuthenticator(authService.AuthenticateNodeCertificate)
+	leadershipNotifier := services.NewLeadershipNotifier(haService, auditService, config.HA.LeadershipWebhook)
+	leadershipNotifier.SetSigningSecret(config.Webhooks.Secret)
+	featureService := services.NewFeatureService(config)
+	approvalService := services.NewApprovalService(db, config, auditService)
+	backupService.RegisterApprovalActions(approvalService)
+	configService.RegisterApprovalActions(approvalService)
+	nodeService.RegisterApprovalActions(approvalService)
+	if err := configService.SetSubnet(config.WG.Subnet); err != nil {
+		log.Fatalf("Invalid WG_SUBNET: %v", err)
+	}
+
+	// Keep backups in an S3-compatible bucket instead of local disk
+	if featureService.IsEnabled(services.FeatureRemoteBackups) {
+		backupStore, err := services.NewS3Store(config.Backup.S3)
+		if err != nil {
+			log.Fatalf("Failed to configure remote backup storage: %v", err)
+		}
+		backupService.SetStore(backupStore)
+	}
 
 	// Initialize handlers
-	nodesHandler := api.NewNodesHandler(nodeService)
+	nodesHandler := api.NewNodesHandler(nodeService, monitoringService, authService, approvalService)
+	policiesHandler := api.NewPoliciesHandler(policyService, authService)
+	groupsHandler := api.NewGroupsHandler(groupService, authService)
 	healthHandler := api.NewHealthHandler(healthService, version)
-	authHandler := api.NewAuthHandler(authService, auditService)
+	authHandler := api.NewAuthHandler(authService)
 	auditHandler := api.NewAuditHandler(auditService, authService)
-	monitoringHandler := api.NewMonitoringHandler(monitoringService)
+	monitoringHandler := api.NewMonitoringHandler(monitoringService, authService)
+	httpMetrics := api.NewHTTPMetrics()
+	monitoringHandler.SetHTTPMetrics(httpMetrics)
 	haHandler := api.NewHAHandler(haService)
-	configHandler := api.NewConfigHandler(configService, authService)
-	backupHandler := api.NewBackupHandler(backupService, authService)
+	configHandler := api.NewConfigHandler(configService, authService, approvalService)
+	backupHandler := api.NewBackupHandler(backupService, authService, approvalService)
 	securityHandler := api.NewSecurityHandler(securityService, authService)
+	featuresHandler := api.NewFeaturesHandler(featureService)
+	approvalHandler := api.NewApprovalHandler(approvalService, authService)
 
 	// Setup router
-	router := setupRouter(nodesHandler, healthHandler, authHandler, auditHandler, monitoringHandler, haHandler, configHandler, backupHandler, securityHandler, authService, auditService)
+	router := setupRouter(nodesHandler, policiesHandler, groupsHandler, healthHandler, authHandler, auditHandler, monitoringHandler, haHandler, configHandler, backupHandler, securityHandler, featuresHandler, approvalHandler, httpMetrics, config.Server.CORS, authService, auditService)
 
 	// Start HA service
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Check for nodes sharing an allocated IP before serving configs
+	report, err := nodeService.CheckDuplicateIPs(ctx, config.WG.RepairDuplicateIPs, nil)
+	if err != nil {
+		slog.Error("Failed to check for duplicate allocated IPs", "error", err)
+	} else if len(report.Conflicts) > 0 {
+		slog.Warn("Found duplicate allocated IPs", "conflicts", len(report.Conflicts), "reallocated", len(report.Reallocated))
+	}
+
+	// Background goroutines are awaited during shutdown
+	var background sync.WaitGroup
+	runInBackground := func(run func(context.Context)) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			run(ctx)
+		}()
+	}
+
+	runInBackground(leadershipNotifier.Run)
+
 	if err := haService.Start(ctx); err != nil {
 		log.Fatalf("Failed to start HA service: %v", err)
 	}
 
+	// Run persisted backup schedules; only the HA leader takes the backups
+	backupService.SetLeaderCheck(haService.IsLeader)
+	if err := backupService.StartScheduler(ctx); err != nil {
+		slog.Error("Failed to start backup scheduler", "error", err)
+	}
+
 	// Start security cleanup tasks
-	go securityService.StartCleanupTasks(ctx)
+	runInBackground(securityService.StartCleanupTasks)
+
+	// Alert on TLS, client CA and agent certificates nearing expiry
+	securityService.SetMonitoringService(monitoringService)
+	runInBackground(securityService.StartCertificateExpiryChecks)
+
+	// Report nodes that stop sending metrics to node webhooks
+	runInBackground(monitoringService.StartOfflineDetection)
+
+	// Rotate node keys older than WG_KEY_ROTATION_DAYS; only the HA leader rotates
+	nodeService.SetLeaderCheck(haService.IsLeader)
+	runInBackground(nodeService.StartKeyRotation)
+
+	// Mark active nodes inactive once their agent misses WG_HEARTBEAT_TIMEOUT
+	// of heartbeats; also leader only
+	runInBackground(nodeService.StartHeartbeatSweep)
+
+	// Prune audit logs and metrics history past their retention once a day;
+	// also leader only, so controllers don't race deleting the same rows
+	if days := config.Retention.AuditLogDays; days > 0 {
+		runInBackground(func(ctx context.Context) {
+			services.RunPeriodicallyIfLeader(ctx, haService.IsLeader, maintenanceInterval, "audit log cleanup", func(ctx context.Context) error {
+				return auditService.CleanupOldLogs(ctx, days)
+			})
+		})
+	}
+	if days := config.Retention.MetricsDays; days > 0 {
+		runInBackground(func(ctx context.Context) {
+			services.RunPeriodicallyIfLeader(ctx, haService.IsLeader, maintenanceInterval, "metrics cleanup", func(ctx context.Context) error {
+				return monitoringService.CleanupOldMetrics(ctx, days)
+			})
+		})
+	}
 
 	// Create server
 	srv := &http.Server{
@@ -90,9 +215,46 @@ func main() {
 	}
 
 	// Start server
+	tlsConfig := config.Server.TLS
+	var redirectSrv *http.Server
+	if tlsConfig.Enabled {
+		srv.TLSConfig = securityService.ConfigureTLS()
+
+		// Ask for agent client certificates; users without one still connect
+		if tlsConfig.ClientCAFile != "" {
+			clientCAs, err := services.LoadClientCAs(tlsConfig.ClientCAFile)
+			if err != nil {
+				log.Fatalf("Failed to load client CAs: %v", err)
+			}
+			srv.TLSConfig.ClientCAs = clientCAs
+			srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+
+		if tlsConfig.RedirectPort > 0 {
+			redirectSrv = &http.Server{
+				Addr:         fmt.Sprintf("%s:%d", config.Server.Host, tlsConfig.RedirectPort),
+				Handler:      api.HTTPSRedirect(config.Server.Port),
+				ReadTimeout:  config.Server.ReadTimeout,
+				WriteTimeout: config.Server.WriteTimeout,
+			}
+			go func() {
+				slog.Info("Redirecting HTTP to HTTPS", "addr", redirectSrv.Addr)
+				if err := redirectSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Fatalf("Failed to start HTTP redirect server: %v", err)
+				}
+			}()
+		}
+	}
+
 	go func() {
-		log.Printf("Starting server on %s", srv.Addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Info("Starting server", "addr", srv.Addr, "tls", tlsConfig.Enabled)
+		var err error
+		if tlsConfig.Enabled {
+			err = srv.ListenAndServeTLS(tlsConfig.CertFile, tlsConfig.KeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -102,22 +264,64 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down server...")
-
-	// Stop HA service
-	if err := haService.Stop(); err != nil {
-		log.Printf("Error stopping HA service: %v", err)
-	}
+	slog.Info("Shutting down server")
 
 	// Graceful shutdown
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer shutdownCancel()
 
+	// Drain in-flight requests first; they may still need the leader
 	if err := srv.Shutdown(shutdownCtx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		slog.Error("Server forced to shutdown", "error", err)
+	}
+	if redirectSrv != nil {
+		redirectSrv.Shutdown(shutdownCtx)
+	}
+
+	// Stop HA service, handing leadership over to a peer
+	if err := haService.Stop(shutdownCtx); err != nil {
+		slog.Error("Failed to stop HA service", "error", err)
 	}
 
-	log.Println("Server exited")
+	// Signal the remaining background goroutines and wait for them
+	cancel()
+	if err := waitForBackground(shutdownCtx, &background, backupService); err != nil {
+		slog.Error("Background tasks did not stop in time", "error", err)
+	}
+
+	slog.Info("Server exited")
+}
+
+// checkJWTSecret refuses a weak JWT secret outside development mode, where
+// it is only logged.
+func checkJWTSecret(config *types.Config) error {
+	err := services.ValidateJWTSecret(config.Auth.JWTSecret, config.Auth.JWTSecretMinLength)
+	if err == nil {
+		return nil
+	}
+	if !config.Server.DevMode {
+		return fmt.Errorf("Insecure JWT_SECRET: %w. Set JWT_SECRET to a random value of at least %d characters (e.g. openssl rand -base64 48)", err, config.Auth.JWTSecretMinLength)
+	}
+	slog.Warn("Insecure JWT_SECRET allowed in development mode", "error", err)
+	return nil
+}
+
+// waitForBackground waits for the goroutines in background and the backup
+// schedules to return, or for ctx to be done.
+func waitForBackground(ctx context.Context, background *sync.WaitGroup, backupService *services.BackupService) error {
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		backupService.WaitForSchedules()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func loadEnv() error {
@@ -134,6 +338,24 @@ func loadConfig() (*types.Config, error) {
 			Port:         getEnvInt("CONTROLLER_PORT", 8080),
 			ReadTimeout:  time.Duration(getEnvInt("READ_TIMEOUT", 10)) * time.Second,
 			WriteTimeout: time.Duration(getEnvInt("WRITE_TIMEOUT", 10)) * time.Second,
+			DevMode:      getEnvBool("DEVELOPMENT_MODE", false),
+			PublicURL:    getEnv("CONTROLLER_PUBLIC_URL", ""),
+			TLS: types.TLSConfig{
+				Enabled:               getEnvBool("TLS_ENABLED", false),
+				CertFile:              getEnv("TLS_CERT_FILE", ""),
+				KeyFile:               getEnv("TLS_KEY_FILE", ""),
+				CAFile:                getEnv("TLS_CA_FILE", ""),
+				RedirectPort:          getEnvInt("TLS_REDIRECT_PORT", 0),
+				ClientCAFile:          getEnv("TLS_CLIENT_CA_FILE", ""),
+				RequireClientCert:     getEnvBool("TLS_REQUIRE_CLIENT_CERT", false),
+				CertExpiryWarningDays: getEnvInt("TLS_CERT_EXPIRY_WARNING_DAYS", 30),
+			},
+			CORS: types.CORSConfig{
+				AllowedOrigins:   getEnvStringSlice("CORS_ALLOWED_ORIGINS", nil),
+				AllowedMethods:   getEnvStringSlice("CORS_ALLOWED_METHODS", []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+				AllowedHeaders:   getEnvStringSlice("CORS_ALLOWED_HEADERS", []string{"Content-Type", "Authorization", "X-CSRF-Token", "X-Request-ID"}),
+				AllowCredentials: getEnvBool("CORS_ALLOW_CREDENTIALS", false),
+			},
 		},
 		Database: types.DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
@@ -142,6 +364,13 @@ func loadConfig() (*types.Config, error) {
 			User:     getEnv("DB_USER", "wg_admin"),
 			Password: getEnv("DB_PASSWORD", "password"),
 			SSLMode:  getEnv("DB_SSL_MODE", "disable"),
+			// Keep well under Postgres' max_connections across all replicas
+			MaxConns:          getEnvInt("DB_MAX_CONNECTIONS", 25),
+			MaxIdleConns:      getEnvInt("DB_MAX_IDLE_CONNECTIONS", 10),
+			MaxIdleTime:       getEnvDuration("DB_MAX_IDLE_TIME", 15*time.Minute),
+			ConnMaxLifetime:   getEnvDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+			ConnectRetries:    getEnvInt("DB_CONNECT_RETRIES", 10),
+			ConnectRetryDelay: getEnvDuration("DB_CONNECT_RETRY_DELAY", time.Second),
 		},
 		WG: types.WGConfig{
 			Interface:           getEnv("WG_INTERFACE", "wg0"),
@@ -151,10 +380,45 @@ func loadConfig() (*types.Config, error) {
 			PersistentKeepalive: getEnvInt("WG_PERSISTENT_KEEPALIVE", 25),
 			MTU:                 getEnvInt("WG_MTU", 1420),
 			ConfigPath:          getEnv("WG_CONFIG_PATH", "/etc/wireguard/"),
+			RepairDuplicateIPs:  getEnvBool("WG_REPAIR_DUPLICATE_IPS", false),
+			KeyRotationInterval: time.Duration(getEnvInt("WG_KEY_ROTATION_DAYS", 0)) * 24 * time.Hour,
+			HeartbeatTimeout:    time.Duration(getEnvInt("WG_HEARTBEAT_TIMEOUT", 300)) * time.Second,
+			DNS:                 getEnvStringSlice("WG_DNS", nil),
+			MinAgentVersion:     getEnv("WG_MIN_AGENT_VERSION", ""),
 		},
 		Log: types.LogConfig{
 			Level:  getEnv("LOG_LEVEL", "info"),
 			Format: getEnv("LOG_FORMAT", "json"),
+			File:   getEnv("LOG_FILE", ""),
+		},
+		Retention: types.RetentionConfig{
+			AuditLogDays: getEnvInt("AUDIT_RETENTION_DAYS", 0),
+			MetricsDays:  getEnvInt("METRICS_RETENTION_DAYS", 30),
+		},
+		Auth: types.AuthConfig{
+			JWTSecret:               getEnv("JWT_SECRET", "your-secret-key"),
+			JWTExpiration:           time.Duration(getEnvInt("JWT_EXPIRES_IN", 24)) * time.Hour,
+			BCryptCost:              getEnvInt("BCRYPT_COST", 12),
+			JWTSecretMinLength:      getEnvInt("JWT_SECRET_MIN_LENGTH", 32),
+			NodeTokenExpiration:     time.Duration(getEnvInt("NODE_TOKEN_EXPIRATION", 8760)) * time.Hour,
+			RefreshTokenExpiration:  time.Duration(getEnvInt("REFRESH_TOKEN_EXPIRATION", 720)) * time.Hour,
+			PasswordResetURL:        getEnv("PASSWORD_RESET_URL", ""),
+			PasswordResetExpiration: time.Duration(getEnvInt("PASSWORD_RESET_EXPIRATION", 30)) * time.Minute,
+			RequireDualApproval:     getEnvBool("REQUIRE_DUAL_APPROVAL", false),
+			AllowRegistration:       getEnvBool("ALLOW_REGISTRATION", false),
+			LDAP: types.LDAPConfig{
+				Enabled:            getEnvBool("LDAP_ENABLED", false),
+				URL:                getEnv("LDAP_URL", ""),
+				UserDNTemplate:     getEnv("LDAP_USER_DN_TEMPLATE", ""),
+				GroupAttribute:     getEnv("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+				EmailAttribute:     getEnv("LDAP_EMAIL_ATTRIBUTE", "mail"),
+				AdminGroups:        getEnvStringSlice("LDAP_ADMIN_GROUPS", nil),
+				OperatorGroups:     getEnvStringSlice("LDAP_OPERATOR_GROUPS", nil),
+				UserGroups:         getEnvStringSlice("LDAP_USER_GROUPS", nil),
+				ObserverGroups:     getEnvStringSlice("LDAP_OBSERVER_GROUPS", nil),
+				Timeout:            time.Duration(getEnvInt("LDAP_TIMEOUT", 10)) * time.Second,
+				InsecureSkipVerify: getEnvBool("LDAP_INSECURE_SKIP_VERIFY", false),
+			},
 		},
 		JWT: types.JWTConfig{
 			Secret:    getEnv("JWT_SECRET", "your-secret-key"),
@@ -167,6 +431,47 @@ func loadConfig() (*types.Config, error) {
 			PeerNodes:         getEnvStringSlice("HA_PEER_NODES", []string{}),
 			HeartbeatInterval: time.Duration(getEnvInt("HA_HEARTBEAT_INTERVAL", 30)) * time.Second,
 			ElectionTimeout:   time.Duration(getEnvInt("HA_ELECTION_TIMEOUT", 60)) * time.Second,
+			LeadershipWebhook: getEnv("HA_LEADERSHIP_WEBHOOK_URL", ""),
+			DiscoveryDNS:      getEnv("HA_DISCOVERY_DNS", ""),
+			DiscoveryInterval: time.Duration(getEnvInt("HA_DISCOVERY_INTERVAL", 30)) * time.Second,
+			SlowPeerLatency:   time.Duration(getEnvInt("HA_SLOW_PEER_MS", 1000)) * time.Millisecond,
+			PeerSecret:        getEnv("HA_PEER_SECRET", ""),
+		},
+		Alerting: types.AlertingConfig{
+			Targets:      loadAlertTargets(),
+			MaxRetries:   getEnvInt("ALERT_NOTIFY_MAX_RETRIES", 3),
+			RetryBackoff: time.Duration(getEnvInt("ALERT_NOTIFY_RETRY_BACKOFF", 2)) * time.Second,
+		},
+		Webhooks: types.WebhookConfig{
+			Endpoints:    loadWebhookEndpoints(),
+			Secret:       getEnv("WEBHOOK_SECRET", ""),
+			MaxRetries:   getEnvInt("WEBHOOK_MAX_RETRIES", 3),
+			RetryBackoff: time.Duration(getEnvInt("WEBHOOK_RETRY_BACKOFF", 2)) * time.Second,
+		},
+		Features: types.FeaturesConfig{
+			Backups:       getEnvBool("FEATURE_BACKUPS", true),
+			RemoteBackups: getEnvBool("FEATURE_REMOTE_BACKUPS", false),
+			Mesh:          getEnvBool("FEATURE_MESH", false),
+			EndpointProbe: getEnvBool("FEATURE_ENDPOINT_PROBE", false),
+			ConfigHistory: getEnvBool("FEATURE_CONFIG_HISTORY", false),
+		},
+		Backup: types.BackupConfig{
+			EncryptionKey: getEnv("BACKUP_ENCRYPTION_KEY", ""),
+			S3: types.S3Config{
+				Endpoint:        getEnv("BACKUP_S3_ENDPOINT", ""),
+				Region:          getEnv("BACKUP_S3_REGION", "us-east-1"),
+				Bucket:          getEnv("BACKUP_S3_BUCKET", ""),
+				AccessKeyID:     getEnv("BACKUP_S3_ACCESS_KEY_ID", ""),
+				SecretAccessKey: getEnv("BACKUP_S3_SECRET_ACCESS_KEY", ""),
+				Prefix:          getEnv("BACKUP_S3_PREFIX", ""),
+			},
+		},
+		Email: types.EmailConfig{
+			SMTPHost:     getEnv("SMTP_HOST", ""),
+			SMTPPort:     getEnvInt("SMTP_PORT", 587),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			From:         getEnv("SMTP_FROM", ""),
 		},
 	}
 
@@ -184,46 +489,92 @@ func initDatabase(config *types.Config) (*gorm.DB, error) {
 		config.Database.SSLMode,
 	)
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	// Postgres may still be starting when the controller comes up
+	return connectWithRetry(config.Database.ConnectRetries, config.Database.ConnectRetryDelay, func() (*gorm.DB, error) {
+		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to database: %w", err)
+		}
+
+		if err := configureConnectionPool(db, config.Database); err != nil {
+			return nil, err
+		}
+
+		if err := migrateDatabase(db); err != nil {
+			if sqlDB, err := db.DB(); err == nil {
+				sqlDB.Close()
+			}
+			return nil, err
+		}
+		return db, nil
+	})
+}
+
+// maxConnectRetryDelay caps the backoff between database connection attempts.
+const maxConnectRetryDelay = 30 * time.Second
+
+// connectWithRetry calls connect until it succeeds, retrying up to retries
+// times after the first failure. The delay before each retry starts at delay
+// and doubles up to maxConnectRetryDelay.
+func connectWithRetry(retries int, delay time.Duration, connect func() (*gorm.DB, error)) (*gorm.DB, error) {
+	for attempt := 0; ; attempt++ {
+		db, err := connect()
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= retries {
+			return nil, err
+		}
+
+		slog.Warn("Database not ready, retrying", "attempt", attempt+1, "retry_in", delay, "error", err)
+		time.Sleep(delay)
+		delay = min(delay*2, maxConnectRetryDelay)
 	}
+}
 
-	// Auto migrate
-	if err := db.AutoMigrate(
-		&models.Node{},
-		&models.Topology{},
-		&models.Policy{},
-		&models.User{},
-		&models.AuditLog{},
-		&services.BackupInfo{},
-		&services.SecurityEvent{},
-	); err != nil {
-		return nil, fmt.Errorf("failed to migrate database: %w", err)
+func migrateDatabase(db *gorm.DB) error {
+	if _, err := migrations.NewMigrator(db, migrations.All).Up(context.Background()); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
 	}
+	return nil
+}
 
-	return db, nil
+// configureConnectionPool sizes the connection pool behind db. Zero values
+// keep database/sql's defaults.
+func configureConnectionPool(db *gorm.DB, config types.DatabaseConfig) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database handle: %w", err)
+	}
+
+	sqlDB.SetMaxOpenConns(config.MaxConns)
+	if config.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	sqlDB.SetConnMaxIdleTime(config.MaxIdleTime)
+	sqlDB.SetConnMaxLifetime(config.ConnMaxLifetime)
+	return nil
 }
 
-func setupRouter(nodesHandler *api.NodesHandler, healthHandler *api.HealthHandler, authHandler *api.AuthHandler, auditHandler *api.AuditHandler, monitoringHandler *api.MonitoringHandler, haHandler *api.HAHandler, configHandler *api.ConfigHandler, backupHandler *api.BackupHandler, securityHandler *api.SecurityHandler, authService *services.AuthService, auditService *services.AuditService) *gin.Engine {
-	router := gin.Default()
+func setupRouter(nodesHandler *api.NodesHandler, policiesHandler *api.PoliciesHandler, groupsHandler *api.GroupsHandler, healthHandler *api.HealthHandler, authHandler *api.AuthHandler, auditHandler *api.AuditHandler, monitoringHandler *api.MonitoringHandler, haHandler *api.HAHandler, configHandler *api.ConfigHandler, backupHandler *api.BackupHandler, securityHandler *api.SecurityHandler, featuresHandler *api.FeaturesHandler, approvalHandler *api.ApprovalHandler, httpMetrics *api.HTTPMetrics, corsConfig types.CORSConfig, authService *services.AuthService, auditService *services.AuditService) *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Recovery())
+
+	// Requests forwarded by an HA follower keep the client's address and
+	// certificate identity
+	router.Use(haHandler.ProxiedClientMiddleware())
+
+	// Tag every request with an ID and log it once it completes
+	router.Use(api.RequestLogger())
+
+	// Count and time requests for /metrics
+	router.Use(httpMetrics.Middleware())
 
 	// Add security middleware
 	router.Use(securityHandler.SecurityMiddleware())
 
 	// Add CORS middleware
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-
-		c.Next()
-	})
+	router.Use(api.CORSMiddleware(corsConfig))
 
 	// Add audit middleware
 	router.Use(func(c *gin.Context) {
@@ -236,7 +587,7 @@ func setupRouter(nodesHandler *api.NodesHandler, healthHandler *api.HealthHandle
 		// Log the request
 		start := time.Now()
 		c.Next()
-		
+
 		// Log after processing
 		latency := time.Since(start)
 		auditService.LogRequest(c.Request.Context(), c.Request.Method, c.Request.URL.Path, c.Writer.Status(), latency, c.ClientIP())
@@ -250,6 +601,9 @@ func setupRouter(nodesHandler *api.NodesHandler, healthHandler *api.HealthHandle
 	// Prometheus metrics endpoint
 	router.GET("/metrics", monitoringHandler.GetPrometheusMetrics)
 
+	// Enabled optional features
+	router.GET("/features", featuresHandler.GetFeatures)
+
 	// Authentication endpoints
 	auth := router.Group("/auth")
 	{
@@ -257,11 +611,14 @@ func setupRouter(nodesHandler *api.NodesHandler, healthHandler *api.HealthHandle
 		auth.POST("/register", authHandler.Register)
 		auth.POST("/logout", authHandler.Logout)
 		auth.POST("/refresh", authHandler.RefreshToken)
-		auth.POST("/change-password", authHandler.ChangePassword)
+		auth.POST("/change-password", authHandler.AuthMiddleware(), authHandler.ChangePassword)
+		auth.POST("/forgot-password", authHandler.ForgotPassword)
+		auth.POST("/reset-password", authHandler.ResetPassword)
 	}
 
 	// HA endpoints
 	ha := router.Group("/ha")
+	ha.Use(featuresHandler.RequireFeature(services.FeatureHA))
 	{
 		ha.GET("/status", haHandler.GetClusterStatus)
 		ha.GET("/health", haHandler.GetHealthStatus)
@@ -273,18 +630,54 @@ func setupRouter(nodesHandler *api.NodesHandler, healthHandler *api.HealthHandle
 	// API routes
 	v1 := router.Group("/api/v1")
 	{
+		// Followers forward writes to the HA leader
+		v1.Use(haHandler.LeaderProxyMiddleware())
+
 		// Authentication middleware for API routes
-		v1.Use(authService.AuthMiddleware())
+		v1.Use(authHandler.AuthMiddleware())
+		v1.Use(authHandler.ReadOnlyMiddleware())
 
 		// Node management
 		nodes := v1.Group("/nodes")
 		{
 			nodes.POST("", nodesHandler.RegisterNode)
 			nodes.GET("", nodesHandler.GetNodes)
+			nodes.POST("/rebalance", nodesHandler.RebalanceTopology)
+			nodes.POST("/check-ips", nodesHandler.CheckDuplicateIPs)
+			nodes.PATCH("/status", nodesHandler.UpdateNodeStatuses)
 			nodes.GET("/:id", nodesHandler.GetNode)
 			nodes.PUT("/:id", nodesHandler.UpdateNode)
 			nodes.DELETE("/:id", nodesHandler.DeleteNode)
 			nodes.GET("/:id/config", nodesHandler.GetNodeConfig)
+			nodes.GET("/:id/config/previous", featuresHandler.RequireFeature(services.FeatureConfigHistory), nodesHandler.GetPreviousNodeConfig)
+			nodes.GET("/:id/agent-config", nodesHandler.DownloadAgentConfig)
+			nodes.POST("/:id/peer-errors", nodesHandler.ReportPeerErrors)
+			nodes.POST("/:id/rotate-key", nodesHandler.RotateNodeKey)
+			nodes.PUT("/:id/public-key", nodesHandler.UpdateNodePublicKey)
+		}
+
+		v1.GET("/topology", nodesHandler.GetTopology)
+
+		// Access policies
+		policies := v1.Group("/policies")
+		{
+			policies.GET("", policiesHandler.GetPolicies)
+			policies.POST("", policiesHandler.CreatePolicy)
+			policies.GET("/:id", policiesHandler.GetPolicy)
+			policies.PUT("/:id", policiesHandler.UpdatePolicy)
+			policies.DELETE("/:id", policiesHandler.DeletePolicy)
+		}
+
+		// Node groups
+		groups := v1.Group("/groups")
+		{
+			groups.GET("", groupsHandler.GetGroups)
+			groups.POST("", groupsHandler.CreateGroup)
+			groups.GET("/:id", groupsHandler.GetGroup)
+			groups.PUT("/:id", groupsHandler.UpdateGroup)
+			groups.DELETE("/:id", groupsHandler.DeleteGroup)
+			groups.PUT("/:id/members/:node_id", groupsHandler.AddGroupMember)
+			groups.DELETE("/:id/members/:node_id", groupsHandler.RemoveGroupMember)
 		}
 
 		// User management
@@ -305,6 +698,8 @@ func setupRouter(nodesHandler *api.NodesHandler, healthHandler *api.HealthHandle
 			audit.GET("/users/:user_id/activity", auditHandler.GetUserActivity)
 			audit.GET("/resources/:resource/:resource_id/activity", auditHandler.GetResourceActivity)
 			audit.GET("/summary", auditHandler.GetActivitySummary)
+			audit.GET("/export", auditHandler.ExportAuditLogs)
+			audit.GET("/verify", auditHandler.VerifyAuditChain)
 		}
 
 		// Monitoring
@@ -313,11 +708,19 @@ func setupRouter(nodesHandler *api.NodesHandler, healthHandler *api.HealthHandle
 			monitoring.POST("/nodes/:node_id/metrics", monitoringHandler.UpdateNodeMetrics)
 			monitoring.GET("/nodes/:node_id/metrics", monitoringHandler.GetNodeMetrics)
 			monitoring.GET("/nodes/metrics", monitoringHandler.GetAllNodeMetrics)
+			monitoring.GET("/stream", monitoringHandler.StreamNodeEvents)
 			monitoring.GET("/nodes/:node_id/health", monitoringHandler.GetNodeHealth)
+			monitoring.GET("/nodes/health", monitoringHandler.GetNodesByHealthScore)
 			monitoring.GET("/nodes/:node_id/history", monitoringHandler.GetMetricsHistory)
 			monitoring.GET("/system/metrics", monitoringHandler.GetSystemMetrics)
 			monitoring.GET("/topology/health", monitoringHandler.GetTopologyHealth)
 			monitoring.GET("/report", monitoringHandler.GenerateReport)
+			monitoring.GET("/config-fetches", monitoringHandler.GetConfigFetchStats)
+			monitoring.GET("/alerts", monitoringHandler.GetAlerts)
+			monitoring.GET("/alerts/rules", monitoringHandler.GetAlertRules)
+			monitoring.POST("/alerts/rules", monitoringHandler.CreateAlertRule)
+			monitoring.PUT("/alerts/rules/:id", monitoringHandler.UpdateAlertRule)
+			monitoring.DELETE("/alerts/rules/:id", monitoringHandler.DeleteAlertRule)
 		}
 
 		// Configuration management
@@ -326,22 +729,40 @@ func setupRouter(nodesHandler *api.NodesHandler, healthHandler *api.HealthHandle
 			config.GET("/export", configHandler.ExportConfiguration)
 			config.POST("/import", configHandler.ImportConfiguration)
 			config.POST("/validate", configHandler.ValidateConfiguration)
+			config.POST("/diff", configHandler.DiffConfiguration)
 			config.GET("/summary", configHandler.GetConfigurationSummary)
 			config.GET("/backup", configHandler.GenerateBackup)
+			config.GET("/versions", configHandler.GetConfigVersions)
+			config.POST("/versions", configHandler.CreateConfigVersion)
+			config.GET("/versions/:id", configHandler.GetConfigVersion)
+			config.POST("/versions/:id/rollback", configHandler.RollbackConfigVersion)
 		}
 
 		// Backup management
 		backup := v1.Group("/backup")
+		backup.Use(featuresHandler.RequireFeature(services.FeatureBackups))
 		{
 			backup.POST("/create", backupHandler.CreateBackup)
 			backup.GET("", backupHandler.GetBackups)
 			backup.GET("/:id", backupHandler.GetBackup)
 			backup.POST("/restore", backupHandler.RestoreBackup)
+			backup.POST("/:id/verify", backupHandler.VerifyBackup)
 			backup.DELETE("/:id", backupHandler.DeleteBackup)
 			backup.POST("/schedule", backupHandler.ScheduleBackup)
+			backup.GET("/schedules", backupHandler.GetBackupSchedules)
+			backup.DELETE("/schedules/:id", backupHandler.DeleteBackupSchedule)
 			backup.GET("/stats", backupHandler.GetBackupStats)
 		}
 
+		// Dual-control approvals for destructive actions
+		approvals := v1.Group("/approvals")
+		{
+			approvals.GET("", approvalHandler.GetApprovals)
+			approvals.GET("/:id", approvalHandler.GetApproval)
+			approvals.POST("/:id/approve", approvalHandler.ApproveRequest)
+			approvals.POST("/:id/reject", approvalHandler.RejectRequest)
+		}
+
 		// Security management
 		security := v1.Group("/security")
 		{
@@ -351,8 +772,11 @@ func setupRouter(nodesHandler *api.NodesHandler, healthHandler *api.HealthHandle
 			security.POST("/validate-password", securityHandler.ValidatePassword)
 			security.POST("/generate-token", securityHandler.GenerateSecureToken)
 			security.GET("/events", securityHandler.GetSecurityEvents)
+			security.GET("/whitelist", securityHandler.GetAllowedIPs)
 			security.POST("/whitelist", securityHandler.AddAllowedIP)
+			security.DELETE("/whitelist", securityHandler.RemoveAllowedIP)
 			security.GET("/blocked-ips", securityHandler.GetBlockedIPs)
+			security.DELETE("/blocked-ips/:ip", securityHandler.UnblockIP)
 		}
 	}
 
@@ -384,10 +808,55 @@ func getEnvBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
 func getEnvStringSlice(key string, defaultValue []string) []string {
 	if value := os.Getenv(key); value != "" {
 		// Split by comma for multiple values
 		return strings.Split(value, ",")
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+// loadWebhookEndpoints reads the URLs notified of each node event from
+// WEBHOOK_<EVENT>_URLS, falling back to WEBHOOK_URL for every event.
+func loadWebhookEndpoints() map[string][]string {
+	defaultURLs := getEnvStringSlice("WEBHOOK_URL", nil)
+	endpoints := make(map[string][]string)
+	for _, event := range []string{
+		services.NodeEventRegistered,
+		services.NodeEventApproved,
+		services.NodeEventActive,
+		services.NodeEventOffline,
+		services.NodeEventDeleted,
+	} {
+		if urls := getEnvStringSlice("WEBHOOK_"+strings.ToUpper(event)+"_URLS", defaultURLs); len(urls) > 0 {
+			endpoints[event] = urls
+		}
+	}
+	return endpoints
+}
+
+// loadAlertTargets reads notification targets for each alert severity from
+// ALERT_<SEVERITY>_WEBHOOK_URLS and ALERT_<SEVERITY>_SLACK_WEBHOOK_URL.
+func loadAlertTargets() map[string]types.AlertTargets {
+	targets := make(map[string]types.AlertTargets)
+	for _, severity := range []string{"info", "warning", "critical"} {
+		prefix := "ALERT_" + strings.ToUpper(severity)
+		target := types.AlertTargets{
+			WebhookURLs:     getEnvStringSlice(prefix+"_WEBHOOK_URLS", nil),
+			SlackWebhookURL: getEnv(prefix+"_SLACK_WEBHOOK_URL", ""),
+		}
+		if len(target.WebhookURLs) > 0 || target.SlackWebhookURL != "" {
+			targets[severity] = target
+		}
+	}
+	return targets
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/api"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestLoadConfigConnectionPool(t *testing.T) {
+	t.Setenv("DB_MAX_CONNECTIONS", "40")
+	t.Setenv("DB_MAX_IDLE_CONNECTIONS", "8")
+	t.Setenv("DB_MAX_IDLE_TIME", "2m")
+	t.Setenv("DB_CONN_MAX_LIFETIME", "1h")
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+
+	expected := types.DatabaseConfig{MaxConns: 40, MaxIdleConns: 8, MaxIdleTime: 2 * time.Minute, ConnMaxLifetime: time.Hour}
+	got := config.Database
+	if got.MaxConns != expected.MaxConns || got.MaxIdleConns != expected.MaxIdleConns ||
+		got.MaxIdleTime != expected.MaxIdleTime || got.ConnMaxLifetime != expected.ConnMaxLifetime {
+		t.Errorf("expected pool settings %+v, got %+v", expected, got)
+	}
+}
+
+func TestDefaultCORSMethodsAllowPatchPreflight(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://ui.example.com")
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(api.CORSMiddleware(config.Server.CORS))
+	router.PATCH("/api/v1/nodes/status", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// Bulk status changes use PATCH, so browsers must be allowed to send it
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodOptions, "/api/v1/nodes/status", nil)
+	req.Header.Set("Origin", "https://ui.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected the preflight to succeed, got %d", w.Code)
+	}
+	methods := strings.Split(w.Header().Get("Access-Control-Allow-Methods"), ", ")
+	found := false
+	for _, method := range methods {
+		if method == http.MethodPatch {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected PATCH in Access-Control-Allow-Methods, got %q", w.Header().Get("Access-Control-Allow-Methods"))
+	}
+}
+
+func TestConfigureConnectionPool(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/pool.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := configureConnectionPool(db, types.DatabaseConfig{MaxConns: 3, MaxIdleConns: 1, ConnMaxLifetime: time.Hour}); err != nil {
+		t.Fatalf("configureConnectionPool failed: %v", err)
+	}
+	if got := sqlDB.Stats().MaxOpenConnections; got != 3 {
+		t.Errorf("expected at most 3 open connections, got %d", got)
+	}
+
+	// Hold three connections, then release them: only one stays idle
+	ctx := context.Background()
+	var conns []*sql.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := sqlDB.Conn(ctx)
+		if err != nil {
+			t.Fatalf("failed to open connection: %v", err)
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	if stats := sqlDB.Stats(); stats.Idle != 1 || stats.MaxIdleClosed != 2 {
+		t.Errorf("expected one idle connection and two closed, got %d idle and %d closed", stats.Idle, stats.MaxIdleClosed)
+	}
+}
+
+func TestConnectWithRetryWaitsForDatabase(t *testing.T) {
+	// The database's directory only appears after a short delay, as a
+	// database container would
+	dir := filepath.Join(t.TempDir(), "pgdata")
+	path := filepath.Join(dir, "controller.db")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		os.Mkdir(dir, 0o755)
+	}()
+
+	attempts := 0
+	db, err := connectWithRetry(20, 10*time.Millisecond, func() (*gorm.DB, error) {
+		attempts++
+		return gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	})
+	if err != nil {
+		t.Fatalf("expected the connection to succeed once the database appeared, got %v", err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if attempts < 2 {
+		t.Errorf("expected the first attempt to fail, got %d attempts", attempts)
+	}
+	if err := db.Exec("CREATE TABLE ready (id INTEGER)").Error; err != nil {
+		t.Errorf("expected a usable connection, got %v", err)
+	}
+}
+
+func TestConnectWithRetryGivesUp(t *testing.T) {
+	unavailable := errors.New("connection refused")
+
+	attempts := 0
+	_, err := connectWithRetry(2, time.Millisecond, func() (*gorm.DB, error) {
+		attempts++
+		return nil, unavailable
+	})
+	if !errors.Is(err, unavailable) {
+		t.Errorf("expected the last error, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected the first attempt and 2 retries, got %d attempts", attempts)
+	}
+}
+
+func TestCheckJWTSecret(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	os.Unsetenv("JWT_SECRET")
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+
+	// The shipped default blocks startup
+	if err := checkJWTSecret(config); !errors.Is(err, services.ErrDefaultJWTSecret) {
+		t.Errorf("expected the default secret to be refused, got %v", err)
+	}
+
+	config.Auth.JWTSecret = "Tr0ub4dor&3"
+	if err := checkJWTSecret(config); !errors.Is(err, services.ErrWeakJWTSecret) {
+		t.Errorf("expected a short secret to be refused, got %v", err)
+	}
+	config.Auth.JWTSecret = strings.Repeat("ab", 24)
+	if err := checkJWTSecret(config); !errors.Is(err, services.ErrWeakJWTSecret) {
+		t.Errorf("expected a low-entropy secret to be refused, got %v", err)
+	}
+
+	config.Auth.JWTSecret = "q7Vx2LpN9sKd4RtY8mWc3HbZ6fJg1AeU5oTi0yQn"
+	if err := checkJWTSecret(config); err != nil {
+		t.Errorf("expected a strong secret to be accepted, got %v", err)
+	}
+
+	// Development mode only warns
+	config.Auth.JWTSecret = "your-secret-key"
+	config.Server.DevMode = true
+	if err := checkJWTSecret(config); err != nil {
+		t.Errorf("expected the default secret to be allowed in development mode, got %v", err)
+	}
+}
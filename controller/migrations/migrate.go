@@ -0,0 +1,71 @@
+//go:build ignore
+
+// Command migrate applies or rolls back controller schema migrations against
+// the database configured by the DB_* environment variables:
+//
+//	go run migrations/migrate.go up|down|version
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/wg-hubspoke/wg-hubspoke/controller/migrations"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: migrate up|down|version")
+	}
+
+	dsn := fmt.Sprintf(
+		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=UTC",
+		getEnv("DB_HOST", "localhost"),
+		getEnv("DB_USER", "wg_admin"),
+		getEnv("DB_PASSWORD", "password"),
+		getEnv("DB_NAME", "wireguard_sdwan"),
+		getEnv("DB_PORT", "5432"),
+		getEnv("DB_SSL_MODE", "disable"),
+	)
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	ctx := context.Background()
+	migrator := migrations.NewMigrator(db, migrations.All)
+
+	switch os.Args[1] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Applied %d migration(s): %v\n", len(applied), applied)
+	case "down":
+		version, err := migrator.Down(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Rolled back migration %d\n", version)
+	case "version":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Schema version %d\n", version)
+	default:
+		log.Fatalf("unknown command %q: use up, down or version", os.Args[1])
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
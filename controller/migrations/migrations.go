@@ -0,0 +1,178 @@
+// Package migrations versions the controller's database schema. Each
+// migration is applied once, in order, and recorded in the schema_version
+// table so later releases can change or drop columns and seed data.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNoAppliedMigrations   = errors.New("no migrations have been applied")
+	ErrIrreversibleMigration = errors.New("migration cannot be rolled back")
+	ErrUnknownMigration      = errors.New("applied migration is not known to this build")
+)
+
+// Migration is one step in the schema's history. Up applies it and Down
+// reverts it; each runs in a transaction along with the schema_version
+// bookkeeping, so a failed step leaves no trace.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *gorm.DB) error
+	Down        func(tx *gorm.DB) error
+}
+
+// SchemaVersion records an applied migration.
+type SchemaVersion struct {
+	Version     int       `json:"version" gorm:"primaryKey;autoIncrement:false"`
+	Description string    `json:"description"`
+	AppliedAt   time.Time `json:"applied_at"`
+}
+
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// NewMigrator runs migrations against db in version order.
+func NewMigrator(db *gorm.DB, migrations []Migration) *Migrator {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return &Migrator{db: db, migrations: sorted}
+}
+
+// Up applies every pending migration and returns the versions applied.
+func (m *Migrator) Up(ctx context.Context) ([]int, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []int
+	for _, migration := range m.migrations {
+		if applied[migration.Version] {
+			continue
+		}
+
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaVersion{
+				Version:     migration.Version,
+				Description: migration.Description,
+				AppliedAt:   time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return versions, fmt.Errorf("failed to apply migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+
+		slog.InfoContext(ctx, "Applied database migration", "version", migration.Version, "description", migration.Description)
+		versions = append(versions, migration.Version)
+	}
+	return versions, nil
+}
+
+// Down rolls back the most recently applied migration and returns its
+// version.
+func (m *Migrator) Down(ctx context.Context) (int, error) {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if current == 0 {
+		return 0, ErrNoAppliedMigrations
+	}
+
+	var migration *Migration
+	for i := range m.migrations {
+		if m.migrations[i].Version == current {
+			migration = &m.migrations[i]
+		}
+	}
+	if migration == nil {
+		return 0, fmt.Errorf("%w: %d", ErrUnknownMigration, current)
+	}
+	if migration.Down == nil {
+		return 0, fmt.Errorf("%w: %d (%s)", ErrIrreversibleMigration, migration.Version, migration.Description)
+	}
+
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := migration.Down(tx); err != nil {
+			return err
+		}
+		return tx.Delete(&SchemaVersion{}, "version = ?", migration.Version).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to roll back migration %d (%s): %w", migration.Version, migration.Description, err)
+	}
+
+	slog.InfoContext(ctx, "Rolled back database migration", "version", migration.Version, "description", migration.Description)
+	return migration.Version, nil
+}
+
+// Version returns the latest applied migration, or 0 if none has been.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return 0, err
+	}
+
+	var version int
+	if err := m.db.WithContext(ctx).Model(&SchemaVersion{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+func (m *Migrator) validate() error {
+	for i, migration := range m.migrations {
+		if migration.Version <= 0 || migration.Up == nil {
+			return fmt.Errorf("migration %d (%s) needs a positive version and an Up step", migration.Version, migration.Description)
+		}
+		if i > 0 && m.migrations[i-1].Version == migration.Version {
+			return fmt.Errorf("migration version %d is used more than once", migration.Version)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) ensureVersionTable(ctx context.Context) error {
+	if err := m.db.WithContext(ctx).AutoMigrate(&SchemaVersion{}); err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+	return nil
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context) (map[int]bool, error) {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return nil, err
+	}
+
+	var versions []int
+	if err := m.db.WithContext(ctx).Model(&SchemaVersion{}).Pluck("version", &versions).Error; err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	applied := make(map[int]bool, len(versions))
+	for _, version := range versions {
+		applied[version] = true
+	}
+	return applied, nil
+}
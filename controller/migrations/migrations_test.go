@@ -0,0 +1,199 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type widget struct {
+	ID   uint
+	Name string
+}
+
+type widgetWithColor struct {
+	ID    uint
+	Name  string
+	Color string
+}
+
+func (widgetWithColor) TableName() string {
+	return "widgets"
+}
+
+var testMigrations = []Migration{
+	// Listed out of order; the migrator sorts them
+	{
+		Version:     2,
+		Description: "add widget color",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&widgetWithColor{}, "Color")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&widgetWithColor{}, "Color")
+		},
+	},
+	{
+		Version:     1,
+		Description: "create widgets",
+		Up: func(tx *gorm.DB) error {
+			return tx.Table("widgets").Migrator().CreateTable(&widget{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("widgets")
+		},
+	},
+}
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+
+	// Each connection to :memory: is a separate database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func schemaVersion(t *testing.T, migrator *Migrator) int {
+	t.Helper()
+
+	version, err := migrator.Version(context.Background())
+	if err != nil {
+		t.Fatalf("Version failed: %v", err)
+	}
+	return version
+}
+
+func TestMigratorUpAndDown(t *testing.T) {
+	db := openTestDB(t)
+	migrator := NewMigrator(db, testMigrations)
+	ctx := context.Background()
+
+	if version := schemaVersion(t, migrator); version != 0 {
+		t.Fatalf("expected an empty database at version 0, got %d", version)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if len(applied) != 2 || applied[0] != 1 || applied[1] != 2 {
+		t.Errorf("expected migrations 1 and 2 to be applied in order, got %v", applied)
+	}
+	if !db.Migrator().HasTable("widgets") || !db.Migrator().HasColumn(&widgetWithColor{}, "Color") {
+		t.Error("expected the widgets table with its color column")
+	}
+	if version := schemaVersion(t, migrator); version != 2 {
+		t.Errorf("expected version 2, got %d", version)
+	}
+
+	var recorded []SchemaVersion
+	db.Order("version").Find(&recorded)
+	if len(recorded) != 2 || recorded[1].Description != "add widget color" || recorded[1].AppliedAt.IsZero() {
+		t.Errorf("expected both migrations in schema_version, got %+v", recorded)
+	}
+
+	// Nothing is pending the second time
+	if applied, err := migrator.Up(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("expected no pending migrations, got %v, %v", applied, err)
+	}
+
+	// Rolling back removes one step at a time
+	if version, err := migrator.Down(ctx); err != nil || version != 2 {
+		t.Fatalf("expected migration 2 to be rolled back, got %d, %v", version, err)
+	}
+	if !db.Migrator().HasTable("widgets") || db.Migrator().HasColumn(&widgetWithColor{}, "Color") {
+		t.Error("expected the widgets table without its color column")
+	}
+	if version := schemaVersion(t, migrator); version != 1 {
+		t.Errorf("expected version 1, got %d", version)
+	}
+
+	if version, err := migrator.Down(ctx); err != nil || version != 1 {
+		t.Fatalf("expected migration 1 to be rolled back, got %d, %v", version, err)
+	}
+	if db.Migrator().HasTable("widgets") {
+		t.Error("expected the widgets table to be dropped")
+	}
+	if _, err := migrator.Down(ctx); !errors.Is(err, ErrNoAppliedMigrations) {
+		t.Errorf("expected ErrNoAppliedMigrations, got %v", err)
+	}
+
+	// And the history can be replayed
+	if applied, err := migrator.Up(ctx); err != nil || len(applied) != 2 {
+		t.Errorf("expected both migrations to be reapplied, got %v, %v", applied, err)
+	}
+}
+
+func TestMigratorRollsBackFailedMigration(t *testing.T) {
+	db := openTestDB(t)
+	failing := append(testMigrations, Migration{
+		Version:     3,
+		Description: "half-finished",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Exec("CREATE TABLE gadgets (id INTEGER)").Error; err != nil {
+				return err
+			}
+			return errors.New("seed data rejected")
+		},
+	})
+	migrator := NewMigrator(db, failing)
+
+	applied, err := migrator.Up(context.Background())
+	if err == nil {
+		t.Fatal("expected the failing migration to be reported")
+	}
+	if len(applied) != 2 {
+		t.Errorf("expected the earlier migrations to stay applied, got %v", applied)
+	}
+	if db.Migrator().HasTable("gadgets") {
+		t.Error("expected the failed migration's changes to be rolled back")
+	}
+	if version := schemaVersion(t, migrator); version != 2 {
+		t.Errorf("expected version 2, got %d", version)
+	}
+}
+
+func TestMigratorDownWithoutDownStep(t *testing.T) {
+	db := openTestDB(t)
+	migrator := NewMigrator(db, []Migration{{
+		Version:     1,
+		Description: "seed defaults",
+		Up:          func(tx *gorm.DB) error { return nil },
+	}})
+	ctx := context.Background()
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if _, err := migrator.Down(ctx); !errors.Is(err, ErrIrreversibleMigration) {
+		t.Errorf("expected ErrIrreversibleMigration, got %v", err)
+	}
+	if version := schemaVersion(t, migrator); version != 1 {
+		t.Errorf("expected the migration to stay applied, got version %d", version)
+	}
+}
+
+func TestAllMigrationsAreOrdered(t *testing.T) {
+	if err := NewMigrator(nil, All).validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range All {
+		if migration.Down == nil {
+			t.Errorf("migration %d (%s) has no Down step", migration.Version, migration.Description)
+		}
+	}
+}
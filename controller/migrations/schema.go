@@ -0,0 +1,211 @@
+package migrations
+
+import (
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/services"
+	"gorm.io/gorm"
+)
+
+// All is the controller's schema history. Append new migrations with the
+// next version; never edit one that has been released. Migration 1 creates
+// tables from the current models, so later steps must allow for their
+// changes already being present on a fresh database, e.g. by checking
+// Migrator().HasColumn first.
+var All = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		// Databases created before versioning already have these tables,
+		// which AutoMigrate leaves in place
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(initialSchema()...)
+		},
+		Down: func(tx *gorm.DB) error {
+			tables := initialSchema()
+			for i := len(tables) - 1; i >= 0; i-- {
+				if err := tx.Migrator().DropTable(tables[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     2,
+		Description: "purge soft-deleted nodes",
+		// Nodes are now deleted outright; earlier soft deletes still hold
+		// their names
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Exec("DELETE FROM topology WHERE hub_id IN (SELECT id FROM nodes WHERE deleted_at IS NOT NULL) OR spoke_id IN (SELECT id FROM nodes WHERE deleted_at IS NOT NULL)").Error; err != nil {
+				return err
+			}
+			return tx.Exec("DELETE FROM nodes WHERE deleted_at IS NOT NULL").Error
+		},
+		// The purged rows can't be restored, and the schema is unchanged
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	},
+	{
+		Version:     3,
+		Description: "agent-generated node keys",
+		// Agents keep their private keys; the controller only asks for a
+		// new public key
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"private_key", "private_key_hash"} {
+				// Dropped by name, as the model no longer has them
+				if tx.Migrator().HasColumn(&models.Node{}, column) {
+					if err := tx.Exec("ALTER TABLE nodes DROP COLUMN " + column).Error; err != nil {
+						return err
+					}
+				}
+			}
+			if !tx.Migrator().HasColumn(&models.Node{}, "KeyRotationRequestedAt") {
+				return tx.Migrator().AddColumn(&models.Node{}, "KeyRotationRequestedAt")
+			}
+			return nil
+		},
+		// The dropped keys are gone for good; the columns come back empty
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"private_key", "private_key_hash"} {
+				if err := tx.Exec("ALTER TABLE nodes ADD COLUMN " + column + " TEXT").Error; err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropColumn(&models.Node{}, "KeyRotationRequestedAt")
+		},
+	},
+	{
+		Version:     4,
+		Description: "unique audit chain sequence",
+		// Controllers sharing a database could give two entries the same
+		// sequence; those links are broken already, so all but the first
+		// are left unchained before the index goes on
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&models.AuditLog{}, "idx_audit_logs_chain_sequence") {
+				return nil
+			}
+			if err := tx.Exec(`UPDATE audit_logs SET sequence = 0 WHERE sequence > 0 AND EXISTS (
+				SELECT 1 FROM audit_logs AS a WHERE a.sequence = audit_logs.sequence
+				AND (a.created_at < audit_logs.created_at OR (a.created_at = audit_logs.created_at AND a.hash < audit_logs.hash)))`).Error; err != nil {
+				return err
+			}
+			if tx.Migrator().HasIndex(&models.AuditLog{}, "idx_audit_logs_sequence") {
+				if err := tx.Migrator().DropIndex(&models.AuditLog{}, "idx_audit_logs_sequence"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().CreateIndex(&models.AuditLog{}, "idx_audit_logs_chain_sequence")
+		},
+		// Entries unchained on the way up stay that way
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&models.AuditLog{}, "idx_audit_logs_chain_sequence"); err != nil {
+				return err
+			}
+			return tx.Exec("CREATE INDEX idx_audit_logs_sequence ON audit_logs (sequence)").Error
+		},
+	},
+	{
+		Version:     5,
+		Description: "node peer errors",
+		// Peers an agent could not apply are kept on the node until its next
+		// report
+		Up: func(tx *gorm.DB) error {
+			for _, field := range []string{"PeerErrors", "PeerErrorsReportedAt"} {
+				if tx.Migrator().HasColumn(&models.Node{}, field) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&models.Node{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, field := range []string{"PeerErrors", "PeerErrorsReportedAt"} {
+				if err := tx.Migrator().DropColumn(&models.Node{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     6,
+		Description: "allowed CIDR column name",
+		// The column was named after the field, c_id_r, which the allowlist
+		// queries by cidr didn't match
+		Up: func(tx *gorm.DB) error {
+			return renameAllowedCIDRColumn(tx, "c_id_r", "cidr")
+		},
+		Down: func(tx *gorm.DB) error {
+			return renameAllowedCIDRColumn(tx, "cidr", "c_id_r")
+		},
+	},
+	{
+		Version:     7,
+		Description: "operator role constraint",
+		// Databases created from infra/docker/init.sql check the role
+		// against a list without operator; AutoMigrate adds no such check
+		Up: func(tx *gorm.DB) error {
+			return replaceUserRoleCheck(tx, "'admin', 'operator', 'user', 'observer'")
+		},
+		Down: func(tx *gorm.DB) error {
+			return replaceUserRoleCheck(tx, "'admin', 'user', 'observer'")
+		},
+	},
+}
+
+// initialSchema lists the tables of migration 1 in dependency order.
+func initialSchema() []interface{} {
+	return []interface{}{
+		&models.Node{},
+		&models.Topology{},
+		&models.Policy{},
+		&models.NodeGroup{},
+		&models.NodeGroupMember{},
+		&models.User{},
+		&models.AuditLog{},
+		&services.BackupInfo{},
+		&services.ApprovalRequest{},
+		&services.ConfigVersion{},
+		&services.RefreshToken{},
+		&services.PasswordResetToken{},
+		&services.BackupSchedule{},
+		&services.SecurityEvent{},
+		&services.SecurityPolicyRecord{},
+		&services.AllowedCIDR{},
+		&services.NodeMetricsSample{},
+		&services.AlertRule{},
+		&services.Alert{},
+		&services.HAElectionState{},
+	}
+}
+
+// renameAllowedCIDRColumn renames the allowlist's CIDR column and its unique
+// index, if the column has the old name.
+func renameAllowedCIDRColumn(tx *gorm.DB, from, to string) error {
+	migrator := tx.Migrator()
+	if !migrator.HasColumn(&services.AllowedCIDR{}, from) || migrator.HasColumn(&services.AllowedCIDR{}, to) {
+		return nil
+	}
+	if err := migrator.RenameColumn(&services.AllowedCIDR{}, from, to); err != nil {
+		return err
+	}
+	if migrator.HasIndex(&services.AllowedCIDR{}, "idx_allowed_cidrs_"+from) {
+		return migrator.RenameIndex(&services.AllowedCIDR{}, "idx_allowed_cidrs_"+from, "idx_allowed_cidrs_"+to)
+	}
+	return nil
+}
+
+// replaceUserRoleCheck recreates the users role check with the given roles,
+// if the table has one.
+func replaceUserRoleCheck(tx *gorm.DB, roles string) error {
+	if !tx.Migrator().HasConstraint(&models.User{}, "users_role_check") {
+		return nil
+	}
+	if err := tx.Exec("ALTER TABLE users DROP CONSTRAINT users_role_check").Error; err != nil {
+		return err
+	}
+	return tx.Exec("ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN (" + roles + "))").Error
+}
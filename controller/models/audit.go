@@ -15,6 +15,9 @@ const (
 	AuditActionDelete AuditAction = "delete"
 	AuditActionLogin  AuditAction = "login"
 	AuditActionLogout AuditAction = "logout"
+	AuditActionExport AuditAction = "export"
+	// AuditActionRequest records a state-changing API request
+	AuditActionRequest AuditAction = "request"
 )
 
 type AuditLog struct {
@@ -28,6 +31,10 @@ type AuditLog struct {
 	IPAddress   string      `json:"ip_address"`
 	UserAgent   string      `json:"user_agent"`
 	Metadata    string      `json:"metadata" gorm:"type:jsonb"`
+	JobID       *uuid.UUID  `json:"job_id,omitempty" gorm:"type:uuid;index"`
+	Sequence    int64       `json:"sequence" gorm:"uniqueIndex:idx_audit_logs_chain_sequence,where:sequence > 0"` // position in the hash chain, 0 if unchained
+	PrevHash    string      `json:"prev_hash"`             // hash of the entry before this one
+	Hash        string      `json:"hash"`                  // SHA-256 over PrevHash and this entry's contents
 	CreatedAt   time.Time   `json:"created_at"`
 }
 
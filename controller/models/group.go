@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// NodeGroup is a named set of spokes that policies can select by ID. Spokes
+// sharing a group with Mesh set peer with each other directly when the mesh
+// feature is on, rather than only through their hub.
+type NodeGroup struct {
+	ID          uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name        string         `json:"name" gorm:"uniqueIndex;not null"`
+	Description string         `json:"description"`
+	Mesh        bool           `json:"mesh"`
+	Members     []Node         `json:"members,omitempty" gorm:"-"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+func (g *NodeGroup) BeforeCreate(tx *gorm.DB) error {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return nil
+}
+
+func (g *NodeGroup) TableName() string {
+	return "node_groups"
+}
+
+// NodeGroupMember assigns a node to a group.
+type NodeGroupMember struct {
+	GroupID   uuid.UUID `json:"group_id" gorm:"type:uuid;primaryKey"`
+	NodeID    uuid.UUID `json:"node_id" gorm:"type:uuid;primaryKey;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (m *NodeGroupMember) TableName() string {
+	return "node_group_members"
+}
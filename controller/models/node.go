@@ -27,22 +27,45 @@ const (
 type Node struct {
 	ID                uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	Name              string     `json:"name" gorm:"uniqueIndex;not null"`
+	Description       string     `json:"description"`
 	NodeType          NodeType   `json:"node_type" gorm:"not null"`
 	PublicKey         string     `json:"public_key" gorm:"not null"`
-	PrivateKeyHash    string     `json:"-" gorm:"column:private_key_hash"`
+	KeyRotatedAt      *time.Time `json:"key_rotated_at"`
+	KeyRotationRequestedAt *time.Time `json:"key_rotation_requested_at"` // set until the agent submits a new public key
 	AllocatedIP       string     `json:"allocated_ip" gorm:"type:inet;not null"`
 	Endpoint          string     `json:"endpoint"`
 	Port              int        `json:"port"`
 	AllowedIPs        []string   `json:"allowed_ips" gorm:"type:text[]"`
 	LastHandshake     *time.Time `json:"last_handshake"`
+	LastSeen          *time.Time `json:"last_seen" gorm:"index"` // last heartbeat from the node's agent
+	EndpointProbedAt  *time.Time `json:"endpoint_probed_at"`
+	EndpointProbeError string    `json:"endpoint_probe_error,omitempty"` // empty if the last probe reached the endpoint
+	PeerErrors        []PeerError `json:"peer_errors,omitempty" gorm:"type:text;serializer:json"` // peers the agent last failed to apply
+	PeerErrorsReportedAt *time.Time `json:"peer_errors_reported_at"`
 	Status            NodeStatus `json:"status" gorm:"default:pending"`
 	PersistentKeepalive *int     `json:"persistent_keepalive"`
+	AgentVersion      string     `json:"agent_version"` // reported by the agent when it registers
+	BuildCommit       string     `json:"build_commit"`
 	MTU               int        `json:"mtu" gorm:"default:1420"`
+	PinnedHubID       *uuid.UUID `json:"pinned_hub_id" gorm:"type:uuid"`
+	BackupHubIDs      []string   `json:"backup_hub_ids" gorm:"type:text[]"`
+	Routes            []string   `json:"routes" gorm:"type:text[]"` // LAN subnets behind the node
+	PreUp             []string   `json:"pre_up" gorm:"type:text[]"`
+	PostUp            []string   `json:"post_up" gorm:"type:text[]"`
+	PreDown           []string   `json:"pre_down" gorm:"type:text[]"`
+	PostDown          []string   `json:"post_down" gorm:"type:text[]"`
+	Tags              map[string]string `json:"tags" gorm:"type:text;serializer:json"` // e.g. region, environment or customer
 	CreatedAt         time.Time  `json:"created_at"`
 	UpdatedAt         time.Time  `json:"updated_at"`
 	DeletedAt         gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// PeerError is a peer that a node's agent could not apply to its interface.
+type PeerError struct {
+	PublicKey string `json:"public_key"`
+	Error     string `json:"error"`
+}
+
 func (n *Node) BeforeCreate(tx *gorm.DB) error {
 	if n.ID == uuid.Nil {
 		n.ID = uuid.New()
@@ -62,6 +85,10 @@ func (n *Node) IsActive() bool {
 	return n.Status == NodeStatusActive
 }
 
+func (n *Node) IsPinned() bool {
+	return n.PinnedHubID != nil && *n.PinnedHubID != uuid.Nil
+}
+
 func (n *Node) GetEndpoint() string {
 	if n.Endpoint == "" {
 		return ""
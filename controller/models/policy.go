@@ -22,6 +22,10 @@ type Policy struct {
 	DestinationNodeID *uuid.UUID    `json:"destination_node_id" gorm:"type:uuid"`
 	SourceNode        *Node         `json:"source_node,omitempty" gorm:"foreignKey:SourceNodeID"`
 	DestinationNode   *Node         `json:"destination_node,omitempty" gorm:"foreignKey:DestinationNodeID"`
+	SourceGroup       NodeType      `json:"source_group"`      // every node of this type
+	DestinationGroup  NodeType      `json:"destination_group"` // every node of this type
+	SourceGroupID     *uuid.UUID    `json:"source_group_id" gorm:"type:uuid"`      // members of this node group
+	DestinationGroupID *uuid.UUID   `json:"destination_group_id" gorm:"type:uuid"` // members of this node group
 	SourceCIDR        string        `json:"source_cidr"`
 	DestinationCIDR   string        `json:"destination_cidr"`
 	Protocol          string        `json:"protocol"`
@@ -49,6 +53,12 @@ func (p *Policy) IsDeny() bool {
 	return p.Action == PolicyActionDeny
 }
 
+// FiltersTraffic reports whether the policy is limited to a protocol or
+// port rather than matching every packet between its addresses.
+func (p *Policy) FiltersTraffic() bool {
+	return p.Port != nil || (p.Protocol != "" && p.Protocol != "any")
+}
+
 func (p *Policy) TableName() string {
 	return "policies"
 }
\ No newline at end of file
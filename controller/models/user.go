@@ -11,8 +11,31 @@ import (
 type UserRole string
 
 const (
-	UserRoleAdmin UserRole = "admin"
-	UserRoleUser  UserRole = "user"
+	UserRoleAdmin    UserRole = "admin"
+	UserRoleOperator UserRole = "operator"
+	UserRoleUser     UserRole = "user"
+	UserRoleObserver UserRole = "observer"
+)
+
+// userRoleLevels orders roles by privilege. Observers sit outside the
+// hierarchy: they can read everything and write nothing.
+var userRoleLevels = map[UserRole]int{
+	UserRoleUser:     1,
+	UserRoleOperator: 2,
+	UserRoleAdmin:    3,
+}
+
+// AtLeast reports whether r grants everything required grants.
+func (r UserRole) AtLeast(required UserRole) bool {
+	return userRoleLevels[r] >= userRoleLevels[required]
+}
+
+// AuthSource records where a user's credentials are checked.
+type AuthSource string
+
+const (
+	AuthSourceLocal AuthSource = "local"
+	AuthSourceLDAP  AuthSource = "ldap"
 )
 
 type User struct {
@@ -22,6 +45,9 @@ type User struct {
 	Password    string    `json:"-" gorm:"not null"`
 	Role        UserRole  `json:"role" gorm:"default:user"`
 	IsActive    bool      `json:"is_active" gorm:"default:true"`
+	AuthSource  AuthSource `json:"auth_source" gorm:"default:local"`
+	// MustChangePassword makes the user choose a new password at next login
+	MustChangePassword bool `json:"must_change_password" gorm:"default:false"`
 	LastLogin   *time.Time `json:"last_login"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
@@ -53,6 +79,10 @@ func (u *User) IsAdmin() bool {
 	return u.Role == UserRoleAdmin
 }
 
+func (u *User) IsReadOnly() bool {
+	return u.Role == UserRoleObserver
+}
+
 func (u *User) TableName() string {
 	return "users"
 }
\ No newline at end of file
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrApprovalNotFound      = errors.New("approval request not found")
+	ErrApprovalNotPending    = errors.New("approval request is no longer pending")
+	ErrApprovalExpired       = errors.New("approval request has expired")
+	ErrSelfApproval          = errors.New("approval must come from a different admin")
+	ErrApproverNotAdmin      = errors.New("only active admins can decide approval requests")
+	ErrUnknownApprovalAction = errors.New("unknown approval action")
+)
+
+// Destructive actions that go through dual control when it is enabled.
+const (
+	ApprovalActionRestoreBackup  = "backup.restore"
+	ApprovalActionDeleteBackup   = "backup.delete"
+	ApprovalActionExportConfig   = "config.export"
+	ApprovalActionRollbackConfig = "config.rollback"
+	ApprovalActionRotateNodeKey  = "node.rekey"
+	ApprovalActionSetNodeKey     = "node.public_key"
+)
+
+const (
+	ApprovalStatusPending  = "pending"
+	ApprovalStatusApproved = "approved" // approved and currently executing
+	ApprovalStatusRejected = "rejected"
+	ApprovalStatusExecuted = "executed"
+	ApprovalStatusFailed   = "failed"
+)
+
+// Pending requests that nobody decides on within this window can no longer
+// be approved.
+const approvalRequestTTL = 24 * time.Hour
+
+// ApprovalRequest is a destructive action waiting for, or decided by, a
+// second admin.
+type ApprovalRequest struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	Action      string     `json:"action" gorm:"not null;index"`
+	Description string     `json:"description"`
+	Payload     string     `json:"payload" gorm:"type:text"`
+	Status      string     `json:"status" gorm:"not null;index"`
+	RequestedBy uuid.UUID  `json:"requested_by" gorm:"type:uuid;not null"`
+	DecidedBy   *uuid.UUID `json:"decided_by,omitempty" gorm:"type:uuid"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	Result      string     `json:"result,omitempty" gorm:"type:text"`
+	Error       string     `json:"error,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+func (ApprovalRequest) TableName() string {
+	return "approval_requests"
+}
+
+// ApprovalExecutor performs an approved action on behalf of the admin who
+// requested it and returns a result to store with the request.
+type ApprovalExecutor func(ctx context.Context, request *ApprovalRequest) (interface{}, error)
+
+type ApprovalService struct {
+	db           *gorm.DB
+	config       *types.Config
+	auditService *AuditService
+	mutex        sync.RWMutex
+	executors    map[string]ApprovalExecutor
+}
+
+func NewApprovalService(db *gorm.DB, config *types.Config, auditService *AuditService) *ApprovalService {
+	return &ApprovalService{
+		db:           db,
+		config:       config,
+		auditService: auditService,
+		executors:    make(map[string]ApprovalExecutor),
+	}
+}
+
+// Required reports whether destructive actions must be approved by a second
+// admin before they run.
+func (s *ApprovalService) Required() bool {
+	return s.config.Auth.RequireDualApproval
+}
+
+func (s *ApprovalService) RegisterAction(action string, executor ApprovalExecutor) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.executors[action] = executor
+}
+
+// RequestApproval records a pending request to run action with payload.
+// Nothing is executed until another admin approves it.
+func (s *ApprovalService) RequestApproval(ctx context.Context, action string, payload interface{}, requestedBy uuid.UUID, description string) (*ApprovalRequest, error) {
+	s.mutex.RLock()
+	_, ok := s.executors[action]
+	s.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownApprovalAction, action)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode approval payload: %w", err)
+	}
+
+	now := time.Now()
+	request := &ApprovalRequest{
+		ID:          uuid.New(),
+		Action:      action,
+		Description: description,
+		Payload:     string(data),
+		Status:      ApprovalStatusPending,
+		RequestedBy: requestedBy,
+		ExpiresAt:   now.Add(approvalRequestTTL),
+	}
+	if err := s.db.WithContext(ctx).Create(request).Error; err != nil {
+		return nil, fmt.Errorf("failed to create approval request: %w", err)
+	}
+
+	if s.auditService != nil {
+		s.auditService.LogActionWithMetadata(ctx, &requestedBy, models.AuditActionCreate, "approval_request", &request.ID,
+			fmt.Sprintf("Requested approval for %s", action), "", "",
+			map[string]interface{}{
+				"action":      action,
+				"description": description,
+				"status":      request.Status,
+			})
+	}
+
+	return request, nil
+}
+
+// Approve lets a second admin approve a pending request. The action runs
+// immediately on behalf of the requester and the request ends up executed
+// or failed; the returned error is only about the approval itself.
+func (s *ApprovalService) Approve(ctx context.Context, id uuid.UUID, approvedBy uuid.UUID) (*ApprovalRequest, error) {
+	request, err := s.decide(ctx, id, approvedBy, ApprovalStatusApproved, "")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	executor, ok := s.executors[request.Action]
+	s.mutex.RUnlock()
+
+	var result interface{}
+	if ok {
+		result, err = executor(ctx, request)
+	} else {
+		err = fmt.Errorf("%w: %s", ErrUnknownApprovalAction, request.Action)
+	}
+
+	request.Status = ApprovalStatusExecuted
+	request.Error = ""
+	if err != nil {
+		request.Status = ApprovalStatusFailed
+		request.Error = err.Error()
+	}
+	if result != nil {
+		if data, marshalErr := json.Marshal(result); marshalErr == nil {
+			request.Result = string(data)
+		}
+	}
+
+	if err := s.db.WithContext(ctx).Model(&ApprovalRequest{}).Where("id = ?", request.ID).Updates(map[string]interface{}{
+		"status": request.Status,
+		"result": request.Result,
+		"error":  request.Error,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to record approval outcome: %w", err)
+	}
+
+	if s.auditService != nil {
+		s.auditService.LogActionWithMetadata(ctx, &approvedBy, models.AuditActionUpdate, "approval_request", &request.ID,
+			fmt.Sprintf("Approved %s requested by %s", request.Action, request.RequestedBy), "", "",
+			map[string]interface{}{
+				"action":       request.Action,
+				"requested_by": request.RequestedBy.String(),
+				"status":       request.Status,
+				"error":        request.Error,
+			})
+	}
+
+	return request, nil
+}
+
+// Reject lets a second admin turn down a pending request without running it.
+func (s *ApprovalService) Reject(ctx context.Context, id uuid.UUID, rejectedBy uuid.UUID, reason string) (*ApprovalRequest, error) {
+	request, err := s.decide(ctx, id, rejectedBy, ApprovalStatusRejected, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.auditService != nil {
+		s.auditService.LogActionWithMetadata(ctx, &rejectedBy, models.AuditActionUpdate, "approval_request", &request.ID,
+			fmt.Sprintf("Rejected %s requested by %s", request.Action, request.RequestedBy), "", "",
+			map[string]interface{}{
+				"action":       request.Action,
+				"requested_by": request.RequestedBy.String(),
+				"status":       request.Status,
+				"reason":       reason,
+			})
+	}
+
+	return request, nil
+}
+
+// decide moves a pending request to status. The status check and update
+// happen in one statement so two admins approving at once cannot both run
+// the action.
+func (s *ApprovalService) decide(ctx context.Context, id uuid.UUID, decidedBy uuid.UUID, status, reason string) (*ApprovalRequest, error) {
+	request, err := s.GetRequest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.RequestedBy == decidedBy {
+		return nil, ErrSelfApproval
+	}
+
+	var approver models.User
+	if err := s.db.WithContext(ctx).Where("id = ?", decidedBy).First(&approver).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrApproverNotAdmin
+		}
+		return nil, fmt.Errorf("failed to load approver: %w", err)
+	}
+	if approver.Role != models.UserRoleAdmin || !approver.IsActive {
+		return nil, ErrApproverNotAdmin
+	}
+
+	if request.Status != ApprovalStatusPending {
+		return nil, ErrApprovalNotPending
+	}
+
+	now := time.Now()
+	if now.After(request.ExpiresAt) {
+		return nil, ErrApprovalExpired
+	}
+
+	res := s.db.WithContext(ctx).Model(&ApprovalRequest{}).
+		Where("id = ? AND status = ?", id, ApprovalStatusPending).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"decided_by": decidedBy,
+			"decided_at": now,
+			"reason":     reason,
+		})
+	if res.Error != nil {
+		return nil, fmt.Errorf("failed to update approval request: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrApprovalNotPending
+	}
+
+	request.Status = status
+	request.DecidedBy = &decidedBy
+	request.DecidedAt = &now
+	request.Reason = reason
+	return request, nil
+}
+
+func (s *ApprovalService) GetRequest(ctx context.Context, id uuid.UUID) (*ApprovalRequest, error) {
+	var request ApprovalRequest
+	if err := s.db.WithContext(ctx).Where("id = ?", id).First(&request).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrApprovalNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch approval request: %w", err)
+	}
+	return &request, nil
+}
+
+// TakeResult returns a request for the admin who made it along with the
+// result of its action, such as exported configuration. The result is
+// cleared as it is handed over, so it is only returned once and doesn't
+// stay in the database. Other admins get the request without the result.
+func (s *ApprovalService) TakeResult(ctx context.Context, id uuid.UUID, userID uuid.UUID) (*ApprovalRequest, error) {
+	request, err := s.GetRequest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	result := request.Result
+	request.Result = ""
+	if result == "" || request.RequestedBy != userID {
+		return request, nil
+	}
+
+	// Clearing only a result that is still there means two fetches at once
+	// cannot both get it
+	res := s.db.WithContext(ctx).Model(&ApprovalRequest{}).
+		Where("id = ? AND requested_by = ? AND result <> ''", id, userID).
+		Update("result", "")
+	if res.Error != nil {
+		return nil, fmt.Errorf("failed to clear approval result: %w", res.Error)
+	}
+	if res.RowsAffected == 1 {
+		request.Result = result
+	}
+	return request, nil
+}
+
+// GetRequests lists approval requests, newest first, optionally filtered by
+// status.
+func (s *ApprovalService) GetRequests(ctx context.Context, status string, page, perPage int) ([]ApprovalRequest, int64, error) {
+	query := s.db.WithContext(ctx).Model(&ApprovalRequest{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count approval requests: %w", err)
+	}
+
+	var requests []ApprovalRequest
+	offset := (page - 1) * perPage
+	if err := query.Order("created_at DESC").Offset(offset).Limit(perPage).Find(&requests).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch approval requests: %w", err)
+	}
+
+	return requests, total, nil
+}
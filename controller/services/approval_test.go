@@ -0,0 +1,316 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+type approvalTestEnv struct {
+	db        *gorm.DB
+	approvals *ApprovalService
+	backups   *BackupService
+	restores  int
+}
+
+func newApprovalTestEnv(t *testing.T) *approvalTestEnv {
+	t.Helper()
+
+	db := newImportTestDB(t)
+	if err := db.Exec(backupInfosTestTable).Error; err != nil {
+		t.Fatalf("failed to create backup_infos: %v", err)
+	}
+	if err := db.AutoMigrate(&ApprovalRequest{}); err != nil {
+		t.Fatalf("failed to migrate approval_requests: %v", err)
+	}
+
+	config := &types.Config{Auth: types.AuthConfig{RequireDualApproval: true}}
+	auditService := NewAuditService(db)
+
+	env := &approvalTestEnv{
+		db:        db,
+		approvals: NewApprovalService(db, config, auditService),
+		backups:   NewBackupService(db, config, auditService),
+	}
+	env.backups.runCommand = func(cmd *exec.Cmd) ([]byte, error) {
+		env.restores++
+		return nil, nil
+	}
+	env.backups.RegisterApprovalActions(env.approvals)
+	return env
+}
+
+func (e *approvalTestEnv) createUser(t *testing.T, name string, role models.UserRole) uuid.UUID {
+	t.Helper()
+
+	user := models.User{Username: name, Email: name + "@example.com", Password: "x", Role: role, IsActive: true}
+	if err := e.db.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user %s: %v", name, err)
+	}
+	return user.ID
+}
+
+func (e *approvalTestEnv) createBackup(t *testing.T) uuid.UUID {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "wg_sdwan_full.sql")
+	if err := os.WriteFile(path, []byte("-- dump\n"), 0600); err != nil {
+		t.Fatalf("failed to write backup file: %v", err)
+	}
+
+	backup := BackupInfo{
+		Name:      "backup_full",
+		Type:      "full",
+		Status:    "completed",
+		FilePath:  path,
+		Storage:   BackupStorageLocal,
+		StartTime: time.Now(),
+		CreatedBy: uuid.New(),
+	}
+	if err := e.db.Create(&backup).Error; err != nil {
+		t.Fatalf("failed to create backup record: %v", err)
+	}
+	return backup.ID
+}
+
+func TestRestoreRequiresSecondAdminApproval(t *testing.T) {
+	env := newApprovalTestEnv(t)
+	ctx := context.Background()
+
+	requester := env.createUser(t, "alice", models.UserRoleAdmin)
+	approver := env.createUser(t, "bob", models.UserRoleAdmin)
+	backupID := env.createBackup(t)
+
+	request, err := env.approvals.RequestApproval(ctx, ApprovalActionRestoreBackup,
+		RestoreOptions{BackupID: backupID, RestoreType: "full"}, requester, "Restore backup")
+	if err != nil {
+		t.Fatalf("RequestApproval failed: %v", err)
+	}
+	if request.Status != ApprovalStatusPending {
+		t.Fatalf("expected pending request, got %s", request.Status)
+	}
+	if env.restores != 0 {
+		t.Fatalf("restore ran before approval")
+	}
+
+	if _, err := env.approvals.Approve(ctx, request.ID, requester); !errors.Is(err, ErrSelfApproval) {
+		t.Fatalf("expected ErrSelfApproval when the requester approves, got %v", err)
+	}
+	if env.restores != 0 {
+		t.Fatalf("restore ran after self-approval attempt")
+	}
+
+	stored, err := env.approvals.GetRequest(ctx, request.ID)
+	if err != nil {
+		t.Fatalf("GetRequest failed: %v", err)
+	}
+	if stored.Status != ApprovalStatusPending {
+		t.Fatalf("expected request to stay pending after self-approval, got %s", stored.Status)
+	}
+
+	approved, err := env.approvals.Approve(ctx, request.ID, approver)
+	if err != nil {
+		t.Fatalf("Approve by second admin failed: %v", err)
+	}
+	if approved.Status != ApprovalStatusExecuted {
+		t.Fatalf("expected executed request, got %s (error %q)", approved.Status, approved.Error)
+	}
+	if env.restores != 1 {
+		t.Fatalf("expected exactly one restore after approval, got %d", env.restores)
+	}
+	if approved.DecidedBy == nil || *approved.DecidedBy != approver {
+		t.Errorf("expected request decided by %s, got %v", approver, approved.DecidedBy)
+	}
+
+	// The restore itself is audited as the requester, the approval as the approver
+	var restoreLog models.AuditLog
+	if err := env.db.Where("resource = ? AND action = ?", "backup", models.AuditActionUpdate).First(&restoreLog).Error; err != nil {
+		t.Fatalf("expected restore audit entry: %v", err)
+	}
+	if restoreLog.UserID == nil || *restoreLog.UserID != requester {
+		t.Errorf("expected restore audited as requester %s, got %v", requester, restoreLog.UserID)
+	}
+
+	var approvalLogs []models.AuditLog
+	env.db.Where("resource = ? AND resource_id = ?", "approval_request", request.ID).Order("created_at").Find(&approvalLogs)
+	if len(approvalLogs) != 2 {
+		t.Fatalf("expected request and approval audit entries, got %d", len(approvalLogs))
+	}
+	if approvalLogs[1].UserID == nil || *approvalLogs[1].UserID != approver {
+		t.Errorf("expected approval audited as %s, got %v", approver, approvalLogs[1].UserID)
+	}
+
+	if _, err := env.approvals.Approve(ctx, request.ID, approver); !errors.Is(err, ErrApprovalNotPending) {
+		t.Fatalf("expected ErrApprovalNotPending on second approval, got %v", err)
+	}
+	if env.restores != 1 {
+		t.Fatalf("restore ran again on repeated approval")
+	}
+}
+
+func TestApprovalRejectsNonAdminApprover(t *testing.T) {
+	env := newApprovalTestEnv(t)
+	ctx := context.Background()
+
+	requester := env.createUser(t, "alice", models.UserRoleAdmin)
+	observer := env.createUser(t, "carol", models.UserRoleObserver)
+	backupID := env.createBackup(t)
+
+	request, err := env.approvals.RequestApproval(ctx, ApprovalActionRestoreBackup,
+		RestoreOptions{BackupID: backupID, RestoreType: "full"}, requester, "Restore backup")
+	if err != nil {
+		t.Fatalf("RequestApproval failed: %v", err)
+	}
+
+	if _, err := env.approvals.Approve(ctx, request.ID, observer); !errors.Is(err, ErrApproverNotAdmin) {
+		t.Fatalf("expected ErrApproverNotAdmin, got %v", err)
+	}
+	if env.restores != 0 {
+		t.Fatalf("restore ran after approval by a non-admin")
+	}
+}
+
+func TestRejectedRequestNeverExecutes(t *testing.T) {
+	env := newApprovalTestEnv(t)
+	ctx := context.Background()
+
+	requester := env.createUser(t, "alice", models.UserRoleAdmin)
+	approver := env.createUser(t, "bob", models.UserRoleAdmin)
+	backupID := env.createBackup(t)
+
+	request, err := env.approvals.RequestApproval(ctx, ApprovalActionDeleteBackup,
+		map[string]interface{}{"backup_id": backupID}, requester, "Delete backup")
+	if err != nil {
+		t.Fatalf("RequestApproval failed: %v", err)
+	}
+
+	rejected, err := env.approvals.Reject(ctx, request.ID, approver, "not agreed")
+	if err != nil {
+		t.Fatalf("Reject failed: %v", err)
+	}
+	if rejected.Status != ApprovalStatusRejected || rejected.Reason != "not agreed" {
+		t.Errorf("expected rejected request with reason, got %s %q", rejected.Status, rejected.Reason)
+	}
+
+	if _, err := env.approvals.Approve(ctx, request.ID, approver); !errors.Is(err, ErrApprovalNotPending) {
+		t.Fatalf("expected ErrApprovalNotPending after rejection, got %v", err)
+	}
+
+	var count int64
+	env.db.Model(&BackupInfo{}).Where("id = ?", backupID).Count(&count)
+	if count != 1 {
+		t.Errorf("expected backup to survive a rejected delete request")
+	}
+}
+
+func TestApprovalResultIsHandedOverOnce(t *testing.T) {
+	env := newApprovalTestEnv(t)
+	env.approvals.RegisterAction("config.test", func(ctx context.Context, request *ApprovalRequest) (interface{}, error) {
+		return map[string]string{"data": "secret"}, nil
+	})
+	ctx := context.Background()
+
+	requester := env.createUser(t, "alice", models.UserRoleAdmin)
+	approver := env.createUser(t, "bob", models.UserRoleAdmin)
+
+	request, err := env.approvals.RequestApproval(ctx, "config.test", nil, requester, "Export configuration")
+	if err != nil {
+		t.Fatalf("RequestApproval failed: %v", err)
+	}
+	if _, err := env.approvals.Approve(ctx, request.ID, approver); err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+
+	other, err := env.approvals.TakeResult(ctx, request.ID, approver)
+	if err != nil {
+		t.Fatalf("TakeResult failed: %v", err)
+	}
+	if other.Result != "" {
+		t.Errorf("expected no result for another admin, got %q", other.Result)
+	}
+
+	taken, err := env.approvals.TakeResult(ctx, request.ID, requester)
+	if err != nil {
+		t.Fatalf("TakeResult failed: %v", err)
+	}
+	if taken.Result != `{"data":"secret"}` {
+		t.Errorf("expected the requester to get the result, got %q", taken.Result)
+	}
+
+	again, err := env.approvals.TakeResult(ctx, request.ID, requester)
+	if err != nil {
+		t.Fatalf("TakeResult failed: %v", err)
+	}
+	if again.Result != "" {
+		t.Errorf("expected the result only once, got %q", again.Result)
+	}
+
+	var stored ApprovalRequest
+	if err := env.db.First(&stored, "id = ?", request.ID).Error; err != nil {
+		t.Fatalf("failed to load approval request: %v", err)
+	}
+	if stored.Result != "" {
+		t.Errorf("expected the stored result to be cleared, got %q", stored.Result)
+	}
+}
+
+func TestRequestApprovalUnknownAction(t *testing.T) {
+	env := newApprovalTestEnv(t)
+
+	_, err := env.approvals.RequestApproval(context.Background(), "nodes.rekey", nil, uuid.New(), "")
+	if !errors.Is(err, ErrUnknownApprovalAction) {
+		t.Fatalf("expected ErrUnknownApprovalAction, got %v", err)
+	}
+}
+
+func TestNodeKeyRotationRunsOnceApproved(t *testing.T) {
+	env := newApprovalTestEnv(t)
+	nodes := NewNodeService(env.db, &types.Config{}, NewAuditService(env.db))
+	nodes.RegisterApprovalActions(env.approvals)
+	ctx := context.Background()
+
+	requester := env.createUser(t, "alice", models.UserRoleOperator)
+	approver := env.createUser(t, "bob", models.UserRoleAdmin)
+	node := models.Node{Name: "spoke-1", NodeType: models.NodeTypeSpoke, PublicKey: testPublicKey(1), AllocatedIP: "10.100.1.2/16", Status: models.NodeStatusActive}
+	if err := env.db.Omit("AllowedIPs", "BackupHubIDs", "Routes", "PreUp", "PostUp", "PreDown", "PostDown").Create(&node).Error; err != nil {
+		t.Fatalf("failed to create node: %v", err)
+	}
+
+	request, err := env.approvals.RequestApproval(ctx, ApprovalActionRotateNodeKey,
+		map[string]interface{}{"node_id": node.ID}, requester, "Rotate the key of node spoke-1")
+	if err != nil {
+		t.Fatalf("RequestApproval failed: %v", err)
+	}
+
+	rotationRequested := func() bool {
+		t.Helper()
+		var stored models.Node
+		if err := env.db.First(&stored, "id = ?", node.ID).Error; err != nil {
+			t.Fatalf("failed to load node: %v", err)
+		}
+		return stored.KeyRotationRequestedAt != nil
+	}
+	if rotationRequested() {
+		t.Fatal("expected no rotation before approval")
+	}
+
+	approved, err := env.approvals.Approve(ctx, request.ID, approver)
+	if err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	if approved.Status != ApprovalStatusExecuted {
+		t.Fatalf("expected executed request, got %s (error %q)", approved.Status, approved.Error)
+	}
+	if !rotationRequested() {
+		t.Error("expected the rotation to be requested once approved")
+	}
+}
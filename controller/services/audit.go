@@ -2,8 +2,17 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,13 +20,46 @@ import (
 	"gorm.io/gorm"
 )
 
+var ErrInvalidCursor = errors.New("invalid pagination cursor")
+
 type AuditService struct {
 	db *gorm.DB
+	// chainMutex serialises appends to the hash chain; it is shared with
+	// the services WithTx returns
+	chainMutex *sync.Mutex
 }
 
 func NewAuditService(db *gorm.DB) *AuditService {
 	return &AuditService{
-		db: db,
+		db:         db,
+		chainMutex: &sync.Mutex{},
+	}
+}
+
+type auditJobKey struct{}
+
+// WithJobID returns a context whose audit entries are stamped with jobID. Jobs
+// such as backups and imports use it so every sub-action they perform can be
+// reviewed together.
+func WithJobID(ctx context.Context, jobID uuid.UUID) context.Context {
+	return context.WithValue(ctx, auditJobKey{}, jobID)
+}
+
+// JobIDFromContext returns the job correlation ID carried by ctx, or nil when
+// the action is not part of a job.
+func JobIDFromContext(ctx context.Context) *uuid.UUID {
+	if jobID, ok := ctx.Value(auditJobKey{}).(uuid.UUID); ok {
+		return &jobID
+	}
+	return nil
+}
+
+// WithTx returns an audit service that writes through tx, so entries for a
+// job's sub-actions are committed or rolled back together with the job.
+func (s *AuditService) WithTx(tx *gorm.DB) *AuditService {
+	return &AuditService{
+		db:         tx,
+		chainMutex: s.chainMutex,
 	}
 }
 
@@ -30,12 +72,13 @@ func (s *AuditService) LogAction(ctx context.Context, userID *uuid.UUID, action
 		Description: description,
 		IPAddress:   ipAddress,
 		UserAgent:   userAgent,
+		JobID:       JobIDFromContext(ctx),
 	}
 
 	// Don't fail the main operation if audit logging fails
-	if err := s.db.Create(auditLog).Error; err != nil {
+	if err := s.appendToChain(ctx, auditLog); err != nil {
 		// Log error but don't return it
-		fmt.Printf("Failed to create audit log: %v\n", err)
+		slog.ErrorContext(ctx, "Failed to create audit log", "error", err)
 	}
 }
 
@@ -56,10 +99,11 @@ func (s *AuditService) LogActionWithMetadata(ctx context.Context, userID *uuid.U
 		IPAddress:   ipAddress,
 		UserAgent:   userAgent,
 		Metadata:    metadataJSON,
+		JobID:       JobIDFromContext(ctx),
 	}
 
-	if err := s.db.Create(auditLog).Error; err != nil {
-		fmt.Printf("Failed to create audit log: %v\n", err)
+	if err := s.appendToChain(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Failed to create audit log", "error", err)
 	}
 }
 
@@ -67,9 +111,97 @@ func (s *AuditService) GetAuditLogs(ctx context.Context, page, perPage int, filt
 	var logs []models.AuditLog
 	var total int64
 
-	query := s.db.Model(&models.AuditLog{}).Preload("User")
+	query := applyAuditFilters(s.db.Model(&models.AuditLog{}).Preload("User"), filters)
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit logs: %w", err)
+	}
+
+	offset := (page - 1) * perPage
+	if err := query.Order("created_at DESC").Offset(offset).Limit(perPage).Find(&logs).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to get audit logs: %w", err)
+	}
+
+	return logs, total, nil
+}
+
+// LogRequest records a state-changing API request. Reads are left out, as
+// they change nothing and would crowd out the entries that matter.
+func (s *AuditService) LogRequest(ctx context.Context, method, path string, status int, latency time.Duration, clientIP string) {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	s.LogActionWithMetadata(ctx, nil, models.AuditActionRequest, "request", nil,
+		fmt.Sprintf("%s %s returned %d", method, path, status), clientIP, "",
+		map[string]interface{}{
+			"method":     method,
+			"path":       path,
+			"status":     status,
+			"latency_ms": latency.Milliseconds(),
+		})
+}
+
+// GetAuditLogsAfter returns up to limit audit logs matching filters, newest
+// first, starting after the entry cursor points at; an empty cursor starts
+// from the newest. Unlike the offset pages of GetAuditLogs, it seeks on
+// (created_at, id) instead of counting past earlier rows, so deep pages stay
+// fast and entries logged while a client pages through are neither repeated
+// nor skipped. The returned cursor is empty once there is nothing left.
+func (s *AuditService) GetAuditLogsAfter(ctx context.Context, cursor string, limit int, filters map[string]interface{}) ([]models.AuditLog, string, error) {
+	var logs []models.AuditLog
+
+	query := applyAuditFilters(s.db.WithContext(ctx).Model(&models.AuditLog{}).Preload("User"), filters)
+	if cursor != "" {
+		createdAt, id, err := decodeAuditCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", createdAt, createdAt, id)
+	}
+
+	// One extra row tells whether another page follows
+	if err := query.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&logs).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to get audit logs: %w", err)
+	}
+
+	if len(logs) <= limit {
+		return logs, "", nil
+	}
+	logs = logs[:limit]
+	return logs, encodeAuditCursor(&logs[limit-1]), nil
+}
+
+// encodeAuditCursor returns an opaque cursor for the entry after log.
+func encodeAuditCursor(log *models.AuditLog) string {
+	key := log.CreatedAt.Format(time.RFC3339Nano) + "," + log.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeAuditCursor(cursor string) (time.Time, uuid.UUID, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	createdAt, id, ok := strings.Cut(string(key), ",")
+	if !ok {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	return t, parsed, nil
+}
 
-	// Apply filters
+// applyAuditFilters narrows query by the filters GetAuditLogs and
+// ExportAuditLogs accept.
+func applyAuditFilters(query *gorm.DB, filters map[string]interface{}) *gorm.DB {
 	if userID, ok := filters["user_id"].(uuid.UUID); ok {
 		query = query.Where("user_id = ?", userID)
 	}
@@ -86,6 +218,17 @@ func (s *AuditService) GetAuditLogs(ctx context.Context, page, perPage int, filt
 		query = query.Where("resource_id = ?", resourceID)
 	}
 
+	if jobID, ok := filters["job_id"].(uuid.UUID); ok {
+		query = query.Where("job_id = ?", jobID)
+	}
+
+	// q matches text anywhere in the description or metadata, ignoring
+	// case, so investigators can find entries mentioning a host or address
+	if q, ok := filters["q"].(string); ok {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(q)) + "%"
+		query = query.Where(`LOWER(description) LIKE ? ESCAPE '\' OR LOWER(CAST(metadata AS TEXT)) LIKE ? ESCAPE '\'`, pattern, pattern)
+	}
+
 	if startTime, ok := filters["start_time"].(time.Time); ok {
 		query = query.Where("created_at >= ?", startTime)
 	}
@@ -94,16 +237,120 @@ func (s *AuditService) GetAuditLogs(ctx context.Context, page, perPage int, filt
 		query = query.Where("created_at <= ?", endTime)
 	}
 
-	if err := query.Count(&total).Error; err != nil {
-		return nil, 0, fmt.Errorf("failed to count audit logs: %w", err)
+	return query
+}
+
+// Audit log export formats
+const (
+	AuditExportFormatCSV  = "csv"
+	AuditExportFormatJSON = "json"
+)
+
+var auditExportColumns = []string{
+	"id", "created_at", "user_id", "action", "resource", "resource_id",
+	"job_id", "description", "ip_address", "user_agent", "metadata",
+	"sequence", "prev_hash", "hash",
+}
+
+// ExportAuditLogs writes every audit log matching filters to w, oldest first,
+// as CSV or as a JSON array. Rows are read and written one at a time so the
+// export never holds the whole log in memory. It returns the number of rows
+// written.
+func (s *AuditService) ExportAuditLogs(ctx context.Context, w io.Writer, format string, filters map[string]interface{}) (int, error) {
+	if format != AuditExportFormatCSV && format != AuditExportFormatJSON {
+		return 0, fmt.Errorf("unsupported audit export format: %s", format)
 	}
 
-	offset := (page - 1) * perPage
-	if err := query.Order("created_at DESC").Offset(offset).Limit(perPage).Find(&logs).Error; err != nil {
-		return nil, 0, fmt.Errorf("failed to get audit logs: %w", err)
+	rows, err := applyAuditFilters(s.db.WithContext(ctx).Model(&models.AuditLog{}), filters).Order("created_at").Rows()
+	if err != nil {
+		return 0, fmt.Errorf("failed to query audit logs: %w", err)
 	}
+	defer rows.Close()
 
-	return logs, total, nil
+	var csvWriter *csv.Writer
+	if format == AuditExportFormatCSV {
+		csvWriter = csv.NewWriter(w)
+		if err := csvWriter.Write(auditExportColumns); err != nil {
+			return 0, fmt.Errorf("failed to write audit export: %w", err)
+		}
+	} else if _, err := io.WriteString(w, "["); err != nil {
+		return 0, fmt.Errorf("failed to write audit export: %w", err)
+	}
+
+	count := 0
+	for rows.Next() {
+		var log models.AuditLog
+		if err := s.db.ScanRows(rows, &log); err != nil {
+			return count, fmt.Errorf("failed to read audit log: %w", err)
+		}
+
+		if csvWriter != nil {
+			err = csvWriter.Write(auditExportRecord(&log))
+		} else {
+			err = writeAuditJSON(w, &log, count == 0)
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to write audit export: %w", err)
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("failed to read audit logs: %w", err)
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+		err = csvWriter.Error()
+	} else {
+		_, err = io.WriteString(w, "]\n")
+	}
+	if err != nil {
+		return count, fmt.Errorf("failed to write audit export: %w", err)
+	}
+
+	return count, nil
+}
+
+func auditExportRecord(log *models.AuditLog) []string {
+	return []string{
+		log.ID.String(),
+		log.CreatedAt.UTC().Format(time.RFC3339Nano),
+		uuidString(log.UserID),
+		string(log.Action),
+		csvSafe(log.Resource),
+		uuidString(log.ResourceID),
+		uuidString(log.JobID),
+		csvSafe(log.Description),
+		csvSafe(log.IPAddress),
+		csvSafe(log.UserAgent),
+		log.Metadata,
+		strconv.FormatInt(log.Sequence, 10),
+		log.PrevHash,
+		log.Hash,
+	}
+}
+
+// csvSafe stops spreadsheet programs from evaluating user-supplied text,
+// such as a User-Agent header, as a formula.
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func writeAuditJSON(w io.Writer, log *models.AuditLog, first bool) error {
+	data, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+	if !first {
+		if _, err := io.WriteString(w, ",\n"); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(data)
+	return err
 }
 
 func (s *AuditService) GetAuditLog(ctx context.Context, id uuid.UUID) (*models.AuditLog, error) {
@@ -202,6 +449,6 @@ func (s *AuditService) CleanupOldLogs(ctx context.Context, retentionDays int) er
 		return fmt.Errorf("failed to cleanup old audit logs: %w", result.Error)
 	}
 
-	fmt.Printf("Cleaned up %d old audit log entries\n", result.RowsAffected)
+	slog.InfoContext(ctx, "Cleaned up old audit log entries", "count", result.RowsAffected)
 	return nil
 }
\ No newline at end of file
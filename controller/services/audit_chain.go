@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+// AuditChainVerification is the outcome of walking the audit log hash chain.
+type AuditChainVerification struct {
+	Valid      bool             `json:"valid"`
+	Checked    int64            `json:"checked"`
+	Unchained  int64            `json:"unchained"` // entries written before hash chaining
+	BrokenLink *AuditChainBreak `json:"broken_link,omitempty"`
+	VerifiedAt time.Time        `json:"verified_at"`
+}
+
+// AuditChainBreak is the first entry at which the chain no longer holds.
+type AuditChainBreak struct {
+	LogID    uuid.UUID `json:"log_id"`
+	Sequence int64     `json:"sequence"`
+	Reason   string    `json:"reason"`
+}
+
+// chainAppendAttempts bounds how often an append is retried after losing a
+// race for the next sequence number.
+const chainAppendAttempts = 3
+
+// errAuditChainUnreadable marks a failure to read the chain tip, after
+// which the entry is stored unchained rather than lost.
+var errAuditChainUnreadable = errors.New("failed to read audit chain")
+
+// appendToChain links auditLog to the newest chained entry and stores it.
+// The tip is read and the entry written under an advisory lock held until
+// the transaction commits, so controllers sharing a database take turns;
+// when s writes through a caller's transaction the lock lasts until that
+// commits. The unique index on sequence catches anything that slips past,
+// and the append is retried against the new tip.
+func (s *AuditService) appendToChain(ctx context.Context, auditLog *models.AuditLog) error {
+	s.chainMutex.Lock()
+	defer s.chainMutex.Unlock()
+
+	if auditLog.ID == uuid.Nil {
+		auditLog.ID = uuid.New()
+	}
+
+	var err error
+	for attempt := 0; attempt < chainAppendAttempts; attempt++ {
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			if err := lockTx(tx, auditChainLock); err != nil {
+				return fmt.Errorf("%w: %v", errAuditChainUnreadable, err)
+			}
+
+			var tip struct {
+				Sequence int64
+				Hash     string
+			}
+			if err := tx.Model(&models.AuditLog{}).Select("sequence, hash").Where("sequence > 0").
+				Order("sequence DESC").Limit(1).Scan(&tip).Error; err != nil {
+				return fmt.Errorf("%w: %v", errAuditChainUnreadable, err)
+			}
+
+			// Postgres keeps microseconds, so hash the time as it will be read back
+			auditLog.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+			auditLog.Sequence = tip.Sequence + 1
+			auditLog.PrevHash = tip.Hash
+			auditLog.Hash = auditLogHash(auditLog)
+
+			return tx.Create(auditLog).Error
+		})
+		if !isUniqueViolation(err) {
+			break
+		}
+		slog.WarnContext(ctx, "Audit chain sequence taken, retrying", "sequence", auditLog.Sequence)
+	}
+
+	if errors.Is(err, errAuditChainUnreadable) {
+		// Keep the entry even if it cannot be chained
+		slog.ErrorContext(ctx, "Failed to read audit chain, storing entry unchained", "error", err)
+		auditLog.Sequence, auditLog.PrevHash, auditLog.Hash = 0, "", ""
+		return s.db.Create(auditLog).Error
+	}
+	return err
+}
+
+// VerifyChain walks the chained audit logs in order and reports the first
+// entry whose contents no longer match its hash, or that does not follow
+// the entry before it. The oldest remaining entry anchors the chain, so
+// retention cleanup does not count as a break.
+func (s *AuditService) VerifyChain(ctx context.Context) (*AuditChainVerification, error) {
+	result := &AuditChainVerification{
+		Valid:      true,
+		VerifiedAt: time.Now(),
+	}
+	db := s.db.WithContext(ctx)
+
+	if err := db.Model(&models.AuditLog{}).Where("sequence IS NULL OR sequence = 0").Count(&result.Unchained).Error; err != nil {
+		return nil, fmt.Errorf("failed to count unchained audit logs: %w", err)
+	}
+
+	rows, err := db.Model(&models.AuditLog{}).Where("sequence > 0").Order("sequence").Rows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	var prevSequence int64
+	var prevHash string
+	for rows.Next() {
+		var log models.AuditLog
+		if err := db.ScanRows(rows, &log); err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		result.Checked++
+
+		var reason string
+		switch {
+		case auditLogHash(&log) != log.Hash:
+			reason = "entry contents do not match its hash"
+		case prevSequence == 0:
+			// The oldest entry has nothing before it to check against
+		case log.Sequence == prevSequence:
+			reason = fmt.Sprintf("sequence %d is used more than once", log.Sequence)
+		case log.Sequence != prevSequence+1:
+			reason = fmt.Sprintf("entries %d to %d are missing", prevSequence+1, log.Sequence-1)
+		case log.PrevHash != prevHash:
+			reason = "previous hash does not match the preceding entry"
+		}
+
+		if reason != "" {
+			result.Valid = false
+			result.BrokenLink = &AuditChainBreak{
+				LogID:    log.ID,
+				Sequence: log.Sequence,
+				Reason:   reason,
+			}
+			return result, nil
+		}
+
+		prevSequence, prevHash = log.Sequence, log.Hash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit logs: %w", err)
+	}
+
+	return result, nil
+}
+
+// auditLogHash hashes the previous hash together with every recorded field.
+// Fields are length-prefixed so content cannot shift from one to the next.
+func auditLogHash(log *models.AuditLog) string {
+	fields := []string{
+		log.PrevHash,
+		strconv.FormatInt(log.Sequence, 10),
+		log.ID.String(),
+		uuidString(log.UserID),
+		string(log.Action),
+		log.Resource,
+		uuidString(log.ResourceID),
+		log.Description,
+		log.IPAddress,
+		log.UserAgent,
+		canonicalAuditMetadata(log.Metadata),
+		uuidString(log.JobID),
+		log.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+
+	h := sha256.New()
+	for _, field := range fields {
+		fmt.Fprintf(h, "%d:%s;", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// canonicalAuditMetadata re-encodes metadata so that the key order and
+// spacing a jsonb column returns hash the same as what was written.
+func canonicalAuditMetadata(metadata string) string {
+	if metadata == "" {
+		return ""
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(metadata), &value); err != nil {
+		return metadata
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return metadata
+	}
+	return string(data)
+}
+
+func uuidString(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
@@ -0,0 +1,572 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+func newAuditExportTestService(t *testing.T) (*AuditService, []models.AuditLog) {
+	t.Helper()
+
+	db := openTestDB(t)
+	if err := db.Exec(auditLogsTestTable).Error; err != nil {
+		t.Fatalf("failed to create audit_logs: %v", err)
+	}
+
+	userID := uuid.New()
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := []models.AuditLog{
+		{UserID: &userID, Action: models.AuditActionLogin, Resource: "auth", Description: "User logged in", IPAddress: "10.0.0.1", CreatedAt: base},
+		{UserID: &userID, Action: models.AuditActionCreate, Resource: "node", Description: "Node hub-1 created", Metadata: `{"name":"hub-1"}`, CreatedAt: base.Add(time.Hour)},
+		{Action: models.AuditActionUpdate, Resource: "policy", Description: "Policy, \"allow-web\" updated", UserAgent: "=cmd()", CreatedAt: base.Add(2 * time.Hour)},
+		{UserID: &userID, Action: models.AuditActionLogout, Resource: "auth", Description: "User logged out", CreatedAt: base.Add(3 * time.Hour)},
+	}
+	// Insert out of order; the export is sorted by time
+	for _, i := range []int{2, 0, 3, 1} {
+		if err := db.Create(&logs[i]).Error; err != nil {
+			t.Fatalf("failed to create audit log: %v", err)
+		}
+	}
+
+	return NewAuditService(db), logs
+}
+
+func TestExportAuditLogsCSV(t *testing.T) {
+	service, logs := newAuditExportTestService(t)
+
+	var buf bytes.Buffer
+	count, err := service.ExportAuditLogs(context.Background(), &buf, AuditExportFormatCSV, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("ExportAuditLogs failed: %v", err)
+	}
+	if count != len(logs) {
+		t.Errorf("expected %d rows written, got %d", len(logs), count)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid CSV: %v", err)
+	}
+	if len(records) != len(logs)+1 {
+		t.Fatalf("expected header and %d rows, got %d records", len(logs), len(records))
+	}
+	if records[0][0] != "id" || records[0][1] != "created_at" || len(records[0]) != len(auditExportColumns) {
+		t.Errorf("unexpected header %v", records[0])
+	}
+
+	for i, log := range logs {
+		row := records[i+1]
+		if row[0] != log.ID.String() {
+			t.Errorf("row %d: expected log %s in time order, got %s", i, log.ID, row[0])
+		}
+		if row[3] != string(log.Action) {
+			t.Errorf("row %d: expected action %s, got %s", i, log.Action, row[3])
+		}
+	}
+
+	// Quotes and commas survive, and formulas are neutralised
+	if records[3][7] != `Policy, "allow-web" updated` {
+		t.Errorf("expected description to round-trip, got %q", records[3][7])
+	}
+	if records[3][9] != "'=cmd()" {
+		t.Errorf("expected user agent formula to be escaped, got %q", records[3][9])
+	}
+	if records[3][2] != "" || records[2][10] != `{"name":"hub-1"}` {
+		t.Errorf("unexpected user_id %q or metadata %q", records[3][2], records[2][10])
+	}
+}
+
+func TestExportAuditLogsJSON(t *testing.T) {
+	service, logs := newAuditExportTestService(t)
+
+	var buf bytes.Buffer
+	if _, err := service.ExportAuditLogs(context.Background(), &buf, AuditExportFormatJSON, map[string]interface{}{}); err != nil {
+		t.Fatalf("ExportAuditLogs failed: %v", err)
+	}
+
+	var exported []models.AuditLog
+	if err := json.Unmarshal(buf.Bytes(), &exported); err != nil {
+		t.Fatalf("export is not a JSON array: %v\n%s", err, buf.String())
+	}
+	if len(exported) != len(logs) {
+		t.Fatalf("expected %d logs, got %d", len(logs), len(exported))
+	}
+	for i, log := range logs {
+		if exported[i].ID != log.ID || exported[i].Description != log.Description {
+			t.Errorf("entry %d: expected %s %q, got %s %q", i, log.ID, log.Description, exported[i].ID, exported[i].Description)
+		}
+	}
+}
+
+func TestExportAuditLogsEmptyJSON(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Exec(auditLogsTestTable).Error; err != nil {
+		t.Fatalf("failed to create audit_logs: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := NewAuditService(db).ExportAuditLogs(context.Background(), &buf, AuditExportFormatJSON, nil); err != nil {
+		t.Fatalf("ExportAuditLogs failed: %v", err)
+	}
+
+	var exported []models.AuditLog
+	if err := json.Unmarshal(buf.Bytes(), &exported); err != nil || len(exported) != 0 {
+		t.Errorf("expected an empty JSON array, got %q (%v)", buf.String(), err)
+	}
+}
+
+func TestExportAuditLogsDateFilters(t *testing.T) {
+	service, logs := newAuditExportTestService(t)
+
+	// The bounds are inclusive
+	filters := map[string]interface{}{
+		"start_time": logs[1].CreatedAt,
+		"end_time":   logs[2].CreatedAt,
+	}
+
+	for _, format := range []string{AuditExportFormatCSV, AuditExportFormatJSON} {
+		var buf bytes.Buffer
+		count, err := service.ExportAuditLogs(context.Background(), &buf, format, filters)
+		if err != nil {
+			t.Fatalf("%s: ExportAuditLogs failed: %v", format, err)
+		}
+		if count != 2 {
+			t.Errorf("%s: expected 2 logs within the range, got %d", format, count)
+		}
+		out := buf.String()
+		for i, log := range logs {
+			inRange := i == 1 || i == 2
+			if got := bytes.Contains([]byte(out), []byte(log.ID.String())); got != inRange {
+				t.Errorf("%s: log %d (%s) in export = %v, want %v", format, i, log.CreatedAt, got, inRange)
+			}
+		}
+	}
+
+	// Other filters combine with the dates
+	filters["resource"] = "policy"
+	var buf bytes.Buffer
+	count, err := service.ExportAuditLogs(context.Background(), &buf, AuditExportFormatCSV, filters)
+	if err != nil {
+		t.Fatalf("ExportAuditLogs failed: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 policy log within the range, got %d", count)
+	}
+}
+
+func TestExportAuditLogsUnsupportedFormat(t *testing.T) {
+	service, _ := newAuditExportTestService(t)
+
+	var buf bytes.Buffer
+	if _, err := service.ExportAuditLogs(context.Background(), &buf, "xml", nil); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing written for an unsupported format, got %q", buf.String())
+	}
+}
+
+func newAuditChainTestService(t *testing.T, entries int) (*AuditService, []uuid.UUID) {
+	t.Helper()
+
+	db := openTestDB(t)
+	if err := db.Exec(auditLogsTestTable).Error; err != nil {
+		t.Fatalf("failed to create audit_logs: %v", err)
+	}
+	service := NewAuditService(db)
+
+	userID := uuid.New()
+	for i := 0; i < entries; i++ {
+		if i%2 == 0 {
+			service.LogAction(context.Background(), &userID, models.AuditActionUpdate, "node", nil,
+				"Node updated", "10.0.0.1", "curl/8.0")
+		} else {
+			service.LogActionWithMetadata(context.Background(), &userID, models.AuditActionCreate, "policy", nil,
+				"Policy created", "", "", map[string]interface{}{"name": "allow-web", "priority": 100})
+		}
+	}
+
+	var logs []models.AuditLog
+	if err := db.Order("sequence").Find(&logs).Error; err != nil {
+		t.Fatalf("failed to load audit logs: %v", err)
+	}
+	ids := make([]uuid.UUID, len(logs))
+	for i, log := range logs {
+		ids[i] = log.ID
+	}
+	return service, ids
+}
+
+func expectChainBrokenAt(t *testing.T, service *AuditService, id uuid.UUID, sequence int64) *AuditChainBreak {
+	t.Helper()
+
+	result, err := service.VerifyChain(context.Background())
+	if err != nil {
+		t.Fatalf("VerifyChain failed: %v", err)
+	}
+	if result.Valid || result.BrokenLink == nil {
+		t.Fatalf("expected a broken chain, got %+v", result)
+	}
+	if result.BrokenLink.LogID != id || result.BrokenLink.Sequence != sequence {
+		t.Errorf("expected break at entry %d (%s), got %+v", sequence, id, result.BrokenLink)
+	}
+	return result.BrokenLink
+}
+
+func TestAuditChainIntact(t *testing.T) {
+	service, ids := newAuditChainTestService(t, 5)
+
+	var logs []models.AuditLog
+	service.db.Order("sequence").Find(&logs)
+	if len(logs) != 5 {
+		t.Fatalf("expected 5 audit logs, got %d", len(logs))
+	}
+	for i, log := range logs {
+		if log.Sequence != int64(i+1) || log.Hash == "" {
+			t.Errorf("entry %d: expected sequence %d with a hash, got %d %q", i, i+1, log.Sequence, log.Hash)
+		}
+		if i == 0 && log.PrevHash != "" {
+			t.Errorf("expected the first entry to start the chain, got prev hash %q", log.PrevHash)
+		}
+		if i > 0 && log.PrevHash != logs[i-1].Hash {
+			t.Errorf("entry %d: expected prev hash %q, got %q", i, logs[i-1].Hash, log.PrevHash)
+		}
+	}
+
+	result, err := service.VerifyChain(context.Background())
+	if err != nil {
+		t.Fatalf("VerifyChain failed: %v", err)
+	}
+	if !result.Valid || result.Checked != int64(len(ids)) || result.BrokenLink != nil {
+		t.Errorf("expected an intact chain of %d entries, got %+v", len(ids), result)
+	}
+}
+
+func TestAuditChainDetectsMutatedRow(t *testing.T) {
+	service, ids := newAuditChainTestService(t, 4)
+
+	service.db.Exec("UPDATE audit_logs SET description = ? WHERE id = ?", "Nothing to see here", ids[1])
+
+	broken := expectChainBrokenAt(t, service, ids[1], 2)
+	if broken.Reason != "entry contents do not match its hash" {
+		t.Errorf("unexpected reason %q", broken.Reason)
+	}
+}
+
+func TestAuditChainDetectsRehashedRow(t *testing.T) {
+	service, ids := newAuditChainTestService(t, 4)
+
+	// Recomputing the altered entry's own hash still breaks the next link
+	var log models.AuditLog
+	service.db.First(&log, "id = ?", ids[1])
+	log.Resource = "user"
+	service.db.Exec("UPDATE audit_logs SET resource = ?, hash = ? WHERE id = ?", log.Resource, auditLogHash(&log), log.ID)
+
+	broken := expectChainBrokenAt(t, service, ids[2], 3)
+	if broken.Reason != "previous hash does not match the preceding entry" {
+		t.Errorf("unexpected reason %q", broken.Reason)
+	}
+}
+
+func TestAuditChainDetectsDeletedRow(t *testing.T) {
+	service, ids := newAuditChainTestService(t, 4)
+
+	service.db.Exec("DELETE FROM audit_logs WHERE id = ?", ids[2])
+
+	broken := expectChainBrokenAt(t, service, ids[3], 4)
+	if broken.Reason != "entries 3 to 3 are missing" {
+		t.Errorf("unexpected reason %q", broken.Reason)
+	}
+}
+
+func TestAuditChainToleratesRetentionAndReformattedMetadata(t *testing.T) {
+	service, ids := newAuditChainTestService(t, 4)
+
+	// Retention cleanup removes the oldest entries
+	service.db.Exec("DELETE FROM audit_logs WHERE id = ?", ids[0])
+	// jsonb hands metadata back with its own key order and spacing
+	service.db.Exec("UPDATE audit_logs SET metadata = ? WHERE id = ?", `{"priority": 100, "name": "allow-web"}`, ids[1])
+
+	result, err := service.VerifyChain(context.Background())
+	if err != nil {
+		t.Fatalf("VerifyChain failed: %v", err)
+	}
+	if !result.Valid || result.Checked != 3 {
+		t.Errorf("expected the remaining 3 entries to verify, got %+v (%+v)", result, result.BrokenLink)
+	}
+}
+
+func TestAuditChainSurvivesRolledBackTransaction(t *testing.T) {
+	service, _ := newAuditChainTestService(t, 2)
+
+	tx := service.db.Begin()
+	service.WithTx(tx).LogAction(context.Background(), nil, models.AuditActionCreate, "node", nil, "Rolled back", "", "")
+	tx.Rollback()
+
+	service.LogAction(context.Background(), nil, models.AuditActionDelete, "node", nil, "Node deleted", "", "")
+
+	// Entries from before hash chaining are reported but not checked
+	if err := service.db.Create(&models.AuditLog{Action: models.AuditActionLogin, Resource: "auth"}).Error; err != nil {
+		t.Fatalf("failed to create unchained audit log: %v", err)
+	}
+
+	result, err := service.VerifyChain(context.Background())
+	if err != nil {
+		t.Fatalf("VerifyChain failed: %v", err)
+	}
+	if !result.Valid || result.Checked != 3 || result.Unchained != 1 {
+		t.Errorf("expected 3 chained entries and 1 unchained entry to verify, got %+v (%+v)", result, result.BrokenLink)
+	}
+}
+
+func TestAuditChainConcurrentControllers(t *testing.T) {
+	first, _ := newAuditChainTestService(t, 0)
+	// A second controller on the same database has its own in-process lock
+	second := NewAuditService(first.db)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, service := range []*AuditService{first, second} {
+			wg.Add(1)
+			go func(service *AuditService) {
+				defer wg.Done()
+				service.LogAction(context.Background(), nil, models.AuditActionUpdate, "node", nil, "Node updated", "", "")
+			}(service)
+		}
+	}
+	wg.Wait()
+
+	result, err := first.VerifyChain(context.Background())
+	if err != nil {
+		t.Fatalf("VerifyChain failed: %v", err)
+	}
+	if !result.Valid || result.Checked != 20 || result.Unchained != 0 {
+		t.Errorf("expected 20 chained entries to verify, got %+v (%+v)", result, result.BrokenLink)
+	}
+}
+
+func TestAuditChainSequenceIsUnique(t *testing.T) {
+	service, _ := newAuditChainTestService(t, 2)
+
+	err := service.db.Create(&models.AuditLog{Action: models.AuditActionLogin, Resource: "auth", Sequence: 2}).Error
+	if !isUniqueViolation(err) {
+		t.Errorf("expected a reused sequence to be rejected, got %v", err)
+	}
+
+	// Unchained entries all have sequence 0
+	for i := 0; i < 2; i++ {
+		if err := service.db.Create(&models.AuditLog{Action: models.AuditActionLogin, Resource: "auth"}).Error; err != nil {
+			t.Fatalf("failed to create unchained audit log: %v", err)
+		}
+	}
+}
+
+func TestAuditChainAppendsWithinTransaction(t *testing.T) {
+	service, _ := newAuditChainTestService(t, 1)
+
+	err := service.db.Transaction(func(tx *gorm.DB) error {
+		txAudit := service.WithTx(tx)
+		txAudit.LogAction(context.Background(), nil, models.AuditActionCreate, "node", nil, "Node created", "", "")
+		txAudit.LogAction(context.Background(), nil, models.AuditActionUpdate, "node", nil, "Node updated", "", "")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	result, err := service.VerifyChain(context.Background())
+	if err != nil {
+		t.Fatalf("VerifyChain failed: %v", err)
+	}
+	if !result.Valid || result.Checked != 3 {
+		t.Errorf("expected 3 chained entries to verify, got %+v (%+v)", result, result.BrokenLink)
+	}
+}
+
+// newAuditPagingTestService seeds entries audit logs an hour apart, with
+// every third sharing its predecessor's timestamp so that paging has to
+// break ties by ID.
+func newAuditPagingTestService(t *testing.T, entries int) (*AuditService, map[uuid.UUID]bool) {
+	t.Helper()
+
+	db := openTestDB(t)
+	if err := db.Exec(auditLogsTestTable).Error; err != nil {
+		t.Fatalf("failed to create audit_logs: %v", err)
+	}
+
+	seeded := make(map[uuid.UUID]bool)
+	createdAt := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < entries; i++ {
+		if i%3 != 2 {
+			createdAt = createdAt.Add(time.Hour)
+		}
+		log := models.AuditLog{Action: models.AuditActionUpdate, Resource: "node", Description: "Node updated", CreatedAt: createdAt}
+		if err := db.Create(&log).Error; err != nil {
+			t.Fatalf("failed to create audit log: %v", err)
+		}
+		seeded[log.ID] = true
+	}
+	return NewAuditService(db), seeded
+}
+
+func TestGetAuditLogsAfterSurvivesConcurrentInserts(t *testing.T) {
+	const entries, perPage = 25, 4
+	ctx := context.Background()
+
+	// Each page is followed by a new entry, as on a busy controller
+	logDuringPaging := func(service *AuditService) {
+		service.LogAction(ctx, nil, models.AuditActionLogin, "auth", nil, "User logged in", "", "")
+	}
+
+	service, seeded := newAuditPagingTestService(t, entries)
+	seen := make(map[uuid.UUID]int)
+	var previous *models.AuditLog
+	cursor, pages := "", 0
+	for {
+		logs, next, err := service.GetAuditLogsAfter(ctx, cursor, perPage, map[string]interface{}{})
+		if err != nil {
+			t.Fatalf("GetAuditLogsAfter failed: %v", err)
+		}
+		pages++
+		for i := range logs {
+			log := &logs[i]
+			if previous != nil && (log.CreatedAt.After(previous.CreatedAt) ||
+				log.CreatedAt.Equal(previous.CreatedAt) && log.ID.String() > previous.ID.String()) {
+				t.Errorf("expected newest first, got %s after %s", log.ID, previous.ID)
+			}
+			seen[log.ID]++
+			previous = log
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+		logDuringPaging(service)
+	}
+
+	if len(seen) != entries || pages != (entries+perPage-1)/perPage {
+		t.Errorf("expected all %d entries over %d pages, got %d over %d", entries, (entries+perPage-1)/perPage, len(seen), pages)
+	}
+	for id, count := range seen {
+		if !seeded[id] || count != 1 {
+			t.Errorf("expected each seeded entry exactly once, got %s %d times (seeded: %v)", id, count, seeded[id])
+		}
+	}
+
+	// The same traversal with offsets repeats entries pushed down by the
+	// inserts and never reaches the oldest ones
+	service, seeded = newAuditPagingTestService(t, entries)
+	seen = make(map[uuid.UUID]int)
+	for page := 1; page <= (entries+perPage-1)/perPage; page++ {
+		logs, _, err := service.GetAuditLogs(ctx, page, perPage, map[string]interface{}{})
+		if err != nil {
+			t.Fatalf("GetAuditLogs failed: %v", err)
+		}
+		for _, log := range logs {
+			seen[log.ID]++
+		}
+		logDuringPaging(service)
+	}
+
+	missed := 0
+	for id := range seeded {
+		if seen[id] == 0 {
+			missed++
+		}
+	}
+	if missed == 0 {
+		t.Errorf("expected offset paging to miss seeded entries, saw %d", len(seen))
+	}
+}
+
+func TestGetAuditLogsAfterRejectsInvalidCursor(t *testing.T) {
+	service, _ := newAuditPagingTestService(t, 1)
+
+	for _, cursor := range []string{"not base64!", "bm8tc2VwYXJhdG9y", encodeAuditCursor(&models.AuditLog{}) + "x"} {
+		if _, _, err := service.GetAuditLogsAfter(context.Background(), cursor, 10, map[string]interface{}{}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("expected ErrInvalidCursor for %q, got %v", cursor, err)
+		}
+	}
+}
+
+func TestGetAuditLogsSearch(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Exec(auditLogsTestTable).Error; err != nil {
+		t.Fatalf("failed to create audit_logs: %v", err)
+	}
+	service := NewAuditService(db)
+	ctx := context.Background()
+
+	service.LogAction(ctx, nil, models.AuditActionCreate, "node", nil, "Node HUB-1.example.com created", "10.0.0.1", "")
+	service.LogActionWithMetadata(ctx, nil, models.AuditActionUpdate, "node", nil, "Node updated", "", "",
+		map[string]interface{}{"endpoint": "hub-1.example.com:51820"})
+	service.LogActionWithMetadata(ctx, nil, models.AuditActionUpdate, "policy", nil, "Policy updated", "", "",
+		map[string]interface{}{"destination_cidr": "192.168.10.0/24"})
+	service.LogAction(ctx, nil, models.AuditActionDelete, "node", nil, "Node hub-10 deleted", "", "")
+
+	tests := []struct {
+		filters  map[string]interface{}
+		expected []string
+	}{
+		{map[string]interface{}{"q": "hub-1.example"}, []string{"Node HUB-1.example.com created", "Node updated"}},
+		{map[string]interface{}{"q": "hub-1.example", "action": string(models.AuditActionUpdate)}, []string{"Node updated"}},
+		{map[string]interface{}{"q": "192.168.10."}, []string{"Policy updated"}},
+		{map[string]interface{}{"q": "hub-1"}, []string{"Node HUB-1.example.com created", "Node hub-10 deleted", "Node updated"}},
+		// Wildcards are matched literally, and other columns are not searched
+		{map[string]interface{}{"q": "hub_1"}, nil},
+		{map[string]interface{}{"q": "10.0.0.1"}, nil},
+	}
+
+	for _, tt := range tests {
+		logs, total, err := service.GetAuditLogs(ctx, 1, 10, tt.filters)
+		if err != nil {
+			t.Fatalf("GetAuditLogs(%v) failed: %v", tt.filters, err)
+		}
+
+		var descriptions []string
+		for _, log := range logs {
+			descriptions = append(descriptions, log.Description)
+		}
+		sort.Strings(descriptions)
+		if int(total) != len(tt.expected) || strings.Join(descriptions, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("GetAuditLogs(%v): expected %q, got %q (total %d)", tt.filters, tt.expected, descriptions, total)
+		}
+	}
+}
+
+func TestLogRequestRecordsOnlyWrites(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Exec(auditLogsTestTable).Error; err != nil {
+		t.Fatalf("failed to create audit_logs: %v", err)
+	}
+	service := NewAuditService(db)
+	ctx := context.Background()
+
+	service.LogRequest(ctx, http.MethodGet, "/api/v1/nodes", http.StatusOK, time.Millisecond, "10.0.0.1")
+	service.LogRequest(ctx, http.MethodPatch, "/api/v1/nodes/status", http.StatusOK, 25*time.Millisecond, "10.0.0.1")
+
+	var logs []models.AuditLog
+	if err := db.Find(&logs).Error; err != nil {
+		t.Fatalf("failed to load audit logs: %v", err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("expected only the PATCH to be recorded, got %d entries", len(logs))
+	}
+	if logs[0].Action != models.AuditActionRequest || logs[0].IPAddress != "10.0.0.1" ||
+		!strings.Contains(logs[0].Metadata, `"path":"/api/v1/nodes/status"`) || !strings.Contains(logs[0].Metadata, `"status":200`) {
+		t.Errorf("unexpected request entry: %+v", logs[0])
+	}
+}
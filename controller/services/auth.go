@@ -2,8 +2,11 @@ package services
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -21,12 +24,23 @@ var (
 	ErrTokenExpired     = errors.New("token expired")
 	ErrUnauthorized     = errors.New("unauthorized")
 	ErrInsufficientRole = errors.New("insufficient role")
+	ErrReadOnlyRole     = errors.New("role has read-only access")
+	ErrDefaultJWTSecret = errors.New("JWT secret is set to a known default value")
+	ErrWeakJWTSecret    = errors.New("JWT secret is too weak")
+	ErrAccountLocked    = errors.New("account temporarily locked")
+	// ErrRegistrationDisabled is returned by Register unless
+	// Auth.AllowRegistration is set
+	ErrRegistrationDisabled = errors.New("self-registration is disabled")
 )
 
 type AuthService struct {
-	db        *gorm.DB
-	config    *types.Config
-	auditSvc  *AuditService
+	db               *gorm.DB
+	config           *types.Config
+	auditSvc         *AuditService
+	ldap             *LDAPAuthenticator // nil unless LDAP login is enabled
+	emailService     *EmailService
+	validatePassword func(password string) []string
+	securityService  *SecurityService // nil disables login lockout
 }
 
 type Claims struct {
@@ -36,15 +50,29 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// nodeTokenAudience distinguishes agent tokens from user tokens.
+const nodeTokenAudience = "wg-sdwan-agent"
+
+// NodeClaims authenticate an agent as a single node.
+type NodeClaims struct {
+	NodeID uuid.UUID `json:"node_id"`
+	jwt.RegisteredClaims
+}
+
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 }
 
 type LoginResponse struct {
-	Token     string          `json:"token"`
-	ExpiresAt time.Time       `json:"expires_at"`
-	User      models.User     `json:"user"`
+	Token                 string      `json:"token"`
+	ExpiresAt             time.Time   `json:"expires_at"`
+	RefreshToken          string      `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time   `json:"refresh_token_expires_at"`
+	User                  models.User `json:"user"`
+	// MustChangePassword means every route but change-password is refused
+	// until the user sets a new password
+	MustChangePassword bool `json:"must_change_password,omitempty"`
 }
 
 type CreateUserRequest struct {
@@ -54,15 +82,122 @@ type CreateUserRequest struct {
 	Role     models.UserRole  `json:"role"`
 }
 
+// defaultJWTSecrets are placeholder secrets shipped in code and documentation.
+var defaultJWTSecrets = []string{
+	"your-secret-key",
+	"your_jwt_secret_here",
+	"your_jwt_secret_key_here_minimum_32_characters",
+	"your-very-secure-jwt-secret-key-here",
+}
+
+// minJWTSecretEntropyBits is the minimum estimated entropy of a JWT secret.
+const minJWTSecretEntropyBits = 96
+
+// ValidateJWTSecret rejects default, short or low-entropy JWT secrets.
+func ValidateJWTSecret(secret string, minLength int) error {
+	for _, defaultSecret := range defaultJWTSecrets {
+		if secret == defaultSecret {
+			return ErrDefaultJWTSecret
+		}
+	}
+
+	if len(secret) < minLength {
+		return fmt.Errorf("%w: must be at least %d characters long", ErrWeakJWTSecret, minLength)
+	}
+
+	if entropy := estimateEntropyBits(secret); entropy < minJWTSecretEntropyBits {
+		return fmt.Errorf("%w: estimated entropy %.0f bits is below %d bits", ErrWeakJWTSecret, entropy, minJWTSecretEntropyBits)
+	}
+
+	return nil
+}
+
+// estimateEntropyBits estimates the total Shannon entropy of a string based on
+// its character frequencies.
+func estimateEntropyBits(value string) float64 {
+	if value == "" {
+		return 0
+	}
+
+	counts := make(map[rune]int)
+	length := 0
+	for _, r := range value {
+		counts[r]++
+		length++
+	}
+
+	var bitsPerChar float64
+	for _, count := range counts {
+		p := float64(count) / float64(length)
+		bitsPerChar -= p * math.Log2(p)
+	}
+
+	return bitsPerChar * float64(length)
+}
+
 func NewAuthService(db *gorm.DB, config *types.Config, auditSvc *AuditService) *AuthService {
-	return &AuthService{
+	service := &AuthService{
 		db:       db,
 		config:   config,
 		auditSvc: auditSvc,
 	}
+	if config.Auth.LDAP.Enabled {
+		service.ldap = NewLDAPAuthenticator(config.Auth.LDAP)
+	}
+	return service
+}
+
+// SetSecurityService enables locking out IPs after repeated failed logins.
+func (s *AuthService) SetSecurityService(securityService *SecurityService) {
+	s.securityService = securityService
 }
 
+// Login authenticates a user. Once an IP reaches the failed login limit,
+// every attempt from it is refused with ErrAccountLocked until the lockout
+// expires, even with the correct password.
 func (s *AuthService) Login(ctx context.Context, req LoginRequest, clientIP, userAgent string) (*LoginResponse, error) {
+	if s.securityService != nil && s.securityService.IsIPBlocked(clientIP) {
+		return nil, ErrAccountLocked
+	}
+
+	user, err := s.authenticate(ctx, req, clientIP, userAgent)
+	if err != nil {
+		if s.securityService != nil && isFailedLogin(err) {
+			var userID *uuid.UUID
+			if user != nil {
+				userID = &user.ID
+			}
+			s.securityService.RecordFailedLogin(ctx, clientIP, userAgent, userID)
+		}
+		return nil, err
+	}
+
+	if s.securityService != nil {
+		s.securityService.RecordSuccessfulLogin(ctx, clientIP, userAgent, user.ID)
+	}
+	return s.completeLogin(ctx, user, clientIP, userAgent)
+}
+
+// isFailedLogin reports whether err means the credentials were wrong, so the
+// attempt counts toward the lockout. Directory rejections count the same as
+// local ones.
+func isFailedLogin(err error) bool {
+	return errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrInvalidPassword) || errors.Is(err, ErrLDAPInvalidCredentials)
+}
+
+// authenticate checks the credentials against LDAP, then local users. On
+// ErrInvalidPassword the matched user is returned along with the error.
+func (s *AuthService) authenticate(ctx context.Context, req LoginRequest, clientIP, userAgent string) (*models.User, error) {
+	if s.ldap != nil {
+		user, err := s.loginWithLDAP(ctx, req, clientIP, userAgent)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, errUseLocalAuth) {
+			return nil, err
+		}
+	}
+
 	var user models.User
 	if err := s.db.Where("username = ?", req.Username).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -79,19 +214,29 @@ func (s *AuthService) Login(ctx context.Context, req LoginRequest, clientIP, use
 		// Log failed login attempt
 		s.auditSvc.LogAction(ctx, &user.ID, models.AuditActionLogin, "user", &user.ID, 
 			fmt.Sprintf("Failed login attempt for user %s", user.Username), clientIP, userAgent)
-		return nil, ErrInvalidPassword
+		return &user, ErrInvalidPassword
 	}
 
+	return &user, nil
+}
+
+// completeLogin issues tokens to a user whose credentials were accepted.
+func (s *AuthService) completeLogin(ctx context.Context, user *models.User, clientIP, userAgent string) (*LoginResponse, error) {
 	// Generate JWT token
-	token, expiresAt, err := s.generateToken(&user)
+	token, expiresAt, err := s.generateToken(user)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
 
+	refreshToken, stored, err := s.createRefreshToken(s.db.WithContext(ctx), user.ID, uuid.New(), clientIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
 	// Update last login time
 	now := time.Now()
 	user.LastLogin = &now
-	if err := s.db.Save(&user).Error; err != nil {
+	if err := s.db.Save(user).Error; err != nil {
 		return nil, fmt.Errorf("failed to update last login: %w", err)
 	}
 
@@ -100,9 +245,12 @@ func (s *AuthService) Login(ctx context.Context, req LoginRequest, clientIP, use
 		fmt.Sprintf("User %s logged in successfully", user.Username), clientIP, userAgent)
 
 	return &LoginResponse{
-		Token:     token,
-		ExpiresAt: expiresAt,
-		User:      user,
+		Token:                 token,
+		ExpiresAt:             expiresAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: stored.ExpiresAt,
+		User:                  *user,
+		MustChangePassword:    user.MustChangePassword,
 	}, nil
 }
 
@@ -136,6 +284,50 @@ func (s *AuthService) CreateUser(ctx context.Context, req CreateUserRequest, cre
 	return user, nil
 }
 
+// RegisterRequest is a self-registration. There is no role: registered
+// accounts are always created with the user role.
+type RegisterRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+// Register creates an account for an unauthenticated caller, if
+// self-registration is enabled.
+func (s *AuthService) Register(ctx context.Context, req RegisterRequest, clientIP, userAgent string) (*models.User, error) {
+	if !s.config.Auth.AllowRegistration {
+		return nil, ErrRegistrationDisabled
+	}
+	if s.validatePassword != nil {
+		if problems := s.validatePassword(req.Password); len(problems) > 0 {
+			return nil, fmt.Errorf("%w: %s", ErrPasswordPolicy, strings.Join(problems, "; "))
+		}
+	}
+
+	var existingUser models.User
+	if err := s.db.Where("username = ? OR email = ?", req.Username, req.Email).First(&existingUser).Error; err == nil {
+		return nil, ErrUserExists
+	}
+
+	user := &models.User{
+		Username: req.Username,
+		Email:    req.Email,
+		Role:     models.UserRoleUser,
+		IsActive: true,
+	}
+	if err := user.SetPassword(req.Password); err != nil {
+		return nil, fmt.Errorf("failed to set password: %w", err)
+	}
+	if err := s.db.Create(user).Error; err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	s.auditSvc.LogAction(ctx, &user.ID, models.AuditActionCreate, "user", &user.ID,
+		fmt.Sprintf("User %s registered", user.Username), clientIP, userAgent)
+
+	return user, nil
+}
+
 func (s *AuthService) GetUsers(ctx context.Context, page, perPage int) ([]models.User, int64, error) {
 	var users []models.User
 	var total int64
@@ -225,18 +417,95 @@ func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 	return nil, ErrInvalidToken
 }
 
+// GenerateNodeToken issues a token the agent on node uses to authenticate
+// against the controller.
+func (s *AuthService) GenerateNodeToken(ctx context.Context, node *models.Node, issuedBy *uuid.UUID) (string, time.Time, error) {
+	expiresAt := time.Now().Add(s.config.Auth.NodeTokenExpiration)
+
+	claims := &NodeClaims{
+		NodeID: node.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "wg-sdwan-controller",
+			Subject:   node.ID.String(),
+			Audience:  jwt.ClaimStrings{nodeTokenAudience},
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(s.config.Auth.JWTSecret))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign node token: %w", err)
+	}
+
+	s.auditSvc.LogAction(ctx, issuedBy, models.AuditActionCreate, "node_token", &node.ID,
+		fmt.Sprintf("Agent token issued for node %s", node.Name), "", "")
+
+	return tokenString, expiresAt, nil
+}
+
+func (s *AuthService) ValidateNodeToken(tokenString string) (*NodeClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &NodeClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(s.config.Auth.JWTSecret), nil
+	}, jwt.WithAudience(nodeTokenAudience))
+
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims, ok := token.Claims.(*NodeClaims); ok && token.Valid && claims.NodeID != uuid.Nil {
+		return claims, nil
+	}
+
+	return nil, ErrInvalidToken
+}
+
+// AuthenticateNodeCertificate returns the node whose client certificate
+// was presented on the connection; see
+// SecurityService.NodeIDFromClientCertificate. Certificates are not trusted
+// without a security service to validate them.
+func (s *AuthService) AuthenticateNodeCertificate(state *tls.ConnectionState) (uuid.UUID, error) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return uuid.Nil, ErrNoClientCertificate
+	}
+	if s.securityService == nil {
+		return uuid.Nil, ErrUntrustedClientCertificate
+	}
+	return s.securityService.NodeIDFromClientCertificate(state)
+}
+
+// NodeClientCertificateRequired reports whether agents must authenticate
+// with a client certificate rather than an agent token alone.
+func (s *AuthService) NodeClientCertificateRequired() bool {
+	return s.config.Server.TLS.RequireClientCert
+}
+
 func (s *AuthService) RequireRole(userRole models.UserRole, requiredRole models.UserRole) error {
-	if userRole == models.UserRoleAdmin {
-		return nil // Admin can access everything
+	if userRole == models.UserRoleObserver {
+		return nil // Observer can read everything, writes are rejected by RequireWriteAccess
 	}
 
-	if requiredRole == models.UserRoleAdmin && userRole != models.UserRoleAdmin {
+	// Admin > operator > user
+	if !userRole.AtLeast(requiredRole) {
 		return ErrInsufficientRole
 	}
 
 	return nil
 }
 
+func (s *AuthService) RequireWriteAccess(userRole models.UserRole) error {
+	if userRole == models.UserRoleObserver {
+		return ErrReadOnlyRole
+	}
+
+	return nil
+}
+
 func (s *AuthService) generateToken(user *models.User) (string, time.Time, error) {
 	expiresAt := time.Now().Add(s.config.Auth.JWTExpiration)
 
@@ -274,10 +543,19 @@ func (s *AuthService) ChangePassword(ctx context.Context, userID uuid.UUID, curr
 	if !user.CheckPassword(currentPassword) {
 		return ErrInvalidPassword
 	}
+	if s.validatePassword != nil {
+		if problems := s.validatePassword(newPassword); len(problems) > 0 {
+			return fmt.Errorf("%w: %s", ErrPasswordPolicy, strings.Join(problems, "; "))
+		}
+	}
+	if newPassword == currentPassword {
+		return fmt.Errorf("%w: must differ from the current password", ErrPasswordPolicy)
+	}
 
 	if err := user.SetPassword(newPassword); err != nil {
 		return fmt.Errorf("failed to set new password: %w", err)
 	}
+	user.MustChangePassword = false
 
 	if err := s.db.Save(&user).Error; err != nil {
 		return fmt.Errorf("failed to update password: %w", err)
@@ -297,11 +575,13 @@ func (s *AuthService) InitializeDefaultAdmin() error {
 	}
 
 	if count == 0 {
+		// The well-known default password must be changed before use
 		admin := &models.User{
-			Username: "admin",
-			Email:    "admin@example.com",
-			Role:     models.UserRoleAdmin,
-			IsActive: true,
+			Username:           "admin",
+			Email:              "admin@example.com",
+			Role:               models.UserRoleAdmin,
+			IsActive:           true,
+			MustChangePassword: true,
 		}
 
 		if err := admin.SetPassword("admin123"); err != nil {
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+)
+
+func newRefreshTestService(t *testing.T) *AuthService {
+	t.Helper()
+
+	db := newImportTestDB(t)
+	if err := db.AutoMigrate(&RefreshToken{}); err != nil {
+		t.Fatalf("failed to migrate refresh_tokens: %v", err)
+	}
+
+	user := models.User{Username: "alice", Email: "alice@example.com", Role: models.UserRoleAdmin, IsActive: true}
+	if err := user.SetPassword("correct-horse"); err != nil {
+		t.Fatalf("failed to set password: %v", err)
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	config := &types.Config{Auth: types.AuthConfig{
+		JWTSecret:     "refresh-test-secret",
+		JWTExpiration: 15 * time.Minute,
+	}}
+	return NewAuthService(db, config, NewAuditService(db))
+}
+
+func loginForRefresh(t *testing.T, service *AuthService) *LoginResponse {
+	t.Helper()
+
+	resp, err := service.Login(context.Background(), LoginRequest{Username: "alice", Password: "correct-horse"}, "10.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if resp.RefreshToken == "" {
+		t.Fatal("expected login to issue a refresh token")
+	}
+	return resp
+}
+
+func TestRefreshTokenRotates(t *testing.T) {
+	service := newRefreshTestService(t)
+	ctx := context.Background()
+	login := loginForRefresh(t, service)
+
+	var stored RefreshToken
+	if err := service.db.First(&stored).Error; err != nil {
+		t.Fatalf("failed to load refresh token: %v", err)
+	}
+	if stored.TokenHash == login.RefreshToken || stored.TokenHash != hashSecretToken(login.RefreshToken) {
+		t.Errorf("expected only the token hash to be stored")
+	}
+
+	refreshed, err := service.RefreshToken(ctx, login.RefreshToken, "10.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("RefreshToken failed: %v", err)
+	}
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == login.RefreshToken {
+		t.Errorf("expected a new refresh token")
+	}
+	claims, err := service.ValidateToken(refreshed.Token)
+	if err != nil {
+		t.Fatalf("expected a valid access token: %v", err)
+	}
+	if claims.Username != "alice" {
+		t.Errorf("expected access token for alice, got %s", claims.Username)
+	}
+
+	// The rotated token keeps working
+	if _, err := service.RefreshToken(ctx, refreshed.RefreshToken, "10.0.0.1", "test"); err != nil {
+		t.Errorf("expected the rotated refresh token to be accepted: %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	service := newRefreshTestService(t)
+	ctx := context.Background()
+	login := loginForRefresh(t, service)
+
+	refreshed, err := service.RefreshToken(ctx, login.RefreshToken, "10.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("RefreshToken failed: %v", err)
+	}
+
+	if _, err := service.RefreshToken(ctx, login.RefreshToken, "10.0.0.2", "attacker"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused for a rotated token, got %v", err)
+	}
+
+	// Reuse ends the session, so the legitimate successor is revoked too
+	if _, err := service.RefreshToken(ctx, refreshed.RefreshToken, "10.0.0.1", "test"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the successor token to be revoked, got %v", err)
+	}
+}
+
+func TestRefreshTokenRevokedOnLogout(t *testing.T) {
+	service := newRefreshTestService(t)
+	ctx := context.Background()
+	login := loginForRefresh(t, service)
+	other := loginForRefresh(t, service)
+
+	if err := service.RevokeRefreshToken(ctx, login.RefreshToken, "10.0.0.1", "test"); err != nil {
+		t.Fatalf("RevokeRefreshToken failed: %v", err)
+	}
+
+	if _, err := service.RefreshToken(ctx, login.RefreshToken, "10.0.0.1", "test"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for a revoked token, got %v", err)
+	}
+	if _, err := service.RefreshToken(ctx, other.RefreshToken, "10.0.0.1", "test"); err != nil {
+		t.Errorf("expected logout to leave other sessions alone: %v", err)
+	}
+	if err := service.RevokeRefreshToken(ctx, "unknown", "10.0.0.1", "test"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for an unknown token, got %v", err)
+	}
+}
+
+func TestRefreshTokenExpired(t *testing.T) {
+	service := newRefreshTestService(t)
+	login := loginForRefresh(t, service)
+
+	service.db.Model(&RefreshToken{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Minute))
+
+	if _, err := service.RefreshToken(context.Background(), login.RefreshToken, "10.0.0.1", "test"); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestRequireRoleHierarchy(t *testing.T) {
+	service := &AuthService{}
+
+	tests := []struct {
+		role     models.UserRole
+		required models.UserRole
+		allowed  bool
+	}{
+		{models.UserRoleAdmin, models.UserRoleAdmin, true},
+		{models.UserRoleAdmin, models.UserRoleOperator, true},
+		{models.UserRoleOperator, models.UserRoleOperator, true},
+		{models.UserRoleOperator, models.UserRoleUser, true},
+		{models.UserRoleOperator, models.UserRoleAdmin, false},
+		{models.UserRoleUser, models.UserRoleOperator, false},
+		{models.UserRoleUser, models.UserRoleAdmin, false},
+		// Observers read everything; writes are refused by RequireWriteAccess
+		{models.UserRoleObserver, models.UserRoleAdmin, true},
+		{models.UserRole("unknown"), models.UserRoleUser, false},
+	}
+
+	for _, tt := range tests {
+		err := service.RequireRole(tt.role, tt.required)
+		if allowed := err == nil; allowed != tt.allowed {
+			t.Errorf("RequireRole(%s, %s): expected allowed=%v, got %v", tt.role, tt.required, tt.allowed, err)
+		}
+	}
+}
+
+// securityTestSchema stands in for the Postgres uuid defaults of the
+// security tables.
+var securityTestSchema = []string{
+	`CREATE TABLE security_events (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), event_type TEXT NOT NULL, severity TEXT NOT NULL,
+		ip TEXT, user_agent TEXT, user_id TEXT, description TEXT, metadata TEXT, created_at DATETIME)`,
+	`CREATE TABLE security_policies (id INTEGER PRIMARY KEY, policies TEXT NOT NULL, updated_by TEXT, updated_at DATETIME)`,
+	`CREATE TABLE allowed_cidrs (id TEXT PRIMARY KEY, cidr TEXT NOT NULL UNIQUE, created_by TEXT, created_at DATETIME)`,
+}
+
+func newLockoutTestService(t *testing.T) (*AuthService, *SecurityService) {
+	t.Helper()
+
+	service := newRefreshTestService(t)
+	for _, stmt := range securityTestSchema {
+		if err := service.db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create security tables: %v", err)
+		}
+	}
+	securityService := NewSecurityService(service.db, service.config, service.auditSvc)
+	service.SetSecurityService(securityService)
+	return service, securityService
+}
+
+func TestLoginLockout(t *testing.T) {
+	service, securityService := newLockoutTestService(t)
+	ctx := context.Background()
+	maxAttempts := securityService.GetSecurityPolicies().MaxLoginAttempts
+
+	for i := 0; i < maxAttempts; i++ {
+		if _, err := service.Login(ctx, LoginRequest{Username: "alice", Password: "wrong"}, "10.0.0.9", "test"); !errors.Is(err, ErrInvalidPassword) {
+			t.Fatalf("attempt %d: expected ErrInvalidPassword, got %v", i+1, err)
+		}
+	}
+	if !securityService.IsIPBlocked("10.0.0.9") {
+		t.Fatalf("expected the IP to be blocked after %d failed logins", maxAttempts)
+	}
+
+	// The correct password does not get through while locked
+	if _, err := service.Login(ctx, LoginRequest{Username: "alice", Password: "correct-horse"}, "10.0.0.9", "test"); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("expected ErrAccountLocked, got %v", err)
+	}
+	// Other IPs are unaffected
+	if _, err := service.Login(ctx, LoginRequest{Username: "alice", Password: "correct-horse"}, "10.0.0.1", "test"); err != nil {
+		t.Errorf("expected login from another IP to succeed: %v", err)
+	}
+
+	var events int64
+	service.db.Model(&SecurityEvent{}).Where("event_type = ?", "failed_login").Count(&events)
+	if events != int64(maxAttempts) {
+		t.Errorf("expected %d failed_login events, got %d", maxAttempts, events)
+	}
+}
+
+func TestLoginLockoutCountsUnknownUsers(t *testing.T) {
+	service, securityService := newLockoutTestService(t)
+	ctx := context.Background()
+
+	for i := 0; i < securityService.GetSecurityPolicies().MaxLoginAttempts; i++ {
+		service.Login(ctx, LoginRequest{Username: "mallory", Password: "guess"}, "10.0.0.9", "test")
+	}
+	if _, err := service.Login(ctx, LoginRequest{Username: "alice", Password: "correct-horse"}, "10.0.0.9", "test"); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("expected ErrAccountLocked after probing unknown users, got %v", err)
+	}
+}
+
+func TestSuccessfulLoginResetsFailedAttempts(t *testing.T) {
+	service, securityService := newLockoutTestService(t)
+	ctx := context.Background()
+	maxAttempts := securityService.GetSecurityPolicies().MaxLoginAttempts
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < maxAttempts-1; i++ {
+			service.Login(ctx, LoginRequest{Username: "alice", Password: "wrong"}, "10.0.0.9", "test")
+		}
+		if _, err := service.Login(ctx, LoginRequest{Username: "alice", Password: "correct-horse"}, "10.0.0.9", "test"); err != nil {
+			t.Fatalf("round %d: expected login below the limit to succeed: %v", round+1, err)
+		}
+	}
+}
+
+func TestChangePasswordClearsMustChangePassword(t *testing.T) {
+	service := newRefreshTestService(t)
+	security := &SecurityService{securityPolicies: &SecurityPolicies{PasswordMinLength: 8, PasswordComplexity: true}}
+	service.SetPasswordValidator(security.ValidatePassword)
+	ctx := context.Background()
+
+	if err := service.db.Model(&models.User{}).Where("username = ?", "alice").Update("must_change_password", true).Error; err != nil {
+		t.Fatalf("failed to flag user: %v", err)
+	}
+
+	resp := loginForRefresh(t, service)
+	if !resp.MustChangePassword {
+		t.Fatal("expected login to report that the password must be changed")
+	}
+	userID := resp.User.ID
+
+	if err := service.ChangePassword(ctx, userID, "correct-horse", "weak", &userID); !errors.Is(err, ErrPasswordPolicy) {
+		t.Errorf("expected ErrPasswordPolicy for a weak password, got %v", err)
+	}
+	if err := service.ChangePassword(ctx, userID, "correct-horse", "N3w-Passw0rd!", &userID); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+
+	user, err := service.GetUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUser failed: %v", err)
+	}
+	if user.MustChangePassword {
+		t.Error("expected changing the password to clear the flag")
+	}
+	resp, err = service.Login(ctx, LoginRequest{Username: "alice", Password: "N3w-Passw0rd!"}, "10.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("expected the new password to work: %v", err)
+	}
+	if resp.MustChangePassword {
+		t.Error("expected no password change to be required after the change")
+	}
+}
+
+func TestInitializeDefaultAdminMustChangePassword(t *testing.T) {
+	db := newImportTestDB(t)
+	service := NewAuthService(db, &types.Config{}, NewAuditService(db))
+
+	if err := service.InitializeDefaultAdmin(); err != nil {
+		t.Fatalf("InitializeDefaultAdmin failed: %v", err)
+	}
+
+	var admin models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("failed to load admin: %v", err)
+	}
+	if !admin.MustChangePassword {
+		t.Error("expected the seeded admin to be forced to change its default password")
+	}
+}
+
+func TestRegisterCreatesUserRoleAccounts(t *testing.T) {
+	service := newRefreshTestService(t)
+	ctx := context.Background()
+	req := RegisterRequest{Username: "bob", Email: "bob@example.com", Password: "N3w-Passw0rd!"}
+
+	if _, err := service.Register(ctx, req, "10.0.0.1", "test"); !errors.Is(err, ErrRegistrationDisabled) {
+		t.Fatalf("expected ErrRegistrationDisabled by default, got %v", err)
+	}
+
+	service.config.Auth.AllowRegistration = true
+	user, err := service.Register(ctx, req, "10.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if user.Role != models.UserRoleUser {
+		t.Errorf("expected a registered account to get the user role, got %s", user.Role)
+	}
+	if _, err := service.Login(ctx, LoginRequest{Username: "bob", Password: "N3w-Passw0rd!"}, "10.0.0.1", "test"); err != nil {
+		t.Errorf("expected the registered account to log in: %v", err)
+	}
+
+	if _, err := service.Register(ctx, RegisterRequest{Username: "alice", Email: "other@example.com", Password: "N3w-Passw0rd!"}, "10.0.0.1", "test"); !errors.Is(err, ErrUserExists) {
+		t.Errorf("expected ErrUserExists for a taken username, got %v", err)
+	}
+}
@@ -1,12 +1,22 @@
 package services
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,10 +25,25 @@ import (
 	"gorm.io/gorm"
 )
 
+var (
+	ErrBackupNotFound         = errors.New("backup not found")
+	ErrBackupChecksumMismatch = errors.New("backup checksum mismatch")
+)
+
 type BackupService struct {
 	db           *gorm.DB
 	config       *types.Config
 	auditService *AuditService
+	store        BackupStore                         // nil when backups stay on local disk
+	runCommand   func(cmd *exec.Cmd) ([]byte, error) // runs pg_dump and psql
+
+	// Scheduled backups; see backup_schedule.go
+	scheduleMutex sync.Mutex
+	schedules     map[uuid.UUID]chan struct{} // stop channels of running schedules
+	schedulerCtx  context.Context
+	scheduleWG    sync.WaitGroup // running schedule goroutines
+	syncInterval  time.Duration  // how often schedules are reloaded from the database
+	isLeader      func() bool
 }
 
 type BackupInfo struct {
@@ -26,8 +51,11 @@ type BackupInfo struct {
 	Name        string    `json:"name" gorm:"not null"`
 	Type        string    `json:"type" gorm:"not null"` // full, incremental, differential
 	Status      string    `json:"status" gorm:"not null"` // running, completed, failed
-	FilePath    string    `json:"file_path" gorm:"not null"`
+	FilePath    string    `json:"file_path" gorm:"not null"` // local path, or object key for remote storage
+	Storage     string    `json:"storage" gorm:"not null;default:local"` // local, s3
+	Encrypted   bool      `json:"encrypted" gorm:"not null;default:false"`
 	FileSize    int64     `json:"file_size"`
+	Checksum    string    `json:"checksum"` // SHA-256 of the stored file, hex encoded
 	StartTime   time.Time `json:"start_time" gorm:"not null"`
 	EndTime     *time.Time `json:"end_time"`
 	CreatedBy   uuid.UUID `json:"created_by" gorm:"type:uuid;not null"`
@@ -43,6 +71,7 @@ type BackupOptions struct {
 	BackupPath    string            `json:"backup_path"`
 	Description   string            `json:"description"`
 	RetentionDays int               `json:"retention_days"`
+	Encrypt       bool              `json:"encrypt"` // AES-256-GCM with BACKUP_ENCRYPTION_KEY
 	Tables        []string          `json:"tables"` // specific tables to backup
 	Metadata      map[string]string `json:"metadata"`
 }
@@ -64,6 +93,19 @@ type RestoreResult struct {
 	Errors        []string  `json:"errors"`
 	Duration      time.Duration `json:"duration"`
 	RestoreTime   time.Time `json:"restore_time"`
+	JobID         uuid.UUID `json:"job_id"`
+}
+
+// BackupVerification compares a stored backup file against the checksum and
+// size recorded when it was created.
+type BackupVerification struct {
+	BackupID         uuid.UUID `json:"backup_id"`
+	Match            bool      `json:"match"`
+	ExpectedChecksum string    `json:"expected_checksum"`
+	ActualChecksum   string    `json:"actual_checksum"`
+	ExpectedSize     int64     `json:"expected_size"`
+	ActualSize       int64     `json:"actual_size"`
+	VerifiedAt       time.Time `json:"verified_at"`
 }
 
 func NewBackupService(db *gorm.DB, config *types.Config, auditService *AuditService) *BackupService {
@@ -71,15 +113,58 @@ func NewBackupService(db *gorm.DB, config *types.Config, auditService *AuditServ
 		db:           db,
 		config:       config,
 		auditService: auditService,
+		runCommand:   (*exec.Cmd).CombinedOutput,
+		schedules:    make(map[uuid.UUID]chan struct{}),
+		schedulerCtx: context.Background(),
+		syncInterval: time.Minute,
 	}
 }
 
+// SetStore makes the service upload completed backups to store and remove
+// the local copy.
+func (s *BackupService) SetStore(store BackupStore) {
+	s.store = store
+}
+
+// RegisterApprovalActions lets restore and delete requests run once a
+// second admin approves them.
+func (s *BackupService) RegisterApprovalActions(approvals *ApprovalService) {
+	approvals.RegisterAction(ApprovalActionRestoreBackup, func(ctx context.Context, request *ApprovalRequest) (interface{}, error) {
+		var options RestoreOptions
+		if err := json.Unmarshal([]byte(request.Payload), &options); err != nil {
+			return nil, fmt.Errorf("invalid restore request: %w", err)
+		}
+		return s.RestoreBackup(ctx, options, request.RequestedBy)
+	})
+
+	approvals.RegisterAction(ApprovalActionDeleteBackup, func(ctx context.Context, request *ApprovalRequest) (interface{}, error) {
+		var payload struct {
+			BackupID uuid.UUID `json:"backup_id"`
+		}
+		if err := json.Unmarshal([]byte(request.Payload), &payload); err != nil {
+			return nil, fmt.Errorf("invalid delete request: %w", err)
+		}
+		return nil, s.DeleteBackup(ctx, payload.BackupID, request.RequestedBy)
+	})
+}
+
 func (s *BackupService) CreateBackup(ctx context.Context, options BackupOptions, createdBy uuid.UUID) (*BackupInfo, error) {
+	// Refuse up front rather than leaving a plaintext dump behind
+	var encryptionKey []byte
+	if options.Encrypt {
+		key, err := s.backupEncryptionKey()
+		if err != nil {
+			return nil, err
+		}
+		encryptionKey = key
+	}
+
 	// Create backup record
 	backup := &BackupInfo{
 		Name:        fmt.Sprintf("backup_%s_%s", options.BackupType, time.Now().Format("20060102_150405")),
 		Type:        options.BackupType,
 		Status:      "running",
+		Storage:     BackupStorageLocal,
 		StartTime:   time.Now(),
 		CreatedBy:   createdBy,
 		Description: options.Description,
@@ -89,6 +174,9 @@ func (s *BackupService) CreateBackup(ctx context.Context, options BackupOptions,
 		return nil, fmt.Errorf("failed to create backup record: %w", err)
 	}
 
+	// The backup record's ID doubles as the job ID for its audit entries
+	ctx = WithJobID(ctx, backup.ID)
+
 	// Create backup directory if it doesn't exist
 	backupDir := options.BackupPath
 	if backupDir == "" {
@@ -127,9 +215,42 @@ func (s *BackupService) CreateBackup(ctx context.Context, options BackupOptions,
 		return nil, err
 	}
 
-	// Get file size
-	if stat, err := os.Stat(backup.FilePath); err == nil {
-		backup.FileSize = stat.Size()
+	if options.Encrypt {
+		encryptedPath, err := encryptBackupFile(backup.FilePath, encryptionKey)
+		if err != nil {
+			os.Remove(backup.FilePath)
+			s.updateBackupStatus(backup.ID, "failed", err.Error())
+			return nil, err
+		}
+		backup.FilePath = encryptedPath
+		backup.Encrypted = true
+	}
+
+	// Record size and checksum of the file as stored
+	checksum, size, err := hashBackupFile(backup.FilePath)
+	if err != nil {
+		s.updateBackupStatus(backup.ID, "failed", err.Error())
+		return nil, err
+	}
+	backup.Checksum = checksum
+	backup.FileSize = size
+
+	if s.store != nil {
+		if err := s.uploadBackup(ctx, backup); err != nil {
+			s.updateBackupStatus(backup.ID, "failed", err.Error())
+			return nil, err
+		}
+	}
+
+	if err := s.db.Model(&BackupInfo{}).Where("id = ?", backup.ID).Updates(map[string]interface{}{
+		"file_path": backup.FilePath,
+		"file_size": backup.FileSize,
+		"checksum":  backup.Checksum,
+		"storage":   backup.Storage,
+		"encrypted": backup.Encrypted,
+	}).Error; err != nil {
+		s.updateBackupStatus(backup.ID, "failed", err.Error())
+		return nil, fmt.Errorf("failed to update backup record: %w", err)
 	}
 
 	// Update backup status
@@ -138,16 +259,24 @@ func (s *BackupService) CreateBackup(ctx context.Context, options BackupOptions,
 	s.updateBackupStatus(backup.ID, "completed", "")
 
 	// Log backup action
-	s.auditService.LogAction(ctx, createdBy, "create_backup", "backup", backup.ID,
+	s.auditService.LogActionWithMetadata(ctx, &createdBy, models.AuditActionCreate, "backup", &backup.ID,
+		fmt.Sprintf("Created %s backup %s", options.BackupType, backup.Name), "", "",
 		map[string]interface{}{
 			"backup_type": options.BackupType,
 			"file_path":   backup.FilePath,
 			"file_size":   backup.FileSize,
+			"checksum":    backup.Checksum,
+			"storage":     backup.Storage,
+			"encrypted":   backup.Encrypted,
 			"duration":    endTime.Sub(backup.StartTime).String(),
 		})
 
-	// Clean up old backups
-	go s.cleanupOldBackups(options.RetentionDays)
+	// Clean up old backups; only the HA leader prunes, so controllers
+	// sharing storage don't race deleting the same files
+	go RunIfLeader(context.Background(), s.leaderCheck(), "backup cleanup", func(context.Context) error {
+		s.cleanupOldBackups(options.RetentionDays)
+		return nil
+	})
 
 	return backup, nil
 }
@@ -181,7 +310,7 @@ func (s *BackupService) performFullBackup(ctx context.Context, backup *BackupInf
 	}
 
 	// Execute backup
-	output, err := cmd.CombinedOutput()
+	output, err := s.runCommand(cmd)
 	if err != nil {
 		return fmt.Errorf("pg_dump failed: %w, output: %s", err, string(output))
 	}
@@ -207,7 +336,7 @@ func (s *BackupService) performSchemaBackup(ctx context.Context, backup *BackupI
 	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", s.config.Database.Password))
 
 	// Execute backup
-	output, err := cmd.CombinedOutput()
+	output, err := s.runCommand(cmd)
 	if err != nil {
 		return fmt.Errorf("pg_dump (schema) failed: %w, output: %s", err, string(output))
 	}
@@ -269,7 +398,9 @@ func (s *BackupService) RestoreBackup(ctx context.Context, options RestoreOption
 	result := &RestoreResult{
 		RestoreTime: startTime,
 		Errors:      []string{},
+		JobID:       uuid.New(),
 	}
+	ctx = WithJobID(ctx, result.JobID)
 
 	// Get backup info
 	var backup BackupInfo
@@ -277,13 +408,52 @@ func (s *BackupService) RestoreBackup(ctx context.Context, options RestoreOption
 		return nil, fmt.Errorf("backup not found: %w", err)
 	}
 
-	// Check if backup file exists
-	if _, err := os.Stat(backup.FilePath); os.IsNotExist(err) {
+	if backup.Storage == BackupStorageS3 {
+		// Restore from a local copy of the object
+		localPath, err := s.downloadBackup(ctx, backup)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(localPath)
+		backup.FilePath = localPath
+	} else if _, err := os.Stat(backup.FilePath); os.IsNotExist(err) {
+		// Check if backup file exists
 		return nil, fmt.Errorf("backup file not found: %s", backup.FilePath)
 	}
 
+	// Refuse to feed a truncated or altered file to psql. Backups created
+	// before checksums were recorded are restored unchecked.
+	if backup.Checksum != "" {
+		checksum, _, err := hashBackupFile(backup.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		if checksum != backup.Checksum {
+			return nil, fmt.Errorf("%w: backup %s expected %s, got %s",
+				ErrBackupChecksumMismatch, backup.Name, backup.Checksum, checksum)
+		}
+	}
+
+	// Encrypted backups are recognised by their header, not the record, so
+	// files copied in from elsewhere are handled too
+	encrypted, err := isEncryptedBackup(backup.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted {
+		key, err := s.backupEncryptionKey()
+		if err != nil {
+			return nil, fmt.Errorf("cannot restore encrypted backup %s: %w", backup.Name, err)
+		}
+		plainPath, err := decryptBackupFile(backup.FilePath, key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot restore encrypted backup %s: %w", backup.Name, err)
+		}
+		defer os.Remove(plainPath)
+		backup.FilePath = plainPath
+	}
+
 	// Perform restore based on type
-	var err error
 	switch options.RestoreType {
 	case "full":
 		err = s.performFullRestore(ctx, backup, options, result)
@@ -297,7 +467,8 @@ func (s *BackupService) RestoreBackup(ctx context.Context, options RestoreOption
 	result.Success = err == nil
 
 	// Log restore action
-	s.auditService.LogAction(ctx, restoredBy, "restore_backup", "backup", backup.ID,
+	s.auditService.LogActionWithMetadata(ctx, &restoredBy, models.AuditActionUpdate, "backup", &backup.ID,
+		fmt.Sprintf("Restored backup %s", backup.Name), "", "",
 		map[string]interface{}{
 			"restore_type":     options.RestoreType,
 			"success":          result.Success,
@@ -317,31 +488,99 @@ func (s *BackupService) performFullRestore(ctx context.Context, backup BackupInf
 		"-U", s.config.Database.User,
 		"-d", s.config.Database.Name,
 		"-f", backup.FilePath,
-		"--verbose",
 	)
 
 	// Set password via environment variable
 	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", s.config.Database.Password))
 
-	// Add options
-	if options.IgnoreErrors {
-		cmd.Args = append(cmd.Args, "--on-error-continue")
+	// psql carries on past failed statements unless told otherwise
+	if !options.IgnoreErrors {
+		cmd.Args = append(cmd.Args, "-v", "ON_ERROR_STOP=1")
 	}
 
 	// Execute restore
-	output, err := cmd.CombinedOutput()
+	output, err := s.runCommand(cmd)
+	result.Errors = append(result.Errors, psqlMessages(output)...)
 	if err != nil {
-		result.Errors = append(result.Errors, fmt.Sprintf("psql failed: %v, output: %s", err, string(output)))
+		result.Errors = append(result.Errors, fmt.Sprintf("psql failed: %v", err))
 		return fmt.Errorf("restore failed: %w", err)
 	}
 
-	// Count restored records (simplified)
-	result.TablesRestored = 5 // Assuming 5 main tables
-	result.RecordsRestored = 1000 // This would need to be calculated properly
-
+	s.countRestoredRows(ctx, backup.FilePath, result)
 	return nil
 }
 
+// countRestoredRows fills in the restore statistics from the tables the dump
+// contains and how many rows each holds once psql has finished.
+func (s *BackupService) countRestoredRows(ctx context.Context, dumpPath string, result *RestoreResult) {
+	tables, err := dumpTables(dumpPath)
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("failed to read restored tables: %v", err))
+		return
+	}
+
+	for _, table := range tables {
+		var count int64
+		if err := s.db.WithContext(ctx).Table(table).Count(&count).Error; err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("failed to count rows in %s: %v", table, err))
+			continue
+		}
+		result.TablesRestored++
+		result.RecordsRestored += count
+	}
+}
+
+var dumpTablePattern = regexp.MustCompile(`^(?:CREATE TABLE (?:IF NOT EXISTS )?|COPY |INSERT INTO )(?:"?public"?\.)?"?([A-Za-z_][A-Za-z0-9_]*)"?[\s(;]`)
+
+// dumpTables lists, in order of first appearance, the tables a plain SQL
+// dump creates or loads data into.
+func dumpTables(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dump: %w", err)
+	}
+	defer file.Close()
+
+	var tables []string
+	seen := make(map[string]bool)
+	inCopy := false
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if inCopy {
+			// COPY data runs until a line holding only \.
+			inCopy = strings.TrimRight(line, "\r\n") != `\.`
+		} else if match := dumpTablePattern.FindStringSubmatch(line); match != nil {
+			if !seen[match[1]] {
+				seen[match[1]] = true
+				tables = append(tables, match[1])
+			}
+			inCopy = strings.HasPrefix(line, "COPY ") && strings.Contains(line, "FROM stdin")
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dump: %w", err)
+		}
+	}
+
+	return tables, nil
+}
+
+// psqlMessages extracts the ERROR, WARNING and NOTICE lines psql printed.
+func psqlMessages(output []byte) []string {
+	var messages []string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.Contains(line, "ERROR:") || strings.Contains(line, "WARNING:") || strings.Contains(line, "NOTICE:") {
+			messages = append(messages, line)
+		}
+	}
+	return messages
+}
+
 func (s *BackupService) performSelectiveRestore(ctx context.Context, backup BackupInfo, options RestoreOptions, result *RestoreResult) error {
 	// For selective restore, we'd need to parse the SQL file and extract specific tables
 	// This is a simplified implementation
@@ -469,6 +708,74 @@ func (s *BackupService) GetBackup(ctx context.Context, id uuid.UUID) (*BackupInf
 	return &backup, nil
 }
 
+// VerifyBackup recomputes the checksum and size of a stored backup file,
+// locally or in the remote store, and compares them with the record.
+func (s *BackupService) VerifyBackup(ctx context.Context, id uuid.UUID) (*BackupVerification, error) {
+	var backup BackupInfo
+	if err := s.db.Where("id = ?", id).First(&backup).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBackupNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch backup: %w", err)
+	}
+
+	var checksum string
+	var size int64
+	if backup.Storage == BackupStorageS3 {
+		if s.store == nil {
+			return nil, ErrBackupStoreNotConfigured
+		}
+		hasher := sha256.New()
+		counter := &countingWriter{}
+		if err := s.store.Download(ctx, backup.FilePath, io.MultiWriter(hasher, counter)); err != nil {
+			return nil, fmt.Errorf("failed to download backup: %w", err)
+		}
+		checksum = hex.EncodeToString(hasher.Sum(nil))
+		size = counter.n
+	} else {
+		var err error
+		checksum, size, err = hashBackupFile(backup.FilePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &BackupVerification{
+		BackupID:         backup.ID,
+		Match:            backup.Checksum != "" && checksum == backup.Checksum && size == backup.FileSize,
+		ExpectedChecksum: backup.Checksum,
+		ActualChecksum:   checksum,
+		ExpectedSize:     backup.FileSize,
+		ActualSize:       size,
+		VerifiedAt:       time.Now(),
+	}, nil
+}
+
+// hashBackupFile returns the hex SHA-256 and size of the file at path.
+func hashBackupFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read backup file: %w", err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
 func (s *BackupService) DeleteBackup(ctx context.Context, id uuid.UUID, deletedBy uuid.UUID) error {
 	// Get backup info
 	var backup BackupInfo
@@ -477,8 +784,8 @@ func (s *BackupService) DeleteBackup(ctx context.Context, id uuid.UUID, deletedB
 	}
 
 	// Delete backup file
-	if err := os.Remove(backup.FilePath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to delete backup file: %w", err)
+	if err := s.removeBackupFile(ctx, backup); err != nil {
+		return err
 	}
 
 	// Delete database record
@@ -487,15 +794,89 @@ func (s *BackupService) DeleteBackup(ctx context.Context, id uuid.UUID, deletedB
 	}
 
 	// Log deletion
-	s.auditService.LogAction(ctx, deletedBy, "delete_backup", "backup", id,
+	s.auditService.LogActionWithMetadata(ctx, &deletedBy, models.AuditActionDelete, "backup", &id,
+		fmt.Sprintf("Deleted backup %s", backup.Name), "", "",
 		map[string]interface{}{
 			"backup_name": backup.Name,
 			"file_path":   backup.FilePath,
+			"storage":     backup.Storage,
 		})
 
 	return nil
 }
 
+// uploadBackup moves a freshly written backup file to the remote store and
+// points the backup record at the object key.
+func (s *BackupService) uploadBackup(ctx context.Context, backup *BackupInfo) error {
+	file, err := os.Open(backup.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat backup file: %w", err)
+	}
+
+	key := s.config.Backup.S3.Prefix + filepath.Base(backup.FilePath)
+	if err := s.store.Upload(ctx, key, file, stat.Size()); err != nil {
+		return fmt.Errorf("failed to upload backup: %w", err)
+	}
+
+	file.Close()
+	if err := os.Remove(backup.FilePath); err != nil {
+		slog.WarnContext(ctx, "Failed to remove local copy of uploaded backup", "path", backup.FilePath, "error", err)
+	}
+
+	backup.FilePath = key
+	backup.Storage = BackupStorageS3
+	return nil
+}
+
+// downloadBackup fetches a remote backup into a temporary file and returns
+// its path. The caller removes the file when done.
+func (s *BackupService) downloadBackup(ctx context.Context, backup BackupInfo) (string, error) {
+	if s.store == nil {
+		return "", ErrBackupStoreNotConfigured
+	}
+
+	file, err := os.CreateTemp("", "wg_sdwan_restore_*"+filepath.Ext(backup.FilePath))
+	if err != nil {
+		return "", fmt.Errorf("failed to create restore file: %w", err)
+	}
+	defer file.Close()
+
+	if err := s.store.Download(ctx, backup.FilePath, file); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to download backup: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write restore file: %w", err)
+	}
+
+	return file.Name(), nil
+}
+
+func (s *BackupService) removeBackupFile(ctx context.Context, backup BackupInfo) error {
+	if backup.Storage == BackupStorageS3 {
+		if s.store == nil {
+			return ErrBackupStoreNotConfigured
+		}
+		if err := s.store.Delete(ctx, backup.FilePath); err != nil {
+			return fmt.Errorf("failed to delete backup object: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.Remove(backup.FilePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete backup file: %w", err)
+	}
+	return nil
+}
+
 func (s *BackupService) updateBackupStatus(id uuid.UUID, status, errorLog string) {
 	updates := map[string]interface{}{
 		"status": status,
@@ -524,29 +905,17 @@ func (s *BackupService) cleanupOldBackups(retentionDays int) {
 	s.db.Where("created_at < ? AND status = ?", cutoffTime, "completed").Find(&oldBackups)
 	
 	for _, backup := range oldBackups {
-		// Delete file
-		os.Remove(backup.FilePath)
+		// Delete file, keeping the record if the file could not be removed
+		if err := s.removeBackupFile(context.Background(), backup); err != nil {
+			slog.Warn("Failed to remove expired backup", "backup", backup.Name, "error", err)
+			continue
+		}
 		
 		// Delete database record
 		s.db.Delete(&backup)
 	}
 }
 
-func (s *BackupService) ScheduleBackup(ctx context.Context, schedule string, options BackupOptions, createdBy uuid.UUID) error {
-	// This would integrate with a job scheduler like cron
-	// For now, we'll just log the schedule request
-	
-	s.auditService.LogAction(ctx, createdBy, "schedule_backup", "backup", uuid.Nil,
-		map[string]interface{}{
-			"schedule":     schedule,
-			"backup_type":  options.BackupType,
-			"compression":  options.Compression,
-			"retention":    options.RetentionDays,
-		})
-	
-	return nil
-}
-
 func (s *BackupService) GetBackupStats(ctx context.Context) (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
 	
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	ErrBackupKeyMissing  = errors.New("backup encryption key is not configured")
+	ErrBackupKeyInvalid  = errors.New("backup encryption key must be 32 bytes, base64 encoded")
+	ErrBackupKeyMismatch = errors.New("backup was encrypted with a different key")
+	ErrBackupCorrupted   = errors.New("encrypted backup is corrupted")
+)
+
+// Encrypted backups start with this magic, followed by a key ID, the GCM
+// nonce and the sealed dump. The magic and key ID are authenticated as
+// additional data.
+var encryptedBackupMagic = []byte("WGSDENC1")
+
+const (
+	backupKeyIDSize    = 8
+	backupHeaderSize   = 8 + backupKeyIDSize
+	encryptedBackupExt = ".enc"
+)
+
+// backupEncryptionKey decodes the configured AES-256 key.
+func (s *BackupService) backupEncryptionKey() ([]byte, error) {
+	encoded := s.config.Backup.EncryptionKey
+	if encoded == "" {
+		return nil, ErrBackupKeyMissing
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, ErrBackupKeyInvalid
+	}
+	return key, nil
+}
+
+// backupKeyID identifies a key without revealing it, so a restore can tell
+// a wrong key apart from a damaged file.
+func backupKeyID(key []byte) []byte {
+	sum := sha256.Sum256(append([]byte("wg-sdwan-backup-key:"), key...))
+	return sum[:backupKeyIDSize]
+}
+
+// encryptBackupFile seals the file at path with AES-256-GCM into path+".enc"
+// and removes the plaintext. It returns the new path.
+func encryptBackupFile(path string, key []byte) (string, error) {
+	plaintext, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup for encryption: %w", err)
+	}
+
+	gcm, err := newBackupGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	header := append(append([]byte{}, encryptedBackupMagic...), backupKeyID(key)...)
+	out := make([]byte, 0, len(header)+len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, header...)
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, plaintext, header)
+
+	encryptedPath := path + encryptedBackupExt
+	if err := os.WriteFile(encryptedPath, out, 0600); err != nil {
+		return "", fmt.Errorf("failed to write encrypted backup: %w", err)
+	}
+	if err := os.Remove(path); err != nil {
+		os.Remove(encryptedPath)
+		return "", fmt.Errorf("failed to remove unencrypted backup: %w", err)
+	}
+
+	return encryptedPath, nil
+}
+
+// isEncryptedBackup reports whether the file at path carries the encrypted
+// backup header.
+func isEncryptedBackup(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(encryptedBackupMagic))
+	if _, err := io.ReadFull(file, magic); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read backup file: %w", err)
+	}
+	return bytes.Equal(magic, encryptedBackupMagic), nil
+}
+
+// decryptBackupFile decrypts an encrypted backup into a temporary file and
+// returns its path. The caller removes the file when done.
+func decryptBackupFile(path string, key []byte) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read encrypted backup: %w", err)
+	}
+
+	gcm, err := newBackupGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) < backupHeaderSize+gcm.NonceSize()+gcm.Overhead() {
+		return "", ErrBackupCorrupted
+	}
+	header := data[:backupHeaderSize]
+	if !bytes.Equal(header[len(encryptedBackupMagic):], backupKeyID(key)) {
+		return "", ErrBackupKeyMismatch
+	}
+
+	nonce := data[backupHeaderSize : backupHeaderSize+gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, data[backupHeaderSize+gcm.NonceSize():], header)
+	if err != nil {
+		return "", ErrBackupCorrupted
+	}
+
+	file, err := os.CreateTemp("", "wg_sdwan_restore_*.sql")
+	if err != nil {
+		return "", fmt.Errorf("failed to create restore file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(plaintext); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write decrypted backup: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write decrypted backup: %w", err)
+	}
+
+	return file.Name(), nil
+}
+
+func newBackupGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
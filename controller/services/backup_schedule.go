@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidBackupSchedule  = errors.New("invalid backup schedule")
+	ErrBackupScheduleNotFound = errors.New("backup schedule not found")
+)
+
+// BackupSchedule is a persisted cron schedule that runs CreateBackup with
+// the stored options.
+type BackupSchedule struct {
+	ID        uuid.UUID     `json:"id" gorm:"type:uuid;primary_key"`
+	Schedule  string        `json:"schedule" gorm:"not null"`
+	Options   BackupOptions `json:"options" gorm:"type:text;serializer:json"`
+	CreatedBy uuid.UUID     `json:"created_by" gorm:"type:uuid;not null"`
+	NextRunAt *time.Time    `json:"next_run_at"`
+	LastRunAt *time.Time    `json:"last_run_at"`
+	LastError string        `json:"last_error,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+func (BackupSchedule) TableName() string {
+	return "backup_schedules"
+}
+
+// SetLeaderCheck makes scheduled backups and pruning of old backups run only
+// while isLeader returns true, so an HA cluster does each once.
+func (s *BackupService) SetLeaderCheck(isLeader func() bool) {
+	s.scheduleMutex.Lock()
+	defer s.scheduleMutex.Unlock()
+	s.isLeader = isLeader
+}
+
+func (s *BackupService) leaderCheck() func() bool {
+	s.scheduleMutex.Lock()
+	defer s.scheduleMutex.Unlock()
+	return s.isLeader
+}
+
+// StartScheduler starts every persisted schedule and keeps following the
+// table, so schedules added or deleted through another controller sharing
+// the database are picked up or stopped here too. Schedules stop when ctx
+// is cancelled.
+func (s *BackupService) StartScheduler(ctx context.Context) error {
+	s.scheduleMutex.Lock()
+	s.schedulerCtx = ctx
+	s.scheduleMutex.Unlock()
+
+	if err := s.syncSchedules(ctx); err != nil {
+		return err
+	}
+
+	s.scheduleWG.Add(1)
+	go func() {
+		defer s.scheduleWG.Done()
+		ticker := time.NewTicker(s.syncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.syncSchedules(ctx); err != nil {
+					slog.ErrorContext(ctx, "Failed to sync backup schedules", "error", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// syncSchedules starts the persisted schedules that aren't running yet and
+// stops those whose rows are gone. The table is read under scheduleMutex so
+// a schedule saved meanwhile by ScheduleBackup is never stopped as missing.
+func (s *BackupService) syncSchedules(ctx context.Context) error {
+	s.scheduleMutex.Lock()
+	defer s.scheduleMutex.Unlock()
+
+	var schedules []BackupSchedule
+	if err := s.db.WithContext(ctx).Find(&schedules).Error; err != nil {
+		return fmt.Errorf("failed to load backup schedules: %w", err)
+	}
+
+	present := make(map[uuid.UUID]bool, len(schedules))
+	for _, schedule := range schedules {
+		present[schedule.ID] = true
+		if _, running := s.schedules[schedule.ID]; running {
+			continue
+		}
+		cron, err := parseCronSchedule(schedule.Schedule)
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping backup schedule", "schedule_id", schedule.ID, "error", err)
+			continue
+		}
+		s.startScheduleLocked(schedule, cron)
+	}
+
+	for id, stop := range s.schedules {
+		if !present[id] {
+			close(stop)
+			delete(s.schedules, id)
+		}
+	}
+
+	return nil
+}
+
+// ScheduleBackup stores a cron schedule for CreateBackup and starts running
+// it.
+func (s *BackupService) ScheduleBackup(ctx context.Context, schedule string, options BackupOptions, createdBy uuid.UUID) (*BackupSchedule, error) {
+	cron, err := parseCronSchedule(schedule)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackupSchedule, err)
+	}
+
+	if options.BackupType == "" {
+		options.BackupType = "full"
+	}
+	if options.Encrypt {
+		if _, err := s.backupEncryptionKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	record := &BackupSchedule{
+		ID:        uuid.New(),
+		Schedule:  schedule,
+		Options:   options,
+		CreatedBy: createdBy,
+	}
+	if next := cron.Next(time.Now()); !next.IsZero() {
+		record.NextRunAt = &next
+	}
+
+	if err := s.db.WithContext(ctx).Create(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to save backup schedule: %w", err)
+	}
+
+	s.startSchedule(*record, cron)
+
+	s.auditService.LogActionWithMetadata(ctx, &createdBy, models.AuditActionCreate, "backup_schedule", &record.ID,
+		fmt.Sprintf("Scheduled %s backup %q", options.BackupType, schedule), "", "",
+		map[string]interface{}{
+			"schedule":    schedule,
+			"backup_type": options.BackupType,
+			"compression": options.Compression,
+			"retention":   options.RetentionDays,
+			"encrypt":     options.Encrypt,
+		})
+
+	return record, nil
+}
+
+func (s *BackupService) GetSchedules(ctx context.Context) ([]BackupSchedule, error) {
+	var schedules []BackupSchedule
+	if err := s.db.WithContext(ctx).Order("created_at").Find(&schedules).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch backup schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+// DeleteSchedule stops a schedule and removes it. A backup already running
+// for it is allowed to finish.
+func (s *BackupService) DeleteSchedule(ctx context.Context, id uuid.UUID, deletedBy uuid.UUID) error {
+	var schedule BackupSchedule
+	if err := s.db.WithContext(ctx).Where("id = ?", id).First(&schedule).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrBackupScheduleNotFound
+		}
+		return fmt.Errorf("failed to fetch backup schedule: %w", err)
+	}
+
+	s.stopSchedule(id)
+
+	if err := s.db.WithContext(ctx).Delete(&schedule).Error; err != nil {
+		return fmt.Errorf("failed to delete backup schedule: %w", err)
+	}
+
+	s.auditService.LogActionWithMetadata(ctx, &deletedBy, models.AuditActionDelete, "backup_schedule", &id,
+		fmt.Sprintf("Deleted backup schedule %q", schedule.Schedule), "", "",
+		map[string]interface{}{
+			"schedule":    schedule.Schedule,
+			"backup_type": schedule.Options.BackupType,
+		})
+
+	return nil
+}
+
+func (s *BackupService) startSchedule(schedule BackupSchedule, cron cronSchedule) {
+	s.scheduleMutex.Lock()
+	defer s.scheduleMutex.Unlock()
+	s.startScheduleLocked(schedule, cron)
+}
+
+func (s *BackupService) startScheduleLocked(schedule BackupSchedule, cron cronSchedule) {
+	if _, running := s.schedules[schedule.ID]; running {
+		return
+	}
+	stop := make(chan struct{})
+	s.schedules[schedule.ID] = stop
+
+	s.scheduleWG.Add(1)
+	go func() {
+		defer s.scheduleWG.Done()
+		s.runSchedule(s.schedulerCtx, schedule, cron, stop)
+	}()
+}
+
+func (s *BackupService) stopSchedule(id uuid.UUID) {
+	s.scheduleMutex.Lock()
+	defer s.scheduleMutex.Unlock()
+
+	if stop, ok := s.schedules[id]; ok {
+		close(stop)
+		delete(s.schedules, id)
+	}
+}
+
+// WaitForSchedules waits for every schedule's goroutine to return once the
+// scheduler's context has been cancelled.
+func (s *BackupService) WaitForSchedules() {
+	s.scheduleWG.Wait()
+}
+
+func (s *BackupService) runSchedule(ctx context.Context, schedule BackupSchedule, cron cronSchedule, stop <-chan struct{}) {
+	for {
+		next := cron.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		s.db.Model(&BackupSchedule{}).Where("id = ?", schedule.ID).Update("next_run_at", next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// Followers skip the run; the leader takes it
+		RunIfLeader(ctx, s.leaderCheck(), "scheduled backup", func(ctx context.Context) error {
+			s.runScheduledBackup(ctx, schedule)
+			return nil
+		})
+	}
+}
+
+// runScheduledBackup runs the schedule as it is stored now. A schedule
+// deleted through another controller since the last sync is stopped
+// instead.
+func (s *BackupService) runScheduledBackup(ctx context.Context, schedule BackupSchedule) {
+	if err := s.db.WithContext(ctx).Where("id = ?", schedule.ID).First(&schedule).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			slog.InfoContext(ctx, "Backup schedule was deleted, stopping it", "schedule_id", schedule.ID)
+			s.stopSchedule(schedule.ID)
+			return
+		}
+		slog.ErrorContext(ctx, "Failed to load backup schedule", "schedule_id", schedule.ID, "error", err)
+		return
+	}
+
+	_, err := s.CreateBackup(ctx, schedule.Options, schedule.CreatedBy)
+
+	updates := map[string]interface{}{
+		"last_run_at": time.Now(),
+		"last_error":  "",
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Scheduled backup failed", "schedule_id", schedule.ID, "error", err)
+		updates["last_error"] = err.Error()
+	}
+	s.db.Model(&BackupSchedule{}).Where("id = ?", schedule.ID).Updates(updates)
+}
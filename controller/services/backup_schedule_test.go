@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newScheduleTestService returns a backup service whose pg_dump runs are
+// counted. Scheduled runs stop when the test ends.
+func newScheduleTestService(t *testing.T) (*BackupService, *int32) {
+	t.Helper()
+
+	service, db := newBackupTestService(t, nil)
+	if err := db.AutoMigrate(&BackupSchedule{}); err != nil {
+		t.Fatalf("failed to migrate backup_schedules: %v", err)
+	}
+
+	var runs int32
+	service.runCommand = func(cmd *exec.Cmd) ([]byte, error) {
+		atomic.AddInt32(&runs, 1)
+		for i, arg := range cmd.Args {
+			if arg == "-f" && i+1 < len(cmd.Args) {
+				return nil, os.WriteFile(cmd.Args[i+1], []byte("-- dump\n"), 0600)
+			}
+		}
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := service.StartScheduler(ctx); err != nil {
+		t.Fatalf("StartScheduler failed: %v", err)
+	}
+	return service, &runs
+}
+
+func waitForRuns(t *testing.T, runs *int32, want int32) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(runs) < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected at least %d scheduled backups, got %d", want, atomic.LoadInt32(runs))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestScheduledBackupRunsAndStopsOnDelete(t *testing.T) {
+	service, runs := newScheduleTestService(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	schedule, err := service.ScheduleBackup(ctx, "@every 20ms", BackupOptions{BackupPath: t.TempDir()}, userID)
+	if err != nil {
+		t.Fatalf("ScheduleBackup failed: %v", err)
+	}
+	if schedule.Options.BackupType != "full" {
+		t.Errorf("expected backup type to default to full, got %q", schedule.Options.BackupType)
+	}
+
+	waitForRuns(t, runs, 2)
+
+	var backups int64
+	service.db.Model(&BackupInfo{}).Where("created_by = ?", userID).Count(&backups)
+	if backups < 2 {
+		t.Errorf("expected scheduled backups to be recorded for the schedule owner, got %d", backups)
+	}
+
+	if err := service.DeleteSchedule(ctx, schedule.ID, userID); err != nil {
+		t.Fatalf("DeleteSchedule failed: %v", err)
+	}
+
+	// A run already in progress may still finish
+	time.Sleep(50 * time.Millisecond)
+	stopped := atomic.LoadInt32(runs)
+	time.Sleep(100 * time.Millisecond)
+	if after := atomic.LoadInt32(runs); after != stopped {
+		t.Fatalf("expected no runs after delete, went from %d to %d", stopped, after)
+	}
+
+	schedules, err := service.GetSchedules(ctx)
+	if err != nil {
+		t.Fatalf("GetSchedules failed: %v", err)
+	}
+	if len(schedules) != 0 {
+		t.Errorf("expected schedule to be removed, got %d", len(schedules))
+	}
+
+	if err := service.DeleteSchedule(ctx, schedule.ID, userID); !errors.Is(err, ErrBackupScheduleNotFound) {
+		t.Errorf("expected ErrBackupScheduleNotFound, got %v", err)
+	}
+}
+
+func TestScheduledBackupSkippedOnFollower(t *testing.T) {
+	service, runs := newScheduleTestService(t)
+	service.SetLeaderCheck(func() bool { return false })
+
+	if _, err := service.ScheduleBackup(context.Background(), "@every 10ms", BackupOptions{BackupPath: t.TempDir()}, uuid.New()); err != nil {
+		t.Fatalf("ScheduleBackup failed: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(runs); n != 0 {
+		t.Fatalf("expected follower to skip scheduled backups, got %d runs", n)
+	}
+}
+
+func TestStartSchedulerResumesPersistedSchedules(t *testing.T) {
+	service, _ := newScheduleTestService(t)
+	if _, err := service.ScheduleBackup(context.Background(), "@every 20ms", BackupOptions{BackupPath: t.TempDir()}, uuid.New()); err != nil {
+		t.Fatalf("ScheduleBackup failed: %v", err)
+	}
+
+	// A restarted controller picks the schedule up from the table
+	restarted := NewBackupService(service.db, service.config, service.auditService)
+	var runs int32
+	restarted.runCommand = func(cmd *exec.Cmd) ([]byte, error) {
+		atomic.AddInt32(&runs, 1)
+		return nil, errors.New("pg_dump unavailable")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := restarted.StartScheduler(ctx); err != nil {
+		t.Fatalf("StartScheduler failed: %v", err)
+	}
+
+	waitForRuns(t, &runs, 1)
+}
+
+// newPeerScheduleTestService starts a second controller's backup service on
+// the database of service, reloading schedules every interval.
+func newPeerScheduleTestService(t *testing.T, service *BackupService, interval time.Duration) (*BackupService, *int32) {
+	t.Helper()
+
+	peer := NewBackupService(service.db, service.config, service.auditService)
+	peer.syncInterval = interval
+	var runs int32
+	peer.runCommand = func(cmd *exec.Cmd) ([]byte, error) {
+		atomic.AddInt32(&runs, 1)
+		return nil, errors.New("pg_dump unavailable")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := peer.StartScheduler(ctx); err != nil {
+		t.Fatalf("StartScheduler failed: %v", err)
+	}
+	return peer, &runs
+}
+
+func TestSchedulerFollowsSchedulesFromPeers(t *testing.T) {
+	service, _ := newScheduleTestService(t)
+	service.SetLeaderCheck(func() bool { return false })
+	ctx := context.Background()
+	userID := uuid.New()
+
+	// The leader was already running when the follower took the request
+	leader, runs := newPeerScheduleTestService(t, service, 10*time.Millisecond)
+
+	schedule, err := service.ScheduleBackup(ctx, "@every 20ms", BackupOptions{BackupPath: t.TempDir()}, userID)
+	if err != nil {
+		t.Fatalf("ScheduleBackup failed: %v", err)
+	}
+	waitForRuns(t, runs, 1)
+
+	if err := service.DeleteSchedule(ctx, schedule.ID, userID); err != nil {
+		t.Fatalf("DeleteSchedule failed: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		leader.scheduleMutex.Lock()
+		_, running := leader.schedules[schedule.ID]
+		leader.scheduleMutex.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the leader to stop a schedule deleted through the follower")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	stopped := atomic.LoadInt32(runs)
+	time.Sleep(100 * time.Millisecond)
+	if after := atomic.LoadInt32(runs); after != stopped {
+		t.Errorf("expected no runs after delete, went from %d to %d", stopped, after)
+	}
+}
+
+func TestScheduledBackupSkipsDeletedRow(t *testing.T) {
+	service, _ := newScheduleTestService(t)
+	service.SetLeaderCheck(func() bool { return false })
+
+	schedule, err := service.ScheduleBackup(context.Background(), "@every 20ms", BackupOptions{BackupPath: t.TempDir()}, uuid.New())
+	if err != nil {
+		t.Fatalf("ScheduleBackup failed: %v", err)
+	}
+
+	// The peer won't resync before its next run
+	peer, runs := newPeerScheduleTestService(t, service, time.Hour)
+	if err := service.db.Delete(&BackupSchedule{}, "id = ?", schedule.ID).Error; err != nil {
+		t.Fatalf("failed to delete backup schedule: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(runs); n != 0 {
+		t.Errorf("expected no backup for a deleted schedule, got %d runs", n)
+	}
+	peer.scheduleMutex.Lock()
+	defer peer.scheduleMutex.Unlock()
+	if _, running := peer.schedules[schedule.ID]; running {
+		t.Error("expected the deleted schedule to be stopped")
+	}
+}
+
+func TestParseCronSchedule(t *testing.T) {
+	from := time.Date(2024, 3, 15, 10, 7, 30, 0, time.UTC) // a Friday
+
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"0 2 * * *", time.Date(2024, 3, 16, 2, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 3, 15, 10, 15, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 3, 15, 11, 0, 0, 0, time.UTC)},
+		{"30 1 * * 0", time.Date(2024, 3, 17, 1, 30, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2024, 3, 15, 13, 0, 0, 0, time.UTC)},
+		{"@every 90m", from.Add(90 * time.Minute)},
+	}
+
+	for _, tt := range tests {
+		schedule, err := parseCronSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("parseCronSchedule(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if got := schedule.Next(from); !got.Equal(tt.next) {
+			t.Errorf("%q: expected next run %v, got %v", tt.spec, tt.next, got)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "@every -1s", "@sometimes"} {
+		if _, err := parseCronSchedule(spec); err == nil {
+			t.Errorf("expected parseCronSchedule(%q) to fail", spec)
+		}
+	}
+}
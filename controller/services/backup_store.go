@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+const (
+	BackupStorageLocal = "local"
+	BackupStorageS3    = "s3"
+)
+
+var ErrBackupStoreNotConfigured = errors.New("remote backup storage is not configured")
+
+// BackupStore keeps backup files outside the controller's local disk.
+// Keys are object names relative to the store's bucket.
+type BackupStore interface {
+	Upload(ctx context.Context, key string, body io.Reader, size int64) error
+	Download(ctx context.Context, key string, w io.Writer) error
+	Delete(ctx context.Context, key string) error
+}
+
+// S3Store is a BackupStore backed by an S3-compatible bucket. Requests use
+// path-style addressing so that MinIO and other self-hosted servers work
+// without wildcard DNS.
+type S3Store struct {
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	accessKeyID     string
+	secretAccessKey string
+	httpClient      *http.Client
+	now             func() time.Time
+}
+
+func NewS3Store(config types.S3Config) (*S3Store, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, fmt.Errorf("S3 endpoint and bucket are required")
+	}
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return nil, fmt.Errorf("S3 access key ID and secret access key are required")
+	}
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: scheme must be http or https", config.Endpoint)
+	}
+
+	region := config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &S3Store{
+		endpoint:        endpoint,
+		region:          region,
+		bucket:          config.Bucket,
+		accessKeyID:     config.AccessKeyID,
+		secretAccessKey: config.SecretAccessKey,
+		httpClient:      &http.Client{Timeout: 30 * time.Minute},
+		now:             time.Now,
+	}, nil
+}
+
+func (s *S3Store) Upload(ctx context.Context, key string, body io.Reader, size int64) error {
+	req, err := s.newRequest(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	return s.do(req, nil)
+}
+
+func (s *S3Store) Download(ctx context.Context, key string, w io.Writer) error {
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.do(req, w)
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.do(req, nil)
+}
+
+func (s *S3Store) newRequest(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + key
+	u.RawPath = ""
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build S3 request: %w", err)
+	}
+
+	// The payload is streamed from disk, so it is not hashed up front
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	s.sign(req, s.now())
+
+	return req, nil
+}
+
+func (s *S3Store) do(req *http.Request, w io.Writer) error {
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("S3 %s %s failed: %w", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("S3 %s %s returned %d: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	if w != nil {
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			return fmt.Errorf("failed to read S3 object %s: %w", req.URL.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header covering the
+// host and every header already set on the request.
+func (s *S3Store) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -1,33 +1,106 @@
 package services
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
 	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
 	"gorm.io/gorm"
 	"gopkg.in/yaml.v2"
 )
 
+var ErrInvalidExportSection = errors.New("invalid export section")
+
+// Sections of a configuration export
+const (
+	ExportSectionNodes    = "nodes"
+	ExportSectionPolicies = "policies"
+	ExportSectionUsers    = "users"
+	ExportSectionTopology = "topology"
+)
+
+var exportSections = []string{ExportSectionNodes, ExportSectionPolicies, ExportSectionUsers, ExportSectionTopology}
+
+// maxDecompressedConfigSize bounds what a compressed configuration file may
+// expand to.
+const maxDecompressedConfigSize = 256 << 20
+
 type ConfigService struct {
 	db          *gorm.DB
 	auditService *AuditService
+	subnet       *net.IPNet // WG_SUBNET, for validating allocated IPs
 }
 
 type ConfigExport struct {
 	Version     string                 `json:"version" yaml:"version"`
 	ExportedAt  time.Time              `json:"exported_at" yaml:"exported_at"`
 	ExportedBy  uuid.UUID              `json:"exported_by" yaml:"exported_by"`
-	Nodes       []models.Node          `json:"nodes" yaml:"nodes"`
-	Policies    []models.Policy        `json:"policies" yaml:"policies"`
-	Users       []UserExport           `json:"users" yaml:"users"`
-	Topology    *models.Topology       `json:"topology" yaml:"topology"`
+	// Sections lists what a partial export contains; a full export leaves
+	// it empty
+	Sections    []string               `json:"sections,omitempty" yaml:"sections,omitempty"`
+	Nodes       []models.Node          `json:"nodes,omitempty" yaml:"nodes,omitempty"`
+	Policies    []models.Policy        `json:"policies,omitempty" yaml:"policies,omitempty"`
+	Users       []UserExport           `json:"users,omitempty" yaml:"users,omitempty"`
+	Topology    *models.Topology       `json:"topology,omitempty" yaml:"topology,omitempty"`
 	SystemConfig map[string]interface{} `json:"system_config" yaml:"system_config"`
 }
 
+// HasSection reports whether the export carries the given section.
+func (e *ConfigExport) HasSection(section string) bool {
+	if len(e.Sections) == 0 {
+		return true
+	}
+	for _, included := range e.Sections {
+		if included == section {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseExportSections parses a comma-separated list of export sections, such
+// as "nodes,policies". An empty list selects every section.
+func ParseExportSections(include string) ([]string, error) {
+	requested := map[string]bool{}
+	for _, section := range strings.Split(include, ",") {
+		section = strings.TrimSpace(section)
+		if section == "" {
+			continue
+		}
+		known := false
+		for _, name := range exportSections {
+			known = known || name == section
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: %q, expected %s", ErrInvalidExportSection, section, strings.Join(exportSections, ", "))
+		}
+		requested[section] = true
+	}
+	if len(requested) == 0 || len(requested) == len(exportSections) {
+		return nil, nil
+	}
+
+	// Keep the canonical order whatever order they were asked for in
+	var sections []string
+	for _, section := range exportSections {
+		if requested[section] {
+			sections = append(sections, section)
+		}
+	}
+	return sections, nil
+}
+
 type UserExport struct {
 	ID        uuid.UUID `json:"id" yaml:"id"`
 	Username  string    `json:"username" yaml:"username"`
@@ -44,6 +117,7 @@ type ImportOptions struct {
 	SkipUsers         bool   `json:"skip_users" yaml:"skip_users"`
 	SkipNodes         bool   `json:"skip_nodes" yaml:"skip_nodes"`
 	SkipPolicies      bool   `json:"skip_policies" yaml:"skip_policies"`
+	DryRun            bool   `json:"dry_run" yaml:"dry_run"` // run the import and roll it back
 	ImportedBy        uuid.UUID `json:"imported_by" yaml:"imported_by"`
 }
 
@@ -59,7 +133,13 @@ type ImportResult struct {
 	PoliciesSkipped  int                  `json:"policies_skipped"`
 	PoliciesErrors   []string             `json:"policies_errors"`
 	GeneralErrors    []string             `json:"general_errors"`
+	// TemporaryPasswords holds the one-off password of each user the import
+	// created, by username. They must choose a new one at first login.
+	TemporaryPasswords map[string]string `json:"temporary_passwords,omitempty"`
+	DryRun           bool                 `json:"dry_run"`
+	VersionID        *uuid.UUID           `json:"version_id,omitempty"` // configuration version recorded after the import
 	ImportedAt       time.Time            `json:"imported_at"`
+	JobID            uuid.UUID            `json:"job_id"`
 }
 
 func NewConfigService(db *gorm.DB, auditService *AuditService) *ConfigService {
@@ -69,93 +149,210 @@ func NewConfigService(db *gorm.DB, auditService *AuditService) *ConfigService {
 	}
 }
 
-func (s *ConfigService) ExportConfiguration(ctx context.Context, exportedBy uuid.UUID, format string) ([]byte, error) {
+// SetSubnet sets the WireGuard subnet that imported nodes' allocated IPs
+// are validated against. Until it is set, only their format is checked.
+func (s *ConfigService) SetSubnet(subnet string) error {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("invalid subnet: %w", err)
+	}
+	s.subnet = ipNet
+	return nil
+}
+
+// RegisterApprovalActions lets full configuration exports and rollbacks run
+// once a second admin approves them. The exported data is kept as the
+// request's result.
+func (s *ConfigService) RegisterApprovalActions(approvals *ApprovalService) {
+	approvals.RegisterAction(ApprovalActionExportConfig, func(ctx context.Context, request *ApprovalRequest) (interface{}, error) {
+		var payload struct {
+			Format   string   `json:"format"`
+			Sections []string `json:"sections"`
+		}
+		if err := json.Unmarshal([]byte(request.Payload), &payload); err != nil {
+			return nil, fmt.Errorf("invalid export request: %w", err)
+		}
+		return s.ExportConfiguration(ctx, request.RequestedBy, payload.Format, payload.Sections)
+	})
+
+	approvals.RegisterAction(ApprovalActionRollbackConfig, func(ctx context.Context, request *ApprovalRequest) (interface{}, error) {
+		var payload struct {
+			VersionID uuid.UUID `json:"version_id"`
+		}
+		if err := json.Unmarshal([]byte(request.Payload), &payload); err != nil {
+			return nil, fmt.Errorf("invalid rollback request: %w", err)
+		}
+		result, err := s.RollbackToVersion(ctx, payload.VersionID, request.RequestedBy)
+		// The result is stored with the request, so users the rollback
+		// recreated reset their password by email instead
+		if result != nil {
+			result.TemporaryPasswords = nil
+		}
+		return result, err
+	})
+}
+
+// ExportConfiguration serializes the configuration. Sections, as returned by
+// ParseExportSections, limits the export to those sections; nil exports
+// everything.
+func (s *ConfigService) ExportConfiguration(ctx context.Context, exportedBy uuid.UUID, format string, sections []string) ([]byte, error) {
 	// Create export structure
+	export, err := s.buildConfigExport(ctx, exportedBy, sections)
+	if err != nil {
+		return nil, err
+	}
+	export.SystemConfig["export_format"] = format
+
+	// Log export action
+	s.auditService.LogActionWithMetadata(ctx, &exportedBy, models.AuditActionExport, "configuration", nil,
+		"Exported configuration", "", "",
+		map[string]interface{}{
+			"format": format,
+			"sections": sections,
+			"nodes_count": len(export.Nodes),
+			"policies_count": len(export.Policies),
+			"users_count": len(export.Users),
+		})
+
+	// Serialize based on format
+	switch format {
+	case "json":
+		return json.MarshalIndent(export, "", "  ")
+	case "yaml":
+		return yaml.Marshal(export)
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// buildConfigExport collects the live nodes, policies, users (without
+// passwords) and topology, or only the given sections.
+func (s *ConfigService) buildConfigExport(ctx context.Context, exportedBy uuid.UUID, sections []string) (*ConfigExport, error) {
 	export := &ConfigExport{
 		Version:    "1.0",
 		ExportedAt: time.Now(),
 		ExportedBy: exportedBy,
+		Sections:   sections,
 		SystemConfig: map[string]interface{}{
-			"export_format": format,
 			"database_version": "1.0",
 		},
 	}
+	db := s.db.WithContext(ctx)
 
 	// Export nodes
-	var nodes []models.Node
-	if err := s.db.Find(&nodes).Error; err != nil {
-		return nil, fmt.Errorf("failed to export nodes: %w", err)
+	if export.HasSection(ExportSectionNodes) {
+		if err := db.Find(&export.Nodes).Error; err != nil {
+			return nil, fmt.Errorf("failed to export nodes: %w", err)
+		}
 	}
-	export.Nodes = nodes
 
 	// Export policies
-	var policies []models.Policy
-	if err := s.db.Find(&policies).Error; err != nil {
-		return nil, fmt.Errorf("failed to export policies: %w", err)
+	if export.HasSection(ExportSectionPolicies) {
+		if err := db.Find(&export.Policies).Error; err != nil {
+			return nil, fmt.Errorf("failed to export policies: %w", err)
+		}
 	}
-	export.Policies = policies
 
 	// Export users (without passwords)
-	var users []models.User
-	if err := s.db.Find(&users).Error; err != nil {
-		return nil, fmt.Errorf("failed to export users: %w", err)
-	}
-	
-	export.Users = make([]UserExport, len(users))
-	for i, user := range users {
-		export.Users[i] = UserExport{
-			ID:        user.ID,
-			Username:  user.Username,
-			Email:     user.Email,
-			Role:      string(user.Role),
-			Active:    user.Active,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
+	if export.HasSection(ExportSectionUsers) {
+		var users []models.User
+		if err := db.Find(&users).Error; err != nil {
+			return nil, fmt.Errorf("failed to export users: %w", err)
+		}
+
+		export.Users = make([]UserExport, len(users))
+		for i, user := range users {
+			export.Users[i] = UserExport{
+				ID:        user.ID,
+				Username:  user.Username,
+				Email:     user.Email,
+				Role:      string(user.Role),
+				Active:    user.IsActive,
+				CreatedAt: user.CreatedAt,
+				UpdatedAt: user.UpdatedAt,
+			}
 		}
 	}
 
 	// Export topology
-	var topology models.Topology
-	if err := s.db.First(&topology).Error; err != nil && err != gorm.ErrRecordNotFound {
-		return nil, fmt.Errorf("failed to export topology: %w", err)
+	if export.HasSection(ExportSectionTopology) {
+		var topology models.Topology
+		err := db.First(&topology).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("failed to export topology: %w", err)
+		}
+		if err == nil {
+			export.Topology = &topology
+		}
+	}
+
+	return export, nil
+}
+
+// CompressConfiguration gzips an exported configuration. Import, validation
+// and diff accept the compressed file as is.
+func CompressConfiguration(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress configuration: %w", err)
 	}
-	if err != gorm.ErrRecordNotFound {
-		export.Topology = &topology
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress configuration: %w", err)
 	}
+	return buf.Bytes(), nil
+}
 
-	// Log export action
-	s.auditService.LogAction(ctx, exportedBy, "export_configuration", "configuration", uuid.Nil, 
-		map[string]interface{}{
-			"format": format,
-			"nodes_count": len(nodes),
-			"policies_count": len(policies),
-			"users_count": len(users),
-		})
+// decompressConfiguration unzips gzip-compressed configuration data and
+// returns anything else unchanged.
+func decompressConfiguration(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
 
-	// Serialize based on format
-	switch format {
-	case "json":
-		return json.MarshalIndent(export, "", "  ")
-	case "yaml":
-		return yaml.Marshal(export)
-	default:
-		return nil, fmt.Errorf("unsupported export format: %s", format)
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress configuration: %w", err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(io.LimitReader(reader, maxDecompressedConfigSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress configuration: %w", err)
 	}
+	if len(decompressed) > maxDecompressedConfigSize {
+		return nil, fmt.Errorf("decompressed configuration exceeds %d bytes", maxDecompressedConfigSize)
+	}
+	return decompressed, nil
 }
 
 func (s *ConfigService) ImportConfiguration(ctx context.Context, data []byte, format string, options ImportOptions) (*ImportResult, error) {
+	return s.importConfiguration(ctx, data, format, options, ConfigVersionSourceImport, "Configuration import")
+}
+
+// importConfiguration imports data and, unless it is a dry run, records the
+// resulting configuration as a new version with the given source.
+func (s *ConfigService) importConfiguration(ctx context.Context, data []byte, format string, options ImportOptions, source, description string) (*ImportResult, error) {
 	result := &ImportResult{
 		ImportedAt: time.Now(),
 		NodesErrors: []string{},
 		UsersErrors: []string{},
 		PoliciesErrors: []string{},
 		GeneralErrors: []string{},
+		JobID: uuid.New(),
+		DryRun: options.DryRun,
 	}
 
+	// Every audit entry written by this import shares the import's job ID
+	ctx = WithJobID(ctx, result.JobID)
+
 	// Parse configuration data
 	var config ConfigExport
-	var err error
-	
+	data, err := decompressConfiguration(data)
+	if err != nil {
+		return nil, err
+	}
+
 	switch format {
 	case "json":
 		err = json.Unmarshal(data, &config)
@@ -177,9 +374,10 @@ func (s *ConfigService) ImportConfiguration(ctx context.Context, data []byte, fo
 		}
 	}()
 
-	// Import nodes
+	// Import nodes; a partial export simply has no records in the
+	// sections it leaves out
 	if !options.SkipNodes {
-		nodesImported, nodesSkipped, nodeErrors := s.importNodes(tx, config.Nodes, options.OverwriteExisting)
+		nodesImported, nodesSkipped, nodeErrors := s.importNodes(ctx, tx, config.Nodes, options)
 		result.NodesImported = nodesImported
 		result.NodesSkipped = nodesSkipped
 		result.NodesErrors = nodeErrors
@@ -187,7 +385,7 @@ func (s *ConfigService) ImportConfiguration(ctx context.Context, data []byte, fo
 
 	// Import policies
 	if !options.SkipPolicies {
-		policiesImported, policiesSkipped, policyErrors := s.importPolicies(tx, config.Policies, options.OverwriteExisting)
+		policiesImported, policiesSkipped, policyErrors := s.importPolicies(ctx, tx, config.Policies, options)
 		result.PoliciesImported = policiesImported
 		result.PoliciesSkipped = policiesSkipped
 		result.PoliciesErrors = policyErrors
@@ -195,10 +393,14 @@ func (s *ConfigService) ImportConfiguration(ctx context.Context, data []byte, fo
 
 	// Import users
 	if !options.SkipUsers {
-		usersImported, usersSkipped, userErrors := s.importUsers(tx, config.Users, options.OverwriteExisting)
+		usersImported, usersSkipped, userErrors, passwords := s.importUsers(ctx, tx, config.Users, options)
 		result.UsersImported = usersImported
 		result.UsersSkipped = usersSkipped
 		result.UsersErrors = userErrors
+		// A dry run creates no one to hand passwords to
+		if !options.DryRun && len(passwords) > 0 {
+			result.TemporaryPasswords = passwords
+		}
 	}
 
 	// Import topology
@@ -215,6 +417,15 @@ func (s *ConfigService) ImportConfiguration(ctx context.Context, data []byte, fo
 		return result, fmt.Errorf("import failed due to critical errors")
 	}
 
+	// A dry run reports what would have been imported and persists nothing
+	if options.DryRun {
+		if err := tx.Rollback().Error; err != nil {
+			return result, fmt.Errorf("failed to roll back dry-run import: %w", err)
+		}
+		result.Success = true
+		return result, nil
+	}
+
 	// Commit transaction
 	if err := tx.Commit().Error; err != nil {
 		result.Success = false
@@ -225,7 +436,8 @@ func (s *ConfigService) ImportConfiguration(ctx context.Context, data []byte, fo
 	result.Success = true
 
 	// Log import action
-	s.auditService.LogAction(ctx, options.ImportedBy, "import_configuration", "configuration", uuid.Nil,
+	s.auditService.LogActionWithMetadata(ctx, &options.ImportedBy, models.AuditActionCreate, "configuration", nil,
+		"Imported configuration", "", "",
 		map[string]interface{}{
 			"format": format,
 			"nodes_imported": result.NodesImported,
@@ -234,10 +446,19 @@ func (s *ConfigService) ImportConfiguration(ctx context.Context, data []byte, fo
 			"overwrite_existing": options.OverwriteExisting,
 		})
 
+	// Keep the resulting configuration so the import can be rolled back
+	version, err := s.createVersion(ctx, options.ImportedBy, source, description)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to record configuration version for import", "job_id", result.JobID, "error", err)
+	} else {
+		result.VersionID = &version.ID
+	}
+
 	return result, nil
 }
 
-func (s *ConfigService) importNodes(tx *gorm.DB, nodes []models.Node, overwrite bool) (int, int, []string) {
+func (s *ConfigService) importNodes(ctx context.Context, tx *gorm.DB, nodes []models.Node, options ImportOptions) (int, int, []string) {
+	audit := s.auditService.WithTx(tx)
 	imported := 0
 	skipped := 0
 	errors := []string{}
@@ -249,12 +470,14 @@ func (s *ConfigService) importNodes(tx *gorm.DB, nodes []models.Node, overwrite
 		
 		if err == nil {
 			// Node exists
-			if overwrite {
+			if options.OverwriteExisting {
 				// Update existing node
 				if err := tx.Model(&existingNode).Updates(node).Error; err != nil {
 					errors = append(errors, fmt.Sprintf("Failed to update node %s: %v", node.Name, err))
 					continue
 				}
+				audit.LogAction(ctx, &options.ImportedBy, models.AuditActionUpdate, "node", &existingNode.ID,
+					fmt.Sprintf("Node %s updated by configuration import", node.Name), "", "")
 				imported++
 			} else {
 				skipped++
@@ -265,6 +488,8 @@ func (s *ConfigService) importNodes(tx *gorm.DB, nodes []models.Node, overwrite
 				errors = append(errors, fmt.Sprintf("Failed to create node %s: %v", node.Name, err))
 				continue
 			}
+			audit.LogAction(ctx, &options.ImportedBy, models.AuditActionCreate, "node", &node.ID,
+				fmt.Sprintf("Node %s created by configuration import", node.Name), "", "")
 			imported++
 		} else {
 			errors = append(errors, fmt.Sprintf("Database error for node %s: %v", node.Name, err))
@@ -274,7 +499,8 @@ func (s *ConfigService) importNodes(tx *gorm.DB, nodes []models.Node, overwrite
 	return imported, skipped, errors
 }
 
-func (s *ConfigService) importPolicies(tx *gorm.DB, policies []models.Policy, overwrite bool) (int, int, []string) {
+func (s *ConfigService) importPolicies(ctx context.Context, tx *gorm.DB, policies []models.Policy, options ImportOptions) (int, int, []string) {
+	audit := s.auditService.WithTx(tx)
 	imported := 0
 	skipped := 0
 	errors := []string{}
@@ -286,12 +512,14 @@ func (s *ConfigService) importPolicies(tx *gorm.DB, policies []models.Policy, ov
 		
 		if err == nil {
 			// Policy exists
-			if overwrite {
+			if options.OverwriteExisting {
 				// Update existing policy
 				if err := tx.Model(&existingPolicy).Updates(policy).Error; err != nil {
 					errors = append(errors, fmt.Sprintf("Failed to update policy %s: %v", policy.Name, err))
 					continue
 				}
+				audit.LogAction(ctx, &options.ImportedBy, models.AuditActionUpdate, "policy", &existingPolicy.ID,
+					fmt.Sprintf("Policy %s updated by configuration import", policy.Name), "", "")
 				imported++
 			} else {
 				skipped++
@@ -302,6 +530,8 @@ func (s *ConfigService) importPolicies(tx *gorm.DB, policies []models.Policy, ov
 				errors = append(errors, fmt.Sprintf("Failed to create policy %s: %v", policy.Name, err))
 				continue
 			}
+			audit.LogAction(ctx, &options.ImportedBy, models.AuditActionCreate, "policy", &policy.ID,
+				fmt.Sprintf("Policy %s created by configuration import", policy.Name), "", "")
 			imported++
 		} else {
 			errors = append(errors, fmt.Sprintf("Database error for policy %s: %v", policy.Name, err))
@@ -311,10 +541,14 @@ func (s *ConfigService) importPolicies(tx *gorm.DB, policies []models.Policy, ov
 	return imported, skipped, errors
 }
 
-func (s *ConfigService) importUsers(tx *gorm.DB, users []UserExport, overwrite bool) (int, int, []string) {
+// importUsers also returns the temporary passwords of the users it created,
+// by username. Exports carry no password hashes.
+func (s *ConfigService) importUsers(ctx context.Context, tx *gorm.DB, users []UserExport, options ImportOptions) (int, int, []string, map[string]string) {
+	audit := s.auditService.WithTx(tx)
 	imported := 0
 	skipped := 0
 	errors := []string{}
+	passwords := map[string]string{}
 
 	for _, userExport := range users {
 		// Check if user exists
@@ -323,44 +557,58 @@ func (s *ConfigService) importUsers(tx *gorm.DB, users []UserExport, overwrite b
 		
 		if err == nil {
 			// User exists
-			if overwrite {
+			if options.OverwriteExisting {
 				// Update existing user (except password)
 				updates := map[string]interface{}{
 					"username":   userExport.Username,
 					"email":      userExport.Email,
 					"role":       userExport.Role,
-					"active":     userExport.Active,
+					"is_active":  userExport.Active,
 					"updated_at": time.Now(),
 				}
 				if err := tx.Model(&existingUser).Updates(updates).Error; err != nil {
 					errors = append(errors, fmt.Sprintf("Failed to update user %s: %v", userExport.Username, err))
 					continue
 				}
+				audit.LogAction(ctx, &options.ImportedBy, models.AuditActionUpdate, "user", &existingUser.ID,
+					fmt.Sprintf("User %s updated by configuration import", userExport.Username), "", "")
 				imported++
 			} else {
 				skipped++
 			}
 		} else if err == gorm.ErrRecordNotFound {
-			// User doesn't exist, create new (with default password)
+			// User doesn't exist, create new with a temporary password
 			newUser := models.User{
-				ID:       userExport.ID,
-				Username: userExport.Username,
-				Email:    userExport.Email,
-				Role:     models.UserRole(userExport.Role),
-				Active:   userExport.Active,
-				Password: "$2a$10$defaulthashedpassword", // Default password, user must change
+				ID:                 userExport.ID,
+				Username:           userExport.Username,
+				Email:              userExport.Email,
+				Role:               models.UserRole(userExport.Role),
+				IsActive:           userExport.Active,
+				MustChangePassword: true,
+			}
+			password, err := generateSecretToken()
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("Failed to generate password for user %s: %v", userExport.Username, err))
+				continue
+			}
+			if err := newUser.SetPassword(password); err != nil {
+				errors = append(errors, fmt.Sprintf("Failed to set password for user %s: %v", userExport.Username, err))
+				continue
 			}
 			if err := tx.Create(&newUser).Error; err != nil {
 				errors = append(errors, fmt.Sprintf("Failed to create user %s: %v", userExport.Username, err))
 				continue
 			}
+			audit.LogAction(ctx, &options.ImportedBy, models.AuditActionCreate, "user", &newUser.ID,
+				fmt.Sprintf("User %s created by configuration import", userExport.Username), "", "")
+			passwords[userExport.Username] = password
 			imported++
 		} else {
 			errors = append(errors, fmt.Sprintf("Database error for user %s: %v", userExport.Username, err))
 		}
 	}
 
-	return imported, skipped, errors
+	return imported, skipped, errors, passwords
 }
 
 func (s *ConfigService) importTopology(tx *gorm.DB, topology *models.Topology, overwrite bool) error {
@@ -385,20 +633,9 @@ func (s *ConfigService) ValidateConfiguration(ctx context.Context, data []byte,
 	warnings := []string{}
 	
 	// Parse configuration data
-	var config ConfigExport
-	var err error
-	
-	switch format {
-	case "json":
-		err = json.Unmarshal(data, &config)
-	case "yaml":
-		err = yaml.Unmarshal(data, &config)
-	default:
-		return nil, fmt.Errorf("unsupported format: %s", format)
-	}
-
+	config, err := parseConfigExport(data, format)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+		return nil, err
 	}
 
 	// Validate version compatibility
@@ -408,6 +645,7 @@ func (s *ConfigService) ValidateConfiguration(ctx context.Context, data []byte,
 
 	// Validate nodes
 	nodeNames := make(map[string]bool)
+	ipOwners := make(map[string]string)
 	for _, node := range config.Nodes {
 		if nodeNames[node.Name] {
 			warnings = append(warnings, fmt.Sprintf("Duplicate node name: %s", node.Name))
@@ -416,10 +654,27 @@ func (s *ConfigService) ValidateConfiguration(ctx context.Context, data []byte,
 		
 		if node.PublicKey == "" {
 			warnings = append(warnings, fmt.Sprintf("Node %s has empty public key", node.Name))
+		} else if types.ValidateKey(node.PublicKey) != nil {
+			warnings = append(warnings, fmt.Sprintf("Node %s has malformed public key, expected a base64 encoded 32-byte WireGuard key", node.Name))
 		}
 		
 		if node.AllocatedIP == "" {
 			warnings = append(warnings, fmt.Sprintf("Node %s has empty allocated IP", node.Name))
+			continue
+		}
+		ip, _, err := net.ParseCIDR(node.AllocatedIP)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("Node %s has invalid allocated IP %s, expected an address with a prefix length such as 10.100.0.2/16", node.Name, node.AllocatedIP))
+			continue
+		}
+		if s.subnet != nil && !s.subnet.Contains(ip) {
+			warnings = append(warnings, fmt.Sprintf("Node %s has allocated IP %s outside the subnet %s", node.Name, node.AllocatedIP, s.subnet))
+		}
+		// A node listed twice is already reported as a duplicate name
+		if owner, ok := ipOwners[ip.String()]; !ok {
+			ipOwners[ip.String()] = node.Name
+		} else if owner != node.Name {
+			warnings = append(warnings, fmt.Sprintf("Node %s has allocated IP %s already used by node %s", node.Name, node.AllocatedIP, owner))
 		}
 	}
 
@@ -450,6 +705,30 @@ func (s *ConfigService) ValidateConfiguration(ctx context.Context, data []byte,
 	return warnings, nil
 }
 
+// parseConfigExport decodes an exported configuration in the given format,
+// compressed or not.
+func parseConfigExport(data []byte, format string) (*ConfigExport, error) {
+	var config ConfigExport
+	data, err := decompressConfiguration(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "json":
+		err = json.Unmarshal(data, &config)
+	case "yaml":
+		err = yaml.Unmarshal(data, &config)
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+	return &config, nil
+}
+
 func (s *ConfigService) GetConfigurationSummary(ctx context.Context) (map[string]interface{}, error) {
 	summary := make(map[string]interface{})
 	
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidConfiguration = errors.New("invalid configuration")
+
+// ConfigDiff describes what importing a configuration file would change
+// compared to the live configuration.
+type ConfigDiff struct {
+	Nodes    EntityDiff `json:"nodes"`
+	Policies EntityDiff `json:"policies"`
+	Users    EntityDiff `json:"users"`
+	Topology EntityDiff `json:"topology"`
+}
+
+// EntityDiff lists records only in the file (added), only in the database
+// (removed) and in both with different values (modified).
+type EntityDiff struct {
+	Added    []DiffEntry `json:"added"`
+	Removed  []DiffEntry `json:"removed"`
+	Modified []DiffEntry `json:"modified"`
+}
+
+type DiffEntry struct {
+	ID      uuid.UUID     `json:"id"`
+	Name    string        `json:"name,omitempty"`
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// FieldChange holds a field's live value (Old) and its value in the file
+// (New), keyed by the field's JSON name.
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// Bookkeeping and runtime fields that never count as a change
+var diffIgnoredFields = map[string]bool{
+	"id":               true,
+	"created_at":       true,
+	"updated_at":       true,
+	"last_handshake":   true,
+	"source_node":      true,
+	"destination_node": true,
+	"hub":              true,
+	"spoke":            true,
+}
+
+// DiffConfiguration compares an exported configuration with the database.
+// Records are matched the way ImportConfiguration matches them: nodes and
+// policies by ID or name, users by ID, username or email. Sections a
+// partial export leaves out are not compared.
+func (s *ConfigService) DiffConfiguration(ctx context.Context, data []byte, format string) (*ConfigDiff, error) {
+	config, err := parseConfigExport(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfiguration, err)
+	}
+
+	db := s.db.WithContext(ctx)
+	diff := &ConfigDiff{
+		Nodes:    newEntityDiff(),
+		Policies: newEntityDiff(),
+		Users:    newEntityDiff(),
+		Topology: newEntityDiff(),
+	}
+
+	if config.HasSection(ExportSectionNodes) {
+		var nodes []models.Node
+		if err := db.Order("name").Find(&nodes).Error; err != nil {
+			return nil, fmt.Errorf("failed to fetch nodes: %w", err)
+		}
+		live := make([]diffRecord, len(nodes))
+		for i, node := range nodes {
+			live[i] = diffRecord{id: node.ID, name: node.Name, keys: []string{node.Name}, value: node}
+		}
+		incoming := make([]diffRecord, len(config.Nodes))
+		for i, node := range config.Nodes {
+			incoming[i] = diffRecord{id: node.ID, name: node.Name, keys: []string{node.Name}, value: node}
+		}
+		if diff.Nodes, err = diffRecords(live, incoming); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.HasSection(ExportSectionPolicies) {
+		var policies []models.Policy
+		if err := db.Order("name").Find(&policies).Error; err != nil {
+			return nil, fmt.Errorf("failed to fetch policies: %w", err)
+		}
+		live := make([]diffRecord, len(policies))
+		for i, policy := range policies {
+			live[i] = diffRecord{id: policy.ID, name: policy.Name, keys: []string{policy.Name}, value: policy}
+		}
+		incoming := make([]diffRecord, len(config.Policies))
+		for i, policy := range config.Policies {
+			incoming[i] = diffRecord{id: policy.ID, name: policy.Name, keys: []string{policy.Name}, value: policy}
+		}
+		if diff.Policies, err = diffRecords(live, incoming); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.HasSection(ExportSectionUsers) {
+		var users []models.User
+		if err := db.Order("username").Find(&users).Error; err != nil {
+			return nil, fmt.Errorf("failed to fetch users: %w", err)
+		}
+		live := make([]diffRecord, len(users))
+		for i, user := range users {
+			// Compare in export form so password hashes never appear in a diff
+			export := UserExport{
+				ID:        user.ID,
+				Username:  user.Username,
+				Email:     user.Email,
+				Role:      string(user.Role),
+				Active:    user.IsActive,
+				CreatedAt: user.CreatedAt,
+				UpdatedAt: user.UpdatedAt,
+			}
+			live[i] = diffRecord{id: user.ID, name: user.Username, keys: []string{user.Username, user.Email}, value: export}
+		}
+		incoming := make([]diffRecord, len(config.Users))
+		for i, user := range config.Users {
+			incoming[i] = diffRecord{id: user.ID, name: user.Username, keys: []string{user.Username, user.Email}, value: user}
+		}
+		if diff.Users, err = diffRecords(live, incoming); err != nil {
+			return nil, err
+		}
+	}
+
+	// There is a single topology record, so the two sides always match up
+	if config.HasSection(ExportSectionTopology) {
+		var topology models.Topology
+		err = db.First(&topology).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to fetch topology: %w", err)
+		}
+		var live []diffRecord
+		if err == nil {
+			live = []diffRecord{{id: topology.ID, keys: []string{"topology"}, value: topology}}
+		}
+		var incoming []diffRecord
+		if config.Topology != nil {
+			incoming = []diffRecord{{id: config.Topology.ID, keys: []string{"topology"}, value: config.Topology}}
+		}
+		if diff.Topology, err = diffRecords(live, incoming); err != nil {
+			return nil, err
+		}
+	}
+
+	return diff, nil
+}
+
+type diffRecord struct {
+	id    uuid.UUID
+	name  string
+	keys  []string // natural keys that identify the record besides its ID
+	value interface{}
+}
+
+func newEntityDiff() EntityDiff {
+	return EntityDiff{
+		Added:    []DiffEntry{},
+		Removed:  []DiffEntry{},
+		Modified: []DiffEntry{},
+	}
+}
+
+func diffRecords(live, incoming []diffRecord) (EntityDiff, error) {
+	diff := newEntityDiff()
+
+	byID := make(map[uuid.UUID]int)
+	byKey := make(map[string]int)
+	for i, record := range live {
+		byID[record.id] = i
+		for _, key := range record.keys {
+			if key != "" {
+				byKey[key] = i
+			}
+		}
+	}
+
+	matched := make(map[int]bool)
+	for _, record := range incoming {
+		i, found := -1, false
+		if record.id != uuid.Nil {
+			i, found = byID[record.id]
+		}
+		for _, key := range record.keys {
+			if found {
+				break
+			}
+			if key != "" {
+				i, found = byKey[key]
+			}
+		}
+
+		if !found {
+			diff.Added = append(diff.Added, DiffEntry{ID: record.id, Name: record.name})
+			continue
+		}
+		matched[i] = true
+
+		changes, err := diffFields(live[i].value, record.value)
+		if err != nil {
+			return diff, err
+		}
+		if len(changes) > 0 {
+			diff.Modified = append(diff.Modified, DiffEntry{ID: live[i].id, Name: live[i].name, Changes: changes})
+		}
+	}
+
+	for i, record := range live {
+		if !matched[i] {
+			diff.Removed = append(diff.Removed, DiffEntry{ID: record.id, Name: record.name})
+		}
+	}
+
+	return diff, nil
+}
+
+// diffFields compares two records field by field through their JSON form,
+// which is also the form the export file uses.
+func diffFields(current, incoming interface{}) ([]FieldChange, error) {
+	oldFields, err := diffFieldValues(current)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := diffFieldValues(incoming)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(oldFields))
+	for name := range oldFields {
+		names = append(names, name)
+	}
+	for name := range newFields {
+		if _, ok := oldFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []FieldChange{}
+	for _, name := range names {
+		if diffIgnoredFields[name] || reflect.DeepEqual(oldFields[name], newFields[name]) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: name, Old: oldFields[name], New: newFields[name]})
+	}
+	return changes, nil
+}
+
+func diffFieldValues(record interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode record: %w", err)
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode record: %w", err)
+	}
+	return fields, nil
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+)
+
+func diffTestNode(name, ip string, port int) models.Node {
+	return models.Node{
+		ID:          uuid.New(),
+		Name:        name,
+		NodeType:    models.NodeTypeSpoke,
+		PublicKey:   name + "-key",
+		AllocatedIP: ip,
+		Endpoint:    name + ".example.com",
+		Port:        port,
+		Status:      models.NodeStatusActive,
+		MTU:         1420,
+	}
+}
+
+func diffTestPolicy(name, protocol string) models.Policy {
+	return models.Policy{
+		ID:              uuid.New(),
+		Name:            name,
+		DestinationCIDR: "10.0.1.0/24",
+		Protocol:        protocol,
+		Action:          models.PolicyActionAllow,
+		Priority:        100,
+		Enabled:         true,
+	}
+}
+
+func diffEntryNames(entries []DiffEntry) []string {
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+func expectDiffNames(t *testing.T, kind string, entries []DiffEntry, want ...string) {
+	t.Helper()
+
+	got := diffEntryNames(entries)
+	if len(got) != len(want) {
+		t.Errorf("expected %s %v, got %v", kind, want, got)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %s %v, got %v", kind, want, got)
+			return
+		}
+	}
+}
+
+func TestDiffConfiguration(t *testing.T) {
+	db := newImportTestDB(t)
+
+	hub := diffTestNode("hub-1", "10.100.0.1", 51820)
+	for _, node := range []models.Node{hub, diffTestNode("spoke-old", "10.100.0.2", 51820)} {
+		if err := db.Omit("AllowedIPs", "BackupHubIDs", "Routes", "PreUp", "PostUp", "PreDown", "PostDown").Create(&node).Error; err != nil {
+			t.Fatalf("failed to create node: %v", err)
+		}
+	}
+	web := diffTestPolicy("allow-web", "tcp")
+	for _, policy := range []models.Policy{web, diffTestPolicy("allow-stale", "udp")} {
+		if err := db.Create(&policy).Error; err != nil {
+			t.Fatalf("failed to create policy: %v", err)
+		}
+	}
+	for _, user := range []models.User{
+		{Username: "alice", Email: "alice@example.com", Password: "x", Role: models.UserRoleAdmin, IsActive: true},
+		{Username: "carol", Email: "carol@example.com", Password: "x", Role: models.UserRoleObserver, IsActive: true},
+	} {
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	// The file moves hub-1 to another port, replaces spoke-old with spoke-new,
+	// keeps allow-web as is, demotes alice and adds a topology
+	changedHub := hub
+	changedHub.ID = uuid.New()
+	changedHub.Port = 51821
+	unchangedWeb := web
+	export := ConfigExport{
+		Version: "1.0",
+		Nodes:   []models.Node{changedHub, diffTestNode("spoke-new", "10.100.0.3", 51820)},
+		Policies: []models.Policy{
+			unchangedWeb,
+			diffTestPolicy("allow-dns", "udp"),
+		},
+		Users: []UserExport{
+			{ID: uuid.New(), Username: "alice", Email: "alice@example.com", Role: string(models.UserRoleObserver), Active: true},
+			{ID: uuid.New(), Username: "bob", Email: "bob@example.com", Role: string(models.UserRoleAdmin), Active: true},
+		},
+		Topology: &models.Topology{ID: uuid.New(), HubID: hub.ID, SpokeID: uuid.New()},
+	}
+	data, err := json.Marshal(export)
+	if err != nil {
+		t.Fatalf("failed to encode export: %v", err)
+	}
+
+	service := NewConfigService(db, NewAuditService(db))
+	diff, err := service.DiffConfiguration(context.Background(), data, "json")
+	if err != nil {
+		t.Fatalf("DiffConfiguration failed: %v", err)
+	}
+
+	expectDiffNames(t, "added nodes", diff.Nodes.Added, "spoke-new")
+	expectDiffNames(t, "removed nodes", diff.Nodes.Removed, "spoke-old")
+	expectDiffNames(t, "modified nodes", diff.Nodes.Modified, "hub-1")
+	if len(diff.Nodes.Modified) == 1 {
+		entry := diff.Nodes.Modified[0]
+		if entry.ID != hub.ID {
+			t.Errorf("expected modified node to carry the live ID %s, got %s", hub.ID, entry.ID)
+		}
+		if len(entry.Changes) != 1 || entry.Changes[0].Field != "port" ||
+			entry.Changes[0].Old != float64(51820) || entry.Changes[0].New != float64(51821) {
+			t.Errorf("expected only port to change from 51820 to 51821, got %+v", entry.Changes)
+		}
+	}
+
+	expectDiffNames(t, "added policies", diff.Policies.Added, "allow-dns")
+	expectDiffNames(t, "removed policies", diff.Policies.Removed, "allow-stale")
+	expectDiffNames(t, "modified policies", diff.Policies.Modified)
+
+	expectDiffNames(t, "added users", diff.Users.Added, "bob")
+	expectDiffNames(t, "removed users", diff.Users.Removed, "carol")
+	expectDiffNames(t, "modified users", diff.Users.Modified, "alice")
+	if len(diff.Users.Modified) == 1 {
+		changes := diff.Users.Modified[0].Changes
+		if len(changes) != 1 || changes[0].Field != "role" || changes[0].Old != "admin" || changes[0].New != "observer" {
+			t.Errorf("expected only role to change from admin to observer, got %+v", changes)
+		}
+	}
+
+	if len(diff.Topology.Added) != 1 || diff.Topology.Added[0].ID != export.Topology.ID {
+		t.Errorf("expected the file's topology to be added, got %+v", diff.Topology.Added)
+	}
+	if len(diff.Topology.Removed) != 0 || len(diff.Topology.Modified) != 0 {
+		t.Errorf("expected no removed or modified topology, got %+v", diff.Topology)
+	}
+}
+
+func TestDiffConfigurationTopologyChange(t *testing.T) {
+	db := newImportTestDB(t)
+
+	live := models.Topology{ID: uuid.New(), HubID: uuid.New(), SpokeID: uuid.New()}
+	if err := db.Omit("Hub", "Spoke").Create(&live).Error; err != nil {
+		t.Fatalf("failed to create topology: %v", err)
+	}
+
+	newSpoke := uuid.New()
+	data, err := json.Marshal(ConfigExport{
+		Version:  "1.0",
+		Topology: &models.Topology{ID: uuid.New(), HubID: live.HubID, SpokeID: newSpoke},
+	})
+	if err != nil {
+		t.Fatalf("failed to encode export: %v", err)
+	}
+
+	diff, err := NewConfigService(db, NewAuditService(db)).DiffConfiguration(context.Background(), data, "json")
+	if err != nil {
+		t.Fatalf("DiffConfiguration failed: %v", err)
+	}
+
+	if len(diff.Topology.Modified) != 1 {
+		t.Fatalf("expected topology to be modified, got %+v", diff.Topology)
+	}
+	changes := diff.Topology.Modified[0].Changes
+	if len(changes) != 1 || changes[0].Field != "spoke_id" || changes[0].Old != live.SpokeID.String() || changes[0].New != newSpoke.String() {
+		t.Errorf("expected only spoke_id to change, got %+v", changes)
+	}
+}
+
+func TestDiffConfigurationRejectsInvalidFile(t *testing.T) {
+	db := newImportTestDB(t)
+	service := NewConfigService(db, NewAuditService(db))
+
+	if _, err := service.DiffConfiguration(context.Background(), []byte("{not json"), "json"); !errors.Is(err, ErrInvalidConfiguration) {
+		t.Errorf("expected ErrInvalidConfiguration for malformed JSON, got %v", err)
+	}
+	if _, err := service.DiffConfiguration(context.Background(), []byte("{}"), "toml"); !errors.Is(err, ErrInvalidConfiguration) {
+		t.Errorf("expected ErrInvalidConfiguration for an unsupported format, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+var ErrNoPreviousConfig = errors.New("no previous config served to node")
+
+// ConfigHistory remembers the last two different configs served to each
+// node so admins can compare a node's config with the one it replaced. It is
+// kept in memory, so it starts empty after a restart and each HA controller
+// has its own.
+type ConfigHistory struct {
+	mu      sync.Mutex
+	configs map[uuid.UUID]*servedConfigs
+}
+
+type servedConfigs struct {
+	current  *types.NodeConfigResponse
+	previous *types.NodeConfigResponse
+}
+
+func NewConfigHistory() *ConfigHistory {
+	return &ConfigHistory{
+		configs: make(map[uuid.UUID]*servedConfigs),
+	}
+}
+
+// Record notes that config was served to a node. A config that only differs
+// from the last one in when it was generated replaces it without moving it to
+// previous.
+func (h *ConfigHistory) Record(nodeID uuid.UUID, config *types.NodeConfigResponse) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	served, ok := h.configs[nodeID]
+	if !ok {
+		h.configs[nodeID] = &servedConfigs{current: config}
+		return
+	}
+
+	if !sameConfig(served.current, config) {
+		served.previous = served.current
+	}
+	served.current = config
+}
+
+// Previous returns the config a node was served before its current one.
+func (h *ConfigHistory) Previous(nodeID uuid.UUID) (*types.NodeConfigResponse, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	served, ok := h.configs[nodeID]
+	if !ok || served.previous == nil {
+		return nil, ErrNoPreviousConfig
+	}
+	return served.previous, nil
+}
+
+// Forget drops a node's history, for a node that has been deleted.
+func (h *ConfigHistory) Forget(nodeID uuid.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.configs, nodeID)
+}
+
+func sameConfig(a, b *types.NodeConfigResponse) bool {
+	x, y := *a, *b
+	x.GeneratedAt = y.GeneratedAt
+	return reflect.DeepEqual(x, y)
+}
+
+// SetConfigHistory makes the node service remember the configs it serves in
+// history. Without one, no previous configs are kept.
+func (s *NodeService) SetConfigHistory(history *ConfigHistory) {
+	s.configHistory = history
+}
+
+// GetPreviousNodeConfig returns the config a node was served before its
+// current one.
+func (s *NodeService) GetPreviousNodeConfig(ctx context.Context, id uuid.UUID) (*types.NodeConfigResponse, error) {
+	var node models.Node
+	if err := s.db.Where("id = ?", id).First(&node).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNodeNotFound
+		}
+		return nil, fmt.Errorf("failed to get node: %w", err)
+	}
+
+	if s.configHistory == nil {
+		return nil, ErrNoPreviousConfig
+	}
+	return s.configHistory.Previous(id)
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPreviousNodeConfigIsTheLastOneServed(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	service.SetConfigHistory(NewConfigHistory())
+	hubID, spokeID := newRotationTopology(t, db)
+	ctx := context.Background()
+
+	first, err := service.GetNodeConfig(ctx, spokeID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if _, err := service.GetPreviousNodeConfig(ctx, spokeID); !errors.Is(err, ErrNoPreviousConfig) {
+		t.Fatalf("expected no previous config after the first fetch, got %v", err)
+	}
+
+	// Fetching the same config again doesn't make it the previous one
+	if _, err := service.GetNodeConfig(ctx, spokeID); err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if _, err := service.GetPreviousNodeConfig(ctx, spokeID); !errors.Is(err, ErrNoPreviousConfig) {
+		t.Fatalf("expected an unchanged config not to be kept as previous, got %v", err)
+	}
+
+	if err := db.Exec("UPDATE nodes SET endpoint = ? WHERE id = ?", "203.0.113.99", hubID).Error; err != nil {
+		t.Fatalf("failed to move hub: %v", err)
+	}
+	current, err := service.GetNodeConfig(ctx, spokeID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+
+	previous, err := service.GetPreviousNodeConfig(ctx, spokeID)
+	if err != nil {
+		t.Fatalf("GetPreviousNodeConfig failed: %v", err)
+	}
+	if len(previous.Peers) != 1 || previous.Peers[0].Endpoint != first.Peers[0].Endpoint {
+		t.Errorf("expected the previous config to have the old hub endpoint, got %+v", previous.Peers)
+	}
+	if previous.Peers[0].Endpoint == current.Peers[0].Endpoint {
+		t.Error("expected the previous config to differ from the current one")
+	}
+}
+
+func TestPreviousNodeConfigWithoutHistory(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	_, spokeID := newRotationTopology(t, db)
+	ctx := context.Background()
+
+	if _, err := service.GetNodeConfig(ctx, spokeID); err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if _, err := service.GetPreviousNodeConfig(ctx, spokeID); !errors.Is(err, ErrNoPreviousConfig) {
+		t.Errorf("expected ErrNoPreviousConfig without a history, got %v", err)
+	}
+	if _, err := service.GetPreviousNodeConfig(ctx, uuid.New()); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("expected ErrNodeNotFound for an unknown node, got %v", err)
+	}
+}
+
+func TestDeletedNodeConfigHistoryIsForgotten(t *testing.T) {
+	history := NewConfigHistory()
+	service, db := newKeyRotationTestService(t, 0)
+	service.SetConfigHistory(history)
+	hubID, spokeID := newRotationTopology(t, db)
+	ctx := context.Background()
+
+	if _, err := service.GetNodeConfig(ctx, spokeID); err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if err := db.Exec("UPDATE nodes SET endpoint = ? WHERE id = ?", "203.0.113.99", hubID).Error; err != nil {
+		t.Fatalf("failed to move hub: %v", err)
+	}
+	if _, err := service.GetNodeConfig(ctx, spokeID); err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+
+	if err := service.DeleteNode(ctx, spokeID); err != nil {
+		t.Fatalf("DeleteNode failed: %v", err)
+	}
+	if _, err := history.Previous(spokeID); !errors.Is(err, ErrNoPreviousConfig) {
+		t.Errorf("expected a deleted node's history to be dropped, got %v", err)
+	}
+}
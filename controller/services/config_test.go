@@ -0,0 +1,524 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB opens an in-memory SQLite database that lives as long as the
+// test.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+
+	// Each connection to :memory: is a separate database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func newImportTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db := openTestDB(t)
+
+	// The models' gen_random_uuid() defaults are Postgres-only, so the tables
+	// an import touches are created by hand using gorm's column names
+	for _, stmt := range importTestSchema {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create test schema: %v", err)
+		}
+	}
+	return db
+}
+
+var importTestSchema = []string{
+	`CREATE TABLE users (
+		id TEXT PRIMARY KEY, username TEXT NOT NULL UNIQUE, email TEXT NOT NULL UNIQUE,
+		password TEXT NOT NULL, role TEXT DEFAULT 'user', is_active BOOLEAN DEFAULT TRUE, auth_source TEXT DEFAULT 'local',
+		must_change_password BOOLEAN DEFAULT FALSE, last_login DATETIME, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+	policiesTestTable,
+	// Array columns are left out; SQLite cannot store them
+	`CREATE TABLE nodes (
+		id TEXT PRIMARY KEY, name TEXT NOT NULL UNIQUE, description TEXT, node_type TEXT NOT NULL,
+		public_key TEXT NOT NULL, key_rotated_at DATETIME, key_rotation_requested_at DATETIME,
+		allocated_ip TEXT NOT NULL, endpoint TEXT,
+		port INTEGER, last_handshake DATETIME, last_seen DATETIME, endpoint_probed_at DATETIME, endpoint_probe_error TEXT, peer_errors TEXT, peer_errors_reported_at DATETIME, agent_version TEXT, build_commit TEXT, status TEXT, persistent_keepalive INTEGER,
+		mtu INTEGER, pinned_hub_id TEXT, tags TEXT, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+	`CREATE TABLE topology (
+		id TEXT PRIMARY KEY, hub_id TEXT NOT NULL, spoke_id TEXT NOT NULL,
+		created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+	`CREATE TABLE config_versions (
+		id TEXT PRIMARY KEY, source TEXT NOT NULL, description TEXT, nodes_count INTEGER,
+		policies_count INTEGER, users_count INTEGER, snapshot TEXT NOT NULL, created_by TEXT,
+		created_at DATETIME)`,
+	auditLogsTestTable,
+}
+
+const policiesTestTable = `CREATE TABLE policies (
+	id TEXT PRIMARY KEY, name TEXT NOT NULL, description TEXT,
+	source_node_id TEXT, destination_node_id TEXT, source_group TEXT, destination_group TEXT,
+	source_group_id TEXT, destination_group_id TEXT,
+	source_c_id_r TEXT, destination_c_id_r TEXT,
+	protocol TEXT, port INTEGER, action TEXT NOT NULL, priority INTEGER DEFAULT 100,
+	enabled BOOLEAN DEFAULT TRUE, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`
+
+const auditLogsTestTable = `CREATE TABLE audit_logs (
+	id TEXT PRIMARY KEY, user_id TEXT, action TEXT NOT NULL, resource TEXT,
+	resource_id TEXT, description TEXT, ip_address TEXT, user_agent TEXT,
+	metadata TEXT, job_id TEXT, sequence INTEGER, prev_hash TEXT, hash TEXT,
+	created_at DATETIME);
+CREATE UNIQUE INDEX idx_audit_logs_chain_sequence ON audit_logs (sequence) WHERE sequence > 0`
+
+const importTestConfig = `{
+	"version": "1.0",
+	"policies": [
+		{"name": "allow-web", "action": "allow", "protocol": "tcp", "destination_cidr": "10.0.1.0/24"},
+		{"name": "deny-telnet", "action": "deny", "protocol": "tcp", "destination_cidr": "10.0.0.0/16"}
+	],
+	"users": [
+		{"id": "6f1c2a4e-8d3b-4b7a-9c1e-2f5d8a7b3c01", "username": "alice", "email": "alice@example.com", "role": "admin", "active": true},
+		{"id": "6f1c2a4e-8d3b-4b7a-9c1e-2f5d8a7b3c02", "username": "bob", "email": "bob@example.com", "role": "observer", "active": true}
+	]
+}`
+
+func TestImportConfigurationSharesJobID(t *testing.T) {
+	db := newImportTestDB(t)
+	auditService := NewAuditService(db)
+	service := NewConfigService(db, auditService)
+
+	importedBy := uuid.New()
+	result, err := service.ImportConfiguration(context.Background(), []byte(importTestConfig), "json", ImportOptions{
+		SkipNodes:  true,
+		ImportedBy: importedBy,
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v (%+v)", err, result)
+	}
+	if result.PoliciesImported != 2 || result.UsersImported != 2 {
+		t.Fatalf("expected 2 policies and 2 users imported, got %d and %d", result.PoliciesImported, result.UsersImported)
+	}
+	if result.JobID == uuid.Nil {
+		t.Fatal("expected import result to carry a job ID")
+	}
+
+	var logs []models.AuditLog
+	if err := db.Order("created_at").Find(&logs).Error; err != nil {
+		t.Fatalf("failed to load audit logs: %v", err)
+	}
+
+	// One entry per imported policy and user, plus the import summary
+	if len(logs) != 5 {
+		t.Fatalf("expected 5 audit entries, got %d", len(logs))
+	}
+	resources := map[string]int{}
+	for _, log := range logs {
+		if log.JobID == nil || *log.JobID != result.JobID {
+			t.Errorf("audit entry %q (%s) has job ID %v, want %s", log.Description, log.Resource, log.JobID, result.JobID)
+		}
+		if log.UserID == nil || *log.UserID != importedBy {
+			t.Errorf("audit entry %q has user %v, want %s", log.Description, log.UserID, importedBy)
+		}
+		resources[log.Resource]++
+	}
+	if resources["policy"] != 2 || resources["user"] != 2 || resources["configuration"] != 1 {
+		t.Errorf("unexpected audit entries per resource: %v", resources)
+	}
+
+	filtered, total, err := auditService.GetAuditLogs(context.Background(), 1, 50, map[string]interface{}{"job_id": result.JobID})
+	if err != nil {
+		t.Fatalf("failed to filter audit logs by job: %v", err)
+	}
+	if total != 5 || len(filtered) != 5 {
+		t.Errorf("expected job_id filter to return all 5 entries, got %d (total %d)", len(filtered), total)
+	}
+}
+
+func TestImportConfigurationJobIDsAreDistinct(t *testing.T) {
+	db := newImportTestDB(t)
+	auditService := NewAuditService(db)
+	service := NewConfigService(db, auditService)
+
+	options := ImportOptions{SkipNodes: true, OverwriteExisting: true, ImportedBy: uuid.New()}
+	first, err := service.ImportConfiguration(context.Background(), []byte(importTestConfig), "json", options)
+	if err != nil {
+		t.Fatalf("first import failed: %v", err)
+	}
+	second, err := service.ImportConfiguration(context.Background(), []byte(importTestConfig), "json", options)
+	if err != nil {
+		t.Fatalf("second import failed: %v", err)
+	}
+	if first.JobID == second.JobID {
+		t.Fatal("expected each import to get its own job ID")
+	}
+
+	// Actions outside of a job are not correlated with either import
+	auditService.LogAction(context.Background(), nil, models.AuditActionLogin, "auth", nil, "User logged in", "", "")
+
+	for _, jobID := range []uuid.UUID{first.JobID, second.JobID} {
+		var count int64
+		if err := db.Model(&models.AuditLog{}).Where("job_id = ?", jobID).Count(&count).Error; err != nil {
+			t.Fatalf("failed to count audit logs: %v", err)
+		}
+		if count != 5 {
+			t.Errorf("expected 5 audit entries for job %s, got %d", jobID, count)
+		}
+	}
+
+	var uncorrelated int64
+	db.Model(&models.AuditLog{}).Where("job_id IS NULL").Count(&uncorrelated)
+	if uncorrelated != 1 {
+		t.Errorf("expected 1 audit entry without a job ID, got %d", uncorrelated)
+	}
+}
+
+func TestImportConfigurationDryRunPersistsNothing(t *testing.T) {
+	db := newImportTestDB(t)
+	service := NewConfigService(db, NewAuditService(db))
+
+	result, err := service.ImportConfiguration(context.Background(), []byte(importTestConfig), "json", ImportOptions{
+		SkipNodes:  true,
+		DryRun:     true,
+		ImportedBy: uuid.New(),
+	})
+	if err != nil {
+		t.Fatalf("dry-run import failed: %v (%+v)", err, result)
+	}
+	if !result.Success || !result.DryRun {
+		t.Errorf("expected a successful dry-run result, got success=%v dry_run=%v", result.Success, result.DryRun)
+	}
+	if result.PoliciesImported != 2 || result.UsersImported != 2 {
+		t.Errorf("expected dry run to report 2 policies and 2 users, got %d and %d", result.PoliciesImported, result.UsersImported)
+	}
+	if len(result.TemporaryPasswords) != 0 {
+		t.Errorf("expected no temporary passwords from a dry run, got %d", len(result.TemporaryPasswords))
+	}
+
+	for _, model := range []interface{}{&models.Policy{}, &models.User{}, &models.AuditLog{}} {
+		var count int64
+		if err := db.Model(model).Count(&count).Error; err != nil {
+			t.Fatalf("failed to count %T: %v", model, err)
+		}
+		if count != 0 {
+			t.Errorf("expected dry run to leave no %T rows, found %d", model, count)
+		}
+	}
+}
+
+func TestImportConfigurationDryRunOverwriteCounts(t *testing.T) {
+	db := newImportTestDB(t)
+	service := NewConfigService(db, NewAuditService(db))
+
+	alice := models.User{Username: "alice", Email: "alice@example.com", Password: "x", Role: models.UserRoleObserver, IsActive: true}
+	if err := db.Create(&alice).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	// Without overwrite the existing user would be skipped
+	result, err := service.ImportConfiguration(context.Background(), []byte(importTestConfig), "json", ImportOptions{
+		SkipNodes:  true,
+		DryRun:     true,
+		ImportedBy: uuid.New(),
+	})
+	if err != nil {
+		t.Fatalf("dry-run import failed: %v", err)
+	}
+	if result.UsersImported != 1 || result.UsersSkipped != 1 {
+		t.Errorf("expected 1 user imported and 1 skipped, got %d and %d", result.UsersImported, result.UsersSkipped)
+	}
+
+	// With overwrite it would be updated
+	result, err = service.ImportConfiguration(context.Background(), []byte(importTestConfig), "json", ImportOptions{
+		SkipNodes:         true,
+		OverwriteExisting: true,
+		DryRun:            true,
+		ImportedBy:        uuid.New(),
+	})
+	if err != nil {
+		t.Fatalf("dry-run overwrite import failed: %v", err)
+	}
+	if result.UsersImported != 2 || result.UsersSkipped != 0 || result.PoliciesImported != 2 {
+		t.Errorf("expected 2 users and 2 policies imported, got %d users (%d skipped) and %d policies",
+			result.UsersImported, result.UsersSkipped, result.PoliciesImported)
+	}
+
+	var stored models.User
+	if err := db.First(&stored, "id = ?", alice.ID).Error; err != nil {
+		t.Fatalf("failed to load user: %v", err)
+	}
+	if stored.Role != models.UserRoleObserver {
+		t.Errorf("expected dry run to leave alice's role as observer, got %s", stored.Role)
+	}
+
+	var users, policies int64
+	db.Model(&models.User{}).Count(&users)
+	db.Model(&models.Policy{}).Count(&policies)
+	if users != 1 || policies != 0 {
+		t.Errorf("expected only the original user to remain, got %d users and %d policies", users, policies)
+	}
+}
+
+func TestImportConfigurationGivesUsersTemporaryPasswords(t *testing.T) {
+	db := newImportTestDB(t)
+	service := NewConfigService(db, NewAuditService(db))
+
+	result, err := service.ImportConfiguration(context.Background(), []byte(importTestConfig), "json", ImportOptions{
+		SkipNodes:  true,
+		ImportedBy: uuid.New(),
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v (%+v)", err, result)
+	}
+	if len(result.TemporaryPasswords) != 2 {
+		t.Fatalf("expected a temporary password for each imported user, got %v", result.TemporaryPasswords)
+	}
+	if result.TemporaryPasswords["alice"] == result.TemporaryPasswords["bob"] {
+		t.Error("expected each user to get a different temporary password")
+	}
+
+	var users []models.User
+	if err := db.Find(&users).Error; err != nil {
+		t.Fatalf("failed to load users: %v", err)
+	}
+	hashes := map[string]bool{}
+	for _, user := range users {
+		if !user.MustChangePassword {
+			t.Errorf("expected %s to be flagged for a password reset", user.Username)
+		}
+		if !user.CheckPassword(result.TemporaryPasswords[user.Username]) {
+			t.Errorf("expected %s's temporary password to match the stored hash", user.Username)
+		}
+		hashes[user.Password] = true
+	}
+	if len(hashes) != len(users) {
+		t.Error("expected imported users not to share a password hash")
+	}
+
+	// A second install importing the same file gets different credentials
+	other := newImportTestDB(t)
+	again, err := NewConfigService(other, NewAuditService(other)).ImportConfiguration(context.Background(), []byte(importTestConfig), "json", ImportOptions{
+		SkipNodes:  true,
+		ImportedBy: uuid.New(),
+	})
+	if err != nil {
+		t.Fatalf("second import failed: %v", err)
+	}
+	if again.TemporaryPasswords["alice"] == result.TemporaryPasswords["alice"] {
+		t.Error("expected a new temporary password on every import")
+	}
+}
+
+func TestExportConfigurationSections(t *testing.T) {
+	db := newImportTestDB(t)
+	service := NewConfigService(db, NewAuditService(db))
+	ctx := context.Background()
+
+	if _, err := service.ImportConfiguration(ctx, []byte(importTestConfig), "json", ImportOptions{ImportedBy: uuid.New()}); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	sections, err := ParseExportSections("policies")
+	if err != nil {
+		t.Fatalf("ParseExportSections failed: %v", err)
+	}
+	data, err := service.ExportConfiguration(ctx, uuid.New(), "json", sections)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("failed to decode export: %v", err)
+	}
+	if _, ok := fields["policies"]; !ok {
+		t.Error("expected the export to contain policies")
+	}
+	for _, section := range []string{"nodes", "users", "topology"} {
+		if _, ok := fields[section]; ok {
+			t.Errorf("expected the export to leave out %s", section)
+		}
+	}
+
+	// The partial export imports cleanly into an empty install
+	other := newImportTestDB(t)
+	otherService := NewConfigService(other, NewAuditService(other))
+	result, err := otherService.ImportConfiguration(ctx, data, "json", ImportOptions{ImportedBy: uuid.New()})
+	if err != nil {
+		t.Fatalf("import of partial export failed: %v (%+v)", err, result)
+	}
+	if result.PoliciesImported != 2 || result.UsersImported != 0 || result.NodesImported != 0 {
+		t.Errorf("expected only the 2 policies to be imported, got %+v", result)
+	}
+	if len(result.GeneralErrors) != 0 || len(result.NodesErrors) != 0 || len(result.UsersErrors) != 0 || len(result.PoliciesErrors) != 0 {
+		t.Errorf("expected no errors, got %+v", result)
+	}
+
+	// Compared with the source, the sections it leaves out are unchanged
+	diff, err := service.DiffConfiguration(ctx, data, "json")
+	if err != nil {
+		t.Fatalf("diff failed: %v", err)
+	}
+	if len(diff.Users.Removed) != 0 {
+		t.Errorf("expected users left out of the export not to show as removed, got %+v", diff.Users.Removed)
+	}
+	if len(diff.Policies.Added) != 0 || len(diff.Policies.Removed) != 0 || len(diff.Policies.Modified) != 0 {
+		t.Errorf("expected the exported policies to match, got %+v", diff.Policies)
+	}
+}
+
+func TestParseExportSections(t *testing.T) {
+	tests := []struct {
+		include string
+		want    []string
+	}{
+		{"", nil},
+		{"nodes", []string{ExportSectionNodes}},
+		{"topology, nodes", []string{ExportSectionNodes, ExportSectionTopology}},
+		{"nodes,nodes,policies", []string{ExportSectionNodes, ExportSectionPolicies}},
+		// Every section is the same as a full export
+		{"users,topology,policies,nodes", nil},
+	}
+	for _, tt := range tests {
+		got, err := ParseExportSections(tt.include)
+		if err != nil {
+			t.Errorf("ParseExportSections(%q) failed: %v", tt.include, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseExportSections(%q) = %v, want %v", tt.include, got, tt.want)
+		}
+	}
+
+	if _, err := ParseExportSections("nodes,passwords"); !errors.Is(err, ErrInvalidExportSection) {
+		t.Errorf("expected ErrInvalidExportSection, got %v", err)
+	}
+}
+
+func TestValidateConfigurationNodeAddresses(t *testing.T) {
+	service := NewConfigService(openTestDB(t), nil)
+	if err := service.SetSubnet("10.100.0.0/16"); err != nil {
+		t.Fatalf("SetSubnet failed: %v", err)
+	}
+
+	validate := func(nodes ...models.Node) []string {
+		t.Helper()
+		data, err := json.Marshal(ConfigExport{Version: "1.0", Nodes: nodes})
+		if err != nil {
+			t.Fatalf("failed to marshal export: %v", err)
+		}
+		warnings, err := service.ValidateConfiguration(context.Background(), data, "json")
+		if err != nil {
+			t.Fatalf("ValidateConfiguration failed: %v", err)
+		}
+		return warnings
+	}
+	node := func(name, publicKey, ip string) models.Node {
+		return models.Node{ID: uuid.New(), Name: name, PublicKey: publicKey, AllocatedIP: ip}
+	}
+
+	tests := []struct {
+		name  string
+		nodes []models.Node
+		want  []string
+	}{
+		{
+			name:  "valid",
+			nodes: []models.Node{node("hub-1", rotationHubKey, "10.100.0.1/16"), node("spoke-1", rotationSpokeKey, "10.100.1.2/16")},
+		},
+		{
+			name:  "outside subnet",
+			nodes: []models.Node{node("hub-1", rotationHubKey, "192.168.1.1/24")},
+			want:  []string{"Node hub-1 has allocated IP 192.168.1.1/24 outside the subnet 10.100.0.0/16"},
+		},
+		{
+			name:  "not a CIDR",
+			nodes: []models.Node{node("hub-1", rotationHubKey, "10.100.0.1")},
+			want:  []string{"Node hub-1 has invalid allocated IP 10.100.0.1, expected an address with a prefix length such as 10.100.0.2/16"},
+		},
+		{
+			name:  "colliding IPs",
+			nodes: []models.Node{node("hub-1", rotationHubKey, "10.100.0.1/16"), node("spoke-1", rotationSpokeKey, "10.100.0.1/24")},
+			want:  []string{"Node spoke-1 has allocated IP 10.100.0.1/24 already used by node hub-1"},
+		},
+		{
+			name:  "malformed key",
+			nodes: []models.Node{node("hub-1", "not-a-key", "10.100.0.1/16")},
+			want:  []string{"Node hub-1 has malformed public key, expected a base64 encoded 32-byte WireGuard key"},
+		},
+		{
+			// A node listed twice is one duplicate, not also a collision
+			name:  "duplicate node",
+			nodes: []models.Node{node("hub-1", rotationHubKey, "10.100.0.1/16"), node("hub-1", rotationHubKey, "10.100.0.1/16")},
+			want:  []string{"Duplicate node name: hub-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validate(tt.nodes...)
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("expected warnings %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	if err := service.SetSubnet("10.100.0.0"); err == nil {
+		t.Error("expected an invalid subnet to be rejected")
+	}
+}
+
+func TestExportConfigurationIncludesDescriptions(t *testing.T) {
+	nodeService, db := newKeyRotationTestService(t, 0)
+	addRegistrationColumns(t, db)
+	service := NewConfigService(db, NewAuditService(db))
+	ctx := context.Background()
+
+	if _, err := nodeService.RegisterNode(ctx, types.NodeRegistrationRequest{
+		Name:        "hub-1",
+		Description: "Frankfurt rack 4",
+		NodeType:    "hub",
+		PublicKey:   rotationHubKey,
+		Endpoint:    "hub-1.example.com",
+		Port:        51820,
+	}); err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+	policyService := NewPolicyService(db, NewAuditService(db))
+	if _, err := policyService.CreatePolicy(ctx, &PolicyRequest{Name: "isolate lab", Description: "Requested in SEC-118", Action: models.PolicyActionDeny, DestinationCIDR: "192.168.50.0/24"}, nil); err != nil {
+		t.Fatalf("CreatePolicy failed: %v", err)
+	}
+
+	for _, format := range []string{"json", "yaml"} {
+		data, err := service.ExportConfiguration(ctx, uuid.New(), format, []string{ExportSectionNodes, ExportSectionPolicies})
+		if err != nil {
+			t.Fatalf("%s export failed: %v", format, err)
+		}
+		export, err := parseConfigExport(data, format)
+		if err != nil {
+			t.Fatalf("failed to decode %s export: %v", format, err)
+		}
+		if len(export.Nodes) != 1 || export.Nodes[0].Description != "Frankfurt rack 4" {
+			t.Errorf("%s: expected the node description in the export, got %+v", format, export.Nodes)
+		}
+		if len(export.Policies) != 1 || export.Policies[0].Description != "Requested in SEC-118" {
+			t.Errorf("%s: expected the policy description in the export, got %+v", format, export.Policies)
+		}
+	}
+}
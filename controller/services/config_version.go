@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+var ErrConfigVersionNotFound = errors.New("configuration version not found")
+
+const (
+	ConfigVersionSourceImport   = "import"
+	ConfigVersionSourceManual   = "manual"
+	ConfigVersionSourceRollback = "rollback"
+)
+
+// ConfigVersion is a stored snapshot of the full configuration export.
+type ConfigVersion struct {
+	ID            uuid.UUID     `json:"id" gorm:"type:uuid;primary_key"`
+	Source        string        `json:"source" gorm:"not null"` // import, manual, rollback
+	Description   string        `json:"description"`
+	NodesCount    int           `json:"nodes_count"`
+	PoliciesCount int           `json:"policies_count"`
+	UsersCount    int           `json:"users_count"`
+	Snapshot      string        `json:"-" gorm:"type:text;not null"`
+	Config        *ConfigExport `json:"config,omitempty" gorm:"-"`
+	CreatedBy     uuid.UUID     `json:"created_by" gorm:"type:uuid"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+func (ConfigVersion) TableName() string {
+	return "config_versions"
+}
+
+// CreateVersion snapshots the live configuration on demand.
+func (s *ConfigService) CreateVersion(ctx context.Context, createdBy uuid.UUID, description string) (*ConfigVersion, error) {
+	version, err := s.createVersion(ctx, createdBy, ConfigVersionSourceManual, description)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditService.LogActionWithMetadata(ctx, &createdBy, models.AuditActionCreate, "config_version", &version.ID,
+		"Created configuration version", "", "",
+		map[string]interface{}{
+			"description":    description,
+			"nodes_count":    version.NodesCount,
+			"policies_count": version.PoliciesCount,
+			"users_count":    version.UsersCount,
+		})
+
+	return version, nil
+}
+
+func (s *ConfigService) createVersion(ctx context.Context, createdBy uuid.UUID, source, description string) (*ConfigVersion, error) {
+	export, err := s.buildConfigExport(ctx, createdBy, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := json.Marshal(export)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode configuration snapshot: %w", err)
+	}
+
+	version := &ConfigVersion{
+		ID:            uuid.New(),
+		Source:        source,
+		Description:   description,
+		NodesCount:    len(export.Nodes),
+		PoliciesCount: len(export.Policies),
+		UsersCount:    len(export.Users),
+		Snapshot:      string(snapshot),
+		CreatedBy:     createdBy,
+	}
+	if err := s.db.WithContext(ctx).Create(version).Error; err != nil {
+		return nil, fmt.Errorf("failed to save configuration version: %w", err)
+	}
+
+	return version, nil
+}
+
+// GetVersions lists versions newest first, without their snapshots.
+func (s *ConfigService) GetVersions(ctx context.Context, page, perPage int) ([]ConfigVersion, int64, error) {
+	var total int64
+	if err := s.db.WithContext(ctx).Model(&ConfigVersion{}).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count configuration versions: %w", err)
+	}
+
+	var versions []ConfigVersion
+	offset := (page - 1) * perPage
+	if err := s.db.WithContext(ctx).Omit("snapshot").Order("created_at DESC").Offset(offset).Limit(perPage).Find(&versions).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch configuration versions: %w", err)
+	}
+
+	return versions, total, nil
+}
+
+// GetVersion returns a version with its decoded snapshot.
+func (s *ConfigService) GetVersion(ctx context.Context, id uuid.UUID) (*ConfigVersion, error) {
+	var version ConfigVersion
+	if err := s.db.WithContext(ctx).Where("id = ?", id).First(&version).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrConfigVersionNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch configuration version: %w", err)
+	}
+
+	var config ConfigExport
+	if err := json.Unmarshal([]byte(version.Snapshot), &config); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration snapshot: %w", err)
+	}
+	version.Config = &config
+
+	return &version, nil
+}
+
+// RollbackToVersion re-imports a stored snapshot, overwriting existing
+// records. Records created after the snapshot are kept. The rolled back
+// configuration is recorded as a new version.
+func (s *ConfigService) RollbackToVersion(ctx context.Context, id uuid.UUID, rolledBackBy uuid.UUID) (*ImportResult, error) {
+	version, err := s.GetVersion(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	options := ImportOptions{
+		OverwriteExisting: true,
+		ImportedBy:        rolledBackBy,
+	}
+	result, err := s.importConfiguration(ctx, []byte(version.Snapshot), "json", options,
+		ConfigVersionSourceRollback, fmt.Sprintf("Rollback to version %s", id))
+	if err != nil {
+		return result, err
+	}
+
+	s.auditService.LogActionWithMetadata(ctx, &rolledBackBy, models.AuditActionUpdate, "config_version", &id,
+		fmt.Sprintf("Rolled back configuration to version %s", id), "", "",
+		map[string]interface{}{
+			"job_id":            result.JobID,
+			"nodes_imported":    result.NodesImported,
+			"policies_imported": result.PoliciesImported,
+			"users_imported":    result.UsersImported,
+		})
+
+	return result, nil
+}
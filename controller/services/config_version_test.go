@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+)
+
+func TestRollbackToConfigVersion(t *testing.T) {
+	db := newImportTestDB(t)
+	service := NewConfigService(db, NewAuditService(db))
+	ctx := context.Background()
+	adminID := uuid.New()
+
+	node := diffTestNode("hub-1", "10.100.0.1", 51820)
+	if err := db.Omit("AllowedIPs", "BackupHubIDs", "Routes", "PreUp", "PostUp", "PreDown", "PostDown").Create(&node).Error; err != nil {
+		t.Fatalf("failed to create node: %v", err)
+	}
+	policy := diffTestPolicy("allow-web", "tcp")
+	if err := db.Create(&policy).Error; err != nil {
+		t.Fatalf("failed to create policy: %v", err)
+	}
+	alice := models.User{Username: "alice", Email: "alice@example.com", Password: "x", Role: models.UserRoleAdmin, IsActive: true}
+	if err := db.Create(&alice).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	first, err := service.CreateVersion(ctx, adminID, "baseline")
+	if err != nil {
+		t.Fatalf("CreateVersion failed: %v", err)
+	}
+	if first.Source != ConfigVersionSourceManual || first.NodesCount != 1 || first.PoliciesCount != 1 || first.UsersCount != 1 {
+		t.Errorf("unexpected first version: %+v", first)
+	}
+
+	db.Model(&models.Node{}).Where("id = ?", node.ID).Update("endpoint", "moved.example.com")
+	db.Model(&models.Policy{}).Where("id = ?", policy.ID).Update("protocol", "udp")
+	db.Model(&models.User{}).Where("id = ?", alice.ID).Update("role", models.UserRoleObserver)
+
+	second, err := service.CreateVersion(ctx, adminID, "after changes")
+	if err != nil {
+		t.Fatalf("CreateVersion failed: %v", err)
+	}
+
+	db.Model(&models.Policy{}).Where("id = ?", policy.ID).Update("protocol", "icmp")
+
+	result, err := service.RollbackToVersion(ctx, first.ID, adminID)
+	if err != nil {
+		t.Fatalf("RollbackToVersion failed: %v (%+v)", err, result)
+	}
+	if result.NodesImported != 1 || result.PoliciesImported != 1 || result.UsersImported != 1 {
+		t.Errorf("expected one node, policy and user restored, got %+v", result)
+	}
+
+	var restoredNode models.Node
+	db.First(&restoredNode, "id = ?", node.ID)
+	if restoredNode.Endpoint != node.Endpoint {
+		t.Errorf("expected node endpoint %q after rollback, got %q", node.Endpoint, restoredNode.Endpoint)
+	}
+	var restoredPolicy models.Policy
+	db.First(&restoredPolicy, "id = ?", policy.ID)
+	if restoredPolicy.Protocol != "tcp" {
+		t.Errorf("expected policy protocol tcp after rollback, got %q", restoredPolicy.Protocol)
+	}
+	var restoredUser models.User
+	db.First(&restoredUser, "id = ?", alice.ID)
+	if restoredUser.Role != models.UserRoleAdmin {
+		t.Errorf("expected alice to be admin after rollback, got %s", restoredUser.Role)
+	}
+
+	// The rollback itself is recorded as the newest version
+	if result.VersionID == nil {
+		t.Fatal("expected rollback to record a configuration version")
+	}
+	versions, total, err := service.GetVersions(ctx, 1, 10)
+	if err != nil {
+		t.Fatalf("GetVersions failed: %v", err)
+	}
+	if total != 3 || len(versions) != 3 {
+		t.Fatalf("expected 3 versions, got %d (total %d)", len(versions), total)
+	}
+	if versions[0].ID != *result.VersionID || versions[0].Source != ConfigVersionSourceRollback {
+		t.Errorf("expected newest version to be the rollback, got %s (%s)", versions[0].ID, versions[0].Source)
+	}
+	if versions[1].ID != second.ID || versions[2].ID != first.ID {
+		t.Errorf("expected versions newest first")
+	}
+	if versions[2].Snapshot != "" {
+		t.Errorf("expected version list to omit snapshots")
+	}
+
+	stored, err := service.GetVersion(ctx, second.ID)
+	if err != nil {
+		t.Fatalf("GetVersion failed: %v", err)
+	}
+	if stored.Config == nil || len(stored.Config.Policies) != 1 || stored.Config.Policies[0].Protocol != "udp" {
+		t.Errorf("expected second version to hold the udp policy, got %+v", stored.Config)
+	}
+
+	var rollbackLogs int64
+	db.Model(&models.AuditLog{}).Where("resource = ? AND resource_id = ? AND action = ?",
+		"config_version", first.ID, models.AuditActionUpdate).Count(&rollbackLogs)
+	if rollbackLogs != 1 {
+		t.Errorf("expected the rollback to be audited against the version, got %d entries", rollbackLogs)
+	}
+}
+
+func TestImportConfigurationRecordsVersion(t *testing.T) {
+	db := newImportTestDB(t)
+	service := NewConfigService(db, NewAuditService(db))
+
+	result, err := service.ImportConfiguration(context.Background(), []byte(importTestConfig), "json", ImportOptions{
+		SkipNodes:  true,
+		ImportedBy: uuid.New(),
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if result.VersionID == nil {
+		t.Fatal("expected import to record a configuration version")
+	}
+
+	version, err := service.GetVersion(context.Background(), *result.VersionID)
+	if err != nil {
+		t.Fatalf("GetVersion failed: %v", err)
+	}
+	if version.Source != ConfigVersionSourceImport || version.PoliciesCount != 2 || version.UsersCount != 2 {
+		t.Errorf("unexpected import version: %+v", version)
+	}
+}
+
+func TestGetConfigVersionNotFound(t *testing.T) {
+	db := newImportTestDB(t)
+	service := NewConfigService(db, NewAuditService(db))
+
+	if _, err := service.GetVersion(context.Background(), uuid.New()); !errors.Is(err, ErrConfigVersionNotFound) {
+		t.Errorf("expected ErrConfigVersionNotFound, got %v", err)
+	}
+	if _, err := service.RollbackToVersion(context.Background(), uuid.New(), uuid.New()); !errors.Is(err, ErrConfigVersionNotFound) {
+		t.Errorf("expected ErrConfigVersionNotFound from rollback, got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression.
+type cronSchedule interface {
+	// Next returns the first activation time after after, or the zero time
+	// if there is none within the next five years.
+	Next(after time.Time) time.Time
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCronSchedule accepts standard five-field expressions (minute, hour,
+// day of month, month, day of week) with *, lists, ranges and steps, the
+// @daily style descriptors and "@every <duration>".
+func parseCronSchedule(spec string) (cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid interval in %q", spec)
+		}
+		return everySchedule{interval: interval}, nil
+	}
+
+	if expr, ok := cronDescriptors[spec]; ok {
+		spec = expr
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in %q, got %d", spec, len(fields))
+	}
+
+	var schedule cronFields
+	var err error
+	if schedule.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if schedule.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if schedule.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if schedule.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if schedule.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+
+	// Both 0 and 7 mean Sunday
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+	schedule.domAny = strings.HasPrefix(fields[2], "*")
+	schedule.dowAny = strings.HasPrefix(fields[4], "*")
+
+	return &schedule, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		valueRange, step := part, 1
+		hasStep := false
+		if i := strings.Index(part, "/"); i >= 0 {
+			valueRange = part[:i]
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step, hasStep = n, true
+		}
+
+		low, high := min, max
+		switch {
+		case valueRange == "*":
+		case strings.Contains(valueRange, "-"):
+			bounds := strings.SplitN(valueRange, "-", 2)
+			var err1, err2 error
+			low, err1 = strconv.Atoi(bounds[0])
+			high, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", valueRange)
+			}
+		default:
+			n, err := strconv.Atoi(valueRange)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", valueRange)
+			}
+			low = n
+			if !hasStep {
+				high = n
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s everySchedule) Next(after time.Time) time.Time {
+	return after.Add(s.interval)
+}
+
+type cronFields struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a day field starting with "*". When both are
+	// restricted, a day matching either one fires, as in standard cron.
+	domAny, dowAny bool
+}
+
+func (s *cronFields) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (s *cronFields) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Advisory lock keys for work that controllers sharing a database must take
+// turns at.
+const (
+	nodeAllocationLock int64 = 0x7767_0001
+	auditChainLock     int64 = 0x7767_0002
+)
+
+// lockTx takes a Postgres advisory lock held until tx ends, so the same
+// work on another controller waits for this transaction. Other databases
+// have a single writer and are left alone.
+func lockTx(tx *gorm.DB, key int64) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", key).Error
+}
+
+// isUniqueViolation reports whether err is a unique constraint failure from
+// Postgres (SQLSTATE 23505) or SQLite.
+func isUniqueViolation(err error) bool {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		return state.SQLState() == "23505"
+	}
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+var ErrEmailNotConfigured = errors.New("email delivery is not configured")
+
+// EmailService sends plain-text email through the configured SMTP relay.
+// net/smtp upgrades to STARTTLS whenever the relay offers it.
+type EmailService struct {
+	config *types.EmailConfig
+	// sendMail is replaced in tests
+	sendMail func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewEmailService(config *types.Config) *EmailService {
+	return &EmailService{
+		config:   &config.Email,
+		sendMail: smtp.SendMail,
+	}
+}
+
+// Enabled reports whether an SMTP relay is configured.
+func (s *EmailService) Enabled() bool {
+	return s != nil && s.config.SMTPHost != ""
+}
+
+// Send delivers a plain-text message to a single recipient.
+func (s *EmailService) Send(to, subject, body string) error {
+	if !s.Enabled() {
+		return ErrEmailNotConfigured
+	}
+	// Header injection through the address or subject
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+
+	port := s.config.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(s.config.SMTPHost, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if s.config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", s.config.SMTPUsername, s.config.SMTPPassword, s.config.SMTPHost)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	if err := s.sendMail(addr, auth, s.config.From, []string{to}, []byte(msg.String())); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
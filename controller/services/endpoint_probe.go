@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+)
+
+var ErrEndpointUnreachable = errors.New("node endpoint is unreachable")
+
+const defaultEndpointProbeTimeout = 3 * time.Second
+
+// EndpointProber checks that a node's WireGuard endpoint can be reached
+// before the node is made active, so a peer that can never answer does not
+// end up as one side of a silent one-way tunnel.
+type EndpointProber interface {
+	Probe(ctx context.Context, endpoint string) error
+}
+
+// UDPProber sends a single datagram to the endpoint and waits for a reply.
+// WireGuard drops unauthenticated packets without answering, so silence is
+// taken as reachable; the probe fails when the host does not resolve or an
+// ICMP port unreachable comes back, meaning nothing listens there.
+type UDPProber struct {
+	timeout time.Duration
+}
+
+func NewUDPProber(timeout time.Duration) *UDPProber {
+	if timeout <= 0 {
+		timeout = defaultEndpointProbeTimeout
+	}
+	return &UDPProber{timeout: timeout}
+}
+
+func (p *UDPProber) Probe(ctx context.Context, endpoint string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", endpoint)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte{0}); err != nil {
+		return err
+	}
+	// An ICMP error is reported on the next read on the socket
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return fmt.Errorf("nothing is listening on %s", endpoint)
+		}
+		return err
+	}
+	return nil
+}
+
+// SetEndpointProber makes nodes with an endpoint pass prober's check before
+// moving to active. Without one, nodes are activated unchecked.
+func (s *NodeService) SetEndpointProber(prober EndpointProber) {
+	s.prober = prober
+}
+
+// probeEndpoints probes every node in nodes that has an endpoint and records
+// the outcome on it. NAT-only nodes without a public endpoint are skipped,
+// since nothing can reach them first. It returns ErrEndpointUnreachable
+// naming the nodes that failed.
+func (s *NodeService) probeEndpoints(ctx context.Context, nodes []models.Node) error {
+	if s.prober == nil {
+		return nil
+	}
+
+	var unreachable []string
+	for i := range nodes {
+		node := &nodes[i]
+		if node.Endpoint == "" {
+			continue
+		}
+
+		probeErr := s.prober.Probe(ctx, node.GetEndpoint())
+		result := ""
+		if probeErr != nil {
+			result = probeErr.Error()
+			unreachable = append(unreachable, fmt.Sprintf("%s (%s): %s", node.Name, node.GetEndpoint(), result))
+		}
+		if err := s.db.WithContext(ctx).Model(&models.Node{}).Where("id = ?", node.ID).UpdateColumns(map[string]interface{}{
+			"endpoint_probed_at":   time.Now(),
+			"endpoint_probe_error": result,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to record endpoint probe for node %s: %w", node.Name, err)
+		}
+	}
+
+	if len(unreachable) > 0 {
+		return fmt.Errorf("%w: %s", ErrEndpointUnreachable, strings.Join(unreachable, "; "))
+	}
+	return nil
+}
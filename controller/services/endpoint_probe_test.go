@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+// stubProber fails the endpoints in unreachable and records every probe.
+type stubProber struct {
+	unreachable map[string]bool
+	probed      []string
+}
+
+func (p *stubProber) Probe(ctx context.Context, endpoint string) error {
+	p.probed = append(p.probed, endpoint)
+	if p.unreachable[endpoint] {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+// insertInactiveNode adds a spoke that is waiting to be activated.
+func insertInactiveNode(t *testing.T, db *gorm.DB, name, endpoint string) uuid.UUID {
+	t.Helper()
+
+	id := insertRotationTestNode(t, db, name, "spoke", name+"-key", "10.100.1.9/16", time.Now())
+	if err := db.Exec("UPDATE nodes SET status = ?, endpoint = ? WHERE id = ?", models.NodeStatusInactive, endpoint, id).Error; err != nil {
+		t.Fatalf("failed to update %s: %v", name, err)
+	}
+	return id
+}
+
+func probeResult(t *testing.T, db *gorm.DB, id uuid.UUID) models.Node {
+	t.Helper()
+
+	var node models.Node
+	if err := db.Select("status", "endpoint_probed_at", "endpoint_probe_error").Where("id = ?", id).First(&node).Error; err != nil {
+		t.Fatalf("failed to load node: %v", err)
+	}
+	return node
+}
+
+func TestUpdateNodeProbesEndpointBeforeActivating(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	prober := &stubProber{unreachable: map[string]bool{"198.51.100.7:51820": true}}
+	service.SetEndpointProber(prober)
+	ctx := context.Background()
+	active := string(models.NodeStatusActive)
+
+	reachable := insertInactiveNode(t, db, "spoke-reachable", "203.0.113.1")
+	if _, err := service.UpdateNode(ctx, reachable, types.NodeUpdateRequest{Status: &active}); err != nil {
+		t.Fatalf("expected a reachable node to be activated, got %v", err)
+	}
+	if node := probeResult(t, db, reachable); node.Status != models.NodeStatusActive || node.EndpointProbedAt == nil || node.EndpointProbeError != "" {
+		t.Errorf("expected a successful probe to be recorded, got %+v", node)
+	}
+
+	unreachable := insertInactiveNode(t, db, "spoke-unreachable", "198.51.100.7")
+	_, err := service.UpdateNode(ctx, unreachable, types.NodeUpdateRequest{Status: &active})
+	if !errors.Is(err, ErrEndpointUnreachable) || !strings.Contains(err.Error(), "spoke-unreachable") {
+		t.Fatalf("expected ErrEndpointUnreachable naming the node, got %v", err)
+	}
+	if node := probeResult(t, db, unreachable); node.Status != models.NodeStatusInactive || node.EndpointProbedAt == nil || node.EndpointProbeError != "connection refused" {
+		t.Errorf("expected the node to stay inactive with the failure recorded, got %+v", node)
+	}
+
+	// A fixed endpoint in the same request is what gets probed
+	fixed := "203.0.113.1"
+	if _, err := service.UpdateNode(ctx, unreachable, types.NodeUpdateRequest{Status: &active, Endpoint: &fixed}); err != nil {
+		t.Fatalf("expected the corrected endpoint to pass, got %v", err)
+	}
+
+	// NAT-only nodes have nothing to probe, and active nodes are not
+	// probed again on every heartbeat
+	natOnly := insertInactiveNode(t, db, "spoke-nat", "")
+	prober.probed = nil
+	for _, id := range []uuid.UUID{natOnly, reachable} {
+		if _, err := service.UpdateNode(ctx, id, types.NodeUpdateRequest{Status: &active}); err != nil {
+			t.Fatalf("UpdateNode failed: %v", err)
+		}
+	}
+	if len(prober.probed) != 0 {
+		t.Errorf("expected no probes, got %v", prober.probed)
+	}
+	if node := probeResult(t, db, natOnly); node.Status != models.NodeStatusActive || node.EndpointProbedAt != nil {
+		t.Errorf("expected the NAT-only node to be activated unprobed, got %+v", node)
+	}
+}
+
+func TestUpdateNodeStatusesProbesEndpoints(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	service.SetEndpointProber(&stubProber{unreachable: map[string]bool{"198.51.100.7:51820": true}})
+	ctx := context.Background()
+
+	reachable := insertInactiveNode(t, db, "spoke-reachable", "203.0.113.1")
+	unreachable := insertInactiveNode(t, db, "spoke-unreachable", "198.51.100.7")
+
+	_, err := service.UpdateNodeStatuses(ctx, []uuid.UUID{reachable, unreachable}, models.NodeStatusActive, nil)
+	if !errors.Is(err, ErrEndpointUnreachable) {
+		t.Fatalf("expected ErrEndpointUnreachable, got %v", err)
+	}
+	for _, id := range []uuid.UUID{reachable, unreachable} {
+		if node := probeResult(t, db, id); node.Status != models.NodeStatusInactive || node.EndpointProbedAt == nil {
+			t.Errorf("expected the batch to be rejected with each probe recorded, got %+v", node)
+		}
+	}
+
+	// Disabling needs no probe
+	if _, err := service.UpdateNodeStatuses(ctx, []uuid.UUID{reachable, unreachable}, models.NodeStatusDisabled, nil); err != nil {
+		t.Errorf("expected nodes to be disabled regardless of reachability, got %v", err)
+	}
+}
+
+func TestUDPProber(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	prober := NewUDPProber(200 * time.Millisecond)
+	if err := prober.Probe(context.Background(), listener.LocalAddr().String()); err != nil {
+		t.Errorf("expected a silent listener to count as reachable, got %v", err)
+	}
+
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	endpoint := closed.LocalAddr().String()
+	closed.Close()
+	if err := prober.Probe(context.Background(), endpoint); err == nil {
+		t.Error("expected a closed port to be unreachable")
+	}
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+// Optional subsystems that can be switched off per deployment.
+const (
+	FeatureHA            = "ha"
+	FeatureBackups       = "backups"
+	FeatureRemoteBackups = "remote_backups"
+	FeatureMesh          = "mesh"
+	FeatureEndpointProbe = "endpoint_probe"
+	FeatureConfigHistory = "config_history"
+)
+
+type FeatureService struct {
+	features map[string]bool
+}
+
+func NewFeatureService(config *types.Config) *FeatureService {
+	return &FeatureService{
+		features: map[string]bool{
+			FeatureHA:            config.HA.Enabled,
+			FeatureBackups:       config.Features.Backups,
+			FeatureRemoteBackups: config.Features.Backups && config.Features.RemoteBackups,
+			FeatureMesh:          config.Features.Mesh,
+			FeatureEndpointProbe: config.Features.EndpointProbe,
+			FeatureConfigHistory: config.Features.ConfigHistory,
+		},
+	}
+}
+
+// IsEnabled reports whether the named feature is on. Unknown features are
+// treated as disabled.
+func (s *FeatureService) IsEnabled(name string) bool {
+	return s.features[name]
+}
+
+// GetFeatures returns a copy of every known feature and whether it is on.
+func (s *FeatureService) GetFeatures() map[string]bool {
+	features := make(map[string]bool, len(s.features))
+	for name, enabled := range s.features {
+		features[name] = enabled
+	}
+	return features
+}
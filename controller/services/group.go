@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNodeGroupNotFound  = errors.New("node group not found")
+	ErrNodeGroupExists    = errors.New("node group already exists")
+	ErrNodeGroupInUse     = errors.New("node group is referenced by a policy")
+	ErrInvalidGroupMember = errors.New("only existing spoke nodes can join a node group")
+)
+
+// NodeGroupRequest creates or replaces a node group's settings. Membership
+// is managed separately.
+type NodeGroupRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	Mesh        bool   `json:"mesh"`
+}
+
+type NodeGroupService struct {
+	db           *gorm.DB
+	auditService *AuditService
+}
+
+func NewNodeGroupService(db *gorm.DB, auditService *AuditService) *NodeGroupService {
+	return &NodeGroupService{
+		db:           db,
+		auditService: auditService,
+	}
+}
+
+func (s *NodeGroupService) CreateGroup(ctx context.Context, req *NodeGroupRequest, performedBy *uuid.UUID) (*models.NodeGroup, error) {
+	if err := validateText(req.Name, req.Description); err != nil {
+		return nil, err
+	}
+	if err := s.checkNameFree(req.Name, uuid.Nil); err != nil {
+		return nil, err
+	}
+
+	group := &models.NodeGroup{
+		Name:        req.Name,
+		Description: req.Description,
+		Mesh:        req.Mesh,
+	}
+	if err := s.db.Create(group).Error; err != nil {
+		return nil, fmt.Errorf("failed to create node group: %w", err)
+	}
+
+	s.auditService.LogAction(ctx, performedBy, models.AuditActionCreate, "node_group", &group.ID,
+		fmt.Sprintf("Node group %s created", group.Name), "", "")
+
+	return group, nil
+}
+
+func (s *NodeGroupService) GetGroups(ctx context.Context) ([]models.NodeGroup, error) {
+	var groups []models.NodeGroup
+	if err := s.db.Order("name").Find(&groups).Error; err != nil {
+		return nil, fmt.Errorf("failed to get node groups: %w", err)
+	}
+	return groups, nil
+}
+
+// GetGroup returns the group with its member nodes.
+func (s *NodeGroupService) GetGroup(ctx context.Context, id uuid.UUID) (*models.NodeGroup, error) {
+	group, err := s.getGroup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	members := s.db.Model(&models.NodeGroupMember{}).Select("node_id").Where("group_id = ?", id)
+	if err := s.db.Where("id IN (?)", members).Order("name").Find(&group.Members).Error; err != nil {
+		return nil, fmt.Errorf("failed to get node group members: %w", err)
+	}
+
+	return group, nil
+}
+
+func (s *NodeGroupService) UpdateGroup(ctx context.Context, id uuid.UUID, req *NodeGroupRequest, performedBy *uuid.UUID) (*models.NodeGroup, error) {
+	group, err := s.getGroup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateText(req.Name, req.Description); err != nil {
+		return nil, err
+	}
+	if err := s.checkNameFree(req.Name, id); err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{
+		"name":        req.Name,
+		"description": req.Description,
+		"mesh":        req.Mesh,
+	}
+	if err := s.db.Model(group).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update node group: %w", err)
+	}
+
+	s.auditService.LogAction(ctx, performedBy, models.AuditActionUpdate, "node_group", &group.ID,
+		fmt.Sprintf("Node group %s updated", group.Name), "", "")
+
+	return s.GetGroup(ctx, id)
+}
+
+// DeleteGroup removes a group and its memberships. Groups that policies
+// still select are kept, since deleting them would silently change what
+// those policies match.
+func (s *NodeGroupService) DeleteGroup(ctx context.Context, id uuid.UUID, performedBy *uuid.UUID) error {
+	group, err := s.getGroup(id)
+	if err != nil {
+		return err
+	}
+
+	var policies int64
+	if err := s.db.Model(&models.Policy{}).Where("source_group_id = ? OR destination_group_id = ?", id, id).Count(&policies).Error; err != nil {
+		return fmt.Errorf("failed to get policies: %w", err)
+	}
+	if policies > 0 {
+		return ErrNodeGroupInUse
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", id).Delete(&models.NodeGroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(group).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete node group: %w", err)
+	}
+
+	s.auditService.LogAction(ctx, performedBy, models.AuditActionDelete, "node_group", &group.ID,
+		fmt.Sprintf("Node group %s deleted", group.Name), "", "")
+
+	return nil
+}
+
+// AddMember assigns a spoke to the group. Adding a current member is a no-op.
+func (s *NodeGroupService) AddMember(ctx context.Context, groupID, nodeID uuid.UUID, performedBy *uuid.UUID) error {
+	group, err := s.getGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	var node models.Node
+	if err := s.db.Where("id = ?", nodeID).First(&node).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidGroupMember
+		}
+		return fmt.Errorf("failed to get node: %w", err)
+	}
+	if !node.IsSpoke() {
+		return ErrInvalidGroupMember
+	}
+
+	member := &models.NodeGroupMember{GroupID: groupID, NodeID: nodeID}
+	result := s.db.Where(member).FirstOrCreate(member)
+	if result.Error != nil {
+		return fmt.Errorf("failed to add node group member: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	s.auditService.LogAction(ctx, performedBy, models.AuditActionUpdate, "node_group", &group.ID,
+		fmt.Sprintf("Node %s added to node group %s", node.Name, group.Name), "", "")
+
+	return nil
+}
+
+func (s *NodeGroupService) RemoveMember(ctx context.Context, groupID, nodeID uuid.UUID, performedBy *uuid.UUID) error {
+	group, err := s.getGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	result := s.db.Where("group_id = ? AND node_id = ?", groupID, nodeID).Delete(&models.NodeGroupMember{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to remove node group member: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNodeNotFound
+	}
+
+	s.auditService.LogAction(ctx, performedBy, models.AuditActionUpdate, "node_group", &group.ID,
+		fmt.Sprintf("Node %s removed from node group %s", nodeID, group.Name), "", "")
+
+	return nil
+}
+
+func (s *NodeGroupService) getGroup(id uuid.UUID) (*models.NodeGroup, error) {
+	var group models.NodeGroup
+	if err := s.db.Where("id = ?", id).First(&group).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNodeGroupNotFound
+		}
+		return nil, fmt.Errorf("failed to get node group: %w", err)
+	}
+	return &group, nil
+}
+
+func (s *NodeGroupService) checkNameFree(name string, id uuid.UUID) error {
+	var count int64
+	if err := s.db.Model(&models.NodeGroup{}).Where("name = ? AND id <> ?", name, id).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check node group name: %w", err)
+	}
+	if count > 0 {
+		return ErrNodeGroupExists
+	}
+	return nil
+}
+
+// meshPeers returns the active spokes that share a mesh group with node.
+func meshPeers(db *gorm.DB, node *models.Node) ([]models.Node, error) {
+	var peers []models.Node
+	err := db.Where("nodes.id <> ? AND nodes.status = ? AND nodes.node_type = ?", node.ID, models.NodeStatusActive, models.NodeTypeSpoke).
+		Where("nodes.id IN (?)", db.Table("node_group_members m").
+			Select("m.node_id").
+			Joins("JOIN node_groups g ON g.id = m.group_id AND g.deleted_at IS NULL").
+			Where("g.mesh = ? AND m.group_id IN (?)", true,
+				db.Table("node_group_members").Select("group_id").Where("node_id = ?", node.ID))).
+		Order("nodes.created_at").
+		Find(&peers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mesh peers: %w", err)
+	}
+	return peers, nil
+}
+
+// groupMembers maps each of the given groups to the set of its member nodes.
+func groupMembers(db *gorm.DB, groupIDs []uuid.UUID) (map[uuid.UUID]map[uuid.UUID]bool, error) {
+	members := make(map[uuid.UUID]map[uuid.UUID]bool, len(groupIDs))
+	if len(groupIDs) == 0 {
+		return members, nil
+	}
+
+	var rows []models.NodeGroupMember
+	if err := db.Where("group_id IN ?", groupIDs).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to get node group members: %w", err)
+	}
+	for _, row := range rows {
+		if members[row.GroupID] == nil {
+			members[row.GroupID] = make(map[uuid.UUID]bool)
+		}
+		members[row.GroupID][row.NodeID] = true
+	}
+	return members, nil
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+func createGroupTestTables(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	for _, stmt := range []string{
+		`CREATE TABLE node_groups (
+			id TEXT PRIMARY KEY, name TEXT NOT NULL UNIQUE, description TEXT, mesh BOOLEAN,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE node_group_members (
+			group_id TEXT NOT NULL, node_id TEXT NOT NULL, created_at DATETIME,
+			PRIMARY KEY (group_id, node_id))`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create test schema: %v", err)
+		}
+	}
+}
+
+func newTestGroup(t *testing.T, groups *NodeGroupService, name string, mesh bool, members ...uuid.UUID) uuid.UUID {
+	t.Helper()
+
+	ctx := context.Background()
+	group, err := groups.CreateGroup(ctx, &NodeGroupRequest{Name: name, Mesh: mesh}, nil)
+	if err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+	for _, nodeID := range members {
+		if err := groups.AddMember(ctx, group.ID, nodeID, nil); err != nil {
+			t.Fatalf("AddMember failed: %v", err)
+		}
+	}
+	return group.ID
+}
+
+func TestMeshGroupPeering(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	createGroupTestTables(t, db)
+	hubID, spokeID := newRotationTopology(t, db)
+	sameGroupID := insertRotationTestNode(t, db, "spoke-2", "spoke", "spoke-2-key", "10.100.1.3/16", time.Now())
+	otherGroupID := insertRotationTestNode(t, db, "spoke-3", "spoke", "spoke-3-key", "10.100.1.4/16", time.Now())
+	for _, id := range []uuid.UUID{sameGroupID, otherGroupID} {
+		if err := db.Exec("INSERT INTO topology (id, hub_id, spoke_id) VALUES (?, ?, ?)", uuid.New(), hubID, id).Error; err != nil {
+			t.Fatalf("failed to link spoke to hub: %v", err)
+		}
+	}
+	groups := NewNodeGroupService(db, NewAuditService(db))
+	newTestGroup(t, groups, "branch", true, spokeID, sameGroupID)
+	newTestGroup(t, groups, "lab", true, otherGroupID)
+	ctx := context.Background()
+
+	config, err := service.GetNodeConfig(ctx, spokeID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if keys := peerKeys(config); len(keys) != 1 || keys[0] != rotationHubKey {
+		t.Fatalf("expected only the hub while the mesh feature is off, got %v", keys)
+	}
+
+	service.config.Features.Mesh = true
+
+	config, err = service.GetNodeConfig(ctx, spokeID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if keys := peerKeys(config); len(keys) != 2 || keys[0] != rotationHubKey || keys[1] != "spoke-2-key" {
+		t.Fatalf("expected the hub and the spoke in the same group, got %v", keys)
+	}
+	direct := config.Peers[1]
+	if len(direct.AllowedIPs) != 1 || direct.AllowedIPs[0] != "10.100.1.3/32" || direct.Endpoint != "203.0.113.1:51820" {
+		t.Errorf("expected a direct peer for spoke-2, got %+v", direct)
+	}
+
+	config, err = service.GetNodeConfig(ctx, otherGroupID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if keys := peerKeys(config); len(keys) != 1 || keys[0] != rotationHubKey {
+		t.Errorf("expected a spoke in another group to only peer with the hub, got %v", keys)
+	}
+
+	// Denying a group cuts its members out of what goes through the hub
+	policyService := NewPolicyService(db, NewAuditService(db))
+	quarantineID := newTestGroup(t, groups, "quarantine", false, otherGroupID)
+	if _, err := policyService.CreatePolicy(ctx, &PolicyRequest{
+		Name:               "isolate quarantine",
+		DestinationGroupID: &quarantineID,
+		Action:             models.PolicyActionDeny,
+	}, nil); err != nil {
+		t.Fatalf("CreatePolicy failed: %v", err)
+	}
+
+	config, err = service.GetNodeConfig(ctx, spokeID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	allowed := config.Peers[0].AllowedIPs
+	if routedThrough(allowed, "10.100.1.4") || !routedThrough(allowed, "10.100.0.1") {
+		t.Errorf("expected the quarantined spoke cut out of the hub peer, got %v", allowed)
+	}
+
+	if err := groups.DeleteGroup(ctx, quarantineID, nil); !errors.Is(err, ErrNodeGroupInUse) {
+		t.Errorf("expected ErrNodeGroupInUse for a group a policy selects, got %v", err)
+	}
+}
+
+func TestNodeGroupMembership(t *testing.T) {
+	_, db := newKeyRotationTestService(t, 0)
+	createGroupTestTables(t, db)
+	hubID, spokeID := newRotationTopology(t, db)
+	groups := NewNodeGroupService(db, NewAuditService(db))
+	groupID := newTestGroup(t, groups, "branch", true)
+	ctx := context.Background()
+
+	if _, err := groups.CreateGroup(ctx, &NodeGroupRequest{Name: "branch"}, nil); !errors.Is(err, ErrNodeGroupExists) {
+		t.Errorf("expected ErrNodeGroupExists, got %v", err)
+	}
+	for _, nodeID := range []uuid.UUID{hubID, uuid.New()} {
+		if err := groups.AddMember(ctx, groupID, nodeID, nil); !errors.Is(err, ErrInvalidGroupMember) {
+			t.Errorf("expected ErrInvalidGroupMember, got %v", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := groups.AddMember(ctx, groupID, spokeID, nil); err != nil {
+			t.Fatalf("AddMember failed: %v", err)
+		}
+	}
+	group, err := groups.GetGroup(ctx, groupID)
+	if err != nil {
+		t.Fatalf("GetGroup failed: %v", err)
+	}
+	if len(group.Members) != 1 || group.Members[0].ID != spokeID {
+		t.Errorf("expected spoke-1 as the only member, got %+v", group.Members)
+	}
+
+	if err := groups.RemoveMember(ctx, groupID, spokeID, nil); err != nil {
+		t.Fatalf("RemoveMember failed: %v", err)
+	}
+	if err := groups.DeleteGroup(ctx, groupID, nil); err != nil {
+		t.Fatalf("DeleteGroup failed: %v", err)
+	}
+	if _, err := groups.GetGroup(ctx, groupID); !errors.Is(err, ErrNodeGroupNotFound) {
+		t.Errorf("expected ErrNodeGroupNotFound after delete, got %v", err)
+	}
+}
@@ -1,11 +1,18 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -22,11 +29,36 @@ type HAService struct {
 	nodeID       string
 	clusterID    string
 	isLeader     bool
+	term         int64
+	votedFor     string
 	peerNodes    map[string]*PeerNode
 	mutex        sync.RWMutex
 	leaderChan   chan bool
 	healthTicker *time.Ticker
 	httpClient   *http.Client
+	resolver     peerResolver
+
+	// Names the node whose client certificate was presented, so requests
+	// proxied to the leader keep the agent's identity
+	authenticateClientCert func(*tls.ConnectionState) (uuid.UUID, error)
+
+	// Background goroutines; Stop signals them and waits for them to exit
+	cancel   context.CancelFunc
+	stopped  chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// peerResolver is the subset of *net.Resolver used for DNS peer discovery.
+type peerResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// peerEndpoint is an address at which DNS says a controller is listening.
+type peerEndpoint struct {
+	Address string
+	Port    int
 }
 
 type PeerNode struct {
@@ -37,14 +69,23 @@ type PeerNode struct {
 	LastSeen time.Time `json:"last_seen"`
 	IsLeader bool      `json:"is_leader"`
 	Version  string    `json:"version"`
+	// Round trip of the last health check, 0 if it failed
+	Latency float64 `json:"latency_ms"`
+	// How long ago LastSeen was, filled in by GetClusterStatus
+	SecondsSinceLastSeen int64  `json:"seconds_since_last_seen"`
+	LastSeenAgo          string `json:"last_seen_ago"`
 }
 
 type ClusterStatus struct {
-	ClusterID    string               `json:"cluster_id"`
-	Leader       string               `json:"leader"`
-	Nodes        map[string]*PeerNode `json:"nodes"`
-	Healthy      bool                 `json:"healthy"`
-	LastElection time.Time            `json:"last_election"`
+	ClusterID string               `json:"cluster_id"`
+	Leader    string               `json:"leader"`
+	Nodes     map[string]*PeerNode `json:"nodes"`
+	Healthy   bool                 `json:"healthy"`
+	// Degraded is set when there is no leader or a peer is unhealthy, unseen
+	// for two heartbeats or slower than HA_SLOW_PEER_MS to answer
+	Degraded        bool      `json:"degraded"`
+	DegradedReasons []string  `json:"degraded_reasons,omitempty"`
+	LastElection    time.Time `json:"last_election"`
 }
 
 type HealthResponse struct {
@@ -70,6 +111,20 @@ type LeaderElectionResponse struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// HAElectionState is the election state a controller must not forget across
+// restarts: the latest term it has seen and whom it voted for in that term.
+type HAElectionState struct {
+	NodeID    string    `json:"node_id" gorm:"primaryKey"`
+	ClusterID string    `json:"cluster_id"`
+	Term      int64     `json:"term" gorm:"not null"`
+	VotedFor  string    `json:"voted_for"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (HAElectionState) TableName() string {
+	return "ha_election_state"
+}
+
 func NewHAService(db *gorm.DB, config *types.Config) *HAService {
 	nodeID := uuid.New().String()
 	if config.HA.NodeID != "" {
@@ -84,44 +139,100 @@ func NewHAService(db *gorm.DB, config *types.Config) *HAService {
 		isLeader:  false,
 		peerNodes: make(map[string]*PeerNode),
 		leaderChan: make(chan bool, 1),
-		httpClient: &http.Client{
-			Timeout: 5 * time.Second,
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			},
-		},
+		stopped:    make(chan struct{}),
+		httpClient: newPeerHTTPClient(config.Server.TLS),
+		resolver:   net.DefaultResolver,
 	}
 }
 
+// SetClientCertificateAuthenticator sets how the node named by a client
+// certificate is found, normally AuthService.AuthenticateNodeCertificate.
+// Without one, agents that authenticate with a certificate alone cannot
+// write through a follower.
+func (s *HAService) SetClientCertificateAuthenticator(authenticate func(*tls.ConnectionState) (uuid.UUID, error)) {
+	s.authenticateClientCert = authenticate
+}
+
 func (s *HAService) Start(ctx context.Context) error {
 	if !s.config.HA.Enabled {
-		fmt.Println("HA not enabled, running in single node mode")
+		slog.InfoContext(ctx, "HA not enabled, running in single node mode")
 		s.isLeader = true
 		return nil
 	}
 
-	fmt.Printf("Starting HA service for node %s in cluster %s\n", s.nodeID, s.clusterID)
+	// Election and leader requests are only trusted when signed
+	if s.config.HA.PeerSecret == "" {
+		return ErrPeerSecretMissing
+	}
+
+	slog.InfoContext(ctx, "Starting HA service", "node_id", s.nodeID, "cluster_id", s.clusterID)
+
+	if err := s.loadElectionState(); err != nil {
+		return err
+	}
 
 	// Start health check ticker
 	s.healthTicker = time.NewTicker(s.config.HA.HeartbeatInterval)
 
+	ctx, s.cancel = context.WithCancel(ctx)
+
 	// Start peer discovery
-	go s.startPeerDiscovery(ctx)
+	s.goBackground(func() { s.startPeerDiscovery(ctx) })
 
 	// Start health monitoring
-	go s.startHealthMonitoring(ctx)
+	s.goBackground(func() { s.startHealthMonitoring(ctx) })
 
 	// Start leader election
-	go s.startLeaderElection(ctx)
+	s.goBackground(func() { s.startLeaderElection(ctx) })
 
 	return nil
 }
 
-func (s *HAService) Stop() error {
+// Stop stops the background goroutines and waits for them to exit, then
+// relinquishes leadership, telling peers so they elect a new leader at once
+// rather than after the heartbeat timeout. It returns ctx's error if the
+// goroutines have not exited by the time ctx is done.
+func (s *HAService) Stop(ctx context.Context) error {
+	// Taken under the mutex so becomeLeader cannot start leader tasks
+	// after the wait below has begun
+	s.mutex.Lock()
+	s.stopOnce.Do(func() { close(s.stopped) })
+	s.mutex.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+	}
 	if s.healthTicker != nil {
 		s.healthTicker.Stop()
 	}
-	return nil
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if s.IsLeader() {
+		s.stepDownAsLeader()
+		s.announceResignation(ctx)
+	}
+	return err
+}
+
+// goBackground runs fn in a goroutine that Stop waits for.
+func (s *HAService) goBackground(fn func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn()
+	}()
 }
 
 func (s *HAService) IsLeader() bool {
@@ -147,16 +258,23 @@ func (s *HAService) GetLeader() string {
 	return ""
 }
 
+// GetClusterStatus reports this controller and a copy of each peer as last
+// checked, marking the cluster degraded with the reasons why.
 func (s *HAService) GetClusterStatus() *ClusterStatus {
+	leader := s.GetLeader()
+
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	status := &ClusterStatus{
 		ClusterID: s.clusterID,
-		Leader:    s.GetLeader(),
+		Leader:    leader,
 		Nodes:     make(map[string]*PeerNode),
 		Healthy:   true,
 	}
+	if leader == "" {
+		status.degrade("no leader elected")
+	}
 
 	// Add self
 	status.Nodes[s.nodeID] = &PeerNode{
@@ -169,17 +287,41 @@ func (s *HAService) GetClusterStatus() *ClusterStatus {
 		Version:  "1.0.0",
 	}
 
-	// Add peers
-	for id, peer := range s.peerNodes {
-		status.Nodes[id] = peer
-		if time.Since(peer.LastSeen) > 2*s.config.HA.HeartbeatInterval {
+	ids := make([]string, 0, len(s.peerNodes))
+	for id := range s.peerNodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	now := time.Now()
+	slow := float64(s.config.HA.SlowPeerLatency) / float64(time.Millisecond)
+	for _, id := range ids {
+		// Copied so health checks don't change it under the caller
+		peer := *s.peerNodes[id]
+		since := now.Sub(peer.LastSeen)
+		peer.SecondsSinceLastSeen = int64(since / time.Second)
+		peer.LastSeenAgo = since.Round(time.Second).String()
+		status.Nodes[id] = &peer
+
+		switch {
+		case since > 2*s.config.HA.HeartbeatInterval:
 			status.Healthy = false
+			status.degrade(fmt.Sprintf("peer %s not seen for %s", id, peer.LastSeenAgo))
+		case peer.Status != "healthy" && peer.Status != "unknown":
+			status.degrade(fmt.Sprintf("peer %s is %s", id, peer.Status))
+		case slow > 0 && peer.Latency > slow:
+			status.degrade(fmt.Sprintf("peer %s took %.0fms to answer its health check", id, peer.Latency))
 		}
 	}
 
 	return status
 }
 
+func (c *ClusterStatus) degrade(reason string) {
+	c.Degraded = true
+	c.DegradedReasons = append(c.DegradedReasons, reason)
+}
+
 func (s *HAService) startPeerDiscovery(ctx context.Context) {
 	// In a real implementation, this would discover peers via:
 	// - Service discovery (Consul, etcd)
@@ -187,7 +329,12 @@ func (s *HAService) startPeerDiscovery(ctx context.Context) {
 	// - DNS discovery
 	// - Kubernetes API
 	
-	// For now, we'll use a simple static configuration
+	if s.config.HA.DiscoveryDNS != "" {
+		s.runDNSDiscovery(ctx)
+		return
+	}
+
+	// Otherwise use the static configuration
 	if len(s.config.HA.PeerNodes) > 0 {
 		for _, peerAddr := range s.config.HA.PeerNodes {
 			peerID := uuid.New().String()
@@ -225,28 +372,51 @@ func (s *HAService) checkPeerHealth(ctx context.Context) {
 	}
 	s.mutex.RUnlock()
 
-	for id, peer := range peers {
-		go func(peerID string, peerNode *PeerNode) {
+	for _, peer := range peers {
+		peerNode := peer
+		s.goBackground(func() {
+			start := time.Now()
 			health := s.checkSinglePeerHealth(ctx, peerNode)
-			
+			latency := time.Since(start)
+
 			s.mutex.Lock()
 			if health != nil {
 				peerNode.Status = health.Status
 				peerNode.IsLeader = health.IsLeader
-				peerNode.LastSeen = health.Timestamp
+				// Our clock rather than the peer's, so skew doesn't count
+				peerNode.LastSeen = time.Now()
 				peerNode.Version = health.Version
+				peerNode.Latency = float64(latency) / float64(time.Millisecond)
+				s.adoptPeerID(peerNode, health.NodeID)
 			} else {
+				// LastSeen stays at the last answer, showing how long the
+				// peer has been unreachable
 				peerNode.Status = "unhealthy"
-				peerNode.LastSeen = time.Now()
+				peerNode.Latency = 0
 			}
 			s.mutex.Unlock()
-		}(id, peer)
+		})
 	}
 }
 
+// adoptPeerID files a statically configured peer under the node ID it
+// reports, so its election and leader requests are recognised. Callers must
+// hold the mutex.
+func (s *HAService) adoptPeerID(peer *PeerNode, nodeID string) {
+	if nodeID == "" || nodeID == peer.ID || nodeID == s.nodeID {
+		return
+	}
+	if _, taken := s.peerNodes[nodeID]; taken {
+		return
+	}
+	delete(s.peerNodes, peer.ID)
+	peer.ID = nodeID
+	s.peerNodes[nodeID] = peer
+}
+
 func (s *HAService) checkSinglePeerHealth(ctx context.Context, peer *PeerNode) *HealthResponse {
-	url := fmt.Sprintf("http://%s:%d/ha/health", peer.Address, peer.Port)
-	
+	url := s.peerURL(peer.Address, peer.Port, "/ha/health").String()
+
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil
@@ -263,16 +433,146 @@ func (s *HAService) checkSinglePeerHealth(ctx context.Context, peer *PeerNode) *
 	}
 
 	var health HealthResponse
-	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+	if err := json.NewDecoder(resp.Body).Decode(&types.APIResponse{Data: &health}); err != nil {
 		return nil
 	}
 
 	return &health
 }
 
+// runDNSDiscovery periodically resolves HA_DISCOVERY_DNS and reconciles the
+// peer set with the controllers it returns.
+func (s *HAService) runDNSDiscovery(ctx context.Context) {
+	interval := s.config.HA.DiscoveryInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.discoverPeers(ctx); err != nil {
+			slog.WarnContext(ctx, "DNS peer discovery failed", "name", s.config.HA.DiscoveryDNS, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resolvePeerEndpoints looks up the discovery record. Names starting with an
+// underscore are treated as SRV records (e.g. _wg-controller._tcp.example.com),
+// anything else as A/AAAA records served on this controller's port.
+func (s *HAService) resolvePeerEndpoints(ctx context.Context) ([]peerEndpoint, error) {
+	name := s.config.HA.DiscoveryDNS
+
+	if strings.HasPrefix(name, "_") {
+		_, records, err := s.resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve SRV record %s: %w", name, err)
+		}
+
+		endpoints := make([]peerEndpoint, 0, len(records))
+		for _, record := range records {
+			endpoints = append(endpoints, peerEndpoint{
+				Address: strings.TrimSuffix(record.Target, "."),
+				Port:    int(record.Port),
+			})
+		}
+		return endpoints, nil
+	}
+
+	addresses, err := s.resolver.LookupHost(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", name, err)
+	}
+
+	endpoints := make([]peerEndpoint, 0, len(addresses))
+	for _, address := range addresses {
+		endpoints = append(endpoints, peerEndpoint{Address: address, Port: s.config.Server.Port})
+	}
+	return endpoints, nil
+}
+
+// discoverPeers reconciles peerNodes with DNS: peers whose endpoint vanished
+// are removed, and new endpoints are added under the node ID they report from
+// /ha/health so a controller that comes back at a new address keeps its ID.
+func (s *HAService) discoverPeers(ctx context.Context) error {
+	endpoints, err := s.resolvePeerEndpoints(ctx)
+	if err != nil {
+		return err
+	}
+
+	resolved := make(map[string]bool, len(endpoints))
+	for _, endpoint := range endpoints {
+		resolved[net.JoinHostPort(endpoint.Address, strconv.Itoa(endpoint.Port))] = true
+	}
+
+	// Drop peers that are no longer in DNS and note the endpoints we know
+	known := make(map[string]bool)
+	s.mutex.Lock()
+	for id, peer := range s.peerNodes {
+		key := net.JoinHostPort(peer.Address, strconv.Itoa(peer.Port))
+		if !resolved[key] {
+			slog.InfoContext(ctx, "Peer removed from DNS", "peer_id", id, "address", key)
+			delete(s.peerNodes, id)
+			continue
+		}
+		known[key] = true
+	}
+	s.mutex.Unlock()
+
+	for _, endpoint := range endpoints {
+		key := net.JoinHostPort(endpoint.Address, strconv.Itoa(endpoint.Port))
+		if known[key] {
+			continue
+		}
+
+		// Unreachable endpoints are retried on the next round
+		health := s.checkSinglePeerHealth(ctx, &PeerNode{Address: endpoint.Address, Port: endpoint.Port})
+		if health == nil || health.NodeID == "" || health.NodeID == s.nodeID {
+			continue
+		}
+
+		s.mutex.Lock()
+		if peer, ok := s.peerNodes[health.NodeID]; ok {
+			peer.Address = endpoint.Address
+			peer.Port = endpoint.Port
+			peer.Status = health.Status
+			peer.IsLeader = health.IsLeader
+			peer.LastSeen = time.Now()
+			peer.Version = health.Version
+		} else {
+			slog.InfoContext(ctx, "Discovered peer", "peer_id", health.NodeID, "address", key)
+			s.peerNodes[health.NodeID] = &PeerNode{
+				ID:       health.NodeID,
+				Address:  endpoint.Address,
+				Port:     endpoint.Port,
+				Status:   health.Status,
+				LastSeen: time.Now(),
+				IsLeader: health.IsLeader,
+				Version:  health.Version,
+			}
+		}
+		s.mutex.Unlock()
+	}
+
+	return nil
+}
+
 func (s *HAService) startLeaderElection(ctx context.Context) {
 	// Initial election delay
-	time.Sleep(time.Duration(s.nodeID[0]%10) * time.Second)
+	delay := time.NewTimer(time.Duration(s.nodeID[0]%10) * time.Second)
+	select {
+	case <-ctx.Done():
+		delay.Stop()
+		return
+	case <-delay.C:
+	}
 
 	electionTicker := time.NewTicker(s.config.HA.ElectionTimeout)
 	defer electionTicker.Stop()
@@ -307,7 +607,7 @@ func (s *HAService) hasHealthyLeader() bool {
 }
 
 func (s *HAService) attemptLeaderElection(ctx context.Context) {
-	fmt.Printf("Node %s attempting leader election\n", s.nodeID)
+	slog.InfoContext(ctx, "Attempting leader election", "node_id", s.nodeID)
 
 	s.mutex.RLock()
 	peers := make(map[string]*PeerNode)
@@ -316,6 +616,17 @@ func (s *HAService) attemptLeaderElection(ctx context.Context) {
 	}
 	s.mutex.RUnlock()
 
+	s.mutex.Lock()
+	s.term++
+	s.votedFor = s.nodeID
+	term := s.term
+	err := s.persistElectionState()
+	s.mutex.Unlock()
+	if err != nil {
+		slog.ErrorContext(ctx, "Aborting election", "node_id", s.nodeID, "term", term, "error", err)
+		return
+	}
+
 	votes := 1 // Vote for self
 	totalNodes := len(peers) + 1
 	requiredVotes := (totalNodes / 2) + 1
@@ -323,28 +634,34 @@ func (s *HAService) attemptLeaderElection(ctx context.Context) {
 	// Send election requests to peers
 	for _, peer := range peers {
 		if peer.Status == "healthy" {
-			if s.requestVote(ctx, peer) {
+			if s.requestVote(ctx, peer, term) {
 				votes++
 			}
 		}
 	}
 
+	// A peer may have moved to a newer term while we were collecting votes
+	if s.CurrentTerm() != term {
+		slog.InfoContext(ctx, "Abandoning election for stale term", "node_id", s.nodeID, "term", term)
+		return
+	}
+
 	// Check if we have majority
 	if votes >= requiredVotes {
 		s.becomeLeader()
-		fmt.Printf("Node %s elected as leader with %d/%d votes\n", s.nodeID, votes, totalNodes)
+		slog.InfoContext(ctx, "Elected as leader", "node_id", s.nodeID, "votes", votes, "nodes", totalNodes)
 	} else {
-		fmt.Printf("Node %s failed to get majority: %d/%d votes\n", s.nodeID, votes, totalNodes)
+		slog.InfoContext(ctx, "Failed to get majority", "node_id", s.nodeID, "votes", votes, "nodes", totalNodes)
 	}
 }
 
-func (s *HAService) requestVote(ctx context.Context, peer *PeerNode) bool {
-	url := fmt.Sprintf("http://%s:%d/ha/election", peer.Address, peer.Port)
-	
+func (s *HAService) requestVote(ctx context.Context, peer *PeerNode, term int64) bool {
+	url := s.peerURL(peer.Address, peer.Port, "/ha/election").String()
+
 	request := LeaderElectionRequest{
 		NodeID:       s.nodeID,
 		ClusterID:    s.clusterID,
-		Term:         time.Now().Unix(),
+		Term:         term,
 		LastLogIndex: 0, // Simplified
 		Timestamp:    time.Now(),
 	}
@@ -359,6 +676,7 @@ func (s *HAService) requestVote(ctx context.Context, peer *PeerNode) bool {
 		return false
 	}
 	req.Header.Set("Content-Type", "application/json")
+	s.signPeerRequest(req, body)
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
@@ -371,10 +689,14 @@ func (s *HAService) requestVote(ctx context.Context, peer *PeerNode) bool {
 	}
 
 	var response LeaderElectionResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+	if err := json.NewDecoder(resp.Body).Decode(&types.APIResponse{Data: &response}); err != nil {
 		return false
 	}
 
+	if response.Term > term {
+		s.observeTerm(response.Term)
+	}
+
 	return response.Success
 }
 
@@ -391,8 +713,12 @@ func (s *HAService) becomeLeader() {
 		default:
 		}
 
-		// Start leader-specific tasks
-		go s.startLeaderTasks()
+		// Start leader-specific tasks, unless the service is stopping
+		select {
+		case <-s.stopped:
+		default:
+			s.goBackground(s.startLeaderTasks)
+		}
 	}
 }
 
@@ -409,12 +735,12 @@ func (s *HAService) stepDownAsLeader() {
 		default:
 		}
 
-		fmt.Printf("Node %s stepped down as leader\n", s.nodeID)
+		slog.Info("Stepped down as leader", "node_id", s.nodeID)
 	}
 }
 
 func (s *HAService) startLeaderTasks() {
-	fmt.Printf("Node %s starting leader tasks\n", s.nodeID)
+	slog.Info("Starting leader tasks", "node_id", s.nodeID)
 
 	// Leader-specific tasks:
 	// 1. Configuration synchronization
@@ -429,6 +755,8 @@ func (s *HAService) startLeaderTasks() {
 
 	for {
 		select {
+		case <-s.stopped:
+			return
 		case <-ticker.C:
 			if !s.IsLeader() {
 				return
@@ -448,12 +776,12 @@ func (s *HAService) announceLeadership() {
 
 	for _, peer := range peers {
 		go func(p *PeerNode) {
-			url := fmt.Sprintf("http://%s:%d/ha/leader", p.Address, p.Port)
-			
+			url := s.peerURL(p.Address, p.Port, "/ha/leader").String()
+
 			announcement := map[string]interface{}{
 				"leader_id":   s.nodeID,
 				"cluster_id":  s.clusterID,
-				"term":        time.Now().Unix(),
+				"term":        s.CurrentTerm(),
 				"timestamp":   time.Now(),
 			}
 
@@ -464,28 +792,96 @@ func (s *HAService) announceLeadership() {
 
 			req, _ := http.NewRequest("POST", url, strings.NewReader(string(body)))
 			req.Header.Set("Content-Type", "application/json")
+			s.signPeerRequest(req, body)
 			
 			s.httpClient.Do(req)
 		}(peer)
 	}
 }
 
-func (s *HAService) HandleVoteRequest(request *LeaderElectionRequest) *LeaderElectionResponse {
+// announceResignation tells every peer this node is no longer the leader
+// and waits for the announcements to be delivered or ctx to be done.
+func (s *HAService) announceResignation(ctx context.Context) {
 	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	peers := make([]*PeerNode, 0, len(s.peerNodes))
+	for _, peer := range s.peerNodes {
+		peers = append(peers, peer)
+	}
+	s.mutex.RUnlock()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"leader_id":  s.nodeID,
+		"cluster_id": s.clusterID,
+		"term":       s.CurrentTerm(),
+		"resigned":   true,
+		"timestamp":  time.Now(),
+	})
+	if err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(p *PeerNode) {
+			defer wg.Done()
+
+			url := s.peerURL(p.Address, p.Port, "/ha/leader").String()
+			req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+			if err != nil {
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			s.signPeerRequest(req, body)
+
+			resp, err := s.httpClient.Do(req)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to announce resignation", "peer", p.Address, "error", err)
+				return
+			}
+			resp.Body.Close()
+		}(peer)
+	}
+	wg.Wait()
+
+	slog.InfoContext(ctx, "Relinquished leadership", "node_id", s.nodeID, "peers", len(peers))
+}
+
+func (s *HAService) HandleVoteRequest(request *LeaderElectionRequest) *LeaderElectionResponse {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
 	response := &LeaderElectionResponse{
 		Success:   false,
-		Term:      time.Now().Unix(),
 		VoterID:   s.nodeID,
 		Timestamp: time.Now(),
 	}
 
-	// Simple voting logic
-	if request.ClusterID == s.clusterID && !s.isLeader {
-		response.Success = true
+	// Requests from other clusters, from controllers we haven't discovered
+	// or for terms we have already moved past are rejected outright, so a
+	// stranger cannot push the cluster to a new term
+	if request.ClusterID != s.clusterID || !s.isKnownPeer(request.NodeID) || request.Term < s.term {
+		response.Term = s.term
+		return response
+	}
+
+	if request.Term > s.term {
+		s.adoptTerm(request.Term)
 	}
 
+	// Only one vote per term
+	if s.votedFor == "" || s.votedFor == request.NodeID {
+		previousVote := s.votedFor
+		s.votedFor = request.NodeID
+		if err := s.persistElectionState(); err != nil {
+			slog.Error("Refusing vote", "node_id", s.nodeID, "candidate", request.NodeID, "error", err)
+			s.votedFor = previousVote
+		} else {
+			response.Success = true
+		}
+	}
+
+	response.Term = s.term
 	return response
 }
 
@@ -500,6 +896,34 @@ func (s *HAService) HandleLeaderAnnouncement(announcement map[string]interface{}
 		return
 	}
 
+	// Only discovered peers may announce a leader or a newer term
+	s.mutex.RLock()
+	known := s.isKnownPeer(leaderID)
+	s.mutex.RUnlock()
+	if !known {
+		return
+	}
+
+	// Ignore leaders from terms we have already moved past
+	if term, ok := announcement["term"].(float64); ok {
+		if int64(term) < s.CurrentTerm() {
+			return
+		}
+		s.observeTerm(int64(term))
+	}
+
+	// A leader shutting down resigns so an election can start right away
+	if resigned, _ := announcement["resigned"].(bool); resigned {
+		s.mutex.Lock()
+		for id, peer := range s.peerNodes {
+			if id == leaderID {
+				peer.IsLeader = false
+			}
+		}
+		s.mutex.Unlock()
+		return
+	}
+
 	// If we're the leader but someone else is announcing, step down
 	if s.isLeader && leaderID != s.nodeID {
 		s.stepDownAsLeader()
@@ -541,14 +965,101 @@ func (s *HAService) SyncConfiguration(ctx context.Context) error {
 	// - Certificate rotations
 	// - System configuration changes
 
-	fmt.Printf("Leader %s syncing configuration to peers\n", s.nodeID)
+	slog.InfoContext(ctx, "Syncing configuration to peers", "node_id", s.nodeID)
+	return nil
+}
+
+// loadElectionState restores the persisted term and vote so that a restarted
+// controller neither reuses an old term nor votes twice within one.
+func (s *HAService) loadElectionState() error {
+	if s.db == nil {
+		return nil
+	}
+
+	var state HAElectionState
+	err := s.db.Where("node_id = ?", s.nodeID).First(&state).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load election state: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.term = state.Term
+	s.votedFor = state.VotedFor
+	slog.Info("Resuming at persisted term", "node_id", s.nodeID, "term", s.term)
 	return nil
 }
 
+// persistElectionState saves the current term and vote. Callers must hold
+// the mutex.
+func (s *HAService) persistElectionState() error {
+	if s.db == nil {
+		return nil
+	}
+
+	state := &HAElectionState{
+		NodeID:    s.nodeID,
+		ClusterID: s.clusterID,
+		Term:      s.term,
+		VotedFor:  s.votedFor,
+	}
+	if err := s.db.Save(state).Error; err != nil {
+		return fmt.Errorf("failed to persist election state: %w", err)
+	}
+	return nil
+}
+
+// adoptTerm moves this node to a newer term, clearing its vote and giving up
+// leadership of the old term. Callers must hold the mutex.
+func (s *HAService) adoptTerm(term int64) {
+	s.term = term
+	s.votedFor = ""
+
+	if s.isLeader {
+		s.isLeader = false
+		select {
+		case s.leaderChan <- false:
+		default:
+		}
+		slog.Info("Stepped down as leader", "node_id", s.nodeID, "term", term)
+	}
+
+	if err := s.persistElectionState(); err != nil {
+		slog.Error("Failed to persist term", "node_id", s.nodeID, "term", term, "error", err)
+	}
+}
+
+// observeTerm adopts term if it is newer than the current one.
+func (s *HAService) observeTerm(term int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if term > s.term {
+		s.adoptTerm(term)
+	}
+}
+
+// CurrentTerm returns the latest election term this node has seen.
+func (s *HAService) CurrentTerm() int64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.term
+}
+
 func (s *HAService) GetLeaderChannel() <-chan bool {
 	return s.leaderChan
 }
 
+// ProxiedByHeader marks requests forwarded by a follower so that a follower
+// with a stale view of the leader never forwards them a second time. It
+// names the follower, whose signature the leader checks before believing
+// the client identity forwarded with it.
+const ProxiedByHeader = "X-WG-Proxied-By"
+
 func (s *HAService) EnsureLeaderOrProxy(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if s.IsLeader() {
@@ -556,14 +1067,157 @@ func (s *HAService) EnsureLeaderOrProxy(next http.HandlerFunc) http.HandlerFunc
 			return
 		}
 
-		// Find leader and proxy request
-		leader := s.GetLeader()
-		if leader == "" {
+		leaderURL := s.leaderURL()
+		if leaderURL == nil {
 			http.Error(w, "No leader available", http.StatusServiceUnavailable)
 			return
 		}
 
-		// In a real implementation, this would proxy the request to the leader
-		http.Error(w, "Not the leader, redirect to leader", http.StatusTemporaryRedirect)
+		if r.Header.Get(ProxiedByHeader) != "" {
+			http.Error(w, "Leader unavailable, request already forwarded", http.StatusServiceUnavailable)
+			return
+		}
+
+		proxy := httputil.NewSingleHostReverseProxy(leaderURL)
+		proxy.Transport = s.httpClient.Transport
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.ErrorContext(r.Context(), "Failed to proxy request to leader", "leader", leaderURL.Host, "error", err)
+			http.Error(w, "Leader unreachable", http.StatusBadGateway)
+		}
+
+		if err := s.forwardClientIdentity(r); err != nil {
+			http.Error(w, "Invalid client certificate", http.StatusUnauthorized)
+			return
+		}
+		proxy.ServeHTTP(w, r)
 	}
-}
\ No newline at end of file
+}
+
+// leaderURL returns the base URL of the peer currently known to be leader.
+func (s *HAService) leaderURL() *url.URL {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, peer := range s.peerNodes {
+		if peer.IsLeader {
+			return s.peerURL(peer.Address, peer.Port, "")
+		}
+	}
+
+	return nil
+}
+
+// LeadershipEvent is the payload delivered to the leadership webhook when
+// this controller becomes or steps down as leader.
+type LeadershipEvent struct {
+	Event     string    `json:"event"` // became_leader, stepped_down
+	NodeID    string    `json:"node_id"`
+	ClusterID string    `json:"cluster_id"`
+	Term      int64     `json:"term"`
+	Peers     int       `json:"peers"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// LeadershipNotifier consumes the HA leader channel and reports each
+// transition to the audit log and, if configured, an external webhook.
+type LeadershipNotifier struct {
+	haService    *HAService
+	auditService *AuditService
+	webhookURL   string
+	secret       string
+	httpClient   *http.Client
+}
+
+func NewLeadershipNotifier(haService *HAService, auditService *AuditService, webhookURL string) *LeadershipNotifier {
+	return &LeadershipNotifier{
+		haService:    haService,
+		auditService: auditService,
+		webhookURL:   webhookURL,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// SetSigningSecret signs leadership webhooks like the other outbound
+// webhooks.
+func (n *LeadershipNotifier) SetSigningSecret(secret string) {
+	n.secret = secret
+}
+
+func (n *LeadershipNotifier) Run(ctx context.Context) {
+	leaderChan := n.haService.GetLeaderChannel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case isLeader := <-leaderChan:
+			n.notify(ctx, n.buildEvent(isLeader))
+		}
+	}
+}
+
+func (n *LeadershipNotifier) buildEvent(isLeader bool) *LeadershipEvent {
+	event := "stepped_down"
+	if isLeader {
+		event = "became_leader"
+	}
+
+	n.haService.mutex.RLock()
+	defer n.haService.mutex.RUnlock()
+
+	return &LeadershipEvent{
+		Event:     event,
+		NodeID:    n.haService.nodeID,
+		ClusterID: n.haService.clusterID,
+		Term:      n.haService.term,
+		Peers:     len(n.haService.peerNodes),
+		Timestamp: time.Now(),
+	}
+}
+
+func (n *LeadershipNotifier) notify(ctx context.Context, event *LeadershipEvent) {
+	if n.auditService != nil {
+		description := fmt.Sprintf("Controller %s %s in cluster %s (term %d)", event.NodeID, strings.ReplaceAll(event.Event, "_", " "), event.ClusterID, event.Term)
+		n.auditService.LogActionWithMetadata(ctx, nil, models.AuditActionUpdate, "ha_leadership", nil, description, "", "", map[string]interface{}{
+			"event":      event.Event,
+			"node_id":    event.NodeID,
+			"cluster_id": event.ClusterID,
+			"term":       event.Term,
+		})
+	}
+
+	if n.webhookURL == "" {
+		return
+	}
+
+	if err := n.sendWebhook(ctx, event); err != nil {
+		slog.ErrorContext(ctx, "Failed to send leadership webhook", "error", err)
+	}
+}
+
+func (n *LeadershipNotifier) sendWebhook(ctx context.Context, event *LeadershipEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal leadership event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.webhookURL, strings.NewReader(string(body)))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setSignatureHeaders(req.Header, n.secret, body, time.Now())
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+// peerScheme is the scheme HA peers are called over. Controllers in a
+// cluster share their server settings, so peers serve TLS exactly when this
+// controller does.
+func peerScheme(tlsConfig types.TLSConfig) string {
+	if tlsConfig.Enabled {
+		return "https"
+	}
+	return "http"
+}
+
+// peerURL returns the URL of path on the controller at address and port.
+func (s *HAService) peerURL(address string, port int, path string) *url.URL {
+	return &url.URL{
+		Scheme: peerScheme(s.config.Server.TLS),
+		Host:   net.JoinHostPort(address, strconv.Itoa(port)),
+		Path:   path,
+	}
+}
+
+// newPeerHTTPClient returns the client used for health checks, elections
+// and proxying to the leader. With TLS enabled, peer certificates are
+// verified against TLS_CA_FILE and the controller's own certificate, for
+// clusters sharing one, on top of the system roots.
+func newPeerHTTPClient(tlsConfig types.TLSConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig.Enabled {
+		roots, err := peerRootCAs(tlsConfig)
+		if err != nil {
+			slog.Error("Failed to load CAs for HA peers, using the system roots", "error", err)
+		}
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    roots,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: transport,
+	}
+}
+
+func peerRootCAs(tlsConfig types.TLSConfig) (*x509.CertPool, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+
+	for _, path := range []string{tlsConfig.CAFile, tlsConfig.CertFile} {
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return roots, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return roots, fmt.Errorf("no certificates found in %s", path)
+		}
+	}
+	return roots, nil
+}
+
+// ErrPeerSecretMissing is returned when HA is enabled without HA_PEER_SECRET.
+var ErrPeerSecretMissing = errors.New("HA_PEER_SECRET is required when HA is enabled")
+
+// signPeerRequest signs body, sent with req, with the cluster's peer secret.
+func (s *HAService) signPeerRequest(req *http.Request, body []byte) {
+	setSignatureHeaders(req.Header, s.config.HA.PeerSecret, body, time.Now())
+}
+
+// VerifyPeerRequest checks that body was signed by a controller holding the
+// cluster's peer secret. Without a secret nothing is accepted.
+func (s *HAService) VerifyPeerRequest(header http.Header, body []byte) error {
+	if s.config.HA.PeerSecret == "" {
+		return ErrPeerSecretMissing
+	}
+	return VerifySignature(s.config.HA.PeerSecret, header, body, time.Now())
+}
+
+// isKnownPeer reports whether id is a peer this controller has discovered.
+// Callers must hold the mutex.
+func (s *HAService) isKnownPeer(id string) bool {
+	_, ok := s.peerNodes[id]
+	return ok
+}
+
+// Headers a follower adds to a request it forwards to the leader, carrying
+// the client as the follower saw it. The leader only believes them when
+// proxySignatureHeader signs them with the peer secret.
+const (
+	proxiedClientIPHeader   = "X-WG-Client-IP"
+	proxiedClientNodeHeader = "X-WG-Client-Node"
+	proxyTimestampHeader    = "X-WG-Proxy-Timestamp"
+	proxySignatureHeader    = "X-WG-Proxy-Signature"
+)
+
+// ErrUntrustedProxiedRequest is returned for a request that claims to have
+// been forwarded by a follower but isn't signed by one.
+var ErrUntrustedProxiedRequest = errors.New("proxied request not signed by a peer")
+
+// ProxiedClient is the client a follower forwarded a request for.
+type ProxiedClient struct {
+	PeerID string
+	IP     string
+	// NodeID is the node named by the client's verified certificate, or
+	// uuid.Nil when it presented none
+	NodeID uuid.UUID
+}
+
+// signProxiedClient signs client for a request with method and uri, so the
+// identity cannot be moved to another request.
+func signProxiedClient(secret string, timestamp int64, method, uri string, client *ProxiedClient) string {
+	content := strings.Join([]string{client.PeerID, method, uri, client.IP, client.NodeID.String()}, "\n")
+	return SignPayload(secret, timestamp, []byte(content))
+}
+
+// forwardClientIdentity replaces whatever proxy headers the client sent
+// with the signed identity of the client as this follower saw it: its
+// address and the node its certificate was verified for.
+func (s *HAService) forwardClientIdentity(r *http.Request) error {
+	client := &ProxiedClient{PeerID: s.nodeID, IP: r.RemoteAddr}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client.IP = host
+	}
+
+	if s.authenticateClientCert != nil {
+		nodeID, err := s.authenticateClientCert(r.TLS)
+		if err == nil {
+			client.NodeID = nodeID
+		} else if !errors.Is(err, ErrNoClientCertificate) && !errors.Is(err, ErrNoCertificateNodeIdentity) {
+			return err
+		}
+	}
+
+	timestamp := time.Now().Unix()
+	r.Header.Set(ProxiedByHeader, client.PeerID)
+	r.Header.Set(proxiedClientIPHeader, client.IP)
+	r.Header.Del(proxiedClientNodeHeader)
+	if client.NodeID != uuid.Nil {
+		r.Header.Set(proxiedClientNodeHeader, client.NodeID.String())
+	}
+	r.Header.Set(proxyTimestampHeader, strconv.FormatInt(timestamp, 10))
+	r.Header.Set(proxySignatureHeader, signProxiedClient(s.config.HA.PeerSecret, timestamp, r.Method, r.URL.RequestURI(), client))
+	return nil
+}
+
+// VerifyProxiedRequest returns the client a follower forwarded r for,
+// after checking the follower signed it with the peer secret no more than
+// SignatureMaxAge ago.
+func (s *HAService) VerifyProxiedRequest(r *http.Request) (*ProxiedClient, error) {
+	if s.config.HA.PeerSecret == "" {
+		return nil, ErrPeerSecretMissing
+	}
+
+	client := &ProxiedClient{
+		PeerID: r.Header.Get(ProxiedByHeader),
+		IP:     r.Header.Get(proxiedClientIPHeader),
+	}
+	if node := r.Header.Get(proxiedClientNodeHeader); node != "" {
+		nodeID, err := uuid.Parse(node)
+		if err != nil {
+			return nil, ErrUntrustedProxiedRequest
+		}
+		client.NodeID = nodeID
+	}
+
+	signature := r.Header.Get(proxySignatureHeader)
+	timestamp, err := strconv.ParseInt(r.Header.Get(proxyTimestampHeader), 10, 64)
+	if err != nil || signature == "" || client.PeerID == "" || net.ParseIP(client.IP) == nil {
+		return nil, ErrUntrustedProxiedRequest
+	}
+	expected := signProxiedClient(s.config.HA.PeerSecret, timestamp, r.Method, r.URL.RequestURI(), client)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, ErrUntrustedProxiedRequest
+	}
+
+	age := time.Since(time.Unix(timestamp, 0))
+	if age > SignatureMaxAge || age < -SignatureMaxAge {
+		return nil, ErrUntrustedProxiedRequest
+	}
+	return client, nil
+}
@@ -0,0 +1,836 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"gorm.io/gorm"
+)
+
+func TestLeadershipNotifierBecomeLeaderWebhook(t *testing.T) {
+	received := make(chan LeadershipEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected application/json content type, got %q", ct)
+		}
+		var event LeadershipEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("failed to decode webhook payload: %v", err)
+		}
+		received <- event
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := &types.Config{
+		HA: types.HAConfig{
+			Enabled:           true,
+			NodeID:            "controller-1",
+			ClusterID:         "cluster-a",
+			HeartbeatInterval: time.Hour,
+		},
+	}
+	haService := NewHAService(nil, config)
+	haService.term = 42
+	haService.peerNodes["controller-2"] = &PeerNode{ID: "controller-2"}
+
+	notifier := NewLeadershipNotifier(haService, nil, server.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notifier.Run(ctx)
+
+	haService.becomeLeader()
+
+	select {
+	case event := <-received:
+		if event.Event != "became_leader" {
+			t.Errorf("expected event became_leader, got %q", event.Event)
+		}
+		if event.NodeID != "controller-1" {
+			t.Errorf("expected node_id controller-1, got %q", event.NodeID)
+		}
+		if event.ClusterID != "cluster-a" {
+			t.Errorf("expected cluster_id cluster-a, got %q", event.ClusterID)
+		}
+		if event.Term != 42 {
+			t.Errorf("expected term 42, got %d", event.Term)
+		}
+		if event.Peers != 1 {
+			t.Errorf("expected 1 peer, got %d", event.Peers)
+		}
+		if event.Timestamp.IsZero() {
+			t.Error("expected timestamp to be set")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for leadership webhook")
+	}
+}
+
+func TestLeadershipNotifierWithoutWebhook(t *testing.T) {
+	config := &types.Config{HA: types.HAConfig{NodeID: "controller-1", ClusterID: "cluster-a"}}
+	notifier := NewLeadershipNotifier(NewHAService(nil, config), nil, "")
+
+	// Without a webhook URL or audit service the notification is a no-op
+	notifier.notify(context.Background(), notifier.buildEvent(false))
+}
+
+// newTestController starts an in-process controller whose API handler runs
+// behind EnsureLeaderOrProxy.
+func newTestController(t *testing.T, nodeID string, handler http.HandlerFunc) (*HAService, *httptest.Server) {
+	t.Helper()
+
+	haService := NewHAService(nil, &types.Config{
+		HA: types.HAConfig{Enabled: true, NodeID: nodeID, ClusterID: "cluster-a"},
+	})
+	server := httptest.NewServer(haService.EnsureLeaderOrProxy(handler))
+	t.Cleanup(server.Close)
+	return haService, server
+}
+
+func TestEnsureLeaderOrProxyForwardsWritesToLeader(t *testing.T) {
+	type receivedRequest struct {
+		method, path, auth, body, proxiedBy string
+	}
+	received := make(chan receivedRequest, 1)
+
+	leader, leaderServer := newTestController(t, "controller-1", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedRequest{
+			method:    r.Method,
+			path:      r.URL.RequestURI(),
+			auth:      r.Header.Get("Authorization"),
+			body:      string(body),
+			proxiedBy: r.Header.Get(ProxiedByHeader),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"success":true,"message":"created by leader"}`))
+	})
+	leader.isLeader = true
+
+	follower, followerServer := newTestController(t, "controller-2", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("follower must not serve write requests locally")
+	})
+
+	leaderAddr, err := url.Parse(leaderServer.URL)
+	if err != nil {
+		t.Fatalf("failed to parse leader URL: %v", err)
+	}
+	host, portStr, _ := net.SplitHostPort(leaderAddr.Host)
+	port, _ := strconv.Atoi(portStr)
+	follower.peerNodes["controller-1"] = &PeerNode{ID: "controller-1", Address: host, Port: port, IsLeader: true, Status: "healthy"}
+
+	req, _ := http.NewRequest(http.MethodPost, followerServer.URL+"/api/v1/nodes?dry_run=true", strings.NewReader(`{"name":"spoke-1"}`))
+	req.Header.Set("Authorization", "Bearer user-token")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request to follower failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected leader's 201 status, got %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != `{"success":true,"message":"created by leader"}` {
+		t.Errorf("expected leader's response body, got %s", body)
+	}
+
+	select {
+	case got := <-received:
+		if got.method != http.MethodPost {
+			t.Errorf("expected POST at leader, got %s", got.method)
+		}
+		if got.path != "/api/v1/nodes?dry_run=true" {
+			t.Errorf("expected path to be preserved, got %s", got.path)
+		}
+		if got.auth != "Bearer user-token" {
+			t.Errorf("expected Authorization header to be preserved, got %q", got.auth)
+		}
+		if got.body != `{"name":"spoke-1"}` {
+			t.Errorf("expected body to be preserved, got %s", got.body)
+		}
+		if got.proxiedBy != "controller-2" {
+			t.Errorf("expected %s header from follower, got %q", ProxiedByHeader, got.proxiedBy)
+		}
+	default:
+		t.Fatal("leader did not receive the proxied request")
+	}
+}
+
+func TestEnsureLeaderOrProxyNoLeader(t *testing.T) {
+	_, server := newTestController(t, "controller-2", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("follower must not serve write requests locally")
+	})
+
+	resp, err := http.Post(server.URL+"/api/v1/nodes", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without a leader, got %d", resp.StatusCode)
+	}
+}
+
+func TestEnsureLeaderOrProxyRejectsForwardedRequests(t *testing.T) {
+	follower, server := newTestController(t, "controller-2", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("follower must not serve write requests locally")
+	})
+	follower.peerNodes["controller-3"] = &PeerNode{ID: "controller-3", Address: "127.0.0.1", Port: 1, IsLeader: true}
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/v1/nodes", strings.NewReader(`{}`))
+	req.Header.Set(ProxiedByHeader, "controller-1")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for an already forwarded request, got %d", resp.StatusCode)
+	}
+}
+
+func TestVerifyProxiedRequestRejectsTampering(t *testing.T) {
+	haService := NewHAService(nil, &types.Config{
+		HA: types.HAConfig{Enabled: true, NodeID: "controller-2", ClusterID: "cluster-a", PeerSecret: "peer-secret"},
+	})
+
+	proxied := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/nodes/1", nil)
+		req.RemoteAddr = "203.0.113.7:40000"
+		if err := haService.forwardClientIdentity(req); err != nil {
+			t.Fatalf("forwardClientIdentity failed: %v", err)
+		}
+		return req
+	}
+
+	client, err := haService.VerifyProxiedRequest(proxied())
+	if err != nil {
+		t.Fatalf("expected the follower's signature to verify, got %v", err)
+	}
+	if client.PeerID != "controller-2" || client.IP != "203.0.113.7" {
+		t.Errorf("unexpected proxied client %+v", client)
+	}
+
+	for name, tamper := range map[string]func(*http.Request){
+		"client address": func(r *http.Request) { r.Header.Set(proxiedClientIPHeader, "198.51.100.1") },
+		"node":           func(r *http.Request) { r.Header.Set(proxiedClientNodeHeader, "00000000-0000-0000-0000-000000000001") },
+		"path":           func(r *http.Request) { r.URL.Path = "/api/v1/nodes/2" },
+		"signature":      func(r *http.Request) { r.Header.Del(proxySignatureHeader) },
+	} {
+		req := proxied()
+		tamper(req)
+		if _, err := haService.VerifyProxiedRequest(req); !errors.Is(err, ErrUntrustedProxiedRequest) {
+			t.Errorf("%s changed: expected ErrUntrustedProxiedRequest, got %v", name, err)
+		}
+	}
+}
+
+// startHANode simulates a controller process starting against db with the
+// election state it persisted before any restart.
+func startHANode(t *testing.T, db *gorm.DB, nodeID string) *HAService {
+	t.Helper()
+
+	haService := NewHAService(db, &types.Config{
+		HA: types.HAConfig{Enabled: true, NodeID: nodeID, ClusterID: "cluster-a", HeartbeatInterval: time.Hour},
+	})
+	if err := haService.loadElectionState(); err != nil {
+		t.Fatalf("failed to load election state: %v", err)
+	}
+	return haService
+}
+
+func newHATestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&HAElectionState{}); err != nil {
+		t.Fatalf("failed to migrate election state: %v", err)
+	}
+	return db
+}
+
+// addPeers makes ids known to haService as peers that have not answered a
+// health check yet.
+func addPeers(haService *HAService, ids ...string) {
+	for _, id := range ids {
+		haService.peerNodes[id] = &PeerNode{ID: id, Status: "unknown"}
+	}
+}
+
+func voteRequest(nodeID string, term int64) *LeaderElectionRequest {
+	return &LeaderElectionRequest{NodeID: nodeID, ClusterID: "cluster-a", Term: term, Timestamp: time.Now()}
+}
+
+func TestHandleVoteRequestOneVotePerTermAcrossRestart(t *testing.T) {
+	db := newHATestDB(t)
+
+	node := startHANode(t, db, "controller-1")
+	addPeers(node, "controller-2", "controller-3")
+	if resp := node.HandleVoteRequest(voteRequest("controller-2", 5)); !resp.Success || resp.Term != 5 {
+		t.Fatalf("expected vote for controller-2 in term 5, got %+v", resp)
+	}
+
+	// Restart and rejoin: the node resumes at the persisted term
+	node = startHANode(t, db, "controller-1")
+	addPeers(node, "controller-2", "controller-3")
+	if term := node.CurrentTerm(); term != 5 {
+		t.Fatalf("expected restarted node to adopt persisted term 5, got %d", term)
+	}
+
+	if resp := node.HandleVoteRequest(voteRequest("controller-3", 5)); resp.Success {
+		t.Error("restarted node voted twice in term 5")
+	}
+	if resp := node.HandleVoteRequest(voteRequest("controller-2", 5)); !resp.Success {
+		t.Error("expected a repeated request from the same candidate to be granted")
+	}
+	if resp := node.HandleVoteRequest(voteRequest("controller-3", 6)); !resp.Success || resp.Term != 6 {
+		t.Errorf("expected vote for controller-3 in the newer term 6, got %+v", resp)
+	}
+}
+
+func TestHandleVoteRequestRejectsStaleTerm(t *testing.T) {
+	db := newHATestDB(t)
+
+	node := startHANode(t, db, "controller-1")
+	addPeers(node, "controller-2", "controller-3")
+	node.HandleVoteRequest(voteRequest("controller-2", 7))
+
+	node = startHANode(t, db, "controller-1")
+	addPeers(node, "controller-2", "controller-3")
+	resp := node.HandleVoteRequest(voteRequest("controller-3", 3))
+	if resp.Success {
+		t.Error("expected vote request for stale term to be rejected")
+	}
+	if resp.Term != 7 {
+		t.Errorf("expected rejection to report current term 7, got %d", resp.Term)
+	}
+
+	otherCluster := voteRequest("controller-3", 8)
+	otherCluster.ClusterID = "cluster-b"
+	if resp := node.HandleVoteRequest(otherCluster); resp.Success {
+		t.Error("expected vote request from another cluster to be rejected")
+	}
+}
+
+func TestAttemptLeaderElectionIncrementsPersistedTerm(t *testing.T) {
+	db := newHATestDB(t)
+
+	node := startHANode(t, db, "controller-1")
+	node.attemptLeaderElection(context.Background())
+	if !node.IsLeader() || node.CurrentTerm() != 1 {
+		t.Fatalf("expected single node to lead term 1, leader=%v term=%d", node.IsLeader(), node.CurrentTerm())
+	}
+
+	// After a restart the node is a follower again but must not reuse term 1
+	node = startHANode(t, db, "controller-1")
+	if node.IsLeader() {
+		t.Fatal("expected restarted node to start as follower")
+	}
+	addPeers(node, "controller-2")
+	if resp := node.HandleVoteRequest(voteRequest("controller-2", 1)); resp.Success {
+		t.Error("node voted for another candidate in a term it already voted for itself")
+	}
+
+	node.attemptLeaderElection(context.Background())
+	if node.CurrentTerm() != 2 {
+		t.Errorf("expected election after restart to use term 2, got %d", node.CurrentTerm())
+	}
+
+	var state HAElectionState
+	if err := db.First(&state, "node_id = ?", "controller-1").Error; err != nil {
+		t.Fatalf("failed to load persisted state: %v", err)
+	}
+	if state.Term != 2 || state.VotedFor != "controller-1" {
+		t.Errorf("expected persisted term 2 voted for self, got %+v", state)
+	}
+}
+
+func TestHandleVoteRequestNewerTermStepsDownLeader(t *testing.T) {
+	node := startHANode(t, newHATestDB(t), "controller-1")
+	node.attemptLeaderElection(context.Background())
+	if !node.IsLeader() {
+		t.Fatal("expected node to become leader")
+	}
+
+	addPeers(node, "controller-2")
+	if resp := node.HandleVoteRequest(voteRequest("controller-2", 2)); !resp.Success {
+		t.Fatalf("expected vote for candidate in newer term, got %+v", resp)
+	}
+	if node.IsLeader() {
+		t.Error("expected leader to step down after seeing a newer term")
+	}
+}
+
+func TestStopRelinquishesLeadership(t *testing.T) {
+	var mu sync.Mutex
+	announcements, resignations := 0, 0
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ha/leader" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := VerifySignature("peer-secret", r.Header, body, time.Now()); err != nil {
+			t.Errorf("expected a signed announcement, got %v", err)
+		}
+		var announcement map[string]interface{}
+		json.Unmarshal(body, &announcement)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if resigned, _ := announcement["resigned"].(bool); resigned && announcement["leader_id"] == "node-1" {
+			resignations++
+		} else {
+			announcements++
+		}
+	}))
+	defer peer.Close()
+	peerURL, _ := url.Parse(peer.URL)
+	port, _ := strconv.Atoi(peerURL.Port())
+
+	haService := NewHAService(newHATestDB(t), &types.Config{
+		Server: types.ServerConfig{Port: port},
+		HA: types.HAConfig{
+			Enabled: true, NodeID: "node-1", ClusterID: "cluster-a", PeerNodes: []string{peerURL.Hostname()},
+			HeartbeatInterval: 10 * time.Millisecond, ElectionTimeout: 10 * time.Millisecond, PeerSecret: "peer-secret",
+		},
+	})
+	if err := haService.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	haService.becomeLeader()
+	<-haService.GetLeaderChannel()
+
+	// Let the leader tasks announce a few heartbeats
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := haService.Stop(ctx); err != nil {
+		t.Fatalf("expected Stop to finish within the timeout, got %v", err)
+	}
+
+	if haService.IsLeader() {
+		t.Error("expected the node to step down on shutdown")
+	}
+	select {
+	case isLeader := <-haService.GetLeaderChannel():
+		if isLeader {
+			t.Error("expected a stepped-down notification")
+		}
+	default:
+		t.Error("expected the step-down to be reported on the leader channel")
+	}
+
+	mu.Lock()
+	sent := announcements
+	if resignations != 1 {
+		t.Errorf("expected the peer to be told of the resignation once, got %d", resignations)
+	}
+	mu.Unlock()
+	if sent == 0 {
+		t.Error("expected heartbeats before shutdown")
+	}
+
+	// The leader tasks have exited, so no more heartbeats go out
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if announcements != sent {
+		t.Errorf("expected no heartbeats after Stop, got %d more", announcements-sent)
+	}
+}
+
+func TestHandleLeaderAnnouncementResignation(t *testing.T) {
+	haService := NewHAService(nil, &types.Config{
+		HA: types.HAConfig{Enabled: true, NodeID: "node-2", ClusterID: "cluster-a", HeartbeatInterval: time.Minute},
+	})
+	haService.peerNodes["node-1"] = &PeerNode{ID: "node-1", Status: "healthy", LastSeen: time.Now()}
+
+	haService.HandleLeaderAnnouncement(map[string]interface{}{"leader_id": "node-1", "cluster_id": "cluster-a"})
+	if !haService.hasHealthyLeader() {
+		t.Fatal("expected the announcing peer to be followed as leader")
+	}
+
+	haService.HandleLeaderAnnouncement(map[string]interface{}{"leader_id": "node-1", "cluster_id": "cluster-a", "resigned": true})
+	if haService.hasHealthyLeader() {
+		t.Error("expected no leader after it resigned, so an election can start")
+	}
+}
+
+func TestUnknownControllersCannotMoveTheTerm(t *testing.T) {
+	db := newHATestDB(t)
+	node := startHANode(t, db, "controller-1")
+	addPeers(node, "controller-2")
+
+	if resp := node.HandleVoteRequest(voteRequest("controller-9", 100)); resp.Success || resp.Term != 0 {
+		t.Errorf("expected a vote request from an unknown controller to be refused at term 0, got %+v", resp)
+	}
+	node.HandleLeaderAnnouncement(map[string]interface{}{"leader_id": "controller-9", "cluster_id": "cluster-a", "term": float64(200)})
+	if term := node.CurrentTerm(); term != 0 {
+		t.Errorf("expected unknown controllers not to move the term, got %d", term)
+	}
+	if node.hasHealthyLeader() {
+		t.Error("expected an unknown controller not to be followed as leader")
+	}
+
+	var count int64
+	db.Model(&HAElectionState{}).Count(&count)
+	if count != 0 {
+		t.Errorf("expected no election state persisted, got %d rows", count)
+	}
+
+	if resp := node.HandleVoteRequest(voteRequest("controller-2", 3)); !resp.Success || resp.Term != 3 {
+		t.Errorf("expected a known peer to still be voted for, got %+v", resp)
+	}
+}
+
+func TestStartRequiresPeerSecret(t *testing.T) {
+	haService := NewHAService(nil, &types.Config{
+		HA: types.HAConfig{Enabled: true, NodeID: "controller-1", ClusterID: "cluster-a", HeartbeatInterval: time.Hour},
+	})
+	if err := haService.Start(context.Background()); !errors.Is(err, ErrPeerSecretMissing) {
+		t.Fatalf("expected ErrPeerSecretMissing, got %v", err)
+	}
+}
+
+func TestCheckPeerHealthAdoptsReportedID(t *testing.T) {
+	host, port := newPeerHealthServer(t, "controller-2")
+
+	haService := NewHAService(nil, &types.Config{
+		HA: types.HAConfig{Enabled: true, NodeID: "controller-1", ClusterID: "cluster-a"},
+	})
+	// Static peers start out under a made up ID
+	haService.peerNodes["static-peer"] = &PeerNode{ID: "static-peer", Address: host, Port: int(port), Status: "unknown"}
+
+	haService.checkPeerHealth(context.Background())
+	haService.wg.Wait()
+
+	haService.mutex.RLock()
+	defer haService.mutex.RUnlock()
+	if !haService.isKnownPeer("controller-2") || haService.isKnownPeer("static-peer") {
+		t.Errorf("expected the peer to be known by its reported ID, got %v", haService.peerNodes)
+	}
+}
+
+// stubResolver serves discovery lookups from fixed records.
+type stubResolver struct {
+	srv   []*net.SRV
+	hosts []string
+	err   error
+}
+
+func (r *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return "", r.srv, r.err
+}
+
+func (r *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return r.hosts, r.err
+}
+
+// newPeerHealthServer starts a controller stub whose /ha/health reports nodeID.
+func newPeerHealthServer(t *testing.T, nodeID string) (string, uint16) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ha/health" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(types.APIResponse{
+			Success: true,
+			Data:    HealthResponse{NodeID: nodeID, Status: "healthy", Timestamp: time.Now(), Version: "1.0.0"},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	host, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	return host, uint16(port)
+}
+
+func newDiscoveryTestService(resolver peerResolver) *HAService {
+	haService := NewHAService(nil, &types.Config{
+		HA: types.HAConfig{Enabled: true, NodeID: "controller-1", ClusterID: "cluster-a", DiscoveryDNS: "_wg-controller._tcp.example.com"},
+	})
+	haService.resolver = resolver
+	return haService
+}
+
+func TestDiscoverPeersAddsAndRemovesPeers(t *testing.T) {
+	host2, port2 := newPeerHealthServer(t, "controller-2")
+	host3, port3 := newPeerHealthServer(t, "controller-3")
+	resolver := &stubResolver{srv: []*net.SRV{
+		{Target: host2 + ".", Port: port2},
+		{Target: host3 + ".", Port: port3},
+	}}
+	haService := newDiscoveryTestService(resolver)
+
+	if err := haService.discoverPeers(context.Background()); err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	if len(haService.peerNodes) != 2 {
+		t.Fatalf("expected 2 discovered peers, got %d", len(haService.peerNodes))
+	}
+	peer, ok := haService.peerNodes["controller-2"]
+	if !ok {
+		t.Fatalf("expected peer keyed by reported node ID, got %v", haService.peerNodes)
+	}
+	if peer.Address != host2 || peer.Port != int(port2) || peer.Status != "healthy" {
+		t.Errorf("unexpected peer: %+v", peer)
+	}
+
+	// controller-2 disappears from DNS
+	resolver.srv = resolver.srv[1:]
+	if err := haService.discoverPeers(context.Background()); err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	if _, ok := haService.peerNodes["controller-2"]; ok {
+		t.Error("expected peer removed from DNS to be dropped")
+	}
+	if _, ok := haService.peerNodes["controller-3"]; !ok || len(haService.peerNodes) != 1 {
+		t.Errorf("expected only controller-3 to remain, got %v", haService.peerNodes)
+	}
+}
+
+func TestDiscoverPeersKeepsIDAcrossReconnect(t *testing.T) {
+	host, port := newPeerHealthServer(t, "controller-2")
+	resolver := &stubResolver{srv: []*net.SRV{{Target: host, Port: port}}}
+	haService := newDiscoveryTestService(resolver)
+
+	if err := haService.discoverPeers(context.Background()); err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+
+	// The same controller comes back on a new address
+	newHost, newPort := newPeerHealthServer(t, "controller-2")
+	resolver.srv = []*net.SRV{{Target: newHost, Port: newPort}}
+	if err := haService.discoverPeers(context.Background()); err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+
+	peer, ok := haService.peerNodes["controller-2"]
+	if !ok || len(haService.peerNodes) != 1 {
+		t.Fatalf("expected reconnected controller under the same ID, got %v", haService.peerNodes)
+	}
+	if peer.Port != int(newPort) {
+		t.Errorf("expected peer port to follow DNS to %d, got %d", newPort, peer.Port)
+	}
+}
+
+func TestDiscoverPeersSkipsSelfAndUnreachable(t *testing.T) {
+	selfHost, selfPort := newPeerHealthServer(t, "controller-1")
+	resolver := &stubResolver{srv: []*net.SRV{
+		{Target: selfHost, Port: selfPort},
+		{Target: "127.0.0.1", Port: 1},
+	}}
+	haService := newDiscoveryTestService(resolver)
+
+	if err := haService.discoverPeers(context.Background()); err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	if len(haService.peerNodes) != 0 {
+		t.Errorf("expected neither self nor unreachable endpoints to be added, got %v", haService.peerNodes)
+	}
+}
+
+func TestDiscoverPeersARecords(t *testing.T) {
+	host, port := newPeerHealthServer(t, "controller-2")
+	haService := newDiscoveryTestService(&stubResolver{hosts: []string{host}})
+	haService.config.HA.DiscoveryDNS = "controllers.example.com"
+	haService.config.Server.Port = int(port)
+
+	if err := haService.discoverPeers(context.Background()); err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	if peer, ok := haService.peerNodes["controller-2"]; !ok || peer.Port != int(port) {
+		t.Errorf("expected A record peer on the controller port, got %v", haService.peerNodes)
+	}
+}
+
+func TestDiscoverPeersResolutionErrorKeepsPeers(t *testing.T) {
+	resolver := &stubResolver{err: errors.New("no such host")}
+	haService := newDiscoveryTestService(resolver)
+	haService.peerNodes["controller-2"] = &PeerNode{ID: "controller-2", Address: "10.0.0.2", Port: 8080}
+
+	if err := haService.discoverPeers(context.Background()); err == nil {
+		t.Fatal("expected resolution error")
+	}
+	if len(haService.peerNodes) != 1 {
+		t.Errorf("expected peers to be kept when DNS fails, got %v", haService.peerNodes)
+	}
+}
+
+// testPeer returns a peer pointing at server.
+func testPeer(t *testing.T, id string, server *httptest.Server) *PeerNode {
+	t.Helper()
+
+	addr, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("failed to parse server URL: %v", err)
+	}
+	host, portStr, _ := net.SplitHostPort(addr.Host)
+	port, _ := strconv.Atoi(portStr)
+	return &PeerNode{ID: id, Address: host, Port: port, Status: "unknown", LastSeen: time.Now()}
+}
+
+func TestClusterStatusReportsSlowAndUnreachablePeers(t *testing.T) {
+	healthServer := func(delay time.Duration) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			json.NewEncoder(w).Encode(types.APIResponse{Success: true, Data: HealthResponse{Status: "healthy"}})
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	haService := NewHAService(nil, &types.Config{
+		HA: types.HAConfig{Enabled: true, NodeID: "controller-1", HeartbeatInterval: time.Minute, SlowPeerLatency: 50 * time.Millisecond},
+	})
+	haService.isLeader = true
+	haService.peerNodes["controller-2"] = testPeer(t, "controller-2", healthServer(0))
+	haService.peerNodes["controller-3"] = testPeer(t, "controller-3", healthServer(100*time.Millisecond))
+
+	down := healthServer(0)
+	haService.peerNodes["controller-4"] = testPeer(t, "controller-4", down)
+	haService.peerNodes["controller-4"].LastSeen = time.Now().Add(-5 * time.Minute)
+	down.Close()
+
+	haService.checkPeerHealth(context.Background())
+	haService.wg.Wait()
+
+	status := haService.GetClusterStatus()
+	if !status.Degraded || status.Healthy {
+		t.Fatalf("expected a degraded, unhealthy cluster, got degraded=%v healthy=%v", status.Degraded, status.Healthy)
+	}
+	if len(status.DegradedReasons) != 2 ||
+		!strings.Contains(status.DegradedReasons[0], "controller-3") ||
+		!strings.Contains(status.DegradedReasons[1], "controller-4 not seen for 5m0s") {
+		t.Errorf("expected the slow and unreachable peers as reasons, got %v", status.DegradedReasons)
+	}
+
+	if fast := status.Nodes["controller-2"]; fast.Status != "healthy" || fast.Latency <= 0 || fast.Latency >= 50 {
+		t.Errorf("expected a fast healthy peer, got status %q latency %.1fms", fast.Status, fast.Latency)
+	}
+	if slow := status.Nodes["controller-3"]; slow.Latency < 100 {
+		t.Errorf("expected the slow peer's latency to be measured, got %.1fms", slow.Latency)
+	}
+	unreachable := status.Nodes["controller-4"]
+	if unreachable.Status != "unhealthy" || unreachable.Latency != 0 {
+		t.Errorf("expected an unhealthy peer without latency, got status %q latency %.1fms", unreachable.Status, unreachable.Latency)
+	}
+	if unreachable.SecondsSinceLastSeen < 300 || unreachable.LastSeenAgo != "5m0s" {
+		t.Errorf("expected the unreachable peer last seen 5m ago, got %ds (%s)", unreachable.SecondsSinceLastSeen, unreachable.LastSeenAgo)
+	}
+
+	// The status is a copy, so the next health check doesn't change it
+	unreachable.Status = "changed"
+	if haService.peerNodes["controller-4"].Status != "unhealthy" {
+		t.Error("expected the cluster status not to share peers with the service")
+	}
+}
+
+func TestClusterStatusWithoutLeaderIsDegraded(t *testing.T) {
+	haService := NewHAService(nil, &types.Config{
+		HA: types.HAConfig{Enabled: true, NodeID: "controller-1", HeartbeatInterval: time.Minute},
+	})
+	haService.peerNodes["controller-2"] = &PeerNode{ID: "controller-2", Status: "healthy", LastSeen: time.Now(), Latency: 2}
+
+	status := haService.GetClusterStatus()
+	if !status.Degraded || len(status.DegradedReasons) != 1 || status.DegradedReasons[0] != "no leader elected" {
+		t.Errorf("expected the missing leader as the only reason, got %v", status.DegradedReasons)
+	}
+	if !status.Healthy {
+		t.Error("expected every peer to count as seen")
+	}
+
+	haService.isLeader = true
+	if status := haService.GetClusterStatus(); status.Degraded {
+		t.Errorf("expected a cluster with a leader and healthy peers not to be degraded, got %v", status.DegradedReasons)
+	}
+}
+
+func TestPeerCallsUseTLS(t *testing.T) {
+	var announcements sync.WaitGroup
+	announcements.Add(1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ha/health":
+			json.NewEncoder(w).Encode(types.APIResponse{Success: true, Data: HealthResponse{Status: "healthy"}})
+		case "/ha/election":
+			json.NewEncoder(w).Encode(types.APIResponse{Success: true, Data: LeaderElectionResponse{Success: true, Term: 1}})
+		case "/ha/leader":
+			announcements.Done()
+		default:
+			w.Write([]byte(`{"success":true,"message":"served by leader"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	// The peers' certificate is trusted through TLS_CA_FILE only
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+
+	haService := NewHAService(nil, &types.Config{
+		Server: types.ServerConfig{TLS: types.TLSConfig{Enabled: true, CAFile: caFile}},
+		HA:     types.HAConfig{Enabled: true, NodeID: "controller-1", ClusterID: "cluster-a"},
+	})
+	peer := testPeer(t, "controller-2", server)
+	peer.IsLeader = true
+	haService.peerNodes["controller-2"] = peer
+
+	if health := haService.checkSinglePeerHealth(context.Background(), peer); health == nil || health.Status != "healthy" {
+		t.Errorf("expected a healthy peer over HTTPS, got %+v", health)
+	}
+	if !haService.requestVote(context.Background(), peer, 1) {
+		t.Error("expected the vote to be granted over HTTPS")
+	}
+	haService.announceResignation(context.Background())
+	announcements.Wait()
+
+	if leader := haService.leaderURL(); leader == nil || leader.Scheme != "https" {
+		t.Fatalf("expected an https leader URL, got %v", leader)
+	}
+	recorder := httptest.NewRecorder()
+	haService.EnsureLeaderOrProxy(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("follower must not serve write requests locally")
+	})(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/nodes", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "served by leader") {
+		t.Errorf("expected the request proxied to the leader over HTTPS, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Without the CA the peer's certificate is refused
+	untrusted := NewHAService(nil, &types.Config{
+		Server: types.ServerConfig{TLS: types.TLSConfig{Enabled: true}},
+		HA:     types.HAConfig{Enabled: true, NodeID: "controller-3"},
+	})
+	if health := untrusted.checkSinglePeerHealth(context.Background(), peer); health != nil {
+		t.Error("expected a peer with an untrusted certificate to be unreachable")
+	}
+}
@@ -2,12 +2,17 @@ package services
 
 import (
 	"context"
+	"log/slog"
 	"time"
 
 	"github.com/wg-hubspoke/wg-hubspoke/common/types"
 	"gorm.io/gorm"
 )
 
+// databaseCheckTimeout bounds the database ping, so a hung connection fails
+// the check rather than the orchestrator's probe timing out.
+const databaseCheckTimeout = 2 * time.Second
+
 type HealthService struct {
 	db      *gorm.DB
 	version string
@@ -43,16 +48,24 @@ func (s *HealthService) GetHealthStatus(ctx context.Context) *types.HealthStatus
 	return status
 }
 
+// IsReady reports whether the controller can serve requests, which needs
+// the database to be reachable.
 func (s *HealthService) IsReady(ctx context.Context) bool {
-	return s.checkDatabase(ctx) == nil
+	if err := s.checkDatabase(ctx); err != nil {
+		slog.WarnContext(ctx, "Readiness check failed: database unreachable", "error", err)
+		return false
+	}
+	return true
 }
 
 func (s *HealthService) checkDatabase(ctx context.Context) error {
-	// Simple database connection check
 	sqlDB, err := s.db.DB()
 	if err != nil {
 		return err
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, databaseCheckTimeout)
+	defer cancel()
+
 	return sqlDB.PingContext(ctx)
-}
\ No newline at end of file
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+const heartbeatSweepInterval = time.Minute
+
+// RecordHeartbeat stamps the node's last contact from its agent. It is kept
+// on the node itself, so stale nodes are still found after a controller
+// restart loses the in-memory metrics.
+func (s *NodeService) RecordHeartbeat(ctx context.Context, id uuid.UUID, now time.Time) error {
+	// UpdateColumn leaves updated_at to real changes
+	result := s.db.WithContext(ctx).Model(&models.Node{}).Where("id = ?", id).UpdateColumn("last_seen", now)
+	if result.Error != nil {
+		return fmt.Errorf("failed to record heartbeat: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNodeNotFound
+	}
+	return nil
+}
+
+// MarkStaleNodesInactive moves active nodes whose last heartbeat is older
+// than the configured timeout to inactive and returns them. Nodes that have
+// never sent a heartbeat are left alone, since there is nothing to say they
+// went quiet.
+func (s *NodeService) MarkStaleNodesInactive(ctx context.Context, now time.Time) ([]uuid.UUID, error) {
+	timeout := s.config.WG.HeartbeatTimeout
+	if timeout <= 0 {
+		return nil, nil
+	}
+
+	cutoff := now.Add(-timeout)
+	var nodes []models.Node
+	if err := s.db.WithContext(ctx).Select("id", "name", "last_seen").
+		Where("status = ? AND last_seen < ?", models.NodeStatusActive, cutoff).
+		Find(&nodes).Error; err != nil {
+		return nil, fmt.Errorf("failed to find stale nodes: %w", err)
+	}
+
+	var marked []uuid.UUID
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		audit := s.auditService.WithTx(tx)
+		for _, node := range nodes {
+			// A heartbeat since the query keeps the node active
+			result := tx.Model(&models.Node{}).
+				Where("id = ? AND status = ? AND last_seen < ?", node.ID, models.NodeStatusActive, cutoff).
+				Update("status", models.NodeStatusInactive)
+			if result.Error != nil {
+				return fmt.Errorf("failed to mark node %s inactive: %w", node.Name, result.Error)
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+
+			audit.LogActionWithMetadata(ctx, nil, models.AuditActionUpdate, "node", &node.ID,
+				fmt.Sprintf("Marked node %s inactive after no heartbeat since %s", node.Name, node.LastSeen.Format(time.RFC3339)), "", "",
+				map[string]interface{}{
+					"previous_status": models.NodeStatusActive,
+					"status":          models.NodeStatusInactive,
+					"last_seen":       node.LastSeen,
+				})
+			marked = append(marked, node.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return marked, nil
+}
+
+// StartHeartbeatSweep runs MarkStaleNodesInactive every
+// heartbeatSweepInterval until ctx is done. It returns immediately when no
+// heartbeat timeout is configured.
+func (s *NodeService) StartHeartbeatSweep(ctx context.Context) {
+	if s.config.WG.HeartbeatTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(heartbeatSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if s.isLeader != nil && !s.isLeader() {
+				continue
+			}
+			marked, err := s.MarkStaleNodesInactive(ctx, now)
+			if err != nil {
+				slog.ErrorContext(ctx, "Heartbeat sweep failed", "error", err)
+			}
+			if len(marked) > 0 {
+				slog.WarnContext(ctx, "Marked nodes without a recent heartbeat inactive", "nodes", len(marked))
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+func newHeartbeatTestService(t *testing.T, timeout time.Duration) (*NodeService, *gorm.DB) {
+	t.Helper()
+
+	service, db := newKeyRotationTestService(t, 0)
+	service.config.WG.HeartbeatTimeout = timeout
+	return service, db
+}
+
+func nodeLastSeen(t *testing.T, db *gorm.DB, id uuid.UUID) (*time.Time, models.NodeStatus) {
+	t.Helper()
+
+	var node models.Node
+	if err := db.Select("last_seen", "status").Where("id = ?", id).First(&node).Error; err != nil {
+		t.Fatalf("failed to load node: %v", err)
+	}
+	return node.LastSeen, node.Status
+}
+
+func TestRecordHeartbeat(t *testing.T) {
+	service, db := newHeartbeatTestService(t, 5*time.Minute)
+	_, spokeID := newRotationTopology(t, db)
+	ctx := context.Background()
+
+	if lastSeen, _ := nodeLastSeen(t, db, spokeID); lastSeen != nil {
+		t.Fatalf("expected no heartbeat yet, got %v", lastSeen)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	if err := service.RecordHeartbeat(ctx, spokeID, now); err != nil {
+		t.Fatalf("RecordHeartbeat failed: %v", err)
+	}
+	if lastSeen, _ := nodeLastSeen(t, db, spokeID); lastSeen == nil || !lastSeen.Equal(now) {
+		t.Errorf("expected last_seen %v, got %v", now, lastSeen)
+	}
+
+	if err := service.RecordHeartbeat(ctx, uuid.New(), now); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("expected ErrNodeNotFound for an unknown node, got %v", err)
+	}
+}
+
+func TestMarkStaleNodesInactive(t *testing.T) {
+	service, db := newHeartbeatTestService(t, 5*time.Minute)
+	hubID, spokeID := newRotationTopology(t, db)
+	ctx := context.Background()
+	now := time.Now()
+
+	neverSeen := insertRotationTestNode(t, db, "spoke-2", "spoke", "spoke-2-key", "10.100.1.3/16", now)
+	disabled := insertRotationTestNode(t, db, "spoke-3", "spoke", "spoke-3-key", "10.100.1.4/16", now)
+	db.Exec("UPDATE nodes SET status = ?, last_seen = ? WHERE id = ?", models.NodeStatusDisabled, now.Add(-time.Hour), disabled)
+	for id, lastSeen := range map[uuid.UUID]time.Time{hubID: now.Add(-time.Minute), spokeID: now.Add(-10 * time.Minute)} {
+		if err := service.RecordHeartbeat(ctx, id, lastSeen); err != nil {
+			t.Fatalf("RecordHeartbeat failed: %v", err)
+		}
+	}
+
+	marked, err := service.MarkStaleNodesInactive(ctx, now)
+	if err != nil {
+		t.Fatalf("MarkStaleNodesInactive failed: %v", err)
+	}
+	if len(marked) != 1 || marked[0] != spokeID {
+		t.Fatalf("expected only spoke-1 to be marked inactive, got %v", marked)
+	}
+	for id, expected := range map[uuid.UUID]models.NodeStatus{
+		hubID:     models.NodeStatusActive,
+		spokeID:   models.NodeStatusInactive,
+		neverSeen: models.NodeStatusActive,
+		disabled:  models.NodeStatusDisabled,
+	} {
+		if _, status := nodeLastSeen(t, db, id); status != expected {
+			t.Errorf("expected node %s to be %s, got %s", id, expected, status)
+		}
+	}
+
+	// The hub stops peering with the stale spoke
+	config, err := service.GetNodeConfig(ctx, hubID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if keys := peerKeys(config); len(keys) != 0 {
+		t.Errorf("expected the hub to drop the inactive spoke, got %v", keys)
+	}
+
+	var audits int64
+	db.Table("audit_logs").Where("resource = ? AND resource_id = ?", "node", spokeID).Count(&audits)
+	if audits != 1 {
+		t.Errorf("expected the change to be audited once, got %d", audits)
+	}
+
+	// Nothing is left to sweep, and no timeout disables the sweep
+	if marked, err := service.MarkStaleNodesInactive(ctx, now); err != nil || len(marked) != 0 {
+		t.Errorf("expected nothing more to mark, got %v, %v", marked, err)
+	}
+	service.config.WG.HeartbeatTimeout = 0
+	if marked, err := service.MarkStaleNodesInactive(ctx, now.Add(time.Hour)); err != nil || len(marked) != 0 {
+		t.Errorf("expected the sweep to be disabled, got %v, %v", marked, err)
+	}
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+var ErrPublicKeyInUse = errors.New("public key is already used by another node")
+
+// keyRotationCheckInterval is how often scheduled rotation looks for nodes
+// whose keys are due.
+const keyRotationCheckInterval = time.Hour
+
+// SetLeaderCheck makes scheduled key rotation and the heartbeat sweep run
+// only while isLeader returns true, so an HA cluster rotates each node's keys
+// once.
+func (s *NodeService) SetLeaderCheck(isLeader func() bool) {
+	s.isLeader = isLeader
+}
+
+// RegisterApprovalActions lets key rotations and public key replacements
+// requested by an operator run once a second admin approves them.
+func (s *NodeService) RegisterApprovalActions(approvals *ApprovalService) {
+	approvals.RegisterAction(ApprovalActionRotateNodeKey, func(ctx context.Context, request *ApprovalRequest) (interface{}, error) {
+		var payload struct {
+			NodeID uuid.UUID `json:"node_id"`
+		}
+		if err := json.Unmarshal([]byte(request.Payload), &payload); err != nil {
+			return nil, fmt.Errorf("invalid key rotation request: %w", err)
+		}
+		return s.RequestKeyRotation(ctx, payload.NodeID, &request.RequestedBy)
+	})
+	approvals.RegisterAction(ApprovalActionSetNodeKey, func(ctx context.Context, request *ApprovalRequest) (interface{}, error) {
+		var payload struct {
+			NodeID    uuid.UUID `json:"node_id"`
+			PublicKey string    `json:"public_key"`
+		}
+		if err := json.Unmarshal([]byte(request.Payload), &payload); err != nil {
+			return nil, fmt.Errorf("invalid public key request: %w", err)
+		}
+		return s.UpdateNodePublicKey(ctx, payload.NodeID, payload.PublicKey, &request.RequestedBy)
+	})
+}
+
+// RequestKeyRotation asks a node's agent for a new key pair. The agent sees
+// rotate_key in its next config, generates the pair itself and submits the
+// public key with UpdateNodePublicKey, so the private key never leaves the
+// node. performedBy is nil for scheduled rotations.
+func (s *NodeService) RequestKeyRotation(ctx context.Context, id uuid.UUID, performedBy *uuid.UUID) (*models.Node, error) {
+	var node models.Node
+	if err := s.db.Where("id = ?", id).First(&node).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNodeNotFound
+		}
+		return nil, fmt.Errorf("failed to get node: %w", err)
+	}
+
+	if err := s.requestKeyRotation(ctx, &node, performedBy); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+func (s *NodeService) requestKeyRotation(ctx context.Context, node *models.Node, performedBy *uuid.UUID) error {
+	now := time.Now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(node).Update("key_rotation_requested_at", now).Error; err != nil {
+			return fmt.Errorf("failed to request key rotation for node %s: %w", node.Name, err)
+		}
+
+		s.auditService.WithTx(tx).LogActionWithMetadata(ctx, performedBy, models.AuditActionUpdate, "node", &node.ID,
+			fmt.Sprintf("Requested WireGuard key rotation for node %s", node.Name), "", "",
+			map[string]interface{}{
+				"public_key": node.PublicKey,
+				"scheduled":  performedBy == nil,
+			})
+		return nil
+	})
+}
+
+// UpdateNodePublicKey replaces a node's public key with one its agent
+// generated, completing any requested rotation. Peer configs are built from
+// the node's row, so every peer moves to the new key on its next refresh.
+// Submitting the current key again changes nothing. performedBy is nil when
+// the node's agent submits the key.
+func (s *NodeService) UpdateNodePublicKey(ctx context.Context, id uuid.UUID, publicKey string, performedBy *uuid.UUID) (*models.Node, error) {
+	if !s.isValidPublicKey(publicKey) {
+		return nil, ErrInvalidPublicKey
+	}
+
+	var node models.Node
+	if err := s.db.Where("id = ?", id).First(&node).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNodeNotFound
+		}
+		return nil, fmt.Errorf("failed to get node: %w", err)
+	}
+	if node.PublicKey == publicKey && node.KeyRotationRequestedAt == nil {
+		return &node, nil
+	}
+
+	var owners int64
+	if err := s.db.Model(&models.Node{}).Where("public_key = ? AND id <> ?", publicKey, id).Count(&owners).Error; err != nil {
+		return nil, fmt.Errorf("failed to check public key: %w", err)
+	}
+	if owners > 0 {
+		return nil, ErrPublicKeyInUse
+	}
+
+	oldPublicKey := node.PublicKey
+	now := time.Now()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"public_key":                publicKey,
+			"key_rotated_at":            now,
+			"key_rotation_requested_at": nil,
+		}
+		if err := tx.Model(&node).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update public key for node %s: %w", node.Name, err)
+		}
+
+		s.auditService.WithTx(tx).LogActionWithMetadata(ctx, performedBy, models.AuditActionUpdate, "node", &node.ID,
+			fmt.Sprintf("Rotated WireGuard key for node %s", node.Name), "", "",
+			map[string]interface{}{
+				"old_public_key": oldPublicKey,
+				"new_public_key": publicKey,
+			})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	node.PublicKey = publicKey
+	node.KeyRotatedAt = &now
+	node.KeyRotationRequestedAt = nil
+	return &node, nil
+}
+
+// RotateExpiredKeys requests new keys from every node that has gone longer
+// than the configured rotation interval without one, counting from its
+// registration if it was never rotated. Nodes with a request outstanding are
+// skipped. It returns the number of nodes asked.
+func (s *NodeService) RotateExpiredKeys(ctx context.Context) (int, error) {
+	interval := s.config.WG.KeyRotationInterval
+	if interval <= 0 {
+		return 0, nil
+	}
+
+	cutoff := time.Now().Add(-interval)
+	var nodes []models.Node
+	if err := s.db.Select("id", "name", "public_key").
+		Where("key_rotation_requested_at IS NULL").
+		Where("key_rotated_at < ? OR (key_rotated_at IS NULL AND created_at < ?)", cutoff, cutoff).
+		Find(&nodes).Error; err != nil {
+		return 0, fmt.Errorf("failed to find nodes due for key rotation: %w", err)
+	}
+
+	requested := 0
+	var errs []error
+	for i := range nodes {
+		if err := s.requestKeyRotation(ctx, &nodes[i], nil); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		requested++
+	}
+
+	return requested, errors.Join(errs...)
+}
+
+// StartKeyRotation requests new keys for expired nodes every keyRotationCheckInterval
+// until ctx is done. It returns immediately when no rotation interval is
+// configured.
+func (s *NodeService) StartKeyRotation(ctx context.Context) {
+	if s.config.WG.KeyRotationInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(keyRotationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.isLeader != nil && !s.isLeader() {
+				continue
+			}
+			requested, err := s.RotateExpiredKeys(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "Scheduled key rotation failed", "error", err)
+			}
+			if requested > 0 {
+				slog.InfoContext(ctx, "Requested WireGuard key rotation", "nodes", requested)
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+const (
+	rotationHubKey   = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	rotationSpokeKey = "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="
+)
+
+func newKeyRotationTestService(t *testing.T, interval time.Duration) (*NodeService, *gorm.DB) {
+	t.Helper()
+
+	db := openTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE nodes (
+			id TEXT PRIMARY KEY, name TEXT NOT NULL, node_type TEXT NOT NULL,
+			public_key TEXT NOT NULL, key_rotated_at DATETIME, key_rotation_requested_at DATETIME,
+			allocated_ip TEXT NOT NULL, endpoint TEXT, port INTEGER, status TEXT, last_seen DATETIME,
+			endpoint_probed_at DATETIME, endpoint_probe_error TEXT, peer_errors TEXT, peer_errors_reported_at DATETIME, agent_version TEXT, build_commit TEXT, persistent_keepalive INTEGER, mtu INTEGER,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE topology (
+			id TEXT PRIMARY KEY, hub_id TEXT NOT NULL, spoke_id TEXT NOT NULL,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		policiesTestTable,
+		auditLogsTestTable,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create test schema: %v", err)
+		}
+	}
+
+	service := NewNodeService(db, &types.Config{
+		WG: types.WGConfig{Subnet: "10.100.0.0/16", KeyRotationInterval: interval},
+	}, NewAuditService(db))
+	return service, db
+}
+
+func insertRotationTestNode(t *testing.T, db *gorm.DB, name, nodeType, publicKey, ip string, created time.Time) uuid.UUID {
+	t.Helper()
+
+	id := uuid.New()
+	err := db.Exec(`INSERT INTO nodes (id, name, node_type, public_key, allocated_ip, endpoint, port, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, name, nodeType, publicKey, ip, "203.0.113.1", 51820, models.NodeStatusActive, created).Error
+	if err != nil {
+		t.Fatalf("failed to insert node %s: %v", name, err)
+	}
+	return id
+}
+
+// newRotationTopology links one spoke to one hub.
+func newRotationTopology(t *testing.T, db *gorm.DB) (hubID, spokeID uuid.UUID) {
+	t.Helper()
+
+	hubID = insertRotationTestNode(t, db, "hub-1", "hub", rotationHubKey, "10.100.0.1/16", time.Now())
+	spokeID = insertRotationTestNode(t, db, "spoke-1", "spoke", rotationSpokeKey, "10.100.1.2/16", time.Now())
+	if err := db.Exec("INSERT INTO topology (id, hub_id, spoke_id) VALUES (?, ?, ?)", uuid.New(), hubID, spokeID).Error; err != nil {
+		t.Fatalf("failed to link spoke to hub: %v", err)
+	}
+	return hubID, spokeID
+}
+
+func peerKeys(config *types.NodeConfigResponse) []string {
+	keys := make([]string, 0, len(config.Peers))
+	for _, peer := range config.Peers {
+		keys = append(keys, peer.PublicKey)
+	}
+	return keys
+}
+
+func TestKeyRotationIsCompletedByTheAgent(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	hubID, spokeID := newRotationTopology(t, db)
+	ctx := context.Background()
+	admin := uuid.New()
+
+	if _, err := service.RequestKeyRotation(ctx, spokeID, &admin); err != nil {
+		t.Fatalf("RequestKeyRotation failed: %v", err)
+	}
+
+	// The agent is asked for a new key and is never sent a private key
+	config, err := service.GetNodeConfig(ctx, spokeID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if !config.RotateKey {
+		t.Error("expected the spoke's config to ask for a new key")
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	if strings.Contains(string(data), "private_key") {
+		t.Errorf("expected no private key in the config, got %s", data)
+	}
+
+	// Peers keep the old key until the agent submits its new one
+	hubConfig, err := service.GetNodeConfig(ctx, hubID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if keys := peerKeys(hubConfig); len(keys) != 1 || keys[0] != rotationSpokeKey {
+		t.Errorf("expected the hub to keep peering with %s, got %v", rotationSpokeKey, keys)
+	}
+	if hubConfig.RotateKey {
+		t.Error("expected the hub's keys to be left alone")
+	}
+
+	newPublicKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{4}, 32))
+	node, err := service.UpdateNodePublicKey(ctx, spokeID, newPublicKey, nil)
+	if err != nil {
+		t.Fatalf("UpdateNodePublicKey failed: %v", err)
+	}
+	if node.PublicKey != newPublicKey || node.KeyRotatedAt == nil || node.KeyRotationRequestedAt != nil {
+		t.Errorf("expected the rotation to be completed, got key %q rotated at %v requested at %v", node.PublicKey, node.KeyRotatedAt, node.KeyRotationRequestedAt)
+	}
+
+	var spoke models.Node
+	if err := db.Where("id = ?", spokeID).First(&spoke).Error; err != nil {
+		t.Fatalf("failed to load spoke: %v", err)
+	}
+	if spoke.PublicKey != newPublicKey || spoke.KeyRotationRequestedAt != nil {
+		t.Errorf("expected the spoke to store the new public key, got %q requested at %v", spoke.PublicKey, spoke.KeyRotationRequestedAt)
+	}
+
+	// The hub's peer for the spoke moves to the new key
+	hubConfig, err = service.GetNodeConfig(ctx, hubID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if keys := peerKeys(hubConfig); len(keys) != 1 || keys[0] != newPublicKey {
+		t.Errorf("expected the hub to peer with %s, got %v", newPublicKey, keys)
+	}
+	if config, _ := service.GetNodeConfig(ctx, spokeID); config.RotateKey {
+		t.Error("expected no further rotation to be asked for")
+	}
+
+	var audits int64
+	db.Table("audit_logs").Where("resource = ? AND resource_id = ? AND user_id = ?", "node", spokeID, admin).Count(&audits)
+	if audits != 1 {
+		t.Errorf("expected the request to be audited once, got %d", audits)
+	}
+	db.Table("audit_logs").Where("resource = ? AND resource_id = ? AND user_id IS NULL", "node", spokeID).Count(&audits)
+	if audits != 1 {
+		t.Errorf("expected the agent's new key to be audited once, got %d", audits)
+	}
+}
+
+func TestUpdateNodePublicKeyRejectsBadKeys(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	_, spokeID := newRotationTopology(t, db)
+	ctx := context.Background()
+
+	if _, err := service.UpdateNodePublicKey(ctx, spokeID, "not-a-key", nil); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Errorf("expected ErrInvalidPublicKey, got %v", err)
+	}
+	if _, err := service.UpdateNodePublicKey(ctx, spokeID, rotationHubKey, nil); !errors.Is(err, ErrPublicKeyInUse) {
+		t.Errorf("expected ErrPublicKeyInUse for the hub's key, got %v", err)
+	}
+	if _, err := service.UpdateNodePublicKey(ctx, uuid.New(), rotationSpokeKey, nil); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("expected ErrNodeNotFound, got %v", err)
+	}
+	if _, err := service.RequestKeyRotation(ctx, uuid.New(), nil); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("expected ErrNodeNotFound, got %v", err)
+	}
+
+	// Submitting the current key again is a no-op
+	node, err := service.UpdateNodePublicKey(ctx, spokeID, rotationSpokeKey, nil)
+	if err != nil {
+		t.Fatalf("UpdateNodePublicKey failed: %v", err)
+	}
+	if node.KeyRotatedAt != nil {
+		t.Errorf("expected the unchanged key not to count as a rotation, got %v", node.KeyRotatedAt)
+	}
+}
+
+func TestRotateExpiredKeys(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 90*24*time.Hour)
+	ctx := context.Background()
+
+	old := time.Now().Add(-100 * 24 * time.Hour)
+	expired := insertRotationTestNode(t, db, "spoke-old", "spoke", rotationSpokeKey, "10.100.1.2/16", old)
+	recentlyRotated := insertRotationTestNode(t, db, "spoke-rotated", "spoke", rotationHubKey, "10.100.1.3/16", old)
+	insertRotationTestNode(t, db, "spoke-new", "spoke", rotationHubKey, "10.100.1.4/16", time.Now())
+	db.Exec("UPDATE nodes SET key_rotated_at = ? WHERE id = ?", time.Now().Add(-24*time.Hour), recentlyRotated)
+
+	requested, err := service.RotateExpiredKeys(ctx)
+	if err != nil {
+		t.Fatalf("RotateExpiredKeys failed: %v", err)
+	}
+	if requested != 1 {
+		t.Errorf("expected one node to be asked for a new key, got %d", requested)
+	}
+
+	var nodes []models.Node
+	db.Select("id", "public_key", "key_rotation_requested_at").Find(&nodes)
+	for _, node := range nodes {
+		asked := node.KeyRotationRequestedAt != nil
+		if want := node.ID == expired; asked != want {
+			t.Errorf("node %s: expected rotation requested=%v, got %v", node.ID, want, asked)
+		}
+	}
+
+	// The outstanding request isn't repeated while the agent catches up
+	if requested, err := service.RotateExpiredKeys(ctx); err != nil || requested != 0 {
+		t.Errorf("expected no further requests, got %d (%v)", requested, err)
+	}
+}
+
+func TestRotateExpiredKeysDisabled(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	insertRotationTestNode(t, db, "spoke-old", "spoke", rotationSpokeKey, "10.100.1.2/16", time.Now().AddDate(-1, 0, 0))
+
+	if rotated, err := service.RotateExpiredKeys(context.Background()); err != nil || rotated != 0 {
+		t.Errorf("expected rotation to be disabled without an interval, got %d (%v)", rotated, err)
+	}
+}
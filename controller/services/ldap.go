@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrLDAPInvalidCredentials = errors.New("invalid LDAP credentials")
+	ErrLDAPNoMatchingGroup    = errors.New("user is not in any LDAP group mapped to a role")
+)
+
+const (
+	defaultLDAPGroupAttribute = "memberOf"
+	defaultLDAPEmailAttribute = "mail"
+	defaultLDAPTimeout        = 10 * time.Second
+
+	// ldapShadowPassword is stored for provisioned users. It is not a bcrypt
+	// hash, so local password checks always fail for them.
+	ldapShadowPassword = "!ldap"
+)
+
+// LDAPUser is a directory user that bound successfully.
+type LDAPUser struct {
+	Username string
+	DN       string
+	Email    string
+	Groups   []string
+	Role     models.UserRole
+}
+
+// LDAPAuthenticator checks credentials by binding to an LDAP server as the
+// user and maps their groups to a role.
+type LDAPAuthenticator struct {
+	config types.LDAPConfig
+}
+
+func NewLDAPAuthenticator(config types.LDAPConfig) *LDAPAuthenticator {
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = defaultLDAPGroupAttribute
+	}
+	if config.EmailAttribute == "" {
+		config.EmailAttribute = defaultLDAPEmailAttribute
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultLDAPTimeout
+	}
+	return &LDAPAuthenticator{config: config}
+}
+
+// Authenticate binds as username and reads their groups and email.
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, username, password string) (*LDAPUser, error) {
+	// Most servers accept an empty password as an anonymous bind
+	if username == "" || password == "" {
+		return nil, ErrLDAPInvalidCredentials
+	}
+
+	dn := strings.ReplaceAll(a.config.UserDNTemplate, "%s", escapeLDAPDN(username))
+
+	conn, err := dialLDAP(ctx, a.config.URL, a.config.Timeout, a.config.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	if err := conn.bind(dn, password); err != nil {
+		var resultErr *ldapResultError
+		if errors.As(err, &resultErr) && resultErr.Code == ldapResultInvalidCredentials {
+			return nil, ErrLDAPInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP bind failed: %w", err)
+	}
+
+	entry, err := conn.readEntry(dn, []string{a.config.GroupAttribute, a.config.EmailAttribute})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LDAP entry: %w", err)
+	}
+
+	user := &LDAPUser{
+		Username: username,
+		DN:       dn,
+		Groups:   entry[strings.ToLower(a.config.GroupAttribute)],
+	}
+	if emails := entry[strings.ToLower(a.config.EmailAttribute)]; len(emails) > 0 {
+		user.Email = emails[0]
+	}
+
+	role, ok := a.roleForGroups(user.Groups)
+	if !ok {
+		return nil, ErrLDAPNoMatchingGroup
+	}
+	user.Role = role
+
+	return user, nil
+}
+
+// roleForGroups returns the most privileged role any of groups maps to.
+func (a *LDAPAuthenticator) roleForGroups(groups []string) (models.UserRole, bool) {
+	mappings := []struct {
+		role   models.UserRole
+		groups []string
+	}{
+		{models.UserRoleAdmin, a.config.AdminGroups},
+		{models.UserRoleOperator, a.config.OperatorGroups},
+		{models.UserRoleUser, a.config.UserGroups},
+		{models.UserRoleObserver, a.config.ObserverGroups},
+	}
+
+	for _, mapping := range mappings {
+		for _, mapped := range mapping.groups {
+			mapped = strings.TrimSpace(mapped)
+			for _, group := range groups {
+				if mapped != "" && strings.EqualFold(mapped, strings.TrimSpace(group)) {
+					return mapping.role, true
+				}
+			}
+		}
+	}
+
+	return "", false
+}
+
+// escapeLDAPDN escapes an attribute value for use in a DN (RFC 4514).
+func escapeLDAPDN(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case r == 0:
+			b.WriteString(`\00`)
+			continue
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			r == '#' && i == 0,
+			r == ' ' && (i == 0 || i == len(value)-1):
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// errUseLocalAuth tells Login to check the password against the local user.
+var errUseLocalAuth = errors.New("use local authentication")
+
+// loginWithLDAP authenticates against the directory and returns the local
+// shadow user, creating it on first login. Local accounts, rejected
+// credentials and an unreachable server fall back to local authentication.
+func (s *AuthService) loginWithLDAP(ctx context.Context, req LoginRequest, clientIP, userAgent string) (*models.User, error) {
+	var user models.User
+	err := s.db.WithContext(ctx).Where("username = ?", req.Username).First(&user).Error
+	exists := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	// An LDAP user must never take over a local account of the same name
+	if exists && user.AuthSource != models.AuthSourceLDAP {
+		return nil, errUseLocalAuth
+	}
+
+	ldapUser, err := s.ldap.Authenticate(ctx, req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, ErrLDAPNoMatchingGroup) {
+			if exists {
+				s.auditSvc.LogAction(ctx, &user.ID, models.AuditActionLogin, "user", &user.ID,
+					fmt.Sprintf("LDAP login rejected for user %s: no group mapped to a role", user.Username), clientIP, userAgent)
+			}
+			return nil, ErrUnauthorized
+		}
+		if !errors.Is(err, ErrLDAPInvalidCredentials) {
+			slog.WarnContext(ctx, "LDAP authentication unavailable, using local authentication", "error", err)
+		}
+		return nil, errUseLocalAuth
+	}
+
+	email := ldapUser.Email
+	if email == "" {
+		email = req.Username + "@ldap.invalid"
+	}
+
+	if !exists {
+		user = models.User{
+			Username:   req.Username,
+			Email:      email,
+			Password:   ldapShadowPassword,
+			Role:       ldapUser.Role,
+			IsActive:   true,
+			AuthSource: models.AuthSourceLDAP,
+		}
+		if err := s.db.WithContext(ctx).Create(&user).Error; err != nil {
+			return nil, fmt.Errorf("failed to provision LDAP user: %w", err)
+		}
+
+		s.auditSvc.LogActionWithMetadata(ctx, &user.ID, models.AuditActionCreate, "user", &user.ID,
+			fmt.Sprintf("User %s provisioned from LDAP", user.Username), clientIP, userAgent,
+			map[string]interface{}{"dn": ldapUser.DN, "role": ldapUser.Role})
+		return &user, nil
+	}
+
+	// Local admins can still disable a directory user
+	if !user.IsActive {
+		return nil, ErrUnauthorized
+	}
+
+	if user.Role != ldapUser.Role || user.Email != email {
+		previousRole := user.Role
+		if err := s.db.WithContext(ctx).Model(&user).Updates(map[string]interface{}{
+			"role":  ldapUser.Role,
+			"email": email,
+		}).Error; err != nil {
+			return nil, fmt.Errorf("failed to update LDAP user: %w", err)
+		}
+		user.Role = ldapUser.Role
+		user.Email = email
+
+		s.auditSvc.LogActionWithMetadata(ctx, &user.ID, models.AuditActionUpdate, "user", &user.ID,
+			fmt.Sprintf("User %s updated from LDAP", user.Username), clientIP, userAgent,
+			map[string]interface{}{"previous_role": previousRole, "role": ldapUser.Role})
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,397 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// This is the small part of LDAPv3 (RFC 4511) needed to authenticate a user:
+// a simple bind followed by a base-object search of the user's entry.
+
+const (
+	berClassUniversal   = 0x00
+	berClassApplication = 0x40
+	berClassContext     = 0x80
+
+	berTagBoolean     = 0x01
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x10
+	berTagSet         = 0x11
+
+	ldapOpBindRequest       = 0
+	ldapOpBindResponse      = 1
+	ldapOpUnbindRequest     = 2
+	ldapOpSearchRequest     = 3
+	ldapOpSearchResultEntry = 4
+	ldapOpSearchResultDone  = 5
+
+	ldapResultSuccess            = 0
+	ldapResultInvalidCredentials = 49
+
+	// Responses larger than this are not expected for a single entry
+	maxLDAPMessageSize = 1 << 20
+)
+
+// berPacket is a decoded BER element. Primitive elements carry their value,
+// constructed ones their children.
+type berPacket struct {
+	class       byte
+	constructed bool
+	tag         byte
+	value       []byte
+	children    []*berPacket
+}
+
+func berPrimitive(class, tag byte, value []byte) *berPacket {
+	return &berPacket{class: class, tag: tag, value: value}
+}
+
+func berConstructed(class, tag byte, children ...*berPacket) *berPacket {
+	return &berPacket{class: class, constructed: true, tag: tag, children: children}
+}
+
+func berString(value string) *berPacket {
+	return berPrimitive(berClassUniversal, berTagOctetString, []byte(value))
+}
+
+func berInteger(tag byte, value int64) *berPacket {
+	var encoded []byte
+	for {
+		encoded = append([]byte{byte(value)}, encoded...)
+		value >>= 8
+		if (value == 0 && encoded[0]&0x80 == 0) || (value == -1 && encoded[0]&0x80 != 0) {
+			break
+		}
+	}
+	return berPrimitive(berClassUniversal, tag, encoded)
+}
+
+func berSequence(children ...*berPacket) *berPacket {
+	return berConstructed(berClassUniversal, berTagSequence, children...)
+}
+
+func (p *berPacket) encode() []byte {
+	content := p.value
+	if p.constructed {
+		content = nil
+		for _, child := range p.children {
+			content = append(content, child.encode()...)
+		}
+	}
+
+	identifier := p.class | p.tag
+	if p.constructed {
+		identifier |= 0x20
+	}
+
+	out := []byte{identifier}
+	if len(content) < 0x80 {
+		out = append(out, byte(len(content)))
+	} else {
+		var length []byte
+		for n := len(content); n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		out = append(out, 0x80|byte(len(length)))
+		out = append(out, length...)
+	}
+	return append(out, content...)
+}
+
+func (p *berPacket) int64() (int64, error) {
+	if p.constructed || len(p.value) == 0 || len(p.value) > 8 {
+		return 0, errors.New("malformed BER integer")
+	}
+	value := int64(int8(p.value[0]))
+	for _, b := range p.value[1:] {
+		value = value<<8 | int64(b)
+	}
+	return value, nil
+}
+
+func (p *berPacket) child(i int) (*berPacket, error) {
+	if !p.constructed || i >= len(p.children) {
+		return nil, errors.New("malformed LDAP message")
+	}
+	return p.children[i], nil
+}
+
+// readBERPacket reads one complete element from r.
+func readBERPacket(r *bufio.Reader) (*berPacket, error) {
+	identifier, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if identifier&0x1f == 0x1f {
+		return nil, errors.New("unsupported BER high tag number")
+	}
+
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		octets := int(first & 0x7f)
+		if octets == 0 || octets > 4 {
+			return nil, errors.New("unsupported BER length")
+		}
+		length = 0
+		for i := 0; i < octets; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxLDAPMessageSize {
+		return nil, fmt.Errorf("LDAP message of %d bytes is too large", length)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return decodeBER(identifier, content)
+}
+
+func decodeBER(identifier byte, content []byte) (*berPacket, error) {
+	p := &berPacket{
+		class:       identifier & 0xc0,
+		constructed: identifier&0x20 != 0,
+		tag:         identifier & 0x1f,
+	}
+	if !p.constructed {
+		p.value = content
+		return p, nil
+	}
+
+	r := bufio.NewReader(bytes.NewReader(content))
+	for {
+		child, err := readBERPacket(r)
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.children = append(p.children, child)
+	}
+}
+
+// ldapResultError is a non-success LDAPResult returned by the server.
+type ldapResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ldapResultError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("LDAP result code %d", e.Code)
+	}
+	return fmt.Sprintf("LDAP result code %d: %s", e.Code, e.Message)
+}
+
+type ldapConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int64
+}
+
+// dialLDAP connects to an ldap:// or ldaps:// URL.
+func dialLDAP(ctx context.Context, rawURL string, timeout time.Duration, insecureSkipVerify bool) (*ldapConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %w", err)
+	}
+
+	host := u.Host
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		tlsDialer := &tls.Dialer{
+			NetDialer: dialer,
+			Config: &tls.Config{
+				ServerName:         u.Hostname(),
+				InsecureSkipVerify: insecureSkipVerify,
+				MinVersion:         tls.VersionTLS12,
+			},
+		}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("unsupported LDAP URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	return &ldapConn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (c *ldapConn) send(op *berPacket) (int64, error) {
+	c.nextID++
+	message := berSequence(berInteger(berTagInteger, c.nextID), op)
+	if _, err := c.conn.Write(message.encode()); err != nil {
+		return 0, fmt.Errorf("failed to send LDAP request: %w", err)
+	}
+	return c.nextID, nil
+}
+
+// receive reads the next message for id and returns its protocol op.
+func (c *ldapConn) receive(id int64) (*berPacket, error) {
+	for {
+		message, err := readBERPacket(c.reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read LDAP response: %w", err)
+		}
+		idPacket, err := message.child(0)
+		if err != nil {
+			return nil, err
+		}
+		op, err := message.child(1)
+		if err != nil {
+			return nil, err
+		}
+		messageID, err := idPacket.int64()
+		if err != nil {
+			return nil, err
+		}
+		// Unsolicited notifications use message ID 0
+		if messageID == id {
+			return op, nil
+		}
+	}
+}
+
+// ldapResult checks the LDAPResult at the start of a response op.
+func ldapResult(op *berPacket) error {
+	codePacket, err := op.child(0)
+	if err != nil {
+		return err
+	}
+	code, err := codePacket.int64()
+	if err != nil {
+		return err
+	}
+	if code == ldapResultSuccess {
+		return nil
+	}
+
+	var message string
+	if diagnostic, err := op.child(2); err == nil {
+		message = string(diagnostic.value)
+	}
+	return &ldapResultError{Code: code, Message: message}
+}
+
+// bind performs a simple bind as dn.
+func (c *ldapConn) bind(dn, password string) error {
+	id, err := c.send(berConstructed(berClassApplication, ldapOpBindRequest,
+		berInteger(berTagInteger, 3),
+		berString(dn),
+		berPrimitive(berClassContext, 0, []byte(password)),
+	))
+	if err != nil {
+		return err
+	}
+
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.class != berClassApplication || op.tag != ldapOpBindResponse {
+		return errors.New("unexpected response to LDAP bind")
+	}
+	return ldapResult(op)
+}
+
+// readEntry fetches attributes of the entry at dn. Attribute names in the
+// result are lower case.
+func (c *ldapConn) readEntry(dn string, attributes []string) (map[string][]string, error) {
+	requested := make([]*berPacket, 0, len(attributes))
+	for _, attribute := range attributes {
+		requested = append(requested, berString(attribute))
+	}
+
+	id, err := c.send(berConstructed(berClassApplication, ldapOpSearchRequest,
+		berString(dn),
+		berInteger(berTagEnumerated, 0), // baseObject
+		berInteger(berTagEnumerated, 0), // neverDerefAliases
+		berInteger(berTagInteger, 1),    // size limit
+		berInteger(berTagInteger, 0),    // time limit
+		berPrimitive(berClassUniversal, berTagBoolean, []byte{0}),
+		berPrimitive(berClassContext, 7, []byte("objectClass")), // (objectClass=*)
+		berSequence(requested...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	entry := make(map[string][]string)
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		if op.class != berClassApplication {
+			return nil, errors.New("unexpected response to LDAP search")
+		}
+
+		switch op.tag {
+		case ldapOpSearchResultEntry:
+			attributeList, err := op.child(1)
+			if err != nil {
+				return nil, err
+			}
+			for _, attribute := range attributeList.children {
+				name, err := attribute.child(0)
+				if err != nil {
+					return nil, err
+				}
+				values, err := attribute.child(1)
+				if err != nil {
+					return nil, err
+				}
+				key := strings.ToLower(string(name.value))
+				for _, value := range values.children {
+					entry[key] = append(entry[key], string(value.value))
+				}
+			}
+		case ldapOpSearchResultDone:
+			if err := ldapResult(op); err != nil {
+				return nil, err
+			}
+			return entry, nil
+		}
+	}
+}
+
+func (c *ldapConn) close() error {
+	c.send(berPrimitive(berClassApplication, ldapOpUnbindRequest, nil))
+	return c.conn.Close()
+}
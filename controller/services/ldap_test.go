@@ -0,0 +1,348 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+const (
+	ldapTestAdmins    = "cn=wg-admins,ou=groups,dc=example,dc=com"
+	ldapTestUsers     = "cn=wg-users,ou=groups,dc=example,dc=com"
+	ldapTestObservers = "cn=wg-observers,ou=groups,dc=example,dc=com"
+)
+
+type ldapTestEntry struct {
+	password string
+	mail     string
+	groups   []string
+}
+
+// mockLDAPServer answers simple binds and base searches for a fixed set of
+// entries keyed by DN.
+type mockLDAPServer struct {
+	listener net.Listener
+	entries  map[string]*ldapTestEntry
+	binds    []string
+}
+
+func newMockLDAPServer(t *testing.T, entries map[string]*ldapTestEntry) *mockLDAPServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &mockLDAPServer{listener: listener, entries: entries}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (m *mockLDAPServer) url() string {
+	return "ldap://" + m.listener.Addr().String()
+}
+
+func (m *mockLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	reply := func(id *berPacket, op *berPacket) {
+		conn.Write(berSequence(id, op).encode())
+	}
+	result := func(tag byte, code int64) *berPacket {
+		return berConstructed(berClassApplication, tag,
+			berInteger(berTagEnumerated, code), berString(""), berString(""))
+	}
+
+	var bound *ldapTestEntry
+	for {
+		message, err := readBERPacket(reader)
+		if err != nil {
+			return
+		}
+		id, op := message.children[0], message.children[1]
+
+		switch op.tag {
+		case ldapOpBindRequest:
+			dn := string(op.children[1].value)
+			password := string(op.children[2].value)
+			m.binds = append(m.binds, dn)
+
+			entry, ok := m.entries[dn]
+			if !ok || entry.password != password {
+				reply(id, result(ldapOpBindResponse, ldapResultInvalidCredentials))
+				continue
+			}
+			bound = entry
+			reply(id, result(ldapOpBindResponse, ldapResultSuccess))
+		case ldapOpSearchRequest:
+			dn := string(op.children[0].value)
+			entry, ok := m.entries[dn]
+			if bound == nil || !ok {
+				reply(id, result(ldapOpSearchResultDone, 32)) // noSuchObject
+				continue
+			}
+
+			var groups []*berPacket
+			for _, group := range entry.groups {
+				groups = append(groups, berString(group))
+			}
+			reply(id, berConstructed(berClassApplication, ldapOpSearchResultEntry,
+				berString(dn),
+				berSequence(
+					berSequence(berString("memberOf"), berConstructed(berClassUniversal, berTagSet, groups...)),
+					berSequence(berString("mail"), berConstructed(berClassUniversal, berTagSet, berString(entry.mail))),
+				),
+			))
+			reply(id, result(ldapOpSearchResultDone, ldapResultSuccess))
+		case ldapOpUnbindRequest:
+			return
+		}
+	}
+}
+
+func ldapTestConfig(url string) types.LDAPConfig {
+	return types.LDAPConfig{
+		Enabled:        true,
+		URL:            url,
+		UserDNTemplate: "uid=%s,ou=people,dc=example,dc=com",
+		AdminGroups:    []string{ldapTestAdmins},
+		UserGroups:     []string{ldapTestUsers},
+		ObserverGroups: []string{ldapTestObservers},
+		Timeout:        5 * time.Second,
+	}
+}
+
+func newLDAPTestAuthService(t *testing.T, ldapConfig types.LDAPConfig) (*AuthService, *gorm.DB) {
+	t.Helper()
+
+	db := newImportTestDB(t)
+	if err := db.AutoMigrate(&RefreshToken{}); err != nil {
+		t.Fatalf("failed to migrate refresh_tokens: %v", err)
+	}
+
+	config := &types.Config{Auth: types.AuthConfig{
+		JWTSecret:     "ldap-test-secret",
+		JWTExpiration: 15 * time.Minute,
+		LDAP:          ldapConfig,
+	}}
+	return NewAuthService(db, config, NewAuditService(db)), db
+}
+
+func TestLDAPLoginProvisionsUser(t *testing.T) {
+	server := newMockLDAPServer(t, map[string]*ldapTestEntry{
+		"uid=dana,ou=people,dc=example,dc=com": {
+			password: "directory-pass",
+			mail:     "dana@example.com",
+			groups:   []string{ldapTestUsers},
+		},
+	})
+	service, db := newLDAPTestAuthService(t, ldapTestConfig(server.url()))
+	ctx := context.Background()
+
+	resp, err := service.Login(ctx, LoginRequest{Username: "dana", Password: "directory-pass"}, "10.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("LDAP login failed: %v", err)
+	}
+	if resp.Token == "" || resp.RefreshToken == "" {
+		t.Errorf("expected access and refresh tokens")
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", "dana").First(&user).Error; err != nil {
+		t.Fatalf("expected a shadow user to be provisioned: %v", err)
+	}
+	if user.AuthSource != models.AuthSourceLDAP || user.Role != models.UserRoleUser || user.Email != "dana@example.com" {
+		t.Errorf("unexpected shadow user: %+v", user)
+	}
+	if user.CheckPassword("directory-pass") {
+		t.Errorf("expected the directory password not to be stored locally")
+	}
+
+	// Group changes in the directory apply on the next login
+	server.entries["uid=dana,ou=people,dc=example,dc=com"].groups = []string{ldapTestAdmins}
+	resp, err = service.Login(ctx, LoginRequest{Username: "dana", Password: "directory-pass"}, "10.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("second LDAP login failed: %v", err)
+	}
+	if resp.User.ID != user.ID || resp.User.Role != models.UserRoleAdmin {
+		t.Errorf("expected the same user promoted to admin, got %s (%s)", resp.User.ID, resp.User.Role)
+	}
+
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected one shadow user, got %d", count)
+	}
+}
+
+func TestLDAPGroupRoleMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		groups  []string
+		want    models.UserRole
+		wantErr error
+	}{
+		{"admin wins over user", []string{ldapTestUsers, ldapTestAdmins}, models.UserRoleAdmin, nil},
+		{"user", []string{"cn=other,dc=example,dc=com", ldapTestUsers}, models.UserRoleUser, nil},
+		{"observer", []string{ldapTestObservers}, models.UserRoleObserver, nil},
+		{"case-insensitive", []string{"CN=WG-Admins,OU=Groups,DC=example,DC=com"}, models.UserRoleAdmin, nil},
+		{"unmapped", []string{"cn=other,dc=example,dc=com"}, "", ErrLDAPNoMatchingGroup},
+		{"no groups", nil, "", ErrLDAPNoMatchingGroup},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockLDAPServer(t, map[string]*ldapTestEntry{
+				"uid=erin,ou=people,dc=example,dc=com": {password: "pw", mail: "erin@example.com", groups: tt.groups},
+			})
+
+			user, err := NewLDAPAuthenticator(ldapTestConfig(server.url())).Authenticate(context.Background(), "erin", "pw")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate failed: %v", err)
+			}
+			if user.Role != tt.want {
+				t.Errorf("expected role %s, got %s", tt.want, user.Role)
+			}
+		})
+	}
+}
+
+func TestLDAPLoginRejectsUnmappedUser(t *testing.T) {
+	server := newMockLDAPServer(t, map[string]*ldapTestEntry{
+		"uid=frank,ou=people,dc=example,dc=com": {password: "pw", mail: "frank@example.com", groups: []string{"cn=other,dc=example,dc=com"}},
+	})
+	service, db := newLDAPTestAuthService(t, ldapTestConfig(server.url()))
+
+	if _, err := service.Login(context.Background(), LoginRequest{Username: "frank", Password: "pw"}, "", ""); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	if count != 0 {
+		t.Errorf("expected no user to be provisioned, got %d", count)
+	}
+}
+
+func TestLDAPBindFailure(t *testing.T) {
+	server := newMockLDAPServer(t, map[string]*ldapTestEntry{
+		"uid=dana,ou=people,dc=example,dc=com": {password: "directory-pass", groups: []string{ldapTestUsers}},
+	})
+	authenticator := NewLDAPAuthenticator(ldapTestConfig(server.url()))
+	ctx := context.Background()
+
+	if _, err := authenticator.Authenticate(ctx, "dana", "wrong"); !errors.Is(err, ErrLDAPInvalidCredentials) {
+		t.Errorf("expected ErrLDAPInvalidCredentials, got %v", err)
+	}
+	// An empty password would be an anonymous bind and must not reach the server
+	binds := len(server.binds)
+	if _, err := authenticator.Authenticate(ctx, "dana", ""); !errors.Is(err, ErrLDAPInvalidCredentials) {
+		t.Errorf("expected ErrLDAPInvalidCredentials for an empty password, got %v", err)
+	}
+	if len(server.binds) != binds {
+		t.Errorf("expected no bind for an empty password")
+	}
+	// DN special characters in the username are escaped
+	authenticator.Authenticate(ctx, "dana,ou=admins", "pw")
+	if last := server.binds[len(server.binds)-1]; last != `uid=dana\,ou\=admins,ou=people,dc=example,dc=com` {
+		t.Errorf("expected the username to be escaped in the DN, got %s", last)
+	}
+
+	service, db := newLDAPTestAuthService(t, ldapTestConfig(server.url()))
+	if _, err := service.Login(ctx, LoginRequest{Username: "dana", Password: "wrong"}, "", ""); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound after a failed bind, got %v", err)
+	}
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	if count != 0 {
+		t.Errorf("expected no user to be provisioned, got %d", count)
+	}
+}
+
+func TestLDAPLoginFallsBackToLocalUsers(t *testing.T) {
+	server := newMockLDAPServer(t, map[string]*ldapTestEntry{
+		"uid=admin,ou=people,dc=example,dc=com": {password: "directory-pass", groups: []string{ldapTestAdmins}},
+	})
+	service, db := newLDAPTestAuthService(t, ldapTestConfig(server.url()))
+	ctx := context.Background()
+
+	local := models.User{Username: "admin", Email: "admin@example.com", Role: models.UserRoleAdmin, IsActive: true}
+	local.SetPassword("local-pass")
+	if err := db.Create(&local).Error; err != nil {
+		t.Fatalf("failed to create local user: %v", err)
+	}
+
+	if _, err := service.Login(ctx, LoginRequest{Username: "admin", Password: "local-pass"}, "", ""); err != nil {
+		t.Errorf("expected local login to keep working: %v", err)
+	}
+	// The directory account of the same name cannot take over the local one
+	if _, err := service.Login(ctx, LoginRequest{Username: "admin", Password: "directory-pass"}, "", ""); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("expected ErrInvalidPassword for directory credentials on a local account, got %v", err)
+	}
+
+	// Local users can still log in while the directory is unreachable
+	server.listener.Close()
+	if _, err := service.Login(ctx, LoginRequest{Username: "admin", Password: "local-pass"}, "", ""); err != nil {
+		t.Errorf("expected local login while LDAP is down: %v", err)
+	}
+}
+
+func TestLDAPLoginLockout(t *testing.T) {
+	server := newMockLDAPServer(t, map[string]*ldapTestEntry{
+		"uid=dana,ou=people,dc=example,dc=com": {password: "directory-pass", groups: []string{ldapTestUsers}},
+	})
+	service, db := newLDAPTestAuthService(t, ldapTestConfig(server.url()))
+	for _, stmt := range securityTestSchema {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create security tables: %v", err)
+		}
+	}
+	securityService := NewSecurityService(db, service.config, service.auditSvc)
+	service.SetSecurityService(securityService)
+	ctx := context.Background()
+	maxAttempts := securityService.GetSecurityPolicies().MaxLoginAttempts
+
+	if _, err := service.Login(ctx, LoginRequest{Username: "dana", Password: "directory-pass"}, "10.0.0.7", "test"); err != nil {
+		t.Fatalf("LDAP login failed: %v", err)
+	}
+
+	for i := 0; i < maxAttempts; i++ {
+		if _, err := service.Login(ctx, LoginRequest{Username: "dana", Password: "wrong"}, "10.0.0.7", "test"); err == nil {
+			t.Fatalf("attempt %d: expected a wrong directory password to be rejected", i+1)
+		}
+	}
+	if !securityService.IsIPBlocked("10.0.0.7") {
+		t.Fatalf("expected the IP to be blocked after %d failed LDAP logins", maxAttempts)
+	}
+	if _, err := service.Login(ctx, LoginRequest{Username: "dana", Password: "directory-pass"}, "10.0.0.7", "test"); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("expected ErrAccountLocked for the directory password while locked, got %v", err)
+	}
+
+	if !isFailedLogin(fmt.Errorf("bind: %w", ErrLDAPInvalidCredentials)) {
+		t.Error("expected rejected LDAP credentials to count as a failed login")
+	}
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// RunIfLeader runs task unless isLeader reports that this controller is an
+// HA follower, so maintenance that deletes shared data runs once per cluster
+// instead of racing on every controller. A nil isLeader means there is no HA
+// and the task always runs. It reports whether the task ran; failures are
+// logged under name.
+func RunIfLeader(ctx context.Context, isLeader func() bool, name string, task func(context.Context) error) bool {
+	if isLeader != nil && !isLeader() {
+		slog.DebugContext(ctx, "Skipping maintenance task on HA follower", "task", name)
+		return false
+	}
+
+	if err := task(ctx); err != nil {
+		slog.ErrorContext(ctx, "Maintenance task failed", "task", name, "error", err)
+	}
+	return true
+}
+
+// RunPeriodicallyIfLeader calls RunIfLeader for task every interval until ctx
+// is done. Leadership is checked on each tick, so a controller that takes
+// over starts running the task without a restart.
+func RunPeriodicallyIfLeader(ctx context.Context, isLeader func() bool, interval time.Duration, name string, task func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			RunIfLeader(ctx, isLeader, name, task)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunIfLeader(t *testing.T) {
+	ctx := context.Background()
+	leader := func() bool { return true }
+	follower := func() bool { return false }
+
+	for _, tc := range []struct {
+		name     string
+		isLeader func() bool
+		want     bool
+	}{
+		{"leader", leader, true},
+		{"follower", follower, false},
+		{"without HA", nil, true},
+	} {
+		ran := false
+		task := func(context.Context) error {
+			ran = true
+			return nil
+		}
+		if got := RunIfLeader(ctx, tc.isLeader, "test", task); got != tc.want || ran != tc.want {
+			t.Errorf("%s: expected the task to run=%v, got reported %v, ran %v", tc.name, tc.want, got, ran)
+		}
+	}
+
+	// A failing task still counts as run
+	if !RunIfLeader(ctx, leader, "test", func(context.Context) error { return errors.New("boom") }) {
+		t.Error("expected a failing task to be reported as run")
+	}
+}
+
+func TestRunPeriodicallyIfLeaderFollowsLeadership(t *testing.T) {
+	var isLeader atomic.Bool
+	var runs atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		RunPeriodicallyIfLeader(ctx, isLeader.Load, 5*time.Millisecond, "test", func(context.Context) error {
+			runs.Add(1)
+			return nil
+		})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if n := runs.Load(); n != 0 {
+		t.Errorf("expected no runs on a follower, got %d", n)
+	}
+
+	// Taking over leadership starts the task without a restart
+	isLeader.Store(true)
+	deadline := time.Now().Add(time.Second)
+	for runs.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if runs.Load() == 0 {
+		t.Error("expected the task to run once leadership was gained")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected RunPeriodicallyIfLeader to return when the context is done")
+	}
+}
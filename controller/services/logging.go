@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+type requestIDKey struct{}
+
+// NewLogger builds the controller's logger from the log settings: JSON
+// unless the format is "text", at info level unless another is given.
+// Records logged with a context carrying a request ID include it as
+// request_id.
+func NewLogger(config types.LogConfig, w io.Writer) *slog.Logger {
+	options := &slog.HandlerOptions{Level: parseLogLevel(config.Level)}
+
+	var handler slog.Handler
+	if strings.EqualFold(config.Format, "text") {
+		handler = slog.NewTextHandler(w, options)
+	} else {
+		handler = slog.NewJSONHandler(w, options)
+	}
+
+	return slog.New(&requestIDHandler{Handler: handler})
+}
+
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// WithRequestID returns a copy of ctx that carries the ID of the request
+// being served.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// requestIDHandler adds the request ID from the record's context, so that
+// services only need to log with the context they were given.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h *requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &requestIDHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *requestIDHandler) WithGroup(name string) slog.Handler {
+	return &requestIDHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -3,21 +3,98 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/wg-hubspoke/wg-hubspoke/common/types"
 	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrUnknownMetric     = errors.New("unknown metric")
+	ErrAlertRuleNotFound = errors.New("alert rule not found")
+	ErrInvalidOperator   = errors.New("invalid alert operator")
+	ErrInvalidSeverity   = errors.New("invalid alert severity")
+)
+
+const (
+	AlertStatusActive   = "active"
+	AlertStatusResolved = "resolved"
+)
+
+var alertOperators = map[string]func(value, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	"<":  func(v, t float64) bool { return v < t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+}
+
+var alertSeverities = map[string]bool{
+	"info":     true,
+	"warning":  true,
+	"critical": true,
+}
+
+const (
+	TunnelStatusUp      = "up"
+	TunnelStatusStale   = "stale"
+	TunnelStatusDown    = "down"
+	TunnelStatusUnknown = "unknown"
+)
+
+// rekeyAfterTime is how often WireGuard renews a session's handshake while
+// the tunnel carries traffic.
+const rekeyAfterTime = 2 * time.Minute
+
+// nodeOfflineAfter is how long a node may go without reporting metrics
+// before it is considered offline.
+const nodeOfflineAfter = 5 * time.Minute
+
+// offlineCheckInterval is how often StartOfflineDetection looks for nodes
+// that stopped reporting.
+const offlineCheckInterval = time.Minute
+
+// maxHistoryPoints caps the number of points returned by GetMetricsHistory;
+// longer series are downsampled by averaging into equal time buckets.
+const maxHistoryPoints = 500
+
+// historyMetricColumns maps metric names accepted by GetMetricsHistory to
+// node_metrics_history columns.
+var historyMetricColumns = map[string]string{
+	"cpu_usage":     "cpu_usage",
+	"memory_usage":  "memory_usage",
+	"disk_usage":    "disk_usage",
+	"network_rx":    "network_rx",
+	"network_tx":    "network_tx",
+	"wg_peers":      "wg_peers",
+	"latency_ms":    "latency",
+	"packet_loss":   "packet_loss",
+	"bandwidth_bps": "bandwidth",
+}
+
 type MonitoringService struct {
-	db           *gorm.DB
-	nodeMetrics  sync.Map
-	systemMetrics *SystemMetrics
-	mutex        sync.RWMutex
+	db                  *gorm.DB
+	nodeMetrics         sync.Map
+	configFetches       sync.Map
+	systemMetrics       *SystemMetrics
+	notificationService *NotificationService
+	mutex               sync.RWMutex
+	subscribers         map[*nodeEventSubscriber]struct{}
+	subscribersMu       sync.Mutex
+	webhookService      *WebhookService
+	// offlineNodes holds the nodes reported offline, so each outage fires
+	// one offline event and the next report fires active
+	offlineNodes sync.Map
+	// controllerVersion and minAgentVersion flag outdated agents; see
+	// SetVersionPolicy
+	controllerVersion string
+	minAgentVersion   string
 }
 
 type NodeMetrics struct {
@@ -33,13 +110,48 @@ type NodeMetrics struct {
 	WGPeers        int       `json:"wg_peers"`
 	WGStatus       string    `json:"wg_status"`
 	WGLastHandshake time.Time `json:"wg_last_handshake"`
+	PersistentKeepalive int   `json:"persistent_keepalive"`
 	Latency        float64   `json:"latency_ms"`
 	PacketLoss     float64   `json:"packet_loss"`
-	Bandwidth      int64     `json:"bandwidth_bps"`
+	Bandwidth      int64     `json:"bandwidth_bps"` // rx+tx bytes per second
 	Errors         []string  `json:"errors"`
 	UpdatedAt      time.Time `json:"updated_at"`
 }
 
+type ConfigFetchStats struct {
+	NodeID        uuid.UUID     `json:"node_id"`
+	Count         int64         `json:"count"`
+	TotalLatency  time.Duration `json:"total_latency_ns"`
+	LastLatency   time.Duration `json:"last_latency_ns"`
+	MaxLatency    time.Duration `json:"max_latency_ns"`
+	LastFetchedAt time.Time     `json:"last_fetched_at"`
+}
+
+// NodeMetricsSample is a single persisted metrics sample for a node.
+type NodeMetricsSample struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	NodeID      uuid.UUID `json:"node_id" gorm:"type:uuid;not null;index:idx_node_metrics_history_node_time"`
+	CPUUsage    float64   `json:"cpu_usage"`
+	MemoryUsage float64   `json:"memory_usage"`
+	DiskUsage   float64   `json:"disk_usage"`
+	NetworkRx   int64     `json:"network_rx"`
+	NetworkTx   int64     `json:"network_tx"`
+	WGPeers     int       `json:"wg_peers"`
+	Latency     float64   `json:"latency_ms"`
+	PacketLoss  float64   `json:"packet_loss"`
+	Bandwidth   int64     `json:"bandwidth_bps"`
+	RecordedAt  time.Time `json:"recorded_at" gorm:"not null;index:idx_node_metrics_history_node_time"`
+}
+
+func (NodeMetricsSample) TableName() string {
+	return "node_metrics_history"
+}
+
+type MetricPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
 type SystemMetrics struct {
 	TotalNodes     int64     `json:"total_nodes"`
 	ActiveNodes    int64     `json:"active_nodes"`
@@ -58,31 +170,59 @@ type MetricsReport struct {
 }
 
 type AlertRule struct {
-	ID          uuid.UUID `json:"id"`
-	Name        string    `json:"name"`
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name        string    `json:"name" gorm:"not null"`
 	Description string    `json:"description"`
-	Metric      string    `json:"metric"`
-	Operator    string    `json:"operator"`
+	Metric      string    `json:"metric" gorm:"not null"`
+	Operator    string    `json:"operator" gorm:"not null"`
 	Threshold   float64   `json:"threshold"`
-	Severity    string    `json:"severity"`
-	Enabled     bool      `json:"enabled"`
+	Severity    string    `json:"severity" gorm:"not null;default:warning"`
+	Enabled     bool      `json:"enabled" gorm:"default:true"`
 	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (r *AlertRule) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+func (AlertRule) TableName() string {
+	return "alert_rules"
 }
 
 type Alert struct {
-	ID          uuid.UUID `json:"id"`
-	RuleID      uuid.UUID `json:"rule_id"`
-	NodeID      uuid.UUID `json:"node_id"`
-	Message     string    `json:"message"`
-	Severity    string    `json:"severity"`
-	Status      string    `json:"status"`
-	TriggeredAt time.Time `json:"triggered_at"`
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	RuleID      uuid.UUID  `json:"rule_id" gorm:"type:uuid;not null;index"`
+	NodeID      uuid.UUID  `json:"node_id" gorm:"type:uuid;not null;index"`
+	Message     string     `json:"message"`
+	Severity    string     `json:"severity"`
+	Status      string     `json:"status" gorm:"not null;index"` // active, resolved
+	Value       float64    `json:"value"`
+	TriggeredAt time.Time  `json:"triggered_at"`
 	ResolvedAt  *time.Time `json:"resolved_at"`
 }
 
-func NewMonitoringService(db *gorm.DB) *MonitoringService {
+func (Alert) TableName() string {
+	return "alerts"
+}
+
+type AlertRuleRequest struct {
+	Name        string  `json:"name" binding:"required"`
+	Description string  `json:"description"`
+	Metric      string  `json:"metric" binding:"required"`
+	Operator    string  `json:"operator" binding:"required"`
+	Threshold   float64 `json:"threshold"`
+	Severity    string  `json:"severity"`
+	Enabled     *bool   `json:"enabled"`
+}
+
+func NewMonitoringService(db *gorm.DB, notificationService *NotificationService) *MonitoringService {
 	return &MonitoringService{
-		db: db,
+		db:                  db,
+		notificationService: notificationService,
 		systemMetrics: &SystemMetrics{
 			LastUpdated: time.Now(),
 		},
@@ -104,57 +244,173 @@ func (s *MonitoringService) UpdateNodeMetrics(ctx context.Context, nodeID uuid.U
 		LastSeen:  time.Now(),
 		UpdatedAt: time.Now(),
 	}
+	if node.PersistentKeepalive != nil {
+		nodeMetrics.PersistentKeepalive = *node.PersistentKeepalive
+	}
 
-	// Extract metrics from map
-	if cpu, ok := metrics["cpu_usage"].(float64); ok {
+	parseNodeMetrics(metrics, nodeMetrics)
+
+	// Derive throughput from the counters of the previous sample
+	var previous *NodeMetrics
+	if loaded, ok := s.nodeMetrics.Load(nodeID); ok {
+		previous = loaded.(*NodeMetrics)
+		nodeMetrics.Bandwidth = computeBandwidth(previous, nodeMetrics)
+	}
+
+	// Store metrics
+	s.nodeMetrics.Store(nodeID, nodeMetrics)
+	s.publishNodeMetrics(previous, nodeMetrics)
+
+	if _, wasOffline := s.offlineNodes.LoadAndDelete(nodeID); wasOffline && s.webhookService != nil {
+		s.webhookService.NotifyNodeEvent(NodeEventActive, &node, "")
+	}
+
+	// Persist sample for history queries
+	sample := &NodeMetricsSample{
+		NodeID:      nodeID,
+		CPUUsage:    nodeMetrics.CPUUsage,
+		MemoryUsage: nodeMetrics.MemoryUsage,
+		DiskUsage:   nodeMetrics.DiskUsage,
+		NetworkRx:   nodeMetrics.NetworkRx,
+		NetworkTx:   nodeMetrics.NetworkTx,
+		WGPeers:     nodeMetrics.WGPeers,
+		Latency:     nodeMetrics.Latency,
+		PacketLoss:  nodeMetrics.PacketLoss,
+		Bandwidth:   nodeMetrics.Bandwidth,
+		RecordedAt:  nodeMetrics.UpdatedAt,
+	}
+	if err := s.db.Create(sample).Error; err != nil {
+		slog.ErrorContext(ctx, "Failed to store metrics history", "node_id", nodeID, "error", err)
+	}
+
+	// Update node last handshake in database
+	if !nodeMetrics.WGLastHandshake.IsZero() {
+		s.db.Model(&node).Update("last_handshake", nodeMetrics.WGLastHandshake)
+	}
+
+	// Check alert rules
+	s.checkAlerts(ctx, nodeMetrics)
+
+	return nil
+}
+
+// parseNodeMetrics copies the reported values onto nodeMetrics. Reports are
+// decoded from JSON, so numbers arrive as float64 (or json.Number when the
+// decoder uses UseNumber) regardless of the target field's type.
+func parseNodeMetrics(metrics map[string]interface{}, nodeMetrics *NodeMetrics) {
+	if cpu, ok := metricFloat(metrics["cpu_usage"]); ok {
 		nodeMetrics.CPUUsage = cpu
 	}
-	if memory, ok := metrics["memory_usage"].(float64); ok {
+	if memory, ok := metricFloat(metrics["memory_usage"]); ok {
 		nodeMetrics.MemoryUsage = memory
 	}
-	if disk, ok := metrics["disk_usage"].(float64); ok {
+	if disk, ok := metricFloat(metrics["disk_usage"]); ok {
 		nodeMetrics.DiskUsage = disk
 	}
-	if rx, ok := metrics["network_rx"].(int64); ok {
+	if rx, ok := metricInt64(metrics["network_rx"]); ok {
 		nodeMetrics.NetworkRx = rx
 	}
-	if tx, ok := metrics["network_tx"].(int64); ok {
+	if tx, ok := metricInt64(metrics["network_tx"]); ok {
 		nodeMetrics.NetworkTx = tx
 	}
-	if peers, ok := metrics["wg_peers"].(int); ok {
-		nodeMetrics.WGPeers = peers
+	if peers, ok := metricInt64(metrics["wg_peers"]); ok {
+		nodeMetrics.WGPeers = int(peers)
 	}
 	if status, ok := metrics["wg_status"].(string); ok {
 		nodeMetrics.WGStatus = status
 	}
-	if handshake, ok := metrics["wg_last_handshake"].(time.Time); ok {
+	if handshake, ok := metricTime(metrics["wg_last_handshake"]); ok {
 		nodeMetrics.WGLastHandshake = handshake
 	}
-	if latency, ok := metrics["latency_ms"].(float64); ok {
+	if latency, ok := metricFloat(metrics["latency_ms"]); ok {
 		nodeMetrics.Latency = latency
 	}
-	if loss, ok := metrics["packet_loss"].(float64); ok {
+	if loss, ok := metricFloat(metrics["packet_loss"]); ok {
 		nodeMetrics.PacketLoss = loss
 	}
-	if bandwidth, ok := metrics["bandwidth_bps"].(int64); ok {
+	if bandwidth, ok := metricInt64(metrics["bandwidth_bps"]); ok {
 		nodeMetrics.Bandwidth = bandwidth
 	}
-	if errors, ok := metrics["errors"].([]string); ok {
-		nodeMetrics.Errors = errors
+	if errs, ok := metrics["errors"].([]interface{}); ok {
+		for _, e := range errs {
+			if msg, ok := e.(string); ok {
+				nodeMetrics.Errors = append(nodeMetrics.Errors, msg)
+			}
+		}
 	}
+}
 
-	// Store metrics
-	s.nodeMetrics.Store(nodeID, nodeMetrics)
+// computeBandwidth returns the combined rx+tx rate in bytes per second
+// between two samples of the cumulative network counters. A counter that
+// went backwards (e.g. the agent restarted) contributes zero.
+func computeBandwidth(previous, current *NodeMetrics) int64 {
+	elapsed := current.UpdatedAt.Sub(previous.UpdatedAt).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
 
-	// Update node last handshake in database
-	if !nodeMetrics.WGLastHandshake.IsZero() {
-		s.db.Model(&node).Update("last_handshake", nodeMetrics.WGLastHandshake)
+	rx := current.NetworkRx - previous.NetworkRx
+	if rx < 0 {
+		rx = 0
+	}
+	tx := current.NetworkTx - previous.NetworkTx
+	if tx < 0 {
+		tx = 0
 	}
 
-	// Check alert rules
-	s.checkAlerts(ctx, nodeMetrics)
+	return int64(float64(rx+tx) / elapsed)
+}
 
-	return nil
+func metricFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func metricInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, true
+		}
+		f, err := v.Float64()
+		return int64(f), err == nil
+	default:
+		return 0, false
+	}
+}
+
+// metricTime accepts an RFC 3339 timestamp or Unix seconds.
+func metricTime(value interface{}) (time.Time, bool) {
+	if str, ok := value.(string); ok {
+		t, err := time.Parse(time.RFC3339, str)
+		return t, err == nil
+	}
+	if t, ok := value.(time.Time); ok {
+		return t, true
+	}
+	if secs, ok := metricInt64(value); ok && secs > 0 {
+		return time.Unix(secs, 0), true
+	}
+	return time.Time{}, false
 }
 
 func (s *MonitoringService) GetNodeMetrics(ctx context.Context, nodeID uuid.UUID) (*NodeMetrics, error) {
@@ -177,14 +433,46 @@ func (s *MonitoringService) GetAllNodeMetrics(ctx context.Context) (map[uuid.UUI
 	return result, nil
 }
 
-func (s *MonitoringService) GetSystemMetrics(ctx context.Context) (*SystemMetrics, error) {
+// RecordConfigFetch records a config fetch for a node along with how long the
+// config took to generate.
+func (s *MonitoringService) RecordConfigFetch(nodeID uuid.UUID, latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value, _ := s.configFetches.LoadOrStore(nodeID, &ConfigFetchStats{NodeID: nodeID})
+	stats := value.(*ConfigFetchStats)
+
+	stats.Count++
+	stats.TotalLatency += latency
+	stats.LastLatency = latency
+	if latency > stats.MaxLatency {
+		stats.MaxLatency = latency
+	}
+	stats.LastFetchedAt = time.Now()
+}
+
+func (s *MonitoringService) GetConfigFetchStats(ctx context.Context) map[uuid.UUID]ConfigFetchStats {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	// Update system metrics
+	result := make(map[uuid.UUID]ConfigFetchStats)
+	s.configFetches.Range(func(key, value interface{}) bool {
+		result[key.(uuid.UUID)] = *value.(*ConfigFetchStats)
+		return true
+	})
+
+	return result
+}
+
+func (s *MonitoringService) GetSystemMetrics(ctx context.Context) (*SystemMetrics, error) {
+	// Update system metrics; this takes the write lock itself
 	s.updateSystemMetrics(ctx)
 
-	return s.systemMetrics, nil
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	systemMetrics := *s.systemMetrics
+	return &systemMetrics, nil
 }
 
 func (s *MonitoringService) updateSystemMetrics(ctx context.Context) {
@@ -221,6 +509,62 @@ func (s *MonitoringService) updateSystemMetrics(ctx context.Context) {
 	s.systemMetrics.LastUpdated = time.Now()
 }
 
+// isNodeOnline reports whether the node has sent metrics recently.
+func isNodeOnline(metrics *NodeMetrics, now time.Time) bool {
+	return now.Sub(metrics.LastSeen) < nodeOfflineAfter
+}
+
+// SetWebhookService makes offline detection notify node webhooks.
+func (s *MonitoringService) SetWebhookService(webhookService *WebhookService) {
+	s.webhookService = webhookService
+}
+
+// CheckOfflineNodes returns the nodes that have stopped reporting since the
+// last check and sends an offline event for each. A node is only reported
+// again after it has come back.
+func (s *MonitoringService) CheckOfflineNodes(ctx context.Context, now time.Time) []uuid.UUID {
+	var offline []uuid.UUID
+	s.nodeMetrics.Range(func(key, value interface{}) bool {
+		nodeID := key.(uuid.UUID)
+		if isNodeOnline(value.(*NodeMetrics), now) {
+			return true
+		}
+		if _, reported := s.offlineNodes.LoadOrStore(nodeID, struct{}{}); !reported {
+			offline = append(offline, nodeID)
+		}
+		return true
+	})
+
+	for _, nodeID := range offline {
+		var node models.Node
+		if err := s.db.Where("id = ?", nodeID).First(&node).Error; err != nil {
+			// Deleted nodes already sent their deleted event
+			continue
+		}
+		slog.WarnContext(ctx, "Node went offline", "node_id", nodeID, "node_name", node.Name)
+		if s.webhookService != nil {
+			s.webhookService.NotifyNodeEvent(NodeEventOffline, &node, "")
+		}
+	}
+	return offline
+}
+
+// StartOfflineDetection checks for nodes that stopped reporting every
+// offlineCheckInterval until ctx is cancelled.
+func (s *MonitoringService) StartOfflineDetection(ctx context.Context) {
+	ticker := time.NewTicker(offlineCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.CheckOfflineNodes(ctx, now)
+		}
+	}
+}
+
 func (s *MonitoringService) GetNodeHealth(ctx context.Context, nodeID uuid.UUID) (map[string]interface{}, error) {
 	metrics, err := s.GetNodeMetrics(ctx, nodeID)
 	if err != nil {
@@ -232,9 +576,73 @@ func (s *MonitoringService) GetNodeHealth(ctx context.Context, nodeID uuid.UUID)
 	health["node_name"] = metrics.NodeName
 	health["status"] = metrics.Status
 	health["last_seen"] = metrics.LastSeen
-	health["is_online"] = time.Since(metrics.LastSeen) < 5*time.Minute
+	health["is_online"] = isNodeOnline(metrics, time.Now())
+	health["tunnel_status"] = tunnelStatus(metrics, time.Now())
 
 	// Health scores
+	healthScore, issues := calculateHealthScore(metrics)
+
+	health["health_score"] = healthScore
+	health["issues"] = issues
+	health["metrics"] = metrics
+
+	return health, nil
+}
+
+// NodeHealthScore is a node's current health score as computed from its latest metrics.
+type NodeHealthScore struct {
+	NodeID      uuid.UUID `json:"node_id"`
+	NodeName    string    `json:"node_name"`
+	Status      string    `json:"status"`
+	HealthScore  float64   `json:"health_score"`
+	Issues       []string  `json:"issues"`
+	IsOnline     bool      `json:"is_online"`
+	TunnelStatus string    `json:"tunnel_status"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// GetNodesByHealthScore returns the nodes whose health score is below (or, if
+// below is false, at or above) the threshold, sorted by score with the scores
+// furthest from the threshold first.
+func (s *MonitoringService) GetNodesByHealthScore(ctx context.Context, threshold float64, below bool) ([]NodeHealthScore, error) {
+	allMetrics, err := s.GetAllNodeMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]NodeHealthScore, 0)
+	for nodeID, metrics := range allMetrics {
+		healthScore, issues := calculateHealthScore(metrics)
+		if below != (healthScore < threshold) {
+			continue
+		}
+
+		result = append(result, NodeHealthScore{
+			NodeID:      nodeID,
+			NodeName:    metrics.NodeName,
+			Status:      metrics.Status,
+			HealthScore:  healthScore,
+			Issues:       issues,
+			IsOnline:     isNodeOnline(metrics, time.Now()),
+			TunnelStatus: tunnelStatus(metrics, time.Now()),
+			LastSeen:     metrics.LastSeen,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].HealthScore != result[j].HealthScore {
+			if below {
+				return result[i].HealthScore < result[j].HealthScore
+			}
+			return result[i].HealthScore > result[j].HealthScore
+		}
+		return result[i].NodeName < result[j].NodeName
+	})
+
+	return result, nil
+}
+
+func calculateHealthScore(metrics *NodeMetrics) (float64, []string) {
 	healthScore := 100.0
 	issues := []string{}
 
@@ -263,11 +671,45 @@ func (s *MonitoringService) GetNodeHealth(ctx context.Context, nodeID uuid.UUID)
 		issues = append(issues, "System errors detected")
 	}
 
-	health["health_score"] = healthScore
-	health["issues"] = issues
-	health["metrics"] = metrics
+	switch tunnelStatus(metrics, time.Now()) {
+	case TunnelStatusStale:
+		healthScore -= 20
+		issues = append(issues, "Stale WireGuard handshake")
+	case TunnelStatusDown:
+		healthScore -= 50
+		issues = append(issues, "WireGuard tunnel down")
+	}
 
-	return health, nil
+	return healthScore, issues
+}
+
+// tunnelStatus judges whether a node's tunnel is alive from the age of its
+// latest handshake. With persistent keepalive the tunnel always carries
+// traffic, so a handshake is due every rekeyAfterTime and at most one
+// keepalive later; it is stale once overdue and down after three missed
+// intervals. Without keepalive an idle tunnel stops handshaking, so an old
+// handshake is not evidence of a problem.
+func tunnelStatus(metrics *NodeMetrics, now time.Time) string {
+	if metrics.WGStatus == "down" {
+		return TunnelStatusDown
+	}
+	if metrics.WGLastHandshake.IsZero() {
+		return TunnelStatusUnknown
+	}
+
+	keepalive := time.Duration(metrics.PersistentKeepalive) * time.Second
+	interval := rekeyAfterTime + keepalive
+	age := now.Sub(metrics.WGLastHandshake)
+	switch {
+	case age <= interval:
+		return TunnelStatusUp
+	case keepalive == 0:
+		return TunnelStatusUnknown
+	case age > 3*interval:
+		return TunnelStatusDown
+	default:
+		return TunnelStatusStale
+	}
 }
 
 func (s *MonitoringService) GetTopologyHealth(ctx context.Context) (map[string]interface{}, error) {
@@ -290,7 +732,7 @@ func (s *MonitoringService) GetTopologyHealth(ctx context.Context) (map[string]i
 	nodeHealth := make(map[string]interface{})
 
 	for nodeID, metrics := range allMetrics {
-		isOnline := time.Since(metrics.LastSeen) < 5*time.Minute
+		isOnline := isNodeOnline(metrics, time.Now())
 		if isOnline {
 			onlineNodes++
 		}
@@ -315,9 +757,15 @@ func (s *MonitoringService) GetTopologyHealth(ctx context.Context) (map[string]i
 		}
 	}
 
+	versionWarnings, err := s.CheckVersionSkew(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	health["node_health"] = nodeHealth
 	health["online_nodes"] = onlineNodes
 	health["total_nodes"] = len(allMetrics)
+	health["version_warnings"] = versionWarnings
 	
 	if len(allMetrics) > 0 {
 		health["average_health_score"] = totalHealthScore / float64(len(allMetrics))
@@ -327,30 +775,381 @@ func (s *MonitoringService) GetTopologyHealth(ctx context.Context) (map[string]i
 }
 
 func (s *MonitoringService) checkAlerts(ctx context.Context, metrics *NodeMetrics) {
-	// Simple alert checking - in production, this would be more sophisticated
-	if metrics.CPUUsage > 90 {
-		s.triggerAlert(ctx, "high_cpu", metrics.NodeID, fmt.Sprintf("CPU usage is %.2f%%", metrics.CPUUsage), "warning")
+	var rules []AlertRule
+	if err := s.db.Where("enabled = ?", true).Find(&rules).Error; err != nil {
+		slog.ErrorContext(ctx, "Failed to load alert rules", "error", err)
+		return
 	}
-	if metrics.MemoryUsage > 90 {
-		s.triggerAlert(ctx, "high_memory", metrics.NodeID, fmt.Sprintf("Memory usage is %.2f%%", metrics.MemoryUsage), "warning")
+	if len(rules) == 0 {
+		return
 	}
-	if metrics.PacketLoss > 10 {
-		s.triggerAlert(ctx, "high_packet_loss", metrics.NodeID, fmt.Sprintf("Packet loss is %.2f%%", metrics.PacketLoss), "critical")
+
+	var activeAlerts []Alert
+	if err := s.db.Where("node_id = ? AND status = ?", metrics.NodeID, AlertStatusActive).Find(&activeAlerts).Error; err != nil {
+		slog.ErrorContext(ctx, "Failed to load active alerts", "node_id", metrics.NodeID, "error", err)
+		return
 	}
-	if time.Since(metrics.LastSeen) > 10*time.Minute {
-		s.triggerAlert(ctx, "node_offline", metrics.NodeID, "Node has been offline for more than 10 minutes", "critical")
+
+	active := make(map[uuid.UUID]*Alert, len(activeAlerts))
+	for i := range activeAlerts {
+		active[activeAlerts[i].RuleID] = &activeAlerts[i]
+	}
+
+	triggered, resolved := planAlertChanges(rules, metrics, active, time.Now())
+
+	for _, alert := range triggered {
+		s.triggerAlert(ctx, alert)
+	}
+	for _, alert := range resolved {
+		if err := s.db.Model(&Alert{}).Where("id = ?", alert.ID).Updates(map[string]interface{}{
+			"status":      AlertStatusResolved,
+			"resolved_at": alert.ResolvedAt,
+		}).Error; err != nil {
+			slog.ErrorContext(ctx, "Failed to resolve alert", "alert_id", alert.ID, "error", err)
+		}
 	}
 }
 
-func (s *MonitoringService) triggerAlert(ctx context.Context, alertType string, nodeID uuid.UUID, message, severity string) {
-	// In a real implementation, this would send notifications, store alerts, etc.
-	fmt.Printf("ALERT [%s]: %s - %s\n", severity, alertType, message)
+// planAlertChanges evaluates rules against metrics and returns the alerts to
+// open and the active alerts whose condition has cleared. A rule that already
+// has an active alert for the node does not open a second one.
+func planAlertChanges(rules []AlertRule, metrics *NodeMetrics, active map[uuid.UUID]*Alert, now time.Time) ([]*Alert, []*Alert) {
+	var triggered, resolved []*Alert
+
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+
+		value, firing, err := evaluateAlertRule(&rule, metrics)
+		if err != nil {
+			continue
+		}
+
+		existing := active[rule.ID]
+		switch {
+		case firing && existing == nil:
+			triggered = append(triggered, &Alert{
+				RuleID:      rule.ID,
+				NodeID:      metrics.NodeID,
+				Message:     fmt.Sprintf("%s: %s is %.2f (%s %.2f)", rule.Name, rule.Metric, value, rule.Operator, rule.Threshold),
+				Severity:    rule.Severity,
+				Status:      AlertStatusActive,
+				Value:       value,
+				TriggeredAt: now,
+			})
+		case !firing && existing != nil:
+			resolvedAt := now
+			existing.Status = AlertStatusResolved
+			existing.ResolvedAt = &resolvedAt
+			resolved = append(resolved, existing)
+		}
+	}
+
+	return triggered, resolved
 }
 
-func (s *MonitoringService) GetMetricsHistory(ctx context.Context, nodeID uuid.UUID, metric string, duration time.Duration) ([]interface{}, error) {
-	// This would typically query a time-series database
-	// For now, return mock data
-	return []interface{}{}, nil
+// evaluateAlertRule returns the current value of the rule's metric and
+// whether it satisfies the rule's condition.
+func evaluateAlertRule(rule *AlertRule, metrics *NodeMetrics) (float64, bool, error) {
+	compare, ok := alertOperators[rule.Operator]
+	if !ok {
+		return 0, false, ErrInvalidOperator
+	}
+
+	value, err := alertMetricValue(metrics, rule.Metric)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return value, compare(value, rule.Threshold), nil
+}
+
+func alertMetricValue(metrics *NodeMetrics, metric string) (float64, error) {
+	switch metric {
+	case "cpu_usage":
+		return metrics.CPUUsage, nil
+	case "memory_usage":
+		return metrics.MemoryUsage, nil
+	case "disk_usage":
+		return metrics.DiskUsage, nil
+	case "network_rx":
+		return float64(metrics.NetworkRx), nil
+	case "network_tx":
+		return float64(metrics.NetworkTx), nil
+	case "wg_peers":
+		return float64(metrics.WGPeers), nil
+	case "latency_ms":
+		return metrics.Latency, nil
+	case "packet_loss":
+		return metrics.PacketLoss, nil
+	case "bandwidth_bps":
+		return float64(metrics.Bandwidth), nil
+	default:
+		return 0, ErrUnknownMetric
+	}
+}
+
+func (s *MonitoringService) triggerAlert(ctx context.Context, alert *Alert) {
+	if err := s.db.Create(alert).Error; err != nil {
+		slog.ErrorContext(ctx, "Failed to store alert", "error", err)
+		return
+	}
+
+	slog.WarnContext(ctx, "Alert triggered", "alert_id", alert.ID, "node_id", alert.NodeID, "severity", alert.Severity, "message", alert.Message)
+
+	// Deliver outside the request so retries don't hold up metrics ingestion
+	if s.notificationService != nil {
+		go s.notificationService.NotifyAlert(context.Background(), alert)
+	}
+}
+
+// systemAlertNamespace derives the RuleID of alerts the controller raises
+// itself, such as certificate expiry, which no alert rule backs.
+var systemAlertNamespace = uuid.MustParse("8b0f6a52-3c1e-4d7a-9f25-6e4b1c0d9a73")
+
+// RaiseSystemAlert opens an alert that is not backed by an alert rule. key
+// identifies the condition, so while its alert is active a repeated check
+// does not open another one.
+func (s *MonitoringService) RaiseSystemAlert(ctx context.Context, key string, nodeID uuid.UUID, severity, message string, value float64) error {
+	ruleID := uuid.NewSHA1(systemAlertNamespace, []byte(key))
+
+	var count int64
+	if err := s.db.Model(&Alert{}).Where("rule_id = ? AND status = ?", ruleID, AlertStatusActive).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check active alerts: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	s.triggerAlert(ctx, &Alert{
+		RuleID:      ruleID,
+		NodeID:      nodeID,
+		Message:     message,
+		Severity:    severity,
+		Status:      AlertStatusActive,
+		Value:       value,
+		TriggeredAt: time.Now(),
+	})
+	return nil
+}
+
+// ResolveSystemAlert resolves the active alert raised for key, if any.
+func (s *MonitoringService) ResolveSystemAlert(ctx context.Context, key string) error {
+	return s.resolveRuleAlerts(uuid.NewSHA1(systemAlertNamespace, []byte(key)))
+}
+
+func validateAlertRule(rule *AlertRule) error {
+	if _, ok := alertOperators[rule.Operator]; !ok {
+		return ErrInvalidOperator
+	}
+	if _, ok := historyMetricColumns[rule.Metric]; !ok {
+		return ErrUnknownMetric
+	}
+	if !alertSeverities[rule.Severity] {
+		return ErrInvalidSeverity
+	}
+	return nil
+}
+
+func (s *MonitoringService) CreateAlertRule(ctx context.Context, req *AlertRuleRequest) (*AlertRule, error) {
+	rule := &AlertRule{
+		Name:        req.Name,
+		Description: req.Description,
+		Metric:      req.Metric,
+		Operator:    req.Operator,
+		Threshold:   req.Threshold,
+		Severity:    req.Severity,
+		Enabled:     true,
+	}
+	if rule.Severity == "" {
+		rule.Severity = "warning"
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+
+	if err := validateAlertRule(rule); err != nil {
+		return nil, err
+	}
+
+	// Create leaves out false, which the column defaults to true
+	enabled := rule.Enabled
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(rule).Error; err != nil {
+			return err
+		}
+		if !enabled {
+			return tx.Model(rule).Update("enabled", false).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create alert rule: %w", err)
+	}
+
+	return rule, nil
+}
+
+// GetAlertRules returns the alert rules whose name or description contains
+// search, or every rule for an empty search.
+func (s *MonitoringService) GetAlertRules(ctx context.Context, search string) ([]AlertRule, error) {
+	var rules []AlertRule
+	if err := matchNameOrDescription(s.db, search).Order("created_at").Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("failed to get alert rules: %w", err)
+	}
+	return rules, nil
+}
+
+func (s *MonitoringService) GetAlertRule(ctx context.Context, id uuid.UUID) (*AlertRule, error) {
+	var rule AlertRule
+	if err := s.db.Where("id = ?", id).First(&rule).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAlertRuleNotFound
+		}
+		return nil, fmt.Errorf("failed to get alert rule: %w", err)
+	}
+	return &rule, nil
+}
+
+func (s *MonitoringService) UpdateAlertRule(ctx context.Context, id uuid.UUID, req *AlertRuleRequest) (*AlertRule, error) {
+	rule, err := s.GetAlertRule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rule.Name = req.Name
+	rule.Description = req.Description
+	rule.Metric = req.Metric
+	rule.Operator = req.Operator
+	rule.Threshold = req.Threshold
+	if req.Severity != "" {
+		rule.Severity = req.Severity
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+
+	if err := validateAlertRule(rule); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Save(rule).Error; err != nil {
+		return nil, fmt.Errorf("failed to update alert rule: %w", err)
+	}
+
+	// Alerts raised under the old condition no longer apply
+	if err := s.resolveRuleAlerts(rule.ID); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+func (s *MonitoringService) DeleteAlertRule(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.GetAlertRule(ctx, id); err != nil {
+		return err
+	}
+
+	if err := s.resolveRuleAlerts(id); err != nil {
+		return err
+	}
+
+	if err := s.db.Delete(&AlertRule{}, "id = ?", id).Error; err != nil {
+		return fmt.Errorf("failed to delete alert rule: %w", err)
+	}
+
+	return nil
+}
+
+func (s *MonitoringService) resolveRuleAlerts(ruleID uuid.UUID) error {
+	now := time.Now()
+	if err := s.db.Model(&Alert{}).
+		Where("rule_id = ? AND status = ?", ruleID, AlertStatusActive).
+		Updates(map[string]interface{}{
+			"status":      AlertStatusResolved,
+			"resolved_at": now,
+		}).Error; err != nil {
+		return fmt.Errorf("failed to resolve alerts: %w", err)
+	}
+	return nil
+}
+
+func (s *MonitoringService) GetAlerts(ctx context.Context, status string, nodeID *uuid.UUID) ([]Alert, error) {
+	query := s.db.Model(&Alert{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if nodeID != nil {
+		query = query.Where("node_id = ?", *nodeID)
+	}
+
+	var alerts []Alert
+	if err := query.Order("triggered_at DESC").Find(&alerts).Error; err != nil {
+		return nil, fmt.Errorf("failed to get alerts: %w", err)
+	}
+	return alerts, nil
+}
+
+func (s *MonitoringService) GetMetricsHistory(ctx context.Context, nodeID uuid.UUID, metric string, duration time.Duration) ([]MetricPoint, error) {
+	column, ok := historyMetricColumns[metric]
+	if !ok {
+		return nil, ErrUnknownMetric
+	}
+
+	end := time.Now()
+	start := end.Add(-duration)
+
+	var points []MetricPoint
+	if err := s.db.Model(&NodeMetricsSample{}).
+		Select("recorded_at AS timestamp, "+column+" AS value").
+		Where("node_id = ? AND recorded_at >= ?", nodeID, start).
+		Order("recorded_at").
+		Scan(&points).Error; err != nil {
+		return nil, fmt.Errorf("failed to get metrics history: %w", err)
+	}
+
+	return downsampleMetricPoints(points, start, end, maxHistoryPoints), nil
+}
+
+// downsampleMetricPoints averages points into at most maxPoints equal time
+// buckets between start and end. Series that already fit are returned as-is.
+func downsampleMetricPoints(points []MetricPoint, start, end time.Time, maxPoints int) []MetricPoint {
+	if len(points) <= maxPoints || maxPoints <= 0 {
+		return points
+	}
+
+	bucketSize := end.Sub(start) / time.Duration(maxPoints)
+	if bucketSize <= 0 {
+		return points[len(points)-maxPoints:]
+	}
+
+	sums := make([]float64, maxPoints)
+	counts := make([]int, maxPoints)
+	for _, point := range points {
+		bucket := int(point.Timestamp.Sub(start) / bucketSize)
+		if bucket < 0 {
+			bucket = 0
+		}
+		if bucket >= maxPoints {
+			bucket = maxPoints - 1
+		}
+		sums[bucket] += point.Value
+		counts[bucket]++
+	}
+
+	result := make([]MetricPoint, 0, maxPoints)
+	for i := 0; i < maxPoints; i++ {
+		if counts[i] == 0 {
+			continue
+		}
+		result = append(result, MetricPoint{
+			Timestamp: start.Add(time.Duration(i) * bucketSize),
+			Value:     sums[i] / float64(counts[i]),
+		})
+	}
+
+	return result
 }
 
 func (s *MonitoringService) CleanupOldMetrics(ctx context.Context, retentionDays int) error {
@@ -366,6 +1165,11 @@ func (s *MonitoringService) CleanupOldMetrics(ctx context.Context, retentionDays
 		return true
 	})
 
+	// Prune persisted history
+	if err := s.db.Where("recorded_at < ?", cutoffTime).Delete(&NodeMetricsSample{}).Error; err != nil {
+		return fmt.Errorf("failed to cleanup metrics history: %w", err)
+	}
+
 	return nil
 }
 
@@ -400,6 +1204,10 @@ func (s *MonitoringService) GenerateReport(ctx context.Context, startTime, endTi
 	}
 
 	report["node_summary"] = nodeSummary
+
+	// Agents to upgrade before the next rollout
+	versionWarnings, _ := s.CheckVersionSkew(ctx)
+	report["version_warnings"] = versionWarnings
 	report["generated_at"] = time.Now()
 
 	return report, nil
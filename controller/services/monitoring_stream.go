@@ -0,0 +1,103 @@
+package services
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	NodeEventMetrics = "metrics"
+	NodeEventStatus  = "status"
+)
+
+// nodeEventBuffer is how many events a subscriber may fall behind by before
+// further events for it are dropped.
+const nodeEventBuffer = 64
+
+// NodeEvent is pushed to stream subscribers as UpdateNodeMetrics records a
+// report. A status event precedes the metrics event when the node's status
+// or WireGuard status differs from its previous report.
+type NodeEvent struct {
+	Type      string       `json:"type"`
+	NodeID    uuid.UUID    `json:"node_id"`
+	Status    string       `json:"status,omitempty"`
+	WGStatus  string       `json:"wg_status,omitempty"`
+	Metrics   *NodeMetrics `json:"metrics,omitempty"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+type nodeEventSubscriber struct {
+	nodeID uuid.UUID // uuid.Nil for every node
+	events chan NodeEvent
+}
+
+// SubscribeNodeEvents returns a channel of events for nodeID, or for every
+// node when nodeID is uuid.Nil. Events are dropped rather than delaying
+// metric updates when the subscriber doesn't keep up. The returned function
+// unsubscribes and closes the channel.
+func (s *MonitoringService) SubscribeNodeEvents(nodeID uuid.UUID) (<-chan NodeEvent, func()) {
+	sub := &nodeEventSubscriber{
+		nodeID: nodeID,
+		events: make(chan NodeEvent, nodeEventBuffer),
+	}
+
+	s.subscribersMu.Lock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[*nodeEventSubscriber]struct{})
+	}
+	s.subscribers[sub] = struct{}{}
+	s.subscribersMu.Unlock()
+
+	unsubscribe := func() {
+		s.subscribersMu.Lock()
+		defer s.subscribersMu.Unlock()
+		if _, ok := s.subscribers[sub]; ok {
+			delete(s.subscribers, sub)
+			close(sub.events)
+		}
+	}
+	return sub.events, unsubscribe
+}
+
+// NodeEventSubscriberCount returns the number of open subscriptions.
+func (s *MonitoringService) NodeEventSubscriberCount() int {
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+	return len(s.subscribers)
+}
+
+// publishNodeMetrics sends the events for a recorded report. previous is the
+// node's prior report, or nil for its first.
+func (s *MonitoringService) publishNodeMetrics(previous, current *NodeMetrics) {
+	var events []NodeEvent
+	if previous == nil || previous.Status != current.Status || previous.WGStatus != current.WGStatus {
+		events = append(events, NodeEvent{
+			Type:      NodeEventStatus,
+			NodeID:    current.NodeID,
+			Status:    current.Status,
+			WGStatus:  current.WGStatus,
+			Timestamp: current.UpdatedAt,
+		})
+	}
+	events = append(events, NodeEvent{
+		Type:      NodeEventMetrics,
+		NodeID:    current.NodeID,
+		Metrics:   current,
+		Timestamp: current.UpdatedAt,
+	})
+
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+	for sub := range s.subscribers {
+		if sub.nodeID != uuid.Nil && sub.nodeID != current.NodeID {
+			continue
+		}
+		for _, event := range events {
+			select {
+			case sub.events <- event:
+			default:
+			}
+		}
+	}
+}
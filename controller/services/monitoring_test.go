@@ -0,0 +1,597 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestEvaluateAlertRuleOperators(t *testing.T) {
+	metrics := &NodeMetrics{
+		NodeID:     uuid.New(),
+		CPUUsage:   90,
+		PacketLoss: 2.5,
+		WGPeers:    3,
+	}
+
+	tests := []struct {
+		name      string
+		metric    string
+		operator  string
+		threshold float64
+		want      bool
+	}{
+		{"greater than above threshold", "cpu_usage", ">", 80, true},
+		{"greater than at threshold", "cpu_usage", ">", 90, false},
+		{"greater or equal at threshold", "cpu_usage", ">=", 90, true},
+		{"greater or equal below threshold", "cpu_usage", ">=", 91, false},
+		{"less than below threshold", "packet_loss", "<", 5, true},
+		{"less than above threshold", "packet_loss", "<", 1, false},
+		{"integer metric", "wg_peers", "<", 4, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &AlertRule{Metric: tt.metric, Operator: tt.operator, Threshold: tt.threshold}
+			_, firing, err := evaluateAlertRule(rule, metrics)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if firing != tt.want {
+				t.Errorf("evaluateAlertRule(%s %s %v) = %v, want %v", tt.metric, tt.operator, tt.threshold, firing, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateAlertRuleInvalid(t *testing.T) {
+	metrics := &NodeMetrics{CPUUsage: 50}
+
+	if _, _, err := evaluateAlertRule(&AlertRule{Metric: "cpu_usage", Operator: "!="}, metrics); err != ErrInvalidOperator {
+		t.Errorf("expected ErrInvalidOperator, got %v", err)
+	}
+	if _, _, err := evaluateAlertRule(&AlertRule{Metric: "temperature", Operator: ">"}, metrics); err != ErrUnknownMetric {
+		t.Errorf("expected ErrUnknownMetric, got %v", err)
+	}
+}
+
+func TestPlanAlertChangesTriggersOnce(t *testing.T) {
+	rule := AlertRule{ID: uuid.New(), Name: "high cpu", Metric: "cpu_usage", Operator: ">", Threshold: 80, Severity: "warning", Enabled: true}
+	metrics := &NodeMetrics{NodeID: uuid.New(), CPUUsage: 95}
+	now := time.Now()
+
+	triggered, resolved := planAlertChanges([]AlertRule{rule}, metrics, map[uuid.UUID]*Alert{}, now)
+	if len(triggered) != 1 || len(resolved) != 0 {
+		t.Fatalf("expected 1 triggered and 0 resolved, got %d and %d", len(triggered), len(resolved))
+	}
+	alert := triggered[0]
+	if alert.RuleID != rule.ID || alert.NodeID != metrics.NodeID || alert.Status != AlertStatusActive || alert.Value != 95 {
+		t.Errorf("unexpected alert: %+v", alert)
+	}
+
+	// An alert that is already active must not be raised again
+	active := map[uuid.UUID]*Alert{rule.ID: alert}
+	triggered, resolved = planAlertChanges([]AlertRule{rule}, metrics, active, now)
+	if len(triggered) != 0 || len(resolved) != 0 {
+		t.Errorf("expected no changes while condition persists, got %d triggered and %d resolved", len(triggered), len(resolved))
+	}
+}
+
+func TestPlanAlertChangesAutoResolves(t *testing.T) {
+	rule := AlertRule{ID: uuid.New(), Metric: "memory_usage", Operator: ">=", Threshold: 90, Severity: "critical", Enabled: true}
+	metrics := &NodeMetrics{NodeID: uuid.New(), MemoryUsage: 40}
+	now := time.Now()
+	active := map[uuid.UUID]*Alert{
+		rule.ID: {ID: uuid.New(), RuleID: rule.ID, NodeID: metrics.NodeID, Status: AlertStatusActive},
+	}
+
+	triggered, resolved := planAlertChanges([]AlertRule{rule}, metrics, active, now)
+	if len(triggered) != 0 || len(resolved) != 1 {
+		t.Fatalf("expected 0 triggered and 1 resolved, got %d and %d", len(triggered), len(resolved))
+	}
+	if resolved[0].Status != AlertStatusResolved {
+		t.Errorf("expected status %q, got %q", AlertStatusResolved, resolved[0].Status)
+	}
+	if resolved[0].ResolvedAt == nil || !resolved[0].ResolvedAt.Equal(now) {
+		t.Errorf("expected resolved_at %v, got %v", now, resolved[0].ResolvedAt)
+	}
+}
+
+func TestPlanAlertChangesSkipsDisabledRules(t *testing.T) {
+	rule := AlertRule{ID: uuid.New(), Metric: "cpu_usage", Operator: ">", Threshold: 10, Enabled: false}
+	metrics := &NodeMetrics{NodeID: uuid.New(), CPUUsage: 99}
+
+	triggered, resolved := planAlertChanges([]AlertRule{rule}, metrics, map[uuid.UUID]*Alert{}, time.Now())
+	if len(triggered) != 0 || len(resolved) != 0 {
+		t.Errorf("expected disabled rule to be ignored, got %d triggered and %d resolved", len(triggered), len(resolved))
+	}
+}
+
+// agentMetricsBody mirrors the report body the agent POSTs to
+// /monitoring/nodes/:node_id/metrics.
+const agentMetricsBody = `{
+	"cpu_usage": 42.5,
+	"memory_usage": 61.25,
+	"disk_usage": 30,
+	"network_rx": 1073741824,
+	"network_tx": 536870912,
+	"wg_peers": 4,
+	"wg_status": "up",
+	"wg_last_handshake": "2024-01-15T10:30:00.123456789Z",
+	"latency_ms": 12.5,
+	"packet_loss": 0.5,
+	"bandwidth_bps": 125000000,
+	"errors": ["peer abc unreachable"]
+}`
+
+func TestParseNodeMetricsFromJSON(t *testing.T) {
+	decoders := map[string]func([]byte) (map[string]interface{}, error){
+		"float64": func(body []byte) (map[string]interface{}, error) {
+			var metrics map[string]interface{}
+			err := json.Unmarshal(body, &metrics)
+			return metrics, err
+		},
+		"json.Number": func(body []byte) (map[string]interface{}, error) {
+			var metrics map[string]interface{}
+			decoder := json.NewDecoder(bytes.NewReader(body))
+			decoder.UseNumber()
+			err := decoder.Decode(&metrics)
+			return metrics, err
+		},
+	}
+
+	for name, decode := range decoders {
+		t.Run(name, func(t *testing.T) {
+			metrics, err := decode([]byte(agentMetricsBody))
+			if err != nil {
+				t.Fatalf("failed to decode body: %v", err)
+			}
+
+			nodeMetrics := &NodeMetrics{}
+			parseNodeMetrics(metrics, nodeMetrics)
+
+			if nodeMetrics.CPUUsage != 42.5 || nodeMetrics.MemoryUsage != 61.25 || nodeMetrics.DiskUsage != 30 {
+				t.Errorf("unexpected resource usage: cpu=%v memory=%v disk=%v", nodeMetrics.CPUUsage, nodeMetrics.MemoryUsage, nodeMetrics.DiskUsage)
+			}
+			if nodeMetrics.NetworkRx != 1073741824 {
+				t.Errorf("expected network_rx 1073741824, got %d", nodeMetrics.NetworkRx)
+			}
+			if nodeMetrics.NetworkTx != 536870912 {
+				t.Errorf("expected network_tx 536870912, got %d", nodeMetrics.NetworkTx)
+			}
+			if nodeMetrics.WGPeers != 4 {
+				t.Errorf("expected wg_peers 4, got %d", nodeMetrics.WGPeers)
+			}
+			if nodeMetrics.Bandwidth != 125000000 {
+				t.Errorf("expected bandwidth_bps 125000000, got %d", nodeMetrics.Bandwidth)
+			}
+			if nodeMetrics.Latency != 12.5 || nodeMetrics.PacketLoss != 0.5 {
+				t.Errorf("unexpected latency/packet loss: %v/%v", nodeMetrics.Latency, nodeMetrics.PacketLoss)
+			}
+			if nodeMetrics.WGStatus != "up" {
+				t.Errorf("expected wg_status up, got %q", nodeMetrics.WGStatus)
+			}
+			want := time.Date(2024, 1, 15, 10, 30, 0, 123456789, time.UTC)
+			if !nodeMetrics.WGLastHandshake.Equal(want) {
+				t.Errorf("expected wg_last_handshake %v, got %v", want, nodeMetrics.WGLastHandshake)
+			}
+			if len(nodeMetrics.Errors) != 1 || nodeMetrics.Errors[0] != "peer abc unreachable" {
+				t.Errorf("unexpected errors: %v", nodeMetrics.Errors)
+			}
+		})
+	}
+}
+
+func TestParseNodeMetricsIgnoresInvalidTypes(t *testing.T) {
+	nodeMetrics := &NodeMetrics{}
+	parseNodeMetrics(map[string]interface{}{
+		"network_rx":        "lots",
+		"wg_peers":          true,
+		"wg_last_handshake": "yesterday",
+	}, nodeMetrics)
+
+	if nodeMetrics.NetworkRx != 0 || nodeMetrics.WGPeers != 0 || !nodeMetrics.WGLastHandshake.IsZero() {
+		t.Errorf("expected invalid values to be ignored, got %+v", nodeMetrics)
+	}
+}
+
+func TestComputeBandwidth(t *testing.T) {
+	start := time.Now()
+	previous := &NodeMetrics{NetworkRx: 1000000, NetworkTx: 500000, UpdatedAt: start}
+	current := &NodeMetrics{NetworkRx: 1600000, NetworkTx: 800000, UpdatedAt: start.Add(30 * time.Second)}
+
+	// (600000 + 300000) bytes over 30 seconds
+	if got := computeBandwidth(previous, current); got != 30000 {
+		t.Errorf("expected 30000 bytes/s, got %d", got)
+	}
+}
+
+func TestComputeBandwidthCounterReset(t *testing.T) {
+	start := time.Now()
+	previous := &NodeMetrics{NetworkRx: 5000000, NetworkTx: 100000, UpdatedAt: start}
+	current := &NodeMetrics{NetworkRx: 2000, NetworkTx: 400000, UpdatedAt: start.Add(10 * time.Second)}
+
+	// rx reset after an agent restart is clamped to zero, tx still counts
+	if got := computeBandwidth(previous, current); got != 30000 {
+		t.Errorf("expected 30000 bytes/s, got %d", got)
+	}
+
+	current.NetworkTx = 0
+	if got := computeBandwidth(previous, current); got != 0 {
+		t.Errorf("expected 0 bytes/s after full reset, got %d", got)
+	}
+}
+
+func TestComputeBandwidthNonPositiveInterval(t *testing.T) {
+	now := time.Now()
+	previous := &NodeMetrics{NetworkRx: 0, UpdatedAt: now}
+	current := &NodeMetrics{NetworkRx: 1000, UpdatedAt: now}
+
+	if got := computeBandwidth(previous, current); got != 0 {
+		t.Errorf("expected 0 for identical timestamps, got %d", got)
+	}
+}
+
+func TestPublishNodeMetricsStatusChanges(t *testing.T) {
+	s := &MonitoringService{}
+	nodeID := uuid.New()
+	all, unsubscribeAll := s.SubscribeNodeEvents(uuid.Nil)
+	defer unsubscribeAll()
+	other, unsubscribeOther := s.SubscribeNodeEvents(uuid.New())
+	defer unsubscribeOther()
+
+	first := &NodeMetrics{NodeID: nodeID, Status: "active", WGStatus: "up"}
+	s.publishNodeMetrics(nil, first)
+	second := &NodeMetrics{NodeID: nodeID, Status: "active", WGStatus: "up"}
+	s.publishNodeMetrics(first, second)
+	third := &NodeMetrics{NodeID: nodeID, Status: "active", WGStatus: "down"}
+	s.publishNodeMetrics(second, third)
+
+	var got []string
+	for len(all) > 0 {
+		got = append(got, (<-all).Type)
+	}
+	expected := []string{NodeEventStatus, NodeEventMetrics, NodeEventMetrics, NodeEventStatus, NodeEventMetrics}
+	if len(got) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected events %v, got %v", expected, got)
+		}
+	}
+	if len(other) != 0 {
+		t.Errorf("expected no events for a subscriber to another node, got %d", len(other))
+	}
+
+	unsubscribeAll()
+	if _, ok := <-all; ok {
+		t.Error("expected unsubscribing to close the channel")
+	}
+	if s.NodeEventSubscriberCount() != 1 {
+		t.Errorf("expected one remaining subscriber, got %d", s.NodeEventSubscriberCount())
+	}
+}
+
+func TestTunnelStatus(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		metrics  NodeMetrics
+		expected string
+	}{
+		{"no handshake", NodeMetrics{WGStatus: "up"}, TunnelStatusUnknown},
+		{"interface down", NodeMetrics{WGStatus: "down", WGLastHandshake: now}, TunnelStatusDown},
+		{"recent handshake", NodeMetrics{WGLastHandshake: now.Add(-time.Minute), PersistentKeepalive: 25}, TunnelStatusUp},
+		{"due with keepalive", NodeMetrics{WGLastHandshake: now.Add(-140 * time.Second), PersistentKeepalive: 25}, TunnelStatusUp},
+		{"overdue", NodeMetrics{WGLastHandshake: now.Add(-5 * time.Minute), PersistentKeepalive: 25}, TunnelStatusStale},
+		{"three intervals missed", NodeMetrics{WGLastHandshake: now.Add(-10 * time.Minute), PersistentKeepalive: 25}, TunnelStatusDown},
+		{"idle without keepalive", NodeMetrics{WGLastHandshake: now.Add(-time.Hour)}, TunnelStatusUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := tunnelStatus(&tt.metrics, now); got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestCalculateHealthScoreHandshakeStaleness(t *testing.T) {
+	fresh := &NodeMetrics{WGStatus: "up", WGLastHandshake: time.Now().Add(-time.Minute), PersistentKeepalive: 25}
+	if score, issues := calculateHealthScore(fresh); score != 100 || len(issues) != 0 {
+		t.Errorf("expected a fresh handshake to be healthy, got %v %v", score, issues)
+	}
+
+	stale := &NodeMetrics{WGStatus: "up", WGLastHandshake: time.Now().Add(-5 * time.Minute), PersistentKeepalive: 25}
+	staleScore, issues := calculateHealthScore(stale)
+	if staleScore >= 100 || len(issues) != 1 || issues[0] != "Stale WireGuard handshake" {
+		t.Errorf("expected a stale handshake to degrade the score, got %v %v", staleScore, issues)
+	}
+
+	offline := &NodeMetrics{WGStatus: "up", WGLastHandshake: time.Now().Add(-time.Hour), PersistentKeepalive: 25}
+	offlineScore, issues := calculateHealthScore(offline)
+	if offlineScore >= staleScore || len(issues) != 1 || issues[0] != "WireGuard tunnel down" {
+		t.Errorf("expected an offline tunnel to be flagged, got %v %v", offlineScore, issues)
+	}
+}
+
+func TestGetNodesByHealthScoreThreshold(t *testing.T) {
+	s := NewMonitoringService(nil, nil)
+	scores := make(map[string]float64)
+	for _, node := range []struct {
+		name    string
+		metrics NodeMetrics
+	}{
+		{"healthy", NodeMetrics{}},
+		{"busy", NodeMetrics{CPUUsage: 95}},                                      // 80
+		{"lossy", NodeMetrics{PacketLoss: 10, Latency: 250}},                     // 60
+		{"full", NodeMetrics{DiskUsage: 95}},                                     // 70, at the threshold
+		{"failing", NodeMetrics{CPUUsage: 95, MemoryUsage: 90, DiskUsage: 99}},   // 30
+		{"down", NodeMetrics{WGStatus: "down", Errors: []string{"wg0 missing"}}}, // 40
+	} {
+		nodeID := uuid.New()
+		metrics := node.metrics
+		metrics.NodeID, metrics.NodeName, metrics.LastSeen = nodeID, node.name, time.Now()
+		s.nodeMetrics.Store(nodeID, &metrics)
+		scores[node.name], _ = calculateHealthScore(&metrics)
+	}
+
+	names := func(nodes []NodeHealthScore) []string {
+		result := make([]string, len(nodes))
+		for i, node := range nodes {
+			result[i] = node.NodeName
+			if node.HealthScore != scores[node.NodeName] {
+				t.Errorf("%s: expected score %v, got %v", node.NodeName, scores[node.NodeName], node.HealthScore)
+			}
+		}
+		return result
+	}
+
+	// Exactly the nodes scoring under 70, worst first
+	below, err := s.GetNodesByHealthScore(context.Background(), 70, true)
+	if err != nil {
+		t.Fatalf("GetNodesByHealthScore failed: %v", err)
+	}
+	if got, want := names(below), []string{"failing", "down", "lossy"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v below 70, got %v", want, got)
+	}
+
+	// The rest, best first
+	above, err := s.GetNodesByHealthScore(context.Background(), 70, false)
+	if err != nil {
+		t.Fatalf("GetNodesByHealthScore failed: %v", err)
+	}
+	if got, want := names(above), []string{"healthy", "busy", "full"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v at or above 70, got %v", want, got)
+	}
+}
+
+func TestGetNodeHealthReportsTunnelStatus(t *testing.T) {
+	s := NewMonitoringService(nil, nil)
+	nodeID := uuid.New()
+	s.nodeMetrics.Store(nodeID, &NodeMetrics{
+		NodeID:              nodeID,
+		WGStatus:            "up",
+		WGLastHandshake:     time.Now().Add(-time.Hour),
+		PersistentKeepalive: 25,
+		LastSeen:            time.Now(),
+	})
+
+	health, err := s.GetNodeHealth(context.Background(), nodeID)
+	if err != nil {
+		t.Fatalf("GetNodeHealth failed: %v", err)
+	}
+	if health["tunnel_status"] != TunnelStatusDown {
+		t.Errorf("expected tunnel_status %q, got %v", TunnelStatusDown, health["tunnel_status"])
+	}
+	if issues, _ := health["issues"].([]string); len(issues) != 1 || issues[0] != "WireGuard tunnel down" {
+		t.Errorf("expected the tunnel to be listed as an issue, got %v", health["issues"])
+	}
+}
+
+func TestTriggerAlertWithoutNotifications(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Exec(`CREATE TABLE alerts (id TEXT PRIMARY KEY, rule_id TEXT NOT NULL, node_id TEXT NOT NULL, message TEXT, severity TEXT, status TEXT NOT NULL, value REAL, triggered_at DATETIME, resolved_at DATETIME)`).Error; err != nil {
+		t.Fatalf("failed to create alerts table: %v", err)
+	}
+	s := NewMonitoringService(db, nil)
+	ctx := context.Background()
+
+	if err := s.RaiseSystemAlert(ctx, "test", uuid.New(), "critical", "test alert", 1); err != nil {
+		t.Fatalf("RaiseSystemAlert failed: %v", err)
+	}
+	// A delivery goroutine would panic on the nil notification service
+	time.Sleep(50 * time.Millisecond)
+
+	alerts, err := s.GetAlerts(ctx, AlertStatusActive, nil)
+	if err != nil {
+		t.Fatalf("GetAlerts failed: %v", err)
+	}
+	if len(alerts) != 1 {
+		t.Errorf("expected the alert to be stored, got %+v", alerts)
+	}
+}
+
+const alertRulesTestTable = `CREATE TABLE alert_rules (
+	id TEXT PRIMARY KEY, name TEXT NOT NULL, description TEXT, metric TEXT NOT NULL, operator TEXT NOT NULL,
+	threshold REAL, severity TEXT NOT NULL DEFAULT 'warning', enabled BOOLEAN DEFAULT true, created_at DATETIME, updated_at DATETIME)`
+
+func TestCreateDisabledAlertRule(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Exec(alertRulesTestTable).Error; err != nil {
+		t.Fatalf("failed to create alert_rules table: %v", err)
+	}
+	s := NewMonitoringService(db, nil)
+	ctx := context.Background()
+
+	disabled := false
+	rule, err := s.CreateAlertRule(ctx, &AlertRuleRequest{Name: "high-cpu", Metric: "cpu_usage", Operator: ">", Threshold: 90, Enabled: &disabled})
+	if err != nil {
+		t.Fatalf("CreateAlertRule failed: %v", err)
+	}
+
+	stored, err := s.GetAlertRule(ctx, rule.ID)
+	if err != nil {
+		t.Fatalf("GetAlertRule failed: %v", err)
+	}
+	if stored.Enabled {
+		t.Error("expected the rule to be stored disabled")
+	}
+}
+
+func TestGetAlertRulesSearchesDescription(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Exec(alertRulesTestTable).Error; err != nil {
+		t.Fatalf("failed to create alert_rules table: %v", err)
+	}
+	s := NewMonitoringService(db, nil)
+	ctx := context.Background()
+
+	for _, req := range []AlertRuleRequest{
+		{Name: "high-cpu", Description: "Page the on-call team (OPS-42)", Metric: "cpu_usage", Operator: ">", Threshold: 90},
+		{Name: "packet-loss", Description: "Tracked in NET-7", Metric: "packet_loss", Operator: ">", Threshold: 5},
+	} {
+		if _, err := s.CreateAlertRule(ctx, &req); err != nil {
+			t.Fatalf("CreateAlertRule failed: %v", err)
+		}
+	}
+
+	for search, want := range map[string]string{"ops-42": "high-cpu", "PACKET": "packet-loss"} {
+		rules, err := s.GetAlertRules(ctx, search)
+		if err != nil {
+			t.Fatalf("GetAlertRules failed: %v", err)
+		}
+		if len(rules) != 1 || rules[0].Name != want {
+			t.Errorf("search %q: expected %s, got %+v", search, want, rules)
+		}
+	}
+	if rules, _ := s.GetAlertRules(ctx, ""); len(rules) != 2 {
+		t.Errorf("expected an empty search to return every rule, got %d", len(rules))
+	}
+}
+
+func newMetricsHistoryTestService(t *testing.T) *MonitoringService {
+	t.Helper()
+
+	db := openTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE nodes (
+			id TEXT PRIMARY KEY, name TEXT NOT NULL, node_type TEXT NOT NULL, allocated_ip TEXT NOT NULL,
+			status TEXT, last_handshake DATETIME, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE node_metrics_history (
+			id TEXT PRIMARY KEY, node_id TEXT NOT NULL, cpu_usage REAL, memory_usage REAL, disk_usage REAL,
+			network_rx INTEGER, network_tx INTEGER, wg_peers INTEGER, latency REAL, packet_loss REAL,
+			bandwidth INTEGER, recorded_at DATETIME NOT NULL)`,
+		`CREATE TABLE alert_rules (id TEXT PRIMARY KEY, enabled BOOLEAN)`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create test schema: %v", err)
+		}
+	}
+	return NewMonitoringService(db, nil)
+}
+
+func TestGetMetricsHistoryReturnsWindow(t *testing.T) {
+	s := newMetricsHistoryTestService(t)
+	ctx := context.Background()
+	nodeID, otherID := uuid.New(), uuid.New()
+	if err := s.db.Exec("INSERT INTO nodes (id, name, node_type, allocated_ip, status) VALUES (?, ?, ?, ?, ?)",
+		nodeID, "spoke-1", "spoke", "10.100.1.2", "active").Error; err != nil {
+		t.Fatalf("failed to insert node: %v", err)
+	}
+
+	// A sample every ten minutes over the last two hours, plus one for
+	// another node
+	now := time.Now()
+	for i := 12; i >= 1; i-- {
+		sample := NodeMetricsSample{ID: uuid.New(), NodeID: nodeID, CPUUsage: float64(i), Latency: float64(100 + i), RecordedAt: now.Add(-time.Duration(i) * 10 * time.Minute)}
+		if err := s.db.Create(&sample).Error; err != nil {
+			t.Fatalf("failed to store sample: %v", err)
+		}
+	}
+	if err := s.db.Create(&NodeMetricsSample{ID: uuid.New(), NodeID: otherID, CPUUsage: 99, RecordedAt: now.Add(-time.Minute)}).Error; err != nil {
+		t.Fatalf("failed to store sample: %v", err)
+	}
+
+	// A reported sample is stored as well
+	if err := s.UpdateNodeMetrics(ctx, nodeID, map[string]interface{}{"cpu_usage": 42.0, "latency_ms": 7.0}); err != nil {
+		t.Fatalf("UpdateNodeMetrics failed: %v", err)
+	}
+
+	points, err := s.GetMetricsHistory(ctx, nodeID, "cpu_usage", 55*time.Minute)
+	if err != nil {
+		t.Fatalf("GetMetricsHistory failed: %v", err)
+	}
+	var values []float64
+	for i, point := range points {
+		values = append(values, point.Value)
+		if i > 0 && point.Timestamp.Before(points[i-1].Timestamp) {
+			t.Errorf("expected points in time order, got %v before %v", points[i-1].Timestamp, point.Timestamp)
+		}
+	}
+	if want := []float64{5, 4, 3, 2, 1, 42}; !reflect.DeepEqual(values, want) {
+		t.Errorf("expected cpu_usage %v over the window, got %v", want, values)
+	}
+
+	// Metric names map to their columns
+	latency, err := s.GetMetricsHistory(ctx, nodeID, "latency_ms", 15*time.Minute)
+	if err != nil {
+		t.Fatalf("GetMetricsHistory failed: %v", err)
+	}
+	if len(latency) != 2 || latency[0].Value != 101 || latency[1].Value != 7 {
+		t.Errorf("expected two latency points, got %+v", latency)
+	}
+
+	if _, err := s.GetMetricsHistory(ctx, nodeID, "cpu_usage; DROP TABLE nodes", time.Hour); !errors.Is(err, ErrUnknownMetric) {
+		t.Errorf("expected ErrUnknownMetric, got %v", err)
+	}
+}
+
+func TestDownsampleMetricPoints(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	end := start.Add(time.Hour)
+	var points []MetricPoint
+	for i := 0; i < 60; i++ {
+		points = append(points, MetricPoint{Timestamp: start.Add(time.Duration(i) * time.Minute), Value: float64(i)})
+	}
+
+	if got := downsampleMetricPoints(points, start, end, 60); len(got) != 60 {
+		t.Errorf("expected a series that fits to be left alone, got %d points", len(got))
+	}
+
+	got := downsampleMetricPoints(points, start, end, 4)
+	if len(got) != 4 {
+		t.Fatalf("expected 4 buckets, got %+v", got)
+	}
+	for i, point := range got {
+		// Each bucket averages 15 consecutive minutes
+		if want := float64(i*15) + 7; point.Value != want || !point.Timestamp.Equal(start.Add(time.Duration(i)*15*time.Minute)) {
+			t.Errorf("bucket %d: expected %v at %v, got %+v", i, want, start.Add(time.Duration(i)*15*time.Minute), point)
+		}
+	}
+}
+
+func TestCleanupOldMetricsPrunesHistory(t *testing.T) {
+	s := newMetricsHistoryTestService(t)
+	nodeID := uuid.New()
+	for _, age := range []time.Duration{40 * 24 * time.Hour, 31 * 24 * time.Hour, time.Hour} {
+		if err := s.db.Create(&NodeMetricsSample{ID: uuid.New(), NodeID: nodeID, RecordedAt: time.Now().Add(-age)}).Error; err != nil {
+			t.Fatalf("failed to store sample: %v", err)
+		}
+	}
+
+	if err := s.CleanupOldMetrics(context.Background(), 30); err != nil {
+		t.Fatalf("CleanupOldMetrics failed: %v", err)
+	}
+	var remaining int64
+	s.db.Model(&NodeMetricsSample{}).Count(&remaining)
+	if remaining != 1 {
+		t.Errorf("expected only the recent sample to be kept, got %d", remaining)
+	}
+}
@@ -2,17 +2,20 @@ package services
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/wg-hubspoke/wg-hubspoke/common/types"
 	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
-	"golang.org/x/crypto/curve25519"
+	"gopkg.in/yaml.v2"
 	"gorm.io/gorm"
 )
 
@@ -21,17 +24,51 @@ var (
 	ErrNodeExists       = errors.New("node already exists")
 	ErrInvalidNodeType  = errors.New("invalid node type")
 	ErrInvalidPublicKey = errors.New("invalid public key")
+	ErrInvalidPinnedHub = errors.New("pinned hub must be an existing hub node")
+	ErrInvalidRoute     = errors.New("routes must be CIDR subnets other than a default route")
+	ErrInvalidTag       = errors.New("tag keys must be 1-63 characters without ':' and values at most 255 characters")
+	ErrInvalidSort      = errors.New("sort must be one of name, created_at, status, last_handshake")
+	ErrInvalidSortOrder = errors.New("order must be asc or desc")
+	ErrInvalidKeepalive = errors.New("persistent_keepalive must be between 0 and 65535 seconds")
+	ErrInvalidAllowedIP = errors.New("allowed_ips must be CIDR subnets")
 )
 
 type NodeService struct {
-	db     *gorm.DB
-	config *types.Config
+	db             *gorm.DB
+	config         *types.Config
+	auditService   *AuditService
+	isLeader       func() bool
+	webhookService *WebhookService
+	prober         EndpointProber
+	configHistory  *ConfigHistory
 }
 
-func NewNodeService(db *gorm.DB, config *types.Config) *NodeService {
+// IPConflict is a set of nodes that share one allocated IP.
+type IPConflict struct {
+	AllocatedIP string      `json:"allocated_ip"`
+	NodeIDs     []uuid.UUID `json:"node_ids"`
+}
+
+// IPReallocation records a node moved off a duplicate IP during repair.
+type IPReallocation struct {
+	NodeID   uuid.UUID `json:"node_id"`
+	NodeName string    `json:"node_name"`
+	OldIP    string    `json:"old_ip"`
+	NewIP    string    `json:"new_ip"`
+}
+
+type IPConsistencyReport struct {
+	Conflicts   []IPConflict     `json:"conflicts"`
+	Reallocated []IPReallocation `json:"reallocated"`
+	Repaired    bool             `json:"repaired"`
+	CheckedAt   time.Time        `json:"checked_at"`
+}
+
+func NewNodeService(db *gorm.DB, config *types.Config, auditService *AuditService) *NodeService {
 	return &NodeService{
-		db:     db,
-		config: config,
+		db:           db,
+		config:       config,
+		auditService: auditService,
 	}
 }
 
@@ -46,37 +83,121 @@ func (s *NodeService) RegisterNode(ctx context.Context, req types.NodeRegistrati
 		return nil, ErrInvalidPublicKey
 	}
 
-	// Check if node already exists
+	// Name and endpoint end up in peers' configs
+	if err := validateText(req.Name, req.Description, req.AgentVersion, req.BuildCommit); err != nil {
+		return nil, err
+	}
+	if req.Port > 0 || !s.allocatesPorts() {
+		if err := validateEndpoint(req.Endpoint, req.Port); err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate hub pinning
+	if err := s.validateHubPin(req.PinnedHubID, req.BackupHubIDs); err != nil {
+		return nil, err
+	}
+
+	if err := validateRoutes(req.Routes); err != nil {
+		return nil, err
+	}
+
+	if err := validateAllowedIPs(req.AllowedIPs); err != nil {
+		return nil, err
+	}
+
+	if err := req.InterfaceHooks.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := validateTags(req.Tags); err != nil {
+		return nil, err
+	}
+
+	if err := validateKeepalive(req.PersistentKeepalive); err != nil {
+		return nil, err
+	}
+
+	// A node registering its key again, e.g. an agent that lost its node
+	// ID, gets its existing record and address back
 	var existingNode models.Node
-	if err := s.db.Where("name = ?", req.Name).First(&existingNode).Error; err == nil {
-		return nil, ErrNodeExists
+	err := s.db.Where("public_key = ?", req.PublicKey).First(&existingNode).Error
+	if err == nil {
+		return s.reclaimNode(ctx, &existingNode, req)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to look up node: %w", err)
 	}
 
-	// Allocate IP address
-	allocatedIP, err := s.allocateIP(ctx, models.NodeType(req.NodeType))
-	if err != nil {
-		return nil, fmt.Errorf("failed to allocate IP: %w", err)
+	// Check if node already exists. Deleted nodes are removed outright, so
+	// their names can be registered again.
+	if err := s.db.Where("name = ?", req.Name).First(&existingNode).Error; err == nil {
+		return nil, ErrNodeExists
 	}
 
 	// Create node
 	node := &models.Node{
-		Name:        req.Name,
-		NodeType:    models.NodeType(req.NodeType),
-		PublicKey:   req.PublicKey,
-		AllocatedIP: allocatedIP,
-		Endpoint:    req.Endpoint,
-		Port:        req.Port,
-		AllowedIPs:  req.AllowedIPs,
-		Status:      models.NodeStatusPending,
-		MTU:         s.config.WG.MTU,
-	}
-
-	if s.config.WG.PersistentKeepalive > 0 {
+		Name:         req.Name,
+		Description:  req.Description,
+		NodeType:     models.NodeType(req.NodeType),
+		PublicKey:    req.PublicKey,
+		Endpoint:     req.Endpoint,
+		AllowedIPs:   req.AllowedIPs,
+		Routes:       req.Routes,
+		PreUp:        req.PreUp,
+		PostUp:       req.PostUp,
+		PreDown:      req.PreDown,
+		PostDown:     req.PostDown,
+		Status:       models.NodeStatusPending,
+		MTU:          s.config.WG.MTU,
+		PinnedHubID:  req.PinnedHubID,
+		BackupHubIDs: req.BackupHubIDs,
+		Tags:         req.Tags,
+		AgentVersion: req.AgentVersion,
+		BuildCommit:  req.BuildCommit,
+	}
+
+	if req.PersistentKeepalive != nil {
+		node.PersistentKeepalive = req.PersistentKeepalive
+	} else if s.config.WG.PersistentKeepalive > 0 {
 		node.PersistentKeepalive = &s.config.WG.PersistentKeepalive
 	}
 
-	if err := s.db.Create(node).Error; err != nil {
-		return nil, fmt.Errorf("failed to create node: %w", err)
+	// Allocate the address and port and store the node under one lock, so
+	// concurrent registrations can't be given the same ones
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockTx(tx, nodeAllocationLock); err != nil {
+			return fmt.Errorf("failed to lock node allocation: %w", err)
+		}
+
+		allocatedIP, err := s.allocateIP(ctx, tx, node.NodeType)
+		if err != nil {
+			return fmt.Errorf("failed to allocate IP: %w", err)
+		}
+
+		port, err := s.listenPort(ctx, tx, node.NodeType, req.Endpoint, req.Port)
+		if err != nil {
+			return fmt.Errorf("failed to allocate listen port: %w", err)
+		}
+
+		// An endpoint without a port is checked once one is allocated
+		if err := validateEndpoint(req.Endpoint, port); err != nil {
+			return err
+		}
+
+		if err := s.checkRoutes(tx, uuid.Nil, req.Routes); err != nil {
+			return err
+		}
+
+		node.AllocatedIP = allocatedIP
+		node.Port = port
+		if err := tx.Create(node).Error; err != nil {
+			return fmt.Errorf("failed to create node: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Update topology if it's a spoke node
@@ -86,13 +207,98 @@ func (s *NodeService) RegisterNode(ctx context.Context, req types.NodeRegistrati
 		}
 	}
 
+	s.notifyNodeEvent(NodeEventRegistered, node, "")
+
+	return node, nil
+}
+
+// reclaimNode returns the node already registered with the request's public
+// key, refreshing the agent version it reports.
+func (s *NodeService) reclaimNode(ctx context.Context, node *models.Node, req types.NodeRegistrationRequest) (*models.Node, error) {
+	if req.AgentVersion != "" || req.BuildCommit != "" {
+		updates := map[string]interface{}{
+			"agent_version": req.AgentVersion,
+			"build_commit":  req.BuildCommit,
+		}
+		if err := s.db.Model(node).Updates(updates).Error; err != nil {
+			return nil, fmt.Errorf("failed to update node: %w", err)
+		}
+	}
+
+	slog.InfoContext(ctx, "Node registered again with its existing public key", "node_id", node.ID, "node_name", node.Name, "requested_name", req.Name)
 	return node, nil
 }
 
-func (s *NodeService) GetNodes(ctx context.Context, page, perPage int, nodeType, status string) ([]models.Node, int64, error) {
+// SetWebhookService makes node lifecycle changes notify node webhooks.
+func (s *NodeService) SetWebhookService(webhookService *WebhookService) {
+	s.webhookService = webhookService
+}
+
+func (s *NodeService) notifyNodeEvent(event string, node *models.Node, previous models.NodeStatus) {
+	if s.webhookService != nil {
+		s.webhookService.NotifyNodeEvent(event, node, previous)
+	}
+}
+
+// notifyStatusChange sends the events for a node whose status changed from
+// previous: approved when it leaves pending for anything but disabled, and
+// active when it becomes active.
+func (s *NodeService) notifyStatusChange(node *models.Node, previous models.NodeStatus) {
+	if node.Status == previous {
+		return
+	}
+	if previous == models.NodeStatusPending && node.Status != models.NodeStatusDisabled {
+		s.notifyNodeEvent(NodeEventApproved, node, previous)
+	}
+	if node.Status == models.NodeStatusActive {
+		s.notifyNodeEvent(NodeEventActive, node, previous)
+	}
+}
+
+// nodeSortColumns maps the sort fields GetNodes accepts to their columns.
+// Anything else is rejected rather than written into ORDER BY.
+var nodeSortColumns = map[string]string{
+	"name":           "name",
+	"created_at":     "created_at",
+	"status":         "status",
+	"last_handshake": "last_handshake",
+}
+
+// nodeOrder returns the ORDER BY clause for sorting nodes by sort in order,
+// created_at and asc when empty. Ties are broken by ID so pages don't
+// overlap.
+func nodeOrder(sort, order string) (string, error) {
+	if sort == "" {
+		sort = "created_at"
+	}
+	column, ok := nodeSortColumns[sort]
+	if !ok {
+		return "", ErrInvalidSort
+	}
+
+	switch strings.ToLower(order) {
+	case "", "asc":
+		return column + " ASC, id", nil
+	case "desc":
+		return column + " DESC, id", nil
+	default:
+		return "", ErrInvalidSortOrder
+	}
+}
+
+// GetNodes returns a page of nodes sorted by sort (name, created_at, status
+// or last_handshake) in order (asc or desc). Each of tags is "key:value" to
+// match nodes with that tag, or "key" to match nodes with the key set to any
+// value; nodes must match all of them.
+func (s *NodeService) GetNodes(ctx context.Context, page, perPage int, nodeType, status, search string, tags []string, sort, order string) ([]models.Node, int64, error) {
 	var nodes []models.Node
 	var total int64
 
+	orderBy, err := nodeOrder(sort, order)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	query := s.db.Model(&models.Node{})
 
 	if nodeType != "" {
@@ -103,12 +309,22 @@ func (s *NodeService) GetNodes(ctx context.Context, page, perPage int, nodeType,
 		query = query.Where("status = ?", status)
 	}
 
+	query = matchNameOrDescription(query, search)
+
+	for _, tag := range tags {
+		pattern, err := tagPattern(tag)
+		if err != nil {
+			return nil, 0, err
+		}
+		query = query.Where(`tags LIKE ? ESCAPE '\'`, pattern)
+	}
+
 	if err := query.Count(&total).Error; err != nil {
 		return nil, 0, fmt.Errorf("failed to count nodes: %w", err)
 	}
 
 	offset := (page - 1) * perPage
-	if err := query.Offset(offset).Limit(perPage).Find(&nodes).Error; err != nil {
+	if err := query.Order(orderBy).Offset(offset).Limit(perPage).Find(&nodes).Error; err != nil {
 		return nil, 0, fmt.Errorf("failed to get nodes: %w", err)
 	}
 
@@ -136,11 +352,42 @@ func (s *NodeService) UpdateNode(ctx context.Context, id uuid.UUID, req types.No
 		return nil, fmt.Errorf("failed to get node: %w", err)
 	}
 
+	if req.Name != nil {
+		if err := validateText(*req.Name); err != nil {
+			return nil, err
+		}
+	}
+	if req.Description != nil {
+		if err := validateText(*req.Description); err != nil {
+			return nil, err
+		}
+	}
+	if req.Endpoint != nil || req.Port != nil {
+		endpoint, port := node.Endpoint, node.Port
+		if req.Endpoint != nil {
+			endpoint = *req.Endpoint
+		}
+		if req.Port != nil {
+			port = *req.Port
+		}
+		if err := validateEndpoint(endpoint, port); err != nil {
+			return nil, err
+		}
+	}
+	if req.Port != nil && *req.Port != node.Port {
+		if err := s.checkPortFree(s.db, *req.Port, node.ID); err != nil {
+			return nil, err
+		}
+	}
+
 	updates := make(map[string]interface{})
 
 	if req.Name != nil {
 		updates["name"] = *req.Name
 	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
 	if req.Endpoint != nil {
 		updates["endpoint"] = *req.Endpoint
 	}
@@ -148,17 +395,103 @@ func (s *NodeService) UpdateNode(ctx context.Context, id uuid.UUID, req types.No
 		updates["port"] = *req.Port
 	}
 	if req.AllowedIPs != nil {
+		if err := validateAllowedIPs(req.AllowedIPs); err != nil {
+			return nil, err
+		}
 		updates["allowed_ips"] = req.AllowedIPs
 	}
+	if req.Routes != nil {
+		if err := validateRoutes(req.Routes); err != nil {
+			return nil, err
+		}
+		updates["routes"] = req.Routes
+	}
+	if err := req.InterfaceHooks.Validate(); err != nil {
+		return nil, err
+	}
+	if req.PreUp != nil {
+		updates["pre_up"] = req.PreUp
+	}
+	if req.PostUp != nil {
+		updates["post_up"] = req.PostUp
+	}
+	if req.PreDown != nil {
+		updates["pre_down"] = req.PreDown
+	}
+	if req.PostDown != nil {
+		updates["post_down"] = req.PostDown
+	}
+	if req.Tags != nil {
+		if err := validateTags(req.Tags); err != nil {
+			return nil, err
+		}
+		// Map updates bypass the column's serializer
+		data, err := json.Marshal(req.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode tags: %w", err)
+		}
+		updates["tags"] = string(data)
+	}
+	if req.PersistentKeepalive != nil {
+		if err := validateKeepalive(req.PersistentKeepalive); err != nil {
+			return nil, err
+		}
+		updates["persistent_keepalive"] = *req.PersistentKeepalive
+	}
 	if req.Status != nil {
 		updates["status"] = *req.Status
 	}
+	if req.PinnedHubID != nil || req.BackupHubIDs != nil {
+		if err := s.validateHubPin(req.PinnedHubID, req.BackupHubIDs); err != nil {
+			return nil, err
+		}
+		if req.PinnedHubID != nil {
+			if *req.PinnedHubID == uuid.Nil {
+				updates["pinned_hub_id"] = nil
+			} else {
+				updates["pinned_hub_id"] = *req.PinnedHubID
+			}
+		}
+		if req.BackupHubIDs != nil {
+			updates["backup_hub_ids"] = req.BackupHubIDs
+		}
+	}
 
+	// The endpoint is probed with any change made in the same request
+	if req.Status != nil && models.NodeStatus(*req.Status) == models.NodeStatusActive && node.Status != models.NodeStatusActive {
+		probed := node
+		if req.Endpoint != nil {
+			probed.Endpoint = *req.Endpoint
+		}
+		if req.Port != nil {
+			probed.Port = *req.Port
+		}
+		if err := s.probeEndpoints(ctx, []models.Node{probed}); err != nil {
+			return nil, err
+		}
+	}
+
+	previousStatus := node.Status
 	if len(updates) > 0 {
-		if err := s.db.Model(&node).Updates(updates).Error; err != nil {
-			return nil, fmt.Errorf("failed to update node: %w", err)
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if req.Routes != nil {
+				if err := lockTx(tx, nodeAllocationLock); err != nil {
+					return fmt.Errorf("failed to lock node allocation: %w", err)
+				}
+				if err := s.checkRoutes(tx, node.ID, req.Routes); err != nil {
+					return err
+				}
+			}
+			if err := tx.Model(&node).Updates(updates).Error; err != nil {
+				return fmt.Errorf("failed to update node: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
+	s.notifyStatusChange(&node, previousStatus)
 
 	return &node, nil
 }
@@ -172,8 +505,24 @@ func (s *NodeService) DeleteNode(ctx context.Context, id uuid.UUID) error {
 		return fmt.Errorf("failed to get node: %w", err)
 	}
 
-	if err := s.db.Delete(&node).Error; err != nil {
-		return fmt.Errorf("failed to delete node: %w", err)
+	// Delete outright rather than soft delete, which would keep holding the
+	// node's unique name
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("hub_id = ? OR spoke_id = ?", node.ID, node.ID).Delete(&models.Topology{}).Error; err != nil {
+			return fmt.Errorf("failed to remove topology: %w", err)
+		}
+		if err := tx.Unscoped().Delete(&node).Error; err != nil {
+			return fmt.Errorf("failed to delete node: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notifyNodeEvent(NodeEventDeleted, &node, "")
+	if s.configHistory != nil {
+		s.configHistory.Forget(node.ID)
 	}
 
 	return nil
@@ -188,12 +537,6 @@ func (s *NodeService) GetNodeConfig(ctx context.Context, id uuid.UUID) (*types.N
 		return nil, fmt.Errorf("failed to get node: %w", err)
 	}
 
-	// Generate private key for the node (this should be done during registration in practice)
-	privateKey, err := s.generatePrivateKey()
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate private key: %w", err)
-	}
-
 	// Get peers for this node
 	peers, err := s.getPeersForNode(ctx, &node)
 	if err != nil {
@@ -201,51 +544,78 @@ func (s *NodeService) GetNodeConfig(ctx context.Context, id uuid.UUID) (*types.N
 	}
 
 	config := &types.NodeConfigResponse{
+		// The agent fills in its own private key
 		Interface: types.WGInterface{
-			PrivateKey: privateKey,
 			Address:    []string{node.AllocatedIP},
 			ListenPort: node.Port,
 			MTU:        node.MTU,
+			DNS:        s.config.WG.DNS,
+			InterfaceHooks: types.InterfaceHooks{
+				PreUp:    node.PreUp,
+				PostUp:   node.PostUp,
+				PreDown:  node.PreDown,
+				PostDown: node.PostDown,
+			},
 		},
 		Peers:       peers,
 		GeneratedAt: time.Now(),
+		RotateKey:   node.KeyRotationRequestedAt != nil,
 	}
 
-	return config, nil
-}
+	seen := make(map[string]bool)
+	for _, peer := range peers {
+		for _, route := range peer.Routes {
+			if !seen[route] {
+				seen[route] = true
+				config.Routes = append(config.Routes, route)
+			}
+		}
+	}
 
-func (s *NodeService) isValidPublicKey(publicKey string) bool {
-	decoded, err := base64.StdEncoding.DecodeString(publicKey)
-	if err != nil {
-		return false
+	if s.configHistory != nil {
+		s.configHistory.Record(id, config)
 	}
-	return len(decoded) == 32
+
+	return config, nil
 }
 
-func (s *NodeService) generatePrivateKey() (string, error) {
-	var privateKey [32]byte
-	if _, err := rand.Read(privateKey[:]); err != nil {
-		return "", err
+// ReportPeerErrors records peers that an agent was unable to apply to its
+// interface so operators can track down the invalid entries. Each report
+// replaces the last, so one without skipped peers clears them.
+func (s *NodeService) ReportPeerErrors(ctx context.Context, id uuid.UUID, report types.PeerApplyReport) error {
+	var node models.Node
+	if err := s.db.WithContext(ctx).Where("id = ?", id).First(&node).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNodeNotFound
+		}
+		return fmt.Errorf("failed to get node: %w", err)
 	}
-	return base64.StdEncoding.EncodeToString(privateKey[:]), nil
-}
 
-func (s *NodeService) generatePublicKey(privateKey string) (string, error) {
-	decoded, err := base64.StdEncoding.DecodeString(privateKey)
-	if err != nil {
-		return "", err
+	peerErrors := make([]models.PeerError, 0, len(report.Skipped))
+	for _, peer := range report.Skipped {
+		slog.WarnContext(ctx, "Node skipped peer", "node", node.Name, "peer", peer.PublicKey, "reason", peer.Error)
+		peerErrors = append(peerErrors, models.PeerError{PublicKey: peer.PublicKey, Error: peer.Error})
 	}
 
-	var privateKeyBytes [32]byte
-	copy(privateKeyBytes[:], decoded)
+	reportedAt := report.ReportedAt
+	if reportedAt.IsZero() {
+		reportedAt = time.Now()
+	}
+	if err := s.db.WithContext(ctx).Model(&node).Select("PeerErrors", "PeerErrorsReportedAt").Updates(&models.Node{
+		PeerErrors:           peerErrors,
+		PeerErrorsReportedAt: &reportedAt,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to record peer errors: %w", err)
+	}
 
-	var publicKey [32]byte
-	curve25519.ScalarBaseMult(&publicKey, &privateKeyBytes)
+	return nil
+}
 
-	return base64.StdEncoding.EncodeToString(publicKey[:]), nil
+func (s *NodeService) isValidPublicKey(publicKey string) bool {
+	return types.ValidateKey(publicKey) == nil
 }
 
-func (s *NodeService) allocateIP(ctx context.Context, nodeType models.NodeType) (string, error) {
+func (s *NodeService) allocateIP(ctx context.Context, tx *gorm.DB, nodeType models.NodeType) (string, error) {
 	// Parse subnet
 	_, subnet, err := net.ParseCIDR(s.config.WG.Subnet)
 	if err != nil {
@@ -254,22 +624,37 @@ func (s *NodeService) allocateIP(ctx context.Context, nodeType models.NodeType)
 
 	// Get all allocated IPs
 	var nodes []models.Node
-	if err := s.db.Select("allocated_ip").Find(&nodes).Error; err != nil {
+	if err := tx.Select("allocated_ip").Find(&nodes).Error; err != nil {
 		return "", fmt.Errorf("failed to get allocated IPs: %w", err)
 	}
 
 	allocatedIPs := make(map[string]bool)
 	for _, node := range nodes {
-		allocatedIPs[node.AllocatedIP] = true
+		allocatedIPs[ipKey(node.AllocatedIP)] = true
 	}
 
-	// Find available IP
-	ip := subnet.IP
+	return nextFreeIP(subnet, allocatedIPs)
+}
+
+// ipKey strips the prefix length from an allocated IP so that "10.0.0.2/24"
+// and "10.0.0.2" compare equal.
+func ipKey(allocatedIP string) string {
+	if i := strings.IndexByte(allocatedIP, '/'); i >= 0 {
+		return allocatedIP[:i]
+	}
+	return allocatedIP
+}
+
+// nextFreeIP returns the first host address in subnet that is not in used,
+// with the subnet's prefix length appended.
+func nextFreeIP(subnet *net.IPNet, used map[string]bool) (string, error) {
+	ones, _ := subnet.Mask.Size()
+
+	ip := make(net.IP, len(subnet.IP))
+	copy(ip, subnet.IP)
 	for subnet.Contains(ip) {
 		ipStr := ip.String()
-		if !allocatedIPs[ipStr] && !ip.Equal(subnet.IP) {
-			// Add subnet mask
-			ones, _ := subnet.Mask.Size()
+		if !used[ipStr] && !ip.Equal(subnet.IP) {
 			return fmt.Sprintf("%s/%d", ipStr, ones), nil
 		}
 		// Increment IP
@@ -284,6 +669,107 @@ func (s *NodeService) allocateIP(ctx context.Context, nodeType models.NodeType)
 	return "", errors.New("no available IP addresses")
 }
 
+// CheckDuplicateIPs finds nodes that share an allocated IP, which can happen
+// after an import or a manual database edit. With repair set, the oldest node
+// of each conflict keeps its address and the others are moved to free ones.
+// performedBy is nil for the check run at startup.
+func (s *NodeService) CheckDuplicateIPs(ctx context.Context, repair bool, performedBy *uuid.UUID) (*IPConsistencyReport, error) {
+	report := &IPConsistencyReport{
+		Conflicts:   []IPConflict{},
+		Reallocated: []IPReallocation{},
+		Repaired:    repair,
+		CheckedAt:   time.Now(),
+	}
+
+	var nodes []models.Node
+	if err := s.db.Select("id", "name", "allocated_ip", "created_at").Order("created_at, id").Find(&nodes).Error; err != nil {
+		return nil, fmt.Errorf("failed to get allocated IPs: %w", err)
+	}
+
+	used := make(map[string]bool)
+	byIP := make(map[string][]models.Node)
+	var order []string
+	for _, node := range nodes {
+		key := ipKey(node.AllocatedIP)
+		if _, seen := byIP[key]; !seen {
+			order = append(order, key)
+		}
+		byIP[key] = append(byIP[key], node)
+		used[key] = true
+	}
+
+	for _, key := range order {
+		if len(byIP[key]) < 2 {
+			continue
+		}
+
+		conflict := IPConflict{AllocatedIP: key}
+		for _, node := range byIP[key] {
+			conflict.NodeIDs = append(conflict.NodeIDs, node.ID)
+		}
+		report.Conflicts = append(report.Conflicts, conflict)
+
+		nodeIDs := make([]string, len(conflict.NodeIDs))
+		for i, id := range conflict.NodeIDs {
+			nodeIDs[i] = id.String()
+		}
+		s.auditService.LogActionWithMetadata(ctx, performedBy, models.AuditActionCreate, "ip_conflict", nil,
+			fmt.Sprintf("Detected %d nodes sharing allocated IP %s", len(conflict.NodeIDs), key), "", "",
+			map[string]interface{}{
+				"allocated_ip": key,
+				"node_ids":     nodeIDs,
+			})
+	}
+
+	if !repair || len(report.Conflicts) == 0 {
+		return report, nil
+	}
+
+	_, subnet, err := net.ParseCIDR(s.config.WG.Subnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet: %w", err)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		audit := s.auditService.WithTx(tx)
+
+		for _, conflict := range report.Conflicts {
+			// The oldest node keeps the address
+			for _, node := range byIP[conflict.AllocatedIP][1:] {
+				newIP, err := nextFreeIP(subnet, used)
+				if err != nil {
+					return fmt.Errorf("failed to reallocate node %s: %w", node.Name, err)
+				}
+				used[ipKey(newIP)] = true
+
+				if err := tx.Model(&models.Node{}).Where("id = ?", node.ID).Update("allocated_ip", newIP).Error; err != nil {
+					return fmt.Errorf("failed to reallocate node %s: %w", node.Name, err)
+				}
+
+				audit.LogActionWithMetadata(ctx, performedBy, models.AuditActionUpdate, "node", &node.ID,
+					fmt.Sprintf("Reallocated node %s from duplicate IP %s to %s", node.Name, node.AllocatedIP, newIP), "", "",
+					map[string]interface{}{
+						"old_ip": node.AllocatedIP,
+						"new_ip": newIP,
+					})
+
+				report.Reallocated = append(report.Reallocated, IPReallocation{
+					NodeID:   node.ID,
+					NodeName: node.Name,
+					OldIP:    node.AllocatedIP,
+					NewIP:    newIP,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
 func (s *NodeService) updateTopology(ctx context.Context, spokeNode *models.Node) error {
 	// Find active hub nodes
 	var hubNodes []models.Node
@@ -291,10 +777,10 @@ func (s *NodeService) updateTopology(ctx context.Context, spokeNode *models.Node
 		return fmt.Errorf("failed to get hub nodes: %w", err)
 	}
 
-	// Connect spoke to first available hub
-	if len(hubNodes) > 0 {
+	// Connect spoke to its pinned hub, or the first available hub if unpinned
+	if hub := s.selectHub(spokeNode, hubNodes); hub != nil {
 		topology := &models.Topology{
-			HubID:   hubNodes[0].ID,
+			HubID:   hub.ID,
 			SpokeID: spokeNode.ID,
 		}
 
@@ -306,9 +792,281 @@ func (s *NodeService) updateTopology(ctx context.Context, spokeNode *models.Node
 	return nil
 }
 
+// selectHub returns the hub a spoke should be attached to. Pinned spokes are
+// only placed on their pinned hub or, failing that, one of their explicitly
+// allowed backup hubs in order; they are never moved to any other hub.
+func (s *NodeService) selectHub(spoke *models.Node, hubs []models.Node) *models.Node {
+	if !spoke.IsPinned() {
+		if len(hubs) == 0 {
+			return nil
+		}
+		return &hubs[0]
+	}
+
+	candidates := []string{spoke.PinnedHubID.String()}
+	candidates = append(candidates, spoke.BackupHubIDs...)
+
+	for _, candidate := range candidates {
+		for i := range hubs {
+			if hubs[i].ID.String() == candidate {
+				return &hubs[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateRoutes checks that every route is a subnet in CIDR notation.
+// Default routes are refused; they would take over the agent's uplink.
+func validateRoutes(routes []string) error {
+	for _, route := range routes {
+		_, ipNet, err := net.ParseCIDR(route)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidRoute, route)
+		}
+		if ones, _ := ipNet.Mask.Size(); ones == 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidRoute, route)
+		}
+	}
+	return nil
+}
+
+// LogHookChange records a change to a node's interface hooks, which run as
+// root on the node, in an audit entry of its own.
+func (s *NodeService) LogHookChange(ctx context.Context, node *models.Node, hooks types.InterfaceHooks, performedBy *uuid.UUID, ipAddress, userAgent string) {
+	s.auditService.LogActionWithMetadata(ctx, performedBy, models.AuditActionUpdate, "node_hooks", &node.ID,
+		fmt.Sprintf("Changed interface hooks of node %s", node.Name), ipAddress, userAgent,
+		map[string]interface{}{
+			"pre_up":    hooks.PreUp,
+			"post_up":   hooks.PostUp,
+			"pre_down":  hooks.PreDown,
+			"post_down": hooks.PostDown,
+		})
+}
+
+// validateAllowedIPs checks the subnets written into peers' AllowedIPs, so
+// nothing but a CIDR can reach a config file.
+func validateAllowedIPs(allowedIPs []string) error {
+	for _, cidr := range allowedIPs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidAllowedIP, cidr)
+		}
+	}
+	return nil
+}
+
+// validateEndpoint checks the address peers will dial, which is endpoint
+// alone or endpoint with port appended when the port is given separately.
+// Nodes without an endpoint are only dialled out from.
+func validateEndpoint(endpoint string, port int) error {
+	if endpoint == "" {
+		return nil
+	}
+	node := models.Node{Endpoint: endpoint, Port: port}
+	return types.ValidateEndpoint(node.GetEndpoint())
+}
+
+func validateTags(tags map[string]string) error {
+	for key, value := range tags {
+		if key == "" || len(key) > 63 || strings.Contains(key, ":") || len(value) > 255 {
+			return fmt.Errorf("%w: %q", ErrInvalidTag, key)
+		}
+		if err := validateText(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tagPattern turns a "key:value" or "key" filter into a LIKE pattern over
+// the tags column. Tags are stored as JSON, where quotes inside keys and
+// values are escaped, so a quoted key followed by a colon only matches a
+// key and a quoted value only matches a whole value.
+func tagPattern(filter string) (string, error) {
+	key, value, hasValue := strings.Cut(filter, ":")
+	if key == "" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidTag, filter)
+	}
+
+	encodedKey, _ := json.Marshal(key)
+	pattern := string(encodedKey) + ":"
+	if hasValue {
+		encodedValue, _ := json.Marshal(value)
+		pattern += string(encodedValue)
+	}
+
+	return "%" + likeEscaper.Replace(pattern) + "%", nil
+}
+
+// likeEscaper escapes LIKE wildcards for patterns used with ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// validateKeepalive checks a keepalive interval fits WireGuard's 16-bit
+// field.
+func validateKeepalive(keepalive *int) error {
+	if keepalive != nil && (*keepalive < 0 || *keepalive > 65535) {
+		return ErrInvalidKeepalive
+	}
+	return nil
+}
+
+func validateText(values ...string) error {
+	for _, value := range values {
+		if err := types.ValidateText(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *NodeService) validateHubPin(pinnedHubID *uuid.UUID, backupHubIDs []string) error {
+	hubIDs := make([]string, 0, len(backupHubIDs)+1)
+	if pinnedHubID != nil && *pinnedHubID != uuid.Nil {
+		hubIDs = append(hubIDs, pinnedHubID.String())
+	}
+	for _, backupID := range backupHubIDs {
+		if _, err := uuid.Parse(backupID); err != nil {
+			return ErrInvalidPinnedHub
+		}
+		hubIDs = append(hubIDs, backupID)
+	}
+
+	for _, hubID := range hubIDs {
+		var hub models.Node
+		if err := s.db.Where("id = ? AND node_type = ?", hubID, models.NodeTypeHub).First(&hub).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidPinnedHub
+			}
+			return fmt.Errorf("failed to get hub node: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// RebalanceTopology redistributes spokes across the active hubs. Unpinned
+// spokes are spread evenly, while pinned spokes are only moved between their
+// pinned hub and allowed backups. It returns the number of spokes moved.
+func (s *NodeService) RebalanceTopology(ctx context.Context) (int, error) {
+	var hubNodes []models.Node
+	if err := s.db.Where("node_type = ? AND status = ?", models.NodeTypeHub, models.NodeStatusActive).Order("created_at").Find(&hubNodes).Error; err != nil {
+		return 0, fmt.Errorf("failed to get hub nodes: %w", err)
+	}
+	if len(hubNodes) == 0 {
+		return 0, nil
+	}
+
+	var spokes []models.Node
+	if err := s.db.Where("node_type = ?", models.NodeTypeSpoke).Order("created_at").Find(&spokes).Error; err != nil {
+		return 0, fmt.Errorf("failed to get spoke nodes: %w", err)
+	}
+
+	var links []models.Topology
+	if err := s.db.Find(&links).Error; err != nil {
+		return 0, fmt.Errorf("failed to get topology: %w", err)
+	}
+
+	currentHub := make(map[uuid.UUID]uuid.UUID)
+	for _, link := range links {
+		currentHub[link.SpokeID] = link.HubID
+	}
+
+	activeHubs := make(map[uuid.UUID]bool)
+	load := make(map[uuid.UUID]int)
+	for _, hub := range hubNodes {
+		activeHubs[hub.ID] = true
+		load[hub.ID] = 0
+	}
+
+	assignments := make(map[uuid.UUID]uuid.UUID)
+
+	// Place pinned spokes first so they count towards hub load
+	var unpinned []models.Node
+	for i := range spokes {
+		spoke := &spokes[i]
+		if !spoke.IsPinned() {
+			unpinned = append(unpinned, *spoke)
+			continue
+		}
+
+		if hub := s.selectHub(spoke, hubNodes); hub != nil {
+			assignments[spoke.ID] = hub.ID
+			load[hub.ID]++
+		} else if hubID, ok := currentHub[spoke.ID]; ok && activeHubs[hubID] {
+			load[hubID]++
+		}
+	}
+
+	// Spread unpinned spokes evenly, keeping them in place where possible
+	maxPerHub := (len(spokes) + len(hubNodes) - 1) / len(hubNodes)
+	for _, spoke := range unpinned {
+		if hubID, ok := currentHub[spoke.ID]; ok && activeHubs[hubID] && load[hubID] < maxPerHub {
+			assignments[spoke.ID] = hubID
+			load[hubID]++
+			continue
+		}
+
+		target := hubNodes[0].ID
+		for _, hub := range hubNodes {
+			if load[hub.ID] < load[target] {
+				target = hub.ID
+			}
+		}
+		assignments[spoke.ID] = target
+		load[target]++
+	}
+
+	moved := 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for spokeID, hubID := range assignments {
+			if current, ok := currentHub[spokeID]; ok && current == hubID {
+				continue
+			}
+
+			if err := tx.Unscoped().Where("spoke_id = ?", spokeID).Delete(&models.Topology{}).Error; err != nil {
+				return fmt.Errorf("failed to remove topology: %w", err)
+			}
+
+			topology := &models.Topology{
+				HubID:   hubID,
+				SpokeID: spokeID,
+			}
+			if err := tx.Create(topology).Error; err != nil {
+				return fmt.Errorf("failed to create topology: %w", err)
+			}
+
+			moved++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return moved, nil
+}
+
+// getPeersForNode returns the node's peers with their AllowedIPs and routes
+// narrowed to the destinations the node's policies allow. With the mesh
+// feature on, spokes also peer directly with the spokes they share a mesh
+// group with, and no longer reach those through the hub.
 func (s *NodeService) getPeersForNode(ctx context.Context, node *models.Node) ([]types.WGPeer, error) {
 	var peers []types.WGPeer
 
+	policies, err := loadPeerPolicies(s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keepalives are sent by the node the config is for, so its own setting
+	// applies to all of its peers: a roaming spoke behind NAT keeps its
+	// mapping open while a server on a stable address can leave them off
+	keepalive := 0
+	if node.PersistentKeepalive != nil {
+		keepalive = *node.PersistentKeepalive
+	}
+
 	if node.NodeType == models.NodeTypeHub {
 		// For hub nodes, get all connected spoke nodes
 		var spokes []models.Node
@@ -320,14 +1078,13 @@ func (s *NodeService) getPeersForNode(ctx context.Context, node *models.Node) ([
 			return nil, fmt.Errorf("failed to get spoke nodes: %w", err)
 		}
 
-		for _, spoke := range spokes {
-			peer := types.WGPeer{
-				PublicKey:  spoke.PublicKey,
-				AllowedIPs: []string{spoke.AllocatedIP},
-			}
-			if spoke.PersistentKeepalive != nil {
-				peer.PersistentKeepalive = *spoke.PersistentKeepalive
+		for i := range spokes {
+			spoke := &spokes[i]
+			peer, ok := policies.peer(node, spoke, spoke.AllocatedIP)
+			if !ok {
+				continue
 			}
+			peer.PersistentKeepalive = keepalive
 			peers = append(peers, peer)
 		}
 	} else {
@@ -341,18 +1098,142 @@ func (s *NodeService) getPeersForNode(ctx context.Context, node *models.Node) ([
 			return nil, fmt.Errorf("failed to get hub node: %w", err)
 		}
 
+		var mesh []models.Node
+		if s.config.Features.Mesh {
+			if mesh, err = meshPeers(s.db, node); err != nil {
+				return nil, err
+			}
+		}
+		direct := make(map[uuid.UUID]bool, len(mesh))
+		for _, peer := range mesh {
+			direct[peer.ID] = true
+		}
+
 		if hub.ID != uuid.Nil {
-			peer := types.WGPeer{
-				PublicKey:  hub.PublicKey,
-				AllowedIPs: []string{"0.0.0.0/0"},
-				Endpoint:   hub.GetEndpoint(),
+			// LANs behind the hub and behind the other spokes on it are all
+			// reached through the hub
+			var siblings []models.Node
+			if err := s.db.Raw(`
+				SELECT n.* FROM nodes n
+				JOIN topology t ON n.id = t.spoke_id
+				WHERE t.hub_id = ? AND n.id <> ? AND n.status = ?
+			`, hub.ID, node.ID, models.NodeStatusActive).Scan(&siblings).Error; err != nil {
+				return nil, fmt.Errorf("failed to get sibling spoke nodes: %w", err)
 			}
-			if hub.PersistentKeepalive != nil {
-				peer.PersistentKeepalive = *hub.PersistentKeepalive
+			// Everything is sent through the hub, including the internet
+			// for full-tunnel spokes and spokes on other hubs. Destinations
+			// behind it that a policy denies are cut out of that
+			var denied []string
+			routes := []string{}
+			for _, destination := range append([]models.Node{hub}, siblings...) {
+				if direct[destination.ID] {
+					continue
+				}
+				address := hostCIDR(ipKey(destination.AllocatedIP))
+				if !policies.allows(node, &destination, address) {
+					denied = append(denied, address)
+				}
+				allowed := policies.filter(node, &destination, destination.Routes)
+				denied = append(denied, deniedCIDRs(destination.Routes, allowed)...)
+				routes = append(routes, allowed...)
 			}
+			allowedIPs := excludeCIDRs(denied)
+			if len(allowedIPs) > 0 {
+				peers = append(peers, types.WGPeer{
+					PublicKey:           hub.PublicKey,
+					AllowedIPs:          allowedIPs,
+					Endpoint:            hub.GetEndpoint(),
+					Routes:              routes,
+					PersistentKeepalive: keepalive,
+				})
+			}
+		}
+
+		for i := range mesh {
+			spoke := &mesh[i]
+			peer, ok := policies.peer(node, spoke, hostCIDR(ipKey(spoke.AllocatedIP)))
+			if !ok {
+				continue
+			}
+			peer.Endpoint = spoke.GetEndpoint()
+			peer.PersistentKeepalive = keepalive
 			peers = append(peers, peer)
 		}
 	}
 
 	return peers, nil
-}
\ No newline at end of file
+}
+
+// agentConfigFile mirrors the layout of the agent's agent.yaml. Settings not
+// written here fall back to the agent's defaults when it loads the file.
+type agentConfigFile struct {
+	Controller struct {
+		URL   string `yaml:"url"`
+		Token string `yaml:"token"`
+	} `yaml:"controller"`
+	Node struct {
+		ID       string `yaml:"id"`
+		Name     string `yaml:"name"`
+		Type     string `yaml:"type"`
+		Endpoint string `yaml:"endpoint,omitempty"`
+		Port     int    `yaml:"port,omitempty"`
+	} `yaml:"node"`
+	WireGuard struct {
+		Interface string `yaml:"interface"`
+		PublicKey string `yaml:"public_key"`
+		MTU       int    `yaml:"mtu,omitempty"`
+	} `yaml:"wireguard"`
+	Monitoring struct {
+		Enabled bool `yaml:"enabled"`
+	} `yaml:"monitoring"`
+}
+
+// GenerateAgentConfig renders a ready-to-deploy agent.yaml for node. The
+// configured public URL takes precedence over controllerURL, and the
+// controller's listen address is used when neither is set.
+func (s *NodeService) GenerateAgentConfig(node *models.Node, controllerURL, token string) ([]byte, error) {
+	if s.config.Server.PublicURL != "" {
+		controllerURL = s.config.Server.PublicURL
+	}
+	if controllerURL == "" {
+		controllerURL = s.listenURL()
+	}
+
+	var file agentConfigFile
+	file.Controller.URL = controllerURL
+	file.Controller.Token = token
+	file.Node.ID = node.ID.String()
+	file.Node.Name = node.Name
+	file.Node.Type = string(node.NodeType)
+	file.Node.Endpoint = node.Endpoint
+	file.Node.Port = node.Port
+	file.WireGuard.Interface = s.config.WG.Interface
+	file.WireGuard.PublicKey = node.PublicKey
+	file.WireGuard.MTU = node.MTU
+	file.Monitoring.Enabled = true
+
+	data, err := yaml.Marshal(&file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal agent config: %w", err)
+	}
+
+	return data, nil
+}
+
+// listenURL returns the URL the controller serves on. A wildcard listen
+// address is replaced with the host's name, which agents can at least
+// attempt to resolve.
+func (s *NodeService) listenURL() string {
+	scheme := "http"
+	if s.config.Server.TLS.Enabled {
+		scheme = "https"
+	}
+	host := s.config.Server.Host
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+		if name, err := os.Hostname(); err == nil {
+			host = name
+		}
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(s.config.Server.Port)))
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidNodeStatus = errors.New("status must be one of pending, active, inactive, disabled")
+
+// NodeStatusChange records a node moved to a new status by
+// UpdateNodeStatuses.
+type NodeStatusChange struct {
+	NodeID         uuid.UUID         `json:"node_id"`
+	NodeName       string            `json:"node_name"`
+	PreviousStatus models.NodeStatus `json:"previous_status"`
+	Status         models.NodeStatus `json:"status"`
+}
+
+func validNodeStatus(status models.NodeStatus) bool {
+	switch status {
+	case models.NodeStatusPending, models.NodeStatusActive, models.NodeStatusInactive, models.NodeStatusDisabled:
+		return true
+	}
+	return false
+}
+
+// UpdateNodeStatuses moves every node in ids to status in one transaction,
+// so maintenance can disable or re-enable many nodes at once and peers never
+// see half a batch. Each change is audited. If any node does not exist, or
+// any node being activated fails its endpoint probe, nothing is changed.
+// Nodes already in status are left alone and are not among the returned
+// changes.
+func (s *NodeService) UpdateNodeStatuses(ctx context.Context, ids []uuid.UUID, status models.NodeStatus, performedBy *uuid.UUID) ([]NodeStatusChange, error) {
+	if !validNodeStatus(status) {
+		return nil, ErrInvalidNodeStatus
+	}
+
+	// Probing happens outside the transaction, which would otherwise be
+	// held open for the network round trips
+	if status == models.NodeStatusActive && s.prober != nil {
+		var activating []models.Node
+		if err := s.db.WithContext(ctx).Select("id", "name", "endpoint", "port").
+			Where("id IN ? AND status <> ?", ids, models.NodeStatusActive).
+			Find(&activating).Error; err != nil {
+			return nil, fmt.Errorf("failed to get nodes to probe: %w", err)
+		}
+		if err := s.probeEndpoints(ctx, activating); err != nil {
+			return nil, err
+		}
+	}
+
+	var nodes []models.Node
+	changes := []NodeStatusChange{}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("id", "name", "node_type", "status").Where("id IN ?", ids).Find(&nodes).Error; err != nil {
+			return fmt.Errorf("failed to get nodes: %w", err)
+		}
+		if missing := missingNodeIDs(ids, nodes); len(missing) > 0 {
+			return fmt.Errorf("%w: %s", ErrNodeNotFound, strings.Join(missing, ", "))
+		}
+
+		audit := s.auditService.WithTx(tx)
+		for i := range nodes {
+			node := &nodes[i]
+			if node.Status == status {
+				continue
+			}
+
+			if err := tx.Model(&models.Node{}).Where("id = ?", node.ID).Update("status", status).Error; err != nil {
+				return fmt.Errorf("failed to update node %s: %w", node.Name, err)
+			}
+
+			audit.LogActionWithMetadata(ctx, performedBy, models.AuditActionUpdate, "node", &node.ID,
+				fmt.Sprintf("Changed node %s status from %s to %s", node.Name, node.Status, status), "", "",
+				map[string]interface{}{
+					"previous_status": node.Status,
+					"status":          status,
+					"bulk":            true,
+				})
+
+			changes = append(changes, NodeStatusChange{
+				NodeID:         node.ID,
+				NodeName:       node.Name,
+				PreviousStatus: node.Status,
+				Status:         status,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Webhooks only go out once the batch is committed
+	for i := range nodes {
+		node := &nodes[i]
+		previous := node.Status
+		node.Status = status
+		s.notifyStatusChange(node, previous)
+	}
+
+	return changes, nil
+}
+
+// missingNodeIDs returns the IDs in ids that are not among found.
+func missingNodeIDs(ids []uuid.UUID, found []models.Node) []string {
+	exists := make(map[uuid.UUID]bool, len(found))
+	for _, node := range found {
+		exists[node.ID] = true
+	}
+
+	var missing []string
+	for _, id := range ids {
+		if !exists[id] {
+			exists[id] = true
+			missing = append(missing, id.String())
+		}
+	}
+	return missing
+}
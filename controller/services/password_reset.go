@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+var ErrPasswordPolicy = errors.New("password does not meet the password policy")
+
+// defaultPasswordResetExpiration applies when no reset token lifetime is
+// configured.
+const defaultPasswordResetExpiration = 30 * time.Minute
+
+// PasswordResetToken is a single-use token emailed to a user who forgot
+// their password. Only the token's hash is kept.
+type PasswordResetToken struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	UserID      uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	TokenHash   string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at,omitempty"`
+	RequestedIP string     `json:"requested_ip"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
+
+// SetEmailService enables emailing password reset links.
+func (s *AuthService) SetEmailService(emailService *EmailService) {
+	s.emailService = emailService
+}
+
+// SetPasswordValidator sets the policy new passwords are checked against.
+// It returns the problems found, or none for an acceptable password.
+func (s *AuthService) SetPasswordValidator(validate func(password string) []string) {
+	s.validatePassword = validate
+}
+
+// RequestPasswordReset emails a reset link to the active local user with
+// the given address. Unknown addresses are not reported, so the endpoint
+// cannot be used to discover accounts.
+func (s *AuthService) RequestPasswordReset(ctx context.Context, email, clientIP, userAgent string) error {
+	if !s.emailService.Enabled() {
+		return ErrEmailNotConfigured
+	}
+
+	var user models.User
+	if err := s.db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	// Directory users change their password in the directory
+	if !user.IsActive || user.AuthSource == models.AuthSourceLDAP {
+		return nil
+	}
+
+	token, err := generateSecretToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate reset token: %w", err)
+	}
+
+	expiration := s.config.Auth.PasswordResetExpiration
+	if expiration <= 0 {
+		expiration = defaultPasswordResetExpiration
+	}
+	reset := &PasswordResetToken{
+		ID:          uuid.New(),
+		UserID:      user.ID,
+		TokenHash:   hashSecretToken(token),
+		ExpiresAt:   time.Now().Add(expiration),
+		RequestedIP: clientIP,
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Only the newest link works
+		if err := tx.Model(&PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return fmt.Errorf("failed to invalidate reset tokens: %w", err)
+		}
+		if err := tx.Create(reset).Error; err != nil {
+			return fmt.Errorf("failed to save reset token: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.emailService.Send(user.Email, "Password reset", s.passwordResetEmail(&user, token, expiration)); err != nil {
+		return err
+	}
+
+	s.auditSvc.LogAction(ctx, &user.ID, models.AuditActionUpdate, "user", &user.ID,
+		fmt.Sprintf("Password reset requested for user %s", user.Username), clientIP, userAgent)
+
+	return nil
+}
+
+func (s *AuthService) passwordResetEmail(user *models.User, token string, expiration time.Duration) string {
+	link := token
+	if base := s.config.Auth.PasswordResetURL; base != "" {
+		separator := "?"
+		if strings.Contains(base, "?") {
+			separator = "&"
+		}
+		link = base + separator + "token=" + url.QueryEscape(token)
+	}
+
+	return fmt.Sprintf("Hello %s,\n\n"+
+		"A password reset was requested for your WireGuard SD-WAN account. Use the link below to choose a new password:\n\n"+
+		"%s\n\n"+
+		"The link expires in %s and can be used once. If you did not request a reset, you can ignore this email.\n",
+		user.Username, link, expiration)
+}
+
+// ResetPassword sets a new password using a reset token. The token is used
+// up even if another request races for it, and every refresh token of the
+// user is revoked so existing sessions end.
+func (s *AuthService) ResetPassword(ctx context.Context, token, newPassword, clientIP, userAgent string) error {
+	if s.validatePassword != nil {
+		if problems := s.validatePassword(newPassword); len(problems) > 0 {
+			return fmt.Errorf("%w: %s", ErrPasswordPolicy, strings.Join(problems, "; "))
+		}
+	}
+
+	var reset PasswordResetToken
+	if err := s.db.WithContext(ctx).Where("token_hash = ?", hashSecretToken(token)).First(&reset).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidToken
+		}
+		return fmt.Errorf("failed to get reset token: %w", err)
+	}
+	if reset.UsedAt != nil {
+		return ErrInvalidToken
+	}
+	if time.Now().After(reset.ExpiresAt) {
+		return ErrTokenExpired
+	}
+
+	user, err := s.GetUser(ctx, reset.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return ErrInvalidToken
+		}
+		return err
+	}
+	if !user.IsActive {
+		return ErrUnauthorized
+	}
+	if err := user.SetPassword(newPassword); err != nil {
+		return fmt.Errorf("failed to set new password: %w", err)
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", reset.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return fmt.Errorf("failed to use reset token: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidToken
+		}
+
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"password":             user.Password,
+			"must_change_password": false,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+		if err := tx.Model(&RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", user.ID).
+			Update("revoked_at", now).Error; err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.auditSvc.LogAction(ctx, &user.ID, models.AuditActionUpdate, "user", &user.ID,
+		fmt.Sprintf("Password reset for user %s", user.Username), clientIP, userAgent)
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+)
+
+type sentEmail struct {
+	addr string
+	to   []string
+	msg  string
+}
+
+var resetTokenPattern = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+func newPasswordResetTestService(t *testing.T) (*AuthService, *[]sentEmail) {
+	t.Helper()
+
+	service := newRefreshTestService(t)
+	if err := service.db.AutoMigrate(&PasswordResetToken{}); err != nil {
+		t.Fatalf("failed to migrate password_reset_tokens: %v", err)
+	}
+
+	service.config.Email = types.EmailConfig{SMTPHost: "smtp.example.com", From: "wg@example.com"}
+	service.config.Auth.PasswordResetURL = "https://wg.example.com/reset"
+
+	var sent []sentEmail
+	emailService := NewEmailService(service.config)
+	emailService.sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		sent = append(sent, sentEmail{addr: addr, to: to, msg: string(msg)})
+		return nil
+	}
+	service.SetEmailService(emailService)
+
+	security := &SecurityService{securityPolicies: &SecurityPolicies{PasswordMinLength: 8, PasswordComplexity: true}}
+	service.SetPasswordValidator(security.ValidatePassword)
+
+	return service, &sent
+}
+
+func requestResetToken(t *testing.T, service *AuthService, sent *[]sentEmail) string {
+	t.Helper()
+
+	if err := service.RequestPasswordReset(context.Background(), "alice@example.com", "10.0.0.1", "test"); err != nil {
+		t.Fatalf("RequestPasswordReset failed: %v", err)
+	}
+	if len(*sent) == 0 {
+		t.Fatal("expected a reset email to be sent")
+	}
+	match := resetTokenPattern.FindStringSubmatch((*sent)[len(*sent)-1].msg)
+	if match == nil {
+		t.Fatalf("expected a reset link in the email: %s", (*sent)[len(*sent)-1].msg)
+	}
+	return match[1]
+}
+
+func TestPasswordResetIssuesToken(t *testing.T) {
+	service, sent := newPasswordResetTestService(t)
+	token := requestResetToken(t, service, sent)
+
+	email := (*sent)[0]
+	if email.addr != "smtp.example.com:587" || len(email.to) != 1 || email.to[0] != "alice@example.com" {
+		t.Errorf("unexpected delivery: %s to %v", email.addr, email.to)
+	}
+
+	var stored PasswordResetToken
+	if err := service.db.First(&stored).Error; err != nil {
+		t.Fatalf("failed to load reset token: %v", err)
+	}
+	if stored.TokenHash != hashSecretToken(token) {
+		t.Errorf("expected only the token hash to be stored")
+	}
+	if remaining := time.Until(stored.ExpiresAt); remaining <= 0 || remaining > defaultPasswordResetExpiration {
+		t.Errorf("expected the token to expire within %s, got %s", defaultPasswordResetExpiration, remaining)
+	}
+
+	// Unknown addresses look the same to the caller but send nothing
+	if err := service.RequestPasswordReset(context.Background(), "nobody@example.com", "10.0.0.1", "test"); err != nil {
+		t.Errorf("expected no error for an unknown address, got %v", err)
+	}
+	if len(*sent) != 1 {
+		t.Errorf("expected no email for an unknown address, got %d emails", len(*sent))
+	}
+}
+
+func TestPasswordResetSetsNewPassword(t *testing.T) {
+	service, sent := newPasswordResetTestService(t)
+	ctx := context.Background()
+	login := loginForRefresh(t, service)
+	token := requestResetToken(t, service, sent)
+
+	if err := service.ResetPassword(ctx, token, "short", "10.0.0.1", "test"); !errors.Is(err, ErrPasswordPolicy) {
+		t.Fatalf("expected ErrPasswordPolicy for a weak password, got %v", err)
+	}
+	if err := service.ResetPassword(ctx, token, "Battery-Staple-9", "10.0.0.1", "test"); err != nil {
+		t.Fatalf("ResetPassword failed: %v", err)
+	}
+
+	if _, err := service.Login(ctx, LoginRequest{Username: "alice", Password: "correct-horse"}, "", ""); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("expected the old password to stop working, got %v", err)
+	}
+	if _, err := service.Login(ctx, LoginRequest{Username: "alice", Password: "Battery-Staple-9"}, "", ""); err != nil {
+		t.Errorf("expected the new password to work: %v", err)
+	}
+	// Sessions from before the reset are ended
+	if _, err := service.RefreshToken(ctx, login.RefreshToken, "10.0.0.1", "test"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected existing refresh tokens to be revoked, got %v", err)
+	}
+}
+
+func TestPasswordResetTokenExpired(t *testing.T) {
+	service, sent := newPasswordResetTestService(t)
+	token := requestResetToken(t, service, sent)
+
+	service.db.Model(&PasswordResetToken{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Minute))
+
+	if err := service.ResetPassword(context.Background(), token, "Battery-Staple-9", "10.0.0.1", "test"); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestPasswordResetTokenSingleUse(t *testing.T) {
+	service, sent := newPasswordResetTestService(t)
+	ctx := context.Background()
+
+	first := requestResetToken(t, service, sent)
+	second := requestResetToken(t, service, sent)
+
+	// A newer request supersedes the earlier link
+	if err := service.ResetPassword(ctx, first, "Battery-Staple-9", "10.0.0.1", "test"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for a superseded token, got %v", err)
+	}
+	if err := service.ResetPassword(ctx, second, "Battery-Staple-9", "10.0.0.1", "test"); err != nil {
+		t.Fatalf("ResetPassword failed: %v", err)
+	}
+	if err := service.ResetPassword(ctx, second, "Another-Pass-7", "10.0.0.1", "test"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for a reused token, got %v", err)
+	}
+	if err := service.ResetPassword(ctx, "unknown", "Another-Pass-7", "10.0.0.1", "test"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for an unknown token, got %v", err)
+	}
+
+	var user models.User
+	service.db.Where("username = ?", "alice").First(&user)
+	if !user.CheckPassword("Battery-Staple-9") {
+		t.Errorf("expected the reused token not to change the password")
+	}
+}
+
+func TestPasswordResetRequiresEmail(t *testing.T) {
+	service := newRefreshTestService(t)
+
+	if err := service.RequestPasswordReset(context.Background(), "alice@example.com", "", ""); !errors.Is(err, ErrEmailNotConfigured) {
+		t.Errorf("expected ErrEmailNotConfigured, got %v", err)
+	}
+}
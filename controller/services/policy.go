@@ -0,0 +1,451 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPolicyNotFound = errors.New("policy not found")
+	ErrInvalidPolicy  = errors.New("invalid policy")
+)
+
+var policyProtocols = map[string]bool{
+	"":     true,
+	"any":  true,
+	"tcp":  true,
+	"udp":  true,
+	"icmp": true,
+}
+
+// PolicyRequest creates or replaces a policy. Each side of the policy
+// selects traffic by at most one of a node, a node type (hub or spoke), a
+// named node group or a CIDR; a side with none of them matches everything.
+type PolicyRequest struct {
+	Name               string              `json:"name" binding:"required"`
+	Description        string              `json:"description"`
+	SourceNodeID       *uuid.UUID          `json:"source_node_id"`
+	SourceGroup        models.NodeType     `json:"source_group"`
+	SourceGroupID      *uuid.UUID          `json:"source_group_id"`
+	SourceCIDR         string              `json:"source_cidr"`
+	DestinationNodeID  *uuid.UUID          `json:"destination_node_id"`
+	DestinationGroup   models.NodeType     `json:"destination_group"`
+	DestinationGroupID *uuid.UUID          `json:"destination_group_id"`
+	DestinationCIDR    string              `json:"destination_cidr"`
+	Protocol           string              `json:"protocol"`
+	Port               *int                `json:"port"`
+	Action             models.PolicyAction `json:"action" binding:"required"`
+	Priority           *int                `json:"priority"`
+	Enabled            *bool               `json:"enabled"`
+}
+
+type PolicyService struct {
+	db           *gorm.DB
+	auditService *AuditService
+}
+
+func NewPolicyService(db *gorm.DB, auditService *AuditService) *PolicyService {
+	return &PolicyService{
+		db:           db,
+		auditService: auditService,
+	}
+}
+
+func (s *PolicyService) CreatePolicy(ctx context.Context, req *PolicyRequest, performedBy *uuid.UUID) (*models.Policy, error) {
+	policy := &models.Policy{Priority: 100, Enabled: true}
+	applyPolicyRequest(policy, req)
+
+	if err := s.validatePolicy(policy); err != nil {
+		return nil, err
+	}
+
+	// Create leaves out false, which the column defaults to true
+	enabled := policy.Enabled
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(policy).Error; err != nil {
+			return err
+		}
+		if !enabled {
+			return tx.Model(policy).Update("enabled", false).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create policy: %w", err)
+	}
+
+	s.auditService.LogAction(ctx, performedBy, models.AuditActionCreate, "policy", &policy.ID,
+		fmt.Sprintf("Policy %s created", policy.Name), "", "")
+
+	return policy, nil
+}
+
+// GetPolicies returns the policies whose name or description contains
+// search, or every policy for an empty search, in evaluation order.
+func (s *PolicyService) GetPolicies(ctx context.Context, search string) ([]models.Policy, error) {
+	var policies []models.Policy
+	if err := matchNameOrDescription(s.db, search).Order("priority, created_at").Find(&policies).Error; err != nil {
+		return nil, fmt.Errorf("failed to get policies: %w", err)
+	}
+	return policies, nil
+}
+
+func (s *PolicyService) GetPolicy(ctx context.Context, id uuid.UUID) (*models.Policy, error) {
+	var policy models.Policy
+	if err := s.db.Where("id = ?", id).First(&policy).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPolicyNotFound
+		}
+		return nil, fmt.Errorf("failed to get policy: %w", err)
+	}
+	return &policy, nil
+}
+
+func (s *PolicyService) UpdatePolicy(ctx context.Context, id uuid.UUID, req *PolicyRequest, performedBy *uuid.UUID) (*models.Policy, error) {
+	policy, err := s.GetPolicy(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	applyPolicyRequest(policy, req)
+
+	if err := s.validatePolicy(policy); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Save(policy).Error; err != nil {
+		return nil, fmt.Errorf("failed to update policy: %w", err)
+	}
+
+	s.auditService.LogAction(ctx, performedBy, models.AuditActionUpdate, "policy", &policy.ID,
+		fmt.Sprintf("Policy %s updated", policy.Name), "", "")
+
+	return policy, nil
+}
+
+func (s *PolicyService) DeletePolicy(ctx context.Context, id uuid.UUID, performedBy *uuid.UUID) error {
+	policy, err := s.GetPolicy(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.Delete(&models.Policy{}, "id = ?", id).Error; err != nil {
+		return fmt.Errorf("failed to delete policy: %w", err)
+	}
+
+	s.auditService.LogAction(ctx, performedBy, models.AuditActionDelete, "policy", &policy.ID,
+		fmt.Sprintf("Policy %s deleted", policy.Name), "", "")
+
+	return nil
+}
+
+func applyPolicyRequest(policy *models.Policy, req *PolicyRequest) {
+	policy.Name = req.Name
+	policy.Description = req.Description
+	policy.SourceNodeID = req.SourceNodeID
+	policy.SourceGroup = req.SourceGroup
+	policy.SourceGroupID = req.SourceGroupID
+	policy.SourceCIDR = req.SourceCIDR
+	policy.DestinationNodeID = req.DestinationNodeID
+	policy.DestinationGroup = req.DestinationGroup
+	policy.DestinationGroupID = req.DestinationGroupID
+	policy.DestinationCIDR = req.DestinationCIDR
+	policy.Protocol = strings.ToLower(req.Protocol)
+	policy.Port = req.Port
+	policy.Action = req.Action
+	if req.Priority != nil {
+		policy.Priority = *req.Priority
+	}
+	if req.Enabled != nil {
+		policy.Enabled = *req.Enabled
+	}
+}
+
+func (s *PolicyService) validatePolicy(policy *models.Policy) error {
+	if !policy.IsAllow() && !policy.IsDeny() {
+		return fmt.Errorf("%w: action must be allow or deny", ErrInvalidPolicy)
+	}
+	if !policyProtocols[policy.Protocol] {
+		return fmt.Errorf("%w: unknown protocol %q", ErrInvalidPolicy, policy.Protocol)
+	}
+	if policy.Port != nil && (*policy.Port < 1 || *policy.Port > 65535) {
+		return fmt.Errorf("%w: port must be between 1 and 65535", ErrInvalidPolicy)
+	}
+	// Enforcement leaves denied CIDRs out of AllowedIPs, which can't
+	// express a protocol or port
+	if policy.FiltersTraffic() {
+		return fmt.Errorf("%w: protocol and port filters can't be enforced; use protocol any without a port", ErrInvalidPolicy)
+	}
+
+	sides := []struct {
+		name    string
+		nodeID  *uuid.UUID
+		group   models.NodeType
+		groupID *uuid.UUID
+		cidr    string
+	}{
+		{"source", policy.SourceNodeID, policy.SourceGroup, policy.SourceGroupID, policy.SourceCIDR},
+		{"destination", policy.DestinationNodeID, policy.DestinationGroup, policy.DestinationGroupID, policy.DestinationCIDR},
+	}
+	for _, side := range sides {
+		selectors := 0
+		if side.nodeID != nil {
+			selectors++
+			var count int64
+			if err := s.db.Model(&models.Node{}).Where("id = ?", *side.nodeID).Count(&count).Error; err != nil {
+				return fmt.Errorf("failed to get node: %w", err)
+			}
+			if count == 0 {
+				return fmt.Errorf("%w: %s node not found", ErrInvalidPolicy, side.name)
+			}
+		}
+		if side.group != "" {
+			selectors++
+			if side.group != models.NodeTypeHub && side.group != models.NodeTypeSpoke {
+				return fmt.Errorf("%w: %s group must be hub or spoke", ErrInvalidPolicy, side.name)
+			}
+		}
+		if side.groupID != nil {
+			selectors++
+			var count int64
+			if err := s.db.Model(&models.NodeGroup{}).Where("id = ?", *side.groupID).Count(&count).Error; err != nil {
+				return fmt.Errorf("failed to get node group: %w", err)
+			}
+			if count == 0 {
+				return fmt.Errorf("%w: %s node group not found", ErrInvalidPolicy, side.name)
+			}
+		}
+		if side.cidr != "" {
+			selectors++
+			if _, _, err := net.ParseCIDR(side.cidr); err != nil {
+				return fmt.Errorf("%w: invalid %s CIDR %q", ErrInvalidPolicy, side.name, side.cidr)
+			}
+		}
+		if selectors > 1 {
+			return fmt.Errorf("%w: %s may select a node, a group or a CIDR, not several", ErrInvalidPolicy, side.name)
+		}
+	}
+
+	return nil
+}
+
+// peerPolicies decides which of the destinations reachable through a peer a
+// node may send to. WireGuard only filters by address, so the decision is
+// made per CIDR and applied by leaving denied CIDRs out of AllowedIPs.
+// Policies limited to a protocol or port cannot be expressed that way;
+// validatePolicy refuses them, and any that predate that check are not
+// considered.
+type peerPolicies struct {
+	policies []models.Policy
+	// members holds the nodes of every node group a policy selects
+	members map[uuid.UUID]map[uuid.UUID]bool
+}
+
+// loadPeerPolicies returns the enabled address-level policies in evaluation
+// order: lowest priority value first.
+func loadPeerPolicies(db *gorm.DB) (*peerPolicies, error) {
+	var policies []models.Policy
+	if err := db.Where("enabled = ?", true).Order("priority, created_at").Find(&policies).Error; err != nil {
+		return nil, fmt.Errorf("failed to get policies: %w", err)
+	}
+
+	result := &peerPolicies{}
+	var groupIDs []uuid.UUID
+	for _, policy := range policies {
+		if policy.FiltersTraffic() {
+			continue
+		}
+		result.policies = append(result.policies, policy)
+		for _, groupID := range []*uuid.UUID{policy.SourceGroupID, policy.DestinationGroupID} {
+			if groupID != nil {
+				groupIDs = append(groupIDs, *groupID)
+			}
+		}
+	}
+
+	members, err := groupMembers(db, groupIDs)
+	if err != nil {
+		return nil, err
+	}
+	result.members = members
+	return result, nil
+}
+
+// allows reports whether source may reach cidr, an address or subnet of
+// destination. The first matching policy decides; traffic no policy matches
+// is allowed.
+func (p *peerPolicies) allows(source, destination *models.Node, cidr string) bool {
+	for i := range p.policies {
+		policy := &p.policies[i]
+		if p.matchesSource(policy, source) && p.matchesDestination(policy, destination, cidr) {
+			return policy.IsAllow()
+		}
+	}
+	return true
+}
+
+// filter returns the CIDRs of destination that source may reach.
+func (p *peerPolicies) filter(source, destination *models.Node, cidrs []string) []string {
+	allowed := make([]string, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if p.allows(source, destination, cidr) {
+			allowed = append(allowed, cidr)
+		}
+	}
+	return allowed
+}
+
+// peer returns destination as a peer of source, carrying address and the
+// routes behind it that source may reach. A denied address leaves only the
+// routes; ok is false when nothing is left, as WireGuard would drop every
+// packet to or from a peer without AllowedIPs.
+func (p *peerPolicies) peer(source, destination *models.Node, address string) (peer types.WGPeer, ok bool) {
+	peer = types.WGPeer{
+		PublicKey: destination.PublicKey,
+		Routes:    p.filter(source, destination, destination.Routes),
+	}
+	if p.allows(source, destination, hostCIDR(ipKey(destination.AllocatedIP))) {
+		peer.AllowedIPs = []string{address}
+	} else {
+		peer.AllowedIPs = peer.Routes
+	}
+	return peer, len(peer.AllowedIPs) > 0
+}
+
+// deniedCIDRs returns the CIDRs that filter removed from cidrs.
+func deniedCIDRs(cidrs, allowed []string) []string {
+	kept := make(map[string]bool, len(allowed))
+	for _, cidr := range allowed {
+		kept[cidr] = true
+	}
+	var denied []string
+	for _, cidr := range cidrs {
+		if !kept[cidr] {
+			denied = append(denied, cidr)
+		}
+	}
+	return denied
+}
+
+func (p *peerPolicies) matchesSource(policy *models.Policy, node *models.Node) bool {
+	switch {
+	case policy.SourceNodeID != nil:
+		return *policy.SourceNodeID == node.ID
+	case policy.SourceGroup != "":
+		return policy.SourceGroup == node.NodeType
+	case policy.SourceGroupID != nil:
+		return p.members[*policy.SourceGroupID][node.ID]
+	case policy.SourceCIDR != "":
+		_, network, err := net.ParseCIDR(policy.SourceCIDR)
+		ip := net.ParseIP(ipKey(node.AllocatedIP))
+		return err == nil && ip != nil && network.Contains(ip)
+	default:
+		return true
+	}
+}
+
+// matchesDestination matches a CIDR of node against the policy. A CIDR only
+// matches a policy CIDR that covers all of it, since part of a subnet cannot
+// be left out of AllowedIPs.
+func (p *peerPolicies) matchesDestination(policy *models.Policy, node *models.Node, cidr string) bool {
+	switch {
+	case policy.DestinationNodeID != nil:
+		return *policy.DestinationNodeID == node.ID
+	case policy.DestinationGroup != "":
+		return policy.DestinationGroup == node.NodeType
+	case policy.DestinationGroupID != nil:
+		return p.members[*policy.DestinationGroupID][node.ID]
+	case policy.DestinationCIDR != "":
+		_, network, err := net.ParseCIDR(policy.DestinationCIDR)
+		if err != nil {
+			return false
+		}
+		_, target, err := net.ParseCIDR(hostCIDR(cidr))
+		if err != nil {
+			return false
+		}
+		policyOnes, _ := network.Mask.Size()
+		targetOnes, _ := target.Mask.Size()
+		return network.Contains(target.IP) && targetOnes >= policyOnes
+	default:
+		return true
+	}
+}
+
+// hostCIDR turns a bare address into a single-host CIDR.
+func hostCIDR(address string) string {
+	if strings.Contains(address, "/") {
+		return address
+	}
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		return address + "/128"
+	}
+	return address + "/32"
+}
+
+// excludeCIDRs returns the prefixes that cover all of IPv4 except the
+// excluded CIDRs, so a peer that carries everything can still be kept from
+// a few destinations. IPv6 and malformed CIDRs are ignored.
+func excludeCIDRs(excluded []string) []string {
+	remaining := []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")}
+	for _, cidr := range excluded {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil || !prefix.Addr().Is4() {
+			continue
+		}
+		prefix = prefix.Masked()
+
+		var next []netip.Prefix
+		for _, p := range remaining {
+			switch {
+			case prefix.Bits() <= p.Bits() && prefix.Contains(p.Addr()):
+				// Excluded entirely
+			case p.Bits() < prefix.Bits() && p.Contains(prefix.Addr()):
+				next = append(next, splitAround(p, prefix)...)
+			default:
+				next = append(next, p)
+			}
+		}
+		remaining = next
+	}
+
+	sort.Slice(remaining, func(i, j int) bool {
+		return remaining[i].Addr().Less(remaining[j].Addr())
+	})
+	result := make([]string, len(remaining))
+	for i, p := range remaining {
+		result[i] = p.String()
+	}
+	return result
+}
+
+// splitAround halves p down to inner, which p contains, and returns the
+// halves that don't hold inner.
+func splitAround(p, inner netip.Prefix) []netip.Prefix {
+	var halves []netip.Prefix
+	for p.Bits() < inner.Bits() {
+		bit := p.Bits()
+		addr := p.Addr().As4()
+		addr[bit/8] |= 0x80 >> (bit % 8)
+		low := netip.PrefixFrom(p.Addr(), bit+1)
+		high := netip.PrefixFrom(netip.AddrFrom4(addr), bit+1)
+		if low.Contains(inner.Addr()) {
+			halves = append(halves, high)
+			p = low
+		} else {
+			halves = append(halves, low)
+			p = high
+		}
+	}
+	return halves
+}
@@ -0,0 +1,403 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+)
+
+func TestPeerPoliciesFilterRoutes(t *testing.T) {
+	source := &models.Node{ID: uuid.New(), NodeType: models.NodeTypeSpoke, AllocatedIP: "10.100.1.2/16"}
+	destination := &models.Node{ID: uuid.New(), NodeType: models.NodeTypeSpoke, AllocatedIP: "10.100.1.3/16"}
+	routes := []string{"192.168.2.0/24", "192.168.3.0/24", "172.16.0.0/12"}
+
+	policies := &peerPolicies{policies: []models.Policy{
+		{Action: models.PolicyActionAllow, DestinationCIDR: "192.168.3.0/24"},
+		{Action: models.PolicyActionDeny, SourceGroup: models.NodeTypeSpoke, DestinationCIDR: "192.168.0.0/16"},
+		// Covers only part of 172.16.0.0/12, which cannot be split
+		{Action: models.PolicyActionDeny, DestinationCIDR: "172.16.5.0/24"},
+	}}
+
+	allowed := policies.filter(source, destination, routes)
+	if len(allowed) != 2 || allowed[0] != "192.168.3.0/24" || allowed[1] != "172.16.0.0/12" {
+		t.Errorf("expected the denied subnet to be removed, got %v", allowed)
+	}
+
+	hub := &models.Node{ID: uuid.New(), NodeType: models.NodeTypeHub, AllocatedIP: "10.100.0.1/16"}
+	if allowed := policies.filter(hub, destination, routes); len(allowed) != len(routes) {
+		t.Errorf("expected a policy for spokes not to apply to a hub, got %v", allowed)
+	}
+
+	bySource := &peerPolicies{policies: []models.Policy{{Action: models.PolicyActionDeny, SourceCIDR: "10.100.1.0/24", DestinationNodeID: &destination.ID}}}
+	if bySource.allows(source, destination, "10.100.1.3/32") {
+		t.Error("expected a source CIDR to match the node's address")
+	}
+	if !bySource.allows(hub, destination, "10.100.1.3/32") {
+		t.Error("expected a source CIDR not to match a node outside it")
+	}
+}
+
+func TestGetNodeConfigAppliesDenyPolicy(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	hubID, spokeID := newRotationTopology(t, db)
+	otherID := insertRotationTestNode(t, db, "spoke-2", "spoke", "spoke-2-key", "10.100.1.3/16", time.Now())
+	if err := db.Exec("INSERT INTO topology (id, hub_id, spoke_id) VALUES (?, ?, ?)", uuid.New(), hubID, otherID).Error; err != nil {
+		t.Fatalf("failed to link spoke to hub: %v", err)
+	}
+	ctx := context.Background()
+
+	config, err := service.GetNodeConfig(ctx, spokeID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if allowed := config.Peers[0].AllowedIPs; len(allowed) != 1 || allowed[0] != "0.0.0.0/0" {
+		t.Fatalf("expected everything to go through the hub without policies, got %v", allowed)
+	}
+
+	policyService := NewPolicyService(db, NewAuditService(db))
+	if _, err := policyService.CreatePolicy(ctx, &PolicyRequest{
+		Name:              "isolate spoke-2",
+		SourceNodeID:      &spokeID,
+		DestinationNodeID: &otherID,
+		Action:            models.PolicyActionDeny,
+	}, nil); err != nil {
+		t.Fatalf("CreatePolicy failed: %v", err)
+	}
+
+	config, err = service.GetNodeConfig(ctx, spokeID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	// Only spoke-2 is cut out of what goes through the hub
+	allowed := config.Peers[0].AllowedIPs
+	if routedThrough(allowed, "10.100.1.3") {
+		t.Errorf("expected spoke-2 to be unreachable, got %v", allowed)
+	}
+	for _, ip := range []string{"10.100.0.1", "10.100.1.4", "10.100.200.1", "8.8.8.8"} {
+		if !routedThrough(allowed, ip) {
+			t.Errorf("expected %s to stay reachable through the hub, got %v", ip, allowed)
+		}
+	}
+
+	// The policy is scoped to spoke-1; the hub still reaches both spokes
+	hubConfig, err := service.GetNodeConfig(ctx, hubID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	for _, peer := range hubConfig.Peers {
+		if len(peer.AllowedIPs) != 1 {
+			t.Errorf("expected the hub to keep every spoke, got %v for %s", peer.AllowedIPs, peer.PublicKey)
+		}
+	}
+}
+
+// routedThrough reports whether ip falls inside one of cidrs.
+func routedThrough(cidrs []string, ip string) bool {
+	addr := netip.MustParseAddr(ip)
+	for _, cidr := range cidrs {
+		if netip.MustParsePrefix(cidr).Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExcludeCIDRs(t *testing.T) {
+	if all := excludeCIDRs(nil); len(all) != 1 || all[0] != "0.0.0.0/0" {
+		t.Errorf("expected everything without exclusions, got %v", all)
+	}
+
+	remaining := excludeCIDRs([]string{"10.100.1.3/32", "192.168.0.0/16", "192.168.5.0/24", "2001:db8::/32"})
+	for _, ip := range []string{"10.100.1.3", "192.168.0.1", "192.168.5.9"} {
+		if routedThrough(remaining, ip) {
+			t.Errorf("expected %s to be excluded, got %v", ip, remaining)
+		}
+	}
+	for _, ip := range []string{"0.0.0.0", "10.100.1.2", "10.100.1.4", "192.167.255.255", "192.169.0.0", "255.255.255.255"} {
+		if !routedThrough(remaining, ip) {
+			t.Errorf("expected %s to remain, got %v", ip, remaining)
+		}
+	}
+
+	// The remaining prefixes don't overlap
+	for i := range remaining {
+		for j := i + 1; j < len(remaining); j++ {
+			if netip.MustParsePrefix(remaining[i]).Overlaps(netip.MustParsePrefix(remaining[j])) {
+				t.Errorf("expected disjoint prefixes, %s overlaps %s", remaining[i], remaining[j])
+			}
+		}
+	}
+
+	if none := excludeCIDRs([]string{"0.0.0.0/1", "128.0.0.0/1"}); len(none) != 0 {
+		t.Errorf("expected nothing left, got %v", none)
+	}
+}
+
+func TestGetNodeConfigDenyKeepsFullTunnel(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	hubID, spokeID := newRotationTopology(t, db)
+	ctx := context.Background()
+
+	// A full-tunnel spoke denied the hub itself still sends the internet
+	// through it
+	policyService := NewPolicyService(db, NewAuditService(db))
+	if _, err := policyService.CreatePolicy(ctx, &PolicyRequest{
+		Name:              "no hub admin",
+		SourceNodeID:      &spokeID,
+		DestinationNodeID: &hubID,
+		Action:            models.PolicyActionDeny,
+	}, nil); err != nil {
+		t.Fatalf("CreatePolicy failed: %v", err)
+	}
+
+	config, err := service.GetNodeConfig(ctx, spokeID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if len(config.Peers) != 1 {
+		t.Fatalf("expected the hub peer to stay, got %d peers", len(config.Peers))
+	}
+	allowed := config.Peers[0].AllowedIPs
+	if len(allowed) == 0 || routedThrough(allowed, "10.100.0.1") {
+		t.Errorf("expected the hub address to be cut out, got %v", allowed)
+	}
+	for _, ip := range []string{"1.1.1.1", "8.8.8.8", "203.0.113.7", "10.100.0.2"} {
+		if !routedThrough(allowed, ip) {
+			t.Errorf("expected %s to stay in the full tunnel, got %v", ip, allowed)
+		}
+	}
+
+	// The hub keeps the spoke: the policy is for traffic from the spoke
+	hubConfig, err := service.GetNodeConfig(ctx, hubID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	if len(hubConfig.Peers) != 1 || len(hubConfig.Peers[0].AllowedIPs) == 0 {
+		t.Errorf("expected the hub to keep its spoke, got %+v", hubConfig.Peers)
+	}
+}
+
+func TestGetNodeConfigDenyKeepsCrossHubSpokes(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	hubID, spokeID := newRotationTopology(t, db)
+	siblingID := insertRotationTestNode(t, db, "spoke-2", "spoke", "spoke-2-key", "10.100.1.3/16", time.Now())
+	otherHubID := insertRotationTestNode(t, db, "hub-2", "hub", "hub-2-key", "10.100.0.2/16", time.Now())
+	remoteID := insertRotationTestNode(t, db, "spoke-3", "spoke", "spoke-3-key", "10.100.2.5/16", time.Now())
+	for _, link := range [][2]uuid.UUID{{hubID, siblingID}, {otherHubID, remoteID}} {
+		if err := db.Exec("INSERT INTO topology (id, hub_id, spoke_id) VALUES (?, ?, ?)", uuid.New(), link[0], link[1]).Error; err != nil {
+			t.Fatalf("failed to link spoke to hub: %v", err)
+		}
+	}
+	ctx := context.Background()
+
+	policyService := NewPolicyService(db, NewAuditService(db))
+	if _, err := policyService.CreatePolicy(ctx, &PolicyRequest{
+		Name:              "isolate spoke-2",
+		SourceNodeID:      &spokeID,
+		DestinationNodeID: &siblingID,
+		Action:            models.PolicyActionDeny,
+	}, nil); err != nil {
+		t.Fatalf("CreatePolicy failed: %v", err)
+	}
+
+	config, err := service.GetNodeConfig(ctx, spokeID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	allowed := config.Peers[0].AllowedIPs
+	if routedThrough(allowed, "10.100.1.3") {
+		t.Errorf("expected spoke-2 to be unreachable, got %v", allowed)
+	}
+	// Spokes and hubs elsewhere are only reached through this hub
+	for _, ip := range []string{"10.100.0.2", "10.100.2.5"} {
+		if !routedThrough(allowed, ip) {
+			t.Errorf("expected %s on the other hub to stay reachable, got %v", ip, allowed)
+		}
+	}
+}
+
+func TestGetNodeConfigOmitsFullyDeniedPeers(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	hubID, spokeID := newRotationTopology(t, db)
+	ctx := context.Background()
+
+	policyService := NewPolicyService(db, NewAuditService(db))
+	if _, err := policyService.CreatePolicy(ctx, &PolicyRequest{
+		Name:              "hub never calls spoke-1",
+		SourceNodeID:      &hubID,
+		DestinationNodeID: &spokeID,
+		Action:            models.PolicyActionDeny,
+	}, nil); err != nil {
+		t.Fatalf("CreatePolicy failed: %v", err)
+	}
+
+	config, err := service.GetNodeConfig(ctx, hubID)
+	if err != nil {
+		t.Fatalf("GetNodeConfig failed: %v", err)
+	}
+	for _, peer := range config.Peers {
+		if len(peer.AllowedIPsWithRoutes()) == 0 {
+			t.Errorf("expected no peer without AllowedIPs, got %+v", peer)
+		}
+	}
+	if len(config.Peers) != 0 {
+		t.Errorf("expected the denied spoke to be left out, got %d peers", len(config.Peers))
+	}
+}
+
+func TestPolicyServiceValidation(t *testing.T) {
+	_, db := newKeyRotationTestService(t, 0)
+	_, spokeID := newRotationTopology(t, db)
+	policyService := NewPolicyService(db, NewAuditService(db))
+	ctx := context.Background()
+	port := 70000
+	missing := uuid.New()
+
+	invalid := []PolicyRequest{
+		{Name: "bad action", Action: "reject"},
+		{Name: "bad protocol", Action: models.PolicyActionDeny, Protocol: "gre"},
+		{Name: "bad port", Action: models.PolicyActionDeny, Port: &port},
+		{Name: "bad group", Action: models.PolicyActionDeny, SourceGroup: "relay"},
+		{Name: "bad cidr", Action: models.PolicyActionDeny, DestinationCIDR: "192.168.1.0"},
+		{Name: "unknown node", Action: models.PolicyActionDeny, DestinationNodeID: &missing},
+		{Name: "two selectors", Action: models.PolicyActionDeny, SourceNodeID: &spokeID, SourceGroup: models.NodeTypeSpoke},
+	}
+	for _, req := range invalid {
+		if _, err := policyService.CreatePolicy(ctx, &req, nil); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("%s: expected ErrInvalidPolicy, got %v", req.Name, err)
+		}
+	}
+
+	policy, err := policyService.CreatePolicy(ctx, &PolicyRequest{Name: "deny lan", Action: models.PolicyActionDeny, DestinationCIDR: "192.168.0.0/16"}, nil)
+	if err != nil {
+		t.Fatalf("CreatePolicy failed: %v", err)
+	}
+	if policy.Priority != 100 || !policy.Enabled {
+		t.Errorf("expected default priority and enabled, got %d %v", policy.Priority, policy.Enabled)
+	}
+
+	disabled := false
+	if _, err := policyService.UpdatePolicy(ctx, policy.ID, &PolicyRequest{Name: "deny lan", Action: models.PolicyActionDeny, DestinationCIDR: "192.168.0.0/16", Enabled: &disabled}, nil); err != nil {
+		t.Fatalf("UpdatePolicy failed: %v", err)
+	}
+	draft, err := policyService.CreatePolicy(ctx, &PolicyRequest{Name: "draft", Action: models.PolicyActionDeny, Enabled: &disabled}, nil)
+	if err != nil {
+		t.Fatalf("CreatePolicy failed: %v", err)
+	}
+	for _, id := range []uuid.UUID{policy.ID, draft.ID} {
+		stored, err := policyService.GetPolicy(ctx, id)
+		if err != nil {
+			t.Fatalf("GetPolicy failed: %v", err)
+		}
+		if stored.Enabled {
+			t.Errorf("expected %s to be stored disabled", stored.Name)
+		}
+	}
+
+	if err := policyService.DeletePolicy(ctx, policy.ID, nil); err != nil {
+		t.Fatalf("DeletePolicy failed: %v", err)
+	}
+	if _, err := policyService.GetPolicy(ctx, policy.ID); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("expected ErrPolicyNotFound after delete, got %v", err)
+	}
+}
+
+func TestPolicyServiceRejectsPortScopedPolicies(t *testing.T) {
+	db := newImportTestDB(t)
+	policyService := NewPolicyService(db, NewAuditService(db))
+	ctx := context.Background()
+	ssh := 22
+
+	// AllowedIPs can't express a protocol or port, so these would be ignored
+	for _, req := range []PolicyRequest{
+		{Name: "deny ssh", Action: models.PolicyActionDeny, Protocol: "tcp", Port: &ssh, DestinationCIDR: "10.20.0.0/16"},
+		{Name: "deny udp", Action: models.PolicyActionDeny, Protocol: "udp", DestinationCIDR: "10.20.0.0/16"},
+		{Name: "deny port", Action: models.PolicyActionDeny, Port: &ssh, DestinationCIDR: "10.20.0.0/16"},
+	} {
+		if _, err := policyService.CreatePolicy(ctx, &req, nil); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("%s: expected ErrInvalidPolicy, got %v", req.Name, err)
+		}
+	}
+
+	policy, err := policyService.CreatePolicy(ctx, &PolicyRequest{Name: "deny lab", Action: models.PolicyActionDeny, Protocol: "any", DestinationCIDR: "10.20.0.0/16"}, nil)
+	if err != nil {
+		t.Fatalf("expected an address-level policy to be accepted: %v", err)
+	}
+	if _, err := policyService.UpdatePolicy(ctx, policy.ID, &PolicyRequest{Name: "deny lab", Action: models.PolicyActionDeny, Protocol: "tcp", Port: &ssh, DestinationCIDR: "10.20.0.0/16"}, nil); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("expected narrowing a policy to a port to be refused, got %v", err)
+	}
+
+	var count int64
+	db.Model(&models.Policy{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected only the address-level policy to be stored, got %d", count)
+	}
+	stored, err := policyService.GetPolicy(ctx, policy.ID)
+	if err != nil {
+		t.Fatalf("GetPolicy failed: %v", err)
+	}
+	if stored.FiltersTraffic() {
+		t.Errorf("expected the refused update to leave the policy unchanged, got %+v", stored)
+	}
+}
+
+func TestGetPoliciesSearchesNameAndDescription(t *testing.T) {
+	db := newImportTestDB(t)
+	policyService := NewPolicyService(db, NewAuditService(db))
+	ctx := context.Background()
+
+	for _, req := range []PolicyRequest{
+		{Name: "isolate lab", Description: "Requested in SEC-118", Action: models.PolicyActionDeny, DestinationCIDR: "192.168.50.0/24"},
+		{Name: "deny telnet", Action: models.PolicyActionDeny, DestinationCIDR: "10.20.0.0/16"},
+	} {
+		if _, err := policyService.CreatePolicy(ctx, &req, nil); err != nil {
+			t.Fatalf("CreatePolicy failed: %v", err)
+		}
+	}
+
+	for search, want := range map[string]string{"sec-118": "isolate lab", "Telnet": "deny telnet"} {
+		policies, err := policyService.GetPolicies(ctx, search)
+		if err != nil {
+			t.Fatalf("GetPolicies failed: %v", err)
+		}
+		if len(policies) != 1 || policies[0].Name != want {
+			t.Errorf("search %q: expected %s, got %+v", search, want, policies)
+		}
+	}
+}
+
+func TestPolicyDescriptionRoundTrip(t *testing.T) {
+	db := newImportTestDB(t)
+	policyService := NewPolicyService(db, NewAuditService(db))
+	ctx := context.Background()
+
+	req := PolicyRequest{Name: "isolate lab", Description: "Requested in SEC-118", Action: models.PolicyActionDeny, DestinationCIDR: "192.168.50.0/24"}
+	policy, err := policyService.CreatePolicy(ctx, &req, nil)
+	if err != nil {
+		t.Fatalf("CreatePolicy failed: %v", err)
+	}
+	stored, err := policyService.GetPolicy(ctx, policy.ID)
+	if err != nil {
+		t.Fatalf("GetPolicy failed: %v", err)
+	}
+	if stored.Description != req.Description {
+		t.Errorf("expected the created description, got %q", stored.Description)
+	}
+
+	req.Description = "Requested in SEC-121"
+	if _, err := policyService.UpdatePolicy(ctx, policy.ID, &req, nil); err != nil {
+		t.Fatalf("UpdatePolicy failed: %v", err)
+	}
+	stored, err = policyService.GetPolicy(ctx, policy.ID)
+	if err != nil {
+		t.Fatalf("GetPolicy failed: %v", err)
+	}
+	if stored.Description != req.Description {
+		t.Errorf("expected the updated description, got %q", stored.Description)
+	}
+}
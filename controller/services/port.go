@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPortRangeExhausted = errors.New("no free listen ports in the configured range")
+	ErrPortInUse          = errors.New("listen port is already allocated to another node")
+)
+
+// needsListenPort reports whether a node is reached by its peers and so
+// needs a fixed listen port. Hubs always are; spokes only when they have an
+// endpoint, since NAT-only spokes just dial out.
+func needsListenPort(nodeType models.NodeType, endpoint string) bool {
+	return nodeType == models.NodeTypeHub || endpoint != ""
+}
+
+// listenPort picks the listen port for a new node. A port the agent asked
+// for is kept unless another node was allocated it; otherwise nodes that
+// need one get the lowest free port in the
+// WG_PORT_RANGE_START..WG_PORT_RANGE_END range, and the rest get 0 so
+// WireGuard picks one. Without a configured range nothing is allocated.
+// Callers hold nodeAllocationLock on tx.
+func (s *NodeService) listenPort(ctx context.Context, tx *gorm.DB, nodeType models.NodeType, endpoint string, requested int) (int, error) {
+	if requested > 0 {
+		return requested, s.checkPortFree(tx, requested, uuid.Nil)
+	}
+
+	if !needsListenPort(nodeType, endpoint) || !s.allocatesPorts() {
+		return 0, nil
+	}
+
+	used, err := s.allocatedPorts(tx, uuid.Nil)
+	if err != nil {
+		return 0, err
+	}
+	return nextFreePort(s.config.WG.PortRangeStart, s.config.WG.PortRangeEnd, used)
+}
+
+// checkPortFree returns ErrPortInUse if a node other than nodeID was
+// allocated port from the configured range. Ports outside the range are
+// not allocated, so nodes on different hosts may share them.
+func (s *NodeService) checkPortFree(tx *gorm.DB, port int, nodeID uuid.UUID) error {
+	if !s.allocatesPorts() || port < s.config.WG.PortRangeStart || port > s.config.WG.PortRangeEnd {
+		return nil
+	}
+
+	used, err := s.allocatedPorts(tx, nodeID)
+	if err != nil {
+		return err
+	}
+	if used[port] {
+		return ErrPortInUse
+	}
+	return nil
+}
+
+// allocatedPorts returns the ports in the configured range held by nodes
+// other than except.
+func (s *NodeService) allocatedPorts(tx *gorm.DB, except uuid.UUID) (map[int]bool, error) {
+	start, end := s.config.WG.PortRangeStart, s.config.WG.PortRangeEnd
+	if start < 1 || end > 65535 || start > end {
+		return nil, fmt.Errorf("invalid port range %d-%d", start, end)
+	}
+
+	var nodes []models.Node
+	if err := tx.Select("port").Where("port BETWEEN ? AND ? AND id <> ?", start, end, except).Find(&nodes).Error; err != nil {
+		return nil, fmt.Errorf("failed to get allocated ports: %w", err)
+	}
+
+	used := make(map[int]bool, len(nodes))
+	for _, node := range nodes {
+		used[node.Port] = true
+	}
+	return used, nil
+}
+
+// allocatesPorts reports whether a listen port range is configured.
+func (s *NodeService) allocatesPorts() bool {
+	return s.config.WG.PortRangeStart != 0 || s.config.WG.PortRangeEnd != 0
+}
+
+// nextFreePort returns the first port from start to end that is not in used.
+func nextFreePort(start, end int, used map[int]bool) (int, error) {
+	for port := start; port <= end; port++ {
+		if !used[port] {
+			return port, nil
+		}
+	}
+	return 0, ErrPortRangeExhausted
+}
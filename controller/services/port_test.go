@@ -0,0 +1,121 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+func testPublicKey(seed byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{seed}, 32))
+}
+
+func TestNextFreePort(t *testing.T) {
+	port, err := nextFreePort(51820, 51823, map[int]bool{51820: true, 51822: true})
+	if err != nil || port != 51821 {
+		t.Errorf("expected 51821, got %d (%v)", port, err)
+	}
+
+	if _, err := nextFreePort(51820, 51821, map[int]bool{51820: true, 51821: true}); !errors.Is(err, ErrPortRangeExhausted) {
+		t.Errorf("expected ErrPortRangeExhausted, got %v", err)
+	}
+}
+
+func TestRegisterNodeAllocatesListenPorts(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	addRegistrationColumns(t, db)
+	service.config.WG.PortRangeStart = 51820
+	service.config.WG.PortRangeEnd = 51822
+	ctx := context.Background()
+
+	register := func(name, nodeType, endpoint string, port int, seed byte) (int, error) {
+		t.Helper()
+		node, err := service.RegisterNode(ctx, types.NodeRegistrationRequest{
+			Name:      name,
+			NodeType:  nodeType,
+			PublicKey: testPublicKey(seed),
+			Endpoint:  endpoint,
+			Port:      port,
+		})
+		if err != nil {
+			return 0, err
+		}
+		return node.Port, nil
+	}
+
+	// Hubs and reachable spokes get the lowest free port in the range
+	if port, err := register("hub-1", "hub", "", 0, 1); err != nil || port != 51820 {
+		t.Errorf("expected the first hub to get 51820, got %d (%v)", port, err)
+	}
+	if port, err := register("spoke-1", "spoke", "spoke-1.example.com", 0, 2); err != nil || port != 51821 {
+		t.Errorf("expected a spoke with an endpoint to get 51821, got %d (%v)", port, err)
+	}
+
+	// NAT-only spokes are left for WireGuard to pick
+	if port, err := register("spoke-nat", "spoke", "", 0, 10); err != nil || port != 0 {
+		t.Errorf("expected a spoke without an endpoint to get no port, got %d (%v)", port, err)
+	}
+
+	// A port the agent asks for is kept, even inside the range
+	if port, err := register("spoke-fixed", "spoke", "fixed.example.com", 51822, 11); err != nil || port != 51822 {
+		t.Errorf("expected the requested port to be kept, got %d (%v)", port, err)
+	}
+
+	// A requested port already held by another node is refused
+	if _, err := register("spoke-clash", "spoke", "clash.example.com", 51820, 13); !errors.Is(err, ErrPortInUse) {
+		t.Errorf("expected ErrPortInUse for a taken port, got %v", err)
+	}
+
+	if _, err := register("spoke-public", "spoke", "public.example.com", 0, 12); !errors.Is(err, ErrPortRangeExhausted) {
+		t.Errorf("expected ErrPortRangeExhausted once the range is used up, got %v", err)
+	}
+}
+
+func TestUpdateNodeRejectsTakenPort(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	addRegistrationColumns(t, db)
+	service.config.WG.PortRangeStart = 51820
+	service.config.WG.PortRangeEnd = 51829
+	ctx := context.Background()
+
+	hub, err := service.RegisterNode(ctx, types.NodeRegistrationRequest{Name: "hub-1", NodeType: "hub", PublicKey: testPublicKey(1)})
+	if err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+	spoke, err := service.RegisterNode(ctx, types.NodeRegistrationRequest{Name: "spoke-1", NodeType: "spoke", PublicKey: testPublicKey(2), Endpoint: "spoke-1.example.com"})
+	if err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+
+	taken := hub.Port
+	if _, err := service.UpdateNode(ctx, spoke.ID, types.NodeUpdateRequest{Port: &taken}); !errors.Is(err, ErrPortInUse) {
+		t.Errorf("expected ErrPortInUse when moving onto the hub's port, got %v", err)
+	}
+
+	// Keeping its own port is not a collision
+	own := spoke.Port
+	if _, err := service.UpdateNode(ctx, spoke.ID, types.NodeUpdateRequest{Port: &own}); err != nil {
+		t.Errorf("expected a node to keep its own port, got %v", err)
+	}
+}
+
+func TestRegisterNodeWithoutPortRange(t *testing.T) {
+	service, db := newKeyRotationTestService(t, 0)
+	addRegistrationColumns(t, db)
+
+	node, err := service.RegisterNode(context.Background(), types.NodeRegistrationRequest{
+		Name:      "hub-1",
+		NodeType:  "hub",
+		PublicKey: testPublicKey(1),
+	})
+	if err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+	if node.Port != 0 {
+		t.Errorf("expected no port without a configured range, got %d", node.Port)
+	}
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+var ErrRefreshTokenReused = errors.New("refresh token has already been used")
+
+// defaultRefreshTokenExpiration applies when no refresh token lifetime is
+// configured.
+const defaultRefreshTokenExpiration = 30 * 24 * time.Hour
+
+// RefreshToken is a stored refresh token. Only the token's hash is kept.
+// Every token minted from the same login shares a family, so reuse of a
+// rotated token or a logout ends the whole session.
+type RefreshToken struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	UserID     uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	FamilyID   uuid.UUID  `json:"family_id" gorm:"type:uuid;not null;index"`
+	TokenHash  string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	ReplacedBy *uuid.UUID `json:"replaced_by,omitempty" gorm:"type:uuid"`
+	ClientIP   string     `json:"client_ip"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// RefreshToken exchanges a refresh token for a new access token and rotates
+// the refresh token. Presenting a token that was already rotated revokes
+// every token in its family.
+func (s *AuthService) RefreshToken(ctx context.Context, refreshToken, clientIP, userAgent string) (*LoginResponse, error) {
+	db := s.db.WithContext(ctx)
+
+	var stored RefreshToken
+	if err := db.Where("token_hash = ?", hashSecretToken(refreshToken)).First(&stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	if stored.ReplacedBy != nil {
+		if err := s.revokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+			return nil, err
+		}
+		s.auditSvc.LogAction(ctx, &stored.UserID, models.AuditActionLogout, "refresh_token", &stored.FamilyID,
+			"Refresh token reused after rotation, session revoked", clientIP, userAgent)
+		return nil, ErrRefreshTokenReused
+	}
+	if stored.RevokedAt != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	user, err := s.GetUser(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, ErrUnauthorized
+	}
+
+	var newRefreshToken string
+	var refreshExpiresAt time.Time
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var next *RefreshToken
+		var err error
+		newRefreshToken, next, err = s.createRefreshToken(tx, user.ID, stored.FamilyID, clientIP, userAgent)
+		if err != nil {
+			return err
+		}
+		refreshExpiresAt = next.ExpiresAt
+
+		// Only one concurrent refresh may rotate the token
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", stored.ID).
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "replaced_by": next.ID})
+		if result.Error != nil {
+			return fmt.Errorf("failed to rotate refresh token: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	token, expiresAt, err := s.generateToken(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	return &LoginResponse{
+		Token:                 token,
+		ExpiresAt:             expiresAt,
+		RefreshToken:          newRefreshToken,
+		RefreshTokenExpiresAt: refreshExpiresAt,
+		User:                  *user,
+		MustChangePassword:    user.MustChangePassword,
+	}, nil
+}
+
+// RevokeRefreshToken ends the session a refresh token belongs to.
+func (s *AuthService) RevokeRefreshToken(ctx context.Context, refreshToken, clientIP, userAgent string) error {
+	var stored RefreshToken
+	if err := s.db.WithContext(ctx).Where("token_hash = ?", hashSecretToken(refreshToken)).First(&stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidToken
+		}
+		return fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	if err := s.revokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+		return err
+	}
+
+	s.auditSvc.LogAction(ctx, &stored.UserID, models.AuditActionLogout, "user", &stored.UserID,
+		"User logged out", clientIP, userAgent)
+
+	return nil
+}
+
+func (s *AuthService) revokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	if err := s.db.WithContext(ctx).Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// createRefreshToken stores a new refresh token and returns its plaintext,
+// which is only ever handed to the client.
+func (s *AuthService) createRefreshToken(db *gorm.DB, userID, familyID uuid.UUID, clientIP, userAgent string) (string, *RefreshToken, error) {
+	token, err := generateSecretToken()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	expiration := s.config.Auth.RefreshTokenExpiration
+	if expiration <= 0 {
+		expiration = defaultRefreshTokenExpiration
+	}
+
+	stored := &RefreshToken{
+		ID:        uuid.New(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashSecretToken(token),
+		ExpiresAt: time.Now().Add(expiration),
+		ClientIP:  clientIP,
+		UserAgent: userAgent,
+	}
+	if err := db.Create(stored).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	return token, stored, nil
+}
+
+// generateSecretToken returns a random URL-safe token for handing to a
+// client. Only its hashSecretToken digest is stored.
+func generateSecretToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func hashSecretToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
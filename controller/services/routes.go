@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+	"gorm.io/gorm"
+)
+
+// checkRoutes refuses routes for node id that overlap the tunnel subnet,
+// any node's address, or a subnet another node in tx already routes. Each
+// destination must have a single node to send it to. Callers hold
+// nodeAllocationLock on tx so two nodes can't claim a subnet at once.
+func (s *NodeService) checkRoutes(tx *gorm.DB, id uuid.UUID, routes []string) error {
+	if len(routes) == 0 {
+		return nil
+	}
+
+	var nodes []models.Node
+	if err := tx.Select("id, name, allocated_ip, routes").Find(&nodes).Error; err != nil {
+		return fmt.Errorf("failed to get node routes: %w", err)
+	}
+	return checkRouteOverlaps(routes, s.config.WG.Subnet, id, nodes)
+}
+
+// checkRouteOverlaps is checkRoutes against a given tunnel subnet and set of
+// nodes. routes must already have passed validateRoutes.
+func checkRouteOverlaps(routes []string, tunnel string, id uuid.UUID, nodes []models.Node) error {
+	type claim struct {
+		prefix netip.Prefix
+		owner  string
+	}
+	var claimed []claim
+	if prefix, err := netip.ParsePrefix(tunnel); err == nil {
+		claimed = append(claimed, claim{prefix.Masked(), "the tunnel subnet"})
+	}
+	for _, node := range nodes {
+		if addr, err := netip.ParseAddr(ipKey(node.AllocatedIP)); err == nil {
+			claimed = append(claimed, claim{netip.PrefixFrom(addr, addr.BitLen()), fmt.Sprintf("the address of node %s", node.Name)})
+		}
+		if node.ID == id {
+			continue
+		}
+		for _, route := range node.Routes {
+			if prefix, err := netip.ParsePrefix(route); err == nil {
+				claimed = append(claimed, claim{prefix.Masked(), fmt.Sprintf("a route of node %s", node.Name)})
+			}
+		}
+	}
+
+	for _, route := range routes {
+		prefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidRoute, route)
+		}
+		prefix = prefix.Masked()
+		for _, c := range claimed {
+			if prefix.Overlaps(c.prefix) {
+				return fmt.Errorf("%w: %s overlaps %s", ErrInvalidRoute, route, c.owner)
+			}
+		}
+		claimed = append(claimed, claim{prefix, "another route in the request"})
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package services
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// matchNameOrDescription narrows query to rows whose name or description
+// contains search, ignoring case. An empty search matches everything.
+func matchNameOrDescription(query *gorm.DB, search string) *gorm.DB {
+	if search == "" {
+		return query
+	}
+	pattern := "%" + strings.ToLower(search) + "%"
+	return query.Where("LOWER(name) LIKE ? OR LOWER(description) LIKE ?", pattern, pattern)
+}
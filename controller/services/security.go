@@ -8,10 +8,16 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
+	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +28,15 @@ import (
 	"gorm.io/gorm"
 )
 
+var (
+	ErrIPNotBlocked       = errors.New("IP is not blocked")
+	ErrCIDRNotAllowlisted = errors.New("CIDR is not in the allowlist")
+
+	ErrNoClientCertificate        = errors.New("no client certificate")
+	ErrUntrustedClientCertificate = errors.New("client certificate not trusted")
+	ErrNoCertificateNodeIdentity  = errors.New("client certificate does not name a node")
+)
+
 type SecurityService struct {
 	db                 *gorm.DB
 	config             *types.Config
@@ -33,17 +48,31 @@ type SecurityService struct {
 	allowedCIDRs       []*net.IPNet
 	sessionTokens      map[string]*SessionInfo
 	securityPolicies   *SecurityPolicies
+	now                func() time.Time
+	// agentCertificates holds the client certificate each agent last
+	// authenticated with, so its expiry can be watched
+	agentCertificates map[uuid.UUID]*x509.Certificate
+	monitoringService *MonitoringService
 }
 
 type LoginAttempts struct {
 	Count     int
 	LastAttempt time.Time
+	BlockedAt    time.Time
 	BlockedUntil time.Time
 }
 
+type BlockedIP struct {
+	IP             string    `json:"ip"`
+	BlockedAt      time.Time `json:"blocked_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	FailedAttempts int       `json:"failed_attempts"`
+}
+
+// RateLimitInfo tracks the requests an IP has made within the last rate
+// limit window, oldest first.
 type RateLimitInfo struct {
-	Count     int
-	ResetTime time.Time
+	Requests []time.Time
 }
 
 type SessionInfo struct {
@@ -66,11 +95,24 @@ type SecurityPolicies struct {
 	IPWhitelistOnly     bool          `json:"ip_whitelist_only"`
 	RateLimitRequests   int           `json:"rate_limit_requests"`
 	RateLimitWindow     time.Duration `json:"rate_limit_window"`
+	// UserRateLimitRequests limits each token subject, user or agent, over
+	// RateLimitWindow on top of the per-IP limit; 0 turns it off
+	UserRateLimitRequests int              `json:"user_rate_limit_requests"`
+	RouteRateLimits       []RouteRateLimit `json:"route_rate_limits"`
 	EnableCSRFProtection bool         `json:"enable_csrf_protection"`
 	EnableHTTPS         bool          `json:"enable_https"`
 	HSTSMaxAge          int           `json:"hsts_max_age"`
 }
 
+// RouteRateLimit sets a tighter limit for requests whose path starts with
+// PathPrefix. Each token subject, or IP for anonymous requests, gets its own
+// allowance per route; a zero Window uses RateLimitWindow.
+type RouteRateLimit struct {
+	PathPrefix string        `json:"path_prefix"`
+	Requests   int           `json:"requests"`
+	Window     time.Duration `json:"window"`
+}
+
 type SecurityEvent struct {
 	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	EventType   string    `json:"event_type" gorm:"not null"`
@@ -83,6 +125,47 @@ type SecurityEvent struct {
 	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
 }
 
+// SecurityPolicyRecord persists the active security policies as a single row.
+type SecurityPolicyRecord struct {
+	ID        int        `json:"id" gorm:"primaryKey"`
+	Policies  string     `json:"policies" gorm:"type:jsonb;not null"`
+	UpdatedBy *uuid.UUID `json:"updated_by" gorm:"type:uuid"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+func (SecurityPolicyRecord) TableName() string {
+	return "security_policies"
+}
+
+// AllowedCIDR persists a network that is permitted when IPWhitelistOnly is on.
+type AllowedCIDR struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CIDR      string     `json:"cidr" gorm:"column:cidr;uniqueIndex;not null"`
+	CreatedBy *uuid.UUID `json:"created_by" gorm:"type:uuid"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+func (AllowedCIDR) TableName() string {
+	return "allowed_cidrs"
+}
+
+// ParseMetadata decodes the event metadata. Rows written before metadata was
+// stored as JSON are returned under the "raw" key.
+func (e *SecurityEvent) ParseMetadata() map[string]interface{} {
+	if e.Metadata == "" {
+		return nil
+	}
+
+	var metadata map[string]interface{}
+	if err := json.Unmarshal([]byte(e.Metadata), &metadata); err != nil {
+		return map[string]interface{}{
+			"raw": e.Metadata,
+		}
+	}
+
+	return metadata
+}
+
 type SecurityReport struct {
 	Period              string                 `json:"period"`
 	TotalEvents         int64                  `json:"total_events"`
@@ -91,14 +174,46 @@ type SecurityReport struct {
 	BlockedIPs          int64                  `json:"blocked_ips"`
 	SuspiciousActivity  int64                  `json:"suspicious_activity"`
 	EventsByType        map[string]int64       `json:"events_by_type"`
-	TopAttackingIPs     []string               `json:"top_attacking_ips"`
+	TopAttackingIPs     []AttackingIP          `json:"top_attacking_ips"`
 	RecentEvents        []SecurityEvent        `json:"recent_events"`
 	Recommendations     []string               `json:"recommendations"`
+	// CertificateExpiry is the watched certificate that expires soonest
+	CertificateExpiry   *CertificateExpiry     `json:"certificate_expiry,omitempty"`
 	GeneratedAt         time.Time              `json:"generated_at"`
 }
 
+// CertificateExpiry describes the certificate that expires soonest in one
+// source: the TLS certificate file, the client CA file or an agent's client
+// certificate.
+type CertificateExpiry struct {
+	Source        string     `json:"source"`
+	Path          string     `json:"path,omitempty"`
+	NodeID        *uuid.UUID `json:"node_id,omitempty"`
+	Subject       string     `json:"subject"`
+	NotAfter      time.Time  `json:"not_after"`
+	DaysRemaining int        `json:"days_remaining"`
+}
+
+const (
+	CertificateSourceTLS      = "tls_cert"
+	CertificateSourceClientCA = "client_ca"
+	CertificateSourceAgent    = "agent"
+)
+
+// defaultCertExpiryWarning applies when TLS_CERT_EXPIRY_WARNING_DAYS is unset.
+const defaultCertExpiryWarning = 30 * 24 * time.Hour
+
+// certificateCheckInterval is how often StartCertificateExpiryChecks looks
+// at the watched certificates.
+const certificateCheckInterval = 24 * time.Hour
+
+type AttackingIP struct {
+	IP           string `json:"ip"`
+	FailedLogins int    `json:"failed_logins"`
+}
+
 func NewSecurityService(db *gorm.DB, config *types.Config, auditService *AuditService) *SecurityService {
-	return &SecurityService{
+	s := &SecurityService{
 		db:             db,
 		config:         config,
 		auditService:   auditService,
@@ -107,6 +222,8 @@ func NewSecurityService(db *gorm.DB, config *types.Config, auditService *AuditSe
 		blockedIPs:     make(map[string]time.Time),
 		allowedCIDRs:   []*net.IPNet{},
 		sessionTokens:  make(map[string]*SessionInfo),
+		now:            time.Now,
+		agentCertificates: make(map[uuid.UUID]*x509.Certificate),
 		securityPolicies: &SecurityPolicies{
 			MaxLoginAttempts:    5,
 			LoginLockoutTime:    15 * time.Minute,
@@ -118,11 +235,46 @@ func NewSecurityService(db *gorm.DB, config *types.Config, auditService *AuditSe
 			IPWhitelistOnly:     false,
 			RateLimitRequests:   100,
 			RateLimitWindow:     time.Minute,
+			UserRateLimitRequests: 100,
+			RouteRateLimits: []RouteRateLimit{
+				{PathPrefix: "/api/v1/config/export", Requests: 10},
+				{PathPrefix: "/api/v1/audit/export", Requests: 10},
+			},
 			EnableCSRFProtection: true,
 			EnableHTTPS:         true,
 			HSTSMaxAge:          31536000, // 1 year
 		},
 	}
+
+	if err := s.loadSecurityPolicies(); err != nil {
+		slog.Warn("Failed to load security policies, using defaults", "error", err)
+	}
+
+	if err := s.loadAllowedCIDRs(); err != nil {
+		slog.Warn("Failed to load allowed CIDRs", "error", err)
+	}
+
+	return s
+}
+
+// loadSecurityPolicies replaces the default policies with the persisted ones,
+// if any have been saved.
+func (s *SecurityService) loadSecurityPolicies() error {
+	var record SecurityPolicyRecord
+	if err := s.db.First(&record, 1).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to get security policies: %w", err)
+	}
+
+	policies := *s.securityPolicies
+	if err := json.Unmarshal([]byte(record.Policies), &policies); err != nil {
+		return fmt.Errorf("failed to unmarshal security policies: %w", err)
+	}
+
+	s.securityPolicies = &policies
+	return nil
 }
 
 func (s *SecurityService) RecordFailedLogin(ctx context.Context, ip, userAgent string, userID *uuid.UUID) {
@@ -135,6 +287,9 @@ func (s *SecurityService) RecordFailedLogin(ctx context.Context, ip, userAgent s
 		attempts.LastAttempt = time.Now()
 		
 		if attempts.Count >= s.securityPolicies.MaxLoginAttempts {
+			if attempts.BlockedAt.IsZero() || time.Now().After(attempts.BlockedUntil) {
+				attempts.BlockedAt = time.Now()
+			}
 			attempts.BlockedUntil = time.Now().Add(s.securityPolicies.LoginLockoutTime)
 			s.blockedIPs[ip] = attempts.BlockedUntil
 		}
@@ -166,21 +321,82 @@ func (s *SecurityService) RecordSuccessfulLogin(ctx context.Context, ip, userAge
 }
 
 func (s *SecurityService) IsIPBlocked(ip string) bool {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	_, blocked := s.BlockedUntil(ip)
+	return blocked
+}
+
+// BlockedUntil returns when the block on ip expires, and false if the IP is
+// not blocked.
+func (s *SecurityService) BlockedUntil(ip string) (time.Time, bool) {
+	// Write lock, since expired blocks are removed below
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
 	if blockedUntil, exists := s.blockedIPs[ip]; exists {
 		if time.Now().Before(blockedUntil) {
-			return true
+			return blockedUntil, true
 		}
 		// Cleanup expired blocks
 		delete(s.blockedIPs, ip)
 	}
 
-	return false
+	return time.Time{}, false
+}
+
+// GetBlockedIPs returns the IPs that are currently blocked, ordered by when
+// their block expires.
+func (s *SecurityService) GetBlockedIPs() []BlockedIP {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	blocked := make([]BlockedIP, 0, len(s.blockedIPs))
+	for ip, blockedUntil := range s.blockedIPs {
+		if now.After(blockedUntil) {
+			continue
+		}
+
+		entry := BlockedIP{
+			IP:        ip,
+			ExpiresAt: blockedUntil,
+		}
+		if attempts, exists := s.failedAttempts[ip]; exists {
+			entry.BlockedAt = attempts.BlockedAt
+			entry.FailedAttempts = attempts.Count
+		}
+
+		blocked = append(blocked, entry)
+	}
+
+	sort.Slice(blocked, func(i, j int) bool {
+		return blocked[i].ExpiresAt.Before(blocked[j].ExpiresAt)
+	})
+
+	return blocked
+}
+
+// UnblockIP manually lifts a block and resets the failed attempt counter.
+func (s *SecurityService) UnblockIP(ctx context.Context, ip string, unblockedBy uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.blockedIPs[ip]; !exists {
+		return ErrIPNotBlocked
+	}
+
+	delete(s.blockedIPs, ip)
+	delete(s.failedAttempts, ip)
+
+	s.auditService.LogAction(ctx, &unblockedBy, models.AuditActionDelete, "blocked_ip", nil,
+		fmt.Sprintf("Unblocked IP %s", ip), "", "")
+
+	return nil
 }
 
 func (s *SecurityService) IsIPAllowed(ip string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
 	if !s.securityPolicies.IPWhitelistOnly {
 		return true
 	}
@@ -199,31 +415,115 @@ func (s *SecurityService) IsIPAllowed(ip string) bool {
 	return false
 }
 
+// CheckRateLimit records a request from ip and reports whether it is within
+// the limit. The window slides with each request rather than resetting at
+// fixed intervals, so no span of RateLimitWindow ever holds more than
+// RateLimitRequests allowed requests. Rejected requests are not counted.
 func (s *SecurityService) CheckRateLimit(ip string) bool {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	now := time.Now()
-	
-	if rateInfo, exists := s.rateLimiter[ip]; exists {
-		if now.After(rateInfo.ResetTime) {
-			// Reset rate limit window
-			rateInfo.Count = 1
-			rateInfo.ResetTime = now.Add(s.securityPolicies.RateLimitWindow)
-		} else {
-			rateInfo.Count++
-			if rateInfo.Count > s.securityPolicies.RateLimitRequests {
-				return false
-			}
+	allowed, _ := s.allowRequest(ip, s.securityPolicies.RateLimitRequests, s.securityPolicies.RateLimitWindow)
+	return allowed
+}
+
+// CheckUserRateLimit records a request made with a token for subject and
+// reports whether it is within UserRateLimitRequests, and if not, when the
+// subject may retry.
+func (s *SecurityService) CheckUserRateLimit(subject string) (bool, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.securityPolicies.UserRateLimitRequests <= 0 {
+		return true, time.Time{}
+	}
+	return s.allowRequest("user:"+subject, s.securityPolicies.UserRateLimitRequests, s.securityPolicies.RateLimitWindow)
+}
+
+// CheckRouteRateLimit records a request for path from client, a token
+// subject or IP, against the route limit with the longest matching prefix.
+// It reports whether the request is within that limit, and if not, when the
+// client may retry. Paths without a route limit are always allowed.
+func (s *SecurityService) CheckRouteRateLimit(path, client string) (bool, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var route *RouteRateLimit
+	for i := range s.securityPolicies.RouteRateLimits {
+		candidate := &s.securityPolicies.RouteRateLimits[i]
+		if candidate.Requests <= 0 || !routeMatches(path, candidate.PathPrefix) {
+			continue
 		}
-	} else {
-		s.rateLimiter[ip] = &RateLimitInfo{
-			Count:     1,
-			ResetTime: now.Add(s.securityPolicies.RateLimitWindow),
+		if route == nil || len(candidate.PathPrefix) > len(route.PathPrefix) {
+			route = candidate
 		}
 	}
+	if route == nil {
+		return true, time.Time{}
+	}
+
+	window := route.Window
+	if window <= 0 {
+		window = s.securityPolicies.RateLimitWindow
+	}
+	return s.allowRequest("route:"+route.PathPrefix+":"+client, route.Requests, window)
+}
+
+// allowRequest applies a sliding window limit to the requests recorded under
+// key; a limit of 0 or less allows everything. A rejected request gets the
+// time the oldest request that counts against it leaves the window. The
+// caller must hold the write lock.
+func (s *SecurityService) allowRequest(key string, limit int, window time.Duration) (bool, time.Time) {
+	if limit <= 0 {
+		return true, time.Time{}
+	}
+
+	now := s.now()
+	rateInfo, exists := s.rateLimiter[key]
+	if !exists {
+		rateInfo = &RateLimitInfo{}
+		s.rateLimiter[key] = rateInfo
+	}
+
+	// Forget requests that have slid out of the window
+	cutoff := now.Add(-window)
+	expired := 0
+	for expired < len(rateInfo.Requests) && !rateInfo.Requests[expired].After(cutoff) {
+		expired++
+	}
+	rateInfo.Requests = rateInfo.Requests[expired:]
+
+	if excess := len(rateInfo.Requests) - limit; excess >= 0 {
+		return false, rateInfo.Requests[excess].Add(window)
+	}
+
+	rateInfo.Requests = append(rateInfo.Requests, now)
+	return true, time.Time{}
+}
+
+// routeMatches reports whether path is prefix or lies below it.
+func routeMatches(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix != "" && (path == prefix || strings.HasPrefix(path, prefix+"/"))
+}
+
+// RateLimitResetTime returns when ip may next make a request, which is when
+// enough of its requests have slid out of the window. It returns the zero
+// time if the IP is not being limited.
+func (s *SecurityService) RateLimitResetTime(ip string) time.Time {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	rateInfo, exists := s.rateLimiter[ip]
+	if !exists {
+		return time.Time{}
+	}
 
-	return true
+	excess := len(rateInfo.Requests) - s.securityPolicies.RateLimitRequests
+	if excess < 0 || s.securityPolicies.RateLimitRequests <= 0 {
+		return time.Time{}
+	}
+	return rateInfo.Requests[excess].Add(s.securityPolicies.RateLimitWindow)
 }
 
 func (s *SecurityService) ValidatePassword(password string) []string {
@@ -436,12 +736,25 @@ func (s *SecurityService) ScanForVulnerabilities(ctx context.Context) (*Security
 		}
 	}
 
-	// Sort and get top 10
-	// This is simplified - in production, use proper sorting
+	// Sort by failed login count and get top 10
 	for ip, count := range ipCounts {
 		if count > 3 {
-			report.TopAttackingIPs = append(report.TopAttackingIPs, ip)
+			report.TopAttackingIPs = append(report.TopAttackingIPs, AttackingIP{
+				IP:           ip,
+				FailedLogins: count,
+			})
+		}
+	}
+
+	sort.Slice(report.TopAttackingIPs, func(i, j int) bool {
+		if report.TopAttackingIPs[i].FailedLogins != report.TopAttackingIPs[j].FailedLogins {
+			return report.TopAttackingIPs[i].FailedLogins > report.TopAttackingIPs[j].FailedLogins
 		}
+		return report.TopAttackingIPs[i].IP < report.TopAttackingIPs[j].IP
+	})
+
+	if len(report.TopAttackingIPs) > 10 {
+		report.TopAttackingIPs = report.TopAttackingIPs[:10]
 	}
 
 	// Recent events (last 10)
@@ -459,6 +772,13 @@ func (s *SecurityService) ScanForVulnerabilities(ctx context.Context) (*Security
 	if report.CriticalEvents > 0 {
 		report.Recommendations = append(report.Recommendations, "Critical security events detected. Review logs immediately.")
 	}
+
+	if expiries := s.CertificateExpiries(); len(expiries) > 0 {
+		report.CertificateExpiry = &expiries[0]
+		if s.expiresSoon(expiries[0]) {
+			report.Recommendations = append(report.Recommendations, fmt.Sprintf("Certificate %s expires in %d days. Renew it before it lapses.", expiries[0].Subject, expiries[0].DaysRemaining))
+		}
+	}
 	
 	if !s.securityPolicies.EnableHTTPS {
 		report.Recommendations = append(report.Recommendations, "HTTPS is not enabled. Enable HTTPS for better security.")
@@ -472,10 +792,26 @@ func (s *SecurityService) UpdateSecurityPolicies(ctx context.Context, policies *
 	defer s.mutex.Unlock()
 
 	oldPolicies := *s.securityPolicies
+
+	data, err := json.Marshal(policies)
+	if err != nil {
+		return fmt.Errorf("failed to marshal security policies: %w", err)
+	}
+
+	record := SecurityPolicyRecord{
+		ID:        1,
+		Policies:  string(data),
+		UpdatedBy: &updatedBy,
+	}
+	if err := s.db.Save(&record).Error; err != nil {
+		return fmt.Errorf("failed to save security policies: %w", err)
+	}
+
 	s.securityPolicies = policies
 
 	// Log policy change
-	s.auditService.LogAction(ctx, updatedBy, "update_security_policies", "security_policies", uuid.Nil,
+	s.auditService.LogActionWithMetadata(ctx, &updatedBy, models.AuditActionUpdate, "security_policies", nil,
+		"Security policies updated", "", "",
 		map[string]interface{}{
 			"old_policies": oldPolicies,
 			"new_policies": policies,
@@ -493,7 +829,7 @@ func (s *SecurityService) GetSecurityPolicies() *SecurityPolicies {
 	return &policies
 }
 
-func (s *SecurityService) AddAllowedCIDR(cidr string) error {
+func (s *SecurityService) AddAllowedCIDR(ctx context.Context, cidr string, addedBy uuid.UUID) error {
 	_, ipNet, err := net.ParseCIDR(cidr)
 	if err != nil {
 		return fmt.Errorf("invalid CIDR: %w", err)
@@ -502,7 +838,87 @@ func (s *SecurityService) AddAllowedCIDR(cidr string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	for _, existing := range s.allowedCIDRs {
+		if existing.String() == ipNet.String() {
+			return nil
+		}
+	}
+
+	record := AllowedCIDR{
+		CIDR:      ipNet.String(),
+		CreatedBy: &addedBy,
+	}
+	if err := s.db.Create(&record).Error; err != nil {
+		return fmt.Errorf("failed to save allowed CIDR: %w", err)
+	}
+
 	s.allowedCIDRs = append(s.allowedCIDRs, ipNet)
+
+	s.auditService.LogAction(ctx, &addedBy, models.AuditActionCreate, "allowed_cidr", &record.ID,
+		fmt.Sprintf("Added %s to IP allowlist", record.CIDR), "", "")
+
+	return nil
+}
+
+func (s *SecurityService) RemoveAllowedCIDR(ctx context.Context, cidr string, removedBy uuid.UUID) error {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	index := -1
+	for i, existing := range s.allowedCIDRs {
+		if existing.String() == ipNet.String() {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return ErrCIDRNotAllowlisted
+	}
+
+	if err := s.db.Where("cidr = ?", ipNet.String()).Delete(&AllowedCIDR{}).Error; err != nil {
+		return fmt.Errorf("failed to delete allowed CIDR: %w", err)
+	}
+
+	s.allowedCIDRs = append(s.allowedCIDRs[:index], s.allowedCIDRs[index+1:]...)
+
+	s.auditService.LogAction(ctx, &removedBy, models.AuditActionDelete, "allowed_cidr", nil,
+		fmt.Sprintf("Removed %s from IP allowlist", ipNet.String()), "", "")
+
+	return nil
+}
+
+func (s *SecurityService) GetAllowedCIDRs() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	cidrs := make([]string, 0, len(s.allowedCIDRs))
+	for _, ipNet := range s.allowedCIDRs {
+		cidrs = append(cidrs, ipNet.String())
+	}
+
+	return cidrs
+}
+
+func (s *SecurityService) loadAllowedCIDRs() error {
+	var records []AllowedCIDR
+	if err := s.db.Order("created_at").Find(&records).Error; err != nil {
+		return fmt.Errorf("failed to get allowed CIDRs: %w", err)
+	}
+
+	for _, record := range records {
+		_, ipNet, err := net.ParseCIDR(record.CIDR)
+		if err != nil {
+			slog.Warn("Skipping invalid allowed CIDR", "cidr", record.CIDR, "error", err)
+			continue
+		}
+		s.allowedCIDRs = append(s.allowedCIDRs, ipNet)
+	}
+
 	return nil
 }
 
@@ -540,6 +956,227 @@ func (s *SecurityService) ValidateCertificate(cert *x509.Certificate) error {
 	return nil
 }
 
+// LoadClientCAs reads the PEM bundle of CAs that issue agent client
+// certificates.
+func LoadClientCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", path)
+	}
+	return pool, nil
+}
+
+// NodeIDFromClientCertificate returns the node a client certificate was
+// issued to. The certificate must have been verified against the client
+// CAs during the handshake and pass ValidateCertificate, which catches one
+// that expired while a kept-alive connection stayed open. The node ID is
+// taken from the subject CN, or failing that a DNS SAN.
+func (s *SecurityService) NodeIDFromClientCertificate(state *tls.ConnectionState) (uuid.UUID, error) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return uuid.Nil, ErrNoClientCertificate
+	}
+	if len(state.VerifiedChains) == 0 {
+		return uuid.Nil, ErrUntrustedClientCertificate
+	}
+
+	cert := state.PeerCertificates[0]
+	if err := s.ValidateCertificate(cert); err != nil {
+		return uuid.Nil, fmt.Errorf("%w: %v", ErrUntrustedClientCertificate, err)
+	}
+
+	for _, name := range append([]string{cert.Subject.CommonName}, cert.DNSNames...) {
+		if nodeID, err := uuid.Parse(name); err == nil {
+			s.mutex.Lock()
+			s.agentCertificates[nodeID] = cert
+			s.mutex.Unlock()
+			return nodeID, nil
+		}
+	}
+	return uuid.Nil, ErrNoCertificateNodeIdentity
+}
+
+// SetMonitoringService makes certificate expiry checks raise monitoring
+// alerts.
+func (s *SecurityService) SetMonitoringService(monitoringService *MonitoringService) {
+	s.monitoringService = monitoringService
+}
+
+// CertificateExpiries returns the soonest-expiring certificate of the TLS
+// certificate file, the client CA file and each agent that has
+// authenticated with a client certificate since startup, soonest first.
+// Files that cannot be read are logged and skipped.
+func (s *SecurityService) CertificateExpiries() []CertificateExpiry {
+	now := s.now()
+	var expiries []CertificateExpiry
+
+	tlsConfig := s.config.Server.TLS
+	for _, file := range []struct{ source, path string }{
+		{CertificateSourceTLS, tlsConfig.CertFile},
+		{CertificateSourceClientCA, tlsConfig.ClientCAFile},
+	} {
+		if file.path == "" {
+			continue
+		}
+		certs, err := readCertificates(file.path)
+		if err != nil {
+			slog.Warn("Failed to read certificate for expiry check", "path", file.path, "error", err)
+			continue
+		}
+		expiry := certificateExpiry(soonestExpiring(certs), now)
+		expiry.Source = file.source
+		expiry.Path = file.path
+		expiries = append(expiries, expiry)
+	}
+
+	s.mutex.RLock()
+	for nodeID, cert := range s.agentCertificates {
+		nodeID := nodeID
+		expiry := certificateExpiry(cert, now)
+		expiry.Source = CertificateSourceAgent
+		expiry.NodeID = &nodeID
+		expiries = append(expiries, expiry)
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(expiries, func(i, j int) bool {
+		return expiries[i].NotAfter.Before(expiries[j].NotAfter)
+	})
+	return expiries
+}
+
+// CheckCertificateExpiry raises a monitoring alert for each watched
+// certificate within the warning window of expiry, critical once it has
+// expired, and resolves the alert once the certificate is renewed. It
+// returns the certificates that are expiring.
+func (s *SecurityService) CheckCertificateExpiry(ctx context.Context) []CertificateExpiry {
+	var expiring []CertificateExpiry
+	for _, expiry := range s.CertificateExpiries() {
+		key := expiry.alertKey()
+		if !s.expiresSoon(expiry) {
+			if s.monitoringService != nil {
+				if err := s.monitoringService.ResolveSystemAlert(ctx, key); err != nil {
+					slog.ErrorContext(ctx, "Failed to resolve certificate expiry alert", "source", expiry.Source, "error", err)
+				}
+			}
+			continue
+		}
+		expiring = append(expiring, expiry)
+
+		severity := "warning"
+		message := fmt.Sprintf("Certificate %s (%s) expires in %d days on %s", expiry.Subject, expiry.describe(), expiry.DaysRemaining, expiry.NotAfter.UTC().Format(time.RFC3339))
+		if !expiry.NotAfter.After(s.now()) {
+			severity = "critical"
+			message = fmt.Sprintf("Certificate %s (%s) expired on %s", expiry.Subject, expiry.describe(), expiry.NotAfter.UTC().Format(time.RFC3339))
+		}
+		slog.WarnContext(ctx, "Certificate nearing expiry", "source", expiry.Source, "path", expiry.Path, "subject", expiry.Subject, "not_after", expiry.NotAfter, "days_remaining", expiry.DaysRemaining)
+
+		if s.monitoringService == nil {
+			continue
+		}
+		var nodeID uuid.UUID
+		if expiry.NodeID != nil {
+			nodeID = *expiry.NodeID
+		}
+		if err := s.monitoringService.RaiseSystemAlert(ctx, key, nodeID, severity, message, float64(expiry.DaysRemaining)); err != nil {
+			slog.ErrorContext(ctx, "Failed to raise certificate expiry alert", "source", expiry.Source, "error", err)
+		}
+	}
+	return expiring
+}
+
+// StartCertificateExpiryChecks checks the watched certificates at startup
+// and then daily until ctx is cancelled.
+func (s *SecurityService) StartCertificateExpiryChecks(ctx context.Context) {
+	ticker := time.NewTicker(certificateCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		s.CheckCertificateExpiry(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *SecurityService) expiresSoon(expiry CertificateExpiry) bool {
+	window := defaultCertExpiryWarning
+	if days := s.config.Server.TLS.CertExpiryWarningDays; days > 0 {
+		window = time.Duration(days) * 24 * time.Hour
+	}
+	return expiry.NotAfter.Sub(s.now()) < window
+}
+
+// alertKey identifies the source rather than the certificate, so renewing
+// it resolves the alert raised for the old one.
+func (e CertificateExpiry) alertKey() string {
+	if e.NodeID != nil {
+		return "certificate:" + e.Source + ":" + e.NodeID.String()
+	}
+	return "certificate:" + e.Source + ":" + e.Path
+}
+
+func (e CertificateExpiry) describe() string {
+	if e.NodeID != nil {
+		return "agent " + e.NodeID.String()
+	}
+	return e.Path
+}
+
+func certificateExpiry(cert *x509.Certificate, now time.Time) CertificateExpiry {
+	return CertificateExpiry{
+		Subject:       cert.Subject.CommonName,
+		NotAfter:      cert.NotAfter,
+		DaysRemaining: int(cert.NotAfter.Sub(now).Hours() / 24),
+	}
+}
+
+func soonestExpiring(certs []*x509.Certificate) *x509.Certificate {
+	soonest := certs[0]
+	for _, cert := range certs[1:] {
+		if cert.NotAfter.Before(soonest.NotAfter) {
+			soonest = cert
+		}
+	}
+	return soonest
+}
+
+// readCertificates parses every certificate in a PEM file.
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return certs, nil
+}
+
 func (s *SecurityService) logSecurityEvent(ctx context.Context, eventType, severity, ip, userAgent string, userID *uuid.UUID, description string, metadata map[string]interface{}) {
 	event := SecurityEvent{
 		EventType:   eventType,
@@ -552,8 +1189,9 @@ func (s *SecurityService) logSecurityEvent(ctx context.Context, eventType, sever
 
 	// Serialize metadata
 	if metadata != nil {
-		// This is simplified - in production, use proper JSON serialization
-		event.Metadata = fmt.Sprintf("%v", metadata)
+		if data, err := json.Marshal(metadata); err == nil {
+			event.Metadata = string(data)
+		}
 	}
 
 	s.db.Create(&event)
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"fmt"
+)
+
+// SecurityEventView is a security event with its metadata decoded, as
+// returned by the events API.
+type SecurityEventView struct {
+	SecurityEvent
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// GetSecurityEvents returns a page of security events, newest first. Empty
+// eventType or severity match any.
+func (s *SecurityService) GetSecurityEvents(ctx context.Context, page, perPage int, eventType, severity string) ([]SecurityEventView, int64, error) {
+	var events []SecurityEvent
+	var total int64
+
+	query := s.db.WithContext(ctx).Model(&SecurityEvent{})
+	if eventType != "" {
+		query = query.Where("event_type = ?", eventType)
+	}
+	if severity != "" {
+		query = query.Where("severity = ?", severity)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count security events: %w", err)
+	}
+
+	offset := (page - 1) * perPage
+	if err := query.Order("created_at DESC").Offset(offset).Limit(perPage).Find(&events).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to get security events: %w", err)
+	}
+
+	views := make([]SecurityEventView, len(events))
+	for i := range events {
+		views[i] = SecurityEventView{
+			SecurityEvent: events[i],
+			Metadata:      events[i].ParseMetadata(),
+		}
+	}
+
+	return views, total, nil
+}
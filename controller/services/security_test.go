@@ -0,0 +1,578 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckRateLimitSlidingWindow(t *testing.T) {
+	_, securityService := newLockoutTestService(t)
+	securityService.securityPolicies.RateLimitRequests = 10
+	securityService.securityPolicies.RateLimitWindow = time.Minute
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	now := start
+	securityService.now = func() time.Time { return now }
+
+	burst := func(at time.Duration) int {
+		now = start.Add(at)
+		allowed := 0
+		for i := 0; i < 10; i++ {
+			if securityService.CheckRateLimit("10.0.0.9") {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	// A full burst at the end of one minute and another just after it
+	// would both pass with fixed one-minute buckets
+	if allowed := burst(50 * time.Second); allowed != 10 {
+		t.Fatalf("expected the first burst to be allowed, got %d of 10", allowed)
+	}
+	if allowed := burst(61 * time.Second); allowed != 0 {
+		t.Errorf("expected a burst across the window boundary to be throttled, got %d of 10 allowed", allowed)
+	}
+	if reset := securityService.RateLimitResetTime("10.0.0.9"); !reset.Equal(start.Add(110 * time.Second)) {
+		t.Errorf("expected the limit to lift once the first burst slides out, got %v", reset.Sub(start))
+	}
+
+	// Once the first burst is a full window old, requests flow again
+	if allowed := burst(110 * time.Second); allowed != 10 {
+		t.Errorf("expected requests to be allowed after the window slid, got %d of 10", allowed)
+	}
+
+	// Other IPs have windows of their own
+	if !securityService.CheckRateLimit("10.0.0.1") {
+		t.Error("expected another IP not to be limited")
+	}
+}
+
+// writeSelfSignedCert writes a certificate for 127.0.0.1 and its key to dir
+// and returns their paths along with the certificate.
+func writeSelfSignedCert(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "wg-controller"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestConfigureTLSServesHTTPSWithMinimumVersion(t *testing.T) {
+	_, securityService := newLockoutTestService(t)
+	certFile, keyFile, cert := writeSelfSignedCert(t, t.TempDir())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		TLSConfig: securityService.ConfigureTLS(),
+	}
+	go srv.ServeTLS(listener, certFile, keyFile)
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	dial := func(minVersion, maxVersion uint16) (tls.ConnectionState, error) {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+			RootCAs:    roots,
+			MinVersion: minVersion,
+			MaxVersion: maxVersion,
+		})
+		if err != nil {
+			return tls.ConnectionState{}, err
+		}
+		defer conn.Close()
+		return conn.ConnectionState(), nil
+	}
+
+	state, err := dial(tls.VersionTLS12, tls.VersionTLS12)
+	if err != nil {
+		t.Fatalf("expected a TLS 1.2 handshake to succeed: %v", err)
+	}
+	if state.Version != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2, got %#x", state.Version)
+	}
+	allowed := false
+	for _, suite := range securityService.ConfigureTLS().CipherSuites {
+		allowed = allowed || suite == state.CipherSuite
+	}
+	if !allowed {
+		t.Errorf("expected one of the configured cipher suites, got %s", tls.CipherSuiteName(state.CipherSuite))
+	}
+
+	if state, err := dial(tls.VersionTLS12, 0); err != nil || state.Version != tls.VersionTLS13 {
+		t.Errorf("expected modern clients to negotiate TLS 1.3, got %#x: %v", state.Version, err)
+	}
+
+	if _, err := dial(tls.VersionTLS10, tls.VersionTLS11); err == nil {
+		t.Error("expected a TLS 1.1 handshake to be refused")
+	}
+}
+
+// newClientCertificate returns a client certificate with the given CN that
+// is valid until notAfter.
+func newClientCertificate(t *testing.T, commonName string, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    notAfter.Add(-400 * 24 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+// verifiedState is the connection state of a handshake that verified cert.
+func verifiedState(cert *x509.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+}
+
+func TestNodeIDFromClientCertificate(t *testing.T) {
+	_, securityService := newLockoutTestService(t)
+	nodeID := uuid.New()
+
+	valid := newClientCertificate(t, nodeID.String(), time.Now().Add(time.Hour))
+	if got, err := securityService.NodeIDFromClientCertificate(verifiedState(valid)); err != nil || got != nodeID {
+		t.Errorf("expected a valid certificate to identify node %s, got %s: %v", nodeID, got, err)
+	}
+
+	// A certificate that expires while a kept-alive connection stays open
+	expired := newClientCertificate(t, nodeID.String(), time.Now().Add(-time.Minute))
+	if err := securityService.ValidateCertificate(expired); err == nil {
+		t.Error("expected ValidateCertificate to reject an expired certificate")
+	}
+	if _, err := securityService.NodeIDFromClientCertificate(verifiedState(expired)); !errors.Is(err, ErrUntrustedClientCertificate) {
+		t.Errorf("expected an expired certificate to be rejected, got %v", err)
+	}
+
+	unverified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{valid}}
+	if _, err := securityService.NodeIDFromClientCertificate(unverified); !errors.Is(err, ErrUntrustedClientCertificate) {
+		t.Errorf("expected a certificate not verified against the client CAs to be rejected, got %v", err)
+	}
+
+	operator := newClientCertificate(t, "alice", time.Now().Add(time.Hour))
+	if _, err := securityService.NodeIDFromClientCertificate(verifiedState(operator)); !errors.Is(err, ErrNoCertificateNodeIdentity) {
+		t.Errorf("expected a certificate without a node ID to identify no node, got %v", err)
+	}
+
+	if _, err := securityService.NodeIDFromClientCertificate(&tls.ConnectionState{}); !errors.Is(err, ErrNoClientCertificate) {
+		t.Errorf("expected no certificate to be reported, got %v", err)
+	}
+}
+
+// newExpiryTestService returns a security service watching certFile whose
+// alerts are recorded by a monitoring service on the same database.
+func newExpiryTestService(t *testing.T, certFile string) (*SecurityService, *MonitoringService) {
+	t.Helper()
+
+	authService, securityService := newLockoutTestService(t)
+	if err := authService.db.Exec(`CREATE TABLE alerts (id TEXT PRIMARY KEY, rule_id TEXT NOT NULL, node_id TEXT NOT NULL, message TEXT, severity TEXT, status TEXT NOT NULL, value REAL, triggered_at DATETIME, resolved_at DATETIME)`).Error; err != nil {
+		t.Fatalf("failed to create alerts table: %v", err)
+	}
+	securityService.config.Server.TLS.CertFile = certFile
+	securityService.config.Server.TLS.CertExpiryWarningDays = 30
+
+	monitoringService := NewMonitoringService(authService.db, NewNotificationService(securityService.config))
+	securityService.SetMonitoringService(monitoringService)
+	return securityService, monitoringService
+}
+
+func writeCertificate(t *testing.T, path string, cert *x509.Certificate) {
+	t.Helper()
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o644); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+}
+
+func TestCheckCertificateExpiryAlertsNearExpiry(t *testing.T) {
+	certFile := filepath.Join(t.TempDir(), "server.crt")
+	writeCertificate(t, certFile, newClientCertificate(t, "wg-controller", time.Now().Add(10*24*time.Hour)))
+	securityService, monitoringService := newExpiryTestService(t, certFile)
+	ctx := context.Background()
+
+	// Just under ten days are left, which counts as nine whole days
+	expiring := securityService.CheckCertificateExpiry(ctx)
+	if len(expiring) != 1 || expiring[0].Source != CertificateSourceTLS || expiring[0].DaysRemaining != 9 {
+		t.Fatalf("expected the TLS certificate to be expiring, got %+v", expiring)
+	}
+	// The daily check must not pile up alerts for the same certificate
+	securityService.CheckCertificateExpiry(ctx)
+
+	alerts, err := monitoringService.GetAlerts(ctx, AlertStatusActive, nil)
+	if err != nil {
+		t.Fatalf("failed to get alerts: %v", err)
+	}
+	if len(alerts) != 1 || alerts[0].Severity != "warning" {
+		t.Fatalf("expected one warning alert, got %+v", alerts)
+	}
+
+	report, err := securityService.ScanForVulnerabilities(ctx)
+	if err != nil {
+		t.Fatalf("failed to build security report: %v", err)
+	}
+	if report.CertificateExpiry == nil || !report.CertificateExpiry.NotAfter.Equal(expiring[0].NotAfter) {
+		t.Errorf("expected the report to show the soonest expiry, got %+v", report.CertificateExpiry)
+	}
+
+	// Renewing the certificate resolves the alert
+	writeCertificate(t, certFile, newClientCertificate(t, "wg-controller", time.Now().Add(365*24*time.Hour)))
+	if expiring := securityService.CheckCertificateExpiry(ctx); len(expiring) != 0 {
+		t.Errorf("expected the renewed certificate not to be expiring, got %+v", expiring)
+	}
+	if alerts, _ := monitoringService.GetAlerts(ctx, AlertStatusActive, nil); len(alerts) != 0 {
+		t.Errorf("expected the alert to be resolved after renewal, got %+v", alerts)
+	}
+}
+
+func TestCheckCertificateExpiryIgnoresFreshCertificates(t *testing.T) {
+	certFile := filepath.Join(t.TempDir(), "server.crt")
+	writeCertificate(t, certFile, newClientCertificate(t, "wg-controller", time.Now().Add(90*24*time.Hour)))
+	securityService, monitoringService := newExpiryTestService(t, certFile)
+	ctx := context.Background()
+
+	// Agent certificates are watched once an agent has authenticated
+	nodeID := uuid.New()
+	agentCert := newClientCertificate(t, nodeID.String(), time.Now().Add(60*24*time.Hour))
+	if _, err := securityService.NodeIDFromClientCertificate(verifiedState(agentCert)); err != nil {
+		t.Fatalf("failed to authenticate agent: %v", err)
+	}
+
+	if expiring := securityService.CheckCertificateExpiry(ctx); len(expiring) != 0 {
+		t.Errorf("expected no certificate to be expiring, got %+v", expiring)
+	}
+	if alerts, _ := monitoringService.GetAlerts(ctx, "", nil); len(alerts) != 0 {
+		t.Errorf("expected no alerts for fresh certificates, got %+v", alerts)
+	}
+
+	expiries := securityService.CertificateExpiries()
+	if len(expiries) != 2 || expiries[0].Source != CertificateSourceAgent || *expiries[0].NodeID != nodeID {
+		t.Errorf("expected the agent certificate to expire soonest, got %+v", expiries)
+	}
+}
+
+func TestSecurityEventMetadataRoundTrip(t *testing.T) {
+	_, securityService := newLockoutTestService(t)
+	ctx := context.Background()
+
+	metadata := map[string]interface{}{"attempt_count": float64(3), "username": "alice"}
+	securityService.logSecurityEvent(ctx, "login_failed", "medium", "10.0.0.9", "test", nil, "Failed login attempt", metadata)
+
+	// Rows written before metadata was stored as JSON
+	legacy := SecurityEvent{EventType: "ip_blocked", Severity: "high", Metadata: "map[attempt_count:3]"}
+	if err := securityService.db.Create(&legacy).Error; err != nil {
+		t.Fatalf("failed to create legacy event: %v", err)
+	}
+
+	events, total, err := securityService.GetSecurityEvents(ctx, 1, 10, "login_failed", "")
+	if err != nil {
+		t.Fatalf("GetSecurityEvents failed: %v", err)
+	}
+	if total != 1 || len(events) != 1 {
+		t.Fatalf("expected one login_failed event, got %d (total %d)", len(events), total)
+	}
+	if !reflect.DeepEqual(events[0].Metadata, metadata) {
+		t.Errorf("expected metadata %v, got %v", metadata, events[0].Metadata)
+	}
+
+	events, _, err = securityService.GetSecurityEvents(ctx, 1, 10, "", "high")
+	if err != nil {
+		t.Fatalf("GetSecurityEvents failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Metadata["raw"] != "map[attempt_count:3]" {
+		t.Errorf("expected the legacy metadata under \"raw\", got %+v", events)
+	}
+}
+
+func TestGetBlockedIPsAndUnblock(t *testing.T) {
+	_, securityService := newLockoutTestService(t)
+	ctx := context.Background()
+	policies := securityService.GetSecurityPolicies()
+
+	before := time.Now()
+	for i := 0; i < policies.MaxLoginAttempts; i++ {
+		securityService.RecordFailedLogin(ctx, "10.0.0.9", "test", nil)
+	}
+	// Below the threshold, so not blocked
+	securityService.RecordFailedLogin(ctx, "10.0.0.10", "test", nil)
+
+	blocked := securityService.GetBlockedIPs()
+	if len(blocked) != 1 || blocked[0].IP != "10.0.0.9" {
+		t.Fatalf("expected only 10.0.0.9 to be blocked, got %+v", blocked)
+	}
+	entry := blocked[0]
+	if entry.FailedAttempts != policies.MaxLoginAttempts {
+		t.Errorf("expected %d failed attempts, got %d", policies.MaxLoginAttempts, entry.FailedAttempts)
+	}
+	if entry.BlockedAt.Before(before) || entry.BlockedAt.After(time.Now()) {
+		t.Errorf("expected the block time to be recorded, got %v", entry.BlockedAt)
+	}
+	if want := entry.BlockedAt.Add(policies.LoginLockoutTime); entry.ExpiresAt.Sub(want).Abs() > time.Second {
+		t.Errorf("expected the block to expire at %v, got %v", want, entry.ExpiresAt)
+	}
+
+	if err := securityService.UnblockIP(ctx, "10.0.0.9", uuid.New()); err != nil {
+		t.Fatalf("UnblockIP failed: %v", err)
+	}
+	if blocked := securityService.GetBlockedIPs(); len(blocked) != 0 {
+		t.Errorf("expected no blocked IPs after unblocking, got %+v", blocked)
+	}
+	if securityService.IsIPBlocked("10.0.0.9") {
+		t.Error("expected the unblocked IP to be allowed again")
+	}
+	// The counter starts over, so one more failure doesn't block again
+	securityService.RecordFailedLogin(ctx, "10.0.0.9", "test", nil)
+	if securityService.IsIPBlocked("10.0.0.9") {
+		t.Error("expected the failed attempt counter to be reset")
+	}
+
+	if err := securityService.UnblockIP(ctx, "10.0.0.10", uuid.New()); !errors.Is(err, ErrIPNotBlocked) {
+		t.Errorf("expected ErrIPNotBlocked for an IP that isn't blocked, got %v", err)
+	}
+}
+
+func TestScanForVulnerabilitiesRanksTopAttackingIPs(t *testing.T) {
+	_, securityService := newLockoutTestService(t)
+	ctx := context.Background()
+
+	// A dozen IPs with 4 to 15 failed logins each, created out of order, plus
+	// one at the threshold that isn't counted as attacking
+	var events []SecurityEvent
+	for i, count := range []int{7, 15, 4, 11, 9, 13, 5, 12, 6, 14, 8, 10, 3} {
+		ip := fmt.Sprintf("198.51.100.%d", i+1)
+		for j := 0; j < count; j++ {
+			events = append(events, SecurityEvent{ID: uuid.New(), EventType: "failed_login", Severity: "warning", IP: ip, CreatedAt: time.Now()})
+		}
+	}
+	if err := securityService.db.Create(&events).Error; err != nil {
+		t.Fatalf("failed to create events: %v", err)
+	}
+
+	report, err := securityService.ScanForVulnerabilities(ctx)
+	if err != nil {
+		t.Fatalf("ScanForVulnerabilities failed: %v", err)
+	}
+	if len(report.TopAttackingIPs) != 10 {
+		t.Fatalf("expected the top 10 attacking IPs, got %d: %+v", len(report.TopAttackingIPs), report.TopAttackingIPs)
+	}
+	for i, attacker := range report.TopAttackingIPs {
+		if want := 15 - i; attacker.FailedLogins != want {
+			t.Errorf("expected entry %d to have %d failed logins, got %+v", i, want, attacker)
+		}
+	}
+	if top := report.TopAttackingIPs[0]; top.IP != "198.51.100.2" {
+		t.Errorf("expected 198.51.100.2 to top the list, got %s", top.IP)
+	}
+}
+
+func TestSecurityPoliciesPersistAcrossRestarts(t *testing.T) {
+	authService, securityService := newLockoutTestService(t)
+	ctx := context.Background()
+
+	// Defaults apply until a policy has been saved
+	restarted := NewSecurityService(securityService.db, authService.config, authService.auditSvc)
+	if got := restarted.GetSecurityPolicies(); !reflect.DeepEqual(got, securityService.GetSecurityPolicies()) {
+		t.Fatalf("expected the defaults without a saved policy, got %+v", got)
+	}
+
+	policies := securityService.GetSecurityPolicies()
+	policies.MaxLoginAttempts = 3
+	policies.LoginLockoutTime = time.Hour
+	policies.RateLimitRequests = 20
+	policies.RouteRateLimits = []RouteRateLimit{{PathPrefix: "/api/v1/nodes", Requests: 5}}
+	if err := securityService.UpdateSecurityPolicies(ctx, policies, uuid.New()); err != nil {
+		t.Fatalf("UpdateSecurityPolicies failed: %v", err)
+	}
+	if got := securityService.GetSecurityPolicies(); !reflect.DeepEqual(got, policies) {
+		t.Errorf("expected the running service to use the new policies, got %+v", got)
+	}
+
+	// A new service against the same database loads them
+	restarted = NewSecurityService(securityService.db, authService.config, authService.auditSvc)
+	if got := restarted.GetSecurityPolicies(); !reflect.DeepEqual(got, policies) {
+		t.Errorf("expected the saved policies after a restart, got %+v", got)
+	}
+
+	// Updating again replaces the single row
+	policies.MaxLoginAttempts = 10
+	if err := restarted.UpdateSecurityPolicies(ctx, policies, uuid.New()); err != nil {
+		t.Fatalf("UpdateSecurityPolicies failed: %v", err)
+	}
+	var rows int64
+	securityService.db.Model(&SecurityPolicyRecord{}).Count(&rows)
+	if rows != 1 {
+		t.Errorf("expected a single policy row, got %d", rows)
+	}
+	if got := NewSecurityService(securityService.db, authService.config, authService.auditSvc).GetSecurityPolicies(); got.MaxLoginAttempts != 10 {
+		t.Errorf("expected the latest policies to be loaded, got %+v", got)
+	}
+}
+
+func TestAllowedCIDRsPersistAcrossRestarts(t *testing.T) {
+	authService, securityService := newLockoutTestService(t)
+	ctx := context.Background()
+	adminID := uuid.New()
+
+	policies := securityService.GetSecurityPolicies()
+	policies.IPWhitelistOnly = true
+	if err := securityService.UpdateSecurityPolicies(ctx, policies, adminID); err != nil {
+		t.Fatalf("UpdateSecurityPolicies failed: %v", err)
+	}
+	if securityService.IsIPAllowed("192.0.2.10") {
+		t.Fatal("expected an empty allowlist to refuse every IP")
+	}
+
+	for _, cidr := range []string{"192.0.2.0/24", "198.51.100.7/32"} {
+		if err := securityService.AddAllowedCIDR(ctx, cidr, adminID); err != nil {
+			t.Fatalf("AddAllowedCIDR(%s) failed: %v", cidr, err)
+		}
+	}
+	// Adding one again is a no-op, and host bits are masked off
+	if err := securityService.AddAllowedCIDR(ctx, "192.0.2.99/24", adminID); err != nil {
+		t.Fatalf("AddAllowedCIDR of a duplicate failed: %v", err)
+	}
+	if err := securityService.AddAllowedCIDR(ctx, "not-a-cidr", adminID); err == nil {
+		t.Error("expected an invalid CIDR to be refused")
+	}
+	if !securityService.IsIPAllowed("192.0.2.10") || securityService.IsIPAllowed("203.0.113.1") {
+		t.Error("expected only allowlisted networks to be allowed")
+	}
+
+	// A restarted controller keeps the allowlist, so its admins aren't locked out
+	restarted := NewSecurityService(securityService.db, authService.config, authService.auditSvc)
+	if got := restarted.GetAllowedCIDRs(); !reflect.DeepEqual(got, []string{"192.0.2.0/24", "198.51.100.7/32"}) {
+		t.Errorf("expected the allowlist to be reloaded, got %v", got)
+	}
+	if !restarted.IsIPAllowed("192.0.2.10") || !restarted.IsIPAllowed("198.51.100.7") {
+		t.Error("expected allowlisted IPs to be allowed after a restart")
+	}
+
+	if err := restarted.RemoveAllowedCIDR(ctx, "192.0.2.0/24", adminID); err != nil {
+		t.Fatalf("RemoveAllowedCIDR failed: %v", err)
+	}
+	if restarted.IsIPAllowed("192.0.2.10") {
+		t.Error("expected the removed network to be refused")
+	}
+	if err := restarted.RemoveAllowedCIDR(ctx, "192.0.2.0/24", adminID); !errors.Is(err, ErrCIDRNotAllowlisted) {
+		t.Errorf("expected ErrCIDRNotAllowlisted removing it again, got %v", err)
+	}
+
+	if got := NewSecurityService(securityService.db, authService.config, authService.auditSvc).GetAllowedCIDRs(); !reflect.DeepEqual(got, []string{"198.51.100.7/32"}) {
+		t.Errorf("expected the removal to persist, got %v", got)
+	}
+}
+
+func TestIsIPAllowedDuringAllowlistChanges(t *testing.T) {
+	_, securityService := newLockoutTestService(t)
+	ctx := context.Background()
+	adminID := uuid.New()
+
+	policies := securityService.GetSecurityPolicies()
+	policies.IPWhitelistOnly = true
+	if err := securityService.UpdateSecurityPolicies(ctx, policies, adminID); err != nil {
+		t.Fatalf("UpdateSecurityPolicies failed: %v", err)
+	}
+
+	// Run with -race: requests check the allowlist while admins edit it
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			cidr := fmt.Sprintf("10.0.%d.0/24", i)
+			if err := securityService.AddAllowedCIDR(ctx, cidr, adminID); err != nil {
+				t.Errorf("AddAllowedCIDR(%s) failed: %v", cidr, err)
+				return
+			}
+			if i%2 == 1 {
+				if err := securityService.RemoveAllowedCIDR(ctx, cidr, adminID); err != nil {
+					t.Errorf("RemoveAllowedCIDR(%s) failed: %v", cidr, err)
+					return
+				}
+			}
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				securityService.IsIPAllowed(fmt.Sprintf("10.0.%d.1", j%20))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !securityService.IsIPAllowed("10.0.0.1") || securityService.IsIPAllowed("10.0.1.1") {
+		t.Error("expected only the networks that were kept to be allowed")
+	}
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+)
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"event":"registered","node_id":"550e8400-e29b-41d4-a716-446655440000"}`)
+	signedAt := time.Unix(1700000000, 0)
+	header := http.Header{}
+	setSignatureHeaders(header, webhookTestSecret, body, signedAt)
+
+	if got := header.Get(SignatureHeader); got != SignPayload(webhookTestSecret, signedAt.Unix(), body) {
+		t.Errorf("expected the signature of the timestamp and body, got %s", got)
+	}
+	if err := VerifySignature(webhookTestSecret, header, body, signedAt.Add(time.Minute)); err != nil {
+		t.Errorf("expected the signature to verify: %v", err)
+	}
+
+	tampered := []byte(`{"event":"deleted","node_id":"550e8400-e29b-41d4-a716-446655440000"}`)
+	if err := VerifySignature(webhookTestSecret, header, tampered, signedAt); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected a tampered body to fail verification, got %v", err)
+	}
+	if err := VerifySignature("other-secret", header, body, signedAt); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected another secret to fail verification, got %v", err)
+	}
+
+	// Moving the timestamp forward to replay the delivery breaks the signature
+	replayed := header.Clone()
+	replayed.Set(SignatureTimestampHeader, "1700003600")
+	if err := VerifySignature(webhookTestSecret, replayed, body, time.Unix(1700003600, 0)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected a rewritten timestamp to fail verification, got %v", err)
+	}
+	if err := VerifySignature(webhookTestSecret, header, body, signedAt.Add(SignatureMaxAge+time.Second)); !errors.Is(err, ErrStaleSignature) {
+		t.Errorf("expected an old delivery to be refused, got %v", err)
+	}
+
+	if err := VerifySignature(webhookTestSecret, http.Header{}, body, signedAt); !errors.Is(err, ErrMissingSignature) {
+		t.Errorf("expected an unsigned request to be refused, got %v", err)
+	}
+	unsigned := http.Header{}
+	setSignatureHeaders(unsigned, "", body, signedAt)
+	if len(unsigned) != 0 {
+		t.Errorf("expected no signature without a secret, got %v", unsigned)
+	}
+}
+
+func TestNotifyAlertWebhookSigned(t *testing.T) {
+	verified := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verified <- VerifySignature(webhookTestSecret, r.Header, body, time.Now())
+	}))
+	defer server.Close()
+
+	service := NewNotificationService(&types.Config{
+		Alerting: types.AlertingConfig{
+			Targets: map[string]types.AlertTargets{"critical": {WebhookURLs: []string{server.URL}}},
+		},
+		Webhooks: types.WebhookConfig{Secret: webhookTestSecret},
+	})
+	service.NotifyAlert(context.Background(), newTestAlert("critical"))
+
+	if err := <-verified; err != nil {
+		t.Errorf("expected the alert webhook to be signed: %v", err)
+	}
+}
+
+func TestLeadershipWebhookSigned(t *testing.T) {
+	verified := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verified <- VerifySignature(webhookTestSecret, r.Header, body, time.Now())
+	}))
+	defer server.Close()
+
+	notifier := NewLeadershipNotifier(NewHAService(nil, &types.Config{}), nil, server.URL)
+	notifier.SetSigningSecret(webhookTestSecret)
+	if err := notifier.sendWebhook(context.Background(), &LeadershipEvent{Event: "became_leader", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("failed to send leadership webhook: %v", err)
+	}
+
+	if err := <-verified; err != nil {
+		t.Errorf("expected the leadership webhook to be signed: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+)
+
+// How a spoke came to be attached to its hub.
+const (
+	TopologyModePinned = "pinned" // the spoke's pinned hub
+	TopologyModeBackup = "backup" // one of the spoke's backup hubs
+	TopologyModeAuto   = "auto"   // placed by the controller
+)
+
+// TopologyGraph is the hub/spoke graph in the nodes-and-edges shape graph
+// libraries take.
+type TopologyGraph struct {
+	Nodes []TopologyNode `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+}
+
+type TopologyNode struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Status      string    `json:"status"`
+	AllocatedIP string    `json:"allocated_ip"`
+}
+
+type TopologyEdge struct {
+	HubID   uuid.UUID   `json:"hub_id"`
+	SpokeID uuid.UUID   `json:"spoke_id"`
+	Mode    string      `json:"mode"`
+	Health  *LinkHealth `json:"health,omitempty"`
+}
+
+// LinkHealth is the state of a spoke's tunnel to its hub as of the spoke's
+// latest metrics report.
+type LinkHealth struct {
+	Online          bool      `json:"online"`
+	TunnelStatus    string    `json:"tunnel_status"`
+	HealthScore     float64   `json:"health_score"`
+	WGStatus        string    `json:"wg_status"`
+	WGLastHandshake time.Time `json:"wg_last_handshake"`
+	Latency         float64   `json:"latency_ms"`
+	PacketLoss      float64   `json:"packet_loss"`
+	LastSeen        time.Time `json:"last_seen"`
+}
+
+// GetTopologyGraph returns every node and the hub each spoke is attached to.
+// Nodes without links, such as hubs with no spokes, are still included.
+func (s *NodeService) GetTopologyGraph(ctx context.Context) (*TopologyGraph, error) {
+	var nodes []models.Node
+	if err := s.db.Select("id", "name", "node_type", "status", "allocated_ip", "pinned_hub_id", "backup_hub_ids").
+		Order("created_at").Find(&nodes).Error; err != nil {
+		return nil, fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	var links []models.Topology
+	if err := s.db.Order("created_at").Find(&links).Error; err != nil {
+		return nil, fmt.Errorf("failed to get topology: %w", err)
+	}
+
+	graph := &TopologyGraph{
+		Nodes: make([]TopologyNode, 0, len(nodes)),
+		Edges: make([]TopologyEdge, 0, len(links)),
+	}
+	byID := make(map[uuid.UUID]*models.Node, len(nodes))
+	for i := range nodes {
+		node := &nodes[i]
+		graph.Nodes = append(graph.Nodes, TopologyNode{
+			ID:          node.ID,
+			Name:        node.Name,
+			Type:        string(node.NodeType),
+			Status:      string(node.Status),
+			AllocatedIP: node.AllocatedIP,
+		})
+		byID[node.ID] = node
+	}
+
+	for _, link := range links {
+		// Links left behind by deleted nodes are not part of the graph
+		spoke, ok := byID[link.SpokeID]
+		if !ok {
+			continue
+		}
+		if _, ok := byID[link.HubID]; !ok {
+			continue
+		}
+		graph.Edges = append(graph.Edges, TopologyEdge{
+			HubID:   link.HubID,
+			SpokeID: link.SpokeID,
+			Mode:    topologyMode(spoke, link.HubID),
+		})
+	}
+
+	return graph, nil
+}
+
+func topologyMode(spoke *models.Node, hubID uuid.UUID) string {
+	if spoke.IsPinned() && *spoke.PinnedHubID == hubID {
+		return TopologyModePinned
+	}
+	for _, backupID := range spoke.BackupHubIDs {
+		if backupID == hubID.String() {
+			return TopologyModeBackup
+		}
+	}
+	return TopologyModeAuto
+}
+
+// AnnotateLinkHealth sets each edge's health from its spoke's latest metrics.
+// Edges whose spoke hasn't reported since the controller started are left
+// without health.
+func (s *MonitoringService) AnnotateLinkHealth(graph *TopologyGraph) {
+	for i := range graph.Edges {
+		edge := &graph.Edges[i]
+		loaded, ok := s.nodeMetrics.Load(edge.SpokeID)
+		if !ok {
+			continue
+		}
+		metrics := loaded.(*NodeMetrics)
+		healthScore, _ := calculateHealthScore(metrics)
+		edge.Health = &LinkHealth{
+			Online:          time.Since(metrics.LastSeen) < 5*time.Minute,
+			TunnelStatus:    tunnelStatus(metrics, time.Now()),
+			HealthScore:     healthScore,
+			WGStatus:        metrics.WGStatus,
+			WGLastHandshake: metrics.WGLastHandshake,
+			Latency:         metrics.Latency,
+			PacketLoss:      metrics.PacketLoss,
+			LastSeen:        metrics.LastSeen,
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+)
+
+func TestGetTopologyGraph(t *testing.T) {
+	db := openTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE nodes (
+			id TEXT PRIMARY KEY, name TEXT NOT NULL, node_type TEXT NOT NULL, allocated_ip TEXT NOT NULL,
+			status TEXT, pinned_hub_id TEXT, backup_hub_ids TEXT,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE topology (
+			id TEXT PRIMARY KEY, hub_id TEXT NOT NULL, spoke_id TEXT NOT NULL,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create test schema: %v", err)
+		}
+	}
+
+	created := time.Now().Add(-time.Hour)
+	insertNode := func(name string, nodeType models.NodeType, ip string, pinnedHubID interface{}) uuid.UUID {
+		id := uuid.New()
+		created = created.Add(time.Minute)
+		err := db.Exec(`INSERT INTO nodes (id, name, node_type, allocated_ip, status, pinned_hub_id, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, id, name, nodeType, ip, models.NodeStatusActive, pinnedHubID, created).Error
+		if err != nil {
+			t.Fatalf("failed to insert node %s: %v", name, err)
+		}
+		return id
+	}
+	link := func(hubID, spokeID uuid.UUID) {
+		created = created.Add(time.Minute)
+		if err := db.Exec("INSERT INTO topology (id, hub_id, spoke_id, created_at) VALUES (?, ?, ?, ?)", uuid.New(), hubID, spokeID, created).Error; err != nil {
+			t.Fatalf("failed to link spoke to hub: %v", err)
+		}
+	}
+
+	hubID := insertNode("hub-1", models.NodeTypeHub, "10.100.0.1/16", nil)
+	idleHubID := insertNode("hub-2", models.NodeTypeHub, "10.100.0.2/16", nil)
+	autoID := insertNode("spoke-1", models.NodeTypeSpoke, "10.100.1.2/16", nil)
+	pinnedID := insertNode("spoke-2", models.NodeTypeSpoke, "10.100.1.3/16", hubID)
+	unlinkedID := insertNode("spoke-3", models.NodeTypeSpoke, "10.100.1.4/16", nil)
+	link(hubID, autoID)
+	link(hubID, pinnedID)
+	link(hubID, uuid.New()) // spoke since deleted
+
+	service := NewNodeService(db, &types.Config{}, NewAuditService(db))
+	graph, err := service.GetTopologyGraph(context.Background())
+	if err != nil {
+		t.Fatalf("GetTopologyGraph failed: %v", err)
+	}
+
+	expectedNodes := []TopologyNode{
+		{ID: hubID, Name: "hub-1", Type: "hub", Status: "active", AllocatedIP: "10.100.0.1/16"},
+		{ID: idleHubID, Name: "hub-2", Type: "hub", Status: "active", AllocatedIP: "10.100.0.2/16"},
+		{ID: autoID, Name: "spoke-1", Type: "spoke", Status: "active", AllocatedIP: "10.100.1.2/16"},
+		{ID: pinnedID, Name: "spoke-2", Type: "spoke", Status: "active", AllocatedIP: "10.100.1.3/16"},
+		{ID: unlinkedID, Name: "spoke-3", Type: "spoke", Status: "active", AllocatedIP: "10.100.1.4/16"},
+	}
+	if len(graph.Nodes) != len(expectedNodes) {
+		t.Fatalf("expected %d nodes, got %+v", len(expectedNodes), graph.Nodes)
+	}
+	for i, expected := range expectedNodes {
+		if graph.Nodes[i] != expected {
+			t.Errorf("node %d: expected %+v, got %+v", i, expected, graph.Nodes[i])
+		}
+	}
+
+	expectedEdges := []TopologyEdge{
+		{HubID: hubID, SpokeID: autoID, Mode: TopologyModeAuto},
+		{HubID: hubID, SpokeID: pinnedID, Mode: TopologyModePinned},
+	}
+	if len(graph.Edges) != len(expectedEdges) {
+		t.Fatalf("expected %d edges, got %+v", len(expectedEdges), graph.Edges)
+	}
+	for i, expected := range expectedEdges {
+		if graph.Edges[i] != expected {
+			t.Errorf("edge %d: expected %+v, got %+v", i, expected, graph.Edges[i])
+		}
+	}
+
+	// Only spokes that have reported metrics get link health
+	monitoring := NewMonitoringService(db, nil)
+	monitoring.nodeMetrics.Store(pinnedID, &NodeMetrics{NodeID: pinnedID, WGStatus: "up", Latency: 20, LastSeen: time.Now()})
+	monitoring.AnnotateLinkHealth(graph)
+	if graph.Edges[0].Health != nil {
+		t.Errorf("expected no health for a spoke without metrics, got %+v", graph.Edges[0].Health)
+	}
+	health := graph.Edges[1].Health
+	if health == nil || !health.Online || health.WGStatus != "up" || health.Latency != 20 || health.HealthScore != 100 {
+		t.Errorf("expected the pinned spoke's latest health, got %+v", health)
+	}
+}
+
+// topologyTestEnv holds hubs and spokes inserted oldest first, as the
+// topology code orders them.
+type topologyTestEnv struct {
+	t       *testing.T
+	service *NodeService
+	created time.Time
+}
+
+func newTopologyTestEnv(t *testing.T) *topologyTestEnv {
+	t.Helper()
+
+	db := openTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE nodes (
+			id TEXT PRIMARY KEY, name TEXT NOT NULL, node_type TEXT NOT NULL, allocated_ip TEXT NOT NULL,
+			status TEXT, pinned_hub_id TEXT, backup_hub_ids TEXT,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE topology (
+			id TEXT PRIMARY KEY, hub_id TEXT NOT NULL, spoke_id TEXT NOT NULL,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create test schema: %v", err)
+		}
+	}
+
+	return &topologyTestEnv{
+		t:       t,
+		service: NewNodeService(db, &types.Config{}, NewAuditService(db)),
+		created: time.Now().Add(-time.Hour),
+	}
+}
+
+func (e *topologyTestEnv) insertNode(name string, nodeType models.NodeType, pinnedHubID interface{}) uuid.UUID {
+	e.t.Helper()
+
+	id := uuid.New()
+	e.created = e.created.Add(time.Minute)
+	err := e.service.db.Exec(`INSERT INTO nodes (id, name, node_type, allocated_ip, status, pinned_hub_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, id, name, nodeType, "10.100.0.1/16", models.NodeStatusActive, pinnedHubID, e.created).Error
+	if err != nil {
+		e.t.Fatalf("failed to insert node %s: %v", name, err)
+	}
+	return id
+}
+
+func (e *topologyTestEnv) link(hubID, spokeID uuid.UUID) {
+	e.t.Helper()
+
+	if err := e.service.db.Exec("INSERT INTO topology (id, hub_id, spoke_id) VALUES (?, ?, ?)", uuid.New(), hubID, spokeID).Error; err != nil {
+		e.t.Fatalf("failed to link spoke to hub: %v", err)
+	}
+}
+
+// hubOf returns the hub each spoke is linked to.
+func (e *topologyTestEnv) hubOf() map[uuid.UUID]uuid.UUID {
+	e.t.Helper()
+
+	var links []models.Topology
+	if err := e.service.db.Find(&links).Error; err != nil {
+		e.t.Fatalf("failed to get topology: %v", err)
+	}
+	hubs := make(map[uuid.UUID]uuid.UUID, len(links))
+	for _, link := range links {
+		if _, ok := hubs[link.SpokeID]; ok {
+			e.t.Fatalf("expected one hub per spoke, %s has several", link.SpokeID)
+		}
+		hubs[link.SpokeID] = link.HubID
+	}
+	return hubs
+}
+
+func TestSelectHubHonorsPin(t *testing.T) {
+	service := &NodeService{}
+	hubs := []models.Node{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	down := uuid.New() // pinned hub that isn't among the active ones
+
+	for _, tc := range []struct {
+		name    string
+		pinned  *uuid.UUID
+		backups []string
+		want    *uuid.UUID
+	}{
+		{"unpinned takes the first hub", nil, nil, &hubs[0].ID},
+		{"pinned", &hubs[1].ID, []string{hubs[2].ID.String()}, &hubs[1].ID},
+		{"pinned hub down falls back to a backup", &down, []string{uuid.NewString(), hubs[2].ID.String()}, &hubs[2].ID},
+		{"no allowed hub up", &down, []string{uuid.NewString()}, nil},
+	} {
+		spoke := &models.Node{PinnedHubID: tc.pinned, BackupHubIDs: tc.backups}
+		hub := service.selectHub(spoke, hubs)
+		switch {
+		case tc.want == nil && hub != nil:
+			t.Errorf("%s: expected no hub, got %s", tc.name, hub.ID)
+		case tc.want != nil && (hub == nil || hub.ID != *tc.want):
+			t.Errorf("%s: expected hub %s, got %v", tc.name, *tc.want, hub)
+		}
+	}
+}
+
+func TestUpdateTopologyRespectsPin(t *testing.T) {
+	env := newTopologyTestEnv(t)
+	first := env.insertNode("hub-1", models.NodeTypeHub, nil)
+	pinnedHub := env.insertNode("hub-2", models.NodeTypeHub, nil)
+	pinnedID := env.insertNode("spoke-1", models.NodeTypeSpoke, pinnedHub)
+	unpinnedID := env.insertNode("spoke-2", models.NodeTypeSpoke, nil)
+
+	for _, id := range []uuid.UUID{pinnedID, unpinnedID} {
+		var spoke models.Node
+		if err := env.service.db.Where("id = ?", id).First(&spoke).Error; err != nil {
+			t.Fatalf("failed to load spoke: %v", err)
+		}
+		if err := env.service.updateTopology(context.Background(), &spoke); err != nil {
+			t.Fatalf("updateTopology failed: %v", err)
+		}
+	}
+
+	hubs := env.hubOf()
+	if hubs[pinnedID] != pinnedHub {
+		t.Errorf("expected the pinned spoke on its pinned hub %s, got %s", pinnedHub, hubs[pinnedID])
+	}
+	if hubs[unpinnedID] != first {
+		t.Errorf("expected the unpinned spoke on the first hub %s, got %s", first, hubs[unpinnedID])
+	}
+}
+
+func TestRebalanceTopologySkipsPinnedSpokes(t *testing.T) {
+	env := newTopologyTestEnv(t)
+	hub1 := env.insertNode("hub-1", models.NodeTypeHub, nil)
+	hub2 := env.insertNode("hub-2", models.NodeTypeHub, nil)
+
+	// Three spokes pinned to hub-1, one of them currently on hub-2, and two
+	// unpinned spokes crowding hub-1
+	var pinned, unpinned []uuid.UUID
+	for _, name := range []string{"pinned-1", "pinned-2", "pinned-3"} {
+		pinned = append(pinned, env.insertNode(name, models.NodeTypeSpoke, hub1))
+	}
+	for _, name := range []string{"spoke-1", "spoke-2"} {
+		unpinned = append(unpinned, env.insertNode(name, models.NodeTypeSpoke, nil))
+	}
+	env.link(hub1, pinned[0])
+	env.link(hub1, pinned[1])
+	env.link(hub2, pinned[2])
+	env.link(hub1, unpinned[0])
+	env.link(hub1, unpinned[1])
+
+	moved, err := env.service.RebalanceTopology(context.Background())
+	if err != nil {
+		t.Fatalf("RebalanceTopology failed: %v", err)
+	}
+
+	hubs := env.hubOf()
+	for _, id := range pinned {
+		if hubs[id] != hub1 {
+			t.Errorf("expected pinned spoke %s to stay on hub-1, got %s", id, hubs[id])
+		}
+	}
+	for _, id := range unpinned {
+		if hubs[id] != hub2 {
+			t.Errorf("expected unpinned spoke %s to be moved to hub-2, got %s", id, hubs[id])
+		}
+	}
+	if moved != 3 {
+		t.Errorf("expected 3 spokes to move, got %d", moved)
+	}
+
+	// Balanced already, so a second pass moves nothing
+	if moved, err := env.service.RebalanceTopology(context.Background()); err != nil || moved != 0 {
+		t.Errorf("expected nothing to move on a second pass, got %d (%v)", moved, err)
+	}
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+)
+
+// agentVersion is a parsed major.minor.patch version. Pre-release and build
+// suffixes are ignored.
+type agentVersion [3]int
+
+// parseAgentVersion accepts versions such as v1.4.2, 1.4 or 2.0.0-rc1.
+// Anything else, including dev builds, reports false.
+func parseAgentVersion(v string) (agentVersion, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+
+	var parsed agentVersion
+	parts := strings.Split(v, ".")
+	if len(parts) > len(parsed) {
+		return parsed, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
+
+func (v agentVersion) less(other agentVersion) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] < other[i]
+		}
+	}
+	return false
+}
+
+// VersionSkew is a node whose agent is older than the controller supports.
+type VersionSkew struct {
+	NodeID       uuid.UUID `json:"node_id"`
+	NodeName     string    `json:"node_name"`
+	AgentVersion string    `json:"agent_version"`
+	Reason       string    `json:"reason"`
+}
+
+// SetVersionPolicy sets the controller's own version and the oldest agent
+// version it supports; either may be empty. Agents behind either one are
+// reported by CheckVersionSkew.
+func (s *MonitoringService) SetVersionPolicy(controllerVersion, minAgentVersion string) error {
+	if minAgentVersion != "" {
+		if _, ok := parseAgentVersion(minAgentVersion); !ok {
+			return fmt.Errorf("invalid minimum agent version %q", minAgentVersion)
+		}
+	}
+	s.controllerVersion = controllerVersion
+	s.minAgentVersion = minAgentVersion
+	return nil
+}
+
+// CheckVersionSkew returns the nodes whose agent is older than the minimum
+// agent version or a major version behind the controller. Nodes that never
+// reported a version, or report one that isn't numbered, are skipped.
+func (s *MonitoringService) CheckVersionSkew(ctx context.Context) ([]VersionSkew, error) {
+	minimum, hasMinimum := parseAgentVersion(s.minAgentVersion)
+	controller, hasController := parseAgentVersion(s.controllerVersion)
+	skews := []VersionSkew{}
+	if !hasMinimum && !hasController {
+		return skews, nil
+	}
+
+	var nodes []models.Node
+	if err := s.db.WithContext(ctx).Select("id", "name", "agent_version").
+		Where("agent_version <> ?", "").Order("name").Find(&nodes).Error; err != nil {
+		return nil, fmt.Errorf("failed to load agent versions: %w", err)
+	}
+
+	for _, node := range nodes {
+		version, ok := parseAgentVersion(node.AgentVersion)
+		if !ok {
+			continue
+		}
+
+		var reason string
+		switch {
+		case hasMinimum && version.less(minimum):
+			reason = fmt.Sprintf("agent %s is older than the minimum supported version %s", node.AgentVersion, s.minAgentVersion)
+		case hasController && version[0] < controller[0]:
+			reason = fmt.Sprintf("agent %s is a major version behind controller %s", node.AgentVersion, s.controllerVersion)
+		default:
+			continue
+		}
+
+		skews = append(skews, VersionSkew{
+			NodeID:       node.ID,
+			NodeName:     node.Name,
+			AgentVersion: node.AgentVersion,
+			Reason:       reason,
+		})
+	}
+	return skews, nil
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func insertVersionedNode(t *testing.T, db *gorm.DB, name, agentVersion string) uuid.UUID {
+	t.Helper()
+
+	id := insertRotationTestNode(t, db, name, "spoke", rotationSpokeKey, "10.100.1.2/16", time.Now())
+	if err := db.Exec("UPDATE nodes SET agent_version = ? WHERE id = ?", agentVersion, id).Error; err != nil {
+		t.Fatalf("failed to set agent version: %v", err)
+	}
+	return id
+}
+
+func TestParseAgentVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version  string
+		expected agentVersion
+		ok       bool
+	}{
+		{"v1.4.2", agentVersion{1, 4, 2}, true},
+		{"1.4", agentVersion{1, 4, 0}, true},
+		{"2.0.0-rc1", agentVersion{2, 0, 0}, true},
+		{"v3+build.7", agentVersion{3, 0, 0}, true},
+		{"dev", agentVersion{}, false},
+		{"", agentVersion{}, false},
+		{"1.2.3.4", agentVersion{}, false},
+		{"1.x", agentVersion{}, false},
+	} {
+		got, ok := parseAgentVersion(tc.version)
+		if ok != tc.ok || (ok && got != tc.expected) {
+			t.Errorf("parseAgentVersion(%q): expected %v %v, got %v %v", tc.version, tc.expected, tc.ok, got, ok)
+		}
+	}
+}
+
+func TestCheckVersionSkew(t *testing.T) {
+	_, db := newKeyRotationTestService(t, 0)
+	oldID := insertVersionedNode(t, db, "spoke-old", "v1.2.0")
+	insertVersionedNode(t, db, "spoke-current", "v2.1.0")
+	majorID := insertVersionedNode(t, db, "spoke-major", "v1.9.0")
+	insertVersionedNode(t, db, "spoke-dev", "dev")
+	insertVersionedNode(t, db, "spoke-unreported", "")
+	ctx := context.Background()
+
+	monitoring := NewMonitoringService(db, nil)
+
+	// Nothing to compare against until a policy is set
+	skews, err := monitoring.CheckVersionSkew(ctx)
+	if err != nil {
+		t.Fatalf("CheckVersionSkew failed: %v", err)
+	}
+	if len(skews) != 0 {
+		t.Errorf("expected no warnings without a version policy, got %+v", skews)
+	}
+
+	if err := monitoring.SetVersionPolicy("v2.1.0", "v1.5.0"); err != nil {
+		t.Fatalf("SetVersionPolicy failed: %v", err)
+	}
+	skews, err = monitoring.CheckVersionSkew(ctx)
+	if err != nil {
+		t.Fatalf("CheckVersionSkew failed: %v", err)
+	}
+
+	// spoke-major meets the minimum but is still a major version behind
+	if len(skews) != 2 || skews[0].NodeID != majorID || skews[1].NodeID != oldID {
+		t.Fatalf("expected warnings for spoke-major and spoke-old, got %+v", skews)
+	}
+	if skews[0].AgentVersion != "v1.9.0" || skews[0].Reason == "" {
+		t.Errorf("expected the version and reason to be reported, got %+v", skews[0])
+	}
+
+	// A dev controller only enforces the minimum
+	if err := monitoring.SetVersionPolicy("dev", "v1.5.0"); err != nil {
+		t.Fatalf("SetVersionPolicy failed: %v", err)
+	}
+	report, err := monitoring.GenerateReport(ctx, time.Now().Add(-time.Hour), time.Now())
+	if err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	if warnings, ok := report["version_warnings"].([]VersionSkew); !ok || len(warnings) != 1 || warnings[0].NodeName != "spoke-old" {
+		t.Errorf("expected the report to warn about spoke-old only, got %+v", report["version_warnings"])
+	}
+
+	if err := monitoring.SetVersionPolicy("v2.1.0", "latest"); err == nil {
+		t.Error("expected an unparseable minimum version to be rejected")
+	}
+}
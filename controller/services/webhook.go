@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+)
+
+const (
+	NodeEventRegistered = "registered"
+	NodeEventApproved   = "approved"
+	NodeEventActive     = "active"
+	NodeEventOffline    = "offline"
+	NodeEventDeleted    = "deleted"
+)
+
+// NodeLifecycleEvent is the JSON payload POSTed to node webhooks.
+type NodeLifecycleEvent struct {
+	ID             uuid.UUID `json:"id"`
+	Event          string    `json:"event"`
+	NodeID         uuid.UUID `json:"node_id"`
+	NodeName       string    `json:"node_name"`
+	NodeType       string    `json:"node_type"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// WebhookService notifies external endpoints of node lifecycle events.
+type WebhookService struct {
+	config     *types.WebhookConfig
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+func NewWebhookService(config *types.Config) *WebhookService {
+	return &WebhookService{
+		config: &config.Webhooks,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		now: time.Now,
+	}
+}
+
+// NotifyNodeEvent sends event for node to the endpoints configured for it
+// in the background, so a slow receiver never holds up the node operation
+// that caused it. previous is the node's status before the change, if any.
+func (s *WebhookService) NotifyNodeEvent(event string, node *models.Node, previous models.NodeStatus) {
+	urls := s.config.Endpoints[event]
+	if len(urls) == 0 {
+		return
+	}
+
+	payload := &NodeLifecycleEvent{
+		ID:             uuid.New(),
+		Event:          event,
+		NodeID:         node.ID,
+		NodeName:       node.Name,
+		NodeType:       string(node.NodeType),
+		Status:         string(node.Status),
+		PreviousStatus: string(previous),
+		Timestamp:      s.now(),
+	}
+
+	go s.deliver(context.Background(), urls, payload)
+}
+
+func (s *WebhookService) deliver(ctx context.Context, urls []string, payload *NodeLifecycleEvent) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to marshal node webhook", "event", payload.Event, "error", err)
+		return
+	}
+
+	for _, url := range urls {
+		err := retryDelivery(ctx, s.config.MaxRetries, s.config.RetryBackoff, func() (bool, error) {
+			return postJSON(ctx, s.httpClient, url, data, s.config.Secret)
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to deliver node webhook", "event", payload.Event, "node_id", payload.NodeID, "url", url, "error", err)
+		}
+	}
+}
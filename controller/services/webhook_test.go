@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wg-hubspoke/wg-hubspoke/common/types"
+	"github.com/wg-hubspoke/wg-hubspoke/controller/models"
+)
+
+const webhookTestSecret = "webhook-test-secret"
+
+// newWebhookReceiver returns the URL of a receiver that checks each
+// delivery's signature and passes the event on.
+func newWebhookReceiver(t *testing.T) (string, <-chan NodeLifecycleEvent) {
+	t.Helper()
+
+	events := make(chan NodeLifecycleEvent, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read webhook body: %v", err)
+			return
+		}
+		if err := VerifySignature(webhookTestSecret, r.Header, body, time.Now()); err != nil {
+			t.Errorf("expected a valid signature: %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var event NodeLifecycleEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("failed to decode webhook payload: %v", err)
+			return
+		}
+		events <- event
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, events
+}
+
+func newTestWebhookService(url string, events ...string) *WebhookService {
+	endpoints := make(map[string][]string)
+	for _, event := range events {
+		endpoints[event] = []string{url}
+	}
+	return NewWebhookService(&types.Config{
+		Webhooks: types.WebhookConfig{
+			Endpoints:    endpoints,
+			Secret:       webhookTestSecret,
+			RetryBackoff: time.Millisecond,
+		},
+	})
+}
+
+// receiveWebhooks waits for n deliveries and returns them by event type.
+func receiveWebhooks(t *testing.T, events <-chan NodeLifecycleEvent, n int) map[string]NodeLifecycleEvent {
+	t.Helper()
+
+	received := make(map[string]NodeLifecycleEvent)
+	for i := 0; i < n; i++ {
+		select {
+		case event := <-events:
+			received[event.Event] = event
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %d of %d webhooks: %v", i, n, received)
+		}
+	}
+	return received
+}
+
+func TestNodeLifecycleWebhooks(t *testing.T) {
+	url, events := newWebhookReceiver(t)
+	service, db := newKeyRotationTestService(t, 0)
+	addRegistrationColumns(t, db)
+	// Active is left unsubscribed
+	service.SetWebhookService(newTestWebhookService(url, NodeEventRegistered, NodeEventApproved, NodeEventDeleted))
+	ctx := context.Background()
+
+	node, err := service.RegisterNode(ctx, types.NodeRegistrationRequest{
+		Name:      "hub-1",
+		NodeType:  "hub",
+		PublicKey: rotationHubKey,
+	})
+	if err != nil {
+		t.Fatalf("RegisterNode failed: %v", err)
+	}
+	registered := receiveWebhooks(t, events, 1)[NodeEventRegistered]
+	if registered.NodeID != node.ID || registered.NodeName != "hub-1" || registered.NodeType != "hub" || registered.Status != string(models.NodeStatusPending) {
+		t.Errorf("expected hub-1 to be registered as pending, got %+v", registered)
+	}
+
+	active := string(models.NodeStatusActive)
+	if _, err := service.UpdateNode(ctx, node.ID, types.NodeUpdateRequest{Status: &active}); err != nil {
+		t.Fatalf("UpdateNode failed: %v", err)
+	}
+	approved := receiveWebhooks(t, events, 1)[NodeEventApproved]
+	if approved.NodeID != node.ID || approved.Status != active || approved.PreviousStatus != string(models.NodeStatusPending) {
+		t.Errorf("expected hub-1 to be approved out of pending, got %+v", approved)
+	}
+
+	if err := service.DeleteNode(ctx, node.ID); err != nil {
+		t.Fatalf("DeleteNode failed: %v", err)
+	}
+	received := receiveWebhooks(t, events, 1)
+	if deleted, ok := received[NodeEventDeleted]; !ok || deleted.NodeID != node.ID {
+		t.Errorf("expected hub-1's deletion and no active event, got %v", received)
+	}
+}
+
+func TestOfflineDetectionWebhooks(t *testing.T) {
+	url, events := newWebhookReceiver(t)
+	_, db := newKeyRotationTestService(t, 0)
+	_, spokeID := newRotationTopology(t, db)
+	monitoring := NewMonitoringService(db, nil)
+	monitoring.SetWebhookService(newTestWebhookService(url, NodeEventOffline, NodeEventActive))
+	ctx := context.Background()
+
+	now := time.Now()
+	monitoring.nodeMetrics.Store(spokeID, &NodeMetrics{NodeID: spokeID, LastSeen: now.Add(-time.Minute)})
+	if offline := monitoring.CheckOfflineNodes(ctx, now); len(offline) != 0 {
+		t.Fatalf("expected a node seen a minute ago to be online, got %v", offline)
+	}
+
+	later := now.Add(nodeOfflineAfter)
+	if offline := monitoring.CheckOfflineNodes(ctx, later); len(offline) != 1 || offline[0] != spokeID {
+		t.Fatalf("expected spoke-1 to go offline, got %v", offline)
+	}
+	if offline := receiveWebhooks(t, events, 1)[NodeEventOffline]; offline.NodeID != spokeID || offline.NodeName != "spoke-1" {
+		t.Errorf("expected spoke-1's offline event, got %+v", offline)
+	}
+	// An outage is only reported once
+	if offline := monitoring.CheckOfflineNodes(ctx, later.Add(time.Minute)); len(offline) != 0 {
+		t.Errorf("expected spoke-1 not to be reported twice, got %v", offline)
+	}
+
+	if err := monitoring.UpdateNodeMetrics(ctx, spokeID, map[string]interface{}{"cpu_usage": 12.5}); err != nil {
+		t.Fatalf("UpdateNodeMetrics failed: %v", err)
+	}
+	if back := receiveWebhooks(t, events, 1)[NodeEventActive]; back.NodeID != spokeID {
+		t.Errorf("expected spoke-1 to be reported active again, got %+v", back)
+	}
+}
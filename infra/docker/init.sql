@@ -7,6 +7,7 @@ CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 CREATE TABLE IF NOT EXISTS nodes (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     name VARCHAR(255) NOT NULL UNIQUE,
+    description TEXT,
     node_type VARCHAR(50) NOT NULL CHECK (node_type IN ('hub', 'spoke')),
     public_key TEXT NOT NULL,
     private_key_hash TEXT,
@@ -18,6 +19,9 @@ CREATE TABLE IF NOT EXISTS nodes (
     status VARCHAR(50) DEFAULT 'pending' CHECK (status IN ('pending', 'active', 'inactive', 'disabled')),
     persistent_keepalive INTEGER,
     mtu INTEGER DEFAULT 1420,
+    pinned_hub_id UUID REFERENCES nodes(id) ON DELETE SET NULL,
+    backup_hub_ids TEXT[],
+    tags TEXT,
     created_at TIMESTAMP DEFAULT NOW(),
     updated_at TIMESTAMP DEFAULT NOW(),
     deleted_at TIMESTAMP
@@ -40,14 +44,34 @@ CREATE TABLE IF NOT EXISTS users (
     username VARCHAR(255) NOT NULL UNIQUE,
     email VARCHAR(255) NOT NULL UNIQUE,
     password VARCHAR(255) NOT NULL,
-    role VARCHAR(50) DEFAULT 'user' CHECK (role IN ('admin', 'user')),
+    role VARCHAR(50) DEFAULT 'user' CHECK (role IN ('admin', 'operator', 'user', 'observer')),
     is_active BOOLEAN DEFAULT TRUE,
+    must_change_password BOOLEAN DEFAULT FALSE,
     last_login TIMESTAMP,
     created_at TIMESTAMP DEFAULT NOW(),
     updated_at TIMESTAMP DEFAULT NOW(),
     deleted_at TIMESTAMP
 );
 
+-- Create node groups table
+CREATE TABLE IF NOT EXISTS node_groups (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) UNIQUE NOT NULL,
+    description TEXT,
+    mesh BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    deleted_at TIMESTAMP
+);
+
+-- Create node group membership table
+CREATE TABLE IF NOT EXISTS node_group_members (
+    group_id UUID NOT NULL REFERENCES node_groups(id) ON DELETE CASCADE,
+    node_id UUID NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (group_id, node_id)
+);
+
 -- Create policies table
 CREATE TABLE IF NOT EXISTS policies (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -55,6 +79,10 @@ CREATE TABLE IF NOT EXISTS policies (
     description TEXT,
     source_node_id UUID REFERENCES nodes(id) ON DELETE CASCADE,
     destination_node_id UUID REFERENCES nodes(id) ON DELETE CASCADE,
+    source_group VARCHAR(50),
+    destination_group VARCHAR(50),
+    source_group_id UUID,
+    destination_group_id UUID,
     source_cidr VARCHAR(255),
     destination_cidr VARCHAR(255),
     protocol VARCHAR(50),
@@ -78,6 +106,7 @@ CREATE TABLE IF NOT EXISTS audit_logs (
     ip_address VARCHAR(255),
     user_agent TEXT,
     metadata JSONB,
+    job_id UUID,
     created_at TIMESTAMP DEFAULT NOW()
 );
 
@@ -99,14 +128,20 @@ CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at);
 
 CREATE INDEX IF NOT EXISTS idx_policies_source_node_id ON policies(source_node_id);
 CREATE INDEX IF NOT EXISTS idx_policies_destination_node_id ON policies(destination_node_id);
+CREATE INDEX IF NOT EXISTS idx_policies_source_group_id ON policies(source_group_id);
+CREATE INDEX IF NOT EXISTS idx_policies_destination_group_id ON policies(destination_group_id);
 CREATE INDEX IF NOT EXISTS idx_policies_action ON policies(action);
 CREATE INDEX IF NOT EXISTS idx_policies_priority ON policies(priority);
 CREATE INDEX IF NOT EXISTS idx_policies_enabled ON policies(enabled);
 CREATE INDEX IF NOT EXISTS idx_policies_deleted_at ON policies(deleted_at);
 
+CREATE INDEX IF NOT EXISTS idx_node_groups_deleted_at ON node_groups(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_node_group_members_node_id ON node_group_members(node_id);
+
 CREATE INDEX IF NOT EXISTS idx_audit_logs_user_id ON audit_logs(user_id);
 CREATE INDEX IF NOT EXISTS idx_audit_logs_action ON audit_logs(action);
 CREATE INDEX IF NOT EXISTS idx_audit_logs_resource ON audit_logs(resource);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_job_id ON audit_logs(job_id);
 CREATE INDEX IF NOT EXISTS idx_audit_logs_created_at ON audit_logs(created_at);
 
 -- Create a function to update the updated_at timestamp
@@ -122,9 +157,10 @@ $$ language 'plpgsql';
 CREATE TRIGGER update_nodes_updated_at BEFORE UPDATE ON nodes FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 CREATE TRIGGER update_topology_updated_at BEFORE UPDATE ON topology FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 CREATE TRIGGER update_users_updated_at BEFORE UPDATE ON users FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_node_groups_updated_at BEFORE UPDATE ON node_groups FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 CREATE TRIGGER update_policies_updated_at BEFORE UPDATE ON policies FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 
--- Insert default admin user (password: admin123)
-INSERT INTO users (username, email, password, role) VALUES 
-('admin', 'admin@example.com', '$2a$10$YourHashedPasswordHere', 'admin')
+-- Insert default admin user (password: admin123), which must change its password at first login
+INSERT INTO users (username, email, password, role, must_change_password) VALUES 
+('admin', 'admin@example.com', '$2a$10$YourHashedPasswordHere', 'admin', TRUE)
 ON CONFLICT (username) DO NOTHING;
\ No newline at end of file
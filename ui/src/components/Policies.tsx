@@ -237,7 +237,7 @@ const PolicyModal: React.FC<PolicyModalProps> = ({ policy, onSave, onClose }) =>
     description: policy?.description || '',
     source_cidr: policy?.source_cidr || '',
     destination_cidr: policy?.destination_cidr || '',
-    protocol: policy?.protocol || 'tcp',
+    protocol: policy?.protocol || 'any',
     port: policy?.port || '',
     action: policy?.action || 'allow',
     priority: policy?.priority || 100,